@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"time"
@@ -10,10 +11,14 @@ import (
 	"github.com/matthieukhl/latentia/internal/config"
 	"github.com/matthieukhl/latentia/internal/database"
 	"github.com/matthieukhl/latentia/internal/llm"
+	"github.com/matthieukhl/latentia/internal/notify"
 	"github.com/matthieukhl/latentia/internal/rag"
 )
 
 func main() {
+	concurrency := flag.Int("concurrency", 2, "Number of test queries optimized at once")
+	flag.Parse()
+
 	// Load configuration
 	cfg, err := config.LoadConfig()
 	if err != nil {
@@ -26,11 +31,8 @@ func main() {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer db.Close()
-
-	// Setup schema
-	if err := db.SetupTestSchema(); err != nil {
-		log.Fatalf("Failed to setup schema: %v", err)
-	}
+	// Deliver the rewrite_created events of the stored rewrites before exiting
+	defer notify.Flush(5 * time.Second)
 
 	// Initialize LLM providers
 	embedder, err := llm.NewEmbedder(&cfg.LLM)
@@ -38,6 +40,14 @@ func main() {
 		log.Fatalf("Failed to create embedder: %v", err)
 	}
 
+	// Setup schema
+	if _, err := db.Migrate(context.Background(), embedder.Dim(), 0); err != nil {
+		log.Fatalf("Failed to migrate schema: %v", err)
+	}
+	if err := db.SetupTestSchema(context.Background()); err != nil {
+		log.Fatalf("Failed to setup schema: %v", err)
+	}
+
 	generator, err := llm.NewGenerator(&cfg.LLM)
 	if err != nil {
 		log.Fatalf("Failed to create generator: %v", err)
@@ -45,8 +55,11 @@ func main() {
 
 	// Initialize document store and seed it
 	docStore := rag.NewDocumentStore(db, embedder)
+	if err := docStore.CheckDimension(context.Background()); err != nil {
+		log.Fatalf("Failed to check embeddings table: %v", err)
+	}
 	fmt.Println("Seeding TiDB optimization documentation...")
-	if err := docStore.SeedTiDBOptimizationDocs(); err != nil {
+	if err := docStore.SeedTiDBOptimizationDocs(context.Background()); err != nil {
 		log.Fatalf("Failed to seed documentation: %v", err)
 	}
 
@@ -82,12 +95,11 @@ func main() {
 
 	ctx := context.Background()
 
+	var items []analyze.SlowQueryRef
+	var names []string
 	for i, test := range testQueries {
-		fmt.Printf("\n=== Testing Query %d: %s ===\n", i+1, test.name)
-		fmt.Printf("SQL: %s\n", test.sql)
-
 		// First, insert a slow query record
-		slowQueryResult, err := db.Exec(`
+		slowQueryResult, err := db.ExecContext(ctx, `
 			INSERT INTO app_slow_queries (digest, sample_sql, started_at, query_time, db, source, status)
 			VALUES (?, ?, ?, ?, ?, ?, ?)
 		`, fmt.Sprintf("test_%d", i), test.sql, time.Now(), 2.5, "latentia", "generated", "pending")
@@ -102,14 +114,20 @@ func main() {
 			log.Printf("Failed to get slow query ID: %v", err)
 			continue
 		}
+		items = append(items, analyze.SlowQueryRef{ID: slowQueryID, SQL: test.sql})
+		names = append(names, test.name)
+	}
 
-		// Run optimization
-		fmt.Println("\nAnalyzing patterns...")
-		result, err := engine.OptimizeQuery(ctx, slowQueryID, test.sql)
-		if err != nil {
-			log.Printf("Failed to optimize query: %v", err)
+	// Run optimizations; llm.queue keeps them within the API rate limits
+	fmt.Printf("\nAnalyzing %d queries, %d at a time...\n", len(items), *concurrency)
+	for i, batch := range engine.OptimizeBatch(ctx, items, *concurrency) {
+		fmt.Printf("\n=== Testing Query %d: %s ===\n", i+1, names[i])
+		fmt.Printf("SQL: %s\n", batch.Ref.SQL)
+		if batch.Err != nil {
+			log.Printf("Failed to optimize query: %v", batch.Err)
 			continue
 		}
+		result := batch.Result
 
 		// Display results
 		fmt.Printf("\nOptimization Results:\n")
@@ -125,22 +143,31 @@ func main() {
 		if result.Caveats != "" {
 			fmt.Printf("\nCaveats:\n%s\n", result.Caveats)
 		}
-
-		// Small delay to respect API rate limits
-		time.Sleep(2 * time.Second)
+		if result.ValidationError != "" {
+			fmt.Printf("\nInvalid rewrite:\n%s\n", result.ValidationError)
+		}
+		if result.PlanDiff != nil {
+			fmt.Printf("\nPlan Changes:\n")
+			if len(result.PlanDiff.Summary) == 0 {
+				fmt.Printf("  (same operators)\n")
+			}
+			for _, line := range result.PlanDiff.Summary {
+				fmt.Printf("  - %s\n", line)
+			}
+		}
 	}
 
 	// Show pending optimizations
 	fmt.Printf("\n=== Pending Optimizations Summary ===\n")
-	pending, err := engine.ListPendingOptimizations(ctx, 10)
+	pending, err := engine.ListPendingOptimizations(ctx, analyze.RewriteSortSeverity, 10)
 	if err != nil {
 		log.Printf("Failed to list pending optimizations: %v", err)
 		return
 	}
 
 	for i, opt := range pending {
-		fmt.Printf("%d. ID: %d, Confidence: %.2f, Type: %s\n", 
-			i+1, opt.ID, opt.ConfidenceScore, opt.Pattern.Type)
+		fmt.Printf("%d. ID: %d, Confidence: %.2f, Type: %s, Severity: %s\n", 
+			i+1, opt.ID, opt.ConfidenceScore, opt.Pattern.Type, opt.Pattern.MaxSeverity())
 	}
 
 	fmt.Println("\nSQL Analysis Engine test completed successfully!")
@@ -0,0 +1,76 @@
+package analyze
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/matthieukhl/latentia/internal/safety"
+)
+
+// maxStoredPlanBytes caps the EXPLAIN ANALYZE output kept for a slow query;
+// prompts cut it further to llm.schema.plan_max_bytes
+const maxStoredPlanBytes = 1 << 20
+
+// CaptureActualPlan runs EXPLAIN ANALYZE of sql through executor, which
+// executes the statement again under the sandbox limits, and returns the
+// plan as text, one operator per line under a header of the column names
+func CaptureActualPlan(ctx context.Context, executor *safety.SafeExecutor, sql string) (string, error) {
+	result, err := executor.Query(ctx, safety.PurposeCapture, "EXPLAIN ANALYZE "+sql)
+	if err != nil {
+		return "", err
+	}
+	plan := formatActualPlan(result)
+	if result.Truncated {
+		plan += "-- more operators beyond safety.max_rows\n"
+	}
+	return TruncatePlan(plan, maxStoredPlanBytes), nil
+}
+
+// formatActualPlan renders the rows of EXPLAIN ANALYZE with their cells
+// separated by " | ", keeping the tree drawing of the id column
+func formatActualPlan(result *safety.Result) string {
+	var b strings.Builder
+	b.WriteString(strings.Join(result.Columns, " | "))
+	b.WriteByte('\n')
+	cells := make([]string, len(result.Columns))
+	for _, row := range result.Rows {
+		for i := range cells {
+			cells[i] = ""
+			if i < len(row) {
+				cells[i] = row[i].String
+			}
+		}
+		b.WriteString(strings.Join(cells, " | "))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// TruncatePlan cuts plan to maxBytes at a line boundary, keeping the top
+// operators, which account for the time of everything beneath them, and
+// noting how many lines were left out
+func TruncatePlan(plan string, maxBytes int) string {
+	if len(plan) <= maxBytes {
+		return plan
+	}
+	lines := strings.Split(strings.TrimRight(plan, "\n"), "\n")
+	kept, size := 0, 0
+	for kept < len(lines) && size+len(lines[kept])+1+len(planNote(len(lines)-kept-1)) <= maxBytes {
+		size += len(lines[kept]) + 1
+		kept++
+	}
+	switch kept {
+	case 0:
+		// Not even the header fits whole
+		return truncateRaw(plan, maxBytes)
+	case len(lines):
+		return strings.Join(lines, "\n") + "\n"
+	}
+	return strings.Join(lines[:kept], "\n") + "\n" + planNote(len(lines)-kept)
+}
+
+// planNote ends a plan cut by TruncatePlan
+func planNote(omitted int) string {
+	return fmt.Sprintf("-- %d more line%s not shown\n", omitted, pluralS(omitted))
+}
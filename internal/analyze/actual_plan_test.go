@@ -0,0 +1,81 @@
+package analyze
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/matthieukhl/latentia/internal/config/configtest"
+	"github.com/matthieukhl/latentia/internal/llm/generate"
+)
+
+// cannedPlan is EXPLAIN ANALYZE output as CaptureActualPlan stores it
+const cannedPlan = "id | estRows | actRows | task | access object | execution info\n" +
+	"Projection_4 | 10000.00 | 12 | root |  | time:52.1ms, loops:2\n" +
+	"└─TableReader_7 | 10000.00 | 12 | root |  | time:51.9ms, loops:2\n" +
+	"  └─TableFullScan_5 | 12000.00 | 12000 | cop[tikv] | table:customers | tikv_task:{time:48ms}\n"
+
+func TestTruncatePlan(t *testing.T) {
+	lines := strings.SplitAfter(cannedPlan, "\n")
+	tests := []struct {
+		name     string
+		maxBytes int
+		want     string
+	}{
+		{"fits", len(cannedPlan), cannedPlan},
+		{"top operators kept", len(lines[0]) + len(lines[1]) + len("-- 2 more lines not shown\n"),
+			lines[0] + lines[1] + "-- 2 more lines not shown\n"},
+		{"header only", len(lines[0]) + len("-- 3 more lines not shown\n"),
+			lines[0] + "-- 3 more lines not shown\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TruncatePlan(cannedPlan, tt.maxBytes)
+			if got != tt.want {
+				t.Errorf("TruncatePlan =\n%s\nwant\n%s", got, tt.want)
+			}
+			if len(got) > tt.maxBytes {
+				t.Errorf("TruncatePlan returned %d bytes, over %d", len(got), tt.maxBytes)
+			}
+		})
+	}
+}
+
+func TestPromptIncludesActualPlan(t *testing.T) {
+	const sql = "SELECT email FROM customers WHERE email LIKE '%john%'"
+	lines := strings.SplitAfter(cannedPlan, "\n")
+	note := "-- 2 more lines not shown\n"
+	tests := []struct {
+		name   string
+		safety string
+		budget int
+		want   string
+	}{
+		{"whole plan", "", 4096, cannedPlan},
+		{"cut to the budget", "", len(lines[0]) + len(lines[1]) + len(note), lines[0] + lines[1] + note},
+		{"left out with literals redacted", "safety:\n  redact_literals: true\n", 4096, ""},
+		{"left out with no budget", "", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oe := newMetricsEngine(t, generate.NewMockGenerator("test", generate.MockOptions{}))
+			configtest.Load(t, metricsConfig+"  schema:\n    plan_max_bytes: "+strconv.Itoa(tt.budget)+"\n"+tt.safety)
+
+			pattern := oe.analyzer.AnalyzeQuery(sql)
+			pattern.ActualPlan = cannedPlan
+			prompt, err := oe.promptBuilder.BuildRetryPrompt(context.Background(), sql, pattern, nil)
+			if err != nil {
+				t.Fatalf("BuildRetryPrompt: %v", err)
+			}
+			text := prompt.String()
+			included := strings.Contains(text, "ACTUAL EXECUTION PLAN (EXPLAIN ANALYZE):\n")
+			if included != (tt.want != "") {
+				t.Fatalf("plan in the prompt = %v, want %v:\n%s", included, tt.want != "", text)
+			}
+			if tt.want != "" && !strings.Contains(text, tt.want) {
+				t.Errorf("prompt lacks %q:\n%s", tt.want, text)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package analyze
+
+import (
+	"context"
+	"sync"
+)
+
+// SlowQueryRef is a slow query to optimize in a batch
+type SlowQueryRef struct {
+	ID  int64
+	SQL string
+}
+
+// BatchResult is what OptimizeBatch got for one slow query: the stored or
+// cached rewrite, or why there is none
+type BatchResult struct {
+	Ref    SlowQueryRef
+	Result *OptimizationResult
+	Err    error
+}
+
+// OptimizeBatch runs OptimizeQuery over items with at most concurrency
+// running at once, and returns one BatchResult per item in the order of
+// items. Generator and embedder calls stay within the limits of llm.queue
+// and the providers' requests_per_minute whatever the concurrency. Once ctx
+// is done, items not yet started fail with its error.
+func (oe *OptimizationEngine) OptimizeBatch(ctx context.Context, items []SlowQueryRef, concurrency int) []BatchResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]BatchResult, len(items))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency && w < len(items); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each worker writes only the results of the items it takes
+			for i := range next {
+				results[i].Ref = items[i]
+				if err := ctx.Err(); err != nil {
+					results[i].Err = err
+					continue
+				}
+				results[i].Result, results[i].Err = oe.OptimizeQuery(ctx, items[i].ID, items[i].SQL)
+			}
+		}()
+	}
+	for i := range items {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,226 @@
+package analyze
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/safety"
+	"github.com/matthieukhl/latentia/internal/tracing"
+)
+
+// Benchmark run counts: the default, and the most one request may ask for
+const (
+	DefaultBenchmarkRuns = 5
+	MaxBenchmarkRuns     = 20
+)
+
+// ErrBenchmarkRefused is returned for rewrites of data-modifying statements,
+// which are never executed, and for statements that run longer than
+// safety.max_stmt_seconds. Statements the sandbox refuses fail with a
+// *safety.Violation instead.
+var ErrBenchmarkRefused = errors.New("benchmark refused")
+
+// BenchmarkTimings are the latencies, in seconds, of the runs of one
+// statement and the rows it returned. Rows stop counting at safety.max_rows.
+type BenchmarkTimings struct {
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	Max    float64 `json:"max"`
+	Rows   int     `json:"rows"`
+}
+
+// Benchmark compares the original and optimized statements of a rewrite
+// executed in the sandbox
+type Benchmark struct {
+	Runs      int              `json:"runs"`
+	Original  BenchmarkTimings `json:"original"`
+	Optimized BenchmarkTimings `json:"optimized"`
+
+	// RowsMatch is set when both statements returned as many rows, and
+	// RowsInconclusive instead when either one had more than
+	// safety.max_rows: counts stopped at the cap tell nothing about the
+	// rows beyond it
+	RowsMatch        bool `json:"rows_match"`
+	RowsInconclusive bool `json:"rows_inconclusive"`
+
+	// Speedup is the original median latency over the optimized one, 0
+	// when the optimized median is 0
+	Speedup       float64   `json:"speedup"`
+	BenchmarkedAt time.Time `json:"benchmarked_at"`
+}
+
+// BenchmarkRewrite executes the original and optimized SQL of a rewrite runs
+// times each, alternating between them so caches favour neither, and stores
+// the latencies and row counts on the rewrite. Statements run on the target
+// of the rewrite's slow query through the sandbox executor: both must be
+// read-only and not match
+// safety.forbid_patterns, and a run exceeding safety.max_stmt_seconds fails
+// the benchmark.
+func (oe *OptimizationEngine) BenchmarkRewrite(ctx context.Context, rewriteID int64, runs int) (bench *Benchmark, err error) {
+	ctx, span := tracing.Start(ctx, "benchmark", tracing.Int("rewrite_id", rewriteID), tracing.Int("benchmark.runs", int64(runs)))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	if runs < 1 || runs > MaxBenchmarkRuns {
+		return nil, fmt.Errorf("runs must be between 1 and %d, got %d", MaxBenchmarkRuns, runs)
+	}
+
+	rewrite, err := oe.GetOptimizationByID(ctx, rewriteID)
+	if err != nil {
+		return nil, err
+	}
+	if rewrite.Pattern.IsDML() {
+		return nil, fmt.Errorf("%w: only read-only statements can be benchmarked", ErrBenchmarkRefused)
+	}
+	// Refuse before running anything if either statement is not allowed
+	for _, stmt := range []string{rewrite.OriginalSQL, rewrite.OptimizedSQL} {
+		if _, err := safety.VerifyReadOnly(stmt); err != nil {
+			return nil, err
+		}
+	}
+	engine, err := oe.forSlowQuery(ctx, rewrite.SlowQueryID)
+	if err != nil {
+		return nil, err
+	}
+
+	var original, optimized []time.Duration
+	var originalTruncated, optimizedTruncated bool
+	bench = &Benchmark{Runs: runs}
+	for i := 0; i < runs; i++ {
+		elapsed, result, err := engine.benchmarkRun(ctx, rewrite.OriginalSQL)
+		if err != nil {
+			return nil, fmt.Errorf("original statement, run %d: %w", i+1, err)
+		}
+		original = append(original, elapsed)
+		bench.Original.Rows, originalTruncated = len(result.Rows), result.Truncated
+
+		elapsed, result, err = engine.benchmarkRun(ctx, rewrite.OptimizedSQL)
+		if err != nil {
+			return nil, fmt.Errorf("optimized statement, run %d: %w", i+1, err)
+		}
+		optimized = append(optimized, elapsed)
+		bench.Optimized.Rows, optimizedTruncated = len(result.Rows), result.Truncated
+	}
+
+	bench.Original.setTimings(original)
+	bench.Optimized.setTimings(optimized)
+	bench.compareRows(originalTruncated, optimizedTruncated)
+	if bench.Optimized.Median > 0 {
+		bench.Speedup = bench.Original.Median / bench.Optimized.Median
+	}
+	bench.BenchmarkedAt = time.Now()
+	switch {
+	case bench.RowsInconclusive:
+		slog.WarnContext(ctx, "benchmarked rewrite returns more rows than safety.max_rows, row counts not compared",
+			"rewrite_id", rewriteID, "original_rows", bench.Original.Rows, "optimized_rows", bench.Optimized.Rows)
+	case !bench.RowsMatch:
+		slog.WarnContext(ctx, "benchmarked rewrite returns a different number of rows",
+			"rewrite_id", rewriteID, "original_rows", bench.Original.Rows, "optimized_rows", bench.Optimized.Rows)
+	}
+
+	if err := oe.storeBenchmark(ctx, rewriteID, bench); err != nil {
+		return nil, err
+	}
+	span.SetAttributes(tracing.Float("benchmark.speedup", bench.Speedup), tracing.Bool("benchmark.rows_match", bench.RowsMatch),
+		tracing.Bool("benchmark.rows_inconclusive", bench.RowsInconclusive))
+	return bench, nil
+}
+
+// compareRows sets RowsMatch, or RowsInconclusive when either statement's
+// result was truncated at safety.max_rows
+func (b *Benchmark) compareRows(originalTruncated, optimizedTruncated bool) {
+	b.RowsInconclusive = originalTruncated || optimizedTruncated
+	b.RowsMatch = !b.RowsInconclusive && b.Original.Rows == b.Optimized.Rows
+}
+
+// benchmarkRun executes sql once in the sandbox, refusing runs that took
+// longer than safety.max_stmt_seconds
+func (oe *OptimizationEngine) benchmarkRun(ctx context.Context, sql string) (time.Duration, *safety.Result, error) {
+	limit := safety.StatementTimeout()
+	started := time.Now()
+	result, err := oe.executor.Query(ctx, safety.PurposeBenchmark, sql)
+	// The server's MAX_EXECUTION_TIME or the sandbox deadline stops a slow
+	// statement with an error; either way it ran out of time
+	if limit > 0 && (err != nil && time.Since(started) >= limit || err == nil && result.Duration > limit) {
+		return 0, nil, fmt.Errorf("%w: statement ran longer than safety.max_stmt_seconds (%v)", ErrBenchmarkRefused, limit)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return result.Duration, result, nil
+}
+
+// setTimings sets the minimum, median and maximum of runs, in seconds
+func (t *BenchmarkTimings) setTimings(runs []time.Duration) {
+	sorted := append([]time.Duration(nil), runs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		median = (sorted[len(sorted)/2-1] + median) / 2
+	}
+	t.Min = sorted[0].Seconds()
+	t.Median = median.Seconds()
+	t.Max = sorted[len(sorted)-1].Seconds()
+}
+
+// storeBenchmark stores b on rewrite id, with a NULL benchmark_rows_match
+// for inconclusive row counts
+func (oe *OptimizationEngine) storeBenchmark(ctx context.Context, id int64, b *Benchmark) error {
+	rowsMatch := sql.NullBool{Bool: b.RowsMatch, Valid: !b.RowsInconclusive}
+	_, err := oe.db.ExecContext(ctx, `
+		UPDATE app_rewrites
+		SET benchmark_runs = ?,
+		    benchmark_original_min = ?, benchmark_original_median = ?, benchmark_original_max = ?, benchmark_original_rows = ?,
+		    benchmark_optimized_min = ?, benchmark_optimized_median = ?, benchmark_optimized_max = ?, benchmark_optimized_rows = ?,
+		    benchmark_rows_match = ?, benchmarked_at = ?
+		WHERE id = ?
+	`, b.Runs,
+		b.Original.Min, b.Original.Median, b.Original.Max, b.Original.Rows,
+		b.Optimized.Min, b.Optimized.Median, b.Optimized.Max, b.Optimized.Rows,
+		rowsMatch, b.BenchmarkedAt, id)
+	if err != nil {
+		return fmt.Errorf("failed to store benchmark of rewrite %d: %w", id, err)
+	}
+	return nil
+}
+
+const benchmarkColumns = `benchmark_runs,
+	COALESCE(benchmark_original_min, 0), COALESCE(benchmark_original_median, 0), COALESCE(benchmark_original_max, 0), COALESCE(benchmark_original_rows, 0),
+	COALESCE(benchmark_optimized_min, 0), COALESCE(benchmark_optimized_median, 0), COALESCE(benchmark_optimized_max, 0), COALESCE(benchmark_optimized_rows, 0),
+	benchmark_rows_match, benchmarked_at`
+
+// getBenchmark returns the last benchmark of a rewrite, nil when it has not
+// been benchmarked
+func (oe *OptimizationEngine) getBenchmark(ctx context.Context, id int64) (*Benchmark, error) {
+	var (
+		b             Benchmark
+		runs          sql.NullInt64
+		rowsMatch     sql.NullBool
+		benchmarkedAt sql.NullTime
+	)
+	err := oe.db.QueryRowContext(ctx, "SELECT "+benchmarkColumns+" FROM app_rewrites WHERE id = ?", id).Scan(
+		&runs,
+		&b.Original.Min, &b.Original.Median, &b.Original.Max, &b.Original.Rows,
+		&b.Optimized.Min, &b.Optimized.Median, &b.Optimized.Max, &b.Optimized.Rows,
+		&rowsMatch, &benchmarkedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load benchmark: %w", err)
+	}
+	if !runs.Valid {
+		return nil, nil
+	}
+	b.Runs = int(runs.Int64)
+	b.RowsMatch, b.RowsInconclusive = rowsMatch.Bool, !rowsMatch.Valid
+	b.BenchmarkedAt = benchmarkedAt.Time
+	if b.Optimized.Median > 0 {
+		b.Speedup = b.Original.Median / b.Optimized.Median
+	}
+	return &b, nil
+}
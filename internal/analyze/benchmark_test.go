@@ -0,0 +1,31 @@
+package analyze
+
+import "testing"
+
+func TestBenchmarkCompareRows(t *testing.T) {
+	tests := []struct {
+		name                    string
+		original, optimized     int
+		originalTrunc, optTrunc bool
+		wantMatch, wantUnknown  bool
+	}{
+		{"same count", 42, 42, false, false, true, false},
+		{"different count", 42, 41, false, false, false, false},
+		{"both empty", 0, 0, false, false, true, false},
+		// Both stopped at max_rows: the results may still differ
+		{"both capped", 1000, 1000, true, true, false, true},
+		{"original capped", 1000, 12, true, false, false, true},
+		{"optimized capped", 12, 1000, false, true, false, true},
+		// An exact fit at the cap is not truncated
+		{"exactly max_rows", 1000, 1000, false, false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Benchmark{Original: BenchmarkTimings{Rows: tt.original}, Optimized: BenchmarkTimings{Rows: tt.optimized}}
+			b.compareRows(tt.originalTrunc, tt.optTrunc)
+			if b.RowsMatch != tt.wantMatch || b.RowsInconclusive != tt.wantUnknown {
+				t.Errorf("RowsMatch, RowsInconclusive = %v, %v, want %v, %v", b.RowsMatch, b.RowsInconclusive, tt.wantMatch, tt.wantUnknown)
+			}
+		})
+	}
+}
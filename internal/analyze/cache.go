@@ -0,0 +1,60 @@
+package analyze
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/metrics"
+)
+
+type noCacheKey struct{}
+
+// WithoutRewriteCache returns a context under which OptimizeQuery always
+// calls the generator, even when the digest has a recent rewrite
+func WithoutRewriteCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+func rewriteCacheDisabled(ctx context.Context) bool {
+	disabled, _ := ctx.Value(noCacheKey{}).(bool)
+	return disabled
+}
+
+// cachedRewrite returns the latest pending or accepted rewrite of any slow
+// query sharing the digest and target of slowQueryID created within
+// analysis.rewrite_cache_ttl, accepted ones first, or nil when there is
+// none or the cache is disabled
+func (oe *OptimizationEngine) cachedRewrite(ctx context.Context, slowQueryID int64) (*OptimizationResult, error) {
+	ttl := config.Current().Analysis.RewriteCacheTTL
+	if ttl <= 0 || rewriteCacheDisabled(ctx) {
+		return nil, nil
+	}
+
+	var id int64
+	err := oe.db.QueryRowContext(ctx, `
+		SELECT r.id
+		FROM app_slow_queries cur
+		JOIN app_slow_queries q ON q.digest = cur.digest AND q.target = cur.target
+		JOIN app_rewrites r ON r.slow_query_id = q.id
+		WHERE cur.id = ? AND r.status IN ('pending', 'accepted') AND r.created_at >= ?
+		ORDER BY r.status = 'accepted' DESC, r.created_at DESC, r.id DESC
+		LIMIT 1`, slowQueryID, time.Now().Add(-ttl)).Scan(&id)
+	if err == sql.ErrNoRows {
+		metrics.RewriteCacheLookups.Inc(metrics.CacheMiss)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up a rewrite of the digest of slow query %d: %w", slowQueryID, err)
+	}
+
+	result, err := oe.GetOptimizationByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	result.Cached = true
+	metrics.RewriteCacheLookups.Inc(metrics.CacheHit)
+	return result, nil
+}
@@ -0,0 +1,95 @@
+package analyze
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Where a rewrite's confidence score came from, see
+// OptimizationResult.ConfidenceSource
+const (
+	// ConfidenceHeuristic scores come from the query pattern and the
+	// length of the LLM's explanations alone
+	ConfidenceHeuristic = "heuristic"
+
+	// ConfidencePlan scores compare the EXPLAIN plans of the original and
+	// the rewrite instead of the LLM's explanations
+	ConfidencePlan = "plan"
+)
+
+// planSignals are the objective differences between the plans of a
+// statement and its rewrite that the confidence score weighs
+type planSignals struct {
+	// RowsRatio is the rows the rewrite's plan is estimated to read over
+	// the original's, 0 when the original's estimates are missing
+	RowsRatio float64 `json:"est_rows_ratio,omitempty"`
+
+	// FullScansRemoved is how many fewer TableFullScan operators the
+	// rewrite's plan has, negative when it has more
+	FullScansRemoved int `json:"full_scans_removed"`
+
+	// TablesChanged is set when the rewrite reads tables the original does
+	// not, or no longer reads some of them
+	TablesChanged bool `json:"tables_changed"`
+}
+
+// comparePlans returns the plan signals of a rewrite, nil when either plan
+// could not be obtained. Tables are compared from the SQL rather than the
+// plans, whose access objects name aliases.
+func (qa *QueryAnalyzer) comparePlans(original, optimized []PlanOperator, originalSQL, optimizedSQL string) *planSignals {
+	if original == nil || optimized == nil {
+		return nil
+	}
+
+	signals := &planSignals{
+		FullScansRemoved: countOperator(original, "TableFullScan") - countOperator(optimized, "TableFullScan"),
+		TablesChanged:    !sameTables(qa.extractTables(originalSQL), qa.extractTables(optimizedSQL)),
+	}
+	if before := rowsRead(original); before > 0 {
+		signals.RowsRatio = rowsRead(optimized) / before
+	}
+	return signals
+}
+
+// rowsRead sums the estimated rows of the operators that access a table,
+// which is what a rewrite can reduce without changing the result
+func rowsRead(plan []PlanOperator) float64 {
+	var total float64
+	for _, op := range plan {
+		if op.Table() == "" {
+			continue
+		}
+		if rows, err := strconv.ParseFloat(op.EstRows, 64); err == nil {
+			total += rows
+		}
+	}
+	return total
+}
+
+func countOperator(plan []PlanOperator, operator string) int {
+	n := 0
+	for _, op := range plan {
+		if op.Operator == operator {
+			n++
+		}
+	}
+	return n
+}
+
+// sameTables reports whether a and b name the same tables, ignoring case
+// and order
+func sameTables(a, b []string) bool {
+	set := map[string]int{}
+	for _, t := range a {
+		set[strings.ToLower(t)] |= 1
+	}
+	for _, t := range b {
+		set[strings.ToLower(t)] |= 2
+	}
+	for _, in := range set {
+		if in != 3 {
+			return false
+		}
+	}
+	return true
+}
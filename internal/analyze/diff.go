@@ -0,0 +1,663 @@
+package analyze
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around each hunk
+const diffContext = 3
+
+// maxDiffCells bounds the line LCS table; larger statements diff as a full
+// replacement
+const maxDiffCells = 1 << 20
+
+// SQLDiff compares an original statement with its rewrite
+type SQLDiff struct {
+	// Unified is a unified diff of both statements after FormatSQL, empty
+	// when they format the same
+	Unified string     `json:"unified"`
+	Clauses ClauseDiff `json:"clauses"`
+
+	// Summary describes the clause changes, one per line
+	Summary []string `json:"summary"`
+}
+
+// ClauseDiff summarizes changes to the clauses of the outermost SELECT (the
+// first branch of a UNION). Predicates and join conditions are compared as
+// sets of AND-ed conditions, so reordering them is not a change.
+type ClauseDiff struct {
+	ColumnsAdded      []string     `json:"columns_added,omitempty"`
+	ColumnsRemoved    []string     `json:"columns_removed,omitempty"`
+	JoinsAdded        []string     `json:"joins_added,omitempty"`
+	JoinsRemoved      []string     `json:"joins_removed,omitempty"`
+	JoinsChanged      []JoinChange `json:"joins_changed,omitempty"`
+	PredicatesAdded   []string     `json:"predicates_added,omitempty"`
+	PredicatesRemoved []string     `json:"predicates_removed,omitempty"`
+	GroupBy           *Change      `json:"group_by,omitempty"`
+	OrderBy           *Change      `json:"order_by,omitempty"`
+	Limit             *Change      `json:"limit,omitempty"`
+}
+
+// JoinChange is a table joined in both statements with a different join type
+// or condition
+type JoinChange struct {
+	Table  string `json:"table"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// Change is a clause that differs; an empty side means it was added or removed
+type Change struct {
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// DiffSQL computes the line and clause diffs between original and optimized
+func DiffSQL(original, optimized string) *SQLDiff {
+	clauses := diffClauses(parseSelect(tokenizeSQL(original)), parseSelect(tokenizeSQL(optimized)))
+	return &SQLDiff{
+		Unified: unifiedDiff(strings.Split(FormatSQL(original), "\n"), strings.Split(FormatSQL(optimized), "\n")),
+		Clauses: clauses,
+		Summary: clauses.Summary(),
+	}
+}
+
+// Empty reports whether no clause changed
+func (d ClauseDiff) Empty() bool {
+	return len(d.Summary()) == 0
+}
+
+// Summary describes each change in a line such as "LIMIT added: LIMIT 100"
+func (d ClauseDiff) Summary() []string {
+	lines := []string{}
+	for _, c := range d.ColumnsRemoved {
+		lines = append(lines, "column removed from SELECT: "+c)
+	}
+	for _, c := range d.ColumnsAdded {
+		lines = append(lines, "column added to SELECT: "+c)
+	}
+	for _, j := range d.JoinsRemoved {
+		lines = append(lines, "join removed: "+j)
+	}
+	for _, j := range d.JoinsAdded {
+		lines = append(lines, "join added: "+j)
+	}
+	for _, j := range d.JoinsChanged {
+		lines = append(lines, fmt.Sprintf("join on %s changed: %s -> %s", j.Table, j.Before, j.After))
+	}
+	for _, p := range d.PredicatesRemoved {
+		lines = append(lines, "predicate removed: "+p)
+	}
+	for _, p := range d.PredicatesAdded {
+		lines = append(lines, "predicate added: "+p)
+	}
+	for _, clause := range []struct {
+		name   string
+		change *Change
+	}{{"GROUP BY", d.GroupBy}, {"ORDER BY", d.OrderBy}, {"LIMIT", d.Limit}} {
+		switch c := clause.change; {
+		case c == nil:
+		case c.Before == "":
+			lines = append(lines, clause.name+" added: "+c.After)
+		case c.After == "":
+			lines = append(lines, clause.name+" removed: "+c.Before)
+		default:
+			lines = append(lines, fmt.Sprintf("%s changed: %s -> %s", clause.name, c.Before, c.After))
+		}
+	}
+	return lines
+}
+
+// selectClauses are the parts of a SELECT compared by the clause diff
+type selectClauses struct {
+	distinct   bool
+	columns    []string
+	joins      []joinRef
+	predicates []predicate
+	groupBy    string
+	orderBy    string
+	limit      string
+
+	// setOps are the top-level set operators after the first branch, such
+	// as UNION ALL, in order
+	setOps []string
+}
+
+// joinRef is one table of the FROM clause; the first table and comma-joined
+// tables have kind FROM
+type joinRef struct {
+	kind         string
+	table        string
+	condition    string
+	conditionKey string // condition with its conjuncts in canonical order
+
+	// onKeys are the canonical keys of the ON conjuncts
+	onKeys []string
+}
+
+// predicate is one AND-ed condition as written, with the canonical key it is
+// compared by. Its shape is its tokens sorted, the same for two conditions
+// that only place the same columns, operators and literals differently.
+type predicate struct {
+	key   string
+	text  string
+	shape string
+}
+
+func (j joinRef) String() string {
+	s := j.kind + " " + j.table
+	if j.condition != "" {
+		s += " " + j.condition
+	}
+	return s
+}
+
+// parseSelect splits the outermost SELECT into its clauses. It stops at the
+// first top-level UNION, EXCEPT or INTERSECT, only noting the set operators
+// from there on.
+func parseSelect(tokens []sqlToken) selectClauses {
+	var parsed selectClauses
+
+	// The outermost SELECT is the first at the lowest depth; CTE bodies and
+	// subqueries are nested deeper
+	start, depth := -1, 0
+	for i, tok := range tokens {
+		if tok.text == "SELECT" && (start < 0 || tok.depth < depth) {
+			start, depth = i, tok.depth
+		}
+	}
+	if start < 0 {
+		return parsed
+	}
+
+	clauses := map[string][]sqlToken{}
+	current := "SELECT"
+	for i := start + 1; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.depth < depth || tok.text == ";" {
+			break
+		}
+		if tok.depth == depth && tok.kind == tokenKeyword {
+			name := tok.text
+			if (name == "GROUP" || name == "ORDER") && i+1 < len(tokens) && tokens[i+1].text == "BY" {
+				name += " BY"
+				i++
+			}
+			switch name {
+			case "UNION", "EXCEPT", "INTERSECT":
+				parsed.setOps = setOperators(tokens[i:], depth)
+				i = len(tokens)
+				continue
+			case "FROM", "WHERE", "GROUP BY", "HAVING", "ORDER BY", "LIMIT", "WINDOW", "FOR", "LOCK":
+				current = name
+				clauses[current] = nil
+				if name == "LIMIT" {
+					// Keep the keyword so the change reads as a clause
+					clauses[current] = append(clauses[current], tok)
+				}
+				continue
+			}
+		}
+		clauses[current] = append(clauses[current], tok)
+	}
+
+	for _, tok := range clauses["SELECT"] {
+		if tok.kind != tokenHint && !selectModifiers[tok.text] {
+			break
+		}
+		parsed.distinct = parsed.distinct || tok.text == "DISTINCT" || tok.text == "DISTINCTROW"
+	}
+	for _, item := range splitTopLevel(skipSelectModifiers(clauses["SELECT"]), depth, ",") {
+		parsed.columns = append(parsed.columns, renderTokens(item))
+	}
+	parsed.joins = parseJoins(clauses["FROM"], depth)
+	parsed.predicates = conjuncts(clauses["WHERE"], depth)
+	for _, p := range conjuncts(clauses["HAVING"], depth) {
+		parsed.predicates = append(parsed.predicates, predicate{key: "HAVING " + p.key, text: "HAVING " + p.text, shape: "HAVING " + p.shape})
+	}
+	parsed.groupBy = renderTokens(clauses["GROUP BY"])
+	parsed.orderBy = renderTokens(clauses["ORDER BY"])
+	parsed.limit = renderTokens(clauses["LIMIT"])
+	return parsed
+}
+
+// setOperators lists the set operators at depth in tokens, each with its ALL
+// or DISTINCT
+func setOperators(tokens []sqlToken, depth int) []string {
+	var ops []string
+	for i, tok := range tokens {
+		if tok.depth != depth {
+			continue
+		}
+		switch tok.text {
+		case "UNION", "EXCEPT", "INTERSECT":
+			op := tok.text
+			if i+1 < len(tokens) && (tokens[i+1].text == "ALL" || tokens[i+1].text == "DISTINCT") {
+				op += " " + tokens[i+1].text
+			}
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+func skipSelectModifiers(tokens []sqlToken) []sqlToken {
+	for len(tokens) > 0 && (tokens[0].kind == tokenHint || selectModifiers[tokens[0].text]) {
+		tokens = tokens[1:]
+	}
+	return tokens
+}
+
+// splitTopLevel splits tokens at separators found at depth
+func splitTopLevel(tokens []sqlToken, depth int, sep string) [][]sqlToken {
+	var parts [][]sqlToken
+	var part []sqlToken
+	for _, tok := range tokens {
+		if tok.depth == depth && tok.text == sep {
+			parts = append(parts, part)
+			part = nil
+			continue
+		}
+		part = append(part, tok)
+	}
+	if len(part) > 0 {
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// conjuncts returns the AND-ed conditions of a WHERE, HAVING or ON clause,
+// sorted by canonical key so their order does not matter
+func conjuncts(tokens []sqlToken, depth int) []predicate {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	var out []predicate
+	var part []sqlToken
+	between := 0
+	flush := func() {
+		if len(part) > 0 {
+			out = append(out, predicate{key: canonicalPredicate(part, depth), text: renderTokens(part), shape: predicateShape(part, depth)})
+			part = nil
+		}
+	}
+	for _, tok := range tokens {
+		if tok.depth == depth {
+			switch tok.text {
+			case "BETWEEN":
+				between++
+			case "AND":
+				if between > 0 {
+					between--
+				} else {
+					flush()
+					continue
+				}
+			}
+		}
+		part = append(part, tok)
+	}
+	flush()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].key < out[j].key })
+	return out
+}
+
+// predicateShape renders a condition in a normal form, the same for two
+// conditions that only place the same operands differently: enclosing
+// parentheses dropped, OR-ed conditions, the operands of a symmetric
+// comparison and the values of an IN list in sorted order
+func predicateShape(tokens []sqlToken, depth int) string {
+	for len(tokens) > 2 && tokens[0].text == "(" && tokens[len(tokens)-1].text == ")" &&
+		!slices.ContainsFunc(tokens[1:len(tokens)-1], func(tok sqlToken) bool { return tok.depth <= depth }) {
+		tokens, depth = tokens[1:len(tokens)-1], depth+1
+	}
+	var disjuncts []string
+	for _, part := range splitTopLevel(tokens, depth, "OR") {
+		disjuncts = append(disjuncts, canonicalPredicate(sortInList(part, depth), depth))
+	}
+	slices.Sort(disjuncts)
+	return strings.Join(disjuncts, " OR ")
+}
+
+// sortInList returns tokens with the values of a top-level IN list sorted
+func sortInList(tokens []sqlToken, depth int) []sqlToken {
+	in := slices.IndexFunc(tokens, func(tok sqlToken) bool { return tok.depth == depth && tok.text == "IN" })
+	if in < 0 || in+1 >= len(tokens) || tokens[in+1].text != "(" || tokens[len(tokens)-1].text != ")" {
+		return tokens
+	}
+	values := splitTopLevel(tokens[in+2:len(tokens)-1], depth+1, ",")
+	slices.SortFunc(values, func(a, b []sqlToken) int { return strings.Compare(renderTokens(a), renderTokens(b)) })
+	sorted := append([]sqlToken(nil), tokens[:in+2]...)
+	for i, value := range values {
+		if i > 0 {
+			sorted = append(sorted, sqlToken{text: ",", kind: tokenPunct, depth: depth + 1})
+		}
+		sorted = append(sorted, value...)
+	}
+	return append(sorted, tokens[len(tokens)-1])
+}
+
+// canonicalPredicate renders a condition, ordering the operands of a
+// symmetric comparison so "a = b" and "b = a" compare equal
+func canonicalPredicate(tokens []sqlToken, depth int) string {
+	op := -1
+	for i, tok := range tokens {
+		if tok.depth != depth {
+			continue
+		}
+		switch tok.text {
+		case "=", "<=>", "!=", "<>":
+			if op >= 0 {
+				return renderTokens(tokens)
+			}
+			op = i
+		case "<", ">", "<=", ">=", "OR", "XOR", "NOT", "IS", "LIKE", "IN", "BETWEEN", "||", "&&":
+			// Ordering comparisons and compound conditions are left as written
+			return renderTokens(tokens)
+		}
+	}
+	if op <= 0 || op == len(tokens)-1 {
+		return renderTokens(tokens)
+	}
+
+	left, right := renderTokens(tokens[:op]), renderTokens(tokens[op+1:])
+	operator := tokens[op].text
+	if operator == "!=" {
+		operator = "<>"
+	}
+	if right < left {
+		left, right = right, left
+	}
+	return left + " " + operator + " " + right
+}
+
+// parseJoins splits a FROM clause into its tables and joins
+func parseJoins(tokens []sqlToken, depth int) []joinRef {
+	var refs []joinRef
+	var current []sqlToken
+	kind := "FROM"
+
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		ref := joinRef{kind: kind}
+		split := len(current)
+		for i, tok := range current {
+			if tok.depth == depth && (tok.text == "ON" || tok.text == "USING") {
+				split = i
+				break
+			}
+		}
+		ref.table = renderTokens(current[:split])
+		if split < len(current) {
+			ref.condition = renderTokens(current[split:])
+			ref.conditionKey = ref.condition
+			if current[split].text == "ON" {
+				for _, p := range conjuncts(current[split+1:], depth) {
+					ref.onKeys = append(ref.onKeys, p.key)
+				}
+				ref.conditionKey = "ON " + strings.Join(ref.onKeys, " AND ")
+			}
+		}
+		refs = append(refs, ref)
+		current = nil
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.depth == depth {
+			if tok.text == "," {
+				flush()
+				kind = "FROM"
+				continue
+			}
+			if tok.kind == tokenKeyword && joinStarts[tok.text] {
+				flush()
+				var words []string
+				for ; i < len(tokens) && tokens[i].kind == tokenKeyword && (joinStarts[tokens[i].text] || tokens[i].text == "OUTER"); i++ {
+					words = append(words, tokens[i].text)
+					if tokens[i].text == "JOIN" || tokens[i].text == "STRAIGHT_JOIN" {
+						i++
+						break
+					}
+				}
+				i--
+				kind = normalizeJoinType(words)
+				continue
+			}
+		}
+		current = append(current, tok)
+	}
+	flush()
+	return refs
+}
+
+// normalizeJoinType spells equivalent join types alike: JOIN and INNER JOIN
+// are INNER JOIN, LEFT OUTER JOIN is LEFT JOIN
+func normalizeJoinType(words []string) string {
+	var kept []string
+	for _, w := range words {
+		if w != "OUTER" {
+			kept = append(kept, w)
+		}
+	}
+	kind := strings.Join(kept, " ")
+	if kind == "JOIN" {
+		return "INNER JOIN"
+	}
+	return kind
+}
+
+func diffClauses(before, after selectClauses) ClauseDiff {
+	var d ClauseDiff
+	d.ColumnsRemoved, d.ColumnsAdded = setDiff(before.columns, after.columns)
+	d.PredicatesRemoved, d.PredicatesAdded = predicateDiff(before.predicates, after.predicates)
+
+	beforeJoins := map[string]joinRef{}
+	for _, j := range before.joins {
+		beforeJoins[j.table] = j
+	}
+	afterJoins := map[string]joinRef{}
+	for _, j := range after.joins {
+		afterJoins[j.table] = j
+		old, ok := beforeJoins[j.table]
+		switch {
+		case !ok:
+			d.JoinsAdded = append(d.JoinsAdded, j.String())
+		case old.kind != j.kind || old.conditionKey != j.conditionKey:
+			d.JoinsChanged = append(d.JoinsChanged, JoinChange{Table: j.table, Before: old.String(), After: j.String()})
+		}
+	}
+	for _, j := range before.joins {
+		if _, ok := afterJoins[j.table]; !ok {
+			d.JoinsRemoved = append(d.JoinsRemoved, j.String())
+		}
+	}
+
+	d.GroupBy = changed(before.groupBy, after.groupBy)
+	d.OrderBy = changed(before.orderBy, after.orderBy)
+	d.Limit = changed(before.limit, after.limit)
+	return d
+}
+
+func changed(before, after string) *Change {
+	if before == after {
+		return nil
+	}
+	return &Change{Before: before, After: after}
+}
+
+// setDiff returns the items only in a and only in b, keeping their order
+func setDiff(a, b []string) (onlyA, onlyB []string) {
+	inA := map[string]bool{}
+	for _, s := range a {
+		inA[s] = true
+	}
+	inB := map[string]bool{}
+	for _, s := range b {
+		inB[s] = true
+		if !inA[s] {
+			onlyB = append(onlyB, s)
+		}
+	}
+	for _, s := range a {
+		if !inB[s] {
+			onlyA = append(onlyA, s)
+		}
+	}
+	return onlyA, onlyB
+}
+
+// predicateDiff returns the text of predicates only in a and only in b
+func predicateDiff(a, b []predicate) (onlyA, onlyB []string) {
+	keys := func(ps []predicate) []string {
+		out := make([]string, len(ps))
+		for i, p := range ps {
+			out[i] = p.key
+		}
+		return out
+	}
+	texts := map[string]string{}
+	for _, p := range append(append([]predicate(nil), a...), b...) {
+		texts[p.key] = p.text
+	}
+	inA, inB := setDiff(keys(a), keys(b))
+	for _, key := range inA {
+		onlyA = append(onlyA, texts[key])
+	}
+	for _, key := range inB {
+		onlyB = append(onlyB, texts[key])
+	}
+	return onlyA, onlyB
+}
+
+// unifiedDiff renders a unified diff of two line slices with diffContext
+// lines of context around each hunk, or nothing when they are equal
+func unifiedDiff(a, b []string) string {
+	ops := diffLines(a, b)
+	if !slices.ContainsFunc(ops, func(op diffOp) bool { return op.kind != ' ' }) {
+		return ""
+	}
+
+	var out strings.Builder
+	out.WriteString("--- original\n+++ optimized\n")
+
+	for start := 0; start < len(ops); {
+		// Find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		// Extend the hunk while changes are within twice the context
+		end := start
+		for i := start; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				end = i + 1
+			} else if i-end >= 2*diffContext {
+				break
+			}
+		}
+		from := max(start-diffContext, 0)
+		to := min(end+diffContext, len(ops))
+
+		aStart, aCount, bStart, bCount := 0, 0, 0, 0
+		for i := 0; i < from; i++ {
+			if ops[i].kind != '+' {
+				aStart++
+			}
+			if ops[i].kind != '-' {
+				bStart++
+			}
+		}
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, op := range ops[from:to] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			out.WriteByte('\n')
+		}
+		start = to
+	}
+	return out.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// diffLines aligns a and b on their longest common subsequence
+func diffLines(a, b []string) []diffOp {
+	var ops []diffOp
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return ops
+	}
+
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
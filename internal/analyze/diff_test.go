@@ -0,0 +1,222 @@
+package analyze
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffSQLAddedLimit(t *testing.T) {
+	d := DiffSQL(
+		"SELECT id, total FROM orders WHERE status = 'paid'",
+		"select id, total\nfrom orders  where status = 'paid' limit 100;",
+	)
+
+	want := ClauseDiff{Limit: &Change{After: "LIMIT 100"}}
+	if !reflect.DeepEqual(d.Clauses, want) {
+		t.Errorf("clauses = %+v, want only the LIMIT added", d.Clauses)
+	}
+	if got := []string{"LIMIT added: LIMIT 100"}; !reflect.DeepEqual(d.Summary, got) {
+		t.Errorf("summary = %q, want %q", d.Summary, got)
+	}
+	// Case and whitespace differences are formatted away
+	wantUnified := `--- original
++++ optimized
+@@ -3,3 +3,4 @@
+   total
+ FROM orders
+ WHERE status = 'paid'
++LIMIT 100
+`
+	if d.Unified != wantUnified {
+		t.Errorf("unified diff =\n%s\nwant\n%s", d.Unified, wantUnified)
+	}
+}
+
+func TestDiffSQLChangedJoinType(t *testing.T) {
+	d := DiffSQL(
+		"SELECT o.id FROM orders o LEFT JOIN customers c ON c.id = o.customer_id WHERE c.country = 'FR'",
+		"SELECT o.id FROM orders o INNER JOIN customers c ON c.id = o.customer_id WHERE c.country = 'FR'",
+	)
+
+	want := ClauseDiff{JoinsChanged: []JoinChange{{
+		Table:  "customers c",
+		Before: "LEFT JOIN customers c ON c.id = o.customer_id",
+		After:  "INNER JOIN customers c ON c.id = o.customer_id",
+	}}}
+	if !reflect.DeepEqual(d.Clauses, want) {
+		t.Errorf("clauses = %+v, want the join type changed", d.Clauses)
+	}
+	if !strings.Contains(d.Unified, "\n-  LEFT JOIN customers c ON c.id = o.customer_id\n+  INNER JOIN customers c ON c.id = o.customer_id\n") {
+		t.Errorf("unified diff does not show the join line replaced:\n%s", d.Unified)
+	}
+}
+
+func TestDiffSQLJoins(t *testing.T) {
+	tests := []struct {
+		name               string
+		original, proposed string
+		want               ClauseDiff
+	}{
+		{
+			"join added",
+			"SELECT * FROM a JOIN b ON a.id = b.id",
+			"SELECT * FROM a JOIN b ON a.id = b.id JOIN c ON c.id = a.id",
+			ClauseDiff{JoinsAdded: []string{"INNER JOIN c ON c.id = a.id"}},
+		},
+		{
+			"join removed",
+			"SELECT a.id FROM a LEFT JOIN b ON a.id = b.id",
+			"SELECT a.id FROM a",
+			ClauseDiff{JoinsRemoved: []string{"LEFT JOIN b ON a.id = b.id"}},
+		},
+		{
+			"join condition changed",
+			"SELECT * FROM a JOIN b ON a.id = b.id",
+			"SELECT * FROM a JOIN b ON a.id = b.id AND b.active = 1",
+			ClauseDiff{JoinsChanged: []JoinChange{{Table: "b", Before: "INNER JOIN b ON a.id = b.id", After: "INNER JOIN b ON a.id = b.id AND b.active = 1"}}},
+		},
+		{
+			"equivalent spellings of a join type",
+			"SELECT * FROM a JOIN b ON a.id = b.id LEFT OUTER JOIN c USING (id)",
+			"SELECT * FROM a INNER JOIN b ON a.id = b.id LEFT JOIN c USING (id)",
+			ClauseDiff{},
+		},
+		{
+			"join conditions reordered",
+			"SELECT * FROM a JOIN b ON a.id = b.id AND b.active = 1",
+			"SELECT * FROM a JOIN b ON b.active = 1 AND b.id = a.id",
+			ClauseDiff{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiffSQL(tt.original, tt.proposed).Clauses; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("clauses = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffSQLReorderedPredicatesUnchanged(t *testing.T) {
+	tests := []struct {
+		name               string
+		original, proposed string
+	}{
+		{
+			"AND-ed conditions reordered",
+			"SELECT id FROM orders WHERE status = 'paid' AND created_at >= '2024-01-01' AND (a = 1 OR b = 2)",
+			"SELECT id FROM orders WHERE (a = 1 OR b = 2) AND created_at >= '2024-01-01' AND status = 'paid'",
+		},
+		{
+			"operands of a symmetric comparison swapped",
+			"SELECT id FROM orders WHERE status = 'paid' AND total <> 0",
+			"SELECT id FROM orders WHERE 'paid' = status AND 0 != total",
+		},
+		{
+			"BETWEEN kept whole",
+			"SELECT id FROM orders WHERE total BETWEEN 10 AND 20 AND status = 'paid'",
+			"SELECT id FROM orders WHERE status = 'paid' AND total BETWEEN 10 AND 20",
+		},
+		{
+			"HAVING conditions reordered",
+			"SELECT status, COUNT(*) FROM orders GROUP BY status HAVING COUNT(*) > 1 AND MAX(total) > 100",
+			"SELECT status, COUNT(*) FROM orders GROUP BY status HAVING MAX(total) > 100 AND COUNT(*) > 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := DiffSQL(tt.original, tt.proposed)
+			if !d.Clauses.Empty() {
+				t.Errorf("clauses = %+v, want no change", d.Clauses)
+			}
+			if len(d.Summary) != 0 {
+				t.Errorf("summary = %q, want it empty", d.Summary)
+			}
+			// The line diff still shows the reordering
+			if d.Unified == "" {
+				t.Error("unified diff is empty for reordered text")
+			}
+		})
+	}
+}
+
+func TestDiffSQLPredicatesAndColumns(t *testing.T) {
+	d := DiffSQL(
+		"SELECT id, name, email FROM users WHERE active = 1 AND LOWER(email) = 'a@b.c'",
+		"SELECT id, name, created_at FROM users WHERE active = 1 AND email = 'a@b.c' ORDER BY id LIMIT 10",
+	)
+	want := ClauseDiff{
+		ColumnsAdded:      []string{"created_at"},
+		ColumnsRemoved:    []string{"email"},
+		PredicatesAdded:   []string{"email = 'a@b.c'"},
+		PredicatesRemoved: []string{"LOWER(email) = 'a@b.c'"},
+		OrderBy:           &Change{After: "id"},
+		Limit:             &Change{After: "LIMIT 10"},
+	}
+	if !reflect.DeepEqual(d.Clauses, want) {
+		t.Errorf("clauses =\n%+v\nwant\n%+v", d.Clauses, want)
+	}
+	wantSummary := []string{
+		"column removed from SELECT: email",
+		"column added to SELECT: created_at",
+		"predicate removed: LOWER(email) = 'a@b.c'",
+		"predicate added: email = 'a@b.c'",
+		"ORDER BY added: id",
+		"LIMIT added: LIMIT 10",
+	}
+	if !reflect.DeepEqual(d.Summary, wantSummary) {
+		t.Errorf("summary =\n%q\nwant\n%q", d.Summary, wantSummary)
+	}
+}
+
+func TestDiffSQLLimitChangedAndRemoved(t *testing.T) {
+	if got := DiffSQL("SELECT id FROM t LIMIT 10", "SELECT id FROM t LIMIT 100").Summary; !reflect.DeepEqual(got, []string{"LIMIT changed: LIMIT 10 -> LIMIT 100"}) {
+		t.Errorf("summary = %q", got)
+	}
+	if got := DiffSQL("SELECT id FROM t LIMIT 10", "SELECT id FROM t").Summary; !reflect.DeepEqual(got, []string{"LIMIT removed: LIMIT 10"}) {
+		t.Errorf("summary = %q", got)
+	}
+}
+
+func TestDiffSQLIdenticalAndDeterministic(t *testing.T) {
+	const sql = "SELECT o.id, c.name FROM orders o JOIN customers c ON c.id = o.customer_id WHERE o.total > 100 AND c.country IN ('FR', 'DE') ORDER BY o.id LIMIT 50"
+	d := DiffSQL(sql, sql)
+	if d.Unified != "" || !d.Clauses.Empty() {
+		t.Errorf("diff of a statement with itself = %+v", d)
+	}
+
+	proposed := strings.Replace(sql, "JOIN", "LEFT JOIN", 1)
+	first := DiffSQL(sql, proposed)
+	for range 20 {
+		if again := DiffSQL(sql, proposed); !reflect.DeepEqual(again, first) {
+			t.Fatalf("diff changed between runs:\n%+v\n%+v", first, again)
+		}
+	}
+}
+
+func TestFormatSQL(t *testing.T) {
+	got := FormatSQL("select /*+ USE_INDEX(o, idx_created) */ o.id, count(*) from orders o left outer join customers c on c.id = o.customer_id and c.active = 1 " +
+		"where o.total between 10 and 20 and o.id in (select order_id from refunds) -- trailing comment\ngroup by o.id limit 5;")
+	want := `SELECT /*+ USE_INDEX(o, idx_created) */
+  o.id,
+  COUNT(*)
+FROM orders o
+  LEFT OUTER JOIN customers c ON c.id = o.customer_id
+    AND c.active = 1
+WHERE o.total BETWEEN 10 AND 20
+  AND o.id IN (
+    SELECT
+      order_id
+    FROM refunds
+  )
+GROUP BY o.id
+LIMIT 5`
+	if got != want {
+		t.Errorf("FormatSQL =\n%s\nwant\n%s", got, want)
+	}
+	// Formatting is stable once applied
+	if again := FormatSQL(got); again != got {
+		t.Errorf("FormatSQL is not idempotent:\n%s", again)
+	}
+}
@@ -4,13 +4,24 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/matthieukhl/latentia/internal/config"
 	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/metrics"
+	"github.com/matthieukhl/latentia/internal/notify"
 	"github.com/matthieukhl/latentia/internal/rag"
+	"github.com/matthieukhl/latentia/internal/safety"
+	"github.com/matthieukhl/latentia/internal/tracing"
 	"github.com/matthieukhl/latentia/internal/types"
 )
 
@@ -20,6 +31,17 @@ type OptimizationEngine struct {
 	analyzer      *QueryAnalyzer
 	promptBuilder *PromptBuilder
 	generator     types.Generator
+	executor      *safety.SafeExecutor
+
+	// targets holds the engine of each target ForTarget was asked for,
+	// shared by all of them
+	targets *targetEngines
+}
+
+// targetEngines are the engines of the targets, by name
+type targetEngines struct {
+	mu      sync.Mutex
+	engines map[string]*OptimizationEngine
 }
 
 // OptimizationResult contains the complete optimization analysis
@@ -32,9 +54,83 @@ type OptimizationResult struct {
 	ExpectedImprovement string     `json:"expected_improvement" db:"expected_improvement"`
 	Caveats          string        `json:"caveats" db:"caveats"`
 	ConfidenceScore  float64       `json:"confidence_score" db:"confidence_score"`
-	Status           string        `json:"status" db:"status"` // pending, accepted, rejected
+	// ConfidenceSource is ConfidencePlan when the score compares EXPLAIN
+	// plans and ConfidenceHeuristic when it could not; empty for invalid
+	// rewrites and those scored before plans were compared
+	ConfidenceSource string        `json:"confidence_source,omitempty" db:"confidence_source"`
+	Status           string        `json:"status" db:"status"` // pending, accepted, rejected, suppressed, invalid
 	CreatedAt        time.Time     `json:"created_at" db:"created_at"`
 	ReviewedAt       *time.Time    `json:"reviewed_at" db:"reviewed_at"`
+	// ReviewedBy names who accepted or rejected the rewrite, and
+	// ReviewComment says why; a rejection always has one
+	ReviewedBy       string        `json:"reviewed_by,omitempty" db:"reviewed_by"`
+	ReviewComment    string        `json:"review_comment,omitempty" db:"review_comment"`
+
+	SlowQueryID int64 `json:"slow_query_id"`
+
+	// Target is the db.targets entry of the slow query, where the rewrite
+	// was explained
+	Target string `json:"target,omitempty"`
+
+	// ParentRewriteID is the rejected rewrite this one was generated to
+	// replace by ReOptimize
+	ParentRewriteID *int64 `json:"parent_rewrite_id,omitempty"`
+
+	// SemanticsChanges lists the differences from the original that can
+	// change the rows returned, such as an added LIMIT or a LEFT JOIN made
+	// INNER; they are also appended to Caveats
+	SemanticsChanges []string `json:"semantics_changes,omitempty"`
+
+	// Cached is set when OptimizeQuery returned an existing rewrite of the
+	// digest instead of generating one
+	Cached bool `json:"cached,omitempty"`
+
+	// PromptText and RawResponse are the prompt as sent, after redaction
+	// and anonymization, and the completion as received. They are only
+	// set by LoadRawExchange and on the result of OptimizeQuery.
+	PromptText  string `json:"prompt_text,omitempty"`
+	RawResponse string `json:"raw_response,omitempty"`
+
+	// Metadata records how the rewrite was produced, such as the prompt
+	// template name and hash
+	Metadata map[string]any `json:"metadata,omitempty"`
+
+	// PromptVersion identifies the prompt templates, their version, style
+	// and instructions; empty for rewrites generated before it was recorded
+	PromptVersion string `json:"prompt_version"`
+
+	// Generator tokens spent producing the rewrite and their estimated cost
+	// in US dollars
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	EstimatedCost    float64 `json:"estimated_cost"`
+
+	// Diff compares OptimizedSQL with OriginalSQL for reviewers
+	Diff *SQLDiff `json:"diff,omitempty"`
+
+	// Realized is measured after acceptance by TrackRealizedImprovements
+	Realized *RealizedImprovement `json:"realized,omitempty"`
+
+	// Benchmark is the last run of BenchmarkRewrite
+	Benchmark *Benchmark `json:"benchmark,omitempty"`
+
+	// Validation outcomes; auto-accept requires both
+	ExplainPassed     bool `json:"explain_passed"`
+	EquivalencePassed bool `json:"equivalence_passed"`
+
+	// ValidationError is why EXPLAIN refused OptimizedSQL for rewrites stored
+	// as invalid
+	ValidationError string `json:"validation_error,omitempty"`
+
+	// EXPLAIN FORMAT='brief' plans of both statements, null when a plan
+	// could not be obtained, and how they differ
+	PlanOriginal  []PlanOperator `json:"plan_original"`
+	PlanOptimized []PlanOperator `json:"plan_optimized"`
+	PlanDiff      *PlanDiff      `json:"plan_diff,omitempty"`
+
+	// IndexRecommendations are reviewed on their own; approving one only
+	// allows "agent apply-index" to create it
+	IndexRecommendations []database.IndexRecommendation `json:"index_recommendations,omitempty"`
 }
 
 // LLMResponse represents the structured response from the LLM
@@ -43,47 +139,227 @@ type LLMResponse struct {
 	Rationale           string `json:"rationale"`
 	ExpectedPlanChange  string `json:"expected_plan_change"`
 	Caveats             string `json:"caveats"`
+
+	// RecommendedIndexes are indexes the model suggests creating, kept apart
+	// from ProposedSQL so that reviewing a rewrite never runs DDL
+	RecommendedIndexes []database.IndexRecommendation `json:"recommended_indexes"`
 }
 
 func NewOptimizationEngine(db *database.DB, docStore *rag.DocumentStore, generator types.Generator) *OptimizationEngine {
-	return &OptimizationEngine{
+	oe := &OptimizationEngine{
 		db:            db,
 		analyzer:      NewQueryAnalyzer(),
-		promptBuilder: NewPromptBuilder(docStore),
+		promptBuilder: NewPromptBuilder(docStore, db).
+			WithJSONMode(generator != nil && types.SupportsJSON(generator)).
+			WithSchemas(database.NewSchemaIntrospector(db.TargetDB(), config.Current().LLM.Schema.CacheTTL)),
 		generator:     generator,
+		executor:      safety.NewSafeExecutor(db.TargetDB()),
+	}
+	oe.targets = &targetEngines{engines: map[string]*OptimizationEngine{db.TargetName(): oe}}
+	return oe
+}
+
+// ForTarget returns the engine explaining, introspecting and benchmarking
+// on the named target, "" being the default one, with the same generator
+// and documents. It fails with database.ErrUnknownTarget for a name that is
+// not an enabled target.
+func (oe *OptimizationEngine) ForTarget(name string) (*OptimizationEngine, error) {
+	tdb, err := oe.db.ForTarget(name)
+	if err != nil {
+		return nil, err
+	}
+	oe.targets.mu.Lock()
+	defer oe.targets.mu.Unlock()
+	if engine, ok := oe.targets.engines[tdb.TargetName()]; ok {
+		return engine, nil
+	}
+	engine := *oe
+	engine.db = tdb
+	promptBuilder := *oe.promptBuilder
+	if promptBuilder.schemas != nil {
+		promptBuilder.schemas = database.NewSchemaIntrospector(tdb.TargetDB(), config.Current().LLM.Schema.CacheTTL)
+	}
+	engine.promptBuilder = &promptBuilder
+	engine.executor = safety.NewSafeExecutor(tdb.TargetDB())
+	oe.targets.engines[tdb.TargetName()] = &engine
+	return &engine, nil
+}
+
+// forSlowQuery returns the engine of the target slow query slowQueryID was
+// read from, oe itself when it is already on it or the query does not exist
+func (oe *OptimizationEngine) forSlowQuery(ctx context.Context, slowQueryID int64) (*OptimizationEngine, error) {
+	var target string
+	err := oe.db.QueryRowContext(ctx, `SELECT target FROM app_slow_queries WHERE id = ?`, slowQueryID).Scan(&target)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && target == oe.db.TargetName() {
+		return oe, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the target of slow query %d: %w", slowQueryID, err)
 	}
+	return oe.ForTarget(target)
 }
 
-// OptimizeQuery processes a slow query through the complete optimization pipeline
+// OptimizeQuery processes a slow query through the complete optimization
+// pipeline, recording each stage's latency and any failure in the metrics
+// and as spans of one trace
 func (oe *OptimizationEngine) OptimizeQuery(ctx context.Context, slowQueryID int64, sql string) (*OptimizationResult, error) {
+	return oe.optimize(ctx, slowQueryID, sql, nil)
+}
+
+// Propose runs the pipeline of OptimizeQuery on sql, from the analysis to
+// the confidence score, without looking for a cached rewrite, storing the
+// result or applying the analysis policy. Generator usage is still
+// recorded. It serves one-off analyses that leave no rewrite behind.
+func (oe *OptimizationEngine) Propose(ctx context.Context, sql string) (result *OptimizationResult, err error) {
+	metrics.OptimizationsStarted.Inc()
+	ctx, span := tracing.Start(ctx, "propose")
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	
+	if _, err := safety.Optimizable(sql); err != nil {
+		metrics.OptimizationsFailed.Inc(metrics.StageValidate)
+		return nil, err
+	}
+	result, stage, err := oe.propose(ctx, span, 0, sql, nil)
+	if err != nil {
+		return nil, err
+	}
+	stage.done()
+	metrics.OptimizationsSucceeded.Inc()
+	return result, nil
+}
+
+// retryOf is the rejected rewrite a new optimization replaces
+type retryOf struct {
+	rewriteID int64
+	feedback  Feedback
+}
+
+// optimize runs the pipeline of OptimizeQuery on the target of the slow
+// query, showing the model the rejected rewrite and its feedback when retry
+// is not nil
+func (oe *OptimizationEngine) optimize(ctx context.Context, slowQueryID int64, sql string, retry *retryOf) (result *OptimizationResult, err error) {
+	engine, err := oe.forSlowQuery(ctx, slowQueryID)
+	if err != nil {
+		return nil, err
+	}
+	if engine != oe {
+		return engine.optimize(ctx, slowQueryID, sql, retry)
+	}
+	
+	metrics.OptimizationsStarted.Inc()
+	ctx, span := tracing.Start(ctx, "optimize", tracing.Int("slow_query_id", slowQueryID))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	
+	// Step 0: Refuse SQL the safety rules forbid, several statements, and
+	// administrative and DDL statements; callers can detect this with
+	// safety.AsViolation and record the reason
+	if _, err := safety.Optimizable(sql); err != nil {
+		metrics.OptimizationsFailed.Inc(metrics.StageValidate)
+		return nil, err
+	}
+	
+	// Reuse a recent rewrite of the digest rather than pay for the same
+	// generation again; retries always generate
+	if retry == nil {
+		cached, err := oe.cachedRewrite(ctx, slowQueryID)
+		if err != nil {
+			slog.WarnContext(ctx, "rewrite cache lookup failed", "slow_query_id", slowQueryID, "error", err)
+		}
+		if cached != nil {
+			span.SetAttributes(tracing.Int("cached_rewrite_id", cached.ID))
+			return cached, nil
+		}
+	}
+	
+	result, stage, err := oe.propose(ctx, span, slowQueryID, sql, retry)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Step 7: Store optimization result
+	stage.next(metrics.StageStore)
+	err = oe.storeOptimizationResult(stage.ctx, slowQueryID, result)
+	if err != nil {
+		return nil, stage.fail(fmt.Errorf("failed to store optimization result: %w", err))
+	}
+	stage.done()
+	metrics.OptimizationsSucceeded.Inc()
+	span.SetAttributes(tracing.Int("rewrite_id", result.ID), tracing.Float("confidence_score", result.ConfidenceScore))
+	if result.Status != "invalid" {
+		oe.publishRewriteCreated(ctx, slowQueryID, result)
+	}
+	
+	// Step 8: Apply the analysis policy. The rewrite is already stored, so a
+	// failure here only leaves it pending for a human.
+	if result.Status != "pending" {
+		return result, nil
+	}
+	if err := oe.applyPolicy(ctx, result); err != nil {
+		slog.WarnContext(ctx, "analysis policy not applied", "rewrite_id", result.ID, "error", err)
+	}
+	
+	return result, nil
+}
+
+// propose runs the stages of optimize from the analysis to the confidence
+// score; on success the validation stage is left for the caller to end
+func (oe *OptimizationEngine) propose(ctx context.Context, span *tracing.Span, slowQueryID int64, sql string, retry *retryOf) (*OptimizationResult, *pipelineStage, error) {
 	// Step 1: Analyze query patterns
-	pattern := oe.analyzer.AnalyzeQuery(sql)
+	stage := startStage(ctx, metrics.StageAnalysis)
+	pattern := oe.analyzeQuery(stage.ctx, slowQueryID, sql)
+	span.SetAttributes(tracing.String("query.pattern", pattern.Type), tracing.String("query.complexity", pattern.Complexity))
 	
-	// Step 2: Build context-aware prompt
-	prompt, err := oe.promptBuilder.BuildOptimizationPrompt(sql, pattern)
+	// Step 2: Build context-aware prompt; retrieval is timed by the builder
+	stage.next(metrics.StagePrompt)
+	var feedback *Feedback
+	if retry != nil {
+		feedback = &retry.feedback
+		span.SetAttributes(tracing.Int("parent_rewrite_id", retry.rewriteID))
+	}
+	prompt, err := oe.promptBuilder.BuildRetryPrompt(stage.ctx, sql, pattern, feedback)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build optimization prompt: %w", err)
+		return nil, nil, stage.fail(fmt.Errorf("failed to build optimization prompt: %w", err))
 	}
 	
 	// Step 3: Generate optimization with LLM
-	llmResponse, err := oe.generator.Complete(ctx, prompt, map[string]any{
-		"max_tokens": 2000,
-		"temperature": 0.1,
-	})
+	stage.next(metrics.StageGeneration)
+	generator := config.Current().LLM.Generator
+	usageCtx, usage := types.WithUsage(stage.ctx)
+	opts := generationOptions(generator)
+	if prompt.JSONMode {
+		opts.JSON = true
+		opts.JSONSchema = responseSchema
+	}
+	generationStarted := time.Now()
+	llmResponse, err := oe.generator.Complete(usageCtx, prompt.String(), opts)
+	cost := oe.recordUsage(stage.ctx, generator, usage)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate optimization: %w", err)
+		return nil, nil, stage.fail(fmt.Errorf("failed to generate optimization: %w", err))
 	}
+	slog.DebugContext(stage.ctx, "optimization generated", "slow_query_id", slowQueryID, "provider", generator.Provider, "model", oe.generator.Model(),
+		"duration_ms", time.Since(generationStarted).Milliseconds(), "prompt_tokens", usage.PromptTokens, "completion_tokens", usage.CompletionTokens)
 	
 	// Step 4: Parse LLM response
+	stage.next(metrics.StageParse)
 	parsedResponse, err := oe.parseLLMResponse(llmResponse)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse LLM response: %w", err)
+		return nil, nil, stage.fail(fmt.Errorf("failed to parse LLM response: %w", err))
 	}
 	
-	// Step 5: Calculate confidence score
-	confidenceScore := oe.calculateConfidenceScore(pattern, parsedResponse)
+	// Put real identifiers and redacted literals back before anything
+	// validates or stores the SQL
+	stage.next(metrics.StageValidate)
+	restoreIdentifiers(prompt.Anonymization, parsedResponse)
+	if err := restoreLiterals(prompt.Redaction, parsedResponse); err != nil {
+		return nil, nil, stage.fail(fmt.Errorf("failed to restore redacted literals: %w", err))
+	}
 	
-	// Step 6: Store optimization result
 	result := &OptimizationResult{
 		OriginalSQL:         sql,
 		OptimizedSQL:        parsedResponse.ProposedSQL,
@@ -91,22 +367,267 @@ func (oe *OptimizationEngine) OptimizeQuery(ctx context.Context, slowQueryID int
 		Rationale:           parsedResponse.Rationale,
 		ExpectedImprovement: parsedResponse.ExpectedPlanChange,
 		Caveats:             parsedResponse.Caveats,
-		ConfidenceScore:     confidenceScore,
 		Status:              "pending",
 		CreatedAt:           time.Now(),
+		Metadata: map[string]any{
+			"prompt_template": prompt.Template,
+			"prompt_hash":     prompt.TemplateHash,
+			"prompt_style":    prompt.Style,
+			"response_format": responseFormat(prompt.JSONMode),
+			"model":           oe.generator.Model(),
+		},
+		Target:               oe.db.TargetName(),
+		PromptVersion:        prompt.Version,
+		PromptTokens:         usage.PromptTokens,
+		CompletionTokens:     usage.CompletionTokens,
+		EstimatedCost:        cost,
+		Diff:                 DiffSQL(sql, parsedResponse.ProposedSQL),
+		IndexRecommendations: parsedResponse.RecommendedIndexes,
+	}
+	result.PromptText, result.RawResponse = rawExchange(prompt.String(), llmResponse)
+	semantics := compareSemantics(sql, result)
+	if prompt.Redaction != nil {
+		result.Metadata["redacted_literals"] = prompt.Redaction.Count()
 	}
+	if prompt.Anonymization != nil {
+		// The model only saw aliases, which explains odd names in its prose
+		result.Metadata["anonymized_identifiers"] = prompt.Anonymization.Count()
+	}
+	if citations := citations(prompt.Context); len(citations) > 0 {
+		result.Metadata["citations"] = citations
+	}
+	if retry != nil {
+		result.ParentRewriteID = &retry.rewriteID
+		if retry.feedback.Reason != "" {
+			result.Metadata["reviewer_feedback"] = retry.feedback.Reason
+		}
+	}
+	
+	// Step 5: EXPLAIN the proposed SQL; broken rewrites are still stored,
+	// as invalid, so the API shows why they were not offered for review
+	oe.validateRewrite(stage.ctx, result)
 	
-	// Store in database
-	err = oe.storeOptimizationResult(ctx, slowQueryID, result)
+	// Step 6: Calculate confidence score, from the plans when both were
+	// obtained
+	if result.Status != "invalid" {
+		signals := oe.analyzer.comparePlans(result.PlanOriginal, result.PlanOptimized, sql, result.OptimizedSQL)
+		if signals != nil {
+			result.Metadata["plan_signals"] = signals
+		}
+		result.ConfidenceScore, result.ConfidenceSource = oe.calculateConfidenceScore(pattern, parsedResponse, signals)
+		// However good the plan, a rewrite returning other rows needs a
+		// reviewer's look
+		if limit := config.Current().Scoring.SemanticsChangeCap; hasCritical(semantics) && result.ConfidenceScore > limit {
+			result.ConfidenceScore = limit
+			result.Metadata["confidence_capped"] = "critical semantics change"
+		}
+	}
+	
+	return result, stage, nil
+}
+
+// citations lists the documents retrieved for a prompt, once each with their
+// best score, so reviewers can check the advice the rewrite was based on
+func citations(context []rag.SearchResult) []map[string]any {
+	var out []map[string]any
+	seen := map[string]int{}
+	for _, result := range context {
+		if i, ok := seen[result.Document]; ok {
+			if result.Score > out[i]["score"].(float64) {
+				out[i]["score"] = result.Score
+			}
+			continue
+		}
+		seen[result.Document] = len(out)
+		out = append(out, map[string]any{
+			"document": result.Document,
+			"category": result.Category,
+			"url":      result.URL,
+			"score":    result.Score,
+		})
+	}
+	return out
+}
+
+// pipelineStage times one stage of OptimizeQuery, both for the stage latency
+// histogram and as a child span of the optimization trace
+type pipelineStage struct {
+	parent context.Context
+	ctx    context.Context
+	timer  *metrics.StageTimer
+	span   *tracing.Span
+}
+
+func startStage(parent context.Context, name string) *pipelineStage {
+	ctx, span := tracing.Start(parent, "optimize."+name)
+	return &pipelineStage{parent: parent, ctx: ctx, timer: metrics.StartStage(name), span: span}
+}
+
+// next ends the current stage and starts the following one
+func (s *pipelineStage) next(name string) {
+	s.done()
+	*s = *startStage(s.parent, name)
+}
+
+func (s *pipelineStage) done() {
+	s.timer.Done()
+	s.span.End()
+}
+
+// fail ends the stage as the one the optimization failed in and returns err
+func (s *pipelineStage) fail(err error) error {
+	s.span.RecordError(err)
+	s.timer.Fail()
+	s.span.End()
+	return err
+}
+
+// applyPolicy auto-rejects a stored rewrite scoring below
+// analysis.auto_reject_below_confidence, or auto-accepts one scoring at or
+// above analysis.auto_accept_above_confidence when it passed both EXPLAIN
+// validation and the equivalence check. Decisions are audited as "policy".
+func (oe *OptimizationEngine) applyPolicy(ctx context.Context, result *OptimizationResult) error {
+	decision, ok := decidePolicy(config.Current().Analysis, result)
+	if !ok {
+		return nil
+	}
+	
+	err := oe.review(database.WithActor(ctx, database.ActorPolicy), result.ID, decision.action, "", decision.reason, map[string]any{
+		"confidence_score": result.ConfidenceScore,
+		"threshold":        decision.threshold,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to store optimization result: %w", err)
+		return err
 	}
 	
-	return result, nil
+	now := time.Now()
+	result.Status = decision.status
+	result.ReviewedAt = &now
+	result.ReviewedBy, result.ReviewComment = database.ActorPolicy, decision.reason
+	return nil
+}
+
+// policyDecision is the review applyPolicy records for a rewrite
+type policyDecision struct {
+	action    string
+	status    string
+	reason    string
+	threshold float64
+}
+
+// decidePolicy returns the review the analysis policy makes of result, ok
+// false when it leaves the rewrite to reviewers
+func decidePolicy(policy config.AnalysisConfig, result *OptimizationResult) (decision policyDecision, ok bool) {
+	switch {
+	case policy.AutoRejectBelowConfidence > 0 && result.ConfidenceScore < policy.AutoRejectBelowConfidence:
+		decision = policyDecision{action: database.ActionReject, status: "rejected", threshold: policy.AutoRejectBelowConfidence}
+		decision.reason = fmt.Sprintf("confidence %.2f below analysis.auto_reject_below_confidence %.2f", result.ConfidenceScore, decision.threshold)
+	case policy.AutoAcceptAboveConfidence > 0 && result.ConfidenceScore >= policy.AutoAcceptAboveConfidence &&
+		result.ExplainPassed && result.EquivalencePassed:
+		decision = policyDecision{action: database.ActionAccept, status: "accepted", threshold: policy.AutoAcceptAboveConfidence}
+		decision.reason = fmt.Sprintf("confidence %.2f at or above analysis.auto_accept_above_confidence %.2f", result.ConfidenceScore, decision.threshold)
+	default:
+		return policyDecision{}, false
+	}
+	return decision, true
+}
+
+// restoreIdentifiers maps the aliases in every field of a response to an
+// anonymized prompt back to the real identifiers. Names the model introduced
+// itself, such as new aliases, are kept.
+func restoreIdentifiers(anonymization *safety.Anonymization, response *LLMResponse) {
+	if anonymization == nil {
+		return
+	}
+	
+	response.ProposedSQL = anonymization.Restore(response.ProposedSQL)
+	for _, field := range []*string{&response.Rationale, &response.ExpectedPlanChange, &response.Caveats} {
+		*field = anonymization.RestoreText(*field)
+	}
+	for i := range response.RecommendedIndexes {
+		index := &response.RecommendedIndexes[i]
+		index.Table = anonymization.Restore(index.Table)
+		for j := range index.Columns {
+			index.Columns[j] = anonymization.Restore(index.Columns[j])
+		}
+		index.Rationale = anonymization.RestoreText(index.Rationale)
+	}
+}
+
+// restoreLiterals substitutes the original literals into every field of a
+// response to a redacted prompt. Only the proposed SQL must resolve fully;
+// the prose fields keep placeholders the model made up.
+func restoreLiterals(redaction *safety.Redaction, response *LLMResponse) error {
+	if redaction == nil {
+		return nil
+	}
+	
+	sql, err := redaction.Restore(response.ProposedSQL)
+	if err != nil {
+		return err
+	}
+	response.ProposedSQL = sql
+	
+	for _, field := range []*string{&response.Rationale, &response.ExpectedPlanChange, &response.Caveats} {
+		if restored, err := redaction.Restore(*field); err == nil {
+			*field = restored
+		}
+	}
+	return nil
+}
+
+// Engine defaults favour short, deterministic answers; llm.generator settings
+// override them
+const (
+	defaultMaxTokens   = 2000
+	defaultTemperature = 0.1
+)
+
+// recordUsage counts the tokens of a generator call in the metrics and the
+// daily usage, whether or not the call succeeded, and returns its estimated
+// cost
+func (oe *OptimizationEngine) recordUsage(ctx context.Context, cfg config.ProviderConfig, usage *types.Usage) float64 {
+	model := oe.generator.Model()
+	input, output := cfg.Prices()
+	metrics.RecordTokens(cfg.Provider, model, usage.PromptTokens, usage.CompletionTokens, input, output)
+
+	cost := cfg.EstimateCost(usage.PromptTokens, usage.CompletionTokens)
+	if err := oe.db.RecordLLMUsage(ctx, model, usage.PromptTokens, usage.CompletionTokens, cost, time.Now()); err != nil {
+		slog.WarnContext(ctx, "LLM usage not recorded", "model", model, "error", err)
+	}
+	return cost
+}
+
+// generationOptions builds the per-request options sent with every rewrite
+func generationOptions(cfg config.ProviderConfig) types.GenerationOptions {
+	temperature := defaultTemperature
+	if cfg.Temperature != nil {
+		temperature = *cfg.Temperature
+	}
+	opts := types.GenerationOptions{
+		MaxTokens:   defaultMaxTokens,
+		Temperature: &temperature,
+		Timeout:     cfg.RequestTimeout,
+	}
+	if cfg.MaxTokens > 0 {
+		opts.MaxTokens = cfg.MaxTokens
+	}
+	return opts
 }
 
 // parseLLMResponse extracts structured information from LLM response
 func (oe *OptimizationEngine) parseLLMResponse(response string) (*LLMResponse, error) {
+	// Structured output first; the marker format stays for providers that
+	// cannot guarantee JSON and templates that still ask for it
+	var jsonErr error
+	if strings.Contains(response, `"proposed_sql"`) {
+		parsed, err := parseJSONResponse(response)
+		if err == nil {
+			return parsed, nil
+		}
+		jsonErr = err
+	}
+	
 	parsed := &LLMResponse{}
 	
 	// Extract SQL using regex - use (?s) for DOTALL mode
@@ -133,15 +654,23 @@ func (oe *OptimizationEngine) parseLLMResponse(response string) (*LLMResponse, e
 	}
 	
 	// Extract caveats
-	caveatsRegex := regexp.MustCompile(`(?s)CAVEATS:\s*(.*?)(?:\n\n|$)`)
+	caveatsRegex := regexp.MustCompile(`(?s)CAVEATS:\s*(.*?)(?:\n\n|RECOMMENDED_INDEXES:|$)`)
 	if matches := caveatsRegex.FindStringSubmatch(response); len(matches) > 1 {
 		parsed.Caveats = strings.TrimSpace(matches[1])
 		parsed.Caveats = strings.ReplaceAll(parsed.Caveats, "• ", "")
 		parsed.Caveats = strings.ReplaceAll(parsed.Caveats, "\n", " ")
 	}
 	
+	// Extract recommended indexes, one CREATE INDEX statement per line
+	if i := strings.Index(response, "RECOMMENDED_INDEXES:"); i >= 0 {
+		parsed.RecommendedIndexes = textIndexes(response[i:])
+	}
+	
 	// Validate that we extracted the essential parts
 	if parsed.ProposedSQL == "" {
+		if jsonErr != nil {
+			return nil, fmt.Errorf("failed to extract optimized SQL from LLM response: %w", jsonErr)
+		}
 		return nil, fmt.Errorf("failed to extract optimized SQL from LLM response")
 	}
 	
@@ -149,40 +678,73 @@ func (oe *OptimizationEngine) parseLLMResponse(response string) (*LLMResponse, e
 }
 
 // calculateConfidenceScore assigns a confidence score based on various factors
-func (oe *OptimizationEngine) calculateConfidenceScore(pattern QueryPattern, response *LLMResponse) float64 {
-	score := 0.5 // Base score
+// and returns where it came from. With plan signals, how the plan changed
+// replaces the length of the LLM's explanations. Weights come from the
+// scoring section of the active config.
+func (oe *OptimizationEngine) calculateConfidenceScore(pattern QueryPattern, response *LLMResponse, signals *planSignals) (float64, string) {
+	weights := config.Current().Scoring
+	score := weights.Base
 	
 	// Pattern-based confidence adjustments
 	switch pattern.Complexity {
 	case "simple":
-		score += 0.3
+		score += weights.SimpleBonus
 	case "medium":
-		score += 0.1
+		score += weights.MediumBonus
 	case "complex":
-		score -= 0.1
+		score -= weights.ComplexPenalty
 	}
 	
-	// Anti-pattern detection boosts confidence
-	if len(pattern.AntiPatterns) > 0 {
-		score += 0.2
-	}
-	
-	// Clear optimization opportunities boost confidence
-	if len(pattern.OptimizationOps) > 2 {
-		score += 0.15
+	// Rewriting a write risks changing which rows it touches
+	if pattern.IsDML() {
+		score -= weights.DMLPenalty
 	}
 	
-	// Response quality indicators
-	if len(response.Rationale) > 50 {
-		score += 0.1
+	// Anti-pattern detection boosts confidence, critical findings the most
+	// and informational ones half as much
+	switch pattern.MaxSeverity() {
+	case SeverityCritical:
+		score += weights.AntiPatternBonus + weights.CriticalBonus
+	case SeverityWarn:
+		score += weights.AntiPatternBonus
+	case SeverityInfo:
+		score += weights.AntiPatternBonus / 2
 	}
 	
-	if len(response.ExpectedPlanChange) > 50 {
-		score += 0.1
+	// Clear optimization opportunities boost confidence
+	if len(pattern.OptimizationOps) > 2 {
+		score += weights.OptimizationBonus
 	}
 	
-	if strings.Contains(strings.ToLower(response.ProposedSQL), "index") {
-		score += 0.05
+	source := ConfidenceHeuristic
+	if signals != nil {
+		source = ConfidencePlan
+		// Reading fewer rows earns up to the whole bonus, reading twice as
+		// many or more the whole penalty
+		switch {
+		case signals.RowsRatio > 1:
+			score -= weights.PlanRowsPenalty * math.Min(signals.RowsRatio-1, 1)
+		case signals.RowsRatio > 0:
+			score += weights.PlanRowsBonus * (1 - signals.RowsRatio)
+		}
+		switch {
+		case signals.FullScansRemoved > 0:
+			score += weights.FullScanBonus
+		case signals.FullScansRemoved < 0:
+			score -= weights.FullScanPenalty
+		}
+		if signals.TablesChanged {
+			score -= weights.TablesChangedPenalty
+		}
+	} else {
+		// Response quality indicators
+		if len(response.Rationale) > 50 {
+			score += weights.RationaleBonus
+		}
+		
+		if len(response.ExpectedPlanChange) > 50 {
+			score += weights.PlanChangeBonus
+		}
 	}
 	
 	// Clamp score between 0.1 and 1.0
@@ -193,26 +755,83 @@ func (oe *OptimizationEngine) calculateConfidenceScore(pattern QueryPattern, res
 		score = 0.1
 	}
 	
-	return score
+	return score, source
 }
 
 // storeOptimizationResult saves the optimization result to the database
-func (oe *OptimizationEngine) storeOptimizationResult(ctx context.Context, slowQueryID int64, result *OptimizationResult) error {
+// publishRewriteCreated notifies of the rewrite just stored, whichever of the
+// worker, the API, the CLI or a retry produced it; the notifiers apply
+// notify.min_confidence
+func (oe *OptimizationEngine) publishRewriteCreated(ctx context.Context, slowQueryID int64, result *OptimizationResult) {
+	var digest string
+	err := oe.db.QueryRowContext(ctx, `SELECT digest FROM app_slow_queries WHERE id = ?`, slowQueryID).Scan(&digest)
+	if err != nil {
+		slog.WarnContext(ctx, "rewrite notification without a digest", "rewrite_id", result.ID, "error", err)
+	}
+	notify.Publish(notify.Event{
+		Type:         notify.RewriteCreated,
+		RewriteID:    result.ID,
+		SlowQueryID:  slowQueryID,
+		Digest:       digest,
+		Confidence:   result.ConfidenceScore,
+		OriginalSQL:  result.OriginalSQL,
+		OptimizedSQL: result.OptimizedSQL,
+		Rationale:    result.Rationale,
+	})
+}
+
+func (oe *OptimizationEngine) storeOptimizationResult(ctx context.Context, slowQueryID int64, result *OptimizationResult) (err error) {
+	ctx, span := tracing.StartKind(ctx, "db.insert app_rewrites", tracing.KindClient, tracing.String("db.system", "tidb"))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	
 	// Serialize pattern as JSON
 	patternJSON, err := json.Marshal(result.Pattern)
 	if err != nil {
 		return fmt.Errorf("failed to serialize pattern: %w", err)
 	}
 	
+	var metadataJSON sql.NullString
+	if len(result.Metadata) > 0 {
+		data, err := json.Marshal(result.Metadata)
+		if err != nil {
+			return fmt.Errorf("failed to serialize metadata: %w", err)
+		}
+		metadataJSON = sql.NullString{String: string(data), Valid: true}
+	}
+	
+	var diffJSON sql.NullString
+	if result.Diff != nil {
+		data, err := json.Marshal(result.Diff)
+		if err != nil {
+			return fmt.Errorf("failed to serialize diff: %w", err)
+		}
+		diffJSON = sql.NullString{String: string(data), Valid: true}
+	}
+	
+	planOriginal, err := planJSON(result.PlanOriginal)
+	if err != nil {
+		return err
+	}
+	planOptimized, err := planJSON(result.PlanOptimized)
+	if err != nil {
+		return err
+	}
+	
 	query := `
 		INSERT INTO app_rewrites (
 			slow_query_id, original_sql, optimized_sql, pattern_analysis,
-			rationale, expected_improvement, caveats, confidence_score,
-			status, created_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			rationale, expected_improvement, caveats, confidence_score, confidence_source,
+			status, created_at, metadata, sql_diff, validation_error,
+			plan_original, plan_optimized, max_severity,
+			prompt_tokens, completion_tokens, estimated_cost, prompt_version, parent_rewrite_id,
+			prompt_text, raw_response
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 	
-	res, err := oe.db.Exec(query,
+	res, err := oe.db.ExecContext(ctx, query,
 		slowQueryID,
 		result.OriginalSQL,
 		result.OptimizedSQL,
@@ -221,8 +840,22 @@ func (oe *OptimizationEngine) storeOptimizationResult(ctx context.Context, slowQ
 		result.ExpectedImprovement,
 		result.Caveats,
 		result.ConfidenceScore,
+		sql.NullString{String: result.ConfidenceSource, Valid: result.ConfidenceSource != ""},
 		result.Status,
 		result.CreatedAt,
+		metadataJSON,
+		diffJSON,
+		sql.NullString{String: result.ValidationError, Valid: result.ValidationError != ""},
+		planOriginal,
+		planOptimized,
+		SeverityRank(result.Pattern.MaxSeverity()),
+		result.PromptTokens,
+		result.CompletionTokens,
+		result.EstimatedCost,
+		result.PromptVersion,
+		result.ParentRewriteID,
+		sql.NullString{String: result.PromptText, Valid: result.PromptText != ""},
+		sql.NullString{String: result.RawResponse, Valid: result.RawResponse != ""},
 	)
 	
 	if err != nil {
@@ -235,29 +868,45 @@ func (oe *OptimizationEngine) storeOptimizationResult(ctx context.Context, slowQ
 	}
 	
 	result.ID = id
+	result.SlowQueryID = slowQueryID
+	
+	if err := oe.db.SaveIndexRecommendations(ctx, id, result.IndexRecommendations); err != nil {
+		return err
+	}
 	return nil
 }
 
+// rewriteTargetColumn selects the target of the slow query of a rewrite of
+// app_rewrites
+const rewriteTargetColumn = `COALESCE((SELECT s.target FROM app_slow_queries s WHERE s.id = app_rewrites.slow_query_id), '')`
+
 // GetOptimizationByID retrieves an optimization result by ID
 func (oe *OptimizationEngine) GetOptimizationByID(ctx context.Context, id int64) (*OptimizationResult, error) {
 	query := `
 		SELECT id, slow_query_id, original_sql, optimized_sql, pattern_analysis,
-			   rationale, expected_improvement, caveats, confidence_score,
-			   status, created_at, reviewed_at
+			   rationale, expected_improvement, caveats, confidence_score, COALESCE(confidence_source, ''),
+			   status, created_at, reviewed_at, COALESCE(reviewed_by, ''), COALESCE(review_comment, ''),
+			   COALESCE(metadata, '{}'), sql_diff,
+			   COALESCE(validation_error, ''), plan_original, plan_optimized,
+			   COALESCE(prompt_tokens, 0), COALESCE(completion_tokens, 0), COALESCE(estimated_cost, 0),
+			   prompt_version, parent_rewrite_id, ` + rewriteTargetColumn + `
 		FROM app_rewrites
 		WHERE id = ?
 	`
 	
-	row := oe.db.QueryRow(query, id)
+	row := oe.db.QueryRowContext(ctx, query, id)
 	
 	var result OptimizationResult
 	var patternJSON string
-	var slowQueryID int64
 	var reviewedAt sql.NullTime
+	var parentRewriteID sql.NullInt64
+	var metadataJSON string
+	var diffJSON sql.NullString
+	var planOriginal, planOptimized sql.NullString
 	
 	err := row.Scan(
 		&result.ID,
-		&slowQueryID,
+		&result.SlowQueryID,
 		&result.OriginalSQL,
 		&result.OptimizedSQL,
 		&patternJSON,
@@ -265,14 +914,28 @@ func (oe *OptimizationEngine) GetOptimizationByID(ctx context.Context, id int64)
 		&result.ExpectedImprovement,
 		&result.Caveats,
 		&result.ConfidenceScore,
+		&result.ConfidenceSource,
 		&result.Status,
 		&result.CreatedAt,
 		&reviewedAt,
+		&result.ReviewedBy,
+		&result.ReviewComment,
+		&metadataJSON,
+		&diffJSON,
+		&result.ValidationError,
+		&planOriginal,
+		&planOptimized,
+		&result.PromptTokens,
+		&result.CompletionTokens,
+		&result.EstimatedCost,
+		&result.PromptVersion,
+		&parentRewriteID,
+		&result.Target,
 	)
 	
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("optimization result not found")
+			return nil, ErrNotFound
 		}
 		return nil, fmt.Errorf("failed to scan optimization result: %w", err)
 	}
@@ -282,29 +945,85 @@ func (oe *OptimizationEngine) GetOptimizationByID(ctx context.Context, id int64)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse pattern JSON: %w", err)
 	}
+	if err := json.Unmarshal([]byte(metadataJSON), &result.Metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata JSON: %w", err)
+	}
+	if err := result.loadDiff(diffJSON); err != nil {
+		return nil, err
+	}
+	if err := result.loadPlans(planOriginal, planOptimized); err != nil {
+		return nil, err
+	}
 	
 	if reviewedAt.Valid {
 		result.ReviewedAt = &reviewedAt.Time
 	}
+	if parentRewriteID.Valid {
+		result.ParentRewriteID = &parentRewriteID.Int64
+	}
+	
+	if result.Realized, err = oe.getRealized(ctx, id); err != nil {
+		return nil, err
+	}
+	if result.Benchmark, err = oe.getBenchmark(ctx, id); err != nil {
+		return nil, err
+	}
+	
+	indexes, err := oe.db.ListIndexRecommendations(ctx, id, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(indexes) > 0 {
+		result.IndexRecommendations = indexes
+	}
 	
 	return &result, nil
 }
 
+// RewriteStatuses are the statuses a stored optimization result can have
+var RewriteStatuses = []string{"pending", "accepted", "rejected", "suppressed", "invalid"}
+
+// RewriteSorts are the orders ListOptimizations accepts besides the default
+var RewriteSorts = []string{RewriteSortSeverity}
+
+// RewriteSortSeverity lists rewrites with the most serious findings first
+const RewriteSortSeverity = "severity"
+
 // ListPendingOptimizations retrieves all pending optimization results
-func (oe *OptimizationEngine) ListPendingOptimizations(ctx context.Context, limit int) ([]OptimizationResult, error) {
+func (oe *OptimizationEngine) ListPendingOptimizations(ctx context.Context, sort string, limit int) ([]OptimizationResult, error) {
+	return oe.ListOptimizations(ctx, "pending", sort, "", limit)
+}
+
+// ListOptimizations retrieves the optimization results with the given status,
+// highest confidence first for pending ones and most recently reviewed first
+// otherwise. With RewriteSortSeverity, rewrites whose pattern has the most
+// serious finding come first. A target other than "" only lists the
+// rewrites of its slow queries.
+func (oe *OptimizationEngine) ListOptimizations(ctx context.Context, status, sort, target string, limit int) ([]OptimizationResult, error) {
+	order := "confidence_score DESC, created_at DESC"
+	if status != "pending" {
+		order = "reviewed_at DESC, id DESC"
+	}
+	if sort == RewriteSortSeverity {
+		order = "max_severity DESC, " + order
+	}
 	query := `
 		SELECT id, slow_query_id, original_sql, optimized_sql, pattern_analysis,
-			   rationale, expected_improvement, caveats, confidence_score,
-			   status, created_at, reviewed_at
+			   rationale, expected_improvement, caveats, confidence_score, COALESCE(confidence_source, ''),
+			   status, created_at, reviewed_at, COALESCE(reviewed_by, ''), COALESCE(review_comment, ''),
+			   COALESCE(metadata, '{}'), sql_diff,
+			   COALESCE(validation_error, ''), plan_original, plan_optimized,
+			   COALESCE(prompt_tokens, 0), COALESCE(completion_tokens, 0), COALESCE(estimated_cost, 0),
+			   prompt_version, parent_rewrite_id, ` + rewriteTargetColumn + `
 		FROM app_rewrites
-		WHERE status = 'pending'
-		ORDER BY confidence_score DESC, created_at DESC
+		WHERE status = ? AND (? = '' OR slow_query_id IN (SELECT id FROM app_slow_queries WHERE target = ?))
+		ORDER BY ` + order + `
 		LIMIT ?
 	`
 	
-	rows, err := oe.db.Query(query, limit)
+	rows, err := oe.db.QueryContext(ctx, query, status, target, target, limit)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query pending optimizations: %w", err)
+		return nil, fmt.Errorf("failed to query %s optimizations: %w", status, err)
 	}
 	defer rows.Close()
 	
@@ -312,12 +1031,15 @@ func (oe *OptimizationEngine) ListPendingOptimizations(ctx context.Context, limi
 	for rows.Next() {
 		var result OptimizationResult
 		var patternJSON string
-		var slowQueryID int64
 		var reviewedAt sql.NullTime
+		var parentRewriteID sql.NullInt64
+	var metadataJSON string
+		var diffJSON sql.NullString
+		var planOriginal, planOptimized sql.NullString
 		
 		err := rows.Scan(
 			&result.ID,
-			&slowQueryID,
+			&result.SlowQueryID,
 			&result.OriginalSQL,
 			&result.OptimizedSQL,
 			&patternJSON,
@@ -325,9 +1047,23 @@ func (oe *OptimizationEngine) ListPendingOptimizations(ctx context.Context, limi
 			&result.ExpectedImprovement,
 			&result.Caveats,
 			&result.ConfidenceScore,
+			&result.ConfidenceSource,
 			&result.Status,
 			&result.CreatedAt,
 			&reviewedAt,
+			&result.ReviewedBy,
+			&result.ReviewComment,
+			&metadataJSON,
+			&diffJSON,
+			&result.ValidationError,
+			&planOriginal,
+			&planOptimized,
+			&result.PromptTokens,
+			&result.CompletionTokens,
+			&result.EstimatedCost,
+			&result.PromptVersion,
+			&parentRewriteID,
+			&result.Target,
 		)
 		
 		if err != nil {
@@ -339,63 +1075,327 @@ func (oe *OptimizationEngine) ListPendingOptimizations(ctx context.Context, limi
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse pattern JSON: %w", err)
 		}
+		if err := json.Unmarshal([]byte(metadataJSON), &result.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to parse metadata JSON: %w", err)
+		}
+		if err := result.loadDiff(diffJSON); err != nil {
+			return nil, err
+		}
+		if err := result.loadPlans(planOriginal, planOptimized); err != nil {
+			return nil, err
+		}
 		
 		if reviewedAt.Valid {
 			result.ReviewedAt = &reviewedAt.Time
 		}
+		if parentRewriteID.Valid {
+			result.ParentRewriteID = &parentRewriteID.Int64
+		}
 		
 		results = append(results, result)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query %s optimizations: %w", status, err)
+	}
 	
 	return results, nil
 }
 
-// AcceptOptimization marks an optimization as accepted
-func (oe *OptimizationEngine) AcceptOptimization(ctx context.Context, id int64) error {
-	query := `
-		UPDATE app_rewrites 
-		SET status = 'accepted', reviewed_at = NOW() 
-		WHERE id = ? AND status = 'pending'
-	`
-	
-	result, err := oe.db.Exec(query, id)
+// RewriteSummary identifies a rewrite of a slow query and its review status
+type RewriteSummary struct {
+	ID              int64      `json:"id"`
+	Status          string     `json:"status"`
+	ConfidenceScore float64    `json:"confidence_score"`
+	CreatedAt       time.Time  `json:"created_at"`
+	ReviewedAt      *time.Time `json:"reviewed_at,omitempty"`
+	ReviewedBy      string     `json:"reviewed_by,omitempty"`
+	ReviewComment   string     `json:"review_comment,omitempty"`
+	ParentRewriteID *int64     `json:"parent_rewrite_id,omitempty"`
+	PromptVersion   string     `json:"prompt_version,omitempty"`
+}
+
+// ListSlowQueryRewrites returns the rewrites proposed for slow query
+// slowQueryID, and the best rewrite of its digest when another occurrence
+// was analyzed, most recent first
+func (oe *OptimizationEngine) ListSlowQueryRewrites(ctx context.Context, slowQueryID int64, bestRewriteID *int64) ([]RewriteSummary, error) {
+	var best int64
+	if bestRewriteID != nil {
+		best = *bestRewriteID
+	}
+	rewrites, err := oe.queryRewriteSummaries(ctx, "WHERE slow_query_id = ? OR id = ? ORDER BY created_at DESC, id DESC", slowQueryID, best)
 	if err != nil {
-		return fmt.Errorf("failed to accept optimization: %w", err)
+		return nil, fmt.Errorf("failed to query the rewrites of slow query %d: %w", slowQueryID, err)
 	}
-	
-	rowsAffected, err := result.RowsAffected()
+	return rewrites, nil
+}
+
+// queryRewriteSummaries returns the rewrites selected by the WHERE and
+// ORDER BY clauses in where
+func (oe *OptimizationEngine) queryRewriteSummaries(ctx context.Context, where string, args ...any) ([]RewriteSummary, error) {
+	rows, err := oe.db.QueryContext(ctx, `
+		SELECT id, status, confidence_score, created_at, reviewed_at,
+		       COALESCE(reviewed_by, ''), COALESCE(review_comment, ''), parent_rewrite_id, prompt_version
+		FROM app_rewrites `+where, args...)
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		return nil, fmt.Errorf("failed to query rewrites: %w", err)
 	}
-	
-	if rowsAffected == 0 {
-		return fmt.Errorf("optimization not found or already reviewed")
+	defer rows.Close()
+
+	rewrites := []RewriteSummary{}
+	for rows.Next() {
+		var r RewriteSummary
+		var reviewedAt sql.NullTime
+		var parentRewriteID sql.NullInt64
+		if err := rows.Scan(&r.ID, &r.Status, &r.ConfidenceScore, &r.CreatedAt, &reviewedAt,
+			&r.ReviewedBy, &r.ReviewComment, &parentRewriteID, &r.PromptVersion); err != nil {
+			return nil, fmt.Errorf("failed to scan rewrite: %w", err)
+		}
+		if reviewedAt.Valid {
+			r.ReviewedAt = &reviewedAt.Time
+		}
+		if parentRewriteID.Valid {
+			r.ParentRewriteID = &parentRewriteID.Int64
+		}
+		rewrites = append(rewrites, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query rewrites: %w", err)
+	}
+	return rewrites, nil
+}
+
+// CountPendingOptimizations returns how many rewrites are awaiting review
+func (oe *OptimizationEngine) CountPendingOptimizations(ctx context.Context) (int, error) {
+	var count int
+	err := oe.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM app_rewrites WHERE status = 'pending'").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count pending optimizations: %w", err)
+	}
+	return count, nil
+}
+
+// loadDiff decodes the stored diff, computing it for rewrites stored before
+// the sql_diff column existed, and checks the rewrite's semantics changes,
+// which are not stored
+func (r *OptimizationResult) loadDiff(data sql.NullString) error {
+	r.SemanticsChanges = semanticsLines(checkSemantics(r.OriginalSQL, r.OptimizedSQL))
+	if !data.Valid {
+		r.Diff = DiffSQL(r.OriginalSQL, r.OptimizedSQL)
+		return nil
+	}
+	r.Diff = &SQLDiff{}
+	if err := json.Unmarshal([]byte(data.String), r.Diff); err != nil {
+		return fmt.Errorf("failed to parse diff JSON: %w", err)
+	}
+	return nil
+}
+
+// PreviewPrompt assembles the prompt OptimizeQuery would send for sql, the
+// sample of slow query slowQueryID or ad-hoc SQL when slowQueryID is 0, on
+// the engine of its target, without calling the generator
+func (oe *OptimizationEngine) PreviewPrompt(ctx context.Context, slowQueryID int64, sql string) (*Prompt, error) {
+	return oe.previewPrompt(ctx, slowQueryID, sql, nil)
+}
+
+func (oe *OptimizationEngine) previewPrompt(ctx context.Context, slowQueryID int64, sql string, retry *retryOf) (*Prompt, error) {
+	engine, err := oe.forSlowQuery(ctx, slowQueryID)
+	if err != nil {
+		return nil, err
+	}
+	pattern := engine.analyzeQuery(ctx, slowQueryID, sql)
+	var feedback *Feedback
+	if retry != nil {
+		feedback = &retry.feedback
+	}
+	return engine.promptBuilder.BuildRetryPrompt(ctx, sql, pattern, feedback)
+}
+
+// PreviewRetrieval returns the search query and documentation the prompt
+// of PreviewPrompt would include
+func (oe *OptimizationEngine) PreviewRetrieval(ctx context.Context, slowQueryID int64, sql string) (string, []rag.SearchResult, error) {
+	engine, err := oe.forSlowQuery(ctx, slowQueryID)
+	if err != nil {
+		return "", nil, err
+	}
+	return engine.promptBuilder.RetrieveContext(ctx, engine.analyzeQuery(ctx, slowQueryID, sql))
+}
+
+// analyzeQuery analyzes sql, reading the LIKE patterns of a statement with
+// ? parameters from an occurrence of its digest captured with literals,
+// expands the views it reads and adds the plan captured for slow query
+// slowQueryID
+func (oe *OptimizationEngine) analyzeQuery(ctx context.Context, slowQueryID int64, sql string) QueryPattern {
+	pattern := oe.analyzer.AnalyzeQuery(sql)
+	if pattern.Placeholders > 0 && slowQueryID > 0 {
+		if sample := oe.literalSample(ctx, slowQueryID); sample != "" {
+			pattern = oe.analyzer.AnalyzeQueryWithSample(sql, sample)
+		}
+	}
+	oe.promptBuilder.ExpandViews(ctx, &pattern)
+	if slowQueryID > 0 {
+		pattern.ActualPlan = oe.actualPlan(ctx, slowQueryID)
+	}
+	return pattern
+}
+
+// actualPlan returns the EXPLAIN ANALYZE output captured for slow query
+// slowQueryID, "" when none was
+func (oe *OptimizationEngine) actualPlan(ctx context.Context, slowQueryID int64) string {
+	var plan sql.NullString
+	err := oe.db.QueryRowContext(ctx, `SELECT actual_plan FROM app_slow_queries WHERE id = ?`, slowQueryID).Scan(&plan)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.WarnContext(ctx, "failed to load the captured plan of the query", "slow_query_id", slowQueryID, "error", err)
+	}
+	return plan.String
+}
+
+// literalSample returns the latest other occurrence of the digest of slow
+// query slowQueryID in its target captured without ? parameters, "" when
+// there is none
+func (oe *OptimizationEngine) literalSample(ctx context.Context, slowQueryID int64) string {
+	var sample string
+	err := oe.db.QueryRowContext(ctx, `
+		SELECT s.sample_sql FROM app_slow_queries s
+		JOIN app_slow_queries q ON q.digest = s.digest AND q.target = s.target
+		WHERE q.id = ? AND s.id <> q.id AND INSTR(s.sample_sql, '?') = 0
+		ORDER BY s.started_at DESC
+		LIMIT 1`, slowQueryID).Scan(&sample)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.WarnContext(ctx, "failed to load a sample of the query", "slow_query_id", slowQueryID, "error", err)
+	}
+	return sample
+}
+
+// ErrNotFound is returned when a rewrite does not exist
+var ErrNotFound = errors.New("optimization result not found")
+
+// ErrNotPending is returned when reviewing a rewrite that was already
+// reviewed
+var ErrNotPending = errors.New("optimization already reviewed")
+
+// ErrReasonRequired is returned when rejecting a rewrite without a comment
+var ErrReasonRequired = errors.New("a reason is required to reject a rewrite")
+
+// MaxReviewerLength matches app_rewrites.reviewed_by
+const MaxReviewerLength = 128
+
+// ValidateReviewer checks a reviewer name given by a caller: at most
+// MaxReviewerLength characters and no control characters, so the name
+// stays one line in the audit log and the exports
+func ValidateReviewer(reviewer string) error {
+	if utf8.RuneCountInString(reviewer) > MaxReviewerLength {
+		return fmt.Errorf("reviewer must be at most %d characters", MaxReviewerLength)
+	}
+	if strings.IndexFunc(reviewer, unicode.IsControl) >= 0 {
+		return errors.New("reviewer must not contain control characters or newlines")
 	}
-	
 	return nil
 }
 
-// RejectOptimization marks an optimization as rejected
-func (oe *OptimizationEngine) RejectOptimization(ctx context.Context, id int64) error {
+// AcceptOptimization marks a pending optimization as accepted by reviewer,
+// with an optional comment. The decision is audited under the actor
+// carried by ctx, which is also the reviewer when reviewer is empty.
+func (oe *OptimizationEngine) AcceptOptimization(ctx context.Context, id int64, reviewer, comment string) error {
+	return oe.review(ctx, id, database.ActionAccept, reviewer, comment, nil)
+}
+
+// RejectOptimization marks a pending optimization as rejected by reviewer,
+// like AcceptOptimization, except that comment is required
+func (oe *OptimizationEngine) RejectOptimization(ctx context.Context, id int64, reviewer, comment string) error {
+	if strings.TrimSpace(comment) == "" {
+		return ErrReasonRequired
+	}
+	return oe.review(ctx, id, database.ActionReject, reviewer, comment, nil)
+}
+
+// AcceptWithReason is AcceptOptimization reviewed by the actor of ctx
+func (oe *OptimizationEngine) AcceptWithReason(ctx context.Context, id int64, reason string) error {
+	return oe.AcceptOptimization(ctx, id, "", reason)
+}
+
+// RejectWithReason is RejectOptimization reviewed by the actor of ctx
+func (oe *OptimizationEngine) RejectWithReason(ctx context.Context, id int64, reason string) error {
+	return oe.RejectOptimization(ctx, id, "", reason)
+}
+
+// review changes the status of a pending rewrite, recording its reviewer
+// and comment, and writes the audit entry in the same transaction, so
+// neither is kept without the other
+func (oe *OptimizationEngine) review(ctx context.Context, id int64, action, reviewer, comment string, details map[string]any) (err error) {
+	ctx, span := tracing.StartKind(ctx, "db.review app_rewrites", tracing.KindClient,
+		tracing.String("db.system", "tidb"), tracing.String("review.action", action), tracing.Int("rewrite_id", id))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	
+	status := map[string]string{
+		database.ActionAccept: "accepted",
+		database.ActionReject: "rejected",
+	}[action]
+	
+	actor := database.ActorFromContext(ctx)
+	reviewer = strings.TrimSpace(reviewer)
+	if reviewer == "" {
+		reviewer = actor
+	}
+	if err := ValidateReviewer(reviewer); err != nil {
+		return err
+	}
+	comment = strings.TrimSpace(comment)
+	// A reviewer named by the request is not who the audit log attributes
+	// the decision to, so it is kept with it
+	if reviewer != actor {
+		if details == nil {
+			details = map[string]any{}
+		}
+		details["reviewer"] = reviewer
+	}
+	
+	tx, err := oe.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin review: %w", err)
+	}
+	defer tx.Rollback()
+	
+	var slowQueryID int64
+	var current string
+	err = tx.QueryRowContext(ctx,
+		"SELECT slow_query_id, status FROM app_rewrites WHERE id = ? FOR UPDATE", id).Scan(&slowQueryID, &current)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load optimization: %w", err)
+	}
+	if current != "pending" {
+		return ErrNotPending
+	}
+	
 	query := `
 		UPDATE app_rewrites 
-		SET status = 'rejected', reviewed_at = NOW() 
+		SET status = ?, reviewed_at = NOW(), reviewed_by = ?, review_comment = NULLIF(?, '')
 		WHERE id = ? AND status = 'pending'
 	`
-	
-	result, err := oe.db.Exec(query, id)
-	if err != nil {
-		return fmt.Errorf("failed to reject optimization: %w", err)
+	if _, err := tx.ExecContext(ctx, query, status, reviewer, comment, id); err != nil {
+		return fmt.Errorf("failed to %s optimization: %w", action, err)
 	}
 	
-	rowsAffected, err := result.RowsAffected()
+	err = database.RecordAuditTx(ctx, tx, database.AuditEntry{
+		Action:      action,
+		RewriteID:   id,
+		SlowQueryID: slowQueryID,
+		Reason:      comment,
+		Details:     details,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		return err
 	}
 	
-	if rowsAffected == 0 {
-		return fmt.Errorf("optimization not found or already reviewed")
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit review: %w", err)
 	}
 	
+	metrics.RecordReview(action, actor)
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,295 @@
+package analyze
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/config/configtest"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/llm/generate"
+	"github.com/matthieukhl/latentia/internal/notify"
+)
+
+func TestDecidePolicyAutoAcceptsEquivalentRewrite(t *testing.T) {
+	policy := config.AnalysisConfig{AutoAcceptAboveConfidence: 0.9}
+	sql := "SELECT id, total FROM orders WHERE DATE(created_at) = '2024-01-01'"
+	result := &OptimizationResult{
+		OptimizedSQL:    "SELECT id, total FROM orders WHERE created_at >= '2024-01-01' AND created_at < '2024-01-02'",
+		ConfidenceScore: 0.95,
+		ExplainPassed:   true,
+	}
+
+	compareSemantics(sql, result)
+	if !result.EquivalencePassed {
+		t.Fatalf("EquivalencePassed = false, semantics changes: %v", result.SemanticsChanges)
+	}
+	decision, ok := decidePolicy(policy, result)
+	if !ok {
+		t.Fatal("decidePolicy left a qualifying rewrite to reviewers")
+	}
+	if decision.action != database.ActionAccept || decision.status != "accepted" {
+		t.Errorf("decision = %s/%s, want %s/accepted", decision.action, decision.status, database.ActionAccept)
+	}
+	if decision.threshold != 0.9 {
+		t.Errorf("threshold = %v, want 0.9", decision.threshold)
+	}
+}
+
+func TestDecidePolicyEquivalenceGate(t *testing.T) {
+	policy := config.AnalysisConfig{AutoAcceptAboveConfidence: 0.9}
+	sql := "SELECT o.id, o.total FROM orders o WHERE o.status = 'paid' ORDER BY o.id"
+	const (
+		thresholdSQL = "SELECT id FROM orders WHERE total > 100"
+		orSQL        = "SELECT id FROM orders WHERE customer_id = 1 OR status = 'paid'"
+		inSQL        = "SELECT id FROM orders WHERE status IN ('paid', 'shipped')"
+		unionSQL     = "SELECT id FROM orders WHERE status = 'paid' UNION ALL SELECT id FROM archived_orders WHERE status = 'paid'"
+		havingSQL    = "SELECT customer_id, COUNT(*) FROM orders GROUP BY customer_id HAVING COUNT(*) > 5"
+		joinSQL      = "SELECT o.id, c.email FROM orders o LEFT JOIN customers c ON c.id = o.customer_id WHERE o.status = 'paid'"
+		innerSQL     = "SELECT o.id, c.email FROM orders o JOIN customers c ON c.id = o.customer_id WHERE c.city = 'Paris'"
+	)
+	tests := []struct {
+		name string
+		// original is the statement rewritten, sql when empty
+		original   string
+		optimized  string
+		equivalent bool
+	}{
+		{"clean rewrite", "", "SELECT o.id, o.total FROM orders o USE INDEX (idx_status) WHERE o.status = 'paid' ORDER BY o.id", true},
+		{"limit added", "", "SELECT o.id, o.total FROM orders o WHERE o.status = 'paid' ORDER BY o.id LIMIT 100", false},
+		{"column dropped", "", "SELECT o.id FROM orders o WHERE o.status = 'paid' ORDER BY o.id", false},
+		{"predicate added", "", "SELECT o.id, o.total FROM orders o WHERE o.status = 'paid' AND o.total > 0 ORDER BY o.id", false},
+		{"predicate removed", "", "SELECT o.id, o.total FROM orders o ORDER BY o.id", false},
+		{"operands swapped", "", "SELECT o.id, o.total FROM orders o WHERE 'paid' = o.status ORDER BY o.id", true},
+		{"literal changed", "", "SELECT o.id, o.total FROM orders o WHERE o.status = 'pending' ORDER BY o.id", false},
+		{"column changed", "", "SELECT o.id, o.total FROM orders o WHERE o.state = 'paid' ORDER BY o.id", false},
+		{"threshold changed", thresholdSQL, "SELECT id FROM orders WHERE total > 1000", false},
+		{"operator changed", thresholdSQL, "SELECT id FROM orders WHERE total >= 100", false},
+		{"OR made an AND", orSQL, "SELECT id FROM orders WHERE customer_id = 1 AND status = 'paid'", false},
+		{"OR reordered", orSQL, "SELECT id FROM orders WHERE (status = 'paid' OR 1 = customer_id)", true},
+		{"OR values swapped", "SELECT id FROM orders WHERE customer_id = 1 OR total = 2", "SELECT id FROM orders WHERE customer_id = 2 OR total = 1", false},
+		{"IN list reordered", inSQL, "SELECT id FROM orders WHERE status IN ('shipped', 'paid')", true},
+		{"IN list changed", inSQL, "SELECT id FROM orders WHERE status IN ('paid', 'pending')", false},
+		{"DISTINCT added", "", "SELECT DISTINCT o.id, o.total FROM orders o WHERE o.status = 'paid' ORDER BY o.id", false},
+		{"DISTINCT removed", "SELECT DISTINCT customer_id FROM orders", "SELECT customer_id FROM orders", false},
+		{"UNION ALL made UNION", unionSQL, "SELECT id FROM orders WHERE status = 'paid' UNION SELECT id FROM archived_orders WHERE status = 'paid'", false},
+		{"UNION branch dropped", unionSQL, "SELECT id FROM orders WHERE status = 'paid'", false},
+		{"HAVING changed", havingSQL, "SELECT customer_id, COUNT(*) FROM orders GROUP BY customer_id HAVING COUNT(*) > 50", false},
+		{"HAVING removed", havingSQL, "SELECT customer_id, COUNT(*) FROM orders GROUP BY customer_id", false},
+		{"LEFT JOIN made INNER", joinSQL, "SELECT o.id, c.email FROM orders o INNER JOIN customers c ON c.id = o.customer_id WHERE o.status = 'paid'", false},
+		{"YEAR made a range", "SELECT id FROM orders WHERE YEAR(created_at) = 2024", "SELECT id FROM orders WHERE created_at >= '2024-01-01' AND created_at < '2025-01-01'", true},
+		{"DATE made a wider range", "SELECT id FROM orders WHERE DATE(created_at) = '2024-01-01'", "SELECT id FROM orders WHERE created_at >= '2024-01-01' AND created_at < '2024-01-03'", false},
+		{"predicate moved into an inner join", innerSQL, "SELECT o.id, c.email FROM orders o JOIN customers c ON c.id = o.customer_id AND c.city = 'Paris'", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := tt.original
+			if original == "" {
+				original = sql
+			}
+			result := &OptimizationResult{OptimizedSQL: tt.optimized, ConfidenceScore: 0.97, ExplainPassed: true}
+			compareSemantics(original, result)
+			if result.EquivalencePassed != tt.equivalent {
+				t.Fatalf("EquivalencePassed = %v, want %v (changes %v)", result.EquivalencePassed, tt.equivalent, result.SemanticsChanges)
+			}
+			decision, ok := decidePolicy(policy, result)
+			if accepted := ok && decision.action == database.ActionAccept; accepted != tt.equivalent {
+				t.Errorf("auto-accepted = %v, want %v", accepted, tt.equivalent)
+			}
+		})
+	}
+}
+
+func TestDecidePolicyNeedsExplain(t *testing.T) {
+	policy := config.AnalysisConfig{AutoAcceptAboveConfidence: 0.9, AutoRejectBelowConfidence: 0.3}
+	tests := []struct {
+		name   string
+		result OptimizationResult
+		action string
+	}{
+		{"unexplained", OptimizationResult{ConfidenceScore: 0.99, EquivalencePassed: true}, ""},
+		{"below accept threshold", OptimizationResult{ConfidenceScore: 0.89, ExplainPassed: true, EquivalencePassed: true}, ""},
+		{"below reject threshold", OptimizationResult{ConfidenceScore: 0.2, ExplainPassed: true, EquivalencePassed: true}, database.ActionReject},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, _ := decidePolicy(policy, &tt.result)
+			if decision.action != tt.action {
+				t.Errorf("action = %q, want %q", decision.action, tt.action)
+			}
+		})
+	}
+}
+
+func TestGenerationOptions(t *testing.T) {
+	temperature := 0.4
+	tests := []struct {
+		name        string
+		cfg         config.ProviderConfig
+		maxTokens   int
+		temperature float64
+		timeout     time.Duration
+	}{
+		{"engine defaults", config.ProviderConfig{}, defaultMaxTokens, defaultTemperature, 0},
+		{"configured", config.ProviderConfig{MaxTokens: 8000, Temperature: &temperature, RequestTimeout: 3 * time.Minute}, 8000, 0.4, 3 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := generationOptions(tt.cfg)
+			if opts.MaxTokens != tt.maxTokens || opts.Temperature == nil || *opts.Temperature != tt.temperature || opts.Timeout != tt.timeout {
+				t.Errorf("generationOptions = %+v, want max_tokens %d, temperature %v, timeout %v", opts, tt.maxTokens, tt.temperature, tt.timeout)
+			}
+		})
+	}
+	// The options point at their own temperature, not the configured one
+	opts := generationOptions(config.ProviderConfig{Temperature: &temperature})
+	*opts.Temperature = 1
+	if temperature != 0.4 {
+		t.Error("generationOptions shares the configured temperature")
+	}
+}
+
+func TestProposeMockOutcomes(t *testing.T) {
+	const sql = "SELECT * FROM customers WHERE email LIKE '%john%'"
+	unsafeAnswer := strings.Replace(jsonAnswer,
+		"SELECT id FROM orders WHERE created_at >= '2024-01-01'", "DELETE FROM customers", 1)
+	tests := []struct {
+		name     string
+		outcome  generate.MockFailure
+		response string
+		// err is in the error, empty for success
+		err    string
+		target error
+		status string
+	}{
+		{"answer", generate.MockOK, jsonAnswer, "", nil, "pending"},
+		{"rate limited", generate.MockRateLimited, jsonAnswer, "failed to generate optimization", generate.ErrMockRateLimited, ""},
+		{"malformed", generate.MockMalformed, jsonAnswer, "failed to parse LLM response", nil, ""},
+		{"truncated", generate.MockTruncated, jsonAnswer, "no complete JSON object", nil, ""},
+		{"hang past request_timeout", generate.MockHang, jsonAnswer, "failed to generate optimization", context.DeadlineExceeded, ""},
+		{"unsafe rewrite", generate.MockOK, unsafeAnswer, "", nil, "invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator := generate.NewMockGenerator("test", generate.MockOptions{Script: []generate.MockFailure{tt.outcome}}).
+				Respond("customers", tt.response)
+			oe := newMetricsEngine(t, generator)
+			configtest.Load(t, strings.Replace(metricsConfig, "    model: test\n", "    model: test\n    request_timeout: 50ms\n", 1))
+
+			result, err := oe.Propose(context.Background(), sql)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Propose = %v, want an error mentioning %q", err, tt.err)
+				}
+				if tt.target != nil && !errors.Is(err, tt.target) {
+					t.Errorf("Propose = %v, want it to wrap %v", err, tt.target)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Propose: %v", err)
+			}
+			if result.Status != tt.status {
+				t.Errorf("status = %q, want %q (validation error %q)", result.Status, tt.status, result.ValidationError)
+			}
+			if generator.Calls() != 1 {
+				t.Errorf("%d generator calls, want 1", generator.Calls())
+			}
+		})
+	}
+}
+
+func TestProposeScriptedFailuresThenAnswer(t *testing.T) {
+	generator := generate.NewMockGenerator("test", generate.MockOptions{
+		Script: []generate.MockFailure{generate.MockRateLimited, generate.MockMalformed},
+	}).Respond("customers", jsonAnswer)
+	oe := newMetricsEngine(t, generator)
+
+	// Each attempt gets the next outcome of the script, then answers
+	for i, want := range []string{"failed to generate optimization", "failed to parse LLM response", ""} {
+		result, err := oe.Propose(context.Background(), "SELECT * FROM customers")
+		switch {
+		case want == "" && err != nil:
+			t.Errorf("attempt %d: %v", i+1, err)
+		case want == "" && result.OptimizedSQL != "SELECT id FROM orders WHERE created_at >= '2024-01-01'":
+			t.Errorf("attempt %d proposed %q", i+1, result.OptimizedSQL)
+		case want != "" && (err == nil || !strings.Contains(err.Error(), want)):
+			t.Errorf("attempt %d = %v, want an error mentioning %q", i+1, err, want)
+		}
+	}
+	if generator.Calls() != 3 {
+		t.Errorf("%d generator calls, want 3", generator.Calls())
+	}
+}
+
+func TestProposeCancelledDuringGeneration(t *testing.T) {
+	generator := generate.NewMockGenerator("test", generate.MockOptions{Latency: time.Minute}).Respond("customers", jsonAnswer)
+	oe := newMetricsEngine(t, generator)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		_, err := oe.Propose(ctx, "SELECT * FROM customers")
+		result <- err
+	}()
+	for generator.Calls() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "failed to generate optimization") {
+			t.Errorf("Propose = %v, want the cancellation of the generation", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Propose still waiting on the generator after cancellation")
+	}
+}
+
+func TestOptimizeQueryPublishesRewriteCreated(t *testing.T) {
+	var mu sync.Mutex
+	var events []map[string]any
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("webhook body: %v", err)
+		}
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	generator := generate.NewMockGenerator("test", generate.MockOptions{}).Respond("customers", jsonAnswer)
+	oe := newMetricsEngine(t, generator)
+	configtest.Load(t, metricsConfig+`
+notify:
+  webhook:
+    webhook_url: "`+receiver.URL+`"
+    events: [rewrite_created]
+    min_confidence: 0
+`)
+
+	result, err := oe.OptimizeQuery(context.Background(), 1, "SELECT * FROM customers WHERE email LIKE '%john%'")
+	if err != nil {
+		t.Fatalf("OptimizeQuery: %v", err)
+	}
+	notify.Flush(5 * time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 1 {
+		t.Fatalf("webhook received %d events, want 1: %v", len(events), events)
+	}
+	if events[0]["type"] != notify.RewriteCreated || events[0]["rewrite_id"] != float64(result.ID) || events[0]["slow_query_id"] != float64(1) {
+		t.Errorf("event = %v, want rewrite_created for rewrite #%d of slow query 1", events[0], result.ID)
+	}
+}
@@ -0,0 +1,319 @@
+package analyze
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/database"
+)
+
+// Export formats
+const (
+	ExportMarkdown = "md"
+	ExportSQL      = "sql"
+	ExportJSON     = "json"
+)
+
+// ExportFormats are the formats Export.Write accepts
+var ExportFormats = []string{ExportMarkdown, ExportSQL, ExportJSON}
+
+// maxExportRewrites caps the rewrites read by ExportRewrites, far above a
+// review session
+const maxExportRewrites = 5000
+
+// ExportedRewrite is the latest rewrite of a digest in an export
+type ExportedRewrite struct {
+	Digest      string   `json:"digest"`
+	DB          string   `json:"db,omitempty"`
+	Tables      []string `json:"tables"`
+	RewriteID   int64    `json:"rewrite_id"`
+	SlowQueryID int64    `json:"slow_query_id"`
+	Status      string   `json:"status"`
+
+	OriginalSQL         string `json:"original_sql"`
+	OptimizedSQL        string `json:"optimized_sql"`
+	Rationale           string `json:"rationale"`
+	ExpectedImprovement string `json:"expected_improvement"`
+	Caveats             string `json:"caveats"`
+
+	ConfidenceScore  float64 `json:"confidence_score"`
+	ConfidenceSource string  `json:"confidence_source,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	ReviewedAt *time.Time `json:"reviewed_at"`
+
+	// Reviewer is who accepted or rejected the rewrite, or for rewrites
+	// reviewed before it was recorded, the actor of the last review decision
+	// in the audit log, such as "api:alice" or "policy"
+	Reviewer string `json:"reviewer,omitempty"`
+
+	IndexRecommendations []database.IndexRecommendation `json:"index_recommendations"`
+}
+
+// Export is a report of the rewrites of one status, one per digest, to hand
+// to the team owning the queries
+type Export struct {
+	Status      string            `json:"status"`
+	Since       *time.Time        `json:"since,omitempty"`
+	GeneratedAt time.Time         `json:"generated_at"`
+	Rewrites    []ExportedRewrite `json:"rewrites"`
+}
+
+// ExportRewrites returns the latest rewrite of status of every digest,
+// reviewed, or created when it was never reviewed, at or after since; a
+// zero since exports them all. Rewrites are ordered by digest.
+func (oe *OptimizationEngine) ExportRewrites(ctx context.Context, status string, since time.Time) (*Export, error) {
+	query := `
+		SELECT r.id, r.slow_query_id, s.digest, COALESCE(s.db, ''), r.status, r.original_sql, r.optimized_sql,
+		       r.pattern_analysis, r.rationale, r.expected_improvement, r.caveats, r.confidence_score,
+		       COALESCE(r.confidence_source, ''), r.created_at, r.reviewed_at,
+		       COALESCE(r.reviewed_by, (SELECT a.actor FROM app_audit_log a
+		                 WHERE a.rewrite_id = r.id AND a.action IN (?, ?)
+		                 ORDER BY a.id DESC LIMIT 1), '')
+		FROM app_rewrites r
+		JOIN app_slow_queries s ON s.id = r.slow_query_id
+		WHERE r.status = ?`
+	args := []any{database.ActionAccept, database.ActionReject, status}
+	if !since.IsZero() {
+		query += ` AND COALESCE(r.reviewed_at, r.created_at) >= ?`
+		args = append(args, since)
+	}
+	query += `
+		ORDER BY s.digest, COALESCE(r.reviewed_at, r.created_at) DESC, r.id DESC
+		LIMIT ?`
+	args = append(args, maxExportRewrites)
+
+	rows, err := oe.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s rewrites: %w", status, err)
+	}
+	defer rows.Close()
+
+	export := &Export{Status: status, GeneratedAt: time.Now().UTC(), Rewrites: []ExportedRewrite{}}
+	if !since.IsZero() {
+		export.Since = &since
+	}
+	for rows.Next() {
+		var r ExportedRewrite
+		var patternJSON string
+		var reviewedAt sql.NullTime
+		if err := rows.Scan(&r.RewriteID, &r.SlowQueryID, &r.Digest, &r.DB, &r.Status, &r.OriginalSQL, &r.OptimizedSQL,
+			&patternJSON, &r.Rationale, &r.ExpectedImprovement, &r.Caveats, &r.ConfidenceScore,
+			&r.ConfidenceSource, &r.CreatedAt, &reviewedAt, &r.Reviewer); err != nil {
+			return nil, fmt.Errorf("failed to scan rewrite: %w", err)
+		}
+		// Rows are newest first within a digest
+		if n := len(export.Rewrites); n > 0 && export.Rewrites[n-1].Digest == r.Digest {
+			continue
+		}
+		var pattern QueryPattern
+		if err := json.Unmarshal([]byte(patternJSON), &pattern); err != nil {
+			return nil, fmt.Errorf("failed to parse the pattern of rewrite %d: %w", r.RewriteID, err)
+		}
+		r.Tables = pattern.Tables
+		if r.Tables == nil {
+			r.Tables = []string{}
+		}
+		if reviewedAt.Valid {
+			r.ReviewedAt = &reviewedAt.Time
+		}
+		export.Rewrites = append(export.Rewrites, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query %s rewrites: %w", status, err)
+	}
+	rows.Close()
+
+	for i := range export.Rewrites {
+		r := &export.Rewrites[i]
+		if r.IndexRecommendations, err = oe.db.ListIndexRecommendations(ctx, r.RewriteID, "", 0); err != nil {
+			return nil, err
+		}
+	}
+	return export, nil
+}
+
+// ExportContentType is the media type of an export in format
+func ExportContentType(format string) string {
+	switch format {
+	case ExportMarkdown:
+		return "text/markdown; charset=utf-8"
+	case ExportSQL:
+		return "application/sql; charset=utf-8"
+	}
+	return "application/json; charset=utf-8"
+}
+
+// Write renders the export in format. In the sql format the queries are
+// comments and only the CREATE INDEX statements of recommendations not
+// rejected or applied run, so the file can be applied as a migration.
+func (e *Export) Write(w io.Writer, format string) error {
+	var b strings.Builder
+	switch format {
+	case ExportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(e)
+	case ExportMarkdown:
+		e.writeMarkdown(&b)
+	case ExportSQL:
+		e.writeSQL(&b)
+	default:
+		return fmt.Errorf("invalid format '%s': must be one of %s", format, strings.Join(ExportFormats, ", "))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// heading describes the export in one line
+func (e *Export) heading() string {
+	line := fmt.Sprintf("%d %s rewrite%s", len(e.Rewrites), e.Status, pluralS(len(e.Rewrites)))
+	if e.Since != nil {
+		line += " since " + e.Since.UTC().Format(time.RFC3339)
+	}
+	return line + ", exported " + e.GeneratedAt.Format(time.RFC3339)
+}
+
+// byTable groups the rewrites by their first table, tables sorted by name
+// and rewrites without a table last
+func (e *Export) byTable() ([]string, map[string][]ExportedRewrite) {
+	groups := map[string][]ExportedRewrite{}
+	for _, r := range e.Rewrites {
+		table := ""
+		if len(r.Tables) > 0 {
+			table = r.Tables[0]
+		}
+		groups[table] = append(groups[table], r)
+	}
+	tables := make([]string, 0, len(groups))
+	for table := range groups {
+		tables = append(tables, table)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		if (tables[i] == "") != (tables[j] == "") {
+			return tables[j] == ""
+		}
+		return tables[i] < tables[j]
+	})
+	return tables, groups
+}
+
+func (e *Export) writeMarkdown(b *strings.Builder) {
+	fmt.Fprintf(b, "# Latentia %s rewrites\n\n%s.\n", e.Status, e.heading())
+	tables, groups := e.byTable()
+	for _, table := range tables {
+		if table == "" {
+			b.WriteString("\n## No table\n")
+		} else {
+			fmt.Fprintf(b, "\n## Table `%s`\n", table)
+		}
+		for _, r := range groups[table] {
+			fmt.Fprintf(b, "\n### Digest `%s`, rewrite #%d\n\n", r.Digest, r.RewriteID)
+			confidence := fmt.Sprintf("%.2f", r.ConfidenceScore)
+			if r.ConfidenceSource != "" {
+				confidence += " (" + r.ConfidenceSource + ")"
+			}
+			fmt.Fprintf(b, "- Confidence: %s\n", confidence)
+			if r.DB != "" {
+				fmt.Fprintf(b, "- Database: `%s`\n", r.DB)
+			}
+			fmt.Fprintf(b, "- Created: %s\n", r.CreatedAt.UTC().Format(time.RFC3339))
+			if r.ReviewedAt != nil {
+				reviewed := r.ReviewedAt.UTC().Format(time.RFC3339)
+				if r.Reviewer != "" {
+					reviewed += " by " + r.Reviewer
+				}
+				fmt.Fprintf(b, "- Reviewed: %s\n", reviewed)
+			}
+			fmt.Fprintf(b, "\n**Original**\n\n```sql\n%s\n```\n", strings.TrimSpace(r.OriginalSQL))
+			fmt.Fprintf(b, "\n**Rewrite**\n\n```sql\n%s\n```\n", strings.TrimSpace(r.OptimizedSQL))
+			for _, section := range []struct{ name, text string }{
+				{"Rationale", r.Rationale},
+				{"Expected improvement", r.ExpectedImprovement},
+				{"Caveats", r.Caveats},
+			} {
+				if text := strings.TrimSpace(section.text); text != "" {
+					fmt.Fprintf(b, "\n**%s**: %s\n", section.name, text)
+				}
+			}
+			if len(r.IndexRecommendations) > 0 {
+				fmt.Fprintf(b, "\n**Indexes**\n\n```sql\n")
+				for _, index := range r.IndexRecommendations {
+					comment := "-- #" + strconv.FormatInt(index.ID, 10) + " " + index.Status
+					if index.Rationale != "" {
+						comment += ": " + strings.Join(strings.Fields(index.Rationale), " ")
+					}
+					stmt, err := index.CreateSQL()
+					if err != nil {
+						fmt.Fprintf(b, "%s\n-- skipped: %v\n", comment, err)
+						continue
+					}
+					fmt.Fprintf(b, "%s\n%s;\n", comment, stmt)
+				}
+				b.WriteString("```\n")
+			}
+		}
+	}
+}
+
+func (e *Export) writeSQL(b *strings.Builder) {
+	// Every stored value goes through sqlComment, so one carrying a newline
+	// cannot start a line that runs when the file is applied
+	fmt.Fprintf(b, "-- Latentia %s rewrites\n%s", e.Status, sqlComment(e.heading()))
+	for _, r := range e.Rewrites {
+		fmt.Fprintf(b, "\n-- %s\n", strings.Repeat("=", 76))
+		b.WriteString(sqlComment(fmt.Sprintf("Digest %s, rewrite #%d, confidence %.2f", r.Digest, r.RewriteID, r.ConfidenceScore)))
+		if r.ReviewedAt != nil {
+			reviewed := r.ReviewedAt.UTC().Format(time.RFC3339)
+			if r.Reviewer != "" {
+				reviewed += " by " + r.Reviewer
+			}
+			b.WriteString(sqlComment("Reviewed " + reviewed))
+		}
+		for _, section := range []struct{ name, text string }{
+			{"Rationale", r.Rationale},
+			{"Expected improvement", r.ExpectedImprovement},
+			{"Caveats", r.Caveats},
+		} {
+			if text := strings.TrimSpace(section.text); text != "" {
+				fmt.Fprintf(b, "--\n%s", sqlComment(section.name+": "+text))
+			}
+		}
+		fmt.Fprintf(b, "--\n-- Original:\n%s", sqlComment(strings.TrimSpace(r.OriginalSQL)+";"))
+		fmt.Fprintf(b, "--\n-- Rewrite:\n%s", sqlComment(strings.TrimSpace(r.OptimizedSQL)+";"))
+		for _, index := range r.IndexRecommendations {
+			stmt, err := index.CreateSQL()
+			switch {
+			case err != nil:
+				fmt.Fprintf(b, "\n%s", sqlComment(fmt.Sprintf("Index #%d skipped: %v", index.ID, err)))
+			case index.Status == database.IndexRejected || index.Status == database.IndexApplied:
+				fmt.Fprintf(b, "\n-- Index #%d (%s)\n-- %s;\n", index.ID, index.Status, stmt)
+			default:
+				fmt.Fprintf(b, "\n-- Index #%d (%s)\n%s;\n", index.ID, index.Status, stmt)
+			}
+		}
+	}
+}
+
+// sqlComment prefixes every line of text with "-- "
+func sqlComment(text string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString(strings.TrimRight("-- "+line, " ") + "\n")
+	}
+	return b.String()
+}
+
+func pluralS(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
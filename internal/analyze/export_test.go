@@ -0,0 +1,50 @@
+package analyze
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportSQLRunsOnlyIndexes(t *testing.T) {
+	reviewed := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	export := &Export{
+		Status:      "accepted",
+		GeneratedAt: reviewed,
+		Rewrites: []ExportedRewrite{{
+			Digest:       "abc\nDROP TABLE customers;",
+			RewriteID:    7,
+			OriginalSQL:  "SELECT * FROM orders WHERE YEAR(created_at) = 2025",
+			OptimizedSQL: "SELECT * FROM orders\nWHERE created_at >= '2025-01-01' AND created_at < '2026-01-01'",
+			Rationale:    "sargable\nrange",
+			ReviewedAt:   &reviewed,
+			Reviewer:     "x\nDROP TABLE orders; --",
+		}},
+	}
+
+	var b strings.Builder
+	if err := export.Write(&b, ExportSQL); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+		if line != "" && !strings.HasPrefix(line, "--") {
+			t.Errorf("line runs when the export is applied: %q\n%s", line, b.String())
+		}
+	}
+	if !strings.Contains(b.String(), "-- DROP TABLE orders; --\n") {
+		t.Errorf("reviewer is not kept as a comment:\n%s", b.String())
+	}
+}
+
+func TestValidateReviewer(t *testing.T) {
+	for _, reviewer := range []string{"", "alice", "Zoé Dupont", strings.Repeat("a", MaxReviewerLength)} {
+		if err := ValidateReviewer(reviewer); err != nil {
+			t.Errorf("ValidateReviewer(%q) = %v, want nil", reviewer, err)
+		}
+	}
+	for _, reviewer := range []string{"x\nDROP TABLE orders; --", "x\r\n", "tab\there", "nul\x00", strings.Repeat("a", MaxReviewerLength+1)} {
+		if err := ValidateReviewer(reviewer); err == nil {
+			t.Errorf("ValidateReviewer(%q) = nil, want an error", reviewer)
+		}
+	}
+}
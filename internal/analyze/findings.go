@@ -0,0 +1,102 @@
+package analyze
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Finding severities, from least to most serious
+const (
+	SeverityInfo     = "info"
+	SeverityWarn     = "warn"
+	SeverityCritical = "critical"
+)
+
+// Finding is one detected anti-pattern with how much it matters and where
+// in the statement it was found
+type Finding struct {
+	Code        string `json:"code"`
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+	// Location is the clause the finding is in, such as WHERE
+	Location string `json:"location,omitempty"`
+}
+
+// findingKinds maps anti-pattern codes to their severity, description and
+// location. Patterns stored with only anti_patterns get their findings from
+// this table when read back.
+var findingKinds = map[string]Finding{
+	"missing-where":              {Severity: SeverityCritical, Description: "UPDATE or DELETE without WHERE changes every row", Location: "WHERE"},
+	"cartesian-join":             {Severity: SeverityCritical, Description: "a join without a condition multiplies the rows of both sides", Location: "FROM"},
+	"implicit-conversion":        {Severity: SeverityWarn, Description: "a string column compared to a number is converted on every row", Location: "condition"},
+	"function-on-indexed-column": {Severity: SeverityWarn, Description: "a function on a compared column prevents index use", Location: "condition"},
+	"function-in-where":          {Severity: SeverityWarn, Description: "a function in WHERE may prevent index use", Location: "WHERE"},
+	"or-across-columns":          {Severity: SeverityWarn, Description: "OR across different columns cannot use a single index range", Location: "condition"},
+	"leading-wildcard-like":      {Severity: SeverityWarn, Description: "LIKE with a leading wildcard cannot use an index", Location: "WHERE"},
+	"subquery-instead-of-join":   {Severity: SeverityWarn, Description: "an IN subquery may be cheaper as a join", Location: "WHERE"},
+	"select-star":                {Severity: SeverityInfo, Description: "SELECT * reads every column", Location: "SELECT"},
+	"missing-limit":              {Severity: SeverityInfo, Description: "a potentially large result set has no LIMIT"},
+	"order-without-limit":        {Severity: SeverityInfo, Description: "ORDER BY without LIMIT sorts the whole result", Location: "ORDER BY"},
+}
+
+// newFinding describes an anti-pattern code; unknown codes are warnings
+func newFinding(code string) Finding {
+	finding, ok := findingKinds[code]
+	if !ok {
+		finding = Finding{Severity: SeverityWarn, Description: code}
+	}
+	finding.Code = code
+	return finding
+}
+
+// findingsFor describes the anti-patterns of a pattern, naming the filter
+// functions in function-on-indexed-column
+func findingsFor(antiPatterns, filterFunctions []string) []Finding {
+	findings := make([]Finding, 0, len(antiPatterns))
+	for _, code := range antiPatterns {
+		finding := newFinding(code)
+		if code == "function-on-indexed-column" && len(filterFunctions) > 0 {
+			finding.Description = strings.Join(filterFunctions, "(), ") + "() on a compared column prevents index use"
+		}
+		findings = append(findings, finding)
+	}
+	return findings
+}
+
+// SeverityRank orders severities: 0 for none or unknown, 3 for critical
+func SeverityRank(severity string) int {
+	switch severity {
+	case SeverityInfo:
+		return 1
+	case SeverityWarn:
+		return 2
+	case SeverityCritical:
+		return 3
+	}
+	return 0
+}
+
+// MaxSeverity returns the most serious severity among the findings, "" when
+// there are none
+func (p QueryPattern) MaxSeverity() string {
+	highest := ""
+	for _, finding := range p.Findings {
+		if SeverityRank(finding.Severity) > SeverityRank(highest) {
+			highest = finding.Severity
+		}
+	}
+	return highest
+}
+
+// UnmarshalJSON reads a stored pattern. Patterns stored before findings
+// existed have their findings derived from anti_patterns.
+func (p *QueryPattern) UnmarshalJSON(data []byte) error {
+	type plain QueryPattern
+	if err := json.Unmarshal(data, (*plain)(p)); err != nil {
+		return err
+	}
+	if p.Findings == nil && len(p.AntiPatterns) > 0 {
+		p.Findings = findingsFor(p.AntiPatterns, p.FilterFunctions)
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+package analyze
+
+import (
+	"strings"
+)
+
+// sqlToken is one lexical token of a statement
+type sqlToken struct {
+	text  string // keywords and function names upper-cased, other words as written
+	raw   string // the word as written, when text upper-cased it
+	kind  tokenKind
+	depth int // parenthesis depth the token is at
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenKeyword
+	tokenLiteral // quoted strings and numbers
+	tokenQuoted  // backtick identifiers
+	tokenPunct
+	tokenHint
+)
+
+// sqlKeywords are upper-cased by the formatter; other words are identifiers
+// whose case may matter, unless they are called as functions
+var sqlKeywords = map[string]bool{}
+
+func init() {
+	for _, word := range strings.Fields(`
+		SELECT DISTINCT DISTINCTROW ALL FROM WHERE GROUP BY HAVING ORDER LIMIT OFFSET
+		UNION EXCEPT INTERSECT WITH RECURSIVE AS ON USING JOIN INNER LEFT RIGHT FULL
+		OUTER CROSS NATURAL STRAIGHT_JOIN AND OR NOT XOR IN IS NULL LIKE ESCAPE REGEXP
+		RLIKE BETWEEN EXISTS CASE WHEN THEN ELSE END ASC DESC INTERVAL TRUE FALSE
+		ANY SOME FOR UPDATE SHARE LOCK MODE WINDOW OVER PARTITION ROWS RANGE
+		PRECEDING FOLLOWING UNBOUNDED CURRENT ROW SQL_CALC_FOUND_ROWS SQL_NO_CACHE
+		HIGH_PRIORITY DIV MOD USE FORCE IGNORE INDEX KEY`) {
+		sqlKeywords[word] = true
+	}
+}
+
+// callableKeywords are keywords that are also function names
+var callableKeywords = map[string]bool{"LEFT": true, "RIGHT": true, "MOD": true}
+
+// tokenizeSQL splits a statement into tokens, dropping comments other than
+// optimizer hints. Unterminated literals run to the end of the input.
+func tokenizeSQL(sql string) []sqlToken {
+	var tokens []sqlToken
+	depth := 0
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '#' || strings.HasPrefix(sql[i:], "-- ") || strings.HasPrefix(sql[i:], "--\n"):
+			if nl := strings.IndexByte(sql[i:], '\n'); nl >= 0 {
+				i += nl + 1
+			} else {
+				i = len(sql)
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			next := len(sql)
+			if end >= 0 {
+				next = i + 2 + end + 2
+			}
+			if strings.HasPrefix(sql[i:], "/*+") {
+				body := strings.Join(strings.Fields(strings.TrimSuffix(sql[i+3:next], "*/")), " ")
+				tokens = append(tokens, sqlToken{text: "/*+ " + body + " */", kind: tokenHint, depth: depth})
+			}
+			i = next
+		case c == '\'' || c == '"' || c == '`':
+			end := quotedTokenEnd(sql, i)
+			kind := tokenLiteral
+			if c == '`' {
+				kind = tokenQuoted
+			}
+			tokens = append(tokens, sqlToken{text: sql[i:end], kind: kind, depth: depth})
+			i = end
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(sql) && sql[i+1] >= '0' && sql[i+1] <= '9' && !afterWord(tokens):
+			start := i
+			for i < len(sql) && (isSQLWordChar(sql[i]) || sql[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, sqlToken{text: sql[start:i], kind: tokenLiteral, depth: depth})
+		case isSQLWordChar(c):
+			start := i
+			for i < len(sql) && isSQLWordChar(sql[i]) {
+				i++
+			}
+			word := sql[start:i]
+			if upper := strings.ToUpper(word); sqlKeywords[upper] {
+				tokens = append(tokens, sqlToken{text: upper, raw: word, kind: tokenKeyword, depth: depth})
+			} else {
+				tokens = append(tokens, sqlToken{text: word, kind: tokenWord, depth: depth})
+			}
+		case c == '(':
+			// Function names are case-insensitive, so calls format alike
+			if n := len(tokens); n > 0 && (tokens[n-1].kind == tokenWord || callableKeywords[tokens[n-1].text]) {
+				if tokens[n-1].raw == "" {
+					tokens[n-1].raw = tokens[n-1].text
+				}
+				tokens[n-1].text = strings.ToUpper(tokens[n-1].text)
+				tokens[n-1].kind = tokenWord
+			}
+			tokens = append(tokens, sqlToken{text: "(", kind: tokenPunct, depth: depth})
+			depth++
+			i++
+		case c == ')':
+			if depth > 0 {
+				depth--
+			}
+			tokens = append(tokens, sqlToken{text: ")", kind: tokenPunct, depth: depth})
+			i++
+		default:
+			// Multi-character operators stay together
+			op := string(c)
+			for _, candidate := range []string{"<=>", "<>", "!=", "<=", ">=", ":=", "||", "&&", "<<", ">>"} {
+				if strings.HasPrefix(sql[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			tokens = append(tokens, sqlToken{text: op, kind: tokenPunct, depth: depth})
+			i += len(op)
+		}
+	}
+	return tokens
+}
+
+// afterWord reports whether the last token is a word, so ".5" after t is
+// the qualified name t.5 rather than a number
+func afterWord(tokens []sqlToken) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	last := tokens[len(tokens)-1]
+	return last.kind == tokenWord || last.kind == tokenQuoted || last.text == ")"
+}
+
+func quotedTokenEnd(sql string, start int) int {
+	quote := sql[start]
+	for i := start + 1; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+func isSQLWordChar(c byte) bool {
+	return c == '_' || c == '$' || c == '@' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// renderTokens joins tokens on one line with canonical spacing
+func renderTokens(tokens []sqlToken) string {
+	var b strings.Builder
+	for i, tok := range tokens {
+		if i > 0 && spaceBetween(tokens[i-1], tok) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(tok.text)
+	}
+	return b.String()
+}
+
+func spaceBetween(prev, tok sqlToken) bool {
+	switch {
+	case prev.text == "(" || prev.text == "." || tok.text == "." || tok.text == ")" || tok.text == ",":
+		return false
+	case tok.text == "(":
+		// Calls hug their parenthesis, keywords such as IN and USING do not
+		return prev.kind != tokenWord && prev.kind != tokenQuoted
+	case prev.text == "@" || prev.text == "@@":
+		return false
+	}
+	return true
+}
+
+// Clause keywords that start a new line in formatted SQL
+var clauseStarts = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "GROUP": true, "HAVING": true, "ORDER": true,
+	"LIMIT": true, "UNION": true, "EXCEPT": true, "INTERSECT": true, "WINDOW": true, "WITH": true,
+}
+
+// Words that begin a join, as long as they are not called as functions
+// (LEFT(...), RIGHT(...))
+var joinStarts = map[string]bool{
+	"JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true, "FULL": true, "CROSS": true,
+	"NATURAL": true, "STRAIGHT_JOIN": true,
+}
+
+// FormatSQL pretty-prints a statement deterministically: keywords and
+// function names upper-cased, whitespace normalized and comments other than
+// hints dropped, with a line per clause, select item, join and top-level
+// AND/OR condition. Subqueries are indented one level per nesting.
+func FormatSQL(sql string) string {
+	tokens := tokenizeSQL(sql)
+	if n := len(tokens); n > 0 && tokens[n-1].text == ";" {
+		tokens = tokens[:n-1]
+	}
+
+	var lines []string
+	var line []sqlToken
+	indent := 0
+	// subqueries holds the indentation of each open subquery, parens
+	// whether each open parenthesis is one
+	var subqueries []int
+	var parens []bool
+	clause := ""
+	selectHead := false // between SELECT and its first item
+	pendingBetween := 0
+
+	flush := func() {
+		if len(line) > 0 {
+			lines = append(lines, strings.Repeat("  ", indent)+renderTokens(line))
+			line = nil
+		}
+	}
+	breakAt := func(level int) {
+		flush()
+		indent = level
+	}
+	base := func() int {
+		if len(subqueries) == 0 {
+			return 0
+		}
+		return subqueries[len(subqueries)-1]
+	}
+	// atClauseLevel reports whether tok is directly inside the innermost
+	// statement rather than inside a call or expression group
+	atClauseLevel := func() bool {
+		return len(parens) == 0 || parens[len(parens)-1]
+	}
+
+	for i, tok := range tokens {
+		next := ""
+		if i+1 < len(tokens) {
+			next = tokens[i+1].text
+		}
+		prev := ""
+		if i > 0 {
+			prev = tokens[i-1].text
+		}
+
+		switch {
+		case tok.text == "(":
+			isSubquery := next == "SELECT" || next == "WITH"
+			parens = append(parens, isSubquery)
+			line = append(line, tok)
+			if isSubquery {
+				subqueries = append(subqueries, base()+2)
+			}
+			continue
+		case tok.text == ")":
+			if len(parens) > 0 {
+				if parens[len(parens)-1] {
+					subqueries = subqueries[:len(subqueries)-1]
+					breakAt(base() + 1)
+				}
+				parens = parens[:len(parens)-1]
+			}
+			line = append(line, tok)
+			continue
+		}
+
+		if atClauseLevel() && tok.kind == tokenKeyword {
+			switch {
+			case clauseStarts[tok.text] && !(tok.text == "WITH" && prev == "("):
+				if len(line) > 0 && !(len(line) == 1 && line[0].text == "(") {
+					breakAt(base())
+				} else {
+					indent = base()
+				}
+				clause = tok.text
+				selectHead = tok.text == "SELECT"
+			case joinStarts[tok.text] && !(tok.text == "JOIN" && joinModifier(prev)):
+				breakAt(base() + 1)
+				clause = "JOIN"
+			case (tok.text == "AND" || tok.text == "OR") && clause != "SELECT":
+				if tok.text == "AND" && pendingBetween > 0 {
+					pendingBetween--
+				} else if clause == "JOIN" {
+					breakAt(base() + 2)
+				} else {
+					breakAt(base() + 1)
+				}
+			case tok.text == "BETWEEN":
+				pendingBetween++
+			}
+		}
+
+		// Select items go on their own lines, after any hints and modifiers
+		if atClauseLevel() && selectHead && tok.text != "SELECT" && tok.kind != tokenHint && !selectModifiers[tok.text] {
+			selectHead = false
+			breakAt(base() + 1)
+		}
+		line = append(line, tok)
+		if atClauseLevel() && clause == "SELECT" && tok.text == "," {
+			breakAt(base() + 1)
+		}
+	}
+	flush()
+	return strings.Join(lines, "\n")
+}
+
+var selectModifiers = map[string]bool{
+	"ALL": true, "DISTINCT": true, "DISTINCTROW": true, "HIGH_PRIORITY": true,
+	"STRAIGHT_JOIN": true, "SQL_CALC_FOUND_ROWS": true, "SQL_NO_CACHE": true,
+}
+
+func joinModifier(word string) bool {
+	switch word {
+	case "INNER", "LEFT", "RIGHT", "FULL", "CROSS", "NATURAL", "OUTER":
+		return true
+	}
+	return false
+}
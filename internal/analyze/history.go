@@ -0,0 +1,172 @@
+package analyze
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+
+	"github.com/matthieukhl/latentia/internal/database"
+)
+
+// Caps of DigestHistory, far above what a digest gathers in practice
+const (
+	maxDigestRewrites = 500
+	maxDigestReviews  = 2000
+)
+
+// DigestRewrite is a rewrite in the history of a digest, with the review
+// decisions recorded for it
+type DigestRewrite struct {
+	RewriteSummary
+	SlowQueryID      int64  `json:"slow_query_id"`
+	OptimizedSQL     string `json:"optimized_sql"`
+	ConfidenceSource string `json:"confidence_source,omitempty"`
+	RealizedStatus   string `json:"realized_status,omitempty"`
+
+	// Reviews are the audit entries of the rewrite, oldest first
+	Reviews []database.AuditEntry `json:"reviews"`
+}
+
+// DigestHistory is every rewrite proposed for the slow queries of a digest
+type DigestHistory struct {
+	Digest string `json:"digest"`
+
+	// Accepted is the most recently accepted rewrite, nil when none is
+	Accepted *DigestRewrite `json:"accepted_rewrite"`
+
+	// Rewrites are oldest first
+	Rewrites []DigestRewrite `json:"rewrites"`
+
+	// PromptVersions compares the reviewable rewrites by prompt version, as
+	// ConfidenceCalibration does for all digests
+	PromptVersions []PromptVersionCalibration `json:"prompt_versions"`
+}
+
+// DigestHistory returns the rewrites of the slow queries with digest and
+// their reviews, so a query that regresses again can be compared with what
+// was tried before. Rewrites are found through their slow query, so those
+// of purged slow queries are gone but their reviews stay in the audit log.
+func (oe *OptimizationEngine) DigestHistory(ctx context.Context, digest string) (*DigestHistory, error) {
+	rows, err := oe.db.QueryContext(ctx, `
+		SELECT r.id, r.status, r.confidence_score, r.created_at, r.reviewed_at,
+		       COALESCE(r.reviewed_by, ''), COALESCE(r.review_comment, ''), r.parent_rewrite_id, r.prompt_version,
+		       r.slow_query_id, r.optimized_sql, COALESCE(r.confidence_source, ''), COALESCE(r.realized_status, '')
+		FROM app_rewrites r
+		JOIN app_slow_queries s ON s.id = r.slow_query_id
+		WHERE s.digest = ?
+		ORDER BY r.created_at, r.id
+		LIMIT ?`, digest, maxDigestRewrites)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the rewrites of digest %s: %w", digest, err)
+	}
+	defer rows.Close()
+
+	history := &DigestHistory{Digest: digest, Rewrites: []DigestRewrite{}, PromptVersions: []PromptVersionCalibration{}}
+	for rows.Next() {
+		var r DigestRewrite
+		var reviewedAt sql.NullTime
+		var parentRewriteID sql.NullInt64
+		if err := rows.Scan(&r.ID, &r.Status, &r.ConfidenceScore, &r.CreatedAt, &reviewedAt,
+			&r.ReviewedBy, &r.ReviewComment, &parentRewriteID, &r.PromptVersion,
+			&r.SlowQueryID, &r.OptimizedSQL, &r.ConfidenceSource, &r.RealizedStatus); err != nil {
+			return nil, fmt.Errorf("failed to scan rewrite: %w", err)
+		}
+		if reviewedAt.Valid {
+			r.ReviewedAt = &reviewedAt.Time
+		}
+		if parentRewriteID.Valid {
+			r.ParentRewriteID = &parentRewriteID.Int64
+		}
+		r.Reviews = []database.AuditEntry{}
+		history.Rewrites = append(history.Rewrites, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query the rewrites of digest %s: %w", digest, err)
+	}
+	if len(history.Rewrites) == 0 {
+		return history, nil
+	}
+
+	ids := make([]int64, len(history.Rewrites))
+	byID := map[int64]*DigestRewrite{}
+	for i := range history.Rewrites {
+		ids[i] = history.Rewrites[i].ID
+		byID[ids[i]] = &history.Rewrites[i]
+	}
+	entries, err := oe.db.ListAudit(ctx, database.AuditFilter{RewriteIDs: ids, Limit: maxDigestReviews})
+	if err != nil {
+		return nil, err
+	}
+	// ListAudit returns the most recent first
+	slices.Reverse(entries)
+	for _, entry := range entries {
+		if r := byID[entry.RewriteID]; r != nil {
+			r.Reviews = append(r.Reviews, entry)
+		}
+	}
+
+	for i := range history.Rewrites {
+		r := &history.Rewrites[i]
+		if r.Status != "accepted" {
+			continue
+		}
+		if history.Accepted == nil || reviewedLater(r, history.Accepted) {
+			history.Accepted = r
+		}
+	}
+	history.PromptVersions = digestPromptVersions(history.Rewrites)
+	return history, nil
+}
+
+// digestPromptVersions groups the pending, accepted and rejected rewrites
+// by prompt version
+func digestPromptVersions(rewrites []DigestRewrite) []PromptVersionCalibration {
+	byVersion := map[string]*calibrationRow{}
+	var rows []*calibrationRow
+	for _, r := range rewrites {
+		if r.Status != "pending" && r.Status != "accepted" && r.Status != "rejected" {
+			continue
+		}
+		row := byVersion[r.PromptVersion]
+		if row == nil {
+			row = &calibrationRow{code: r.PromptVersion}
+			byVersion[r.PromptVersion] = row
+			rows = append(rows, row)
+		}
+		row.rewrites++
+		row.confidence += r.ConfidenceScore
+		switch r.Status {
+		case "pending":
+			row.pending++
+			continue
+		case "accepted":
+			row.accepted++
+		case "rejected":
+			row.rejected++
+		}
+		if r.ReviewedAt != nil {
+			row.reviewSeconds += r.ReviewedAt.Sub(r.CreatedAt).Seconds()
+			row.timedReviews++
+		}
+	}
+
+	grouped := make([]calibrationRow, len(rows))
+	for i, row := range rows {
+		grouped[i] = *row
+	}
+	return promptVersionCalibrations(grouped)
+}
+
+// reviewedLater reports whether a was reviewed after b, by creation time
+// for rewrites without a review time
+func reviewedLater(a, b *DigestRewrite) bool {
+	at, bt := a.CreatedAt, b.CreatedAt
+	if a.ReviewedAt != nil {
+		at = *a.ReviewedAt
+	}
+	if b.ReviewedAt != nil {
+		bt = *b.ReviewedAt
+	}
+	return !at.Before(bt)
+}
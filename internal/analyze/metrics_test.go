@@ -0,0 +1,251 @@
+package analyze
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/matthieukhl/latentia/internal/config/configtest"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/llm/embed"
+	"github.com/matthieukhl/latentia/internal/llm/generate"
+	"github.com/matthieukhl/latentia/internal/metrics"
+	"github.com/matthieukhl/latentia/internal/rag"
+	"github.com/matthieukhl/latentia/internal/safety"
+)
+
+const metricsConfig = `
+llm:
+  generator:
+    provider: mock
+    model: test
+    input_price_per_mtok: 3
+    output_price_per_mtok: 15
+  rag:
+    enabled: false
+`
+
+// emptyDB is a database where every query returns no rows and every
+// statement affects one, so an optimization runs on the generator alone
+type emptyDB struct{}
+
+func (emptyDB) Connect(context.Context) (driver.Conn, error) { return emptyDB{}, nil }
+func (emptyDB) Driver() driver.Driver                        { return nil }
+
+func (emptyDB) Prepare(query string) (driver.Stmt, error) { return emptyDB{}, nil }
+func (emptyDB) Close() error                              { return nil }
+func (emptyDB) Begin() (driver.Tx, error)                 { return emptyDB{}, nil }
+
+func (emptyDB) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) { return emptyDB{}, nil }
+
+func (emptyDB) Commit() error   { return nil }
+func (emptyDB) Rollback() error { return nil }
+
+func (emptyDB) NumInput() int                                   { return -1 }
+func (emptyDB) Exec(args []driver.Value) (driver.Result, error) { return emptyDB{}, nil }
+func (emptyDB) Query(args []driver.Value) (driver.Rows, error)  { return emptyDB{}, nil }
+
+func (emptyDB) LastInsertId() (int64, error) { return 1, nil }
+func (emptyDB) RowsAffected() (int64, error) { return 1, nil }
+
+func (emptyDB) Columns() []string              { return nil }
+func (emptyDB) Next(dest []driver.Value) error { return io.EOF }
+
+// newMetricsEngine returns an engine on generator over an emptyDB
+func newMetricsEngine(t *testing.T, generator *generate.MockGenerator) *OptimizationEngine {
+	t.Helper()
+	configtest.Load(t, metricsConfig)
+	pool := sql.OpenDB(emptyDB{})
+	t.Cleanup(func() { pool.Close() })
+	db := &database.DB{DB: pool}
+	oe := NewOptimizationEngine(db, rag.NewDocumentStore(db, embed.NewMockEmbedder("test", 8)), generator)
+	// A DB built outside NewConnection has no target; explain and
+	// introspect on the app pool instead, as ForTarget swaps them
+	oe.executor = safety.NewSafeExecutor(pool)
+	oe.promptBuilder.schemas = database.NewSchemaIntrospector(pool, 0)
+	return oe
+}
+
+// series snapshots the pipeline series an optimization moves
+type series struct {
+	started, succeeded float64
+	failed             map[string]float64
+	stages             map[string]uint64
+	promptTokens       float64
+	completionTokens   float64
+	cost               float64
+}
+
+var pipelineStages = []string{
+	metrics.StageAnalysis, metrics.StagePrompt, metrics.StageRetrieval, metrics.StageGeneration,
+	metrics.StageParse, metrics.StageValidate, metrics.StageStore,
+}
+
+func snapshot() series {
+	s := series{
+		started:          metrics.OptimizationsStarted.Value(),
+		succeeded:        metrics.OptimizationsSucceeded.Value(),
+		failed:           map[string]float64{},
+		stages:           map[string]uint64{},
+		promptTokens:     metrics.LLMTokens.Value("mock", "test-mock", "prompt"),
+		completionTokens: metrics.LLMTokens.Value("mock", "test-mock", "completion"),
+		cost:             metrics.LLMCost.Value("mock", "test-mock"),
+	}
+	for _, stage := range pipelineStages {
+		s.failed[stage] = metrics.OptimizationsFailed.Value(stage)
+		s.stages[stage] = metrics.StageDuration.Count(stage)
+	}
+	return s
+}
+
+func TestOptimizeQueryIncrementsPipelineSeries(t *testing.T) {
+	generator := generate.NewMockGenerator("test", generate.MockOptions{}).Respond("customers", jsonAnswer)
+	oe := newMetricsEngine(t, generator)
+
+	before := snapshot()
+	result, err := oe.OptimizeQuery(context.Background(), 1, "SELECT * FROM customers WHERE email LIKE '%john%'")
+	if err != nil {
+		t.Fatalf("OptimizeQuery: %v", err)
+	}
+	after := snapshot()
+
+	if after.started-before.started != 1 || after.succeeded-before.succeeded != 1 {
+		t.Errorf("started +%v, succeeded +%v, want +1 each", after.started-before.started, after.succeeded-before.succeeded)
+	}
+	for _, stage := range pipelineStages {
+		if got := after.stages[stage] - before.stages[stage]; got != 1 {
+			t.Errorf("stage %s timed %d times, want once", stage, got)
+		}
+		if got := after.failed[stage] - before.failed[stage]; got != 0 {
+			t.Errorf("failed in %s +%v, want no failure", stage, got)
+		}
+	}
+
+	if result.ID != 1 {
+		t.Errorf("rewrite not stored: ID = %d", result.ID)
+	}
+
+	// The tokens the mock reports are counted for its provider and model,
+	// at the configured prices
+	prompt, completion := after.promptTokens-before.promptTokens, after.completionTokens-before.completionTokens
+	if prompt != float64(result.PromptTokens) || completion != float64(result.CompletionTokens) || prompt == 0 || completion == 0 {
+		t.Errorf("tokens +%v/+%v, want the %d/%d of the result", prompt, completion, result.PromptTokens, result.CompletionTokens)
+	}
+	if cost, want := after.cost-before.cost, (prompt*3+completion*15)/1e6; !approxEqual(cost, want) || !approxEqual(result.EstimatedCost, want) {
+		t.Errorf("cost +%v, result %v, want %v", cost, result.EstimatedCost, want)
+	}
+}
+
+func TestProposeLeavesStoreUntimed(t *testing.T) {
+	oe := newMetricsEngine(t, generate.NewMockGenerator("test", generate.MockOptions{}).Respond("customers", jsonAnswer))
+
+	before := snapshot()
+	if _, err := oe.Propose(context.Background(), "SELECT * FROM customers"); err != nil {
+		t.Fatalf("Propose: %v", err)
+	}
+	after := snapshot()
+	if after.started-before.started != 1 || after.succeeded-before.succeeded != 1 {
+		t.Errorf("started +%v, succeeded +%v, want +1 each", after.started-before.started, after.succeeded-before.succeeded)
+	}
+	if got := after.stages[metrics.StageValidate] - before.stages[metrics.StageValidate]; got != 1 {
+		t.Errorf("validation timed %d times, want once", got)
+	}
+	if got := after.stages[metrics.StageStore] - before.stages[metrics.StageStore]; got != 0 {
+		t.Errorf("store timed %d times, though Propose stores nothing", got)
+	}
+}
+
+func TestOptimizeQueryCountsFailureStage(t *testing.T) {
+	tests := []struct {
+		name    string
+		outcome generate.MockFailure
+		sql     string
+		stage   string
+		tokens  bool
+	}{
+		{"provider error", generate.MockRateLimited, "SELECT * FROM customers", metrics.StageGeneration, false},
+		{"unparseable response", generate.MockMalformed, "SELECT * FROM customers", metrics.StageParse, true},
+		{"unsafe statement", generate.MockOK, "DROP TABLE customers", metrics.StageValidate, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator := generate.NewMockGenerator("test", generate.MockOptions{Script: []generate.MockFailure{tt.outcome}})
+			oe := newMetricsEngine(t, generator)
+
+			before := snapshot()
+			if _, err := oe.OptimizeQuery(context.Background(), 1, tt.sql); err == nil {
+				t.Fatal("OptimizeQuery succeeded")
+			}
+			after := snapshot()
+
+			if after.started-before.started != 1 || after.succeeded != before.succeeded {
+				t.Errorf("started +%v, succeeded +%v, want +1 and +0", after.started-before.started, after.succeeded-before.succeeded)
+			}
+			for _, stage := range pipelineStages {
+				want := 0.0
+				if stage == tt.stage {
+					want = 1
+				}
+				if got := after.failed[stage] - before.failed[stage]; got != want {
+					t.Errorf("failed in %s +%v, want +%v", stage, got, want)
+				}
+			}
+			if counted := after.promptTokens > before.promptTokens; counted != tt.tokens {
+				t.Errorf("tokens counted = %v, want %v", counted, tt.tokens)
+			}
+		})
+	}
+}
+
+func TestPipelineSeriesNames(t *testing.T) {
+	var buf bytes.Buffer
+	metrics.Default.WriteText(&buf)
+	for _, name := range []string{
+		"latentia_optimizations_started_total",
+		"latentia_optimizations_succeeded_total",
+		"latentia_optimizations_failed_total",
+		"latentia_optimization_stage_duration_seconds",
+		"latentia_llm_tokens_total",
+		"latentia_llm_cost_usd_total",
+		"latentia_reviews_total",
+	} {
+		if !strings.Contains(buf.String(), "# TYPE "+name+" ") {
+			t.Errorf("%s is not exposed", name)
+		}
+	}
+}
+
+func approxEqual(a, b float64) bool {
+	d := a - b
+	return d < 1e-12 && d > -1e-12
+}
+
+// interruptedDB is an emptyDB whose result sets fail before their first row,
+// as when the connection drops mid-stream
+type interruptedDB struct{ emptyDB }
+
+var errInterrupted = errors.New("connection lost while reading rows")
+
+func (interruptedDB) Connect(context.Context) (driver.Conn, error) { return interruptedDB{}, nil }
+func (interruptedDB) Prepare(query string) (driver.Stmt, error)    { return interruptedDB{}, nil }
+func (interruptedDB) Query(args []driver.Value) (driver.Rows, error) {
+	return interruptedDB{}, nil
+}
+func (interruptedDB) Next(dest []driver.Value) error { return errInterrupted }
+
+func TestListOptimizationsReportsStreamErrors(t *testing.T) {
+	oe := newMetricsEngine(t, generate.NewMockGenerator("test", generate.MockOptions{}))
+	pool := sql.OpenDB(interruptedDB{})
+	t.Cleanup(func() { pool.Close() })
+	oe.db = &database.DB{DB: pool}
+
+	results, err := oe.ListOptimizations(context.Background(), "pending", "", "", 10)
+	if !errors.Is(err, errInterrupted) {
+		t.Errorf("ListOptimizations = %d results, %v; want the stream error", len(results), err)
+	}
+}
@@ -13,6 +13,43 @@ type QueryPattern struct {
 	OptimizationOps []string `json:"optimization_opportunities"`
 	Complexity      string   `json:"complexity"` // simple, medium, complex
 	Keywords        []string `json:"keywords"`
+	// FilterFunctions name the functions applied to compared columns behind
+	// function-on-indexed-column, such as DATE
+	FilterFunctions []string `json:"filter_functions,omitempty"`
+	// Placeholders counts the ? parameters of a statement captured from a
+	// prepared statement
+	Placeholders int `json:"placeholders,omitempty"`
+	// Views are the views the query reads, when PromptBuilder.ExpandViews
+	// found them; Tables then also lists the tables behind them
+	Views []string `json:"views,omitempty"`
+	// ActualPlan is the EXPLAIN ANALYZE output captured when the slow query
+	// was generated with --capture-plan
+	ActualPlan string `json:"actual_plan,omitempty"`
+
+	// Findings describe AntiPatterns with their severity and location
+	Findings []Finding `json:"findings"`
+}
+
+// IsDML reports whether the pattern is an UPDATE, DELETE or INSERT, whose
+// rewrites change data
+func (p QueryPattern) IsDML() bool {
+	switch p.Type {
+	case "update", "delete", "insert":
+		return true
+	}
+	return false
+}
+
+// HighSeverity lists the codes of critical findings, which make the
+// statement dangerous rather than only slow, such as an UPDATE without WHERE
+func (p QueryPattern) HighSeverity() []string {
+	var severe []string
+	for _, finding := range p.Findings {
+		if finding.Severity == SeverityCritical {
+			severe = append(severe, finding.Code)
+		}
+	}
+	return severe
 }
 
 // QueryAnalyzer detects patterns and anti-patterns in SQL queries
@@ -26,33 +63,58 @@ func NewQueryAnalyzer() *QueryAnalyzer {
 	return &QueryAnalyzer{
 		joinRegex:     regexp.MustCompile(`(?i)\b(INNER\s+JOIN|LEFT\s+JOIN|RIGHT\s+JOIN|FULL\s+JOIN|JOIN)\b`),
 		tableRegex:    regexp.MustCompile(`(?i)\b(?:FROM|JOIN)\s+([a-zA-Z_][a-zA-Z0-9_]*)`),
-		subqueryRegex: regexp.MustCompile(`\([^)]*SELECT[^)]*\)`),
+		subqueryRegex: regexp.MustCompile(`(?i)\([^)]*SELECT[^)]*\)`),
 	}
 }
 
-// AnalyzeQuery examines a SQL query and identifies patterns and optimization opportunities
+// AnalyzeQuery examines a SQL query and identifies patterns and optimization
+// opportunities. Tables, joins, SELECT * and the statement type come from the
+// token structure of the query, so quoted and schema-qualified names, derived
+// tables and CTEs are handled; when it cannot be followed the text heuristics
+// are used instead.
 func (qa *QueryAnalyzer) AnalyzeQuery(sql string) QueryPattern {
+	return qa.AnalyzeQueryWithSample(sql, "")
+}
+
+// AnalyzeQueryWithSample is AnalyzeQuery for a statement with ? parameters,
+// whose LIKE patterns are read from sample, an occurrence of the same
+// statement with its literals. Without a sample, LIKE ? is taken as a
+// pattern search of unknown shape rather than a leading wildcard.
+func (qa *QueryAnalyzer) AnalyzeQueryWithSample(sql, sample string) QueryPattern {
 	sql = strings.TrimSpace(sql)
 	sqlLower := strings.ToLower(sql)
 	
+	structure, ok := parseQueryStructure(sql)
+	if !ok {
+		structure = qa.heuristicStructure(sql, sqlLower)
+	}
+	predicates := findPredicateIssues(sql)
+	likes := findLikePatterns(sql)
+	if sample != "" {
+		likes = withSample(likes, findLikePatterns(sample))
+	}
+	
 	pattern := QueryPattern{
-		Tables:          qa.extractTables(sql),
+		Tables:          structure.tables,
 		AntiPatterns:    []string{},
 		OptimizationOps: []string{},
 		Keywords:        []string{},
+		FilterFunctions: predicates.functions,
+		Placeholders:    countPlaceholders(sql),
 	}
 	
 	// Detect primary query type
-	pattern.Type = qa.detectQueryType(sqlLower)
+	pattern.Type = qa.detectQueryType(sqlLower, structure, likes)
 	
 	// Detect anti-patterns
-	pattern.AntiPatterns = qa.detectAntiPatterns(sqlLower)
+	pattern.AntiPatterns = qa.detectAntiPatterns(sqlLower, structure, predicates, likes)
+	pattern.Findings = findingsFor(pattern.AntiPatterns, pattern.FilterFunctions)
 	
 	// Identify optimization opportunities
 	pattern.OptimizationOps = qa.identifyOptimizations(sqlLower, pattern.AntiPatterns)
 	
 	// Assess complexity
-	pattern.Complexity = qa.assessComplexity(sqlLower, len(pattern.Tables))
+	pattern.Complexity = qa.assessComplexity(sqlLower, structure)
 	
 	// Extract relevant keywords
 	pattern.Keywords = qa.extractKeywords(sqlLower)
@@ -60,13 +122,45 @@ func (qa *QueryAnalyzer) AnalyzeQuery(sql string) QueryPattern {
 	return pattern
 }
 
+// heuristicStructure approximates the structure of a query the token walk
+// could not follow from its text
+func (qa *QueryAnalyzer) heuristicStructure(sql, sqlLower string) *queryStructure {
+	statement := "SELECT"
+	if fields := strings.Fields(sqlLower); len(fields) > 0 {
+		switch fields[0] {
+		case "update", "delete", "insert", "replace":
+			statement = strings.ToUpper(fields[0])
+		}
+	}
+	return &queryStructure{
+		statement:  statement,
+		tables:     qa.extractTables(sql),
+		joins:      len(qa.joinRegex.FindAllString(sqlLower, -1)),
+		subqueries: len(qa.subqueryRegex.FindAllString(sqlLower, -1)),
+		unions:     strings.Count(sqlLower, "union"),
+		selectStar: strings.Contains(sqlLower, "select *"),
+		// Cartesian product risk (comma joins)
+		crossJoin:  strings.Contains(sqlLower, " from ") && strings.Count(sqlLower, ",") > 0 && !strings.Contains(sqlLower, "join"),
+		inSubquery: len(qa.subqueryRegex.FindAllString(sqlLower, -1)) > 0 && strings.Contains(sqlLower, "in ("),
+		where:      strings.Contains(sqlLower, "where"),
+	}
+}
+
 // detectQueryType identifies the primary type of SQL operation
-func (qa *QueryAnalyzer) detectQueryType(sql string) string {
+func (qa *QueryAnalyzer) detectQueryType(sql string, structure *queryStructure, likes []likePattern) string {
 	if strings.Contains(sql, "sleep(") {
 		return "sleep-test"
 	}
 	
-	joinCount := len(qa.joinRegex.FindAllString(sql, -1))
+	// Writes are classified by statement; INSERT ... SELECT is still an insert
+	if structure.statement != "SELECT" {
+		if structure.statement == "REPLACE" {
+			return "insert"
+		}
+		return strings.ToLower(structure.statement)
+	}
+	
+	joinCount := structure.joins
 	if joinCount > 0 {
 		if joinCount >= 3 {
 			return "complex-join"
@@ -78,11 +172,13 @@ func (qa *QueryAnalyzer) detectQueryType(sql string) string {
 		return "aggregation"
 	}
 	
-	if strings.Contains(sql, "like") && strings.Contains(sql, "%") {
-		return "pattern-search"
+	for _, like := range likes {
+		if like.searches() {
+			return "pattern-search"
+		}
 	}
 	
-	if strings.Contains(sql, "select *") {
+	if structure.selectStar {
 		return "full-select"
 	}
 	
@@ -94,41 +190,63 @@ func (qa *QueryAnalyzer) detectQueryType(sql string) string {
 }
 
 // detectAntiPatterns identifies performance anti-patterns
-func (qa *QueryAnalyzer) detectAntiPatterns(sql string) []string {
+func (qa *QueryAnalyzer) detectAntiPatterns(sql string, structure *queryStructure, predicates predicateIssues, likes []likePattern) []string {
 	antiPatterns := []string{}
+	read := structure.statement == "SELECT"
+	
+	// UPDATE or DELETE without WHERE changes every row of the table
+	if (structure.statement == "UPDATE" || structure.statement == "DELETE") && !structure.where {
+		antiPatterns = append(antiPatterns, "missing-where")
+	}
 	
 	// SELECT * usage
-	if strings.Contains(sql, "select *") {
+	if structure.selectStar {
 		antiPatterns = append(antiPatterns, "select-star")
 	}
 	
 	// Leading wildcard LIKE patterns
-	if strings.Contains(sql, "like '%") {
-		antiPatterns = append(antiPatterns, "leading-wildcard-like")
+	for _, like := range likes {
+		if like.leading {
+			antiPatterns = append(antiPatterns, "leading-wildcard-like")
+			break
+		}
 	}
 	
 	// Missing LIMIT on potentially large result sets
-	if !strings.Contains(sql, "limit") && (strings.Contains(sql, "join") || strings.Contains(sql, "order by")) {
+	if read && !strings.Contains(sql, "limit") && (strings.Contains(sql, "join") || strings.Contains(sql, "order by")) {
 		antiPatterns = append(antiPatterns, "missing-limit")
 	}
 	
-	// Cartesian product risk (comma joins)
-	if strings.Contains(sql, " from ") && strings.Count(sql, ",") > 0 && !strings.Contains(sql, "join") {
+	// Cartesian product risk: joins without a condition
+	if structure.crossJoin {
 		antiPatterns = append(antiPatterns, "cartesian-join")
 	}
 	
-	// Functions in WHERE clause
-	if regexp.MustCompile(`(?i)WHERE[^=]*\([^)]*\)\s*[=<>]`).MatchString(sql) {
+	// Functions wrapping a compared column; the text check catches what the
+	// token scan cannot tie to a column
+	if len(predicates.functions) > 0 {
+		antiPatterns = append(antiPatterns, "function-on-indexed-column")
+	} else if regexp.MustCompile(`(?i)WHERE[^=]*\([^)]*\)\s*[=<>]`).MatchString(sql) {
 		antiPatterns = append(antiPatterns, "function-in-where")
 	}
 	
+	// String columns compared to numbers are converted row by row
+	if predicates.implicitConversion {
+		antiPatterns = append(antiPatterns, "implicit-conversion")
+	}
+	
+	// OR across different columns defeats a single index range
+	if predicates.orAcrossColumns {
+		antiPatterns = append(antiPatterns, "or-across-columns")
+	}
+	
 	// Subquery instead of JOIN
-	if len(qa.subqueryRegex.FindAllString(sql, -1)) > 0 && strings.Contains(sql, "in (") {
+	if structure.inSubquery {
 		antiPatterns = append(antiPatterns, "subquery-instead-of-join")
 	}
 	
 	// ORDER BY without LIMIT
-	if strings.Contains(sql, "order by") && !strings.Contains(sql, "limit") {
+	if read && strings.Contains(sql, "order by") && !strings.Contains(sql, "limit") {
 		antiPatterns = append(antiPatterns, "order-without-limit")
 	}
 	
@@ -141,6 +259,8 @@ func (qa *QueryAnalyzer) identifyOptimizations(sql string, antiPatterns []string
 	
 	for _, pattern := range antiPatterns {
 		switch pattern {
+		case "missing-where":
+			optimizations = append(optimizations, "batch-full-table-write")
 		case "select-star":
 			optimizations = append(optimizations, "specify-columns")
 		case "leading-wildcard-like":
@@ -151,6 +271,12 @@ func (qa *QueryAnalyzer) identifyOptimizations(sql string, antiPatterns []string
 			optimizations = append(optimizations, "explicit-join-syntax")
 		case "function-in-where":
 			optimizations = append(optimizations, "move-functions-to-select")
+		case "function-on-indexed-column":
+			optimizations = append(optimizations, "rewrite-as-column-range")
+		case "implicit-conversion":
+			optimizations = append(optimizations, "match-literal-types")
+		case "or-across-columns":
+			optimizations = append(optimizations, "split-or-into-union")
 		case "subquery-instead-of-join":
 			optimizations = append(optimizations, "convert-to-join")
 		case "order-without-limit":
@@ -175,8 +301,9 @@ func (qa *QueryAnalyzer) identifyOptimizations(sql string, antiPatterns []string
 }
 
 // assessComplexity determines query complexity based on various factors
-func (qa *QueryAnalyzer) assessComplexity(sql string, tableCount int) string {
+func (qa *QueryAnalyzer) assessComplexity(sql string, structure *queryStructure) string {
 	score := 0
+	tableCount := len(structure.tables)
 	
 	// Table count factor
 	if tableCount >= 4 {
@@ -186,12 +313,10 @@ func (qa *QueryAnalyzer) assessComplexity(sql string, tableCount int) string {
 	}
 	
 	// Join complexity
-	joinCount := len(qa.joinRegex.FindAllString(sql, -1))
-	score += joinCount
+	score += structure.joins
 	
 	// Subquery complexity
-	subqueryCount := len(qa.subqueryRegex.FindAllString(sql, -1))
-	score += subqueryCount * 2
+	score += structure.subqueries * 2
 	
 	// Aggregation complexity
 	if strings.Contains(sql, "group by") {
@@ -205,7 +330,7 @@ func (qa *QueryAnalyzer) assessComplexity(sql string, tableCount int) string {
 	}
 	
 	// UNION complexity
-	if strings.Contains(sql, "union") {
+	if structure.unions > 0 {
 		score += 2
 	}
 	
@@ -0,0 +1,181 @@
+package analyze
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/matthieukhl/latentia/internal/safety"
+)
+
+// PlanOperator is one row of an EXPLAIN FORMAT='brief' plan
+type PlanOperator struct {
+	// Operator is the operator name without tree drawing, ID suffix or
+	// build/probe side, such as IndexRangeScan
+	Operator     string `json:"operator"`
+	Depth        int    `json:"depth"`
+	EstRows      string `json:"est_rows,omitempty"`
+	Task         string `json:"task,omitempty"`
+	AccessObject string `json:"access_object,omitempty"`
+	Info         string `json:"operator_info,omitempty"`
+}
+
+// Table returns the table the operator reads, from its access object
+func (o PlanOperator) Table() string {
+	for _, part := range strings.Split(o.AccessObject, ",") {
+		if table, ok := strings.CutPrefix(strings.TrimSpace(part), "table:"); ok {
+			return table
+		}
+	}
+	return ""
+}
+
+// key identifies the operator when comparing plans, such as
+// "TableFullScan on orders"
+func (o PlanOperator) key() string {
+	if table := o.Table(); table != "" {
+		return o.Operator + " on " + table
+	}
+	return o.Operator
+}
+
+// PlanDiff summarizes how the operators of the rewrite's plan differ from
+// the original's. Operators are compared as multisets keyed by name and
+// table, so estimates and operator info changing alone is not a change.
+type PlanDiff struct {
+	OperatorsAdded   []string `json:"operators_added,omitempty"`
+	OperatorsRemoved []string `json:"operators_removed,omitempty"`
+
+	// Summary describes the changes, one per line, such as "TableFullScan
+	// replaced by IndexRangeScan on orders"
+	Summary []string `json:"summary"`
+}
+
+// parsePlan reads the rows of EXPLAIN FORMAT='brief' by column name
+func parsePlan(result *safety.Result) []PlanOperator {
+	column := map[string]int{}
+	for i, name := range result.Columns {
+		column[strings.ToLower(name)] = i
+	}
+	get := func(row []sql.NullString, name string) string {
+		if i, ok := column[name]; ok && i < len(row) {
+			return row[i].String
+		}
+		return ""
+	}
+
+	plan := make([]PlanOperator, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		id := get(row, "id")
+		name := strings.TrimLeftFunc(id, func(r rune) bool { return !unicode.IsLetter(r) })
+		depth := len([]rune(id)) - len([]rune(name))
+
+		// Non-brief plans suffix IDs, as in TableReader_7; joins mark sides
+		// as in IndexRangeScan(Build)
+		if i := strings.IndexByte(name, '('); i > 0 {
+			name = name[:i]
+		}
+		if i := strings.LastIndexByte(name, '_'); i > 0 && strings.Trim(name[i+1:], "0123456789") == "" {
+			name = name[:i]
+		}
+
+		plan = append(plan, PlanOperator{
+			Operator:     name,
+			Depth:        depth / 2,
+			EstRows:      get(row, "estrows"),
+			Task:         get(row, "task"),
+			AccessObject: get(row, "access object"),
+			Info:         get(row, "operator info"),
+		})
+	}
+	return plan
+}
+
+// DiffPlans compares the plans of an original statement and its rewrite; it
+// returns nil when either plan could not be obtained
+func DiffPlans(original, optimized []PlanOperator) *PlanDiff {
+	if original == nil || optimized == nil {
+		return nil
+	}
+
+	counts := map[string]int{}
+	for _, op := range original {
+		counts[op.key()]++
+	}
+	for _, op := range optimized {
+		counts[op.key()]--
+	}
+
+	diff := &PlanDiff{Summary: []string{}}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for n := counts[key]; n > 0; n-- {
+			diff.OperatorsRemoved = append(diff.OperatorsRemoved, key)
+		}
+		for n := counts[key]; n < 0; n++ {
+			diff.OperatorsAdded = append(diff.OperatorsAdded, key)
+		}
+	}
+
+	// Pair a removed and an added access to the same table as a replacement,
+	// which is what most rewrites are after
+	added := append([]string(nil), diff.OperatorsAdded...)
+	var removedLines []string
+	for _, removed := range diff.OperatorsRemoved {
+		name, table, ok := strings.Cut(removed, " on ")
+		replaced := false
+		for i, a := range added {
+			if other, otherTable, _ := strings.Cut(a, " on "); ok && otherTable == table {
+				diff.Summary = append(diff.Summary, fmt.Sprintf("%s replaced by %s on %s", name, other, table))
+				added = append(added[:i], added[i+1:]...)
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			removedLines = append(removedLines, "operator removed: "+removed)
+		}
+	}
+	diff.Summary = append(diff.Summary, removedLines...)
+	for _, a := range added {
+		diff.Summary = append(diff.Summary, "operator added: "+a)
+	}
+	return diff
+}
+
+// loadPlans decodes the stored plans, each NULL when it could not be
+// obtained, and compares them
+func (r *OptimizationResult) loadPlans(original, optimized sql.NullString) error {
+	for _, p := range []struct {
+		data sql.NullString
+		plan *[]PlanOperator
+	}{{original, &r.PlanOriginal}, {optimized, &r.PlanOptimized}} {
+		if !p.data.Valid {
+			continue
+		}
+		if err := json.Unmarshal([]byte(p.data.String), p.plan); err != nil {
+			return fmt.Errorf("failed to parse plan JSON: %w", err)
+		}
+	}
+	r.PlanDiff = DiffPlans(r.PlanOriginal, r.PlanOptimized)
+	return nil
+}
+
+// planJSON encodes a plan for storage, NULL when it could not be obtained
+func planJSON(plan []PlanOperator) (sql.NullString, error) {
+	if plan == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(plan)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("failed to serialize plan: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
@@ -0,0 +1,224 @@
+package analyze
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/matthieukhl/latentia/internal/safety"
+)
+
+// The EXPLAIN FORMAT='brief' output of a query on orders by status, without
+// and with an index on status, as rows of columns
+const (
+	fullScanExplain = `[
+		["id", "estRows", "task", "access object", "operator info"],
+		["Projection_4", "10.00", "root", "", "test.orders.id"],
+		["└─TableReader_7", "10.00", "root", "", "data:Selection_6"],
+		["  └─Selection_6", "10.00", "cop[tikv]", "", "eq(test.orders.status, \"paid\")"],
+		["    └─TableFullScan_5", "10000.00", "cop[tikv]", "table:orders", "keep order:false"]
+	]`
+	indexExplain = `[
+		["id", "estRows", "task", "access object", "operator info"],
+		["Projection_4", "10.00", "root", "", "test.orders.id"],
+		["└─IndexLookUp_10", "10.00", "root", "", ""],
+		["  ├─IndexRangeScan_8(Build)", "10.00", "cop[tikv]", "table:orders, index:idx_status(status)", "range:[\"paid\",\"paid\"]"],
+		["  └─TableRowIDScan_9(Probe)", "10.00", "cop[tikv]", "table:orders", "keep order:false"]
+	]`
+)
+
+// explainResult decodes canned EXPLAIN output whose first row holds the
+// column names
+func explainResult(t *testing.T, canned string) *safety.Result {
+	t.Helper()
+	var rows [][]any
+	if err := json.Unmarshal([]byte(canned), &rows); err != nil {
+		t.Fatalf("bad canned plan: %v", err)
+	}
+	result := &safety.Result{}
+	for _, name := range rows[0] {
+		result.Columns = append(result.Columns, name.(string))
+	}
+	for _, row := range rows[1:] {
+		var values []sql.NullString
+		for _, v := range row {
+			s, ok := v.(string)
+			values = append(values, sql.NullString{String: s, Valid: ok})
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	return result
+}
+
+func TestParsePlan(t *testing.T) {
+	got := parsePlan(explainResult(t, indexExplain))
+	want := []PlanOperator{
+		{Operator: "Projection", Depth: 0, EstRows: "10.00", Task: "root", Info: "test.orders.id"},
+		{Operator: "IndexLookUp", Depth: 1, EstRows: "10.00", Task: "root"},
+		{Operator: "IndexRangeScan", Depth: 2, EstRows: "10.00", Task: "cop[tikv]", AccessObject: "table:orders, index:idx_status(status)", Info: `range:["paid","paid"]`},
+		{Operator: "TableRowIDScan", Depth: 2, EstRows: "10.00", Task: "cop[tikv]", AccessObject: "table:orders", Info: "keep order:false"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePlan =\n%+v\nwant\n%+v", got, want)
+	}
+	if table := got[2].Table(); table != "orders" {
+		t.Errorf("Table() = %q, want orders", table)
+	}
+	if table := got[0].Table(); table != "" {
+		t.Errorf("Table() of a projection = %q", table)
+	}
+}
+
+func TestParsePlanColumnsByName(t *testing.T) {
+	// Columns in another order and case, one missing and a NULL, as other
+	// versions and formats return them
+	got := parsePlan(explainResult(t, `[
+		["ACCESS OBJECT", "ID", "ESTROWS"],
+		["table:t", "TableFullScan_3", null],
+		["", "HashJoin", "5"]
+	]`))
+	want := []PlanOperator{
+		{Operator: "TableFullScan", AccessObject: "table:t"},
+		{Operator: "HashJoin", EstRows: "5"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePlan =\n%+v\nwant\n%+v", got, want)
+	}
+
+	if got := parsePlan(&safety.Result{Columns: []string{"id"}}); got == nil || len(got) != 0 {
+		t.Errorf("parsePlan of no rows = %#v, want an empty plan, not a missing one", got)
+	}
+}
+
+func TestDiffPlans(t *testing.T) {
+	diff := DiffPlans(parsePlan(explainResult(t, fullScanExplain)), parsePlan(explainResult(t, indexExplain)))
+	want := &PlanDiff{
+		OperatorsAdded:   []string{"IndexLookUp", "IndexRangeScan on orders", "TableRowIDScan on orders"},
+		OperatorsRemoved: []string{"Selection", "TableFullScan on orders", "TableReader"},
+		Summary: []string{
+			"TableFullScan replaced by IndexRangeScan on orders",
+			"operator removed: Selection",
+			"operator removed: TableReader",
+			"operator added: IndexLookUp",
+			"operator added: TableRowIDScan on orders",
+		},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("DiffPlans =\n%+v\nwant\n%+v", diff, want)
+	}
+
+	// Estimates changing alone is not a change
+	same := parsePlan(explainResult(t, strings.ReplaceAll(fullScanExplain, "10000.00", "20.00")))
+	if diff := DiffPlans(parsePlan(explainResult(t, fullScanExplain)), same); len(diff.Summary) != 0 || diff.OperatorsAdded != nil || diff.OperatorsRemoved != nil {
+		t.Errorf("DiffPlans of the same operators = %+v", diff)
+	}
+	if diff := DiffPlans(nil, same); diff != nil {
+		t.Errorf("DiffPlans without the original plan = %+v, want nil", diff)
+	}
+}
+
+func TestLoadPlans(t *testing.T) {
+	original, err := planJSON(parsePlan(explainResult(t, fullScanExplain)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	optimized, err := planJSON(parsePlan(explainResult(t, indexExplain)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r OptimizationResult
+	if err := r.loadPlans(original, optimized); err != nil {
+		t.Fatalf("loadPlans: %v", err)
+	}
+	if !reflect.DeepEqual(r.PlanOriginal, parsePlan(explainResult(t, fullScanExplain))) {
+		t.Errorf("original plan did not survive storage: %+v", r.PlanOriginal)
+	}
+	if r.PlanDiff == nil || r.PlanDiff.Summary[0] != "TableFullScan replaced by IndexRangeScan on orders" {
+		t.Errorf("plan diff = %+v", r.PlanDiff)
+	}
+
+	// A rewrite that failed EXPLAIN has no plan and no diff
+	r = OptimizationResult{}
+	if err := r.loadPlans(original, sql.NullString{}); err != nil {
+		t.Fatalf("loadPlans: %v", err)
+	}
+	if r.PlanOptimized != nil || r.PlanDiff != nil {
+		t.Errorf("missing plan loaded as %+v, diff %+v", r.PlanOptimized, r.PlanDiff)
+	}
+	if stored, _ := planJSON(nil); stored.Valid {
+		t.Errorf("missing plan stored as %q, want NULL", stored.String)
+	}
+
+	err = r.loadPlans(sql.NullString{String: `[{"operator": "TableFullScan", "depth": "one"}]`, Valid: true}, sql.NullString{})
+	if err == nil || !strings.HasPrefix(err.Error(), "failed to parse plan JSON") {
+		t.Errorf("loadPlans of a corrupt plan = %v", err)
+	}
+}
+
+func TestComparePlans(t *testing.T) {
+	qa := NewQueryAnalyzer()
+	fullScan := parsePlan(explainResult(t, fullScanExplain))
+	index := parsePlan(explainResult(t, indexExplain))
+	const sql = "SELECT id FROM orders WHERE status = 'paid'"
+
+	signals := qa.comparePlans(fullScan, index, sql, "SELECT id FROM orders USE INDEX (idx_status) WHERE status = 'paid'")
+	want := &planSignals{RowsRatio: 20.0 / 10000, FullScansRemoved: 1}
+	if !reflect.DeepEqual(signals, want) {
+		t.Errorf("signals = %+v, want %+v", signals, want)
+	}
+
+	signals = qa.comparePlans(index, fullScan, sql, "SELECT o.id FROM orders o JOIN customers c ON c.id = o.customer_id WHERE o.status = 'paid'")
+	want = &planSignals{RowsRatio: 10000.0 / 20, FullScansRemoved: -1, TablesChanged: true}
+	if !reflect.DeepEqual(signals, want) {
+		t.Errorf("signals of a worse rewrite = %+v, want %+v", signals, want)
+	}
+
+	// Without estimates for the original, rows are not compared
+	noEstimates := []PlanOperator{{Operator: "TableFullScan", AccessObject: "table:orders"}}
+	if signals := qa.comparePlans(noEstimates, index, sql, sql); signals.RowsRatio != 0 {
+		t.Errorf("rows ratio without estimates = %v", signals.RowsRatio)
+	}
+	if signals := qa.comparePlans(fullScan, nil, sql, sql); signals != nil {
+		t.Errorf("signals without the rewrite's plan = %+v, want nil", signals)
+	}
+}
+
+func TestConfidenceScoreFromPlans(t *testing.T) {
+	oe := &OptimizationEngine{}
+	qa := NewQueryAnalyzer()
+	fullScan := parsePlan(explainResult(t, fullScanExplain))
+	index := parsePlan(explainResult(t, indexExplain))
+	const sql = "SELECT id FROM orders WHERE status = 'paid'"
+	pattern := QueryPattern{Complexity: "complex"}
+	// Long explanations, which only the heuristic rewards
+	response := &LLMResponse{Rationale: strings.Repeat("r", 60), ExpectedPlanChange: strings.Repeat("p", 60)}
+
+	tests := []struct {
+		name       string
+		signals    *planSignals
+		wantScore  float64
+		wantSource string
+	}{
+		// 0.5 base, -0.1 complex, +0.1 rationale, +0.1 plan change
+		{"no plans", nil, 0.6, ConfidenceHeuristic},
+		// 0.5 base, -0.1 complex, +0.2 for reading 0.2% of the rows, +0.1
+		// for the full scan removed
+		{"better plan", qa.comparePlans(fullScan, index, sql, sql), 0.4 + 0.2*(1-0.002) + 0.1, ConfidencePlan},
+		// Reading more rows, with another full scan and other tables, takes
+		// every penalty and clamps to 0.1
+		{"worse plan", qa.comparePlans(index, fullScan, sql, "SELECT id FROM customers"), 0.1, ConfidencePlan},
+		{"same plan", qa.comparePlans(fullScan, fullScan, sql, sql), 0.4, ConfidencePlan},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, source := oe.calculateConfidenceScore(pattern, response, tt.signals)
+			if math.Abs(score-tt.wantScore) > 1e-9 || source != tt.wantSource {
+				t.Errorf("score = %v from %s, want %v from %s", score, source, tt.wantScore, tt.wantSource)
+			}
+		})
+	}
+}
@@ -0,0 +1,384 @@
+package analyze
+
+import (
+	"sort"
+	"strings"
+)
+
+// predicateIssues are the comparisons in WHERE and ON conditions that keep
+// an index from being used
+type predicateIssues struct {
+	// functions are the functions applied to a compared column, such as DATE
+	// in DATE(created_at) = '2024-01-01', upper-cased and sorted
+	functions []string
+	// implicitConversion is set when a column whose name suggests it holds
+	// strings is compared to a number, which converts the column on every row
+	implicitConversion bool
+	// orAcrossColumns is set when OR combines comparisons of different
+	// columns, which one index range cannot serve
+	orAcrossColumns bool
+}
+
+// comparisonOperators compare the operands around them
+var comparisonOperators = map[string]bool{
+	"=": true, "<=>": true, "<>": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true,
+	"LIKE": true, "IN": true, "BETWEEN": true, "REGEXP": true, "RLIKE": true,
+}
+
+// intervalUnits appear as words in function arguments without being columns
+var intervalUnits = map[string]bool{
+	"MICROSECOND": true, "SECOND": true, "MINUTE": true, "HOUR": true, "DAY": true,
+	"WEEK": true, "MONTH": true, "QUARTER": true, "YEAR": true,
+}
+
+// textColumnWords are parts of column names, split at underscores, that
+// suggest a string column, as in customer_email or varchar_col
+var textColumnWords = map[string]bool{
+	"name": true, "username": true, "login": true, "email": true, "phone": true, "mobile": true,
+	"code": true, "sku": true, "uuid": true, "guid": true, "hash": true, "token": true, "slug": true,
+	"zip": true, "postcode": true, "isbn": true, "ean": true, "upc": true, "serial": true, "iban": true,
+	"str": true, "string": true, "text": true, "char": true, "varchar": true,
+}
+
+// findPredicateIssues reads the WHERE and ON conditions of sql, including
+// those of subqueries
+func findPredicateIssues(sql string) predicateIssues {
+	tokens := tokenizeSQL(sql)
+	in := predicateTokens(tokens)
+	opening := make([]int, len(tokens))
+	var open []int
+	for i, tok := range tokens {
+		switch {
+		case tok.text == "(":
+			open = append(open, i)
+		case tok.text == ")" && len(open) > 0:
+			opening[i] = open[len(open)-1]
+			open = open[:len(open)-1]
+		default:
+			opening[i] = -1
+		}
+	}
+
+	var issues predicateIssues
+	functions := map[string]bool{}
+	groups := map[int]bool{}
+	for k, tok := range tokens {
+		if !in[k] {
+			continue
+		}
+		if tok.text == "OR" || tok.text == "||" {
+			lo, hi := predicateGroup(tokens, in, k)
+			if !groups[lo] {
+				groups[lo] = true
+				issues.orAcrossColumns = issues.orAcrossColumns || branchesCompareDifferentColumns(tokens, in, lo, hi, tok.depth)
+			}
+			continue
+		}
+		if !isComparison(tok) || k == 0 || k+1 >= len(tokens) {
+			continue
+		}
+
+		left := k - 1
+		if tokens[left].text == "NOT" && left > 0 {
+			left--
+		}
+		if fn, ok := columnFunction(tokens, opening, left); ok {
+			functions[fn] = true
+		} else if tokens[left].kind == tokenLiteral && k+2 < len(tokens) && tokens[k+2].text == "(" {
+			if fn, ok := columnFunction(tokens, opening, closingParen(tokens, k+2)); ok {
+				functions[fn] = true
+			}
+		}
+
+		if tok.kind == tokenPunct || tok.text == "IN" || tok.text == "BETWEEN" {
+			right := k + 1
+			if tokens[right].text == "(" && right+1 < len(tokens) {
+				right++
+			}
+			column := right
+			if column+2 < len(tokens) && tokens[column+1].text == "." {
+				column += 2
+			}
+			if isTextColumn(tokens, left) && isNumber(tokens, right) || isNumber(tokens, left) && isTextColumn(tokens, column) {
+				issues.implicitConversion = true
+			}
+		}
+	}
+
+	for fn := range functions {
+		issues.functions = append(issues.functions, fn)
+	}
+	sort.Strings(issues.functions)
+	return issues
+}
+
+// predicateTokens marks the tokens that belong to a WHERE or ON condition.
+// A condition ends at the next clause or join at its depth; parentheses
+// inherit it unless they hold a subquery, whose own WHERE starts another.
+func predicateTokens(tokens []sqlToken) []bool {
+	in := make([]bool, len(tokens))
+	active := map[int]bool{}
+	for i, tok := range tokens {
+		word := tok.text
+		switch {
+		case tok.kind == tokenKeyword && word == "WHERE":
+			active[tok.depth] = true
+			continue
+		case tok.kind == tokenKeyword && word == "ON":
+			// ON DUPLICATE KEY UPDATE assigns instead of comparing
+			active[tok.depth] = i+1 >= len(tokens) || !strings.EqualFold(tokens[i+1].text, "DUPLICATE")
+			continue
+		case tok.kind == tokenKeyword && (clauseTerminators[word] || joinStartWords[word]) || tok.text == ",":
+			active[tok.depth] = false
+			continue
+		case tok.text == "(":
+			active[tok.depth+1] = active[tok.depth]
+		}
+		in[i] = active[tok.depth]
+	}
+	return in
+}
+
+// predicateGroup returns the bounds of the condition or parenthesized part of
+// one around the OR at k
+func predicateGroup(tokens []sqlToken, in []bool, k int) (int, int) {
+	depth := tokens[k].depth
+	inGroup := func(i int) bool {
+		return tokens[i].depth > depth || tokens[i].depth == depth && in[i]
+	}
+	lo, hi := k, k+1
+	for lo > 0 && inGroup(lo-1) {
+		lo--
+	}
+	for hi < len(tokens) && inGroup(hi) {
+		hi++
+	}
+	return lo, hi
+}
+
+// branchesCompareDifferentColumns splits tokens[lo:hi] at the ORs at depth
+// and reports whether its branches compare different sets of columns, as in
+// a = 1 OR b = 2 but not a = 1 OR a = 2. A function compared counts for the
+// columns it is applied to, as in DATE(a) = '2024-01-01' OR b = 2.
+func branchesCompareDifferentColumns(tokens []sqlToken, in []bool, lo, hi, depth int) bool {
+	var first string
+	columns := map[string]bool{}
+	differ := false
+	flush := func() {
+		if len(columns) == 0 {
+			return
+		}
+		names := make([]string, 0, len(columns))
+		for name := range columns {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		set := strings.Join(names, ",")
+		if first == "" {
+			first = set
+		} else if set != first {
+			differ = true
+		}
+		columns = map[string]bool{}
+	}
+
+	for i := lo; i < hi; i++ {
+		tok := tokens[i]
+		if tok.depth == depth && (tok.text == "OR" || tok.text == "||") {
+			flush()
+			continue
+		}
+		if in[i] && (isComparison(tok) || tok.text == "IS") && i > lo {
+			left := i - 1
+			if tokens[left].text == "NOT" && left > lo {
+				left--
+			}
+			if name := columnName(tokens, left); name != "" {
+				columns[name] = true
+			} else if open := openingParen(tokens, left); open > lo {
+				for j := open + 1; j < left; j++ {
+					if name := columnName(tokens, j); name != "" && !intervalUnits[tokens[j].text] && tokens[j+1].text != "." {
+						columns[name] = true
+					}
+				}
+			}
+		}
+	}
+	flush()
+	return differ
+}
+
+func isComparison(tok sqlToken) bool {
+	return (tok.kind == tokenPunct || tok.kind == tokenKeyword) && comparisonOperators[tok.text]
+}
+
+// columnFunction reports the function whose call ends at the ")" at end when
+// one of its arguments is a column, as in DATE(created_at)
+func columnFunction(tokens []sqlToken, opening []int, end int) (string, bool) {
+	if end < 0 || tokens[end].text != ")" || opening[end] < 1 {
+		return "", false
+	}
+	open := opening[end]
+	fn := tokens[open-1]
+	if fn.kind != tokenWord {
+		return "", false
+	}
+	for i := open + 1; i < end; i++ {
+		tok := tokens[i]
+		if tok.text == "SELECT" {
+			return "", false
+		}
+		if isIdentifier(tok) && !intervalUnits[tok.text] && (i+1 >= len(tokens) || tokens[i+1].text != "(") {
+			return fn.text, true
+		}
+	}
+	return "", false
+}
+
+// openingParen returns the index of the "(" matching the ")" at close, -1
+// when tokens[close] is not one or has no match
+func openingParen(tokens []sqlToken, close int) int {
+	if close < 0 || tokens[close].text != ")" {
+		return -1
+	}
+	depth := tokens[close].depth
+	for i := close - 1; i >= 0; i-- {
+		if tokens[i].text == "(" && tokens[i].depth == depth {
+			return i
+		}
+	}
+	return -1
+}
+
+// closingParen returns the index of the ")" matching the "(" at open, -1
+// when there is none
+func closingParen(tokens []sqlToken, open int) int {
+	depth := tokens[open].depth
+	for i := open + 1; i < len(tokens); i++ {
+		if tokens[i].text == ")" && tokens[i].depth == depth {
+			return i
+		}
+	}
+	return -1
+}
+
+// columnName returns the lower-cased, possibly qualified column at i, "" when
+// tokens[i] is not a column reference
+func columnName(tokens []sqlToken, i int) string {
+	if i < 0 || !isIdentifier(tokens[i]) || i+1 < len(tokens) && tokens[i+1].text == "(" {
+		return ""
+	}
+	name := strings.ToLower(identifierName(tokens[i]))
+	if i >= 2 && tokens[i-1].text == "." && isIdentifier(tokens[i-2]) {
+		name = strings.ToLower(identifierName(tokens[i-2])) + "." + name
+	}
+	return name
+}
+
+// isTextColumn reports whether the column at i has a name that suggests it
+// holds strings
+func isTextColumn(tokens []sqlToken, i int) bool {
+	name := columnName(tokens, i)
+	if name == "" {
+		return false
+	}
+	if dot := strings.LastIndexByte(name, '.'); dot >= 0 {
+		name = name[dot+1:]
+	}
+	for _, part := range strings.Split(name, "_") {
+		if textColumnWords[part] {
+			return true
+		}
+	}
+	return false
+}
+
+// isNumber reports whether tokens[i] is a numeric literal, possibly negative
+func isNumber(tokens []sqlToken, i int) bool {
+	if i < len(tokens) && tokens[i].text == "-" {
+		i++
+	}
+	if i >= len(tokens) || tokens[i].kind != tokenLiteral {
+		return false
+	}
+	c := tokens[i].text[0]
+	return c >= '0' && c <= '9' || c == '.'
+}
+
+// likePattern is the pattern operand of one LIKE, in order of appearance
+type likePattern struct {
+	// placeholder is set for a ? operand, whose pattern is only known from
+	// a sample with the literals in place
+	placeholder bool
+	// wildcard is set when the pattern has % or _, so LIKE is not an
+	// equality
+	wildcard bool
+	// leading is set when the pattern starts with %, which no index range
+	// can serve
+	leading bool
+}
+
+// searches reports whether the LIKE matches a pattern rather than a value,
+// assuming a placeholder is bound to one
+func (p likePattern) searches() bool {
+	return p.placeholder || p.wildcard
+}
+
+// findLikePatterns reads the operand of every LIKE and NOT LIKE of sql. A
+// pattern built by CONCAT is judged by its first argument, so
+// CONCAT('%', ?) has a leading wildcard.
+func findLikePatterns(sql string) []likePattern {
+	tokens := tokenizeSQL(sql)
+	var patterns []likePattern
+	for k, tok := range tokens {
+		if tok.text != "LIKE" || tok.kind != tokenKeyword || k+1 >= len(tokens) {
+			continue
+		}
+		operand := k + 1
+		if tokens[operand].text == "CONCAT" && operand+2 < len(tokens) && tokens[operand+1].text == "(" {
+			operand += 2
+		}
+		switch op := tokens[operand]; {
+		case op.text == "?":
+			patterns = append(patterns, likePattern{placeholder: true})
+		case op.kind == tokenLiteral && (op.text[0] == '\'' || op.text[0] == '"'):
+			value := op.text[1:]
+			patterns = append(patterns, likePattern{
+				wildcard: strings.ContainsAny(value, "%_") || tokens[k+1].text == "CONCAT",
+				leading:  strings.HasPrefix(value, "%"),
+			})
+		default:
+			// A column or expression: nothing is known of the pattern
+			patterns = append(patterns, likePattern{})
+		}
+	}
+	return patterns
+}
+
+// withSample fills in the placeholders of patterns from those of sample,
+// the LIKEs of the same statement with literals in place, when both have
+// as many
+func withSample(patterns, sample []likePattern) []likePattern {
+	if len(sample) != len(patterns) {
+		return patterns
+	}
+	filled := make([]likePattern, len(patterns))
+	for i, p := range patterns {
+		if p.placeholder && !sample[i].placeholder {
+			p = sample[i]
+		}
+		filled[i] = p
+	}
+	return filled
+}
+
+// countPlaceholders returns the number of ? parameters in sql
+func countPlaceholders(sql string) int {
+	n := 0
+	for _, tok := range tokenizeSQL(sql) {
+		if tok.text == "?" && tok.kind == tokenPunct {
+			n++
+		}
+	}
+	return n
+}
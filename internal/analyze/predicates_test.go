@@ -0,0 +1,126 @@
+package analyze
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/matthieukhl/latentia/internal/prompts"
+)
+
+// predicatePatterns are the anti-patterns findPredicateIssues drives
+var predicatePatterns = []string{"implicit-conversion", "function-on-indexed-column", "or-across-columns"}
+
+func TestDetectPredicateAntiPatterns(t *testing.T) {
+	tests := []struct {
+		name      string
+		sql       string
+		want      []string // of predicatePatterns
+		functions []string
+	}{
+		{"string column compared to a number", "SELECT id FROM users WHERE phone = 5551234", []string{"implicit-conversion"}, nil},
+		{"number on the left, qualified column", "SELECT id FROM products p WHERE 1042 = p.sku", []string{"implicit-conversion"}, nil},
+		{"string column in a list of numbers", "SELECT id FROM users WHERE zip_code IN (75001, 75002)", []string{"implicit-conversion"}, nil},
+		{"string column compared to a string", "SELECT id FROM users WHERE phone = '5551234'", nil, nil},
+		{"numeric column compared to a number", "SELECT id FROM users WHERE id = 123", nil, nil},
+		{"number outside a condition", "SELECT name FROM users WHERE id = 1 LIMIT 10", nil, nil},
+
+		{"date function", "SELECT id FROM orders WHERE DATE(created_at) = '2024-01-01'", []string{"function-on-indexed-column"}, []string{"DATE"}},
+		{"several functions", "SELECT id FROM orders o WHERE YEAR(o.created_at) = 2024 AND LOWER(email) = 'a@b.c'", []string{"function-on-indexed-column"}, []string{"LOWER", "YEAR"}},
+		{"literal on the left", "SELECT id FROM orders WHERE '2024' = YEAR(created_at)", []string{"function-on-indexed-column"}, []string{"YEAR"}},
+		{"function in a join condition", "SELECT o.id FROM orders o JOIN customers c ON LOWER(c.email) = o.email", []string{"function-on-indexed-column"}, []string{"LOWER"}},
+		{"function in a subquery", "SELECT id FROM users WHERE id IN (SELECT user_id FROM coupons WHERE UPPER(user_ref) = 'X1')", []string{"function-on-indexed-column"}, []string{"UPPER"}},
+		{"function on the value side", "SELECT id FROM orders WHERE created_at > DATE_SUB(NOW(), INTERVAL 1 DAY)", nil, nil},
+		{"function in the select list", "SELECT DATE(created_at) FROM orders WHERE id = 7", nil, nil},
+
+		{"or across columns", "SELECT id FROM orders WHERE status = 'paid' OR customer_id = 5", []string{"or-across-columns"}, nil},
+		{"or on one column", "SELECT id FROM orders WHERE status = 'paid' OR status = 'shipped'", nil, nil},
+		{"or of a function and another column", "SELECT id FROM orders o WHERE YEAR(o.created_at) = 2024 OR o.status = 'paid'", []string{"function-on-indexed-column", "or-across-columns"}, []string{"YEAR"}},
+		{"or of functions on one column", "SELECT id FROM orders WHERE DATE(created_at) = '2024-01-01' OR DATE(created_at) = '2024-01-02'", []string{"function-on-indexed-column"}, []string{"DATE"}},
+		{"or inside parentheses", "SELECT id FROM orders WHERE total > 10 AND (status = 'paid' OR region = 'eu')", []string{"or-across-columns"}, nil},
+
+		{"all three", "SELECT id FROM users WHERE DATE(created_at) = '2024-01-01' OR phone = 5551234", predicatePatterns, []string{"DATE"}},
+		{"upsert assignments", "INSERT INTO users (id, phone) VALUES (1, '555') ON DUPLICATE KEY UPDATE phone = 5551234", nil, nil},
+	}
+	qa := NewQueryAnalyzer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern := qa.AnalyzeQuery(tt.sql)
+			var got []string
+			for _, p := range predicatePatterns {
+				if slices.Contains(pattern.AntiPatterns, p) {
+					got = append(got, p)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("anti-patterns = %q, want %q among %q", pattern.AntiPatterns, tt.want, predicatePatterns)
+			}
+			if !reflect.DeepEqual(pattern.FilterFunctions, tt.functions) {
+				t.Errorf("filter functions = %q, want %q", pattern.FilterFunctions, tt.functions)
+			}
+			// function-in-where only stands in when no function was tied to a
+			// column
+			if len(tt.functions) > 0 && slices.Contains(pattern.AntiPatterns, "function-in-where") {
+				t.Errorf("both function anti-patterns reported: %q", pattern.AntiPatterns)
+			}
+		})
+	}
+}
+
+// predicateHints is the optimization opportunity and the search hint of
+// each predicate anti-pattern
+var predicateHints = map[string][2]string{
+	"implicit-conversion":        {"match-literal-types", "implicit type conversion"},
+	"function-on-indexed-column": {"rewrite-as-column-range", "function on indexed column"},
+	"or-across-columns":          {"split-or-into-union", "IndexMerge"},
+}
+
+func TestPredicateAntiPatternsInPrompt(t *testing.T) {
+	sql := "SELECT id FROM users WHERE DATE(created_at) = '2024-01-01' OR phone = 5551234"
+	pattern := NewQueryAnalyzer().AnalyzeQuery(sql)
+	pb := &PromptBuilder{templates: prompts.MustDefault()}
+
+	search := pb.buildSearchQuery(pattern)
+	sections, err := pb.buildPromptSections(sql, pattern, "", "", nil, Feedback{}, false, false, false)
+	if err != nil {
+		t.Fatalf("buildPromptSections: %v", err)
+	}
+	var analysis string
+	for _, section := range sections {
+		if section.Name == "analysis" {
+			analysis = section.Content
+		}
+	}
+	if analysis == "" {
+		t.Fatalf("no analysis section in %+v", sections)
+	}
+
+	for anti, hint := range predicateHints {
+		opportunity, searchHint := hint[0], hint[1]
+		if !slices.Contains(pattern.OptimizationOps, opportunity) {
+			t.Errorf("%s: opportunities %q lack %s", anti, pattern.OptimizationOps, opportunity)
+		}
+		if !strings.Contains(search, searchHint) {
+			t.Errorf("%s: search query %q lacks %q", anti, search, searchHint)
+		}
+		if !strings.Contains(analysis, anti) || !strings.Contains(analysis, opportunity) {
+			t.Errorf("%s: analysis section lacks it or %s:\n%s", anti, opportunity, analysis)
+		}
+	}
+	if !strings.Contains(analysis, "Functions applied to filtered columns: DATE\n") {
+		t.Errorf("analysis section does not name the function:\n%s", analysis)
+	}
+
+	// Without a function on a column the line is left out
+	pattern = NewQueryAnalyzer().AnalyzeQuery("SELECT id FROM users WHERE phone = 5551234")
+	sections, err = pb.buildPromptSections(sql, pattern, "", "", nil, Feedback{}, false, false, false)
+	if err != nil {
+		t.Fatalf("buildPromptSections: %v", err)
+	}
+	for _, section := range sections {
+		if strings.Contains(section.Content, "Functions applied") {
+			t.Errorf("section %s names functions of a query without any:\n%s", section.Name, section.Content)
+		}
+	}
+}
@@ -3,44 +3,270 @@ package analyze
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/metrics"
+	"github.com/matthieukhl/latentia/internal/prompts"
 	"github.com/matthieukhl/latentia/internal/rag"
+	"github.com/matthieukhl/latentia/internal/safety"
 )
 
 // PromptBuilder creates context-aware optimization prompts using RAG
 type PromptBuilder struct {
-	docStore *rag.DocumentStore
+	docStore    *rag.DocumentStore
+	identifiers safety.IdentifierStore
+	schemas     *database.SchemaIntrospector
+	templates   *prompts.Set
+	jsonMode    bool
 }
 
-func NewPromptBuilder(docStore *rag.DocumentStore) *PromptBuilder {
+// PromptSection is one named block of an assembled prompt
+type PromptSection struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// Prompt is the structured form of an optimization prompt. String() yields
+// the exact text sent to the generator.
+type Prompt struct {
+	SearchQuery string             `json:"search_query"`
+	Context     []rag.SearchResult `json:"context"`
+	Sections    []PromptSection    `json:"sections"`
+
+	// Template and TemplateHash identify the prompt templates used
+	Template     string `json:"template"`
+	TemplateHash string `json:"template_hash"`
+
+	// Style is the llm.prompt_style the prompt was rendered in, detailed
+	// for queries that are not simple, and Version identifies its
+	// instructions so rewrites compare by prompt version
+	Style   string `json:"style"`
+	Version string `json:"version"`
+
+	// Redaction is set when safety.redact_literals replaced the literals of
+	// the SQL in the prompt; it restores them in the proposed SQL
+	Redaction *safety.Redaction `json:"-"`
+
+	// Anonymization is set when safety.anonymize_identifiers replaced the
+	// identifiers of the SQL in the prompt; it restores them likewise
+	Anonymization *safety.Anonymization `json:"-"`
+
+	// JSONMode is set when the prompt asks for a JSON object rather than
+	// the marker format
+	JSONMode bool `json:"json_mode"`
+}
+
+// String concatenates all sections into the final prompt text
+func (p *Prompt) String() string {
+	var b strings.Builder
+	for _, section := range p.Sections {
+		b.WriteString(section.Content)
+	}
+	return b.String()
+}
+
+// EstimatedTokens returns the approximate token count of the whole prompt
+func (p *Prompt) EstimatedTokens() int {
+	total := 0
+	for _, section := range p.Sections {
+		total += EstimateTokens(section.Content)
+	}
+	return total
+}
+
+// EstimateTokens approximates the number of LLM tokens in text using the
+// common ~4 characters per token rule of thumb
+func EstimateTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	return (len(text) + 3) / 4
+}
+
+// NewPromptBuilder renders prompts with the templates from llm.templates_dir.
+// LoadConfig has already validated them; should they have become unreadable
+// since, the embedded defaults are used instead. identifiers provides the
+// aliases used under safety.anonymize_identifiers.
+func NewPromptBuilder(docStore *rag.DocumentStore, identifiers safety.IdentifierStore) *PromptBuilder {
+	templates, err := prompts.Load(config.Current().LLM.TemplatesDir)
+	if err != nil {
+		slog.Error("failed to load prompt templates, using defaults", "error", err)
+		templates = prompts.MustDefault()
+	}
+	
 	return &PromptBuilder{
-		docStore: docStore,
+		docStore:    docStore,
+		identifiers: identifiers,
+		templates:   templates,
 	}
 }
 
+// WithJSONMode makes the prompts ask for a JSON object with the format_json
+// section, for generators that can guarantee one
+func (pb *PromptBuilder) WithJSONMode(on bool) *PromptBuilder {
+	pb.jsonMode = on
+	return pb
+}
+
+// WithSchemas adds the definitions of the query's tables read by schemas to
+// the prompts, within llm.schema.max_chars. They are left out under
+// safety.anonymize_identifiers, which they would defeat.
+func (pb *PromptBuilder) WithSchemas(schemas *database.SchemaIntrospector) *PromptBuilder {
+	pb.schemas = schemas
+	return pb
+}
+
 // BuildOptimizationPrompt creates a comprehensive prompt for SQL optimization
 func (pb *PromptBuilder) BuildOptimizationPrompt(sql string, pattern QueryPattern) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	prompt, err := pb.BuildPrompt(context.Background(), sql, pattern)
+	if err != nil {
+		return "", err
+	}
+	
+	return prompt.String(), nil
+}
+
+// Feedback is a reviewer's rejection of a previous rewrite, shown to the
+// model when optimizing the same SQL again
+type Feedback struct {
+	PreviousSQL string
+	Reason      string
+}
+
+// BuildPrompt assembles the optimization prompt and returns it section by
+// section. With safety.redact_literals, the SQL in the prompt has its literals
+// replaced by placeholders, and with safety.anonymize_identifiers its
+// identifiers and the pattern's tables by aliases; the pattern is expected to
+// come from the original SQL, which never leaves the process.
+func (pb *PromptBuilder) BuildPrompt(ctx context.Context, sql string, pattern QueryPattern) (*Prompt, error) {
+	return pb.BuildRetryPrompt(ctx, sql, pattern, nil)
+}
+
+// BuildRetryPrompt is BuildPrompt with the feedback section showing the
+// rejected rewrite and why it was rejected, when feedback is not nil. The
+// rejected SQL is redacted and anonymized like the original; the reason is
+// sent as the reviewer wrote it.
+func (pb *PromptBuilder) BuildRetryPrompt(ctx context.Context, sql string, pattern QueryPattern, feedback *Feedback) (*Prompt, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 	
+	var previous Feedback
+	if feedback != nil {
+		previous = *feedback
+	}
+	
+	// Read with the real table names, before they are anonymized
+	var schema string
+	if maxChars := config.Current().LLM.Schema.MaxChars; pb.schemas != nil && maxChars > 0 && !config.Current().Safety.AnonymizeIdentifiers {
+		schema = schemaContext(ctx, pb.schemas, pattern.Tables, pattern.Views, maxChars)
+	}
+	
+	// The plan names tables and indexes and shows literals in operator
+	// info, so it is left out when either is hidden
+	var plan string
+	if maxBytes := config.Current().LLM.Schema.PlanMaxBytes; maxBytes > 0 && !config.Current().Safety.AnonymizeIdentifiers && !config.Current().Safety.RedactLiterals {
+		plan = TruncatePlan(pattern.ActualPlan, maxBytes)
+	}
+	
+	// Identifiers first, so literals are still quoted and left alone
+	var anonymization *safety.Anonymization
+	if config.Current().Safety.AnonymizeIdentifiers {
+		if pb.identifiers == nil {
+			return nil, fmt.Errorf("safety.anonymize_identifiers requires the app database")
+		}
+		var err error
+		sql, anonymization, err = safety.AnonymizeIdentifiers(ctx, pb.identifiers, sql)
+		if err != nil {
+			return nil, err
+		}
+		pattern.Tables = anonymization.Tables()
+		if previous.PreviousSQL != "" {
+			var previousAnonymization *safety.Anonymization
+			previous.PreviousSQL, previousAnonymization, err = safety.AnonymizeIdentifiers(ctx, pb.identifiers, previous.PreviousSQL)
+			if err != nil {
+				return nil, err
+			}
+			anonymization.Merge(previousAnonymization)
+		}
+	}
+	
+	var redaction *safety.Redaction
+	if config.Current().Safety.RedactLiterals {
+		sql, redaction = safety.RedactLiterals(sql)
+		if previous.PreviousSQL != "" {
+			previous.PreviousSQL = redaction.Redact(previous.PreviousSQL)
+		}
+	}
+	
+	searchQuery, context, err := pb.RetrieveContext(ctx, pattern)
+	if err != nil {
+		return nil, err
+	}
+	
+	style := promptStyle(pattern)
+	sections, err := pb.buildPromptSections(sql, pattern, schema, plan, context, previous, redaction != nil, anonymization != nil, style == prompts.StyleConcise)
+	if err != nil {
+		return nil, err
+	}
+	
+	return &Prompt{
+		SearchQuery:  searchQuery,
+		Context:      context,
+		Sections:     sections,
+		Template:     pb.templates.Name(),
+		TemplateHash: pb.templates.Hash(),
+		Style:        style,
+		Version:      pb.templates.Version(style),
+		Redaction:     redaction,
+		Anonymization: anonymization,
+		JSONMode:      pb.jsonMode,
+	}, nil
+}
+
+// RetrieveContext runs the RAG search for a pattern and returns the search
+// query used along with the matching documentation chunks
+func (pb *PromptBuilder) RetrieveContext(ctx context.Context, pattern QueryPattern) (string, []rag.SearchResult, error) {
 	// Build search query based on pattern analysis
 	searchQuery := pb.buildSearchQuery(pattern)
 	
 	// Retrieve relevant documentation context
-	context, err := pb.docStore.Search(ctx, searchQuery, 3)
+	timer := metrics.StartStage(metrics.StageRetrieval)
+	opts := rag.NewSearchOptions(3)
+	if category := patternCategory(pattern); category != "" {
+		opts.PreferredCategories = []string{category}
+	}
+	results, err := pb.docStore.Search(ctx, searchQuery, opts)
+	timer.Done()
 	if err != nil {
-		return "", fmt.Errorf("failed to retrieve context: %w", err)
+		return searchQuery, nil, fmt.Errorf("failed to retrieve context: %w", err)
 	}
 	
-	// Build the complete prompt
-	prompt := pb.buildPromptTemplate(sql, pattern, context)
-	
-	return prompt, nil
+	return searchQuery, results, nil
 }
 
-// buildSearchQuery creates a search query based on the detected pattern
+// patternCategory is the category of the seeded documentation about the
+// pattern's query type, preferred when retrieving its context
+func patternCategory(pattern QueryPattern) string {
+	switch pattern.Type {
+	case "complex-join", "simple-join":
+		return "joins"
+	case "aggregation":
+		return "aggregation"
+	case "pattern-search":
+		return "indexes"
+	case "update", "delete", "insert":
+		return "dml"
+	default:
+		return ""
+	}
+}
+
+// buildSearchQuery creates a search query based on the detected pattern. It
+// is made of fixed terms only, so no literal from the SQL reaches the embedder.
 func (pb *PromptBuilder) buildSearchQuery(pattern QueryPattern) string {
 	queryParts := []string{}
 	
@@ -54,6 +280,8 @@ func (pb *PromptBuilder) buildSearchQuery(pattern QueryPattern) string {
 		queryParts = append(queryParts, "LIKE pattern search index optimization")
 	case "full-select":
 		queryParts = append(queryParts, "SELECT * column projection optimization")
+	case "update", "delete", "insert":
+		queryParts = append(queryParts, "DML UPDATE DELETE batch write performance")
 	default:
 		queryParts = append(queryParts, "SQL query optimization performance")
 	}
@@ -61,6 +289,8 @@ func (pb *PromptBuilder) buildSearchQuery(pattern QueryPattern) string {
 	// Add anti-patterns to search
 	for _, antiPattern := range pattern.AntiPatterns {
 		switch antiPattern {
+		case "missing-where":
+			queryParts = append(queryParts, "full table UPDATE DELETE batching")
 		case "leading-wildcard-like":
 			queryParts = append(queryParts, "wildcard LIKE index")
 		case "cartesian-join":
@@ -69,6 +299,12 @@ func (pb *PromptBuilder) buildSearchQuery(pattern QueryPattern) string {
 			queryParts = append(queryParts, "LIMIT result set")
 		case "subquery-instead-of-join":
 			queryParts = append(queryParts, "subquery JOIN conversion")
+		case "function-on-indexed-column":
+			queryParts = append(queryParts, "function on indexed column expression index range")
+		case "implicit-conversion":
+			queryParts = append(queryParts, "implicit type conversion string number comparison index")
+		case "or-across-columns":
+			queryParts = append(queryParts, "OR condition IndexMerge UNION")
 		}
 	}
 	
@@ -80,88 +316,51 @@ func (pb *PromptBuilder) buildSearchQuery(pattern QueryPattern) string {
 	return strings.Join(queryParts, " ")
 }
 
-// buildPromptTemplate constructs the complete optimization prompt
-func (pb *PromptBuilder) buildPromptTemplate(sql string, pattern QueryPattern, context []rag.SearchResult) string {
-	var prompt strings.Builder
-	
-	// System context
-	prompt.WriteString("You are a TiDB performance expert specializing in SQL optimization. ")
-	prompt.WriteString("Analyze the provided slow query and suggest concrete optimizations.\n\n")
-	
-	// Query information
-	prompt.WriteString("SLOW QUERY ANALYSIS:\n")
-	prompt.WriteString(fmt.Sprintf("Query Type: %s\n", pattern.Type))
-	prompt.WriteString(fmt.Sprintf("Complexity: %s\n", pattern.Complexity))
-	prompt.WriteString(fmt.Sprintf("Tables: %s\n", strings.Join(pattern.Tables, ", ")))
-	
-	if len(pattern.AntiPatterns) > 0 {
-		prompt.WriteString(fmt.Sprintf("Anti-patterns detected: %s\n", strings.Join(pattern.AntiPatterns, ", ")))
+// promptStyle is llm.prompt_style for queries of simple complexity; the
+// others always get the detailed prompt
+func promptStyle(pattern QueryPattern) string {
+	if config.Current().LLM.PromptStyle == prompts.StyleConcise && pattern.Complexity == "simple" {
+		return prompts.StyleConcise
 	}
-	
-	if len(pattern.OptimizationOps) > 0 {
-		prompt.WriteString(fmt.Sprintf("Optimization opportunities: %s\n", strings.Join(pattern.OptimizationOps, ", ")))
+	return prompts.StyleDetailed
+}
+
+// buildPromptSections renders the optimization prompt as ordered sections
+func (pb *PromptBuilder) buildPromptSections(sql string, pattern QueryPattern, schema, plan string, context []rag.SearchResult, feedback Feedback, redacted, anonymized, concise bool) ([]PromptSection, error) {
+	docs := make([]prompts.Doc, len(context))
+	for i, result := range context {
+		docs[i] = prompts.Doc{Document: result.Document, Category: result.Category, Text: result.Text, URL: result.URL}
 	}
-	prompt.WriteString("\n")
 	
-	// Original query
-	prompt.WriteString("ORIGINAL QUERY:\n```sql\n")
-	prompt.WriteString(sql)
-	prompt.WriteString("\n```\n\n")
-	
-	// Relevant documentation context
-	if len(context) > 0 {
-		prompt.WriteString("RELEVANT TIDB OPTIMIZATION KNOWLEDGE:\n")
-		for i, result := range context {
-			prompt.WriteString(fmt.Sprintf("%d. %s (%s)\n", i+1, result.Document, result.Category))
-			prompt.WriteString(fmt.Sprintf("   %s\n\n", result.Text))
-		}
+	rendered, err := pb.templates.Render(prompts.Data{
+		SQL: sql,
+		Pattern: prompts.Pattern{
+			Type:            pattern.Type,
+			Complexity:      pattern.Complexity,
+			Tables:          pattern.Tables,
+			AntiPatterns:    pattern.AntiPatterns,
+			OptimizationOps: pattern.OptimizationOps,
+			Keywords:        pattern.Keywords,
+			FilterFunctions: pattern.FilterFunctions,
+			HighSeverity:    pattern.HighSeverity(),
+		},
+		Schema:      schema,
+		Plan:        plan,
+		Context:     docs,
+		Feedback:    feedback.Reason,
+		PreviousSQL: feedback.PreviousSQL,
+		Redacted:    redacted,
+		Anonymized:  anonymized,
+		JSONMode:    pb.jsonMode,
+		Concise:     concise,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render prompt: %w", err)
 	}
 	
-	// Instructions
-	prompt.WriteString("INSTRUCTIONS:\n")
-	prompt.WriteString("Based on the query analysis and TiDB optimization knowledge above, provide a comprehensive optimization.\n")
-	prompt.WriteString("Focus on the detected anti-patterns and optimization opportunities.\n\n")
-	
-	// Response format
-	prompt.WriteString("FORMAT YOUR RESPONSE EXACTLY AS FOLLOWS:\n\n")
-	prompt.WriteString("PROPOSED_SQL:\n```sql\n[Your optimized query here]\n```\n\n")
-	prompt.WriteString("RATIONALE:\n")
-	prompt.WriteString("• [Primary optimization applied]\n")
-	prompt.WriteString("• [Secondary improvements made]\n")
-	prompt.WriteString("• [Why this approach was chosen]\n\n")
-	prompt.WriteString("EXPECTED_PLAN_CHANGE:\n")
-	prompt.WriteString("• [Index usage improvements]\n")
-	prompt.WriteString("• [Join order optimizations]\n")
-	prompt.WriteString("• [Row reduction techniques]\n\n")
-	prompt.WriteString("CAVEATS:\n")
-	prompt.WriteString("• [Any semantic differences]\n")
-	prompt.WriteString("• [Performance assumptions made]\n")
-	prompt.WriteString("• [Edge cases to monitor]\n\n")
-	
-	// Specific guidance based on pattern
-	prompt.WriteString("OPTIMIZATION FOCUS:\n")
-	switch pattern.Type {
-	case "complex-join", "simple-join":
-		prompt.WriteString("- Optimize JOIN order and algorithms\n")
-		prompt.WriteString("- Ensure proper index usage on join columns\n")
-		prompt.WriteString("- Consider converting subqueries to JOINs\n")
-	case "aggregation":
-		prompt.WriteString("- Optimize GROUP BY and ORDER BY performance\n")
-		prompt.WriteString("- Use appropriate indexes for aggregation\n")
-		prompt.WriteString("- Consider pre-filtering with WHERE clauses\n")
-	case "pattern-search":
-		prompt.WriteString("- Optimize LIKE patterns for index usage\n")
-		prompt.WriteString("- Avoid leading wildcards when possible\n")
-		prompt.WriteString("- Consider full-text search alternatives\n")
-	case "sleep-test":
-		prompt.WriteString("- Remove artificial delays (SLEEP functions)\n")
-		prompt.WriteString("- Replace with efficient query patterns\n")
-		prompt.WriteString("- Ensure minimal resource usage\n")
-	default:
-		prompt.WriteString("- Apply general SQL optimization principles\n")
-		prompt.WriteString("- Focus on index usage and query structure\n")
-		prompt.WriteString("- Minimize data processing overhead\n")
+	sections := make([]PromptSection, len(rendered))
+	for i, section := range rendered {
+		sections[i] = PromptSection{Name: section.Name, Content: section.Content}
 	}
-	
-	return prompt.String()
-}
\ No newline at end of file
+	return sections, nil
+}
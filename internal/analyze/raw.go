@@ -0,0 +1,57 @@
+package analyze
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/matthieukhl/latentia/internal/config"
+)
+
+// rawExchange returns the prompt and completion to store with a rewrite,
+// both empty when llm.store_raw is off
+func rawExchange(prompt, response string) (string, string) {
+	llmCfg := config.Current().LLM
+	if !llmCfg.StoreRaw {
+		return "", ""
+	}
+	return truncateRaw(prompt, llmCfg.StoreRawMaxBytes), truncateRaw(response, llmCfg.StoreRawMaxBytes)
+}
+
+// truncateRaw cuts s to at most maxBytes on a rune boundary, ending with a
+// note of how much was dropped
+func truncateRaw(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	note := fmt.Sprintf("\n-- truncated, %d bytes total", len(s))
+	cut := maxBytes - len(note)
+	if cut < 0 {
+		return ""
+	}
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + note
+}
+
+// LoadRawExchange fills PromptText and RawResponse of result from the
+// database. They are left out of GetOptimizationByID and the listings to
+// keep those light; both stay empty for rewrites stored with
+// llm.store_raw off.
+func (oe *OptimizationEngine) LoadRawExchange(ctx context.Context, result *OptimizationResult) error {
+	var promptText, rawResponse sql.NullString
+	err := oe.db.QueryRowContext(ctx,
+		`SELECT prompt_text, raw_response FROM app_rewrites WHERE id = ?`, result.ID,
+	).Scan(&promptText, &rawResponse)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load raw exchange: %w", err)
+	}
+	result.PromptText = promptText.String
+	result.RawResponse = rawResponse.String
+	return nil
+}
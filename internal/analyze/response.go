@@ -0,0 +1,191 @@
+package analyze
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/matthieukhl/latentia/internal/database"
+)
+
+// responseSchema describes the JSON object format_json asks for, for
+// providers that take a schema
+var responseSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"proposed_sql":         map[string]any{"type": "string", "description": "The optimized query"},
+		"rationale":            map[string]any{"type": "string"},
+		"expected_plan_change": map[string]any{"type": "string"},
+		"caveats":              map[string]any{"type": "string"},
+		"recommended_indexes": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"table":     map[string]any{"type": "string"},
+					"columns":   map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+					"type":      map[string]any{"type": "string", "enum": []string{database.IndexTypeIndex, database.IndexTypeUnique}},
+					"rationale": map[string]any{"type": "string"},
+				},
+				"required": []string{"table", "columns"},
+			},
+		},
+	},
+	"required": []string{"proposed_sql", "rationale", "expected_plan_change", "caveats"},
+}
+
+// responseFormat names the format a prompt asked for in rewrite metadata
+func responseFormat(jsonMode bool) string {
+	if jsonMode {
+		return "json"
+	}
+	return "text"
+}
+
+// parseJSONResponse reads a response in the format_json shape. The object
+// may be wrapped in a Markdown fence or surrounded by prose, and fields may
+// be lists of strings instead of strings.
+func parseJSONResponse(response string) (*LLMResponse, error) {
+	start, end := strings.Index(response, "{"), strings.LastIndex(response, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no complete JSON object in response")
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(response[start:end+1]), &fields); err != nil {
+		return nil, fmt.Errorf("invalid JSON response: %w", err)
+	}
+
+	parsed := &LLMResponse{}
+	for name, dest := range map[string]*string{
+		"proposed_sql":         &parsed.ProposedSQL,
+		"rationale":            &parsed.Rationale,
+		"expected_plan_change": &parsed.ExpectedPlanChange,
+		"caveats":              &parsed.Caveats,
+	} {
+		raw, ok := fields[name]
+		if !ok {
+			continue
+		}
+		value, err := jsonText(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in JSON response: %w", name, err)
+		}
+		*dest = value
+	}
+
+	if raw, ok := fields["recommended_indexes"]; ok {
+		parsed.RecommendedIndexes = jsonIndexes(raw)
+	}
+
+	parsed.ProposedSQL = stripSQLFence(parsed.ProposedSQL)
+	if parsed.ProposedSQL == "" {
+		return nil, fmt.Errorf("JSON response has no proposed_sql")
+	}
+	return parsed, nil
+}
+
+// jsonIndexes reads recommended_indexes, dropping entries that do not name
+// a table and plain columns
+func jsonIndexes(raw json.RawMessage) []database.IndexRecommendation {
+	var entries []struct {
+		Table     string          `json:"table"`
+		Columns   json.RawMessage `json:"columns"`
+		Type      string          `json:"type"`
+		Rationale json.RawMessage `json:"rationale"`
+	}
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil
+	}
+
+	var indexes []database.IndexRecommendation
+	for _, entry := range entries {
+		index := database.IndexRecommendation{Table: entry.Table, Type: entry.Type}
+		if err := json.Unmarshal(entry.Columns, &index.Columns); err != nil {
+			// A single column or a comma-separated list as one string
+			columns, err := jsonText(entry.Columns)
+			if err != nil {
+				continue
+			}
+			index.Columns = splitColumns(columns)
+		}
+		if len(entry.Rationale) > 0 {
+			index.Rationale, _ = jsonText(entry.Rationale)
+		}
+		if index.Normalize() == nil {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+// createIndexRegex matches a CREATE INDEX statement of the text format, with
+// an optional trailing -- comment taken as its rationale
+var createIndexRegex = regexp.MustCompile(`(?im)^\s*CREATE\s+(UNIQUE\s+)?INDEX\s+\S+\s+ON\s+([\w$.` + "`" + `]+)\s*\(([^)]*)\)[^\n]*?(?:--\s*(.*))?$`)
+
+// textIndexes reads the CREATE INDEX statements of a RECOMMENDED_INDEXES
+// section, dropping any that do not name a table and plain columns
+func textIndexes(section string) []database.IndexRecommendation {
+	var indexes []database.IndexRecommendation
+	for _, m := range createIndexRegex.FindAllStringSubmatch(section, -1) {
+		index := database.IndexRecommendation{
+			Table:     m[2],
+			Columns:   splitColumns(m[3]),
+			Rationale: strings.TrimSpace(m[4]),
+		}
+		if m[1] != "" {
+			index.Type = database.IndexTypeUnique
+		}
+		if index.Normalize() == nil {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+// splitColumns splits an index column list, dropping ASC and DESC
+func splitColumns(list string) []string {
+	var columns []string
+	for _, column := range strings.Split(list, ",") {
+		fields := strings.Fields(column)
+		if len(fields) == 0 {
+			continue
+		}
+		columns = append(columns, fields[0])
+	}
+	return columns
+}
+
+// jsonText reads a string, a list of strings joined by spaces, or null
+func jsonText(raw json.RawMessage) (string, error) {
+	var text *string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		if text == nil {
+			return "", nil
+		}
+		return strings.TrimSpace(*text), nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return "", fmt.Errorf("expected a string or a list of strings")
+	}
+	for i := range list {
+		list[i] = strings.TrimSpace(list[i])
+	}
+	return strings.Join(list, " "), nil
+}
+
+// stripSQLFence removes a ```sql fence the model put inside proposed_sql
+func stripSQLFence(sql string) string {
+	sql = strings.TrimSpace(sql)
+	if !strings.HasPrefix(sql, "```") {
+		return sql
+	}
+	sql = strings.TrimPrefix(sql, "```")
+	if i := strings.IndexByte(sql, '\n'); i >= 0 && !strings.ContainsAny(strings.TrimSpace(sql[:i]), " ;(") {
+		// Drop the ```sql language tag line
+		sql = sql[i+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(sql), "```"))
+}
@@ -0,0 +1,211 @@
+package analyze
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/matthieukhl/latentia/internal/database"
+)
+
+const jsonAnswer = `{
+  "proposed_sql": "SELECT id FROM orders WHERE created_at >= '2024-01-01'",
+  "rationale": "The range predicate can use idx_created_at.",
+  "expected_plan_change": "TableFullScan becomes IndexRangeScan.",
+  "caveats": "None."
+}`
+
+func TestParseLLMResponseJSON(t *testing.T) {
+	want := &LLMResponse{
+		ProposedSQL:        "SELECT id FROM orders WHERE created_at >= '2024-01-01'",
+		Rationale:          "The range predicate can use idx_created_at.",
+		ExpectedPlanChange: "TableFullScan becomes IndexRangeScan.",
+		Caveats:            "None.",
+	}
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{"bare object", jsonAnswer},
+		{"json fence", "```json\n" + jsonAnswer + "\n```"},
+		{"plain fence", "```\n" + jsonAnswer + "\n```\n"},
+		{"prose around", "Here is the optimized query:\n\n" + jsonAnswer + "\n\nLet me know if you need more."},
+		{"sql fence inside proposed_sql", strings.Replace(jsonAnswer,
+			`"SELECT id FROM orders WHERE created_at >= '2024-01-01'"`,
+			`"`+"```sql\\nSELECT id FROM orders WHERE created_at >= '2024-01-01'\\n```"+`"`, 1)},
+		{"lists of strings", `{
+  "proposed_sql": "SELECT id FROM orders WHERE created_at >= '2024-01-01'",
+  "rationale": ["The range predicate", "can use idx_created_at."],
+  "expected_plan_change": ["TableFullScan becomes IndexRangeScan."],
+  "caveats": " None. "
+}`},
+	}
+	oe := &OptimizationEngine{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := oe.parseLLMResponse(tt.response)
+			if err != nil {
+				t.Fatalf("parseLLMResponse: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("parseLLMResponse =\n%+v\nwant\n%+v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseLLMResponseJSONNullFields(t *testing.T) {
+	got, err := parseJSONResponse(`{"proposed_sql": "SELECT 1", "rationale": null}`)
+	if err != nil {
+		t.Fatalf("parseJSONResponse: %v", err)
+	}
+	if got.ProposedSQL != "SELECT 1" || got.Rationale != "" || got.Caveats != "" {
+		t.Errorf("parseJSONResponse = %+v", got)
+	}
+}
+
+func TestParseLLMResponseRefusesBrokenJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     string
+	}{
+		{
+			"truncated before the closing brace",
+			`{"proposed_sql": "SELECT id FROM orders WHERE created_at >= '2024-01-01'", "rationale": "The range pre`,
+			"no complete JSON object",
+		},
+		{
+			"truncated inside a fence",
+			"```json\n{\"proposed_sql\": \"SELECT id FROM ord",
+			"no complete JSON object",
+		},
+		{
+			"trailing comma",
+			`{"proposed_sql": "SELECT 1", "rationale": "x",}`,
+			"invalid JSON response",
+		},
+		{
+			"single-quoted value",
+			`{"proposed_sql": 'SELECT 1'}`,
+			"invalid JSON response",
+		},
+		{
+			"two objects",
+			`{"proposed_sql": "SELECT 1"} {"proposed_sql": "SELECT 2"}`,
+			"invalid JSON response",
+		},
+		{
+			"wrong field type",
+			`{"proposed_sql": "SELECT 1", "rationale": 42}`,
+			"invalid rationale in JSON response",
+		},
+		{
+			"empty proposed_sql",
+			`{"proposed_sql": "  ", "rationale": "nothing to change"}`,
+			"JSON response has no proposed_sql",
+		},
+		{
+			"empty fence as proposed_sql",
+			`{"proposed_sql": "` + "```sql\\n```" + `"}`,
+			"JSON response has no proposed_sql",
+		},
+	}
+	oe := &OptimizationEngine{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := oe.parseLLMResponse(tt.response)
+			if err == nil {
+				t.Fatalf("parseLLMResponse = %+v, want an error", got)
+			}
+			if !strings.Contains(err.Error(), "failed to extract optimized SQL") || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLLMResponseFallsBackToMarkers(t *testing.T) {
+	// A template still asking for the marker format, whose answer happens to
+	// mention the JSON field name
+	response := "PROPOSED_SQL:\n```sql\nSELECT id FROM orders USE INDEX (idx_status) WHERE status = 'paid'\n```\n\n" +
+		"RATIONALE:\n• Forces idx_status\n• Avoids a full scan\n\n" +
+		"EXPECTED_PLAN_CHANGE:   IndexRangeScan on idx_status\n\n" +
+		"CAVEATS:\n\n   The \"proposed_sql\" was checked against the schema.\n"
+
+	got, err := (&OptimizationEngine{}).parseLLMResponse(response)
+	if err != nil {
+		t.Fatalf("parseLLMResponse: %v", err)
+	}
+	want := &LLMResponse{
+		ProposedSQL:        "SELECT id FROM orders USE INDEX (idx_status) WHERE status = 'paid'",
+		Rationale:          "Forces idx_status Avoids a full scan",
+		ExpectedPlanChange: "IndexRangeScan on idx_status",
+		Caveats:            `The "proposed_sql" was checked against the schema.`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLLMResponse =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParseLLMResponseWithoutSQL(t *testing.T) {
+	_, err := (&OptimizationEngine{}).parseLLMResponse("I cannot optimize this query without its schema.")
+	if err == nil || err.Error() != "failed to extract optimized SQL from LLM response" {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestParseLLMResponseIndexes(t *testing.T) {
+	jsonResponse := `{
+  "proposed_sql": "SELECT id FROM orders WHERE status = 'paid'",
+  "rationale": "r", "expected_plan_change": "p", "caveats": "c",
+  "recommended_indexes": [
+    {"table": "orders", "columns": ["status", "created_at"], "rationale": "covers the filter"},
+    {"table": "orders", "columns": "customer_id, total DESC", "type": "unique"},
+    {"table": "", "columns": ["id"]},
+    {"table": "orders", "columns": ["LOWER(email)"]}
+  ]
+}`
+	textResponse := "PROPOSED_SQL:\n```sql\nSELECT id FROM orders WHERE status = 'paid'\n```\n\n" +
+		"RATIONALE: r\n\nEXPECTED_PLAN_CHANGE: p\n\nCAVEATS: c\n\n" +
+		"RECOMMENDED_INDEXES:\n" +
+		"CREATE INDEX idx_status ON orders (status, created_at); -- covers the filter\n" +
+		"CREATE UNIQUE INDEX idx_customer ON orders (customer_id, total DESC);\n" +
+		"CREATE INDEX idx_email ON orders (LOWER(email));\n"
+
+	for name, response := range map[string]string{"json": jsonResponse, "text": textResponse} {
+		t.Run(name, func(t *testing.T) {
+			got, err := (&OptimizationEngine{}).parseLLMResponse(response)
+			if err != nil {
+				t.Fatalf("parseLLMResponse: %v", err)
+			}
+			if len(got.RecommendedIndexes) != 2 {
+				t.Fatalf("indexes = %+v, want the two valid ones", got.RecommendedIndexes)
+			}
+			first, second := got.RecommendedIndexes[0], got.RecommendedIndexes[1]
+			if first.Table != "orders" || !reflect.DeepEqual(first.Columns, []string{"status", "created_at"}) || first.Rationale != "covers the filter" {
+				t.Errorf("first index = %+v", first)
+			}
+			if !reflect.DeepEqual(second.Columns, []string{"customer_id", "total"}) || second.Type != database.IndexTypeUnique {
+				t.Errorf("second index = %+v", second)
+			}
+		})
+	}
+}
+
+func TestStripSQLFence(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"SELECT 1", "SELECT 1"},
+		{"  SELECT 1  ", "SELECT 1"},
+		{"```sql\nSELECT 1\n```", "SELECT 1"},
+		{"```\nSELECT 1\n```", "SELECT 1"},
+		{"```SELECT 1```", "SELECT 1"},
+		{"```SELECT id\nFROM t```", "SELECT id\nFROM t"},
+		{"```sql\nSELECT 1", "SELECT 1"},
+	}
+	for _, tt := range tests {
+		if got := stripSQLFence(tt.in); got != tt.want {
+			t.Errorf("stripSQLFence(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package analyze
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/metrics"
+)
+
+// ErrNotRetryable is returned when re-optimizing a rewrite that was neither
+// rejected nor invalid
+var ErrNotRetryable = errors.New("only rejected or invalid optimizations can be retried")
+
+// maxRewriteHistory bounds the rewrites RewriteHistory walks, in case a
+// chain was corrupted into a cycle
+const maxRewriteHistory = 100
+
+// ReOptimize asks the generator again for the slow query of a rejected or
+// invalid rewrite, showing it the previous proposal and the reviewer's
+// feedback. The new rewrite is stored with parent_rewrite_id pointing at the
+// old one, which keeps its status, and replaces it as best rewrite of the
+// slow queries unless it is invalid too. The retry is audited under the
+// actor carried by ctx.
+func (oe *OptimizationEngine) ReOptimize(ctx context.Context, rewriteID int64, feedback string) (*OptimizationResult, error) {
+	parent, retry, err := oe.retryFor(ctx, rewriteID, feedback)
+	if err != nil {
+		return nil, err
+	}
+	result, err := oe.optimize(ctx, parent.SlowQueryID, parent.OriginalSQL, retry)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Status != "invalid" {
+		_, err := oe.db.ExecContext(ctx,
+			"UPDATE app_slow_queries SET best_rewrite_id = ? WHERE best_rewrite_id = ?", result.ID, rewriteID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to replace best rewrite %d: %w", rewriteID, err)
+		}
+	}
+
+	err = oe.db.RecordAudit(ctx, database.AuditEntry{
+		Action:      database.ActionRetry,
+		RewriteID:   result.ID,
+		SlowQueryID: result.SlowQueryID,
+		Reason:      retry.feedback.Reason,
+		Details:     map[string]any{"parent_rewrite_id": rewriteID, "status": result.Status},
+	})
+	if err != nil {
+		return nil, err
+	}
+	metrics.RecordReview(database.ActionRetry, database.ActorFromContext(ctx))
+	return result, nil
+}
+
+// PreviewRetryPrompt assembles the prompt ReOptimize would send for rewrite
+// rewriteID and feedback, without calling the generator
+func (oe *OptimizationEngine) PreviewRetryPrompt(ctx context.Context, rewriteID int64, feedback string) (*Prompt, error) {
+	parent, retry, err := oe.retryFor(ctx, rewriteID, feedback)
+	if err != nil {
+		return nil, err
+	}
+	return oe.previewPrompt(ctx, parent.SlowQueryID, parent.OriginalSQL, retry)
+}
+
+// retryFor loads rewrite rewriteID, failing with ErrNotRetryable unless it
+// was rejected or invalid, and the retry showing it to the model
+func (oe *OptimizationEngine) retryFor(ctx context.Context, rewriteID int64, feedback string) (*OptimizationResult, *retryOf, error) {
+	parent, err := oe.GetOptimizationByID(ctx, rewriteID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if parent.Status != "rejected" && parent.Status != "invalid" {
+		return nil, nil, ErrNotRetryable
+	}
+	return parent, &retryOf{
+		rewriteID: rewriteID,
+		feedback:  Feedback{PreviousSQL: parent.OptimizedSQL, Reason: strings.TrimSpace(feedback)},
+	}, nil
+}
+
+// RewriteHistory returns the chain of retries rewrite id belongs to, from
+// the first proposal to the latest retries, oldest first
+func (oe *OptimizationEngine) RewriteHistory(ctx context.Context, id int64) ([]RewriteSummary, error) {
+	root := id
+	seen := map[int64]bool{}
+	for len(seen) < maxRewriteHistory {
+		seen[root] = true
+		var parent sql.NullInt64
+		err := oe.db.QueryRowContext(ctx, "SELECT parent_rewrite_id FROM app_rewrites WHERE id = ?", root).Scan(&parent)
+		if err == sql.ErrNoRows {
+			if root == id {
+				return nil, ErrNotFound
+			}
+			// The parent was purged: the history starts at its child
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load rewrite %d: %w", root, err)
+		}
+		if !parent.Valid || seen[parent.Int64] {
+			break
+		}
+		root = parent.Int64
+	}
+
+	history, err := oe.queryRewriteSummaries(ctx, "WHERE id = ?", root)
+	if err != nil {
+		return nil, err
+	}
+	visited := map[int64]bool{root: true}
+	level := []int64{root}
+	for len(level) > 0 && len(history) < maxRewriteHistory {
+		args := make([]any, len(level))
+		for i, id := range level {
+			args[i] = id
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(level)), ", ")
+		children, err := oe.queryRewriteSummaries(ctx, "WHERE parent_rewrite_id IN ("+placeholders+") ORDER BY created_at, id", args...)
+		if err != nil {
+			return nil, err
+		}
+		level = nil
+		for _, r := range children {
+			if visited[r.ID] {
+				continue
+			}
+			visited[r.ID] = true
+			history = append(history, r)
+			level = append(level, r.ID)
+		}
+	}
+	return history, nil
+}
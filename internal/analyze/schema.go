@@ -0,0 +1,80 @@
+package analyze
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/matthieukhl/latentia/internal/database"
+)
+
+// schemaContext renders the definitions of tables for the schema section,
+// within maxChars. A table that does not fit is shown with only its indexed
+// columns, then by name only; missing and inaccessible tables are noted as
+// such rather than failing the prompt. The tables named in views are shown
+// by their CREATE VIEW statement.
+func schemaContext(ctx context.Context, schemas *database.SchemaIntrospector, tables, views []string, maxChars int) string {
+	var blocks []string
+	used := 0
+	add := func(block string) bool {
+		if used+len(block) > maxChars {
+			return false
+		}
+		blocks = append(blocks, block)
+		used += len(block) + 2
+		return true
+	}
+
+	isView := map[string]bool{}
+	for _, view := range views {
+		isView[strings.ToLower(view)] = true
+	}
+
+	for _, table := range tables {
+		if isView[strings.ToLower(table)] {
+			if v, err := schemas.View(ctx, table); err == nil {
+				if !add(v.DDL()) {
+					add(fmt.Sprintf("-- %s: view definition omitted to fit the prompt", table))
+				}
+				continue
+			}
+		}
+		t, err := schemas.Table(ctx, table)
+		if err != nil {
+			if !errors.Is(err, database.ErrTableUnavailable) {
+				slog.WarnContext(ctx, "table definition not read", "table", table, "error", err)
+			}
+			add(fmt.Sprintf("-- %s: schema unavailable", table))
+			continue
+		}
+		if add(t.DDL()) {
+			continue
+		}
+		if compact, omitted := indexedColumnsOnly(t); add(compact.DDL() + fmt.Sprintf("\n-- %d more columns not shown", omitted)) {
+			continue
+		}
+		add(fmt.Sprintf("-- %s: schema omitted to fit the prompt", table))
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// indexedColumnsOnly returns a copy of t keeping the columns that are part
+// of an index, and how many columns it dropped
+func indexedColumnsOnly(t *database.TableSchema) (*database.TableSchema, int) {
+	indexed := map[string]bool{}
+	for _, index := range t.Indexes {
+		for _, column := range index.Columns {
+			indexed[strings.ToLower(column)] = true
+		}
+	}
+	compact := *t
+	compact.Columns = nil
+	for _, c := range t.Columns {
+		if indexed[strings.ToLower(c.Name)] {
+			compact.Columns = append(compact.Columns, c)
+		}
+	}
+	return &compact, len(t.Columns) - len(compact.Columns)
+}
@@ -0,0 +1,252 @@
+package analyze
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// semanticsChange is a difference between a statement and its rewrite that
+// can change the rows returned. Critical changes, such as an added LIMIT,
+// almost always do; the others depend on the data or are common in
+// equivalent rewrites, like moving a predicate into a join condition.
+type semanticsChange struct {
+	text     string
+	critical bool
+}
+
+// checkSemantics compares the outermost SELECT of original and optimized,
+// clause by clause, for changes to the rows they return. It needs no
+// database, so it also covers rewrites EXPLAIN could not validate.
+func checkSemantics(original, optimized string) []semanticsChange {
+	before, after := parseSelect(tokenizeSQL(original)), parseSelect(tokenizeSQL(optimized))
+	d := diffClauses(before, after)
+
+	var changes []semanticsChange
+	add := func(critical bool, format string, args ...any) {
+		changes = append(changes, semanticsChange{text: fmt.Sprintf(format, args...), critical: critical})
+	}
+
+	switch c := d.Limit; {
+	case c == nil:
+	case c.Before == "":
+		add(true, "%s added: fewer rows may be returned", c.After)
+	case c.After == "":
+		add(true, "%s removed: more rows may be returned", c.Before)
+	default:
+		add(true, "LIMIT changed: %s -> %s", c.Before, c.After)
+	}
+
+	// Without ORDER BY, which rows a LIMIT keeps is up to the plan
+	if c := d.OrderBy; c != nil && c.Before != "" {
+		if before.limit != "" {
+			add(true, "ORDER BY changed under %s: other rows may be kept", before.limit)
+		} else {
+			add(false, "ORDER BY changed: rows may come back in another order")
+		}
+	}
+
+	if d.GroupBy != nil {
+		add(true, "GROUP BY changed: %s -> %s", orNone(d.GroupBy.Before), orNone(d.GroupBy.After))
+	}
+	switch {
+	case !before.distinct && after.distinct:
+		add(true, "DISTINCT added: duplicate rows are no longer returned")
+	case before.distinct && !after.distinct:
+		add(true, "DISTINCT removed: duplicate rows may be returned")
+	}
+	if !slices.Equal(before.setOps, after.setOps) {
+		add(true, "set operators changed: %s -> %s", orNone(strings.Join(before.setOps, ", ")), orNone(strings.Join(after.setOps, ", ")))
+	}
+
+	var expanded []string
+	for _, c := range d.ColumnsRemoved {
+		if c == "*" || strings.HasSuffix(c, ".*") {
+			expanded = append(expanded, c)
+			continue
+		}
+		add(true, "column dropped from SELECT: %s", c)
+	}
+	if len(expanded) > 0 {
+		add(false, "%s replaced by a column list: callers using other columns will break", strings.Join(expanded, ", "))
+	}
+
+	beforeJoins := map[string]joinRef{}
+	for _, j := range before.joins {
+		beforeJoins[j.table] = j
+	}
+	for _, j := range after.joins {
+		old, ok := beforeJoins[j.table]
+		switch {
+		case !ok:
+		case old.kind != j.kind:
+			// Conditions moved along are reported with the predicates
+			if !innerJoinKinds[old.kind] || !innerJoinKinds[j.kind] {
+				add(true, "join type of %s changed: %s -> %s", j.table, old.kind, j.kind)
+			}
+		case old.conditionKey != j.conditionKey:
+			add(false, "join condition of %s changed: %s -> %s", j.table, orNone(old.condition), orNone(j.condition))
+		}
+	}
+	for _, j := range d.JoinsAdded {
+		add(false, "join added: %s; rows may be duplicated", j)
+	}
+	for _, j := range d.JoinsRemoved {
+		add(false, "join removed: %s", j)
+	}
+
+	// A condition moved between WHERE and the ON of an inner join filters
+	// the same rows
+	moved := func(p predicate, joins []joinRef) bool {
+		for _, j := range joins {
+			if innerJoinKinds[j.kind] && slices.Contains(j.onKeys, p.key) {
+				return true
+			}
+		}
+		return false
+	}
+	var added, removed []predicate
+	for _, p := range after.predicates {
+		if !slices.ContainsFunc(before.predicates, samePredicate(p)) && !moved(p, before.joins) {
+			added = append(added, p)
+		}
+	}
+	for _, p := range before.predicates {
+		if !slices.ContainsFunc(after.predicates, samePredicate(p)) && !moved(p, after.joins) {
+			removed = append(removed, p)
+		}
+	}
+
+	// A predicate added filters rows out and one removed lets more through,
+	// unless the pair is the same condition with its operands placed
+	// differently, or a date function made a range on its column. Anything
+	// else, a changed literal, operator or column, or an OR become an AND,
+	// filters other rows.
+	for i := 0; i < len(removed); i++ {
+		lower, upper, ok := sargableRange(removed[i])
+		if !ok {
+			continue
+		}
+		l := slices.IndexFunc(added, func(a predicate) bool { return a.shape == lower })
+		u := slices.IndexFunc(added, func(a predicate) bool { return a.shape == upper })
+		if l < 0 || u < 0 {
+			continue
+		}
+		add(false, "predicate rewritten: %s -> %s AND %s", removed[i].text, added[l].text, added[u].text)
+		added = slices.Delete(added, max(l, u), max(l, u)+1)
+		added = slices.Delete(added, min(l, u), min(l, u)+1)
+		removed = slices.Delete(removed, i, i+1)
+		i--
+	}
+	for _, p := range added {
+		if i := slices.IndexFunc(removed, func(r predicate) bool { return r.shape == p.shape }); i >= 0 {
+			add(false, "predicate rewritten: %s -> %s", removed[i].text, p.text)
+			removed = slices.Delete(removed, i, i+1)
+			continue
+		}
+		add(true, "predicate added: %s", p.text)
+	}
+	for _, p := range removed {
+		add(true, "predicate removed: %s", p.text)
+	}
+	return changes
+}
+
+// sargableRange returns the shapes of the range predicates equivalent to
+// DATE(col) = 'YYYY-MM-DD' or YEAR(col) = YYYY, the rewrite that lets an
+// index on col be used
+func sargableRange(p predicate) (lower, upper string, ok bool) {
+	tokens := tokenizeSQL(p.text)
+	if len(tokens) < 6 || tokens[1].text != "(" || tokens[len(tokens)-3].text != ")" ||
+		tokens[len(tokens)-2].text != "=" || tokens[len(tokens)-1].kind != tokenLiteral {
+		return "", "", false
+	}
+	column := renderTokens(tokens[2 : len(tokens)-3])
+	literal := tokens[len(tokens)-1].text
+
+	var from, to string
+	switch tokens[0].text {
+	case "DATE":
+		day, err := time.Parse("2006-01-02", strings.Trim(literal, "'"))
+		if err != nil || !strings.HasPrefix(literal, "'") {
+			return "", "", false
+		}
+		from, to = literal, "'"+day.AddDate(0, 0, 1).Format("2006-01-02")+"'"
+	case "YEAR":
+		year, err := strconv.Atoi(literal)
+		if err != nil {
+			return "", "", false
+		}
+		from, to = fmt.Sprintf("'%d-01-01'", year), fmt.Sprintf("'%d-01-01'", year+1)
+	default:
+		return "", "", false
+	}
+	shape := func(condition string) string { return predicateShape(tokenizeSQL(condition), 0) }
+	return shape(column + " >= " + from), shape(column + " < " + to), true
+}
+
+func samePredicate(p predicate) func(predicate) bool {
+	return func(other predicate) bool { return other.key == p.key }
+}
+
+// innerJoinKinds are the join kinds that return the same rows for the same
+// conditions, so FROM a, b WHERE becoming an explicit JOIN is no change
+var innerJoinKinds = map[string]bool{"FROM": true, "INNER JOIN": true, "CROSS JOIN": true}
+
+func orNone(clause string) string {
+	if clause == "" {
+		return "(none)"
+	}
+	return clause
+}
+
+// semanticsLines returns the text of each change, nil when there are none
+func semanticsLines(changes []semanticsChange) []string {
+	var lines []string
+	for _, c := range changes {
+		lines = append(lines, c.text)
+	}
+	return lines
+}
+
+// hasCritical reports whether any change almost certainly changes results
+func hasCritical(changes []semanticsChange) bool {
+	for _, c := range changes {
+		if c.critical {
+			return true
+		}
+	}
+	return false
+}
+
+// withSemanticsCaveats appends the changes to the LLM's caveats so
+// reviewers see them where they look for risks
+func withSemanticsCaveats(caveats string, changes []semanticsChange) string {
+	if len(changes) == 0 {
+		return caveats
+	}
+	var b strings.Builder
+	if caveats != "" {
+		b.WriteString(caveats)
+		b.WriteString("\n\n")
+	}
+	b.WriteString("Detected changes that may alter results:")
+	for _, c := range changes {
+		b.WriteString("\n- ")
+		b.WriteString(c.text)
+	}
+	return b.String()
+}
+
+// compareSemantics records the changes between sql and the rewrite in result
+// and adds them to its caveats. The rewrite passes the equivalence check
+// auto-accept requires when none of them is critical.
+func compareSemantics(sql string, result *OptimizationResult) []semanticsChange {
+	semantics := checkSemantics(sql, result.OptimizedSQL)
+	result.SemanticsChanges = semanticsLines(semantics)
+	result.Caveats = withSemanticsCaveats(result.Caveats, semantics)
+	result.EquivalencePassed = !hasCritical(semantics)
+	return semantics
+}
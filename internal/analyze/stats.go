@@ -0,0 +1,297 @@
+package analyze
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// calibrationBuckets is the number of confidence buckets, one per decile
+const calibrationBuckets = 10
+
+// Calibration compares the confidence of rewrites to what reviewers made of
+// them. Invalid and suppressed rewrites were never up for review and are
+// left out.
+type Calibration struct {
+	Since    *time.Time `json:"since,omitempty"`
+	Rewrites int        `json:"rewrites"`
+	Accepted int        `json:"accepted"`
+	Rejected int        `json:"rejected"`
+	// AcceptanceRate is accepted over accepted and rejected, nil before any
+	// review
+	AcceptanceRate *float64                   `json:"acceptance_rate"`
+	Buckets        []CalibrationBucket        `json:"buckets"`
+	Patterns       []PatternCalibration       `json:"patterns"`
+	PromptVersions []PromptVersionCalibration `json:"prompt_versions"`
+}
+
+// CalibrationBucket is the review outcome of the rewrites whose confidence
+// is in [MinConfidence, MaxConfidence), the last bucket including 1
+type CalibrationBucket struct {
+	MinConfidence  float64  `json:"min_confidence"`
+	MaxConfidence  float64  `json:"max_confidence"`
+	Rewrites       int      `json:"rewrites"`
+	Pending        int      `json:"pending"`
+	Accepted       int      `json:"accepted"`
+	Rejected       int      `json:"rejected"`
+	AcceptanceRate *float64 `json:"acceptance_rate"`
+	// AvgReviewSeconds is the average time from creation to review of the
+	// accepted and rejected rewrites
+	AvgReviewSeconds *float64 `json:"avg_review_seconds"`
+}
+
+// PatternCalibration is the review outcome of the rewrites of queries with
+// one anti-pattern. A rewrite counts for each anti-pattern of its query.
+type PatternCalibration struct {
+	Code             string   `json:"code"`
+	Rewrites         int      `json:"rewrites"`
+	Accepted         int      `json:"accepted"`
+	Rejected         int      `json:"rejected"`
+	AcceptanceRate   *float64 `json:"acceptance_rate"`
+	AvgConfidence    float64  `json:"avg_confidence"`
+	AvgReviewSeconds *float64 `json:"avg_review_seconds"`
+}
+
+// PromptVersionCalibration is the review outcome of the rewrites generated
+// with one prompt version, to compare template changes and prompt styles
+type PromptVersionCalibration struct {
+	// Version is "" for rewrites generated before versions were recorded
+	Version          string   `json:"version"`
+	Rewrites         int      `json:"rewrites"`
+	Pending          int      `json:"pending"`
+	Accepted         int      `json:"accepted"`
+	Rejected         int      `json:"rejected"`
+	AcceptanceRate   *float64 `json:"acceptance_rate"`
+	AvgConfidence    float64  `json:"avg_confidence"`
+	AvgReviewSeconds *float64 `json:"avg_review_seconds"`
+}
+
+// calibrationRow is one group of the calibration queries: a confidence
+// decile, or an anti-pattern code or prompt version
+type calibrationRow struct {
+	bucket        int
+	code          string
+	rewrites      int
+	pending       int
+	accepted      int
+	rejected      int
+	confidence    float64 // sum
+	reviewSeconds float64 // sum over the timed reviews
+	timedReviews  int
+}
+
+// calibrationSums are the aggregates shared by the calibration queries, in
+// calibrationRow order
+const calibrationSums = `COUNT(*),
+	COALESCE(SUM(status = 'pending'), 0),
+	COALESCE(SUM(status = 'accepted'), 0),
+	COALESCE(SUM(status = 'rejected'), 0),
+	COALESCE(SUM(confidence_score), 0),
+	COALESCE(SUM(CASE WHEN status <> 'pending' AND reviewed_at IS NOT NULL
+	    THEN TIMESTAMPDIFF(SECOND, created_at, reviewed_at) END), 0),
+	COALESCE(SUM(status <> 'pending' AND reviewed_at IS NOT NULL), 0)`
+
+// calibrationFilter keeps the reviewable rewrites created since since
+func calibrationFilter(since time.Time) (string, []any) {
+	where := "status IN ('pending', 'accepted', 'rejected')"
+	if since.IsZero() {
+		return where, nil
+	}
+	return where + " AND created_at >= ?", []any{since}
+}
+
+// ConfidenceCalibration buckets the rewrites created since since, all of
+// them when zero, by confidence decile, anti-pattern and prompt version with
+// their acceptance rate and review latency
+func (oe *OptimizationEngine) ConfidenceCalibration(ctx context.Context, since time.Time) (*Calibration, error) {
+	where, args := calibrationFilter(since)
+	buckets, err := oe.queryCalibrationRows(ctx, "confidence calibration", true, `
+		SELECT CAST(FLOOR(confidence_score * 10) AS SIGNED) AS bucket, `+calibrationSums+`
+		FROM app_rewrites
+		WHERE `+where+`
+		GROUP BY bucket`, args...)
+	if err != nil {
+		return nil, err
+	}
+	patterns, err := oe.patternCalibrationRows(ctx, since)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := oe.queryCalibrationRows(ctx, "prompt version calibration", false, `
+		SELECT prompt_version, `+calibrationSums+`
+		FROM app_rewrites
+		WHERE `+where+`
+		GROUP BY prompt_version`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	calibration := newCalibration(buckets, patterns)
+	calibration.PromptVersions = promptVersionCalibrations(versions)
+	if !since.IsZero() {
+		calibration.Since = &since
+	}
+	return calibration, nil
+}
+
+// patternCalibrationRows aggregates the rewrites of each known anti-pattern
+// code, one UNION ALL branch per code since anti_patterns is a JSON array
+func (oe *OptimizationEngine) patternCalibrationRows(ctx context.Context, since time.Time) ([]calibrationRow, error) {
+	where, filterArgs := calibrationFilter(since)
+	codes := slices.Sorted(maps.Keys(findingKinds))
+
+	branches := make([]string, 0, len(codes))
+	var args []any
+	for _, code := range codes {
+		candidate, err := json.Marshal(code)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode anti-pattern %s: %w", code, err)
+		}
+		branches = append(branches, `SELECT ?, `+calibrationSums+`
+			FROM app_rewrites
+			WHERE `+where+` AND JSON_CONTAINS(pattern_analysis, ?, '$.anti_patterns')`)
+		args = append(args, code)
+		args = append(args, filterArgs...)
+		args = append(args, string(candidate))
+	}
+
+	return oe.queryCalibrationRows(ctx, "anti-pattern calibration", false, strings.Join(branches, "\nUNION ALL\n"), args...)
+}
+
+// queryCalibrationRows runs a query selecting a decile when bucketed, a code
+// otherwise, followed by calibrationSums
+func (oe *OptimizationEngine) queryCalibrationRows(ctx context.Context, what string, bucketed bool, query string, args ...any) ([]calibrationRow, error) {
+	rows, err := oe.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", what, err)
+	}
+	defer rows.Close()
+
+	var result []calibrationRow
+	for rows.Next() {
+		var row calibrationRow
+		var key any = &row.code
+		if bucketed {
+			key = &row.bucket
+		}
+		if err := rows.Scan(key, &row.rewrites, &row.pending, &row.accepted, &row.rejected,
+			&row.confidence, &row.reviewSeconds, &row.timedReviews); err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", what, err)
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", what, err)
+	}
+	return result, nil
+}
+
+// newCalibration fills every decile, empty ones included, from the bucket
+// rows and lists the anti-patterns that have rewrites, most common first.
+// Buckets outside 0-9 are clamped, so a score of 1 counts in the last decile
+// and a stray score still counts.
+func newCalibration(buckets, patterns []calibrationRow) *Calibration {
+	calibration := &Calibration{
+		Buckets:        make([]CalibrationBucket, calibrationBuckets),
+		Patterns:       []PatternCalibration{},
+		PromptVersions: []PromptVersionCalibration{},
+	}
+	timed := make([]calibrationRow, calibrationBuckets)
+	for i := range calibration.Buckets {
+		calibration.Buckets[i].MinConfidence = float64(i) / calibrationBuckets
+		calibration.Buckets[i].MaxConfidence = float64(i+1) / calibrationBuckets
+	}
+	for _, row := range buckets {
+		i := min(max(row.bucket, 0), calibrationBuckets-1)
+		b := &calibration.Buckets[i]
+		b.Rewrites += row.rewrites
+		b.Pending += row.pending
+		b.Accepted += row.accepted
+		b.Rejected += row.rejected
+		timed[i].reviewSeconds += row.reviewSeconds
+		timed[i].timedReviews += row.timedReviews
+
+		calibration.Rewrites += row.rewrites
+		calibration.Accepted += row.accepted
+		calibration.Rejected += row.rejected
+	}
+	for i := range calibration.Buckets {
+		b := &calibration.Buckets[i]
+		b.AcceptanceRate = acceptanceRate(b.Accepted, b.Rejected)
+		b.AvgReviewSeconds = average(timed[i].reviewSeconds, timed[i].timedReviews)
+	}
+	calibration.AcceptanceRate = acceptanceRate(calibration.Accepted, calibration.Rejected)
+
+	for _, row := range patterns {
+		if row.rewrites == 0 {
+			continue
+		}
+		calibration.Patterns = append(calibration.Patterns, PatternCalibration{
+			Code:             row.code,
+			Rewrites:         row.rewrites,
+			Accepted:         row.accepted,
+			Rejected:         row.rejected,
+			AcceptanceRate:   acceptanceRate(row.accepted, row.rejected),
+			AvgConfidence:    row.confidence / float64(row.rewrites),
+			AvgReviewSeconds: average(row.reviewSeconds, row.timedReviews),
+		})
+	}
+	sort.SliceStable(calibration.Patterns, func(i, j int) bool {
+		if calibration.Patterns[i].Rewrites != calibration.Patterns[j].Rewrites {
+			return calibration.Patterns[i].Rewrites > calibration.Patterns[j].Rewrites
+		}
+		return calibration.Patterns[i].Code < calibration.Patterns[j].Code
+	})
+	return calibration
+}
+
+// promptVersionCalibrations lists the prompt versions with rewrites, most
+// rewrites first
+func promptVersionCalibrations(rows []calibrationRow) []PromptVersionCalibration {
+	versions := []PromptVersionCalibration{}
+	for _, row := range rows {
+		if row.rewrites == 0 {
+			continue
+		}
+		versions = append(versions, PromptVersionCalibration{
+			Version:          row.code,
+			Rewrites:         row.rewrites,
+			Pending:          row.pending,
+			Accepted:         row.accepted,
+			Rejected:         row.rejected,
+			AcceptanceRate:   acceptanceRate(row.accepted, row.rejected),
+			AvgConfidence:    row.confidence / float64(row.rewrites),
+			AvgReviewSeconds: average(row.reviewSeconds, row.timedReviews),
+		})
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Rewrites != versions[j].Rewrites {
+			return versions[i].Rewrites > versions[j].Rewrites
+		}
+		return versions[i].Version < versions[j].Version
+	})
+	return versions
+}
+
+// acceptanceRate is accepted over reviewed, nil when nothing was reviewed
+func acceptanceRate(accepted, rejected int) *float64 {
+	if accepted+rejected == 0 {
+		return nil
+	}
+	rate := float64(accepted) / float64(accepted+rejected)
+	return &rate
+}
+
+// average is total over n, nil when n is 0
+func average(total float64, n int) *float64 {
+	if n == 0 {
+		return nil
+	}
+	avg := total / float64(n)
+	return &avg
+}
@@ -0,0 +1,246 @@
+package analyze
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/matthieukhl/latentia/internal/database"
+)
+
+func ratio(n, d int) *float64 {
+	r := float64(n) / float64(d)
+	return &r
+}
+
+// rates renders the rates of a bucket for failure messages
+func rates(b CalibrationBucket) string {
+	s := func(p *float64) string {
+		if p == nil {
+			return "nil"
+		}
+		return fmt.Sprintf("%.3f", *p)
+	}
+	return fmt.Sprintf("%d rewrites, %d pending, %d/%d accepted (%s), review %s",
+		b.Rewrites, b.Pending, b.Accepted, b.Accepted+b.Rejected, s(b.AcceptanceRate), s(b.AvgReviewSeconds))
+}
+
+func TestNewCalibrationBuckets(t *testing.T) {
+	c := newCalibration([]calibrationRow{
+		{bucket: 0, rewrites: 2, rejected: 2, reviewSeconds: 60, timedReviews: 2},
+		{bucket: 9, rewrites: 3, pending: 1, accepted: 2, reviewSeconds: 300, timedReviews: 2},
+		// Stray scores outside [0, 1] count in the nearest decile
+		{bucket: -1, rewrites: 1, rejected: 1},
+		{bucket: 10, rewrites: 1, accepted: 1, reviewSeconds: 100, timedReviews: 1},
+		{bucket: 5, rewrites: 1, pending: 1},
+	}, nil)
+
+	if len(c.Buckets) != calibrationBuckets {
+		t.Fatalf("%d buckets, want one per decile", len(c.Buckets))
+	}
+	want := map[int]CalibrationBucket{
+		0: {Rewrites: 3, Rejected: 3, AcceptanceRate: ratio(0, 3), AvgReviewSeconds: ratio(60, 2)},
+		5: {Rewrites: 1, Pending: 1},
+		9: {Rewrites: 4, Pending: 1, Accepted: 3, AcceptanceRate: ratio(3, 3), AvgReviewSeconds: ratio(400, 3)},
+	}
+	for i, b := range c.Buckets {
+		if math.Abs(b.MinConfidence-float64(i)/10) > 1e-9 || math.Abs(b.MaxConfidence-float64(i+1)/10) > 1e-9 {
+			t.Errorf("bucket %d covers [%v, %v)", i, b.MinConfidence, b.MaxConfidence)
+		}
+		// Empty deciles are listed, without rates
+		w := want[i]
+		w.MinConfidence, w.MaxConfidence = b.MinConfidence, b.MaxConfidence
+		if !reflect.DeepEqual(b, w) {
+			t.Errorf("bucket %d: %s, want %s", i, rates(b), rates(w))
+		}
+	}
+
+	if c.Rewrites != 8 || c.Accepted != 3 || c.Rejected != 3 {
+		t.Errorf("totals = %d rewrites, %d accepted, %d rejected", c.Rewrites, c.Accepted, c.Rejected)
+	}
+	if c.AcceptanceRate == nil || *c.AcceptanceRate != 0.5 {
+		t.Errorf("acceptance rate = %v, want 0.5", c.AcceptanceRate)
+	}
+}
+
+func TestNewCalibrationWithoutRewrites(t *testing.T) {
+	c := newCalibration(nil, nil)
+	if c.Rewrites != 0 || c.AcceptanceRate != nil || len(c.Buckets) != calibrationBuckets {
+		t.Errorf("calibration = %+v", c)
+	}
+	// Lists encode as [] rather than null
+	if c.Patterns == nil || c.PromptVersions == nil || promptVersionCalibrations(nil) == nil {
+		t.Error("empty lists are nil")
+	}
+}
+
+func TestNewCalibrationPatterns(t *testing.T) {
+	c := newCalibration(nil, []calibrationRow{
+		{code: "select-star", rewrites: 2, accepted: 1, rejected: 1, confidence: 1.5, reviewSeconds: 30, timedReviews: 2},
+		{code: "cartesian-join"},
+		{code: "missing-limit", rewrites: 2, pending: 2, confidence: 0.4},
+		{code: "function-in-where", rewrites: 5, accepted: 4, confidence: 4.5, reviewSeconds: 100, timedReviews: 1},
+	})
+	want := []PatternCalibration{
+		{Code: "function-in-where", Rewrites: 5, Accepted: 4, AcceptanceRate: ratio(4, 4), AvgConfidence: 0.9, AvgReviewSeconds: ratio(100, 1)},
+		{Code: "missing-limit", Rewrites: 2, AvgConfidence: 0.2},
+		{Code: "select-star", Rewrites: 2, Accepted: 1, Rejected: 1, AcceptanceRate: ratio(1, 2), AvgConfidence: 0.75, AvgReviewSeconds: ratio(30, 2)},
+	}
+	// Codes without rewrites are left out; ties go by code
+	if !reflect.DeepEqual(c.Patterns, want) {
+		t.Errorf("patterns =\n%+v\nwant\n%+v", c.Patterns, want)
+	}
+}
+
+func TestPromptVersionCalibrations(t *testing.T) {
+	got := promptVersionCalibrations([]calibrationRow{
+		{code: "", rewrites: 1, rejected: 1, confidence: 0.3},
+		{code: "v2", rewrites: 3, accepted: 2, pending: 1, confidence: 2.4},
+		{code: "v1", rewrites: 1, accepted: 1, confidence: 0.8},
+	})
+	var versions []string
+	for _, v := range got {
+		versions = append(versions, v.Version)
+	}
+	if !reflect.DeepEqual(versions, []string{"v2", "", "v1"}) {
+		t.Errorf("versions = %q, want most rewrites first, then by version", versions)
+	}
+	if math.Abs(got[0].AvgConfidence-0.8) > 1e-9 || *got[0].AcceptanceRate != 1 || got[0].Pending != 1 {
+		t.Errorf("v2 = %+v", got[0])
+	}
+}
+
+// openCalibrationDB connects to LATENTIA_TEST_DSN, a scratch database whose
+// name ends in _test, and recreates a trimmed app_rewrites in it
+func openCalibrationDB(t *testing.T) *database.DB {
+	t.Helper()
+	dsn := os.Getenv("LATENTIA_TEST_DSN")
+	if dsn == "" {
+		t.Skip("LATENTIA_TEST_DSN is not set")
+	}
+	pool, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+
+	ctx := context.Background()
+	var name sql.NullString
+	if err := pool.QueryRowContext(ctx, "SELECT DATABASE()").Scan(&name); err != nil {
+		t.Fatalf("failed to reach database: %v", err)
+	}
+	if !strings.HasSuffix(name.String, "_test") {
+		t.Fatalf("LATENTIA_TEST_DSN selects database %q; use a scratch database ending in _test", name.String)
+	}
+	for _, stmt := range []string{
+		"DROP TABLE IF EXISTS app_rewrites",
+		`CREATE TABLE app_rewrites (
+			id BIGINT PRIMARY KEY,
+			status VARCHAR(20) NOT NULL,
+			confidence_score DECIMAL(3,2) NOT NULL,
+			pattern_analysis JSON NOT NULL,
+			prompt_version VARCHAR(128) NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			reviewed_at DATETIME NULL
+		)`,
+	} {
+		if _, err := pool.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("failed to prepare fixtures: %v\n%s", err, stmt)
+		}
+	}
+	return &database.DB{DB: pool}
+}
+
+func TestConfidenceCalibration(t *testing.T) {
+	db := openCalibrationDB(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	old := now.AddDate(0, 0, -30)
+
+	rewrites := []struct {
+		id         int64
+		status     string
+		confidence float64
+		patterns   string
+		created    time.Time
+		review     time.Duration // after creation, 0 for none
+	}{
+		{1, "rejected", 0.00, `["select-star"]`, now, time.Minute},
+		{2, "rejected", 0.09, `[]`, now, 3 * time.Minute},
+		{3, "accepted", 0.10, `["select-star", "missing-limit"]`, now, time.Hour},
+		{4, "pending", 0.55, `["missing-limit"]`, now, 0},
+		{5, "accepted", 0.90, `[]`, now, 10 * time.Second},
+		{6, "rejected", 0.99, `["function-in-where"]`, now, 20 * time.Second},
+		{7, "accepted", 1.00, `["function-in-where"]`, now, 30 * time.Second},
+		// Never up for review
+		{8, "invalid", 1.00, `["select-star"]`, now, 0},
+		{9, "suppressed", 0.50, `["select-star"]`, now, 0},
+		// Before the since of the second report
+		{10, "accepted", 0.95, `["select-star"]`, old, time.Second},
+	}
+	for _, r := range rewrites {
+		var reviewed any
+		if r.review > 0 {
+			reviewed = r.created.Add(r.review)
+		}
+		if _, err := db.ExecContext(context.Background(),
+			"INSERT INTO app_rewrites (id, status, confidence_score, pattern_analysis, created_at, reviewed_at) VALUES (?, ?, ?, ?, ?, ?)",
+			r.id, r.status, r.confidence, `{"anti_patterns": `+r.patterns+`}`, r.created, reviewed); err != nil {
+			t.Fatalf("failed to insert rewrite %d: %v", r.id, err)
+		}
+	}
+
+	oe := &OptimizationEngine{db: db}
+	c, err := oe.ConfidenceCalibration(context.Background(), time.Time{})
+	if err != nil {
+		t.Fatalf("ConfidenceCalibration: %v", err)
+	}
+
+	// 0.00 and 0.09 share the first decile, 0.10 starts the second and 1.00
+	// joins 0.90 to 0.99 in the last
+	want := map[int]CalibrationBucket{
+		0: {Rewrites: 2, Rejected: 2, AcceptanceRate: ratio(0, 2), AvgReviewSeconds: ratio(60+180, 2)},
+		1: {Rewrites: 1, Accepted: 1, AcceptanceRate: ratio(1, 1), AvgReviewSeconds: ratio(3600, 1)},
+		5: {Rewrites: 1, Pending: 1},
+		9: {Rewrites: 4, Accepted: 3, Rejected: 1, AcceptanceRate: ratio(3, 4), AvgReviewSeconds: ratio(10+20+30+1, 4)},
+	}
+	for i, b := range c.Buckets {
+		w := want[i]
+		w.MinConfidence, w.MaxConfidence = b.MinConfidence, b.MaxConfidence
+		if !reflect.DeepEqual(b, w) {
+			t.Errorf("bucket %d: %s, want %s", i, rates(b), rates(w))
+		}
+	}
+	if c.Rewrites != 8 || c.Since != nil {
+		t.Errorf("%d rewrites since %v, want the 8 reviewable ones", c.Rewrites, c.Since)
+	}
+
+	var codes []string
+	for _, p := range c.Patterns {
+		codes = append(codes, fmt.Sprintf("%s:%d", p.Code, p.Rewrites))
+	}
+	if want := []string{"select-star:3", "function-in-where:2", "missing-limit:2"}; !reflect.DeepEqual(codes, want) {
+		t.Errorf("patterns = %q, want %q", codes, want)
+	}
+	if p := c.Patterns[1]; math.Abs(p.AvgConfidence-0.995) > 1e-9 || *p.AcceptanceRate != 0.5 {
+		t.Errorf("function-in-where = %+v", p)
+	}
+
+	// Only the rewrites created since a time
+	c, err = oe.ConfidenceCalibration(context.Background(), now.AddDate(0, 0, -1))
+	if err != nil {
+		t.Fatalf("ConfidenceCalibration since: %v", err)
+	}
+	if c.Rewrites != 7 || c.Buckets[9].Rewrites != 3 || c.Since == nil {
+		t.Errorf("since yesterday: %d rewrites, %d in the last decile", c.Rewrites, c.Buckets[9].Rewrites)
+	}
+	if len(c.PromptVersions) != 1 || c.PromptVersions[0].Version != "" || c.PromptVersions[0].Rewrites != 7 {
+		t.Errorf("prompt versions = %+v", c.PromptVersions)
+	}
+}
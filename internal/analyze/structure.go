@@ -0,0 +1,483 @@
+package analyze
+
+import (
+	"strings"
+)
+
+// queryStructure is what the analyzer reads from the token structure of a
+// statement rather than from its text: real table references, with CTE names,
+// aliases and derived tables left out, and the shape of its joins.
+type queryStructure struct {
+	// statement is SELECT, INSERT, UPDATE, DELETE or REPLACE; a CTE takes
+	// the type of the statement it introduces
+	statement string
+	// tables are the base tables referenced anywhere, as written without
+	// quotes, such as orders or shop.orders, in order of appearance
+	tables     []string
+	joins      int // explicit and comma joins
+	subqueries int // derived tables, CTE bodies and subqueries in expressions
+	unions     int // UNION, EXCEPT and INTERSECT
+	selectStar bool
+	// crossJoin is set when a join has no condition: CROSS JOIN, JOIN
+	// without ON or USING, or comma joins without a column equality
+	// between two tables in WHERE
+	crossJoin  bool
+	inSubquery bool // a subquery is the right side of IN
+	// where is set when the statement itself, not only a subquery, has a
+	// WHERE clause
+	where bool
+}
+
+// clauseTerminators end a FROM clause at its depth
+var clauseTerminators = map[string]bool{
+	"WHERE": true, "GROUP": true, "HAVING": true, "ORDER": true, "LIMIT": true,
+	"UNION": true, "EXCEPT": true, "INTERSECT": true, "WINDOW": true, "FOR": true,
+	"LOCK": true, "SET": true, "VALUES": true, "SELECT": true, "RETURNING": true,
+}
+
+// joinStartWords begin a join operator in a FROM clause
+var joinStartWords = map[string]bool{
+	"JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true, "FULL": true,
+	"CROSS": true, "NATURAL": true, "STRAIGHT_JOIN": true,
+}
+
+// structureParser walks the tokens of one statement, recursing into
+// parentheses; failed is set on anything it does not understand
+type structureParser struct {
+	tokens  []sqlToken
+	closing []int           // index of the ")" matching each "("
+	ctes    map[string]bool // lower-cased CTE names, which are not tables
+	seen    map[string]bool // tables already recorded
+	result  queryStructure
+	failed  bool
+}
+
+// parseQueryStructure reads the structure of sql. It reports false for
+// input it cannot follow, such as unbalanced parentheses or statements other
+// than SELECT, INSERT, UPDATE, DELETE and REPLACE, so callers can fall back
+// to text heuristics.
+func parseQueryStructure(sql string) (*queryStructure, bool) {
+	tokens := tokenizeSQL(sql)
+	for len(tokens) > 0 && tokens[len(tokens)-1].text == ";" {
+		tokens = tokens[:len(tokens)-1]
+	}
+	if len(tokens) == 0 {
+		return nil, false
+	}
+
+	p := &structureParser{tokens: tokens, ctes: map[string]bool{}, seen: map[string]bool{}}
+	if !p.matchParentheses() {
+		return nil, false
+	}
+	p.result.statement = p.statementType()
+	if p.result.statement == "" {
+		return nil, false
+	}
+
+	p.query(0, len(tokens))
+	if p.failed {
+		return nil, false
+	}
+	return &p.result, true
+}
+
+// matchParentheses records the closing parenthesis of each opening one
+func (p *structureParser) matchParentheses() bool {
+	p.closing = make([]int, len(p.tokens))
+	var open []int
+	for i, tok := range p.tokens {
+		switch tok.text {
+		case "(":
+			open = append(open, i)
+		case ")":
+			if len(open) == 0 {
+				return false
+			}
+			p.closing[open[len(open)-1]] = i
+			open = open[:len(open)-1]
+		}
+	}
+	return len(open) == 0
+}
+
+// statementType returns the statement the tokens start, skipping opening
+// parentheses and CTE definitions
+func (p *structureParser) statementType() string {
+	i := 0
+	for i < len(p.tokens) && p.tokens[i].text == "(" {
+		i++
+	}
+	if i < len(p.tokens) && p.tokens[i].text == "WITH" {
+		for i++; i < len(p.tokens); i++ {
+			tok := p.tokens[i]
+			if tok.text == "(" {
+				i = p.closing[i]
+				continue
+			}
+			if word := upperWord(tok); isStatementWord(word) {
+				return word
+			}
+		}
+		return ""
+	}
+	if i < len(p.tokens) {
+		if word := upperWord(p.tokens[i]); isStatementWord(word) {
+			return word
+		}
+	}
+	return ""
+}
+
+func isStatementWord(word string) bool {
+	switch word {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "REPLACE":
+		return true
+	}
+	return false
+}
+
+// upperWord returns a keyword or identifier token upper-cased, "" for other
+// tokens; statement words such as INSERT are not formatter keywords
+func upperWord(tok sqlToken) string {
+	if tok.kind == tokenKeyword || tok.kind == tokenWord {
+		return strings.ToUpper(tok.text)
+	}
+	return ""
+}
+
+// query reads tokens[start:end], a statement or query expression
+func (p *structureParser) query(start, end int) {
+	if start >= end {
+		return
+	}
+	depth := p.tokens[start].depth
+	i := start
+	if p.tokens[i].text == "WITH" {
+		i = p.ctesAt(i+1, end)
+	}
+
+	inSelectList, inWhere := false, false
+	unconditioned, whereJoin := 0, false
+	for ; i < end && !p.failed; i++ {
+		tok := p.tokens[i]
+		if tok.text == "(" {
+			p.nested(i)
+			i = p.closing[i]
+			continue
+		}
+
+		switch word := upperWord(tok); {
+		case word == "SELECT":
+			inSelectList, inWhere = true, false
+		case word == "FROM" && tok.kind == tokenKeyword:
+			inSelectList, inWhere = false, false
+			next, cross := p.fromList(i+1, end, depth)
+			unconditioned += cross
+			i = next - 1
+		case (word == "UPDATE" || word == "INSERT" || word == "REPLACE") && i == p.statementStart(start):
+			i = p.target(word, i+1, end, depth, &unconditioned) - 1
+		case word == "WHERE":
+			inSelectList, inWhere = false, true
+			p.result.where = p.result.where || tok.depth == 0
+		case word == "UNION" || word == "EXCEPT" || word == "INTERSECT":
+			p.result.unions++
+			inSelectList, inWhere = false, false
+		case clauseTerminators[word]:
+			inSelectList, inWhere = false, false
+		case tok.text == "*" && inSelectList && i > start && startsSelectItem(p.tokens[i-1]):
+			p.result.selectStar = true
+		case inWhere && (tok.text == "=" || tok.text == "<=>") && p.joinsTwoTables(i, start, end):
+			whereJoin = true
+		}
+	}
+
+	if unconditioned > 0 && !whereJoin {
+		p.result.crossJoin = true
+	}
+}
+
+// statementStart is the index of the statement word of the query at start,
+// after any CTE definitions
+func (p *structureParser) statementStart(start int) int {
+	if p.tokens[start].text != "WITH" {
+		return start
+	}
+	for i := start + 1; i < len(p.tokens); i++ {
+		if p.tokens[i].text == "(" {
+			i = p.closing[i]
+			continue
+		}
+		if isStatementWord(upperWord(p.tokens[i])) {
+			return i
+		}
+	}
+	return start
+}
+
+// startsSelectItem reports whether a "*" after tok is a select item rather
+// than a multiplication: after SELECT, a modifier, a hint, a comma or the
+// dot of t.*
+func startsSelectItem(tok sqlToken) bool {
+	return tok.text == "SELECT" || tok.text == "," || tok.text == "." || tok.kind == tokenHint || selectModifiers[tok.text]
+}
+
+// joinsTwoTables reports whether the comparison at i is t1.a = t2.b, an
+// implicit join condition between two different tables
+func (p *structureParser) joinsTwoTables(i, start, end int) bool {
+	if i-3 < start || i+4 > end {
+		return false
+	}
+	left, right := p.tokens[i-3:i], p.tokens[i+1:i+4]
+	qualified := func(ts []sqlToken) bool {
+		return isIdentifier(ts[0]) && ts[1].text == "." && isIdentifier(ts[2])
+	}
+	return qualified(left) && qualified(right) && !strings.EqualFold(identifierName(left[0]), identifierName(right[0]))
+}
+
+// ctesAt reads the CTE definitions after WITH, returning the index of the
+// statement they introduce
+func (p *structureParser) ctesAt(i, end int) int {
+	if i < end && p.tokens[i].text == "RECURSIVE" {
+		i++
+	}
+	for i < end && !p.failed {
+		if !isIdentifier(p.tokens[i]) {
+			p.failed = true
+			return end
+		}
+		p.ctes[strings.ToLower(identifierName(p.tokens[i]))] = true
+		i++
+		if i < end && p.tokens[i].text == "(" {
+			// Column list
+			i = p.closing[i] + 1
+		}
+		if i >= end || p.tokens[i].text != "AS" || i+1 >= end || p.tokens[i+1].text != "(" {
+			p.failed = true
+			return end
+		}
+		open := i + 1
+		p.result.subqueries++
+		p.query(open+1, p.closing[open])
+		i = p.closing[open] + 1
+		if i < end && p.tokens[i].text == "," {
+			i++
+			continue
+		}
+		return i
+	}
+	return i
+}
+
+// nested reads the parenthesized tokens opened at open: a subquery, or an
+// expression that may hold subqueries
+func (p *structureParser) nested(open int) {
+	close := p.closing[open]
+	if open+1 >= close {
+		return
+	}
+	if first := p.tokens[open+1].text; first == "SELECT" || first == "WITH" || first == "(" && p.startsQuery(open+1) {
+		p.result.subqueries++
+		if open > 0 && p.tokens[open-1].text == "IN" {
+			p.result.inSubquery = true
+		}
+		p.query(open+1, close)
+		return
+	}
+	for i := open + 1; i < close; i++ {
+		if p.tokens[i].text == "(" {
+			p.nested(i)
+			i = p.closing[i]
+		}
+	}
+}
+
+// startsQuery reports whether the parentheses opened at open hold a query,
+// as in ((SELECT ...) UNION (SELECT ...))
+func (p *structureParser) startsQuery(open int) bool {
+	for open < len(p.tokens) && p.tokens[open].text == "(" {
+		open++
+	}
+	return open < len(p.tokens) && (p.tokens[open].text == "SELECT" || p.tokens[open].text == "WITH")
+}
+
+// fromList reads table references from i up to the end of the FROM clause at
+// depth, returning where the clause ends and how many joins had no condition
+func (p *structureParser) fromList(i, end, depth int) (int, int) {
+	unconditioned := 0
+	i = p.factor(i, end, depth)
+	for i < end && !p.failed {
+		tok := p.tokens[i]
+		if tok.depth < depth {
+			return i, unconditioned
+		}
+		word := upperWord(tok)
+		switch {
+		case tok.text == ",":
+			p.result.joins++
+			unconditioned++
+			i = p.factor(i+1, end, depth)
+		case tok.kind == tokenKeyword && joinStartWords[word]:
+			natural, cross := false, false
+			for i < end && p.tokens[i].kind == tokenKeyword && (joinStartWords[p.tokens[i].text] || p.tokens[i].text == "OUTER") {
+				w := p.tokens[i].text
+				natural = natural || w == "NATURAL"
+				cross = cross || w == "CROSS"
+				i++
+				if w == "JOIN" || w == "STRAIGHT_JOIN" {
+					break
+				}
+			}
+			p.result.joins++
+			i = p.factor(i, end, depth)
+			if i < end && (p.tokens[i].text == "ON" || p.tokens[i].text == "USING") {
+				i = p.condition(i+1, end, depth)
+			} else if !natural {
+				if cross {
+					p.result.crossJoin = true
+				} else {
+					unconditioned++
+				}
+			}
+		case tok.text == "(":
+			p.nested(i)
+			i = p.closing[i] + 1
+		case clauseTerminators[word] || word == "ON" && tok.depth == depth:
+			return i, unconditioned
+		default:
+			// Index hints, partitions and the like
+			i++
+		}
+	}
+	return i, unconditioned
+}
+
+// condition skips an ON or USING condition, reading any subqueries in it,
+// up to the next join or the end of the FROM clause
+func (p *structureParser) condition(i, end, depth int) int {
+	for ; i < end; i++ {
+		tok := p.tokens[i]
+		word := upperWord(tok)
+		if tok.depth < depth || tok.depth == depth && (tok.text == "," || joinStartWords[word] && tok.kind == tokenKeyword || clauseTerminators[word]) {
+			return i
+		}
+		if tok.text == "(" {
+			p.nested(i)
+			i = p.closing[i]
+		}
+	}
+	return i
+}
+
+// factor reads one table reference: a table name with an optional alias, a
+// derived table, or a parenthesized join
+func (p *structureParser) factor(i, end, depth int) int {
+	if i >= end {
+		p.failed = true
+		return end
+	}
+	if p.tokens[i].text == "LATERAL" {
+		i++
+	}
+	if i < end && p.tokens[i].text == "(" {
+		close := p.closing[i]
+		if p.startsQuery(i + 1) {
+			p.nested(i)
+		} else {
+			next, cross := p.fromList(i+1, close, depth+1)
+			if next < close {
+				p.failed = true
+			}
+			if cross > 0 {
+				p.result.crossJoin = true
+			}
+		}
+		return p.alias(close+1, end)
+	}
+
+	name, next := p.tableName(i, end)
+	if name == "" {
+		p.failed = true
+		return end
+	}
+	p.addTable(name)
+	return p.alias(next, end)
+}
+
+// target reads the table an INSERT, REPLACE or UPDATE writes, which for
+// UPDATE may be a join
+func (p *structureParser) target(statement string, i, end, depth int, unconditioned *int) int {
+	for i < end && p.tokens[i].kind != tokenQuoted {
+		switch upperWord(p.tokens[i]) {
+		case "LOW_PRIORITY", "DELAYED", "HIGH_PRIORITY", "IGNORE", "INTO":
+			i++
+			continue
+		}
+		break
+	}
+	if statement == "UPDATE" {
+		next, cross := p.fromList(i, end, depth)
+		*unconditioned += cross
+		return next
+	}
+	name, next := p.tableName(i, end)
+	if name == "" {
+		p.failed = true
+		return end
+	}
+	p.addTable(name)
+	return next
+}
+
+// tableName reads a possibly schema-qualified name at i
+func (p *structureParser) tableName(i, end int) (string, int) {
+	if i >= end || !isIdentifier(p.tokens[i]) {
+		return "", i
+	}
+	parts := []string{identifierName(p.tokens[i])}
+	i++
+	for i+1 < end && p.tokens[i].text == "." && isIdentifier(p.tokens[i+1]) {
+		parts = append(parts, identifierName(p.tokens[i+1]))
+		i += 2
+	}
+	return strings.Join(parts, "."), i
+}
+
+// alias skips an optional [AS] alias after a table reference
+func (p *structureParser) alias(i, end int) int {
+	if i < end && p.tokens[i].text == "AS" {
+		i++
+	}
+	if i < end && isIdentifier(p.tokens[i]) {
+		i++
+	}
+	return i
+}
+
+func (p *structureParser) addTable(name string) {
+	key := strings.ToLower(name)
+	if key == "dual" || p.ctes[key] || p.seen[key] {
+		return
+	}
+	p.seen[key] = true
+	p.result.tables = append(p.result.tables, name)
+}
+
+// isIdentifier reports whether tok can name a table, column or alias
+func isIdentifier(tok sqlToken) bool {
+	if tok.kind == tokenQuoted {
+		return true
+	}
+	return tok.kind == tokenWord && !clauseTerminators[strings.ToUpper(tok.text)] && strings.ToUpper(tok.text) != "INTO"
+}
+
+// identifierName strips the backticks of a quoted identifier; a name the
+// tokenizer took for a function call is returned as written
+func identifierName(tok sqlToken) string {
+	if tok.kind == tokenQuoted {
+		return strings.ReplaceAll(strings.TrimSuffix(strings.TrimPrefix(tok.text, "`"), "`"), "``", "`")
+	}
+	if tok.raw != "" {
+		return tok.raw
+	}
+	return tok.text
+}
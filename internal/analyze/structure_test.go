@@ -0,0 +1,274 @@
+package analyze
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQueryStructure(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want queryStructure
+	}{
+		{
+			"simple select",
+			"SELECT id FROM orders WHERE id = 1",
+			queryStructure{statement: "SELECT", tables: []string{"orders"}, where: true},
+		},
+		{
+			"select star",
+			"SELECT * FROM orders",
+			queryStructure{statement: "SELECT", tables: []string{"orders"}, selectStar: true},
+		},
+		{
+			"qualified star",
+			"SELECT o.* FROM orders o",
+			queryStructure{statement: "SELECT", tables: []string{"orders"}, selectStar: true},
+		},
+		{
+			"multiplication is not a star",
+			"SELECT price * qty FROM order_items",
+			queryStructure{statement: "SELECT", tables: []string{"order_items"}},
+		},
+		{
+			"count star is not a select star",
+			"SELECT COUNT(*) FROM orders",
+			queryStructure{statement: "SELECT", tables: []string{"orders"}},
+		},
+		{
+			"trailing semicolons",
+			"SELECT id FROM orders;;",
+			queryStructure{statement: "SELECT", tables: []string{"orders"}},
+		},
+		{
+			"schema-qualified name",
+			"SELECT id FROM shop.orders",
+			queryStructure{statement: "SELECT", tables: []string{"shop.orders"}},
+		},
+		{
+			"quoted names",
+			"SELECT `id` FROM `shop`.`order items` AS `o`",
+			queryStructure{statement: "SELECT", tables: []string{"shop.order items"}},
+		},
+		{
+			"escaped backtick in quoted name",
+			"SELECT 1 FROM `we``ird`",
+			queryStructure{statement: "SELECT", tables: []string{"we`ird"}},
+		},
+		{
+			"alias without AS",
+			"SELECT o.id FROM orders o WHERE o.total > 10",
+			queryStructure{statement: "SELECT", tables: []string{"orders"}, where: true},
+		},
+		{
+			"dual is not a table",
+			"SELECT 1 FROM dual",
+			queryStructure{statement: "SELECT"},
+		},
+		{
+			"inner join",
+			"SELECT o.id FROM orders o JOIN customers c ON c.id = o.customer_id",
+			queryStructure{statement: "SELECT", tables: []string{"orders", "customers"}, joins: 1},
+		},
+		{
+			"left outer join using",
+			"SELECT * FROM orders LEFT OUTER JOIN customers USING (customer_id)",
+			queryStructure{statement: "SELECT", tables: []string{"orders", "customers"}, joins: 1, selectStar: true},
+		},
+		{
+			"three-way join",
+			"SELECT 1 FROM a JOIN b ON b.a_id = a.id LEFT JOIN c ON c.b_id = b.id",
+			queryStructure{statement: "SELECT", tables: []string{"a", "b", "c"}, joins: 2},
+		},
+		{
+			"cross join",
+			"SELECT 1 FROM a CROSS JOIN b",
+			queryStructure{statement: "SELECT", tables: []string{"a", "b"}, joins: 1, crossJoin: true},
+		},
+		{
+			"join without condition",
+			"SELECT 1 FROM a JOIN b",
+			queryStructure{statement: "SELECT", tables: []string{"a", "b"}, joins: 1, crossJoin: true},
+		},
+		{
+			"natural join has a condition",
+			"SELECT 1 FROM a NATURAL JOIN b",
+			queryStructure{statement: "SELECT", tables: []string{"a", "b"}, joins: 1},
+		},
+		{
+			"comma join with equality",
+			"SELECT 1 FROM orders o, customers c WHERE o.customer_id = c.id",
+			queryStructure{statement: "SELECT", tables: []string{"orders", "customers"}, joins: 1, where: true},
+		},
+		{
+			"comma join without equality",
+			"SELECT 1 FROM orders o, customers c WHERE o.total > 10",
+			queryStructure{statement: "SELECT", tables: []string{"orders", "customers"}, joins: 1, crossJoin: true, where: true},
+		},
+		{
+			"index hint",
+			"SELECT id FROM orders USE INDEX (idx_status) WHERE status = 'paid'",
+			queryStructure{statement: "SELECT", tables: []string{"orders"}, where: true},
+		},
+		{
+			"derived table",
+			"SELECT t.n FROM (SELECT COUNT(*) AS n FROM orders) AS t",
+			queryStructure{statement: "SELECT", tables: []string{"orders"}, subqueries: 1},
+		},
+		{
+			"derived table joined",
+			"SELECT c.name, t.n FROM customers c JOIN (SELECT customer_id, COUNT(*) n FROM orders GROUP BY customer_id) t ON t.customer_id = c.id",
+			queryStructure{statement: "SELECT", tables: []string{"customers", "orders"}, joins: 1, subqueries: 1},
+		},
+		{
+			"nested derived tables",
+			"SELECT * FROM (SELECT * FROM (SELECT id FROM orders) a) b",
+			queryStructure{statement: "SELECT", tables: []string{"orders"}, subqueries: 2, selectStar: true},
+		},
+		{
+			"parenthesized join",
+			"SELECT 1 FROM (a JOIN b ON a.id = b.a_id)",
+			queryStructure{statement: "SELECT", tables: []string{"a", "b"}, joins: 1},
+		},
+		{
+			"IN subquery",
+			"SELECT id FROM customers WHERE id IN (SELECT customer_id FROM orders)",
+			queryStructure{statement: "SELECT", tables: []string{"customers", "orders"}, subqueries: 1, inSubquery: true, where: true},
+		},
+		{
+			"EXISTS subquery",
+			"SELECT id FROM customers c WHERE EXISTS (SELECT 1 FROM orders o WHERE o.customer_id = c.id)",
+			queryStructure{statement: "SELECT", tables: []string{"customers", "orders"}, subqueries: 1, where: true},
+		},
+		{
+			"subquery only WHERE",
+			"SELECT * FROM (SELECT id FROM orders WHERE total > 0) t",
+			queryStructure{statement: "SELECT", tables: []string{"orders"}, subqueries: 1, selectStar: true},
+		},
+		{
+			"scalar subquery in select list",
+			"SELECT id, (SELECT MAX(total) FROM orders o WHERE o.customer_id = c.id) FROM customers c",
+			queryStructure{statement: "SELECT", tables: []string{"orders", "customers"}, subqueries: 1},
+		},
+		{
+			"union",
+			"SELECT id FROM a UNION SELECT id FROM b",
+			queryStructure{statement: "SELECT", tables: []string{"a", "b"}, unions: 1},
+		},
+		{
+			"union all of three",
+			"SELECT id FROM a UNION ALL SELECT id FROM b UNION ALL SELECT id FROM a",
+			queryStructure{statement: "SELECT", tables: []string{"a", "b"}, unions: 2},
+		},
+		{
+			"parenthesized union",
+			"(SELECT id FROM a) UNION (SELECT id FROM b) ORDER BY id",
+			queryStructure{statement: "SELECT", tables: []string{"a", "b"}, unions: 1, subqueries: 2},
+		},
+		{
+			"except and intersect",
+			"SELECT id FROM a EXCEPT SELECT id FROM b INTERSECT SELECT id FROM c",
+			queryStructure{statement: "SELECT", tables: []string{"a", "b", "c"}, unions: 2},
+		},
+		{
+			"cte",
+			"WITH paid AS (SELECT * FROM orders WHERE status = 'paid') SELECT COUNT(*) FROM paid",
+			queryStructure{statement: "SELECT", tables: []string{"orders"}, subqueries: 1, selectStar: true},
+		},
+		{
+			"cte name case-insensitive",
+			"WITH Paid AS (SELECT id FROM orders) SELECT id FROM PAID",
+			queryStructure{statement: "SELECT", tables: []string{"orders"}, subqueries: 1},
+		},
+		{
+			"several ctes with column list",
+			"WITH a (n) AS (SELECT id FROM orders), b AS (SELECT n FROM a) SELECT * FROM b JOIN customers c ON c.id = b.n",
+			queryStructure{statement: "SELECT", tables: []string{"orders", "customers"}, joins: 1, subqueries: 2, selectStar: true},
+		},
+		{
+			"recursive cte",
+			"WITH RECURSIVE n AS (SELECT 1 AS i UNION ALL SELECT i + 1 FROM n WHERE i < 10) SELECT i FROM n",
+			queryStructure{statement: "SELECT", subqueries: 1, unions: 1},
+		},
+		{
+			"cte feeding a delete",
+			"WITH old AS (SELECT id FROM orders WHERE total = 0) DELETE FROM orders WHERE id IN (SELECT id FROM old)",
+			queryStructure{statement: "DELETE", tables: []string{"orders"}, subqueries: 2, inSubquery: true, where: true},
+		},
+		{
+			"update",
+			"UPDATE orders SET status = 'paid' WHERE id = 1",
+			queryStructure{statement: "UPDATE", tables: []string{"orders"}, where: true},
+		},
+		{
+			"update without where",
+			"UPDATE LOW_PRIORITY orders SET status = 'paid'",
+			queryStructure{statement: "UPDATE", tables: []string{"orders"}},
+		},
+		{
+			"multi-table update",
+			"UPDATE orders o JOIN customers c ON c.id = o.customer_id SET o.vip = 1 WHERE c.tier = 'gold'",
+			queryStructure{statement: "UPDATE", tables: []string{"orders", "customers"}, joins: 1, where: true},
+		},
+		{
+			"delete",
+			"DELETE FROM shop.sessions WHERE expires_at < NOW()",
+			queryStructure{statement: "DELETE", tables: []string{"shop.sessions"}, where: true},
+		},
+		{
+			"insert select",
+			"INSERT INTO archive (id) SELECT id FROM orders WHERE created_at < '2020-01-01'",
+			queryStructure{statement: "INSERT", tables: []string{"archive", "orders"}, where: true},
+		},
+		{
+			"insert ignore values",
+			"INSERT IGNORE INTO `audit` VALUES (1, 'x')",
+			queryStructure{statement: "INSERT", tables: []string{"audit"}},
+		},
+		{
+			"replace",
+			"REPLACE INTO counters SELECT name, COUNT(*) FROM events GROUP BY name",
+			queryStructure{statement: "REPLACE", tables: []string{"counters", "events"}},
+		},
+		{
+			"keywords inside literals",
+			"SELECT 'FROM x JOIN y' FROM orders WHERE note = 'UNION SELECT'",
+			queryStructure{statement: "SELECT", tables: []string{"orders"}, where: true},
+		},
+		{
+			"table repeated once",
+			"SELECT 1 FROM orders a JOIN orders b ON a.id = b.parent_id",
+			queryStructure{statement: "SELECT", tables: []string{"orders"}, joins: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseQueryStructure(tt.sql)
+			if !ok {
+				t.Fatalf("parseQueryStructure(%q) failed", tt.sql)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("parseQueryStructure(%q) =\n%+v\nwant\n%+v", tt.sql, *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQueryStructureRefuses(t *testing.T) {
+	for _, sql := range []string{
+		"",
+		";",
+		"SELECT (1 FROM orders",
+		"SELECT 1) FROM orders",
+		"SHOW TABLES",
+		"SET @x = 1",
+		"CREATE TABLE t (id INT)",
+		"WITH x SELECT 1",
+		"SELECT 1 FROM",
+	} {
+		if got, ok := parseQueryStructure(sql); ok {
+			t.Errorf("parseQueryStructure(%q) = %+v, want failure", sql, *got)
+		}
+	}
+}
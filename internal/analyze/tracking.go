@@ -0,0 +1,334 @@
+package analyze
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// Realized statuses of accepted rewrites
+const (
+	RealizedImproved     = "improved"
+	RealizedUnchanged    = "unchanged"
+	RealizedRegressed    = "regressed"
+	RealizedInconclusive = "inconclusive"
+)
+
+// TrackingPolicy controls how realized improvements are measured
+type TrackingPolicy struct {
+	// Window is how far before and after acceptance occurrences are compared
+	Window time.Duration
+
+	// MinSamples is the number of occurrences needed on each side of the
+	// acceptance before a result is reported
+	MinSamples int
+
+	// RegressionThreshold is the relative change in average query time
+	// below which a rewrite counts as unchanged, e.g. 0.1 for 10%
+	RegressionThreshold float64
+}
+
+// RealizedImprovement compares the occurrences of a rewrite's digest that
+// were ingested before and after it was accepted. Only occurrences slow enough
+// to reach the slow query log are seen, so the figures are estimates.
+type RealizedImprovement struct {
+	Status        string  `json:"status"`
+	BeforeAvgTime float64 `json:"before_avg_time"`
+	AfterAvgTime  float64 `json:"after_avg_time"`
+	BeforeSamples int     `json:"before_samples"`
+	AfterSamples  int     `json:"after_samples"`
+
+	// MinutesSavedPerDay is the time saved per day at the rate occurrences
+	// were seen after acceptance, negative for regressions and nil when
+	// inconclusive
+	MinutesSavedPerDay *float64  `json:"minutes_saved_per_day,omitempty"`
+	TrackedAt          time.Time `json:"tracked_at"`
+}
+
+// TrackedRewrite is the realized improvement of one accepted rewrite
+type TrackedRewrite struct {
+	RewriteID  int64     `json:"rewrite_id"`
+	Digest     string    `json:"digest"`
+	ReviewedAt time.Time `json:"reviewed_at"`
+	RealizedImprovement
+}
+
+// RealizedStats aggregates the realized improvements of accepted rewrites
+type RealizedStats struct {
+	Tracked      int `json:"tracked"`
+	Improved     int `json:"improved"`
+	Unchanged    int `json:"unchanged"`
+	Regressed    int `json:"regressed"`
+	Inconclusive int `json:"inconclusive"`
+
+	// MinutesSavedPerDay sums the conclusive rewrites, regressions included
+	MinutesSavedPerDay float64 `json:"minutes_saved_per_day"`
+
+	// Rewrites lists the tracked rewrites, most time saved first
+	Rewrites []TrackedRewrite `json:"rewrites"`
+}
+
+// TrackingSummary reports what a tracking run did
+type TrackingSummary struct {
+	Tracked   int
+	Regressed []int64
+}
+
+// TrackRealizedImprovements measures every accepted rewrite whose after
+// window was still open when it was last measured. Rewrites of digests that
+// got slower are marked regressed.
+func (oe *OptimizationEngine) TrackRealizedImprovements(ctx context.Context, policy TrackingPolicy) (*TrackingSummary, error) {
+	window := int64(policy.Window.Seconds())
+	rows, err := oe.db.QueryContext(ctx, `
+		SELECT id FROM app_rewrites
+		WHERE status = 'accepted' AND reviewed_at IS NOT NULL
+		  AND (tracked_at IS NULL OR tracked_at < reviewed_at + INTERVAL ? SECOND)
+		ORDER BY id
+	`, window)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list accepted rewrites: %w", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan rewrite id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list accepted rewrites: %w", err)
+	}
+
+	summary := &TrackingSummary{}
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return summary, ctx.Err()
+		}
+		realized, err := oe.measureRealized(ctx, id, policy)
+		if err == sql.ErrNoRows {
+			// The slow query was purged, nothing left to compare against
+			continue
+		}
+		if err != nil {
+			return summary, err
+		}
+		if err := oe.storeRealized(ctx, id, realized); err != nil {
+			return summary, err
+		}
+		summary.Tracked++
+		if realized.Status == RealizedRegressed {
+			summary.Regressed = append(summary.Regressed, id)
+			slog.WarnContext(ctx, "accepted rewrite regressed",
+				"rewrite_id", id, "before_avg_time", realized.BeforeAvgTime, "after_avg_time", realized.AfterAvgTime,
+				"before_samples", realized.BeforeSamples, "after_samples", realized.AfterSamples)
+		}
+	}
+	return summary, nil
+}
+
+// measureRealized compares the occurrences of a rewrite's digest within
+// policy.Window before and after reviewed_at. Times are compared in the
+// database, where both were recorded.
+func (oe *OptimizationEngine) measureRealized(ctx context.Context, id int64, policy TrackingPolicy) (*RealizedImprovement, error) {
+	window := int64(policy.Window.Seconds())
+	var (
+		realized     RealizedImprovement
+		afterSeconds int64
+	)
+	err := oe.db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(CASE WHEN sq.started_at < r.reviewed_at THEN 1 END),
+			COALESCE(AVG(CASE WHEN sq.started_at < r.reviewed_at THEN sq.query_time END), 0),
+			COUNT(CASE WHEN sq.started_at >= r.reviewed_at THEN 1 END),
+			COALESCE(AVG(CASE WHEN sq.started_at >= r.reviewed_at THEN sq.query_time END), 0),
+			LEAST(GREATEST(TIMESTAMPDIFF(SECOND, r.reviewed_at, NOW()), 0), ?)
+		FROM app_rewrites r
+		JOIN app_slow_queries origin ON origin.id = r.slow_query_id
+		LEFT JOIN app_slow_queries sq ON sq.digest = origin.digest
+			AND sq.started_at >= r.reviewed_at - INTERVAL ? SECOND
+			AND sq.started_at < r.reviewed_at + INTERVAL ? SECOND
+		WHERE r.id = ?
+		GROUP BY r.id, r.reviewed_at
+	`, window, window, window, id).Scan(
+		&realized.BeforeSamples, &realized.BeforeAvgTime,
+		&realized.AfterSamples, &realized.AfterAvgTime,
+		&afterSeconds,
+	)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure rewrite %d: %w", id, err)
+	}
+
+	realized.Status = classifyRealized(&realized, policy)
+	if realized.Status != RealizedInconclusive && afterSeconds > 0 {
+		perDay := float64(realized.AfterSamples) / (float64(afterSeconds) / 86400)
+		saved := (realized.BeforeAvgTime - realized.AfterAvgTime) * perDay / 60
+		realized.MinutesSavedPerDay = &saved
+	}
+	realized.TrackedAt = time.Now()
+	return &realized, nil
+}
+
+// classifyRealized decides the status from the averages, refusing to draw
+// conclusions from fewer than policy.MinSamples occurrences on either side
+func classifyRealized(r *RealizedImprovement, policy TrackingPolicy) string {
+	switch {
+	case r.BeforeSamples < policy.MinSamples || r.AfterSamples < policy.MinSamples || r.BeforeAvgTime <= 0:
+		return RealizedInconclusive
+	case r.AfterAvgTime > r.BeforeAvgTime*(1+policy.RegressionThreshold):
+		return RealizedRegressed
+	case r.AfterAvgTime < r.BeforeAvgTime*(1-policy.RegressionThreshold):
+		return RealizedImproved
+	}
+	return RealizedUnchanged
+}
+
+func (oe *OptimizationEngine) storeRealized(ctx context.Context, id int64, r *RealizedImprovement) error {
+	var saved sql.NullFloat64
+	if r.MinutesSavedPerDay != nil {
+		saved = sql.NullFloat64{Float64: *r.MinutesSavedPerDay, Valid: true}
+	}
+	_, err := oe.db.ExecContext(ctx, `
+		UPDATE app_rewrites
+		SET realized_status = ?, before_avg_time = ?, after_avg_time = ?,
+		    before_samples = ?, after_samples = ?, minutes_saved_per_day = ?, tracked_at = NOW()
+		WHERE id = ?
+	`, r.Status, r.BeforeAvgTime, r.AfterAvgTime, r.BeforeSamples, r.AfterSamples, saved, id)
+	if err != nil {
+		return fmt.Errorf("failed to store realized improvement of rewrite %d: %w", id, err)
+	}
+	return nil
+}
+
+const realizedColumns = `realized_status, COALESCE(before_avg_time, 0), COALESCE(after_avg_time, 0),
+	COALESCE(before_samples, 0), COALESCE(after_samples, 0), minutes_saved_per_day, tracked_at`
+
+// scanRealized scans realizedColumns, returning nil for untracked rewrites
+func scanRealized(scan func(dest ...any) error, extra ...any) (*RealizedImprovement, error) {
+	var (
+		r         RealizedImprovement
+		status    sql.NullString
+		saved     sql.NullFloat64
+		trackedAt sql.NullTime
+	)
+	dest := append(extra, &status, &r.BeforeAvgTime, &r.AfterAvgTime, &r.BeforeSamples, &r.AfterSamples, &saved, &trackedAt)
+	if err := scan(dest...); err != nil {
+		return nil, err
+	}
+	if !status.Valid {
+		return nil, nil
+	}
+	r.Status = status.String
+	r.TrackedAt = trackedAt.Time
+	if saved.Valid {
+		r.MinutesSavedPerDay = &saved.Float64
+	}
+	return &r, nil
+}
+
+// getRealized returns the realized improvement of a rewrite, nil when it
+// has not been tracked
+func (oe *OptimizationEngine) getRealized(ctx context.Context, id int64) (*RealizedImprovement, error) {
+	row := oe.db.QueryRowContext(ctx, "SELECT "+realizedColumns+" FROM app_rewrites WHERE id = ?", id)
+	realized, err := scanRealized(row.Scan)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load realized improvement: %w", err)
+	}
+	return realized, nil
+}
+
+// GetRealizedStats aggregates the realized improvements of all tracked
+// rewrites
+func (oe *OptimizationEngine) GetRealizedStats(ctx context.Context) (*RealizedStats, error) {
+	rows, err := oe.db.QueryContext(ctx, `
+		SELECT r.id, COALESCE(sq.digest, ''), r.reviewed_at, `+realizedColumns+`
+		FROM app_rewrites r
+		LEFT JOIN app_slow_queries sq ON sq.id = r.slow_query_id
+		WHERE r.realized_status IS NOT NULL AND r.reviewed_at IS NOT NULL
+		ORDER BY r.id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query realized improvements: %w", err)
+	}
+	defer rows.Close()
+
+	stats := &RealizedStats{Rewrites: []TrackedRewrite{}}
+	for rows.Next() {
+		var tracked TrackedRewrite
+		realized, err := scanRealized(rows.Scan, &tracked.RewriteID, &tracked.Digest, &tracked.ReviewedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan realized improvement: %w", err)
+		}
+		tracked.RealizedImprovement = *realized
+
+		stats.Tracked++
+		switch realized.Status {
+		case RealizedImproved:
+			stats.Improved++
+		case RealizedUnchanged:
+			stats.Unchanged++
+		case RealizedRegressed:
+			stats.Regressed++
+		default:
+			stats.Inconclusive++
+		}
+		if realized.MinutesSavedPerDay != nil {
+			stats.MinutesSavedPerDay += *realized.MinutesSavedPerDay
+		}
+		stats.Rewrites = append(stats.Rewrites, tracked)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query realized improvements: %w", err)
+	}
+
+	sort.SliceStable(stats.Rewrites, func(i, j int) bool {
+		return savedOrMin(stats.Rewrites[i]) > savedOrMin(stats.Rewrites[j])
+	})
+	return stats, nil
+}
+
+// savedOrMin sorts inconclusive rewrites after every conclusive one
+func savedOrMin(t TrackedRewrite) float64 {
+	if t.MinutesSavedPerDay == nil {
+		return -1e300
+	}
+	return *t.MinutesSavedPerDay
+}
+
+// CountOptimizationsByStatus counts rewrites per status, optionally only
+// those created since a time
+func (oe *OptimizationEngine) CountOptimizationsByStatus(ctx context.Context, since time.Time) (map[string]int, error) {
+	query := "SELECT status, COUNT(*) FROM app_rewrites"
+	var args []any
+	if !since.IsZero() {
+		query += " WHERE created_at >= ?"
+		args = append(args, since)
+	}
+	rows, err := oe.db.QueryContext(ctx, query+" GROUP BY status", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count optimizations: %w", err)
+	}
+	defer rows.Close()
+
+	counts := map[string]int{"pending": 0, "accepted": 0, "rejected": 0, "suppressed": 0, "invalid": 0}
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan optimization count: %w", err)
+		}
+		counts[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to count optimizations: %w", err)
+	}
+	return counts, nil
+}
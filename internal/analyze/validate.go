@@ -0,0 +1,54 @@
+package analyze
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/matthieukhl/latentia/internal/safety"
+)
+
+// validateRewrite runs EXPLAIN on the original and proposed SQL through the
+// sandbox, keeping both plans and their differences. A rewrite that does not
+// parse or references missing tables or columns is stored as invalid with a
+// zero confidence instead of awaiting review. When the original statement
+// cannot be explained either, for example because it ran in another
+// database, the rewrite is left pending without the EXPLAIN check rather
+// than blamed for it. The sandbox only explains reads, so rewrites of UPDATE,
+// DELETE and INSERT are left pending for review without the check.
+func (oe *OptimizationEngine) validateRewrite(ctx context.Context, result *OptimizationResult) {
+	if result.Pattern.IsDML() {
+		slog.InfoContext(ctx, "rewrite not validated: data-modifying statements are not explained", "type", result.Pattern.Type)
+		result.Metadata["explain_skipped"] = "data-modifying statement"
+		return
+	}
+
+	original, origErr := oe.explain(ctx, result.OriginalSQL)
+	optimized, err := oe.explain(ctx, result.OptimizedSQL)
+	result.PlanOriginal, result.PlanOptimized = original, optimized
+	result.PlanDiff = DiffPlans(original, optimized)
+	if err == nil {
+		result.ExplainPassed = true
+		return
+	}
+
+	// A rewrite the sandbox refuses, such as one that writes, is never
+	// worth reviewing
+	if _, ok := safety.AsViolation(err); !ok && origErr != nil {
+		slog.WarnContext(ctx, "rewrite not validated: original statement cannot be explained either", "error", origErr)
+		return
+	}
+
+	slog.InfoContext(ctx, "rewrite failed EXPLAIN validation", "error", err)
+	result.Status = "invalid"
+	result.ValidationError = err.Error()
+	result.ConfidenceScore = 0
+}
+
+// explain returns the brief plan of sql, nil when it cannot be obtained
+func (oe *OptimizationEngine) explain(ctx context.Context, sql string) ([]PlanOperator, error) {
+	result, err := oe.executor.Query(ctx, safety.PurposeExplain, "EXPLAIN FORMAT='brief' "+sql)
+	if err != nil {
+		return nil, err
+	}
+	return parsePlan(result), nil
+}
@@ -0,0 +1,73 @@
+package analyze
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+)
+
+// maxViewDepth bounds how many views of views ExpandViews follows
+const maxViewDepth = 4
+
+// ExpandViews finds the views among the tables of pattern, under
+// llm.schema.expand_views, and adds the tables their definitions read to
+// pattern.Tables, following views of views up to maxViewDepth. The views are
+// listed in pattern.Views, so the prompt shows their definitions rather than
+// their columns. A view whose definition cannot be read stays a table.
+func (pb *PromptBuilder) ExpandViews(ctx context.Context, pattern *QueryPattern) {
+	if pb.schemas == nil || !config.Current().LLM.Schema.ExpandViews {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	seen := map[string]bool{}
+	for _, table := range pattern.Tables {
+		seen[strings.ToLower(table)] = true
+	}
+	pending := pattern.Tables
+	for depth := 0; depth < maxViewDepth && len(pending) > 0; depth++ {
+		var next []string
+		for _, name := range pending {
+			view, err := pb.schemas.View(ctx, name)
+			if err != nil {
+				if !errors.Is(err, database.ErrTableUnavailable) {
+					slog.WarnContext(ctx, "view definition not read", "view", name, "error", err)
+				}
+				continue
+			}
+			pattern.Views = append(pattern.Views, name)
+			for _, table := range viewTables(name, view) {
+				if key := strings.ToLower(table); !seen[key] {
+					seen[key] = true
+					pattern.Tables = append(pattern.Tables, table)
+					next = append(next, table)
+				}
+			}
+		}
+		pending = next
+	}
+}
+
+// viewTables returns the tables read by the definition of view, referenced
+// in the query as name. Unqualified tables of a view named with its schema
+// are in that schema rather than the connection's.
+func viewTables(name string, view *database.ViewSchema) []string {
+	structure, ok := parseQueryStructure(view.Definition)
+	if !ok {
+		structure = NewQueryAnalyzer().heuristicStructure(view.Definition, strings.ToLower(view.Definition))
+	}
+	tables := make([]string, len(structure.tables))
+	for i, table := range structure.tables {
+		if strings.Contains(name, ".") && !strings.Contains(table, ".") {
+			table = view.Schema + "." + table
+		}
+		tables[i] = table
+	}
+	return tables
+}
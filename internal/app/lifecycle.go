@@ -0,0 +1,213 @@
+// Package app coordinates the startup and shutdown of the long-running parts
+// of the agent process.
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultStopTimeout bounds how long a component may take to stop when it
+// was registered without a timeout of its own
+const DefaultStopTimeout = 10 * time.Second
+
+// StartFunc runs a component. It may block until ctx is cancelled or return
+// straight away; an error it returns shuts the whole process down.
+type StartFunc func(ctx context.Context) error
+
+// StopFunc asks a component to stop and release its resources before ctx is
+// done
+type StopFunc func(ctx context.Context) error
+
+// Component is a registered part of the process
+type Component struct {
+	Name string
+
+	// StopTimeout bounds StopFunc and the wait for StartFunc to return
+	StopTimeout time.Duration
+
+	start StartFunc
+	stop  StopFunc
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Lifecycle starts components in registration order and stops them in
+// reverse order once the process is signalled or a component fails, so
+// components registered last, like the HTTP server, stop first and the ones
+// they depend on, like the database, last. A second signal during shutdown
+// exits immediately.
+type Lifecycle struct {
+	components []*Component
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	signals chan os.Signal
+
+	mu       sync.Mutex
+	err      error
+	stopOnce sync.Once
+
+	// exit is called with the exit code on a forced exit
+	exit func(code int)
+}
+
+// New returns a lifecycle whose root context is cancelled on SIGINT or
+// SIGTERM. Callers should defer Close in case they return before Run.
+func New() *Lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Lifecycle{
+		ctx:     ctx,
+		cancel:  cancel,
+		signals: make(chan os.Signal, 2),
+		exit:    os.Exit,
+	}
+	signal.Notify(l.signals, os.Interrupt, syscall.SIGTERM)
+	go l.handleSignals()
+	return l
+}
+
+// Context is the root context of the process. It is cancelled by the first
+// signal or when a component fails.
+func (l *Lifecycle) Context() context.Context {
+	return l.ctx
+}
+
+// Register adds a component. Either function may be nil: components with no
+// start only need closing, like the database, and components with no stop
+// stop when their context is cancelled, like the job runner.
+func (l *Lifecycle) Register(name string, start StartFunc, stop StopFunc) *Component {
+	c := &Component{
+		Name:        name,
+		StopTimeout: DefaultStopTimeout,
+		start:       start,
+		stop:        stop,
+		done:        make(chan struct{}),
+	}
+	// Until Run starts it there is nothing to wait for
+	close(c.done)
+	l.components = append(l.components, c)
+	return c
+}
+
+// Run starts every component and blocks until the root context is cancelled,
+// then stops them in reverse order. It returns the first error returned by a
+// component start, if any.
+func (l *Lifecycle) Run() error {
+	for _, c := range l.components {
+		if c.start == nil {
+			continue
+		}
+
+		c.done = make(chan struct{})
+		ctx, cancel := context.WithCancel(context.Background())
+		c.cancel = cancel
+		go func(c *Component) {
+			defer close(c.done)
+			if err := c.start(ctx); err != nil {
+				l.fail(fmt.Errorf("%s failed: %w", c.Name, err))
+			}
+		}(c)
+	}
+
+	<-l.ctx.Done()
+	l.Close()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// Close stops the registered components in reverse order unless Run already
+// did
+func (l *Lifecycle) Close() {
+	l.cancel()
+	l.stopOnce.Do(l.shutdown)
+}
+
+// fail records the first component error and starts the shutdown
+func (l *Lifecycle) fail(err error) {
+	l.mu.Lock()
+	if l.err == nil {
+		l.err = err
+	}
+	l.mu.Unlock()
+
+	slog.Error("component failed, shutting down", "error", err)
+	l.cancel()
+}
+
+func (l *Lifecycle) handleSignals() {
+	select {
+	case sig := <-l.signals:
+		slog.Info("shutting down", "signal", sig.String())
+		l.cancel()
+	case <-l.ctx.Done():
+	}
+
+	// Shutdown has started; wait for a second signal to force the exit
+	sig := <-l.signals
+	slog.Warn("forcing exit", "signal", sig.String())
+	l.exit(1)
+}
+
+func (l *Lifecycle) shutdown() {
+	started := time.Now()
+	for i := len(l.components) - 1; i >= 0; i-- {
+		l.stopComponent(l.components[i])
+	}
+	slog.Info("shutdown complete", "elapsed", time.Since(started).Round(time.Millisecond))
+}
+
+// stopComponent cancels the component's context, calls its stop and waits
+// for its start to return, giving up after its stop timeout
+func (l *Lifecycle) stopComponent(c *Component) {
+	started := time.Now()
+	slog.Info("stopping component", "component", c.Name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.StopTimeout)
+	defer cancel()
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if c.stop != nil {
+		if err := c.stop(ctx); err != nil {
+			slog.Warn("component stopped with error", "component", c.Name, "error", err)
+		}
+	}
+
+	if ctx.Err() == nil {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+		}
+	}
+	if ctx.Err() != nil {
+		slog.Warn("component did not stop in time", "component", c.Name, "timeout", c.StopTimeout)
+		return
+	}
+	slog.Info("component stopped", "component", c.Name, "elapsed", time.Since(started).Round(time.Millisecond))
+}
+
+// CloseFunc adapts a function without a context, like Close, to a StopFunc
+// that stops waiting for it when the stop timeout elapses
+func CloseFunc(close func() error) StopFunc {
+	return func(ctx context.Context) error {
+		done := make(chan error, 1)
+		go func() { done <- close() }()
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
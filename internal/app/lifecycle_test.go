@@ -0,0 +1,242 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// recorder collects component events in the order they happen
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) add(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) list() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+// slowComponent registers a component that runs until its context is
+// cancelled and takes delay to drain once it is, then to stop
+func slowComponent(l *Lifecycle, rec *recorder, name string, delay time.Duration) *Component {
+	start := func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(delay)
+		rec.add(name + " drained")
+		return nil
+	}
+	stop := func(ctx context.Context) error {
+		rec.add(name + " stop")
+		time.Sleep(delay)
+		return nil
+	}
+	return l.Register(name, start, stop)
+}
+
+// newTestLifecycle returns a lifecycle whose forced exits are reported on
+// the returned channel instead of exiting the test binary
+func newTestLifecycle(t *testing.T) (*Lifecycle, chan int) {
+	t.Helper()
+	exits := make(chan int, 1)
+	l := New()
+	l.exit = func(code int) { exits <- code }
+	t.Cleanup(l.Close)
+	return l, exits
+}
+
+// run calls l.Run in the background and returns its result channel
+func run(l *Lifecycle) chan error {
+	result := make(chan error, 1)
+	go func() { result <- l.Run() }()
+	return result
+}
+
+func wait(t *testing.T, result chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+		return nil
+	}
+}
+
+func TestShutdownStopsInReverseOrder(t *testing.T) {
+	l, _ := newTestLifecycle(t)
+	rec := &recorder{}
+	l.Register("database", nil, func(ctx context.Context) error {
+		rec.add("database stop")
+		return nil
+	})
+	slowComponent(l, rec, "scheduler", 20*time.Millisecond)
+	slowComponent(l, rec, "workers", 30*time.Millisecond)
+	slowComponent(l, rec, "http", 10*time.Millisecond)
+
+	result := run(l)
+	time.Sleep(20 * time.Millisecond)
+	l.signals <- syscall.SIGTERM
+	if err := wait(t, result); err != nil {
+		t.Fatalf("Run returned %v", err)
+	}
+
+	// Each component has drained before the one it depends on is stopped
+	want := []string{
+		"http stop", "http drained",
+		"workers stop", "workers drained",
+		"scheduler stop", "scheduler drained",
+		"database stop",
+	}
+	if got := rec.list(); !reflect.DeepEqual(got, want) {
+		t.Errorf("events =\n%q\nwant\n%q", got, want)
+	}
+	if l.Context().Err() == nil {
+		t.Error("root context not cancelled by the signal")
+	}
+}
+
+func TestShutdownGivesUpOnSlowComponent(t *testing.T) {
+	l, _ := newTestLifecycle(t)
+	rec := &recorder{}
+	slowComponent(l, rec, "database", 0)
+	stuck := l.Register("notifier", nil, func(ctx context.Context) error {
+		rec.add("notifier stop")
+		select {
+		case <-time.After(time.Second):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	stuck.StopTimeout = 50 * time.Millisecond
+	// A start ignoring its context only holds the shutdown up to the timeout
+	hung := l.Register("worker", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}, nil)
+	hung.StopTimeout = 50 * time.Millisecond
+
+	result := run(l)
+	started := time.Now()
+	l.signals <- syscall.SIGINT
+	if err := wait(t, result); err != nil {
+		t.Fatalf("Run returned %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+		t.Errorf("shutdown took %v, want the slow components cut off after their 50ms timeouts", elapsed)
+	}
+	if got, want := rec.list(), []string{"notifier stop", "database stop", "database drained"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
+
+func TestSecondSignalForcesExit(t *testing.T) {
+	l, exits := newTestLifecycle(t)
+	stopping := make(chan struct{})
+	release := make(chan struct{})
+	l.Register("workers", nil, func(ctx context.Context) error {
+		close(stopping)
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return nil
+	})
+
+	result := run(l)
+	l.signals <- syscall.SIGTERM
+	<-stopping
+
+	select {
+	case code := <-exits:
+		t.Fatalf("exited with %d on the first signal", code)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	l.signals <- syscall.SIGINT
+	select {
+	case code := <-exits:
+		if code != 1 {
+			t.Errorf("exit code = %d, want 1", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second signal did not force the exit")
+	}
+	select {
+	case <-result:
+		t.Error("shutdown finished before the forced exit")
+	default:
+	}
+
+	close(release)
+	wait(t, result)
+}
+
+func TestComponentFailureShutsDown(t *testing.T) {
+	l, exits := newTestLifecycle(t)
+	rec := &recorder{}
+	slowComponent(l, rec, "database", 0)
+	l.Register("ingest", func(ctx context.Context) error {
+		return errors.New("slow log not readable")
+	}, nil)
+	slowComponent(l, rec, "http", 0)
+
+	err := wait(t, run(l))
+	if err == nil || err.Error() != "ingest failed: slow log not readable" {
+		t.Fatalf("Run returned %v, want the ingest failure", err)
+	}
+	if got, want := rec.list(), []string{"http stop", "http drained", "database stop", "database drained"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+	select {
+	case code := <-exits:
+		t.Errorf("exited with %d without a signal", code)
+	default:
+	}
+}
+
+func TestCloseWithoutRun(t *testing.T) {
+	l, _ := newTestLifecycle(t)
+	rec := &recorder{}
+	l.Register("database", nil, func(ctx context.Context) error {
+		rec.add("database stop")
+		return nil
+	})
+	slowComponent(l, rec, "http", 0)
+
+	l.Close()
+	l.Close()
+	// Components that never started are only stopped, once
+	if got, want := rec.list(), []string{"http stop", "database stop"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
+
+func TestCloseFunc(t *testing.T) {
+	closed := errors.New("closed")
+	if err := CloseFunc(func() error { return closed })(context.Background()); err != closed {
+		t.Errorf("CloseFunc = %v, want the close error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := CloseFunc(func() error {
+		time.Sleep(time.Second)
+		return nil
+	})(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CloseFunc on a slow close = %v, want the deadline", err)
+	}
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/rag"
+	"github.com/spf13/cobra"
+)
+
+var (
+	addDocFile     string
+	addDocCategory string
+)
+
+var addDocCmd = &cobra.Command{
+	Use:   "add-doc",
+	Short: "Add markdown documentation, such as runbooks, to the RAG store",
+	Long: `Add a markdown file, or every .md and .markdown file under a directory,
+to the documentation store used as context for optimizations. Each file is
+titled by its first heading, chunked and embedded like the seeded TiDB
+documentation.
+
+Files unchanged since they were last added are skipped without calling the
+embedder. A file that cannot be added is reported and the others are still
+added; the command fails at the end if any did not.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return addDocs()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(addDocCmd)
+
+	addDocCmd.Flags().StringVar(&addDocFile, "file", "", "Markdown file or directory to add (required)")
+	addDocCmd.Flags().StringVar(&addDocCategory, "category", "internal", "Category of the added documents")
+	addDocCmd.Flags().BoolVar(&noEmbeddingCache, "no-embedding-cache", false, "Embed every chunk with the provider, ignoring app_embedding_cache")
+	addDocCmd.MarkFlagRequired("file")
+}
+
+func addDocs() error {
+	files, err := rag.MarkdownFiles(addDocFile)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no markdown files under %s", addDocFile)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if err := db.UpgradeAppSchema(ctx); err != nil {
+		return fmt.Errorf("failed to upgrade schema: %w", err)
+	}
+
+	embedder, err := newDocEmbedder(cfg, db)
+	if err != nil {
+		return err
+	}
+	docStore := rag.NewDocumentStore(db, embedder)
+	if err := docStore.CheckDimension(ctx); err != nil {
+		return err
+	}
+
+	fmt.Printf("📚 Adding %d file%s as %q documentation...\n", len(files), plural(len(files)), addDocCategory)
+	var added, unchanged, failed int
+	for _, file := range files {
+		changed, err := docStore.AddDocumentFromFile(ctx, file, addDocCategory)
+		switch {
+		case err != nil:
+			failed++
+			fmt.Printf("   ❌ %v\n", err)
+		case changed:
+			added++
+			fmt.Printf("   ✅ %s\n", file)
+		default:
+			unchanged++
+			fmt.Printf("   ⏭️  %s (unchanged)\n", file)
+		}
+	}
+
+	fmt.Printf("\n%d added, %d unchanged, %d failed\n", added, unchanged, failed)
+	printEmbeddingCache(embedder)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d file%s could not be added", failed, len(files), plural(len(files)))
+	}
+	return nil
+}
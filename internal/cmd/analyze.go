@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/matthieukhl/latentia/internal/analyze"
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/ingest"
+	"github.com/matthieukhl/latentia/internal/safety"
+	"github.com/spf13/cobra"
+)
+
+var (
+	analyzeSQL    string
+	analyzeFile   string
+	analyzeDB     string
+	analyzeOutput string
+	analyzeSave   bool
+	analyzeNoLLM  bool
+	analyzeForce  bool
+	analyzeTarget string
+)
+
+var analyzeCmd = &cobra.Command{
+	Use:   "analyze",
+	Short: "Optimize one query given by --sql, --file or stdin",
+	Long: `Run the full optimization pipeline on a single statement: pattern
+analysis, documentation retrieval, generation, EXPLAIN validation and
+confidence scoring. The statement comes from --sql, --file or, when neither
+is given, standard input:
+
+  agent analyze --sql 'SELECT * FROM orders WHERE DATE(created_at) = "2024-01-01"'
+  agent analyze --file query.sql --output json
+  cat query.sql | agent analyze --no-llm
+
+Nothing is stored unless --save records the query as an ad-hoc slow query
+with its rewrite, as POST /api/analyze does, for review. --no-llm prints
+only the static analysis and needs neither the database nor the LLM.
+Input holding more than one statement, or an administrative or DDL
+statement such as SET or ALTER TABLE, is refused. The statement is
+explained on the default target unless --target names another entry of
+db.targets.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runAnalyze(cmd.InOrStdin())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(analyzeCmd)
+
+	analyzeCmd.Flags().StringVar(&analyzeSQL, "sql", "", "SQL statement to optimize")
+	analyzeCmd.Flags().StringVar(&analyzeFile, "file", "", "File holding the SQL statement to optimize")
+	analyzeCmd.Flags().StringVar(&analyzeDB, "db", "", "Database the statement runs in, recorded with --save")
+	analyzeCmd.Flags().StringVarP(&analyzeOutput, "output", "o", "text", "Output format: text or json")
+	analyzeCmd.Flags().BoolVar(&analyzeSave, "save", false, "Store the query and its rewrite for review")
+	analyzeCmd.Flags().BoolVar(&analyzeNoLLM, "no-llm", false, "Only print the static pattern analysis")
+	analyzeCmd.Flags().BoolVar(&analyzeForce, "force", false, "With --save, call the LLM even when the digest has a recent rewrite")
+	analyzeCmd.Flags().StringVar(&analyzeTarget, "target", "", "db.targets entry to explain the statement on, the default one if unset")
+	analyzeCmd.MarkFlagsMutuallyExclusive("sql", "file")
+	analyzeCmd.MarkFlagsMutuallyExclusive("no-llm", "save")
+}
+
+// analyzeReport is the --output json document of agent analyze
+type analyzeReport struct {
+	SQL     string                      `json:"sql"`
+	Pattern analyze.QueryPattern        `json:"pattern"`
+	Rewrite *analyze.OptimizationResult `json:"rewrite,omitempty"`
+	Saved   bool                        `json:"saved"`
+}
+
+func runAnalyze(stdin io.Reader) error {
+	if analyzeOutput != "text" && analyzeOutput != "json" {
+		return fmt.Errorf("invalid output '%s': must be text or json", analyzeOutput)
+	}
+	input, err := readAnalyzeInput(stdin)
+	if err != nil {
+		return err
+	}
+	sql, err := analyzeStatement(input)
+	if err != nil {
+		return err
+	}
+
+	report := analyzeReport{SQL: sql, Pattern: analyze.NewQueryAnalyzer().AnalyzeQuery(sql)}
+	if analyzeNoLLM {
+		return printAnalyzeReport(report)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+	tdb, err := db.ForTarget(analyzeTarget)
+	if err != nil {
+		return err
+	}
+
+	// Usage, anonymization aliases and saved rewrites need the app tables
+	ctx := context.Background()
+	if err := db.UpgradeAppSchema(ctx); err != nil {
+		return fmt.Errorf("failed to upgrade schema: %w", err)
+	}
+	engine, err := newOptimizationEngine(cfg, tdb)
+	if err != nil {
+		return err
+	}
+
+	if analyzeOutput == "text" {
+		fmt.Fprintf(os.Stderr, "🧠 Optimizing with %s...\n", cfg.LLM.Generator.Model)
+	}
+	if analyzeSave {
+		report.Rewrite, err = saveAnalysis(ctx, tdb, engine, sql)
+		report.Saved = err == nil
+	} else {
+		report.Rewrite, err = engine.Propose(ctx, sql)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to optimize query: %w", err)
+	}
+
+	// The prompt and completion are printed by agent review show --raw
+	report.Rewrite.PromptText, report.Rewrite.RawResponse = "", ""
+	return printAnalyzeReport(report)
+}
+
+// readAnalyzeInput returns the SQL of --sql, --file or stdin, refusing a
+// terminal as stdin so that a forgotten flag does not wait for input
+func readAnalyzeInput(stdin io.Reader) (string, error) {
+	switch {
+	case analyzeSQL != "":
+		return analyzeSQL, nil
+	case analyzeFile != "":
+		data, err := os.ReadFile(analyzeFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", analyzeFile, err)
+		}
+		return string(data), nil
+	}
+
+	if f, ok := stdin.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return "", fmt.Errorf("no SQL given: use --sql, --file or pipe a statement on stdin")
+		}
+	}
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", fmt.Errorf("failed to read stdin: %w", err)
+	}
+	return string(data), nil
+}
+
+// analyzeStatement checks input is a single SELECT or DML statement the
+// safety rules allow, and returns it without its trailing semicolons
+func analyzeStatement(input string) (string, error) {
+	if strings.TrimSpace(input) == "" {
+		return "", fmt.Errorf("no SQL given: the input is empty")
+	}
+	sql, err := safety.Optimizable(input)
+	if err != nil {
+		return "", fmt.Errorf("cannot analyze the input: %w", err)
+	}
+	return sql, nil
+}
+
+// saveAnalysis records sql as an ad-hoc slow query of the target of db and
+// optimizes it like POST /api/analyze, settling the slow query whether or
+// not it succeeds
+func saveAnalysis(ctx context.Context, db *database.DB, engine *analyze.OptimizationEngine, sql string) (*analyze.OptimizationResult, error) {
+	ingester := ingest.NewSlowQueryIngester(db)
+	q, err := ingester.RecordAdhocQuery(ctx, sql, analyzeDB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record query: %w", err)
+	}
+
+	analyzeCtx := ctx
+	if analyzeForce {
+		analyzeCtx = analyze.WithoutRewriteCache(ctx)
+	}
+	result, err := engine.OptimizeQuery(analyzeCtx, q.ID, sql)
+	if err != nil {
+		// Nothing retries an ad-hoc query, so it never goes back to pending
+		if skipErr := ingester.SkipSlowQuery(ctx, q.ID, "ad-hoc analysis failed: "+err.Error()); skipErr != nil {
+			slog.Warn("failed to update ad-hoc slow query", "slow_query_id", q.ID, "error", skipErr)
+		}
+		return nil, err
+	}
+
+	// An invalid rewrite completes the analysis but is nobody's best
+	var bestRewriteID int64
+	if result.Status != "invalid" {
+		bestRewriteID = result.ID
+	}
+	if err := ingester.CompleteSlowQuery(ctx, q.ID, bestRewriteID); err != nil {
+		slog.Warn("failed to update ad-hoc slow query", "slow_query_id", q.ID, "error", err)
+	}
+	return result, nil
+}
+
+func printAnalyzeReport(report analyzeReport) error {
+	if analyzeOutput == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	pattern := report.Pattern
+	fmt.Printf("🔍 %s query (%s), tables: %s\n", pattern.Type, pattern.Complexity, listOrNone(pattern.Tables))
+	if len(pattern.Findings) == 0 {
+		fmt.Println("   No anti-patterns found")
+	}
+	for _, finding := range pattern.Findings {
+		location := ""
+		if finding.Location != "" {
+			location = " in " + finding.Location
+		}
+		fmt.Printf("   • [%s] %s%s: %s\n", finding.Severity, finding.Code, location, finding.Description)
+	}
+	fmt.Printf("   Opportunities: %s\n", listOrNone(pattern.OptimizationOps))
+
+	rewrite := report.Rewrite
+	if rewrite == nil {
+		return nil
+	}
+	confidence := fmt.Sprintf("confidence %.2f", rewrite.ConfidenceScore)
+	if rewrite.ConfidenceSource != "" {
+		confidence += " from " + rewrite.ConfidenceSource
+	}
+	switch {
+	case report.Saved && rewrite.Cached:
+		fmt.Printf("\n♻️  Reused rewrite %d of the same digest (%s, %s)\n", rewrite.ID, rewrite.Status, confidence)
+	case report.Saved:
+		fmt.Printf("\n💾 Saved as rewrite %d (%s, %s)\n", rewrite.ID, rewrite.Status, confidence)
+	default:
+		fmt.Printf("\n✨ Proposed rewrite (%s, %s, not saved)\n", rewrite.Status, confidence)
+	}
+	fmt.Printf("\n%s\n", rewrite.OptimizedSQL)
+	if rewrite.Rationale != "" {
+		fmt.Printf("\n%s\n", rewrite.Rationale)
+	}
+	if rewrite.ExpectedImprovement != "" {
+		fmt.Printf("\n📈 Expected plan change: %s\n", rewrite.ExpectedImprovement)
+	}
+	if rewrite.Caveats != "" {
+		fmt.Printf("\n⚠️  Caveats: %s\n", rewrite.Caveats)
+	}
+	if rewrite.ValidationError != "" {
+		fmt.Printf("\n❌ Invalid: %s\n", rewrite.ValidationError)
+	}
+	if rewrite.PlanDiff != nil {
+		for _, line := range rewrite.PlanDiff.Summary {
+			fmt.Printf("🧭 %s\n", line)
+		}
+	}
+	if tokens := rewrite.PromptTokens + rewrite.CompletionTokens; tokens > 0 {
+		fmt.Printf("\n   %d tokens (%d prompt, %d completion), %s\n", tokens, rewrite.PromptTokens, rewrite.CompletionTokens, formatCost(rewrite.EstimatedCost))
+	}
+	return nil
+}
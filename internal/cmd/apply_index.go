@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/spf13/cobra"
+)
+
+var (
+	applyIndexID  int64
+	applyIndexYes bool
+)
+
+var applyIndexCmd = &cobra.Command{
+	Use:   "apply-index",
+	Short: "Create an approved index recommendation",
+	Long: `Create the index of an approved recommendation on the monitored
+database. Approving a recommendation in the review API never runs DDL; this
+command is the only place it happens.
+
+Without --yes the CREATE INDEX statement is printed and nothing is changed.
+Creating an index on a large table takes time and resources, so run it when
+the cluster can afford it.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return applyIndex()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(applyIndexCmd)
+
+	applyIndexCmd.Flags().Int64Var(&applyIndexID, "id", 0, "Index recommendation ID (required)")
+	applyIndexCmd.Flags().BoolVar(&applyIndexYes, "yes", false, "Confirm and create the index")
+	applyIndexCmd.MarkFlagRequired("id")
+}
+
+func applyIndex() error {
+	if applyIndexID <= 0 {
+		return fmt.Errorf("invalid index recommendation ID %d", applyIndexID)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	if err := db.UpgradeAppSchema(context.Background()); err != nil {
+		return fmt.Errorf("failed to upgrade schema: %w", err)
+	}
+
+	ctx := database.WithActor(context.Background(), database.CLIActor())
+	recommendation, err := db.GetIndexRecommendation(ctx, applyIndexID)
+	if errors.Is(err, database.ErrIndexNotFound) {
+		return fmt.Errorf("index recommendation %d not found", applyIndexID)
+	}
+	if err != nil {
+		return err
+	}
+
+	stmt, err := recommendation.CreateSQL()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("🔎 Index recommendation %d (rewrite %d, %s)\n", recommendation.ID, recommendation.RewriteID, recommendation.Status)
+	if recommendation.Rationale != "" {
+		fmt.Printf("   %s\n", strings.ReplaceAll(recommendation.Rationale, "\n", " "))
+	}
+	fmt.Printf("   %s\n", stmt)
+
+	if recommendation.Status != database.IndexApproved {
+		return fmt.Errorf("index recommendation %d is %s; only approved recommendations can be applied", recommendation.ID, recommendation.Status)
+	}
+	if !applyIndexYes {
+		fmt.Println("⚠️  Not applied: re-run with --yes to create the index")
+		return nil
+	}
+
+	if _, err := db.ApplyIndexRecommendation(ctx, recommendation.ID); err != nil {
+		return fmt.Errorf("failed to apply index recommendation %d: %w", recommendation.ID, err)
+	}
+	fmt.Printf("✅ Index %s created\n", recommendation.Name())
+	return nil
+}
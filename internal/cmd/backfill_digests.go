@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/ingest"
+	"github.com/spf13/cobra"
+)
+
+var backfillDigestsBatchSize int
+
+var backfillDigestsCmd = &cobra.Command{
+	Use:   "backfill-digests",
+	Short: "Normalize stored slow queries and recompute their digests",
+	Long: `Store the normalized SQL of slow queries ingested before it was, and
+recompute the digests the agent made itself, for generated, ad-hoc and
+MySQL slow log queries, from it. Queries that only differed in their
+literals then share a digest: their statistics are merged and exact
+suppressions follow them. Digests from TiDB are kept.
+
+Rows are processed --batch-size at a time and only while they lack a
+normalized text, so the command can be interrupted and run again.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return backfillDigests()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(backfillDigestsCmd)
+
+	backfillDigestsCmd.Flags().IntVar(&backfillDigestsBatchSize, "batch-size", 500, "Slow queries read per batch")
+}
+
+func backfillDigests() error {
+	if backfillDigestsBatchSize <= 0 {
+		return fmt.Errorf("--batch-size must be positive")
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := db.UpgradeAppSchema(ctx); err != nil {
+		return fmt.Errorf("failed to upgrade app schema: %w", err)
+	}
+
+	fmt.Println("🔄 Normalizing stored slow queries...")
+	summary, err := ingest.NewSlowQueryIngester(db).BackfillDigests(ctx, backfillDigestsBatchSize)
+	fmt.Printf("   Normalized: %d\n", summary.Normalized)
+	fmt.Printf("   Digests recomputed: %d (%d slow quer%s)\n", summary.Renamed, summary.Moved, pluralizeQuery(int(summary.Moved)))
+	if err != nil {
+		return err
+	}
+	fmt.Println("✅ Digests backfilled")
+	return nil
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/matthieukhl/latentia/internal/analyze"
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/spf13/cobra"
+)
+
+var (
+	benchmarkID   int64
+	benchmarkRuns int
+)
+
+var benchmarkCmd = &cobra.Command{
+	Use:   "benchmark",
+	Short: "Time the original and optimized SQL of a rewrite",
+	Long: `Execute the original and optimized SQL of a rewrite --runs times each
+against the database, alternating between them, and store the minimum,
+median and maximum latency and the rows returned on the rewrite.
+
+Statements run in the sandbox: only read-only statements are executed, and
+statements matching safety.forbid_patterns or running longer than
+safety.max_stmt_seconds are refused. Row counts stop at safety.max_rows, and
+are not compared when either statement returns more rows than that.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runBenchmark()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(benchmarkCmd)
+
+	benchmarkCmd.Flags().Int64Var(&benchmarkID, "id", 0, "Rewrite ID (required)")
+	benchmarkCmd.Flags().IntVar(&benchmarkRuns, "runs", analyze.DefaultBenchmarkRuns, fmt.Sprintf("Executions of each statement (1-%d)", analyze.MaxBenchmarkRuns))
+	benchmarkCmd.MarkFlagRequired("id")
+}
+
+func runBenchmark() error {
+	if benchmarkID <= 0 {
+		return fmt.Errorf("invalid rewrite ID %d", benchmarkID)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	if err := db.UpgradeAppSchema(context.Background()); err != nil {
+		return fmt.Errorf("failed to upgrade schema: %w", err)
+	}
+
+	// Benchmarks never call the LLM, so the engine needs no providers
+	engine := analyze.NewOptimizationEngine(db, nil, nil)
+	fmt.Printf("⏱️  Benchmarking rewrite %d, %d run%s of each statement...\n", benchmarkID, benchmarkRuns, plural(benchmarkRuns))
+	bench, err := engine.BenchmarkRewrite(context.Background(), benchmarkID, benchmarkRuns)
+	if err != nil {
+		return fmt.Errorf("failed to benchmark rewrite %d: %w", benchmarkID, err)
+	}
+
+	fmt.Printf("\n%-10s %10s %10s %10s %8s\n", "", "min", "median", "max", "rows")
+	for _, row := range []struct {
+		name    string
+		timings analyze.BenchmarkTimings
+	}{{"original", bench.Original}, {"optimized", bench.Optimized}} {
+		fmt.Printf("%-10s %9.1fms %9.1fms %9.1fms %8d\n", row.name,
+			row.timings.Min*1000, row.timings.Median*1000, row.timings.Max*1000, row.timings.Rows)
+	}
+
+	fmt.Println()
+	if bench.Speedup > 0 {
+		fmt.Printf("🚀 Median speedup: %.2fx\n", bench.Speedup)
+	}
+	switch {
+	case bench.RowsInconclusive:
+		fmt.Printf("❔ Row counts not compared: a statement returned more than safety.max_rows (%d)\n", config.Current().Safety.MaxRows)
+	case bench.RowsMatch:
+		fmt.Printf("✅ Both statements returned %d row%s\n", bench.Original.Rows, plural(bench.Original.Rows))
+	default:
+		fmt.Printf("⚠️  Row counts differ: %d original, %d optimized\n", bench.Original.Rows, bench.Optimized.Rows)
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/matthieukhl/latentia/internal/config"
 	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/ingest"
 	"github.com/spf13/cobra"
 )
 
@@ -58,7 +59,8 @@ func checkSlowQueries(cmd *cobra.Command, args []string) error {
 	}
 	defer db.Close()
 	
-	queries, err := fetchSlowQueries(db)
+	filter := ingest.NewFilter(cfg.Ingest.Filters)
+	queries, err := fetchSlowQueries(db, filter)
 	if err != nil {
 		// Handle TiDB Serverless limitation
 		if strings.Contains(err.Error(), "command denied") || strings.Contains(err.Error(), "Unknown column") {
@@ -77,6 +79,8 @@ func checkSlowQueries(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to fetch slow queries: %w", err)
 	}
 	
+	printExcluded(filter.Excluded())
+	
 	if len(queries) == 0 {
 		fmt.Println("📭 No slow queries found matching criteria")
 		fmt.Printf("💡 Try running: agent generate-slow --type=sleep --duration=2\n")
@@ -110,24 +114,24 @@ func checkSlowQueries(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func fetchSlowQueries(db *database.DB) ([]SlowQueryInfo, error) {
+// fetchSlowQueries returns the most recent slow queries that pass filter
+func fetchSlowQueries(db *database.DB, filter *ingest.Filter) ([]SlowQueryInfo, error) {
 	query := `
 		SELECT 
 			Start_time,
 			Query_time,
 			Digest,
 			Query,
-			DB,
+			COALESCE(DB, '') as DB,
 			COALESCE(Index_names, '') as Index_names,
 			Is_internal,
 			COALESCE(User, '') as User
 		FROM INFORMATION_SCHEMA.SLOW_QUERY 
 		WHERE Query_time >= ? 
-		  AND DB = 'latentia'
 		ORDER BY Start_time DESC 
 		LIMIT ?`
 	
-	rows, err := db.Query(query, minTime, showLast)
+	rows, err := db.TargetDB().Query(query, minTime, showLast)
 	if err != nil {
 		return nil, err
 	}
@@ -152,6 +156,10 @@ func fetchSlowQueries(db *database.DB) ([]SlowQueryInfo, error) {
 			return nil, err
 		}
 		
+		if !filter.Allow(q.DB, q.User, q.Digest, q.QueryTime) {
+			continue
+		}
+		
 		// Parse start time
 		q.StartTime, err = time.Parse("2006-01-02 15:04:05", startTimeStr)
 		if err != nil {
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the agent configuration",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the configuration without connecting to anything",
+	Long: `Load the configuration the same way the server does (config file,
+profile, defaults and LATENTIA_* environment variables) and report every
+invalid field with its key path. Exits non-zero when the configuration is
+invalid.`,
+	RunE: validateConfig,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the fully resolved configuration with secrets masked",
+	Long: `Print the configuration after merging defaults, the config file, the
+active profile and LATENTIA_* environment variables. API keys, passwords and
+DSN credentials are masked. --provenance annotates every value with where it
+came from.`,
+	RunE: showConfig,
+}
+
+var (
+	configShowFormat     string
+	configShowProvenance bool
+)
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configShowCmd)
+
+	configShowCmd.Flags().StringVar(&configShowFormat, "format", "yaml", "Output format (yaml|json)")
+	configShowCmd.Flags().BoolVar(&configShowProvenance, "provenance", false, "Annotate each value with its source (default, file, env)")
+}
+
+func validateConfig(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		var invalid *config.ValidationError
+		if !errors.As(err, &invalid) {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		fmt.Printf("❌ Configuration has %d problem%s:\n", len(invalid.Errors), plural(len(invalid.Errors)))
+		for _, fe := range invalid.Errors {
+			fmt.Printf("  ✘  %s\n", fe.Error())
+		}
+		return exitWithCode(cmd, 1)
+	}
+
+	source := cfg.File()
+	if source == "" {
+		source = "defaults and environment"
+	}
+	fmt.Printf("✅ Configuration is valid (%s)\n", source)
+	if cfg.Profile() != "" {
+		fmt.Printf("   Profile: %s (%s)\n", cfg.Profile(), cfg.ProfileFile())
+	}
+	fmt.Printf("   Server: %s\n", cfg.Server.Addr)
+	fmt.Printf("   Embedder: %s/%s, Generator: %s/%s\n",
+		cfg.LLM.Embedder.Provider, cfg.LLM.Embedder.Model,
+		cfg.LLM.Generator.Provider, cfg.LLM.Generator.Model)
+	if len(cfg.Schedules) > 0 {
+		fmt.Printf("   Schedules: %d job%s\n", len(cfg.Schedules), plural(len(cfg.Schedules)))
+	}
+	return nil
+}
+
+func showConfig(cmd *cobra.Command, args []string) error {
+	if configShowFormat != "yaml" && configShowFormat != "json" {
+		return fmt.Errorf("invalid format '%s': must be yaml or json", configShowFormat)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if configShowFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(cfg.Redacted(configShowProvenance))
+	}
+
+	doc, err := configYAML(cfg.Leaves(), configShowProvenance)
+	if err != nil {
+		return fmt.Errorf("failed to render config: %w", err)
+	}
+	if cfg.Profile() != "" {
+		fmt.Printf("# profile: %s (%s)\n", cfg.Profile(), cfg.ProfileFile())
+	}
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	defer enc.Close()
+	return enc.Encode(doc)
+}
+
+// configYAML builds a YAML document keeping the declaration order of the
+// config structs, with the source of each value as a line comment
+func configYAML(leaves []config.Leaf, provenance bool) (*yaml.Node, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, leaf := range leaves {
+		parts := strings.Split(leaf.Key, ".")
+		node := root
+		for _, part := range parts[:len(parts)-1] {
+			node = yamlChild(node, part)
+		}
+
+		value := &yaml.Node{}
+		if err := value.Encode(leaf.Value); err != nil {
+			return nil, err
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: parts[len(parts)-1]}
+		if provenance {
+			key.LineComment = leaf.Source
+		}
+		node.Content = append(node.Content, key, value)
+	}
+	return root, nil
+}
+
+// yamlChild returns the mapping stored under key, creating it if needed
+func yamlChild(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, child)
+	return child
+}
@@ -0,0 +1,334 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/llm"
+	"github.com/matthieukhl/latentia/internal/types"
+	"github.com/spf13/cobra"
+)
+
+var doctorTimeout time.Duration
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose the agent environment end to end",
+	Long: `Run an ordered checklist covering configuration, database connectivity,
+server version, application schema, vector support, the embedder and
+generator providers, and INFORMATION_SCHEMA.SLOW_QUERY access.
+
+Each check prints ✔ or ✘ with a one-line remediation hint and is bounded by
+--timeout, so a hung dependency cannot stall the whole diagnosis. The
+command exits non-zero if any hard requirement fails; soft checks (⚠) are
+informational.`,
+	RunE: runDoctor,
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+
+	doctorCmd.Flags().DurationVar(&doctorTimeout, "timeout", 15*time.Second, "Timeout for each individual check")
+}
+
+// doctorCheck is a single diagnosis step
+type doctorCheck struct {
+	name string
+	hard bool
+	hint string
+	run  func(ctx context.Context) (string, func(), error)
+}
+
+// doctorState carries what earlier checks produced to later ones. Checks
+// never write to it directly: they return a commit func that is applied only
+// if the check finished within its timeout.
+type doctorState struct {
+	cfg      *config.Config
+	db       *database.DB
+	tablesOK bool
+	failed   int
+	warnings int
+}
+
+var requiredAppTables = []string{"app_slow_queries", "app_documents", "app_embeddings", "app_rewrites"}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	fmt.Println("🩺 Running environment diagnosis...")
+	fmt.Println()
+
+	state := &doctorState{}
+	defer func() {
+		if state.db != nil {
+			state.db.Close()
+		}
+	}()
+
+	checks := []doctorCheck{
+		{
+			name: "Configuration loads",
+			hard: true,
+			hint: "create deploy/config.yaml from deploy/config.yaml.sample",
+			run: func(ctx context.Context) (string, func(), error) {
+				cfg, err := config.LoadConfig()
+				if err != nil {
+					return "", nil, err
+				}
+				return fmt.Sprintf("embedder %s, generator %s", cfg.LLM.Embedder.Provider, cfg.LLM.Generator.Provider),
+					func() { state.cfg = cfg }, nil
+			},
+		},
+		{
+			name: "Database connects",
+			hard: true,
+			hint: "check db.dsn, or db.app_dsn and db.target_dsn or db.targets (host, port, credentials, tls=true for TiDB Cloud)",
+			run: func(ctx context.Context) (string, func(), error) {
+				if state.cfg == nil {
+					return "", nil, errSkipped
+				}
+				// Report an unreachable database now rather than after
+				// db.connect_retries
+				dbCfg := state.cfg.DB
+				dbCfg.ConnectRetries = 0
+				db, err := database.NewConnection(&dbCfg)
+				if err != nil {
+					return "", nil, err
+				}
+				detail := "ping ok"
+				switch names := db.TargetNames(); {
+				case len(names) > 1:
+					detail = fmt.Sprintf("ping ok (app and %d targets: %s)", len(names), strings.Join(names, ", "))
+				case db.Split():
+					detail = "ping ok (app and target databases)"
+				}
+				if dbCfg.TLS.Enabled() {
+					detail += ", TLS from db.tls"
+				}
+				if state.cfg.DB.ReadOnly {
+					detail += ", target read-only"
+				}
+				return detail, func() { state.db = db }, nil
+			},
+		},
+		{
+			name: "Server flavor and version",
+			hard: false,
+			hint: "vector search requires TiDB (v8.4+ or TiDB Cloud Serverless)",
+			run: func(ctx context.Context) (string, func(), error) {
+				if state.db == nil {
+					return "", nil, errSkipped
+				}
+				var version string
+				if err := state.db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&version); err != nil {
+					return "", nil, err
+				}
+				if !strings.Contains(strings.ToLower(version), "tidb") {
+					return version, nil, fmt.Errorf("server %q does not look like TiDB", version)
+				}
+				return version, nil, nil
+			},
+		},
+		{
+			name: "Application schema present",
+			hard: true,
+			hint: "run 'agent migrate' to create or upgrade the app_* tables",
+			run: func(ctx context.Context) (string, func(), error) {
+				if state.db == nil {
+					return "", nil, errSkipped
+				}
+				var missing []string
+				for _, table := range requiredAppTables {
+					exists, err := state.db.TableExists(ctx, table)
+					if err != nil {
+						return "", nil, err
+					}
+					if !exists {
+						missing = append(missing, table)
+					}
+				}
+				if len(missing) > 0 {
+					return "", nil, fmt.Errorf("missing tables: %s", strings.Join(missing, ", "))
+				}
+				version, err := state.db.SchemaVersion(ctx)
+				if err != nil {
+					return "", nil, err
+				}
+				if latest := database.LatestSchemaVersion(); version < latest {
+					return "", nil, fmt.Errorf("schema at version %d of %d (migrations pending)", version, latest)
+				}
+				// Tables created by older versions may lack newer columns
+				outdated, err := state.db.MissingAppColumns(ctx)
+				if err != nil {
+					return "", nil, err
+				}
+				if len(outdated) > 0 {
+					return "", nil, fmt.Errorf("missing columns or indexes: %s (schema out of date)", strings.Join(outdated, ", "))
+				}
+				return fmt.Sprintf("%d tables found, schema version %d", len(requiredAppTables), version), func() { state.tablesOK = true }, nil
+			},
+		},
+		{
+			name: "Vector functions usable",
+			hard: true,
+			hint: "use a TiDB version with vector search support",
+			run: func(ctx context.Context) (string, func(), error) {
+				if state.db == nil {
+					return "", nil, errSkipped
+				}
+				var distance float64
+				if err := state.db.QueryRowContext(ctx, "SELECT VEC_COSINE_DISTANCE('[1,0]', '[0,1]')").Scan(&distance); err != nil {
+					return "", nil, err
+				}
+				return fmt.Sprintf("VEC_COSINE_DISTANCE ok (%.1f)", distance), nil, nil
+			},
+		},
+		{
+			name: "Embedder reachable and dimension matches",
+			hard: true,
+			hint: "check llm.embedder settings and API key; re-create app_embeddings if the model changed",
+			run: func(ctx context.Context) (string, func(), error) {
+				if state.cfg == nil {
+					return "", nil, errSkipped
+				}
+				embedder, err := llm.NewEmbedder(&state.cfg.LLM)
+				if err != nil {
+					return "", nil, err
+				}
+
+				embeddings, err := embedder.Embed(ctx, []string{"latentia doctor"})
+				if err != nil {
+					return "", nil, err
+				}
+				if len(embeddings) != 1 || len(embeddings[0]) != embedder.Dim() {
+					return "", nil, fmt.Errorf("embedder returned %d values, expected %d", vectorLen(embeddings), embedder.Dim())
+				}
+
+				if state.db != nil && state.tablesOK {
+					columnDim, err := state.db.EmbeddingDimension(ctx)
+					if err != nil {
+						return "", nil, err
+					}
+					if columnDim != embedder.Dim() {
+						return "", nil, fmt.Errorf("embedder %s produces %d dims but app_embeddings.embedding is VECTOR(%d)",
+							embedder.Model(), embedder.Dim(), columnDim)
+					}
+				}
+				return fmt.Sprintf("%s, %d dims", embedder.Model(), embedder.Dim()), nil, nil
+			},
+		},
+		{
+			name: "Generator reachable",
+			hard: true,
+			hint: "check llm.generator provider, model and API key",
+			run: func(ctx context.Context) (string, func(), error) {
+				if state.cfg == nil {
+					return "", nil, errSkipped
+				}
+				generator, err := llm.NewGenerator(&state.cfg.LLM)
+				if err != nil {
+					return "", nil, err
+				}
+				if _, err := generator.Complete(ctx, "Reply with OK.", types.GenerationOptions{MaxTokens: 5}); err != nil {
+					return "", nil, err
+				}
+				return generator.Model(), nil, nil
+			},
+		},
+		{
+			name: "INFORMATION_SCHEMA.SLOW_QUERY accessible",
+			hard: false,
+			hint: "not available on TiDB Serverless; use 'agent generate-slow --record' instead",
+			run: func(ctx context.Context) (string, func(), error) {
+				if state.db == nil {
+					return "", nil, errSkipped
+				}
+				rows, err := state.db.TargetDB().QueryContext(ctx, "SELECT 1 FROM INFORMATION_SCHEMA.SLOW_QUERY LIMIT 1")
+				if err != nil {
+					return "", nil, err
+				}
+				rows.Close()
+				return "readable", nil, nil
+			},
+		},
+	}
+
+	for _, check := range checks {
+		runDoctorCheck(check, state)
+	}
+
+	fmt.Println()
+	if state.failed > 0 {
+		fmt.Printf("❌ %d hard requirement%s failed, %d warning%s\n",
+			state.failed, plural(state.failed), state.warnings, plural(state.warnings))
+		return exitWithCode(cmd, 1)
+	}
+
+	fmt.Printf("✅ All hard requirements passed (%d warning%s)\n", state.warnings, plural(state.warnings))
+	return nil
+}
+
+// errSkipped marks a check whose prerequisite check failed
+var errSkipped = fmt.Errorf("skipped")
+
+// runDoctorCheck runs a check under its own timeout. The check runs in a
+// goroutine so calls that ignore ctx (like the initial ping) cannot block,
+// and a check that times out never gets to update the shared state.
+func runDoctorCheck(check doctorCheck, state *doctorState) {
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+
+	type outcome struct {
+		detail string
+		commit func()
+		err    error
+	}
+	done := make(chan outcome, 1)
+	start := time.Now()
+	go func() {
+		detail, commit, err := check.run(ctx)
+		done <- outcome{detail, commit, err}
+	}()
+
+	var result outcome
+	select {
+	case result = <-done:
+		if result.commit != nil {
+			result.commit()
+		}
+	case <-ctx.Done():
+		result = outcome{err: fmt.Errorf("timed out after %v", doctorTimeout)}
+	}
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	switch {
+	case result.err == errSkipped:
+		fmt.Printf("  -  %s (skipped, prerequisite failed)\n", check.name)
+	case result.err == nil:
+		fmt.Printf("  ✔  %s — %s (%v)\n", check.name, result.detail, elapsed)
+	case check.hard:
+		state.failed++
+		fmt.Printf("  ✘  %s — %v\n", check.name, result.err)
+		fmt.Printf("     💡 %s\n", check.hint)
+	default:
+		state.warnings++
+		fmt.Printf("  ⚠  %s — %v\n", check.name, result.err)
+		fmt.Printf("     💡 %s\n", check.hint)
+	}
+}
+
+func vectorLen(embeddings [][]float32) int {
+	if len(embeddings) == 0 {
+		return 0
+	}
+	return len(embeddings[0])
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
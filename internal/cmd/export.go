@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/analyze"
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportStatus string
+	exportSince  string
+	exportFormat string
+	exportOut    string
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Write the reviewed rewrites as a Markdown, SQL or JSON report",
+	Long: `Export the latest rewrite of every digest in a status, accepted by
+default, with its original query, rationale, expected improvement, caveats
+and index recommendations, as a report for the team owning the queries.
+
+  agent export --since 2024-06-01 --format md --out report.md
+  agent export --format sql --out migration.sql
+
+The md format groups rewrites by table with their confidence and review
+times. The sql format is migration-style: queries are comments, and the
+CREATE INDEX statements of recommendations neither rejected nor applied
+are runnable. GET /api/export serves the same report.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runExport()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringVar(&exportStatus, "status", "accepted", "Status of the rewrites exported: "+strings.Join(analyze.RewriteStatuses, ", "))
+	exportCmd.Flags().StringVar(&exportSince, "since", "", "Only rewrites reviewed since this RFC 3339 time, date or duration ago")
+	exportCmd.Flags().StringVar(&exportFormat, "format", analyze.ExportMarkdown, "Report format: "+strings.Join(analyze.ExportFormats, ", "))
+	exportCmd.Flags().StringVar(&exportOut, "out", "", "File to write, standard output by default")
+}
+
+// parseSince reads --since as an RFC 3339 time, a local date or a duration
+// before now
+func parseSince(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	d, err := config.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected an RFC 3339 time, YYYY-MM-DD or a duration, got '%s'", value)
+	}
+	return time.Now().Add(-d), nil
+}
+
+func runExport() error {
+	if !slices.Contains(analyze.ExportFormats, exportFormat) {
+		return fmt.Errorf("invalid format '%s': must be one of %s", exportFormat, strings.Join(analyze.ExportFormats, ", "))
+	}
+	if !slices.Contains(analyze.RewriteStatuses, exportStatus) {
+		return fmt.Errorf("invalid status '%s': must be one of %s", exportStatus, strings.Join(analyze.RewriteStatuses, ", "))
+	}
+	var since time.Time
+	if exportSince != "" {
+		var err error
+		if since, err = parseSince(exportSince); err != nil {
+			return fmt.Errorf("invalid --since: %w", err)
+		}
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if err := db.UpgradeAppSchema(ctx); err != nil {
+		return fmt.Errorf("failed to upgrade schema: %w", err)
+	}
+
+	// Exports never call the LLM, so the engine needs no providers
+	engine := analyze.NewOptimizationEngine(db, nil, nil)
+	export, err := engine.ExportRewrites(ctx, exportStatus, since)
+	if err != nil {
+		return fmt.Errorf("failed to export rewrites: %w", err)
+	}
+
+	if exportOut == "" {
+		return export.Write(os.Stdout, exportFormat)
+	}
+	f, err := os.Create(exportOut)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", exportOut, err)
+	}
+	err = export.Write(f, exportFormat)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", exportOut, err)
+	}
+	fmt.Printf("📦 Exported %d %s rewrite%s to %s\n", len(export.Rewrites), exportStatus, plural(len(export.Rewrites)), exportOut)
+	return nil
+}
@@ -1,20 +1,29 @@
 package cmd
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"strconv"
 	"time"
 
+	"github.com/matthieukhl/latentia/internal/analyze"
 	"github.com/matthieukhl/latentia/internal/config"
 	"github.com/matthieukhl/latentia/internal/database"
 	"github.com/matthieukhl/latentia/internal/ingest"
+	"github.com/matthieukhl/latentia/internal/safety"
 	"github.com/spf13/cobra"
 )
 
 var (
-	queryType string
-	duration  int
-	count     int
-	record    bool
+	queryType    string
+	duration     = secondsDuration(2 * time.Second)
+	count        int
+	record       bool
+	parallel     int
+	sleepSeconds int
+	loadJSONOut  string
+	capturePlan  bool
 )
 
 var generateCmd = &cobra.Command{
@@ -28,7 +37,16 @@ Available query types:
 - sleep: Uses SLEEP() function for guaranteed slow queries
 - full-scan: Queries without proper indexes (table scans)
 - complex-join: Inefficient JOIN patterns
-- aggregation: Heavy GROUP BY/ORDER BY operations`,
+- aggregation: Heavy GROUP BY/ORDER BY operations
+
+With --parallel N the selected query mix is issued by N concurrent workers
+for the wall-clock --duration (e.g. --parallel 8 --duration 60s), and a
+per-template latency summary is printed at the end.
+
+With --capture-plan, each recorded full-scan, complex-join or aggregation
+query is run again under EXPLAIN ANALYZE in the sandbox, bounded by the
+safety limits, and its actual plan is stored with it for the optimization
+prompt.`,
 	RunE: generateSlowQuery,
 }
 
@@ -36,12 +54,24 @@ func init() {
 	rootCmd.AddCommand(generateCmd)
 	
 	generateCmd.Flags().StringVar(&queryType, "type", "sleep", "Type of slow query (sleep|full-scan|complex-join|aggregation)")
-	generateCmd.Flags().IntVar(&duration, "duration", 2, "Duration in seconds for sleep-based queries")
+	generateCmd.Flags().Var(&duration, "duration", "Sleep length for sleep queries, or total run time with --parallel (e.g. 2 or 60s)")
 	generateCmd.Flags().IntVar(&count, "count", 1, "Number of slow queries to generate")
 	generateCmd.Flags().BoolVar(&record, "record", true, "Record slow queries to app_slow_queries table")
+	generateCmd.Flags().IntVar(&parallel, "parallel", 0, "Number of concurrent workers for sustained load generation")
+	generateCmd.Flags().IntVar(&sleepSeconds, "sleep-seconds", 2, "SLEEP() length in seconds for sleep queries in --parallel mode")
+	generateCmd.Flags().StringVar(&loadJSONOut, "json-out", "", "Write the --parallel summary as JSON to this file")
+	generateCmd.Flags().BoolVar(&capturePlan, "capture-plan", false, "Store the EXPLAIN ANALYZE plan of each recorded query")
+	generateCmd.MarkFlagsMutuallyExclusive("capture-plan", "parallel")
 }
 
 func generateSlowQuery(cmd *cobra.Command, args []string) error {
+	if capturePlan && !record {
+		return fmt.Errorf("--capture-plan requires --record: the plan is stored with the slow query")
+	}
+	if parallel > 0 {
+		return generateParallelLoad(cmd)
+	}
+	
 	fmt.Printf("🐌 Generating %d slow quer%s of type '%s'...\n", count, pluralize(count), queryType)
 	if record {
 		fmt.Println("📝 Recording slow queries to app_slow_queries table...")
@@ -60,6 +90,9 @@ func generateSlowQuery(cmd *cobra.Command, args []string) error {
 	
 	var ingester *ingest.SlowQueryIngester
 	if record {
+		if err := db.UpgradeAppSchema(context.Background()); err != nil {
+			return fmt.Errorf("failed to upgrade app schema: %w", err)
+		}
 		ingester = ingest.NewSlowQueryIngester(db)
 	}
 	
@@ -78,13 +111,14 @@ func generateSlowQuery(cmd *cobra.Command, args []string) error {
 }
 
 func generateSleepQueries(db *database.DB, ingester *ingest.SlowQueryIngester, cfg *config.Config) error {
-	fmt.Printf("   ⏰ Running SLEEP(%d) queries...\n", duration)
+	seconds := duration.Seconds()
+	fmt.Printf("   ⏰ Running SLEEP(%d) queries...\n", seconds)
 	
 	for i := 0; i < count; i++ {
-		query := fmt.Sprintf("SELECT SLEEP(%d), id, email FROM customers LIMIT 1", duration)
+		query := sleepQuery(seconds)
 		
 		start := time.Now()
-		_, err := db.Exec(query)
+		_, err := executeDrained(context.Background(), db, query)
 		if err != nil {
 			return fmt.Errorf("failed to execute sleep query %d: %w", i+1, err)
 		}
@@ -93,7 +127,7 @@ func generateSleepQueries(db *database.DB, ingester *ingest.SlowQueryIngester, c
 		
 		// Record to app_slow_queries if enabled
 		if ingester != nil && queryTime >= 0.1 { // Only record queries >= 100ms
-			err = ingester.RecordGeneratedSlowQuery(query, start, queryTime, "latentia", "agent-generator")
+			err = ingester.RecordGeneratedSlowQuery(context.Background(), query, start, queryTime, "latentia", "agent-generator", "")
 			if err != nil {
 				fmt.Printf("   ⚠️  Failed to record query %d: %v\n", i+1, err)
 			} else {
@@ -115,24 +149,23 @@ func generateSleepQueries(db *database.DB, ingester *ingest.SlowQueryIngester, c
 // executeAndRecord is a helper function to execute a query and optionally record it
 func executeAndRecord(db *database.DB, ingester *ingest.SlowQueryIngester, query string, queryNum int) (time.Duration, error) {
 	start := time.Now()
-	rows, err := db.Query(query)
+	
+	// Read every row to force full execution
+	rowCount, err := executeDrained(context.Background(), db, query)
 	if err != nil {
 		return 0, fmt.Errorf("failed to execute query %d: %w", queryNum, err)
 	}
 	
-	// Count rows to force full execution
-	rowCount := 0
-	for rows.Next() {
-		rowCount++
-	}
-	rows.Close()
-	
 	elapsed := time.Since(start)
 	queryTime := elapsed.Seconds()
 	
 	// Record to app_slow_queries if enabled and query was slow enough
 	if ingester != nil && queryTime >= 0.01 { // Record queries >= 10ms for testing
-		err = ingester.RecordGeneratedSlowQuery(query, start, queryTime, "latentia", "agent-generator")
+		var plan string
+		if capturePlan {
+			plan = capturePlanOf(db.TargetDB(), query, queryNum)
+		}
+		err = ingester.RecordGeneratedSlowQuery(context.Background(), query, start, queryTime, "latentia", "agent-generator", plan)
 		if err != nil {
 			fmt.Printf("   ⚠️  Failed to record query %d: %v\n", queryNum, err)
 		} else {
@@ -144,25 +177,23 @@ func executeAndRecord(db *database.DB, ingester *ingest.SlowQueryIngester, query
 	return elapsed, nil
 }
 
+// capturePlanOf runs EXPLAIN ANALYZE of query in the sandbox of target and
+// returns its plan, "" when it could not be captured; the query is still
+// recorded
+func capturePlanOf(target *sql.DB, query string, queryNum int) string {
+	plan, err := analyze.CaptureActualPlan(context.Background(), safety.NewSafeExecutor(target), query)
+	if err != nil {
+		fmt.Printf("   ⚠️  Failed to capture the plan of query %d: %v\n", queryNum, err)
+		return ""
+	}
+	fmt.Printf("   🧭 Captured the plan of query %d (%d bytes)\n", queryNum, len(plan))
+	return plan
+}
+
 func generateFullScanQueries(db *database.DB, ingester *ingest.SlowQueryIngester, cfg *config.Config) error {
 	fmt.Println("   🔍 Running full table scan queries...")
 	
-	queries := []string{
-		// Search in product names (no index on name field)
-		"SELECT * FROM products WHERE name LIKE '%Book%'",
-		
-		// Search in order notes (text field, no index)
-		"SELECT * FROM orders WHERE notes LIKE '%special%'",
-		
-		// Search in customer emails with wildcard (defeats index)
-		"SELECT * FROM customers WHERE email LIKE '%gmail%'",
-		
-		// Complex WHERE on multiple unindexed fields
-		"SELECT * FROM products WHERE description LIKE '%professional%' AND name LIKE '%Pro%'",
-		
-		// Range query on unindexed total field
-		"SELECT * FROM orders WHERE total BETWEEN 100 AND 300",
-	}
+	queries := fullScanQueries
 	
 	for i := 0; i < count; i++ {
 		query := queries[i%len(queries)]
@@ -183,36 +214,7 @@ func generateFullScanQueries(db *database.DB, ingester *ingest.SlowQueryIngester
 func generateComplexJoinQueries(db *database.DB, ingester *ingest.SlowQueryIngester, cfg *config.Config) error {
 	fmt.Println("   🔗 Running complex JOIN queries...")
 	
-	queries := []string{
-		// Inefficient cross join pattern
-		`SELECT c.email, o.total, p.name 
-		 FROM customers c, orders o, products p, order_items oi
-		 WHERE c.id = o.customer_id 
-		 AND o.id = oi.order_id 
-		 AND oi.product_id = p.id
-		 AND c.city LIKE '%New%'`,
-		
-		// Multiple JOINs with text search
-		`SELECT c.company, COUNT(*) as order_count, SUM(o.total) as total_spent
-		 FROM customers c 
-		 JOIN orders o ON c.id = o.customer_id
-		 JOIN order_items oi ON o.id = oi.order_id
-		 JOIN products p ON oi.product_id = p.id
-		 WHERE p.description LIKE '%professional%'
-		 GROUP BY c.company
-		 ORDER BY total_spent DESC`,
-		
-		// Subquery with JOIN
-		`SELECT * FROM orders o
-		 WHERE o.customer_id IN (
-		   SELECT c.id FROM customers c 
-		   WHERE c.email LIKE '%@gmail%' 
-		   AND c.company LIKE '%Tech%'
-		 )
-		 AND o.total > (
-		   SELECT AVG(total) FROM orders
-		 )`,
-	}
+	queries := complexJoinQueries
 	
 	for i := 0; i < count; i++ {
 		query := queries[i%len(queries)]
@@ -233,44 +235,7 @@ func generateComplexJoinQueries(db *database.DB, ingester *ingest.SlowQueryInges
 func generateAggregationQueries(db *database.DB, ingester *ingest.SlowQueryIngester, cfg *config.Config) error {
 	fmt.Println("   📊 Running heavy aggregation queries...")
 	
-	queries := []string{
-		// Heavy GROUP BY with ORDER BY
-		`SELECT c.city, c.country, COUNT(*) as customers, 
-		        SUM(o.total) as total_sales,
-		        AVG(o.total) as avg_order
-		 FROM customers c
-		 LEFT JOIN orders o ON c.id = o.customer_id
-		 GROUP BY c.city, c.country
-		 ORDER BY total_sales DESC, avg_order DESC`,
-		
-		// Complex aggregation with text operations
-		`SELECT 
-		   UPPER(p.category) as category,
-		   COUNT(DISTINCT c.id) as unique_customers,
-		   COUNT(oi.id) as items_sold,
-		   SUM(oi.quantity * oi.price) as revenue,
-		   CONCAT(MIN(p.name), ' to ', MAX(p.name)) as product_range
-		 FROM products p
-		 JOIN order_items oi ON p.id = oi.product_id
-		 JOIN orders o ON oi.order_id = o.id
-		 JOIN customers c ON o.customer_id = c.id
-		 WHERE p.description LIKE '%professional%' OR p.description LIKE '%premium%'
-		 GROUP BY p.category
-		 HAVING revenue > 100
-		 ORDER BY COUNT(DISTINCT c.id) DESC, revenue DESC`,
-		
-		// Window functions with aggregation
-		`SELECT 
-		   c.email,
-		   o.total,
-		   ROW_NUMBER() OVER (PARTITION BY c.city ORDER BY o.total DESC) as city_rank,
-		   SUM(o.total) OVER (PARTITION BY c.country) as country_total,
-		   RANK() OVER (ORDER BY o.total DESC) as global_rank
-		 FROM customers c
-		 JOIN orders o ON c.id = o.customer_id
-		 WHERE o.status IN ('paid', 'shipped', 'delivered')
-		 ORDER BY o.total DESC`,
-	}
+	queries := aggregationQueries
 	
 	for i := 0; i < count; i++ {
 		query := queries[i%len(queries)]
@@ -288,6 +253,130 @@ func generateAggregationQueries(db *database.DB, ingester *ingest.SlowQueryInges
 	return nil
 }
 
+// fullScanQueries are table scans over unindexed columns
+var fullScanQueries = []string{
+	// Search in product names (no index on name field)
+	"SELECT * FROM products WHERE name LIKE '%Book%'",
+	
+	// Search in order notes (text field, no index)
+	"SELECT * FROM orders WHERE notes LIKE '%special%'",
+	
+	// Search in customer emails with wildcard (defeats index)
+	"SELECT * FROM customers WHERE email LIKE '%gmail%'",
+	
+	// Complex WHERE on multiple unindexed fields
+	"SELECT * FROM products WHERE description LIKE '%professional%' AND name LIKE '%Pro%'",
+	
+	// Range query on unindexed total field
+	"SELECT * FROM orders WHERE total BETWEEN 100 AND 300",
+}
+
+// complexJoinQueries are inefficient multi-table JOIN patterns
+var complexJoinQueries = []string{
+	// Inefficient cross join pattern
+	`SELECT c.email, o.total, p.name 
+	 FROM customers c, orders o, products p, order_items oi
+	 WHERE c.id = o.customer_id 
+	 AND o.id = oi.order_id 
+	 AND oi.product_id = p.id
+	 AND c.city LIKE '%New%'`,
+	
+	// Multiple JOINs with text search
+	`SELECT c.company, COUNT(*) as order_count, SUM(o.total) as total_spent
+	 FROM customers c 
+	 JOIN orders o ON c.id = o.customer_id
+	 JOIN order_items oi ON o.id = oi.order_id
+	 JOIN products p ON oi.product_id = p.id
+	 WHERE p.description LIKE '%professional%'
+	 GROUP BY c.company
+	 ORDER BY total_spent DESC`,
+	
+	// Subquery with JOIN
+	`SELECT * FROM orders o
+	 WHERE o.customer_id IN (
+	   SELECT c.id FROM customers c 
+	   WHERE c.email LIKE '%@gmail%' 
+	   AND c.company LIKE '%Tech%'
+	 )
+	 AND o.total > (
+	   SELECT AVG(total) FROM orders
+	 )`,
+}
+
+// aggregationQueries are heavy GROUP BY/ORDER BY and window queries
+var aggregationQueries = []string{
+	// Heavy GROUP BY with ORDER BY
+	`SELECT c.city, c.country, COUNT(*) as customers, 
+	        SUM(o.total) as total_sales,
+	        AVG(o.total) as avg_order
+	 FROM customers c
+	 LEFT JOIN orders o ON c.id = o.customer_id
+	 GROUP BY c.city, c.country
+	 ORDER BY total_sales DESC, avg_order DESC`,
+	
+	// Complex aggregation with text operations
+	`SELECT 
+	   UPPER(p.category) as category,
+	   COUNT(DISTINCT c.id) as unique_customers,
+	   COUNT(oi.id) as items_sold,
+	   SUM(oi.quantity * oi.price) as revenue,
+	   CONCAT(MIN(p.name), ' to ', MAX(p.name)) as product_range
+	 FROM products p
+	 JOIN order_items oi ON p.id = oi.product_id
+	 JOIN orders o ON oi.order_id = o.id
+	 JOIN customers c ON o.customer_id = c.id
+	 WHERE p.description LIKE '%professional%' OR p.description LIKE '%premium%'
+	 GROUP BY p.category
+	 HAVING revenue > 100
+	 ORDER BY COUNT(DISTINCT c.id) DESC, revenue DESC`,
+	
+	// Window functions with aggregation
+	`SELECT 
+	   c.email,
+	   o.total,
+	   ROW_NUMBER() OVER (PARTITION BY c.city ORDER BY o.total DESC) as city_rank,
+	   SUM(o.total) OVER (PARTITION BY c.country) as country_total,
+	   RANK() OVER (ORDER BY o.total DESC) as global_rank
+	 FROM customers c
+	 JOIN orders o ON c.id = o.customer_id
+	 WHERE o.status IN ('paid', 'shipped', 'delivered')
+	 ORDER BY o.total DESC`,
+}
+
+// sleepQuery returns the SLEEP()-based slow query for the given length
+func sleepQuery(seconds int) string {
+	return fmt.Sprintf("SELECT SLEEP(%d), id, email FROM customers LIMIT 1", seconds)
+}
+
+// secondsDuration is a duration flag that also accepts a bare number of seconds
+type secondsDuration time.Duration
+
+func (d *secondsDuration) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *secondsDuration) Set(value string) error {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		*d = secondsDuration(time.Duration(seconds) * time.Second)
+		return nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: use seconds (2) or a unit (60s, 5m)", value)
+	}
+	*d = secondsDuration(parsed)
+	return nil
+}
+
+func (d *secondsDuration) Type() string {
+	return "duration"
+}
+
+// Seconds returns the duration in whole seconds
+func (d secondsDuration) Seconds() int {
+	return int(time.Duration(d).Seconds())
+}
+
 func pluralize(count int) string {
 	if count == 1 {
 		return "y"
@@ -0,0 +1,299 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/ingest"
+	"github.com/matthieukhl/latentia/internal/safety"
+	"github.com/spf13/cobra"
+)
+
+// loadTemplate is one query in the load generation mix
+type loadTemplate struct {
+	Name string
+	SQL  string
+}
+
+// templateStats accumulates latencies and errors for a single template
+type templateStats struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	errors    int
+	recorded  int
+}
+
+// LoadSummary is the per-template result of a parallel load run
+type LoadSummary struct {
+	Template   string  `json:"template"`
+	SQL        string  `json:"sql"`
+	Executions int     `json:"executions"`
+	Errors     int     `json:"errors"`
+	Recorded   int     `json:"recorded"`
+	P50Ms      float64 `json:"p50_ms"`
+	P95Ms      float64 `json:"p95_ms"`
+	P99Ms      float64 `json:"p99_ms"`
+	MaxMs      float64 `json:"max_ms"`
+}
+
+// LoadReport is the JSON document written by --json-out
+type LoadReport struct {
+	QueryType string        `json:"query_type"`
+	Workers   int           `json:"workers"`
+	Elapsed   string        `json:"elapsed"`
+	Templates []LoadSummary `json:"templates"`
+}
+
+func generateParallelLoad(cmd *cobra.Command) error {
+	runFor := time.Duration(duration)
+	if !cmd.Flags().Changed("duration") {
+		runFor = 60 * time.Second
+	}
+
+	templates, err := loadTemplates(queryType)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("🐌 Generating '%s' load with %d workers for %v...\n", queryType, parallel, runFor)
+	if record {
+		fmt.Println("📝 Recording qualifying executions to app_slow_queries table...")
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Each worker holds a connection for the whole run, plus one for recording
+	dbCfg := cfg.DB
+	if dbCfg.MaxOpenConns < parallel+1 {
+		dbCfg.MaxOpenConns = parallel + 1
+	}
+
+	db, err := database.NewConnection(&dbCfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	var ingester *ingest.SlowQueryIngester
+	if record {
+		if err := db.UpgradeAppSchema(context.Background()); err != nil {
+			return fmt.Errorf("failed to upgrade app schema: %w", err)
+		}
+		ingester = ingest.NewSlowQueryIngester(db)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, runFor)
+	defer cancel()
+
+	stats := make([]*templateStats, len(templates))
+	for i := range stats {
+		stats[i] = &templateStats{}
+	}
+
+	started := time.Now()
+	var wg sync.WaitGroup
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			runLoadWorker(ctx, db, ingester, templates, stats, worker)
+		}(w)
+	}
+
+	// Progress ticker so long runs show signs of life
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				executions := 0
+				for _, st := range stats {
+					st.mu.Lock()
+					executions += len(st.latencies) + st.errors
+					st.mu.Unlock()
+				}
+				fmt.Printf("   ⏱️  %v elapsed, %d executions\n", time.Since(started).Round(time.Second), executions)
+			}
+		}
+	}()
+
+	wg.Wait()
+	elapsed := time.Since(started)
+
+	if ctx.Err() == context.Canceled {
+		fmt.Println("\n🛑 Interrupted, stopping workers")
+	}
+
+	report := LoadReport{
+		QueryType: queryType,
+		Workers:   parallel,
+		Elapsed:   elapsed.Round(time.Millisecond).String(),
+	}
+	for i, tmpl := range templates {
+		report.Templates = append(report.Templates, summarizeTemplate(tmpl, stats[i]))
+	}
+
+	printLoadSummary(report)
+
+	if loadJSONOut != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode summary: %w", err)
+		}
+		if err := os.WriteFile(loadJSONOut, data, 0644); err != nil {
+			return fmt.Errorf("failed to write summary: %w", err)
+		}
+		fmt.Printf("💾 Summary written to %s\n", loadJSONOut)
+	}
+
+	return nil
+}
+
+// runLoadWorker issues queries round-robin from its own offset until ctx is done
+func runLoadWorker(ctx context.Context, db *database.DB, ingester *ingest.SlowQueryIngester, templates []loadTemplate, stats []*templateStats, worker int) {
+	for i := worker; ctx.Err() == nil; i++ {
+		idx := i % len(templates)
+		tmpl := templates[idx]
+
+		start := time.Now()
+		_, err := executeDrained(ctx, db, tmpl.SQL)
+		elapsed := time.Since(start)
+
+		// Cancellation mid-query is the end of the run, not a query error
+		if ctx.Err() != nil {
+			return
+		}
+
+		st := stats[idx]
+		if err != nil {
+			st.mu.Lock()
+			st.errors++
+			st.mu.Unlock()
+			continue
+		}
+
+		recorded := false
+		if ingester != nil && elapsed.Seconds() >= 0.01 {
+			if err := ingester.RecordGeneratedSlowQuery(ctx, tmpl.SQL, start, elapsed.Seconds(), "latentia", "agent-generator", ""); err == nil {
+				recorded = true
+			}
+		}
+
+		st.mu.Lock()
+		st.latencies = append(st.latencies, elapsed)
+		if recorded {
+			st.recorded++
+		}
+		st.mu.Unlock()
+	}
+}
+
+// executeDrained runs a query through safety.QuerySafe and reads every row
+// so the full cost is paid
+func executeDrained(ctx context.Context, db *database.DB, query string) (int, error) {
+	rows, err := safety.QuerySafe(ctx, db.TargetDB(), query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		count++
+	}
+	return count, rows.Err()
+}
+
+// loadTemplates returns the named query mix for a generator type
+func loadTemplates(queryType string) ([]loadTemplate, error) {
+	var queries []string
+	switch queryType {
+	case "sleep":
+		queries = []string{sleepQuery(sleepSeconds)}
+	case "full-scan":
+		queries = fullScanQueries
+	case "complex-join":
+		queries = complexJoinQueries
+	case "aggregation":
+		queries = aggregationQueries
+	default:
+		return nil, fmt.Errorf("unknown query type: %s", queryType)
+	}
+
+	templates := make([]loadTemplate, len(queries))
+	for i, q := range queries {
+		templates[i] = loadTemplate{
+			Name: fmt.Sprintf("%s#%d", queryType, i+1),
+			SQL:  q,
+		}
+	}
+	return templates, nil
+}
+
+func summarizeTemplate(tmpl loadTemplate, st *templateStats) LoadSummary {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	latencies := append([]time.Duration(nil), st.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	summary := LoadSummary{
+		Template:   tmpl.Name,
+		SQL:        truncateSQL(tmpl.SQL, 120),
+		Executions: len(latencies) + st.errors,
+		Errors:     st.errors,
+		Recorded:   st.recorded,
+		P50Ms:      percentileMs(latencies, 0.50),
+		P95Ms:      percentileMs(latencies, 0.95),
+		P99Ms:      percentileMs(latencies, 0.99),
+	}
+	if len(latencies) > 0 {
+		summary.MaxMs = float64(latencies[len(latencies)-1].Microseconds()) / 1000
+	}
+	return summary
+}
+
+// percentileMs returns the nearest-rank percentile of sorted latencies in milliseconds
+func percentileMs(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return float64(sorted[rank].Microseconds()) / 1000
+}
+
+func printLoadSummary(report LoadReport) {
+	fmt.Printf("\n📊 Load summary (%d workers, %s)\n", report.Workers, report.Elapsed)
+	fmt.Println(strings.Repeat("─", 80))
+	fmt.Printf("%-16s %10s %10s %10s %10s %8s %9s\n", "TEMPLATE", "EXECS", "P50 ms", "P95 ms", "P99 ms", "ERRORS", "RECORDED")
+	for _, t := range report.Templates {
+		fmt.Printf("%-16s %10d %10.1f %10.1f %10.1f %8d %9d\n",
+			t.Template, t.Executions, t.P50Ms, t.P95Ms, t.P99Ms, t.Errors, t.Recorded)
+	}
+	fmt.Println(strings.Repeat("─", 80))
+}
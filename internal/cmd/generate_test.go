@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/matthieukhl/latentia/internal/config/configtest"
+)
+
+// cannedPlan is the EXPLAIN ANALYZE output planDB answers, as TiDB prints it
+var cannedPlan = [][]string{
+	{"id", "estRows", "actRows", "task", "access object", "execution info", "operator info", "memory", "disk"},
+	{"Projection_4", "10000.00", "12", "root", "", "time:52.1ms, loops:2", "latentia.customers.email", "1.2 KB", "N/A"},
+	{"└─TableReader_7", "10000.00", "12", "root", "", "time:51.9ms, loops:2", "data:Selection_6", "3.4 KB", "N/A"},
+	{"  └─Selection_6", "10000.00", "12", "cop[tikv]", "", "tikv_task:{time:50ms}", `like(latentia.customers.email, "%john%", 92)`, "N/A", "N/A"},
+	{"    └─TableFullScan_5", "12000.00", "12000", "cop[tikv]", "table:customers", "tikv_task:{time:48ms}", "keep order:false", "N/A", "N/A"},
+}
+
+// planDB is a target answering every query with cannedPlan, or failing with
+// err, and recording the statements it is sent
+type planDB struct {
+	err error
+
+	mu      sync.Mutex
+	queries []string
+}
+
+func (d *planDB) Connect(context.Context) (driver.Conn, error) { return planConn{d}, nil }
+func (d *planDB) Driver() driver.Driver                        { return nil }
+
+func (d *planDB) sent() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.queries...)
+}
+
+type planConn struct{ db *planDB }
+
+func (c planConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c planConn) Close() error                        { return nil }
+func (c planConn) Begin() (driver.Tx, error)           { return c, nil }
+func (c planConn) Commit() error                       { return nil }
+func (c planConn) Rollback() error                     { return nil }
+
+func (c planConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) { return c, nil }
+
+func (c planConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	c.db.queries = append(c.db.queries, query)
+	c.db.mu.Unlock()
+	if c.db.err != nil {
+		return nil, c.db.err
+	}
+	return &planRows{rows: cannedPlan[1:]}, nil
+}
+
+type planRows struct{ rows [][]string }
+
+func (r *planRows) Columns() []string { return cannedPlan[0] }
+func (r *planRows) Close() error      { return nil }
+
+func (r *planRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	for i, cell := range r.rows[0] {
+		dest[i] = cell
+	}
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestCapturePlanOfCannedPlan(t *testing.T) {
+	const query = "SELECT email FROM customers WHERE email LIKE '%john%'"
+	tests := []struct {
+		name   string
+		config string
+		err    error
+		want   string
+	}{
+		{
+			"whole plan",
+			"",
+			nil,
+			"id | estRows | actRows | task | access object | execution info | operator info | memory | disk\n" +
+				"Projection_4 | 10000.00 | 12 | root |  | time:52.1ms, loops:2 | latentia.customers.email | 1.2 KB | N/A\n" +
+				"└─TableReader_7 | 10000.00 | 12 | root |  | time:51.9ms, loops:2 | data:Selection_6 | 3.4 KB | N/A\n" +
+				`  └─Selection_6 | 10000.00 | 12 | cop[tikv] |  | tikv_task:{time:50ms} | like(latentia.customers.email, "%john%", 92) | N/A | N/A` + "\n" +
+				"    └─TableFullScan_5 | 12000.00 | 12000 | cop[tikv] | table:customers | tikv_task:{time:48ms} | keep order:false | N/A | N/A\n",
+		},
+		{
+			"operators beyond safety.max_rows",
+			"safety:\n  max_rows: 2\n",
+			nil,
+			"id | estRows | actRows | task | access object | execution info | operator info | memory | disk\n" +
+				"Projection_4 | 10000.00 | 12 | root |  | time:52.1ms, loops:2 | latentia.customers.email | 1.2 KB | N/A\n" +
+				"└─TableReader_7 | 10000.00 | 12 | root |  | time:51.9ms, loops:2 | data:Selection_6 | 3.4 KB | N/A\n" +
+				"-- more operators beyond safety.max_rows\n",
+		},
+		{
+			// The query is still recorded, without a plan
+			"capture failure",
+			"",
+			errors.New("Error 1105: EXPLAIN ANALYZE not supported"),
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configtest.Load(t, tt.config)
+			target := &planDB{err: tt.err}
+			pool := sql.OpenDB(target)
+			defer pool.Close()
+
+			if got := capturePlanOf(pool, query, 1); got != tt.want {
+				t.Errorf("capturePlanOf =\n%s\nwant\n%s", got, tt.want)
+			}
+			sent := target.sent()
+			// The statement run again is bounded by the sandbox limits
+			if len(sent) != 1 || !strings.HasPrefix(sent[0], "EXPLAIN ANALYZE SELECT /*+ MAX_EXECUTION_TIME(") || !strings.HasSuffix(sent[0], strings.TrimPrefix(query, "SELECT")) {
+				t.Errorf("statements sent = %q, want one sandboxed EXPLAIN ANALYZE of the query", sent)
+			}
+		})
+	}
+}
+
+func TestCapturePlanFlags(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"generate-slow", "--capture-plan", "--record=false"}, "--capture-plan requires --record"},
+		{[]string{"generate-slow", "--capture-plan", "--parallel", "4"}, "[capture-plan parallel] were all set"},
+	}
+	for _, tt := range tests {
+		err := execute(t, "", tt.args...)
+		resetGenerateFlags()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q = %v, want an error mentioning %q", tt.args, err, tt.want)
+		}
+	}
+}
+
+// resetGenerateFlags puts the flags TestCapturePlanFlags sets back to their
+// defaults, which cobra keeps between runs
+func resetGenerateFlags() {
+	for _, name := range []string{"capture-plan", "record", "parallel"} {
+		f := generateCmd.Flags().Lookup(name)
+		f.Value.Set(f.DefValue)
+		f.Changed = false
+	}
+}
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/analyze"
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/ingest"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyDigest string
+	historyLimit  int
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history --digest <digest>",
+	Short: "Show what was tried for a digest, as a timeline",
+	Long: `Print the accepted rewrite of a digest, if any, then a timeline of its
+slow query occurrences, the rewrites proposed for them and every review
+decision, oldest first. Only the latest --limit occurrences are listed;
+rewrites and reviews are always listed in full.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return showDigestHistory()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().StringVar(&historyDigest, "digest", "", "Digest of the slow queries")
+	historyCmd.Flags().IntVar(&historyLimit, "limit", 50, fmt.Sprintf("Latest occurrences to list (at most %d)", ingest.MaxSlowQueryPageSize))
+	_ = historyCmd.MarkFlagRequired("digest")
+}
+
+// historyEvent is one line of the timeline
+type historyEvent struct {
+	at   time.Time
+	text string
+}
+
+func showDigestHistory() error {
+	if historyLimit < 1 || historyLimit > ingest.MaxSlowQueryPageSize {
+		return fmt.Errorf("--limit must be between 1 and %d", ingest.MaxSlowQueryPageSize)
+	}
+	digest := strings.ToLower(strings.TrimSpace(historyDigest))
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if err := db.UpgradeAppSchema(ctx); err != nil {
+		return fmt.Errorf("failed to upgrade schema: %w", err)
+	}
+
+	occurrences, total, err := ingest.NewSlowQueryIngester(db).ListSlowQueries(ctx, ingest.SlowQueryFilter{Digest: digest, PageSize: historyLimit})
+	if err != nil {
+		return err
+	}
+	history, err := analyze.NewOptimizationEngine(db, nil, nil).DigestHistory(ctx, digest)
+	if err != nil {
+		return err
+	}
+	if total == 0 && len(history.Rewrites) == 0 {
+		return fmt.Errorf("no slow queries or rewrites for digest %s", digest)
+	}
+
+	fmt.Printf("📜 Digest %s: %d occurrence%s, %d rewrite%s\n", digest, total, plural(total), len(history.Rewrites), plural(len(history.Rewrites)))
+	if accepted := history.Accepted; accepted != nil {
+		fmt.Printf("\n✅ Accepted rewrite %d (confidence %.2f", accepted.ID, accepted.ConfidenceScore)
+		if accepted.ReviewedAt != nil {
+			fmt.Printf(", accepted %s", accepted.ReviewedAt.Local().Format(time.DateTime))
+		}
+		if accepted.ReviewedBy != "" {
+			fmt.Printf(" by %s", accepted.ReviewedBy)
+		}
+		if accepted.RealizedStatus != "" {
+			fmt.Printf(", realized: %s", accepted.RealizedStatus)
+		}
+		fmt.Printf(")\n%s\n", indent(accepted.OptimizedSQL, "   "))
+	} else {
+		fmt.Println("\n   No accepted rewrite")
+	}
+	if len(history.PromptVersions) > 1 {
+		fmt.Printf("\n🧾 By prompt version\n")
+		for _, v := range history.PromptVersions {
+			fmt.Printf("   %-40s %d rewrite%s, %d accepted, %d rejected, acceptance %s, avg confidence %.2f\n",
+				promptVersionLabel(v.Version), v.Rewrites, plural(v.Rewrites), v.Accepted, v.Rejected, formatRate(v.AcceptanceRate), v.AvgConfidence)
+		}
+	}
+
+	var events []historyEvent
+	for _, q := range occurrences {
+		events = append(events, historyEvent{q.StartedAt, fmt.Sprintf("🐢 slow query %d ran %.2fs (%s, %s)", q.ID, q.QueryTime, q.Source, q.Status)})
+	}
+	for _, r := range history.Rewrites {
+		text := fmt.Sprintf("💡 rewrite %d proposed for slow query %d (%s, confidence %.2f", r.ID, r.SlowQueryID, r.Status, r.ConfidenceScore)
+		if r.ParentRewriteID != nil {
+			text += fmt.Sprintf(", retry of %d", *r.ParentRewriteID)
+		}
+		if r.RealizedStatus != "" {
+			text += ", realized: " + r.RealizedStatus
+		}
+		if r.PromptVersion != "" {
+			text += ", prompt " + r.PromptVersion
+		}
+		events = append(events, historyEvent{r.CreatedAt, text + ")"})
+
+		for _, review := range r.Reviews {
+			by := review.Actor
+			if reviewer, ok := review.Details["reviewer"].(string); ok && reviewer != "" {
+				by = reviewer + " via " + review.Actor
+			}
+			text := fmt.Sprintf("%s rewrite %d %s by %s", reviewIcon(review.Action), r.ID, reviewVerb(review.Action), by)
+			if review.Reason != "" {
+				text += ": " + review.Reason
+			}
+			events = append(events, historyEvent{review.CreatedAt, text})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].at.Before(events[j].at) })
+
+	fmt.Println()
+	if total > len(occurrences) {
+		fmt.Printf("   Listing the latest %d of %d occurrences\n", len(occurrences), total)
+	}
+	for _, e := range events {
+		fmt.Printf("%s  %s\n", e.at.Local().Format(time.DateTime), e.text)
+	}
+	return nil
+}
+
+func reviewIcon(action string) string {
+	switch action {
+	case database.ActionAccept:
+		return "✅"
+	case database.ActionReject:
+		return "❌"
+	case database.ActionRetry:
+		return "🔁"
+	}
+	return "📝"
+}
+
+// reviewVerb phrases an audit action for the timeline; a retry entry is
+// recorded on the rewrite it created
+func reviewVerb(action string) string {
+	switch action {
+	case database.ActionAccept:
+		return "accepted"
+	case database.ActionReject:
+		return "rejected"
+	case database.ActionRetry:
+		return "requested as a retry"
+	}
+	return action
+}
+
+func indent(text, prefix string) string {
+	return prefix + strings.ReplaceAll(strings.TrimSpace(text), "\n", "\n"+prefix)
+}
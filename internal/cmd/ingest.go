@@ -1,8 +1,12 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/matthieukhl/latentia/internal/config"
 	"github.com/matthieukhl/latentia/internal/database"
@@ -13,6 +17,7 @@ import (
 var (
 	ingestMinTime float64
 	ingestLimit   int
+	ingestTarget  string
 )
 
 var ingestCmd = &cobra.Command{
@@ -23,7 +28,11 @@ into the app_slow_queries table for analysis.
 
 This command is designed for on-premise TiDB installations where
 INFORMATION_SCHEMA.SLOW_QUERY is accessible. TiDB Serverless users
-should use the generate-slow command with --record flag instead.`,
+should use the generate-slow command with --record flag instead.
+
+Every enabled entry of db.targets is read unless --target names one:
+
+  agent ingest-slow --target prod-eu`,
 	RunE: ingestSlowQueries,
 }
 
@@ -32,6 +41,7 @@ func init() {
 	
 	ingestCmd.Flags().Float64Var(&ingestMinTime, "min-time", 0.1, "Minimum query time in seconds to ingest")
 	ingestCmd.Flags().IntVar(&ingestLimit, "limit", 100, "Maximum number of slow queries to ingest")
+	ingestCmd.Flags().StringVar(&ingestTarget, "target", "", "Only ingest from this db.targets entry")
 }
 
 func ingestSlowQueries(cmd *cobra.Command, args []string) error {
@@ -49,15 +59,55 @@ func ingestSlowQueries(cmd *cobra.Command, args []string) error {
 	}
 	defer db.Close()
 	
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := db.UpgradeAppSchema(ctx); err != nil {
+		return fmt.Errorf("failed to upgrade app schema: %w", err)
+	}
+	
+	targets := db.TargetNames()
+	if ingestTarget != "" {
+		targets = []string{ingestTarget}
+	}
+	for _, target := range targets {
+		tdb, err := db.ForTarget(target)
+		if err != nil {
+			return err
+		}
+		if len(targets) > 1 || ingestTarget != "" {
+			fmt.Printf("\n🎯 Target %s\n", target)
+		}
+		summary, err := ingest.NewSlowQueryIngester(tdb).IngestFromInformationSchema(ctx, ingestMinTime, ingestLimit)
+		if err != nil {
+			return fmt.Errorf("failed to ingest slow queries from target %s: %w", target, err)
+		}
+		
+		fmt.Printf("   Fetched: %d, Inserted: %d, Duplicates: %d\n", summary.Fetched, summary.Inserted, summary.Duplicates)
+		if summary.Suppressed > 0 {
+			fmt.Printf("   🔕 Suppressed (stored as skipped): %d\n", summary.Suppressed)
+		}
+		if summary.Linked > 0 {
+			fmt.Printf("   🔗 Already analyzed digests (linked to their rewrite): %d\n", summary.Linked)
+		}
+		printExcluded(summary.Excluded)
+	}
 	ingester := ingest.NewSlowQueryIngester(db)
 	
-	err = ingester.IngestFromInformationSchema(ingestMinTime, ingestLimit)
+	// Show the digests costing the most time overall
+	stats, err := db.ListSlowQueryStats(ctx, 5)
 	if err != nil {
-		return fmt.Errorf("failed to ingest slow queries: %w", err)
+		return err
+	}
+	if len(stats) > 0 {
+		fmt.Printf("\n🔥 Top digests by total time:\n")
+		for i, st := range stats {
+			fmt.Printf("   %d. %.1fs total, %d× avg %.3fs max %.3fs - %s\n", i+1,
+				st.TotalQueryTime, st.ExecCount, st.AvgQueryTime, st.MaxQueryTime, truncateSQL(st.SampleSQL, 60))
+		}
 	}
 	
 	// Show summary of ingested queries
-	queries, err := ingester.GetSlowQueries("pending", 10)
+	queries, err := ingester.GetSlowQueries(ctx, "pending", 10)
 	if err != nil {
 		return fmt.Errorf("failed to get ingested queries: %w", err)
 	}
@@ -66,7 +116,7 @@ func ingestSlowQueries(cmd *cobra.Command, args []string) error {
 	fmt.Printf("🔍 Recent slow queries (showing last %d):\n", len(queries))
 	
 	for i, q := range queries {
-		fmt.Printf("   %d. [%s] %.3fs - %s\n", i+1, q.Source, q.QueryTime, truncateSQL(q.SampleSQL, 60))
+		fmt.Printf("   %d. [%s] %.3fs - %s\n", i+1, targetLabel(q.Target, q.Source), q.QueryTime, truncateSQL(q.SampleSQL, 60))
 	}
 	
 	if len(queries) > 0 {
@@ -78,6 +128,23 @@ func ingestSlowQueries(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// targetLabel prefixes source with the target of a slow query unless it is
+// the default one of a single-target setup
+func targetLabel(target, source string) string {
+	if target == "" || target == config.DefaultTarget {
+		return source
+	}
+	return target + "/" + source
+}
+
+// printExcluded reports slow queries dropped by ingest.filters, per rule
+func printExcluded(excluded map[string]int) {
+	if len(excluded) == 0 {
+		return
+	}
+	fmt.Printf("   🚫 Excluded by ingest.filters: %s\n", ingest.FormatExcluded(excluded))
+}
+
 func truncateSQL(sql string, maxLen int) string {
 	// Clean up whitespace
 	sql = strings.ReplaceAll(sql, "\n", " ")
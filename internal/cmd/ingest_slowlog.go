@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/ingest"
+	"github.com/spf13/cobra"
+)
+
+var (
+	slowLogFile     string
+	slowLogMinTime  float64
+	slowLogFollow   bool
+	slowLogInterval time.Duration
+	slowLogTarget   string
+)
+
+var ingestSlowLogCmd = &cobra.Command{
+	Use:   "ingest-slowlog",
+	Short: "Ingest slow queries from a TiDB or MySQL slow log file",
+	Long: `Ingest slow queries from a slow log file, such as tidb-slow.log, into the
+app_slow_queries table for analysis, with the source "slowlog".
+
+This is for self-hosted clusters where INFORMATION_SCHEMA.SLOW_QUERY is
+restricted but the log files can be read. Entries already ingested, by
+digest and start time, are skipped, so the same file can be read again.
+Blocks that cannot be parsed are skipped and counted. With --follow the
+file is read as it grows, across rotations, until interrupted. The entries
+belong to the default target unless --target names the db.targets entry
+the log comes from.`,
+	RunE: ingestSlowLog,
+}
+
+func init() {
+	rootCmd.AddCommand(ingestSlowLogCmd)
+
+	ingestSlowLogCmd.Flags().StringVar(&slowLogFile, "file", "", "Slow log file to read")
+	ingestSlowLogCmd.Flags().Float64Var(&slowLogMinTime, "min-time", 0.1, "Minimum query time in seconds to ingest")
+	ingestSlowLogCmd.Flags().BoolVar(&slowLogFollow, "follow", false, "Keep reading entries appended to the file")
+	ingestSlowLogCmd.Flags().DurationVar(&slowLogInterval, "interval", time.Second, "How often --follow checks the file for new entries")
+	ingestSlowLogCmd.Flags().StringVar(&slowLogTarget, "target", "", "db.targets entry the log comes from, the default one if unset")
+	_ = ingestSlowLogCmd.MarkFlagRequired("file")
+}
+
+func ingestSlowLog(cmd *cobra.Command, args []string) error {
+	if slowLogInterval <= 0 {
+		return fmt.Errorf("--interval must be > 0")
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := db.UpgradeAppSchema(ctx); err != nil {
+		return fmt.Errorf("failed to upgrade app schema: %w", err)
+	}
+
+	tail, err := ingest.OpenSlowLog(slowLogFile)
+	if err != nil {
+		return err
+	}
+	defer tail.Close()
+
+	tdb, err := db.ForTarget(slowLogTarget)
+	if err != nil {
+		return err
+	}
+	ingester := ingest.NewSlowQueryIngester(tdb)
+	fmt.Printf("🔄 Ingesting slow queries from %s...\n", slowLogFile)
+	fmt.Printf("   Min time: %.1fs\n", slowLogMinTime)
+
+	read := func(final bool) error {
+		queries, malformed, err := tail.Read(final)
+		if err != nil {
+			return err
+		}
+		if len(queries) == 0 && malformed == 0 && slowLogFollow {
+			return nil
+		}
+		summary, err := ingester.IngestSlowLog(ctx, queries, slowLogMinTime)
+		if err != nil {
+			return fmt.Errorf("failed to ingest slow queries: %w", err)
+		}
+		fmt.Printf("   Read: %d, Inserted: %d, Duplicates: %d\n", len(queries), summary.Inserted, summary.Duplicates)
+		if malformed > 0 {
+			fmt.Printf("   ⚠️  Malformed entries skipped: %d\n", malformed)
+		}
+		if summary.Suppressed > 0 {
+			fmt.Printf("   🔕 Suppressed (stored as skipped): %d\n", summary.Suppressed)
+		}
+		if summary.Linked > 0 {
+			fmt.Printf("   🔗 Already analyzed digests (linked to their rewrite): %d\n", summary.Linked)
+		}
+		printExcluded(summary.Excluded)
+		return nil
+	}
+
+	if !slowLogFollow {
+		return read(true)
+	}
+
+	fmt.Printf("👀 Following %s, press Ctrl+C to stop\n", slowLogFile)
+	ticker := time.NewTicker(slowLogInterval)
+	defer ticker.Stop()
+	for {
+		if err := read(false); err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
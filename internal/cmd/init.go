@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	initOutput         string
+	initNonInteractive bool
+	initForce          bool
+)
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create a starter config.yaml",
+	Long: `Ask a few questions (database, LLM provider, background worker) and
+write a commented config.yaml. With --non-interactive, write a template using
+the mock providers so 'agent run' works immediately.
+
+An existing file is never overwritten without --force.`,
+	RunE: initConfig,
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+
+	initCmd.Flags().StringVarP(&initOutput, "output", "o", "config.yaml", "Path of the config file to write")
+	initCmd.Flags().BoolVar(&initNonInteractive, "non-interactive", false, "Write the mock-provider template without asking")
+	initCmd.Flags().BoolVar(&initForce, "force", false, "Overwrite an existing file")
+}
+
+// initAnswers are the template inputs; defaultInitAnswers yields the
+// non-interactive mock template
+type initAnswers struct {
+	DSN               string
+	GeneratorProvider string
+	GeneratorModel    string
+	GeneratorKeyEnv   string
+	GeneratorBaseURL  string
+	EmbedderProvider  string
+	EmbedderModel     string
+	EmbedderKeyEnv    string
+	Worker            bool
+}
+
+func defaultInitAnswers() initAnswers {
+	return initAnswers{
+		DSN:               "root@tcp(127.0.0.1:4000)/test?parseTime=true",
+		GeneratorProvider: "mock",
+		GeneratorModel:    "mock-generator",
+		EmbedderProvider:  "mock",
+		EmbedderModel:     "mock-embedding",
+	}
+}
+
+// Default model and key variable per provider
+var initProviderDefaults = map[string]struct{ generator, embedder, keyEnv string }{
+	"openai":       {"gpt-4o-mini", "text-embedding-3-small", "OPENAI_API_KEY"},
+	"azure-openai": {"gpt-4o-mini", "", "AZURE_OPENAI_API_KEY"},
+	"anthropic":    {"claude-3-5-sonnet", "", "ANTHROPIC_API_KEY"},
+	"gemini":       {"gemini-2.0-flash", "", "GEMINI_API_KEY"},
+	"ollama":       {"llama3.1", "", ""},
+	"mock":         {"mock-generator", "mock-embedding", ""},
+}
+
+func initConfig(cmd *cobra.Command, args []string) error {
+	answers := defaultInitAnswers()
+	path := initOutput
+
+	if !initNonInteractive {
+		p := &prompter{in: bufio.NewReader(cmd.InOrStdin()), out: cmd.OutOrStdout()}
+		fmt.Println("🛠️  Creating a Latentia configuration (press Enter to accept defaults)")
+		fmt.Println()
+		var err error
+		if path, answers, err = askInitQuestions(p, path, answers); err != nil {
+			return err
+		}
+	}
+
+	if !initForce {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists; use --force to overwrite it", path)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to check %s: %w", path, err)
+		}
+	}
+
+	data, err := renderInitConfig(answers)
+	if err != nil {
+		return err
+	}
+	// Never write a file that would not load
+	if err := config.ValidateData(data); err != nil {
+		return fmt.Errorf("generated config is invalid: %w", err)
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	fmt.Printf("✅ Wrote %s\n", path)
+	for _, env := range []string{answers.GeneratorKeyEnv, answers.EmbedderKeyEnv} {
+		if env != "" && os.Getenv(env) == "" {
+			fmt.Printf("💡 Export %s before starting the agent\n", env)
+		}
+	}
+	fmt.Println("💡 Check it with 'agent config validate', then start with 'agent run'")
+	return nil
+}
+
+func askInitQuestions(p *prompter, path string, a initAnswers) (string, initAnswers, error) {
+	var err error
+	ask := func(question, def string) string {
+		if err != nil {
+			return def
+		}
+		var answer string
+		answer, err = p.ask(question, def)
+		return answer
+	}
+
+	path = ask("Config file path", path)
+
+	if dsn := ask("TiDB DSN (leave empty for local mock mode)", ""); dsn != "" {
+		a.DSN = dsn
+		a.GeneratorProvider = ask("LLM provider (anthropic|openai|azure-openai|gemini|ollama|mock)", "openai")
+		for err == nil && !containsString(config.GeneratorProviders, a.GeneratorProvider) {
+			fmt.Fprintf(p.out, "   Unknown provider %q\n", a.GeneratorProvider)
+			a.GeneratorProvider = ask("LLM provider (anthropic|openai|azure-openai|gemini|ollama|mock)", "openai")
+		}
+		defaults := initProviderDefaults[a.GeneratorProvider]
+		a.GeneratorModel = ask("Model", defaults.generator)
+		if a.GeneratorProvider == "azure-openai" {
+			// The deployment defaults to the model name
+			a.GeneratorBaseURL = ask("Azure OpenAI endpoint (https://{resource}.openai.azure.com)", "")
+		}
+		if a.GeneratorProvider != "mock" && a.GeneratorProvider != "ollama" {
+			a.GeneratorKeyEnv = ask("Environment variable holding the API key", defaults.keyEnv)
+		}
+
+		// Anthropic has no embeddings API; OpenAI embeddings need their own key
+		a.EmbedderProvider, a.EmbedderModel = "mock", "mock-embedding"
+		if a.GeneratorProvider != "mock" && yes(ask("Use OpenAI embeddings for documentation search? (y/n)", "y")) {
+			a.EmbedderProvider, a.EmbedderModel = "openai", initProviderDefaults["openai"].embedder
+			a.EmbedderKeyEnv = a.GeneratorKeyEnv
+			if a.GeneratorProvider != "openai" {
+				a.EmbedderKeyEnv = ask("Environment variable holding the OpenAI API key", "OPENAI_API_KEY")
+			}
+		}
+	}
+
+	a.Worker = yes(ask("Run the background worker (ingest and analyze on a schedule)? (y/n)", "n"))
+	return path, a, err
+}
+
+func yes(answer string) bool {
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// prompter asks questions on the command's input and output
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func (p *prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "❓ %s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "❓ %s: ", question)
+	}
+
+	line, err := p.in.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		if errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("input ended before all questions were answered")
+		}
+		return "", err
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer, nil
+	}
+	return def, nil
+}
+
+func renderInitConfig(a initAnswers) ([]byte, error) {
+	tmpl, err := template.New("config").Funcs(template.FuncMap{"quote": strconv.Quote}).Parse(initConfigTemplate)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, a); err != nil {
+		return nil, fmt.Errorf("failed to render config: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+const initConfigTemplate = `# Latentia agent configuration, generated by 'agent init'.
+# Every key can be overridden with LATENTIA_<SECTION>_<KEY> environment
+# variables, e.g. LATENTIA_DB_DSN. See deploy/config.yaml.sample for all keys.
+
+server:
+  addr: ":8080"
+
+db:
+  # TiDB connection; keep the password out of this file with password_file
+  dsn: {{quote .DSN}}
+  maxOpenConns: 10
+  # password_file: "/run/secrets/tidb_password"
+
+llm:
+  embedder:
+    provider: {{quote .EmbedderProvider}} # openai|azure-openai|cohere|voyage|ollama|mock
+    model: {{quote .EmbedderModel}}
+{{- if .EmbedderKeyEnv}}
+    api_key_env: {{quote .EmbedderKeyEnv}}
+{{- end}}
+  generator:
+    provider: {{quote .GeneratorProvider}} # anthropic|openai|azure-openai|gemini|ollama|mock
+    model: {{quote .GeneratorModel}}
+{{- if .GeneratorKeyEnv}}
+    api_key_env: {{quote .GeneratorKeyEnv}}
+{{- end}}
+{{- if .GeneratorBaseURL}}
+    base_url: {{quote .GeneratorBaseURL}}
+    # deployment: "my-deployment" # defaults to the model name
+{{- end}}
+
+safety:
+  # Upper bound for any statement the agent runs on your behalf
+  max_stmt_seconds: 10
+
+log:
+  level: "info" # debug|info|warn|error
+
+# Background jobs run by 'agent run' (and 'agent watch'); durations like
+# "15m" or 5-field cron expressions
+{{- if .Worker}}
+schedules:
+  ingest: "15m"
+  analyze: "30m"
+  track: "1h"
+{{- else}}
+schedules: {}
+  # ingest: "15m"
+  # analyze: "30m"
+  # track: "1h"
+{{- end}}
+`
@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matthieukhl/latentia/internal/config"
+)
+
+// inTempDir runs the rest of the test in an empty working directory, where
+// config.LoadConfig finds the config.yaml written by init
+func inTempDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("failed to enter %s: %v", dir, err)
+	}
+	t.Cleanup(func() {
+		if err := os.Chdir(wd); err != nil {
+			t.Errorf("failed to return to %s: %v", wd, err)
+		}
+	})
+	return dir
+}
+
+// execute runs the agent with args and input on stdin, resetting the flags
+// it sets afterwards since cobra keeps them between runs
+func execute(t *testing.T, input string, args ...string) error {
+	t.Helper()
+	t.Cleanup(func() {
+		initOutput, initNonInteractive, initForce = "config.yaml", false, false
+		profile = ""
+		rootCmd.SetArgs(nil)
+		rootCmd.SetIn(nil)
+		rootCmd.SetOut(nil)
+		rootCmd.SetErr(nil)
+	})
+	rootCmd.SetArgs(args)
+	rootCmd.SetIn(strings.NewReader(input))
+	rootCmd.SetOut(io.Discard)
+	rootCmd.SetErr(io.Discard)
+	return rootCmd.Execute()
+}
+
+// validate runs 'agent config validate' on the config.yaml of the working
+// directory and returns the loaded config
+func validate(t *testing.T) *config.Config {
+	t.Helper()
+	if err := execute(t, "", "config", "validate"); err != nil {
+		data, _ := os.ReadFile("config.yaml")
+		t.Fatalf("config validate: %v\n%s", err, data)
+	}
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	return cfg
+}
+
+func TestInitNonInteractiveThenValidate(t *testing.T) {
+	inTempDir(t)
+	if err := execute(t, "", "init", "--non-interactive"); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+
+	cfg := validate(t)
+	if cfg.LLM.Generator.Provider != "mock" || cfg.LLM.Embedder.Provider != "mock" {
+		t.Errorf("providers = %s/%s, want the mock ones", cfg.LLM.Generator.Provider, cfg.LLM.Embedder.Provider)
+	}
+	if len(cfg.Schedules) != 0 {
+		t.Errorf("schedules = %v, want the worker off", cfg.Schedules)
+	}
+	info, err := os.Stat("config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("config.yaml mode = %v, want 0600 for the DSN", perm)
+	}
+}
+
+func TestInitRefusesToOverwrite(t *testing.T) {
+	inTempDir(t)
+	if err := os.WriteFile("config.yaml", []byte("# mine\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := execute(t, "", "init", "--non-interactive")
+	if err == nil || err.Error() != "config.yaml already exists; use --force to overwrite it" {
+		t.Fatalf("init over an existing file = %v", err)
+	}
+	if data, _ := os.ReadFile("config.yaml"); string(data) != "# mine\n" {
+		t.Errorf("existing file changed to:\n%s", data)
+	}
+
+	if err := execute(t, "", "init", "--non-interactive", "--force"); err != nil {
+		t.Fatalf("init --force: %v", err)
+	}
+	validate(t)
+}
+
+func TestInitInteractiveThenValidate(t *testing.T) {
+	const dsn = "app@tcp(tidb.internal:4000)/shop?parseTime=true"
+	tests := []struct {
+		name string
+		// answers, one per line, in the order of the questions
+		answers      []string
+		generator    string
+		embedder     string
+		keyEnv       string
+		embedKeyEnv  string
+		baseURL      string
+		withSchedule bool
+	}{
+		{
+			"local mock mode",
+			[]string{"", "", "y"},
+			"mock", "mock", "", "", "", true,
+		},
+		{
+			"openai with its embeddings",
+			[]string{"", dsn, "openai", "", "", "y", "n"},
+			"openai", "openai", "OPENAI_API_KEY", "OPENAI_API_KEY", "", false,
+		},
+		{
+			"azure openai with openai embeddings",
+			[]string{"", dsn, "azure-openai", "gpt-4o", "https://shop.openai.azure.com", "", "yes", "", "y"},
+			"azure-openai", "openai", "AZURE_OPENAI_API_KEY", "OPENAI_API_KEY", "https://shop.openai.azure.com", true,
+		},
+		{
+			"anthropic with mock embeddings",
+			[]string{"", dsn, "anthropic", "", "MY_KEY", "n", "n"},
+			"anthropic", "mock", "MY_KEY", "", "", false,
+		},
+		{
+			"gemini after an unknown provider",
+			[]string{"", dsn, "bard", "gemini", "", "", "n", "n"},
+			"gemini", "mock", "GEMINI_API_KEY", "", "", false,
+		},
+		{
+			"ollama needs no key",
+			[]string{"", dsn, "ollama", "", "n", "y"},
+			"ollama", "mock", "", "", "", true,
+		},
+		{
+			"mock generator against a database",
+			[]string{"", dsn, "mock", "", "n"},
+			"mock", "mock", "", "", "", false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inTempDir(t)
+			if err := execute(t, strings.Join(tt.answers, "\n")+"\n", "init"); err != nil {
+				t.Fatalf("init: %v", err)
+			}
+			// As init asks, the keys are exported before the agent starts
+			for _, env := range []string{tt.keyEnv, tt.embedKeyEnv} {
+				if env != "" {
+					t.Setenv(env, "test-key")
+				}
+			}
+
+			cfg := validate(t)
+			g, e := cfg.LLM.Generator, cfg.LLM.Embedder
+			if g.Provider != tt.generator || e.Provider != tt.embedder {
+				t.Errorf("providers = %s/%s, want %s/%s", g.Provider, e.Provider, tt.generator, tt.embedder)
+			}
+			if g.APIKeyEnv != tt.keyEnv || e.APIKeyEnv != tt.embedKeyEnv {
+				t.Errorf("key variables = %q/%q, want %q/%q", g.APIKeyEnv, e.APIKeyEnv, tt.keyEnv, tt.embedKeyEnv)
+			}
+			if g.BaseURL != tt.baseURL {
+				t.Errorf("base URL = %q, want %q", g.BaseURL, tt.baseURL)
+			}
+			if g.Model == "" || e.Model == "" {
+				t.Errorf("models = %q/%q, want the defaults", g.Model, e.Model)
+			}
+			if wantDSN := tt.answers[1]; wantDSN != "" && cfg.DB.DSN != wantDSN {
+				t.Errorf("dsn = %q, want %q", cfg.DB.DSN, wantDSN)
+			}
+			if got := len(cfg.Schedules) > 0; got != tt.withSchedule {
+				t.Errorf("schedules = %v, want the worker on: %v", cfg.Schedules, tt.withSchedule)
+			}
+		})
+	}
+}
+
+func TestInitInteractiveCustomPath(t *testing.T) {
+	dir := inTempDir(t)
+	if err := execute(t, "conf/agent.yaml\n\nn\n", "init"); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "conf", "agent.yaml")); err != nil {
+		t.Errorf("config not written to the chosen path: %v", err)
+	}
+	if err := config.ValidateData(mustRead(t, filepath.Join(dir, "conf", "agent.yaml"))); err != nil {
+		t.Errorf("written config is invalid: %v", err)
+	}
+}
+
+func TestInitInputEndsEarly(t *testing.T) {
+	inTempDir(t)
+	err := execute(t, "\napp@tcp(db:4000)/x\n", "init")
+	if err == nil || err.Error() != "input ended before all questions were answered" {
+		t.Fatalf("init = %v", err)
+	}
+	if _, err := os.Stat("config.yaml"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("config written from partial answers: %v", err)
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
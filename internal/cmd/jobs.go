@@ -0,0 +1,456 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/analyze"
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/ingest"
+	"github.com/matthieukhl/latentia/internal/llm"
+	"github.com/matthieukhl/latentia/internal/logging"
+	"github.com/matthieukhl/latentia/internal/models"
+	"github.com/matthieukhl/latentia/internal/notify"
+	"github.com/matthieukhl/latentia/internal/rag"
+	"github.com/matthieukhl/latentia/internal/safety"
+	"github.com/matthieukhl/latentia/internal/schedule"
+	"github.com/matthieukhl/latentia/internal/worker"
+)
+
+// jobFactory builds the run function of a named background job
+type jobFactory func(cfg *config.Config, db *database.DB) (func(ctx context.Context) error, error)
+
+var jobFactories = map[string]jobFactory{
+	"ingest":    newIngestJob,
+	"analyze":   newAnalyzeJob,
+	"track":     newTrackJob,
+	"sync-docs": newSyncDocsJob,
+	"purge":     newPurgeJob,
+}
+
+// jobNames lists the known background jobs in a stable order
+func jobNames() []string {
+	names := make([]string, 0, len(jobFactories))
+	for name := range jobFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// buildRunner registers the given jobs using their configured schedules,
+// falling back to defaults for jobs without an entry under schedules
+func buildRunner(cfg *config.Config, db *database.DB, names []string, defaults map[string]schedule.Schedule) (*worker.Runner, error) {
+	runner := worker.NewRunner()
+	runner.OnComplete = notifyJobFailure
+
+	for _, name := range names {
+		factory, ok := jobFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown job %q (available: %s)", name, strings.Join(jobNames(), ", "))
+		}
+
+		sched := defaults[name]
+		if spec, ok := cfg.Schedules[name]; ok {
+			parsed, err := schedule.Parse(spec)
+			if err != nil {
+				return nil, fmt.Errorf("invalid schedules.%s: %w", name, err)
+			}
+			sched = parsed
+		}
+		if sched == nil {
+			return nil, fmt.Errorf("no schedule configured for job %q", name)
+		}
+
+		run, err := factory(cfg, db)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up job %q: %w", name, err)
+		}
+		if err := runner.Register(worker.Job{Name: name, Schedule: sched, Run: run}); err != nil {
+			return nil, err
+		}
+	}
+
+	return runner, nil
+}
+
+// notifyJobFailure publishes a job_failed event for failed runs
+func notifyJobFailure(name string, elapsed time.Duration, err error) {
+	if err == nil {
+		return
+	}
+	notify.Publish(notify.Event{
+		Type:    notify.JobFailed,
+		Job:     name,
+		Error:   err.Error(),
+		Details: map[string]any{"elapsed_seconds": elapsed.Seconds()},
+	})
+}
+
+// defaultSchedules are used by watch for jobs without an entry under schedules
+func defaultSchedules(cfg *config.Config) map[string]schedule.Schedule {
+	ingestInterval := cfg.Ingest.SlowQueryInterval
+	if ingestInterval <= 0 {
+		ingestInterval = 5 * time.Minute
+	}
+	purgeInterval := cfg.Retention.Interval
+	if purgeInterval <= 0 {
+		purgeInterval = 24 * time.Hour
+	}
+	// Analysis polls as often as slow queries arrive
+	return map[string]schedule.Schedule{
+		"ingest":    schedule.Every(ingestInterval),
+		"analyze":   schedule.Every(ingestInterval),
+		"track":     schedule.Every(time.Hour),
+		"sync-docs": schedule.Every(24 * time.Hour),
+		"purge":     schedule.Every(purgeInterval),
+	}
+}
+
+// serverSchedules are used by run for jobs without an entry under schedules:
+// ingestion every ingest.slowquery_interval and purging every
+// retention.interval, each unless its interval is 0
+func serverSchedules(cfg *config.Config) map[string]schedule.Schedule {
+	defaults := map[string]schedule.Schedule{}
+	if cfg.Ingest.SlowQueryInterval > 0 {
+		defaults["ingest"] = schedule.Every(cfg.Ingest.SlowQueryInterval)
+	}
+	if cfg.Retention.Interval > 0 {
+		defaults["purge"] = schedule.Every(cfg.Retention.Interval)
+	}
+	return defaults
+}
+
+// watchConfig applies hot-reloadable settings to the logger and, when given,
+// to the schedules of the runner's jobs. defaults may be nil.
+func watchConfig(cfg *config.Config, runner *worker.Runner, defaults func(*config.Config) map[string]schedule.Schedule) {
+	cfg.Watch(func(old, updated *config.Config) {
+		if logLevel == "" {
+			if err := logging.SetLevel(updated.Log.Level); err != nil {
+				slog.Error("failed to apply log level", "error", err)
+			}
+		}
+		if runner == nil {
+			return
+		}
+
+		fallback := map[string]schedule.Schedule{}
+		if defaults != nil {
+			fallback = defaults(updated)
+		}
+		for _, job := range runner.Jobs() {
+			sched := fallback[job.Name]
+			if spec, ok := updated.Schedules[job.Name]; ok {
+				// Already validated by the reload
+				sched, _ = schedule.Parse(spec)
+			}
+			if sched == nil {
+				slog.Warn("job schedule removed, keeping previous schedule until restart", "job", job.Name)
+				continue
+			}
+			if sched.String() == job.Schedule {
+				continue
+			}
+			if err := runner.Reschedule(job.Name, sched); err != nil {
+				slog.Error("failed to reschedule job", "job", job.Name, "error", err)
+				continue
+			}
+			slog.Info("job rescheduled", "job", job.Name, "schedule", sched.String())
+		}
+	})
+}
+
+// scheduledIngest is the ingest job built by newIngestJob, whose latest
+// run the server reports at /api/ingest/status
+var scheduledIngest *ingest.ScheduledIngest
+
+// newIngestJob pulls new entries from INFORMATION_SCHEMA.SLOW_QUERY of
+// every target
+func newIngestJob(cfg *config.Config, db *database.DB) (func(ctx context.Context) error, error) {
+	var ingesters []*ingest.SlowQueryIngester
+	for _, name := range db.TargetNames() {
+		tdb, err := db.ForTarget(name)
+		if err != nil {
+			return nil, err
+		}
+		ingesters = append(ingesters, ingest.NewSlowQueryIngester(tdb))
+	}
+	scheduledIngest = ingest.NewScheduledIngest(ingesters...)
+	return scheduledIngest.Run, nil
+}
+
+// newOptimizationEngine builds an engine with the configured LLM providers,
+// checking the stored embeddings match the embedder
+func newOptimizationEngine(cfg *config.Config, db *database.DB) (*analyze.OptimizationEngine, error) {
+	embedder, err := llm.NewEmbedder(&cfg.LLM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embedder: %w", err)
+	}
+	generator, err := llm.NewGenerator(&cfg.LLM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create generator: %w", err)
+	}
+
+	docStore := rag.NewDocumentStore(db, embedder)
+	if err := docStore.CheckDimension(context.Background()); err != nil {
+		return nil, err
+	}
+	return analyze.NewOptimizationEngine(db, docStore, generator), nil
+}
+
+// staleAnalysisAge is how long after its claim a slow query still analyzing
+// is taken as abandoned; far above the two minutes one analysis is given,
+// as optimize-pending claims a whole batch before running it
+const staleAnalysisAge = time.Hour
+
+// newAnalyzeJob runs the optimization engine over pending slow queries
+func newAnalyzeJob(cfg *config.Config, db *database.DB) (func(ctx context.Context) error, error) {
+	engine, err := newOptimizationEngine(cfg, db)
+	if err != nil {
+		return nil, err
+	}
+	ingester := ingest.NewSlowQueryIngester(db)
+	// Slow queries a crashed or killed process left analyzing would block
+	// their digests for good
+	reset, err := ingester.ResetStaleAnalyses(context.Background(), staleAnalysisAge)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reset stale analyses: %w", err)
+	}
+	if reset > 0 {
+		slog.Warn("stale analyses reset", "slow_queries", reset, "claimed_before", staleAnalysisAge)
+	}
+
+	return func(ctx context.Context) error {
+		// Yield the generator to API and CLI calls under llm.queue
+		ctx = llm.WithPriority(ctx, llm.PriorityBatch)
+		current := config.Current()
+		limit := current.Worker.AnalyzeBatchSize
+
+		// Stop producing rewrites until reviews catch up
+		if maxPending := current.Analysis.MaxPendingRewrites; maxPending > 0 {
+			pending, err := engine.CountPendingOptimizations(ctx)
+			if err != nil {
+				return err
+			}
+			if pending >= maxPending {
+				slog.InfoContext(ctx, "analysis paused: too many rewrites awaiting review", "pending", pending, "max_pending_rewrites", maxPending)
+				return nil
+			}
+			if maxPending-pending < limit {
+				limit = maxPending - pending
+			}
+		}
+
+		queries, err := ingester.GetSlowQueriesToAnalyze(ctx, current.Analysis.MinQueryTimeToAnalyze, limit)
+		if err != nil {
+			return fmt.Errorf("failed to get pending slow queries: %w", err)
+		}
+		// Suppressions added or edited since ingestion still apply
+		suppressions, err := db.ActiveSuppressions(ctx)
+		if err != nil {
+			return err
+		}
+
+		var failed int
+		for _, q := range queries {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			outcome, err := analyzeSlowQuery(ctx, engine, ingester, suppressions, q, current.Worker.AnalyzeMaxAttempts)
+			if err != nil {
+				return err
+			}
+			if outcome.Failed {
+				failed++
+			}
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d of %d slow queries failed to analyze", failed, len(queries))
+		}
+		return nil
+	}, nil
+}
+
+// analyzeOutcome is what became of one slow query analyzeSlowQuery ran
+type analyzeOutcome struct {
+	// Result is the stored rewrite; nil when the query was skipped or failed
+	Result *analyze.OptimizationResult
+	// Status is the slow query's status afterwards
+	Status string
+	// Failed is set when the analysis failed; Err says why the query failed
+	// or was skipped
+	Failed bool
+	Err    error
+}
+
+// analyzeSlowQuery optimizes one pending slow query and moves it to its next
+// status: skipped when suppressed or refused by the safety rules, among them
+// administrative and DDL statements, unsupported when its sample holds
+// several statements, back to pending (or skipped after maxAttempts) when
+// the analysis fails, pending without counting an attempt when the
+// provider's rate limit deferred it, completed
+// with the rewrite as its best one otherwise. The error is only set for
+// database failures and interruption, after which the caller should stop.
+func analyzeSlowQuery(ctx context.Context, engine *analyze.OptimizationEngine, ingester *ingest.SlowQueryIngester,
+	suppressions database.Suppressions, q models.SlowQuery, maxAttempts int) (analyzeOutcome, error) {
+	outcome, ok, err := beginAnalysis(ctx, ingester, suppressions, q)
+	if !ok || err != nil {
+		return outcome, err
+	}
+
+	// A shutdown lets the query finish within worker.shutdown_timeout
+	detached, cancelDetached := worker.Detach(ctx, config.Current().Worker.ShutdownTimeout)
+	queryCtx, cancel := context.WithTimeout(detached, 2*time.Minute)
+	result, err := engine.OptimizeQuery(queryCtx, q.ID, q.SampleSQL)
+	cancel()
+	cancelDetached()
+	return finishAnalysis(ctx, ingester, q, result, err, maxAttempts)
+}
+
+// beginAnalysis skips q when its digest is suppressed and otherwise claims
+// it, marking it analyzing; ok reports whether it should be optimized, false
+// as well when another worker or optimize-pending claimed it first
+func beginAnalysis(ctx context.Context, ingester *ingest.SlowQueryIngester, suppressions database.Suppressions,
+	q models.SlowQuery) (outcome analyzeOutcome, ok bool, err error) {
+	if suppression := suppressions.Match(q.Digest); suppression != nil {
+		slog.InfoContext(ctx, "slow query skipped: digest is suppressed", "slow_query_id", q.ID, "suppression_id", suppression.ID)
+		if err := ingester.SkipSlowQuery(ctx, q.ID, database.SuppressedPrefix+suppression.Reason); err != nil {
+			return analyzeOutcome{}, false, fmt.Errorf("failed to update slow query %d: %w", q.ID, err)
+		}
+		return analyzeOutcome{Status: models.StatusSkipped, Err: errors.New("digest is suppressed: " + suppression.Reason)}, false, nil
+	}
+	claimed, err := ingester.ClaimSlowQuery(ctx, q.ID)
+	if err != nil {
+		return analyzeOutcome{}, false, fmt.Errorf("failed to update slow query %d: %w", q.ID, err)
+	}
+	if !claimed {
+		slog.InfoContext(ctx, "slow query skipped: already claimed", "slow_query_id", q.ID)
+		return analyzeOutcome{Status: models.StatusAnalyzing, Err: errors.New("already claimed by another analysis")}, false, nil
+	}
+	return analyzeOutcome{}, true, nil
+}
+
+// finishAnalysis moves q, marked analyzing by beginAnalysis, to its next
+// status once OptimizeQuery returned result and err, as analyzeSlowQuery
+// describes
+func finishAnalysis(ctx context.Context, ingester *ingest.SlowQueryIngester, q models.SlowQuery,
+	result *analyze.OptimizationResult, err error, maxAttempts int) (analyzeOutcome, error) {
+	// q leaves analyzing even when ctx was cancelled by a shutdown
+	settleCtx := context.WithoutCancel(ctx)
+	switch {
+	case err != nil:
+	case result.Cached:
+		slog.InfoContext(ctx, "reused rewrite of the same digest", "rewrite_id", result.ID, "slow_query_id", q.ID)
+	case result.Status == "invalid":
+		slog.WarnContext(ctx, "rewrite stored as invalid", "rewrite_id", result.ID, "slow_query_id", q.ID, "error", result.ValidationError)
+	default:
+		slog.InfoContext(ctx, "rewrite stored", "rewrite_id", result.ID, "slow_query_id", q.ID, "digest", q.Digest,
+			"confidence", result.ConfidenceScore)
+	}
+
+	if violation, ok := safety.AsViolation(err); ok && safety.IsMultiStatement(violation) {
+		// Never retried: the sample will not become a single statement
+		slog.WarnContext(ctx, "slow query unsupported: several statements", "slow_query_id", q.ID)
+		if err := ingester.MarkUnsupported(settleCtx, q.ID, violation.Error()); err != nil {
+			return analyzeOutcome{}, fmt.Errorf("failed to update slow query %d: %w", q.ID, err)
+		}
+		return analyzeOutcome{Status: models.StatusUnsupported, Err: violation}, nil
+	}
+	if violation, ok := safety.AsViolation(err); ok {
+		slog.WarnContext(ctx, "slow query skipped by safety rules", "slow_query_id", q.ID, "code", violation.Code, "pattern", violation.Pattern)
+		if err := ingester.SkipSlowQuery(settleCtx, q.ID, violation.Error()); err != nil {
+			return analyzeOutcome{}, fmt.Errorf("failed to update slow query %d: %w", q.ID, err)
+		}
+		return analyzeOutcome{Status: models.StatusSkipped, Err: violation}, nil
+	}
+
+	if err != nil && ctx.Err() != nil {
+		// Interrupted by a shutdown rather than failed; a later run starts
+		// it over
+		if err := ingester.UpdateSlowQueryStatus(settleCtx, q.ID, "pending"); err != nil {
+			return analyzeOutcome{}, fmt.Errorf("failed to update slow query %d: %w", q.ID, err)
+		}
+		return analyzeOutcome{}, ctx.Err()
+	}
+	if errors.Is(err, llm.ErrRateLimited) {
+		// Nothing was sent: a later run retries it without counting an attempt
+		if err := ingester.UpdateSlowQueryStatus(settleCtx, q.ID, "pending"); err != nil {
+			return analyzeOutcome{}, fmt.Errorf("failed to update slow query %d: %w", q.ID, err)
+		}
+		slog.InfoContext(ctx, "slow query analysis deferred by the provider rate limit", "slow_query_id", q.ID, "error", err)
+		return analyzeOutcome{Status: models.StatusPending, Err: err}, nil
+	}
+	if err != nil {
+		// Put it back so a later run can retry, up to the attempt limit
+		skipped, recordErr := ingester.RecordAnalysisFailure(settleCtx, q.ID, maxAttempts, err)
+		if recordErr != nil {
+			return analyzeOutcome{}, fmt.Errorf("failed to update slow query %d: %w", q.ID, recordErr)
+		}
+		if skipped {
+			slog.WarnContext(ctx, "slow query skipped after repeated analysis failures", "slow_query_id", q.ID,
+				"attempts", maxAttempts, "error", err)
+			return analyzeOutcome{Status: models.StatusSkipped, Failed: true, Err: err}, nil
+		}
+		slog.WarnContext(ctx, "slow query analysis failed", "slow_query_id", q.ID, "error", err)
+		return analyzeOutcome{Status: models.StatusPending, Failed: true, Err: err}, nil
+	}
+
+	// An invalid rewrite completes the analysis but is nobody's best
+	var bestRewriteID int64
+	if result.Status != "invalid" {
+		bestRewriteID = result.ID
+	}
+	if err := ingester.CompleteSlowQuery(settleCtx, q.ID, bestRewriteID); err != nil {
+		return analyzeOutcome{}, fmt.Errorf("failed to update slow query %d: %w", q.ID, err)
+	}
+	return analyzeOutcome{Result: result, Status: models.StatusCompleted}, nil
+}
+
+// retentionPolicy converts the retention section into a purge policy
+func retentionPolicy(r config.RetentionConfig) database.RetentionPolicy {
+	return database.RetentionPolicy{
+		SlowQueriesOlderThan:      r.SlowQueries,
+		RejectedRewritesOlderThan: r.RejectedRewrites,
+		LLMUsageOlderThan:         r.LLMUsage,
+		BatchSize:                 r.BatchSize,
+	}
+}
+
+// newPurgeJob deletes the rows older than the retention section allows
+func newPurgeJob(cfg *config.Config, db *database.DB) (func(ctx context.Context) error, error) {
+	return func(ctx context.Context) error {
+		results, err := db.PurgeOldRecords(ctx, retentionPolicy(config.Current().Retention))
+		for _, res := range results {
+			if res.Skipped == "" {
+				slog.InfoContext(ctx, "old records purged", "table", res.Table, "cutoff", res.Cutoff, "deleted", res.Deleted)
+			}
+		}
+		return err
+	}, nil
+}
+
+// newTrackJob measures the realized improvement of accepted rewrites
+func newTrackJob(cfg *config.Config, db *database.DB) (func(ctx context.Context) error, error) {
+	// Tracking never calls the LLM, so the engine needs no providers
+	engine := analyze.NewOptimizationEngine(db, nil, nil)
+	return func(ctx context.Context) error {
+		analysis := config.Current().Analysis
+		summary, err := engine.TrackRealizedImprovements(ctx, analyze.TrackingPolicy{
+			Window:              analysis.TrackingWindow,
+			MinSamples:          analysis.TrackingMinSamples,
+			RegressionThreshold: analysis.RegressionThreshold,
+		})
+		if err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "realized improvements tracked", "rewrites", summary.Tracked, "regressed", len(summary.Regressed))
+		return nil
+	}, nil
+}
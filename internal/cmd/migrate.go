@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/llm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateTo     int
+	migrateStatus bool
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply pending app schema migrations",
+	Long: `Apply the app schema migrations not yet recorded in app_schema_migrations,
+up to --to or the latest. A database whose app tables were created before
+migrations were tracked is stamped with the baseline migration rather than
+having it run. Migrations are never reverted.
+
+Commands migrate the schema themselves when they start unless
+db.auto_migrate is off; with it off, only this command changes it.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return migrateSchema()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+
+	migrateCmd.Flags().IntVar(&migrateTo, "to", 0, "Version to migrate to (default the latest)")
+	migrateCmd.Flags().BoolVar(&migrateStatus, "status", false, "List the migrations and whether they are applied, without applying any")
+}
+
+func migrateSchema() error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if migrateStatus {
+		return printMigrations(ctx, db)
+	}
+
+	from, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	applied, err := db.Migrate(ctx, embeddingDim(cfg), migrateTo)
+	for _, m := range applied {
+		if m.Baseline {
+			fmt.Printf("   📌 %d %s: existing schema stamped\n", m.Version, m.Name)
+			continue
+		}
+		fmt.Printf("   ✅ %d %s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	to, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Printf("✅ Schema already at version %d\n", to)
+		return nil
+	}
+	fmt.Printf("✅ Schema migrated from version %d to %d\n", from, to)
+	return nil
+}
+
+func printMigrations(ctx context.Context, db *database.DB) error {
+	migrations, err := db.Migrations(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		switch {
+		case m.AppliedAt == nil:
+			fmt.Printf("   ⏳ %d %s: pending\n", m.Version, m.Name)
+		case m.Baseline:
+			fmt.Printf("   📌 %d %s: stamped %s\n", m.Version, m.Name, m.AppliedAt.Local().Format(time.DateTime))
+		default:
+			fmt.Printf("   ✅ %d %s: applied %s\n", m.Version, m.Name, m.AppliedAt.Local().Format(time.DateTime))
+		}
+	}
+	return nil
+}
+
+// migrateOnStart applies pending migrations before a long-running command
+// serves anything, unless db.auto_migrate is off, in which case it fails
+// while migrations are pending
+func migrateOnStart(ctx context.Context, cfg *config.Config, db *database.DB) error {
+	if !cfg.DB.AutoMigrate {
+		return db.UpgradeAppSchema(ctx)
+	}
+	applied, err := db.Migrate(ctx, embeddingDim(cfg), 0)
+	for _, m := range applied {
+		slog.InfoContext(ctx, "schema migration applied", "version", m.Version, "name", m.Name, "baseline", m.Baseline)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to migrate app schema: %w", err)
+	}
+	return nil
+}
+
+// embeddingDim is the dimension a new app_embeddings is created with: the
+// configured embedder's, as OpenAI models fix their own, else vector.dim
+func embeddingDim(cfg *config.Config) int {
+	if embedder, err := llm.NewEmbedder(&cfg.LLM); err == nil {
+		return embedder.Dim()
+	}
+	return cfg.Vector.Dim
+}
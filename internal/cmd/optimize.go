@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/matthieukhl/latentia/internal/analyze"
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/ingest"
+	"github.com/matthieukhl/latentia/internal/llm"
+	"github.com/matthieukhl/latentia/internal/models"
+	"github.com/matthieukhl/latentia/internal/rag"
+	"github.com/spf13/cobra"
+)
+
+var (
+	optimizeLimit        int
+	optimizeMinQueryTime float64
+	optimizeDB           string
+	optimizeDryRun       bool
+	optimizeForce        bool
+	optimizeConcurrency  int
+)
+
+var optimizePendingCmd = &cobra.Command{
+	Use:   "optimize-pending",
+	Short: "Optimize pending slow queries once",
+	Long: `Run the optimization engine over pending slow queries, slowest first, and
+print a summary. Each analyzed query is marked completed with its rewrite as
+best_rewrite_id; failures and skips are recorded as the analyze job does.
+
+--min-query-time defaults to analysis.min_query_time_to_analyze. With
+--dry-run only the detected query patterns are printed: the LLM is not
+called and nothing is written.
+
+A query whose digest has a pending or accepted rewrite younger than
+analysis.rewrite_cache_ttl gets that rewrite instead of a new one; --force
+calls the LLM anyway.
+
+--concurrency optimizes that many queries at once; LLM calls still respect
+llm.queue and the providers' requests_per_minute. Interrupting the command
+puts the queries not analyzed yet back to pending.`,
+	Args: cobra.NoArgs,
+	RunE: optimizePending,
+}
+
+func init() {
+	rootCmd.AddCommand(optimizePendingCmd)
+
+	optimizePendingCmd.Flags().IntVar(&optimizeLimit, "limit", 10, "Maximum number of slow queries to optimize")
+	optimizePendingCmd.Flags().Float64Var(&optimizeMinQueryTime, "min-query-time", -1, "Only optimize queries slower than this many seconds")
+	optimizePendingCmd.Flags().StringVar(&optimizeDB, "db", "", "Only optimize queries that ran in this database")
+	optimizePendingCmd.Flags().BoolVar(&optimizeDryRun, "dry-run", false, "Print detected patterns without calling the LLM")
+	optimizePendingCmd.Flags().BoolVar(&optimizeForce, "force", false, "Call the LLM even when the digest has a recent rewrite")
+	optimizePendingCmd.Flags().IntVar(&optimizeConcurrency, "concurrency", 1, "Number of slow queries optimized at once")
+}
+
+func optimizePending(cmd *cobra.Command, args []string) error {
+	if optimizeLimit <= 0 {
+		return fmt.Errorf("--limit must be positive")
+	}
+	if optimizeConcurrency <= 0 {
+		return fmt.Errorf("--concurrency must be positive")
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := db.UpgradeAppSchema(ctx); err != nil {
+		return fmt.Errorf("failed to upgrade app schema: %w", err)
+	}
+
+	minQueryTime := optimizeMinQueryTime
+	if minQueryTime < 0 {
+		minQueryTime = cfg.Analysis.MinQueryTimeToAnalyze
+	}
+
+	ingester := ingest.NewSlowQueryIngester(db)
+	var queries []models.SlowQuery
+	if optimizeDB != "" {
+		queries, err = ingester.GetSlowQueriesToAnalyzeInDB(ctx, optimizeDB, minQueryTime, optimizeLimit)
+	} else {
+		queries, err = ingester.GetSlowQueriesToAnalyze(ctx, minQueryTime, optimizeLimit)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get pending slow queries: %w", err)
+	}
+	if len(queries) == 0 {
+		fmt.Println("✅ No pending slow queries to optimize")
+		return nil
+	}
+
+	if optimizeDryRun {
+		printPatterns(queries)
+		return nil
+	}
+
+	embedder, err := llm.NewEmbedder(&cfg.LLM)
+	if err != nil {
+		return fmt.Errorf("failed to create embedder: %w", err)
+	}
+	generator, err := llm.NewGenerator(&cfg.LLM)
+	if err != nil {
+		return fmt.Errorf("failed to create generator: %w", err)
+	}
+	docStore := rag.NewDocumentStore(db, embedder)
+	if err := docStore.CheckDimension(ctx); err != nil {
+		return err
+	}
+	engine := analyze.NewOptimizationEngine(db, docStore, generator)
+
+	suppressions, err := db.ActiveSuppressions(ctx)
+	if err != nil {
+		return err
+	}
+
+	if optimizeForce {
+		ctx = analyze.WithoutRewriteCache(ctx)
+	}
+
+	fmt.Printf("🧠 Optimizing %d pending slow queries...\n\n", len(queries))
+	outcomes := make([]analyzeOutcome, len(queries))
+	var items []analyze.SlowQueryRef
+	var analyzed []int
+	for i, q := range queries {
+		outcome, ok, err := beginAnalysis(ctx, ingester, suppressions, q)
+		if err != nil {
+			return err
+		}
+		outcomes[i] = outcome
+		if ok {
+			items = append(items, analyze.SlowQueryRef{ID: q.ID, SQL: q.SampleSQL})
+			analyzed = append(analyzed, i)
+		}
+	}
+
+	// Every query marked analyzing is moved on, even after an interruption
+	var firstErr error
+	for j, batch := range engine.OptimizeBatch(ctx, items, optimizeConcurrency) {
+		i := analyzed[j]
+		outcome, err := finishAnalysis(ctx, ingester, queries[i], batch.Result, batch.Err, cfg.Worker.AnalyzeMaxAttempts)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			outcome = analyzeOutcome{Status: models.StatusPending, Failed: true, Err: err}
+		}
+		outcomes[i] = outcome
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tDIGEST\tQUERY TIME\tREWRITE\tCONFIDENCE\tSTATUS")
+	var completed, failed, skipped int
+	for i, q := range queries {
+		outcome := outcomes[i]
+		rewrite, confidence, status := "-", "-", outcome.Status
+		switch {
+		case outcome.Result != nil:
+			completed++
+			rewrite = fmt.Sprintf("#%d", outcome.Result.ID)
+			confidence = fmt.Sprintf("%.2f", outcome.Result.ConfidenceScore)
+			status = outcome.Result.Status
+			if outcome.Result.Cached {
+				status += " (cached)"
+			}
+		case outcome.Failed:
+			failed++
+			status = "failed: " + outcome.Err.Error()
+		default:
+			skipped++
+			status = "skipped: " + outcome.Err.Error()
+		}
+		fmt.Fprintf(w, "%d\t%s\t%.3fs\t%s\t%s\t%s\n", q.ID, shortDigest(q.Digest), q.QueryTime, rewrite, confidence, truncateText(status, 80))
+	}
+	w.Flush()
+	if firstErr != nil {
+		return firstErr
+	}
+
+	fmt.Printf("\n📊 %d optimized, %d failed, %d skipped\n", completed, failed, skipped)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d slow queries failed to optimize", failed, len(queries))
+	}
+	return nil
+}
+
+// printPatterns shows what the analyzer detects in each query, for --dry-run
+func printPatterns(queries []models.SlowQuery) {
+	analyzer := analyze.NewQueryAnalyzer()
+	fmt.Printf("🔍 Dry run: patterns of %d pending slow queries\n", len(queries))
+	for _, q := range queries {
+		pattern := analyzer.AnalyzeQuery(q.SampleSQL)
+		fmt.Printf("\n#%d %s (%.3fs)\n", q.ID, shortDigest(q.Digest), q.QueryTime)
+		fmt.Printf("   SQL:           %s\n", truncateText(strings.Join(strings.Fields(q.SampleSQL), " "), 100))
+		fmt.Printf("   Type:          %s (%s)\n", pattern.Type, pattern.Complexity)
+		fmt.Printf("   Tables:        %s\n", listOrNone(pattern.Tables))
+		findings := make([]string, len(pattern.Findings))
+		for i, finding := range pattern.Findings {
+			findings[i] = finding.Code + " (" + finding.Severity + ")"
+		}
+		fmt.Printf("   Anti-patterns: %s\n", listOrNone(findings))
+		fmt.Printf("   Opportunities: %s\n", listOrNone(pattern.OptimizationOps))
+	}
+}
+
+func shortDigest(digest string) string {
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
+}
+
+func listOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/analyze"
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/ingest"
+	"github.com/matthieukhl/latentia/internal/llm"
+	"github.com/matthieukhl/latentia/internal/rag"
+	"github.com/spf13/cobra"
+)
+
+var (
+	previewSlowQueryID int64
+	previewSQL         string
+	previewRetryOf     int64
+	previewFeedback    string
+	previewSearchOnly  bool
+)
+
+var promptPreviewCmd = &cobra.Command{
+	Use:   "prompt-preview",
+	Short: "Show the fully assembled optimization prompt without calling the LLM",
+	Long: `Run pattern analysis and RAG retrieval for a slow query and print the
+exact prompt that would be sent to the generator, split into its sections
+with an estimated token count for each. The prompt is assembled by the
+optimization engine itself, on the target the slow query was read from.
+
+--retry-of previews the prompt of agent review retry for a rejected or
+invalid rewrite, with --feedback as the reviewer's reason. Use --search-only
+to print just the documentation retrieved for the query.
+
+Nothing is written, and the app schema is not upgraded: run agent migrate
+first if the prompt needs tables it lacks, such as the identifier aliases of
+safety.anonymize_identifiers.`,
+	RunE: previewPrompt,
+}
+
+func init() {
+	rootCmd.AddCommand(promptPreviewCmd)
+
+	promptPreviewCmd.Flags().Int64Var(&previewSlowQueryID, "slow-query-id", 0, "ID of a row in app_slow_queries to preview")
+	promptPreviewCmd.Flags().StringVar(&previewSQL, "sql", "", "SQL text to preview instead of a stored slow query")
+	promptPreviewCmd.Flags().Int64Var(&previewRetryOf, "retry-of", 0, "ID of a rejected or invalid rewrite whose retry prompt to preview")
+	promptPreviewCmd.Flags().StringVar(&previewFeedback, "feedback", "", "Reviewer feedback shown with --retry-of")
+	promptPreviewCmd.Flags().BoolVar(&previewSearchOnly, "search-only", false, "Only print the RAG retrieval results")
+	promptPreviewCmd.MarkFlagsMutuallyExclusive("retry-of", "search-only")
+}
+
+func previewPrompt(cmd *cobra.Command, args []string) error {
+	sources := 0
+	for _, set := range []bool{previewSlowQueryID != 0, previewSQL != "", previewRetryOf != 0} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("exactly one of --slow-query-id, --sql or --retry-of is required")
+	}
+	if previewFeedback != "" && previewRetryOf == 0 {
+		return fmt.Errorf("--feedback needs --retry-of")
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	embedder, err := llm.NewEmbedder(&cfg.LLM)
+	if err != nil {
+		return fmt.Errorf("failed to create embedder: %w", err)
+	}
+	// The generator decides the response format the prompt asks for;
+	// creating it makes no request, and without one the prompt is the
+	// text-format one, which the configured provider may not be sent
+	generator, err := llm.NewGenerator(&cfg.LLM)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️  Previewing the text-format prompt: failed to create the %s generator: %v\n\n", cfg.LLM.Generator.Provider, err)
+		generator = nil
+	}
+	engine := analyze.NewOptimizationEngine(db, rag.NewDocumentStore(db, embedder), generator)
+
+	ctx := context.Background()
+	sql := previewSQL
+	if previewSlowQueryID != 0 {
+		slowQuery, err := ingest.NewSlowQueryIngester(db).GetSlowQueryByID(ctx, previewSlowQueryID)
+		if err != nil {
+			return fmt.Errorf("failed to load slow query: %w", err)
+		}
+		sql = slowQuery.SampleSQL
+	}
+
+	if previewSearchOnly {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		searchQuery, results, err := engine.PreviewRetrieval(ctx, previewSlowQueryID, sql)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("🔍 Search query: %s\n\n", searchQuery)
+		printSearchResults(results)
+		return nil
+	}
+
+	var prompt *analyze.Prompt
+	if previewRetryOf != 0 {
+		prompt, err = engine.PreviewRetryPrompt(ctx, previewRetryOf, previewFeedback)
+	} else {
+		prompt, err = engine.PreviewPrompt(ctx, previewSlowQueryID, sql)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to build prompt: %w", err)
+	}
+
+	for _, section := range prompt.Sections {
+		header := fmt.Sprintf("── [%s] ~%d tokens ", section.Name, analyze.EstimateTokens(section.Content))
+		fmt.Println(header + strings.Repeat("─", max(0, 80-len([]rune(header)))))
+		fmt.Print(section.Content)
+		if !strings.HasSuffix(section.Content, "\n") {
+			fmt.Println()
+		}
+	}
+	fmt.Println(strings.Repeat("─", 80))
+	fmt.Printf("📏 Total: %d characters, ~%d tokens across %d sections\n",
+		len(prompt.String()), prompt.EstimatedTokens(), len(prompt.Sections))
+	fmt.Printf("🧩 Templates: %s (%s), prompt version %s\n", prompt.Template, prompt.TemplateHash, prompt.Version)
+	if prompt.JSONMode {
+		fmt.Printf("🧾 Response format: JSON\n")
+	}
+	if prompt.Redaction != nil {
+		fmt.Printf("🔒 Redacted literals: %d\n", prompt.Redaction.Count())
+	}
+	if prompt.Anonymization != nil {
+		fmt.Printf("🎭 Anonymized identifiers: %d\n", prompt.Anonymization.Count())
+	}
+
+	return nil
+}
+
+func printSearchResults(results []rag.SearchResult) {
+	if len(results) == 0 {
+		fmt.Println("📭 No documentation matched the search query")
+		return
+	}
+
+	for i, result := range results {
+		fmt.Printf("   %d. [%.3f] %s - %s\n", i+1, result.Score, result.Document, result.Category)
+		fmt.Printf("      %s\n", truncateText(result.Text, 100))
+	}
+}
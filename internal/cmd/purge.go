@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/spf13/cobra"
+)
+
+// purgeNothingDeleted is the exit code when no rows matched the policy
+const purgeNothingDeleted = 3
+
+var (
+	purgeOlderThan           string
+	purgeSlowQueriesAge      string
+	purgeRejectedRewritesAge string
+	purgeLLMUsageAge         string
+	purgeDryRun              bool
+	purgeBatchSize           int
+)
+
+var purgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Delete old slow queries, rejected rewrites and LLM usage rows",
+	Long: `Apply the retention policy manually. Rows older than the given ages are
+deleted in bounded batches so no single transaction grows too large.
+
+Only completed, skipped and unsupported slow queries are purged, and those
+with an accepted rewrite, their own or linked by digest, are always kept.
+best_rewrite_id is cleared before the rewrite it references is removed.
+
+Ages default to the retention section of the config; --older-than sets the
+age of both slow queries and rejected rewrites. Ages accept Go durations
+plus day/week suffixes (e.g. 30d, 12w, 720h). An empty or zero age skips
+that table.
+
+Exit codes: 0 rows were deleted (or would be, with --dry-run),
+3 nothing matched, 1 an error occurred.`,
+	RunE: purgeOldRecords,
+}
+
+func init() {
+	rootCmd.AddCommand(purgeCmd)
+
+	purgeCmd.Flags().StringVar(&purgeOlderThan, "older-than", "", "Purge slow queries and rejected rewrites older than this age")
+	purgeCmd.Flags().StringVar(&purgeSlowQueriesAge, "slow-queries-older-than", "", "Purge slow queries older than this age (default retention.slow_queries)")
+	purgeCmd.Flags().StringVar(&purgeRejectedRewritesAge, "rejected-rewrites-older-than", "", "Purge rejected rewrites older than this age (default retention.rejected_rewrites)")
+	purgeCmd.Flags().StringVar(&purgeLLMUsageAge, "llm-usage-older-than", "", "Purge LLM usage aggregates older than this age (default retention.llm_usage)")
+	purgeCmd.Flags().BoolVar(&purgeDryRun, "dry-run", false, "Report what would be deleted without deleting")
+	purgeCmd.Flags().IntVar(&purgeBatchSize, "batch-size", 0, "Maximum rows deleted per transaction (default retention.batch_size)")
+	purgeCmd.MarkFlagsMutuallyExclusive("older-than", "slow-queries-older-than")
+	purgeCmd.MarkFlagsMutuallyExclusive("older-than", "rejected-rewrites-older-than")
+}
+
+func purgeOldRecords(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	policy := retentionPolicy(cfg.Retention)
+	policy.DryRun = purgeDryRun
+	policy.Progress = func(table string, deleted, total int64) {
+		fmt.Printf("   🗑️  %s: %d/%d deleted\n", table, deleted, total)
+	}
+
+	flags := cmd.Flags()
+	ages := []struct {
+		flag  string
+		value string
+		age   *time.Duration
+	}{
+		{"older-than", purgeOlderThan, &policy.SlowQueriesOlderThan},
+		{"older-than", purgeOlderThan, &policy.RejectedRewritesOlderThan},
+		{"slow-queries-older-than", purgeSlowQueriesAge, &policy.SlowQueriesOlderThan},
+		{"rejected-rewrites-older-than", purgeRejectedRewritesAge, &policy.RejectedRewritesOlderThan},
+		{"llm-usage-older-than", purgeLLMUsageAge, &policy.LLMUsageOlderThan},
+	}
+	for _, a := range ages {
+		if !flags.Changed(a.flag) {
+			continue
+		}
+		if *a.age, err = parseRetentionAge(a.flag, a.value); err != nil {
+			return err
+		}
+	}
+	if flags.Changed("batch-size") {
+		if purgeBatchSize <= 0 {
+			return fmt.Errorf("--batch-size must be positive")
+		}
+		policy.BatchSize = purgeBatchSize
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if purgeDryRun {
+		fmt.Println("🔍 Dry run: nothing will be deleted")
+	} else {
+		fmt.Printf("🧹 Purging old records in batches of %d...\n", policy.BatchSize)
+	}
+
+	results, err := db.PurgeOldRecords(ctx, policy)
+	printPurgeResults(results)
+	if err != nil {
+		return err
+	}
+
+	var affected int64
+	for _, res := range results {
+		if purgeDryRun {
+			affected += res.Matched
+		} else {
+			affected += res.Deleted
+		}
+	}
+
+	if affected == 0 {
+		fmt.Println("\n📭 Nothing to purge")
+		return exitWithCode(cmd, purgeNothingDeleted)
+	}
+
+	if purgeDryRun {
+		fmt.Printf("\n💡 %d rows would be deleted; re-run without --dry-run to purge\n", affected)
+	} else {
+		fmt.Printf("\n✅ Purged %d rows\n", affected)
+	}
+	return nil
+}
+
+func parseRetentionAge(flag, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	age, err := config.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("--%s: %w", flag, err)
+	}
+	return age, nil
+}
+
+func printPurgeResults(results []database.PurgeResult) {
+	fmt.Println()
+	for _, res := range results {
+		if res.Skipped != "" {
+			fmt.Printf("⏭️  %-18s skipped (%s)\n", res.Table, res.Skipped)
+			continue
+		}
+
+		if purgeDryRun {
+			fmt.Printf("📋 %-18s %d rows older than %s\n", res.Table, res.Matched, res.Cutoff)
+			for _, line := range res.Sample {
+				fmt.Printf("      %s\n", line)
+			}
+			if res.Matched > int64(len(res.Sample)) && len(res.Sample) > 0 {
+				fmt.Printf("      ... and %d more\n", res.Matched-int64(len(res.Sample)))
+			}
+			continue
+		}
+
+		fmt.Printf("📋 %-18s %d of %d matching rows deleted\n", res.Table, res.Deleted, res.Matched)
+	}
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/rag"
+	"github.com/spf13/cobra"
+)
+
+var reindexDocID int64
+
+var reindexDocsCmd = &cobra.Command{
+	Use:   "reindex-docs",
+	Short: "Rebuild the embeddings of stored documentation",
+	Long: `Chunk and embed stored documents again from their content, replacing
+their embeddings: every document, or only the one given by --doc-id.
+
+Each document is rebuilt in its own transaction, so one that fails keeps its
+previous embeddings and the others are still reindexed; the command fails at
+the end if any was not. Use it after changing vector.chunk_words or
+vector.chunk_overlap, or to repair documents left without embeddings.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return reindexDocs()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reindexDocsCmd)
+
+	reindexDocsCmd.Flags().Int64Var(&reindexDocID, "doc-id", 0, "Reindex only this document")
+	reindexDocsCmd.Flags().BoolVar(&noEmbeddingCache, "no-embedding-cache", false, "Embed every chunk with the provider, ignoring app_embedding_cache")
+}
+
+func reindexDocs() error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if err := db.UpgradeAppSchema(ctx); err != nil {
+		return fmt.Errorf("failed to upgrade schema: %w", err)
+	}
+
+	embedder, err := newDocEmbedder(cfg, db)
+	if err != nil {
+		return err
+	}
+	docStore := rag.NewDocumentStore(db, embedder)
+	if err := docStore.CheckDimension(ctx); err != nil {
+		return err
+	}
+
+	if reindexDocID != 0 {
+		chunks, err := docStore.ReindexDocument(ctx, reindexDocID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Reindexed document %d (%d chunk%s)\n", reindexDocID, chunks, plural(chunks))
+		return nil
+	}
+
+	docs, err := docStore.ListDocuments(ctx)
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		fmt.Println("📭 No documents to reindex; run seed-docs, add-doc or sync-docs first")
+		return nil
+	}
+
+	fmt.Printf("📚 Reindexing %d document%s...\n", len(docs), plural(len(docs)))
+	var failed, total int
+	for i, doc := range docs {
+		chunks, err := docStore.ReindexDocument(ctx, doc.ID)
+		if err != nil {
+			failed++
+			fmt.Printf("   [%d/%d] ❌ %v\n", i+1, len(docs), err)
+			continue
+		}
+		total += chunks
+		fmt.Printf("   [%d/%d] ✅ %d %s (%d chunk%s)\n", i+1, len(docs), doc.ID, doc.Title, chunks, plural(chunks))
+	}
+
+	fmt.Printf("\n%d reindexed into %d chunk%s, %d failed\n", len(docs)-failed, total, plural(total), failed)
+	printEmbeddingCache(embedder)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d document%s could not be reindexed", failed, len(docs), plural(len(docs)))
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/analyze"
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportSince   string
+	reportRecent  int
+	reportTracked int
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Summarize recent rewrites, time saved and review decisions",
+	Long: `Print the weekly report: rewrites created over the period by status, the
+realized improvement of accepted rewrites as measured by the track job, and
+the most recent review decisions from the audit log.
+
+Time saved is estimated from the occurrences ingested after acceptance.
+Rewrites with too few occurrences before or after acceptance are listed as
+inconclusive and left out of the total. Regressions are always listed.`,
+	RunE: printReport,
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+
+	reportCmd.Flags().StringVar(&reportSince, "since", "7d", "Report on this period (Go duration or day/week suffix)")
+	reportCmd.Flags().IntVar(&reportRecent, "decisions", 20, "Number of recent review decisions to show")
+	reportCmd.Flags().IntVar(&reportTracked, "top", 10, "Number of tracked rewrites to show, regressions excluded")
+}
+
+func printReport(cmd *cobra.Command, args []string) error {
+	period, err := config.ParseDuration(reportSince)
+	if err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+	since := time.Now().Add(-period)
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if err := db.UpgradeAppSchema(ctx); err != nil {
+		return fmt.Errorf("failed to upgrade schema: %w", err)
+	}
+
+	engine := analyze.NewOptimizationEngine(db, nil, nil)
+	counts, err := engine.CountOptimizationsByStatus(ctx, since)
+	if err != nil {
+		return err
+	}
+	realized, err := engine.GetRealizedStats(ctx)
+	if err != nil {
+		return err
+	}
+	entries, err := db.ListAudit(ctx, database.AuditFilter{Since: since, Limit: reportRecent})
+	if err != nil {
+		return err
+	}
+	suppressions, err := db.ActiveSuppressions(ctx)
+	if err != nil {
+		return err
+	}
+	suppressed, err := db.CountSuppressedSlowQueries(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("📊 Report for %s – %s\n\n", since.Format("2006-01-02 15:04"), time.Now().Format("2006-01-02 15:04"))
+
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	fmt.Printf("📝 Rewrites created: %d (%d pending, %d accepted, %d rejected, %d suppressed, %d invalid)\n\n",
+		total, counts["pending"], counts["accepted"], counts["rejected"], counts["suppressed"], counts["invalid"])
+	fmt.Printf("🔕 Suppressed: %d active suppressions, %d slow queries not analyzed\n\n", len(suppressions), suppressed)
+
+	fmt.Printf("⏱️  Realized improvement of accepted rewrites\n")
+	fmt.Printf("   Tracked: %d (%d improved, %d unchanged, %d regressed, %d inconclusive)\n",
+		realized.Tracked, realized.Improved, realized.Unchanged, realized.Regressed, realized.Inconclusive)
+	fmt.Printf("   Estimated time saved: %.1f minutes/day\n", realized.MinutesSavedPerDay)
+
+	shown := 0
+	for _, r := range realized.Rewrites {
+		if r.Status == analyze.RealizedRegressed || shown >= reportTracked {
+			continue
+		}
+		printTrackedRewrite("   ", r)
+		shown++
+	}
+	fmt.Println()
+
+	if realized.Regressed > 0 {
+		fmt.Printf("⚠️  Regressions (slower since acceptance)\n")
+		for _, r := range realized.Rewrites {
+			if r.Status == analyze.RealizedRegressed {
+				printTrackedRewrite("   ", r)
+			}
+		}
+		fmt.Println()
+	}
+
+	fmt.Printf("🧾 Recent review decisions\n")
+	if len(entries) == 0 {
+		fmt.Printf("   (none)\n")
+	}
+	for _, e := range entries {
+		line := fmt.Sprintf("   %s  %-8s rewrite %d by %s", e.CreatedAt.Format("2006-01-02 15:04"), e.Action, e.RewriteID, e.Actor)
+		if e.Reason != "" {
+			line += ": " + e.Reason
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func printTrackedRewrite(indent string, r analyze.TrackedRewrite) {
+	digest := r.Digest
+	if len(digest) > 12 {
+		digest = digest[:12]
+	}
+	saved := "inconclusive"
+	if r.MinutesSavedPerDay != nil {
+		saved = fmt.Sprintf("%+.1f min/day", *r.MinutesSavedPerDay)
+	}
+	fmt.Printf("%s#%-6d %-12s %-12s %.3fs → %.3fs (%d → %d samples)  %s\n",
+		indent, r.RewriteID, digest, r.Status, r.BeforeAvgTime, r.AfterAvgTime, r.BeforeSamples, r.AfterSamples, saved)
+}
@@ -0,0 +1,335 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/matthieukhl/latentia/internal/analyze"
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reviewReason   string
+	reviewReviewer string
+	showID         int64
+	showRaw        bool
+)
+
+var reviewCmd = &cobra.Command{
+	Use:   "review",
+	Short: "Accept or reject pending rewrites",
+	Long: `Review pending rewrites by ID. Every decision is written to the audit log
+in the same transaction as the status change, attributed to the operating
+system user running the command, who is also recorded as the reviewer of
+the rewrite unless --reviewer names someone else. Rejections need a reason,
+prompted for when --reason is not given.`,
+}
+
+var reviewShowCmd = &cobra.Command{
+	Use:   "show <rewrite-id>",
+	Short: "Show a rewrite and how it differs from the original query",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return showRewrite(args[0])
+	},
+}
+
+var showCmd = &cobra.Command{
+	Use:   "show --id <rewrite-id>",
+	Short: "Show a rewrite, like review show",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return showRewrite(strconv.FormatInt(showID, 10))
+	},
+}
+
+var reviewAcceptCmd = &cobra.Command{
+	Use:   "accept <rewrite-id>...",
+	Short: "Accept one or more pending rewrites",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return reviewRewrites(cmd, args, database.ActionAccept)
+	},
+}
+
+var reviewRejectCmd = &cobra.Command{
+	Use:   "reject <rewrite-id>...",
+	Short: "Reject one or more pending rewrites",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return reviewRewrites(cmd, args, database.ActionReject)
+	},
+}
+
+var reviewRetryCmd = &cobra.Command{
+	Use:     "retry <rewrite-id>",
+	Aliases: []string{"r!"},
+	Short:   "Reject a rewrite and ask the LLM for another one",
+	Long: `Reject a pending rewrite, or take one that was already rejected or is
+invalid, and generate a new rewrite of the same query. The LLM is shown the
+previous proposal and the reason, prompted for when --reason is not given.
+The new rewrite is linked to the old one, which stays rejected.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return retryRewrite(cmd, args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reviewCmd)
+	reviewCmd.AddCommand(reviewShowCmd)
+	rootCmd.AddCommand(showCmd)
+	reviewCmd.AddCommand(reviewAcceptCmd)
+	reviewCmd.AddCommand(reviewRejectCmd)
+	reviewCmd.AddCommand(reviewRetryCmd)
+
+	reviewCmd.PersistentFlags().StringVar(&reviewReason, "reason", "", "Reason recorded with the decision, required to reject")
+	reviewCmd.PersistentFlags().StringVar(&reviewReviewer, "reviewer", "", "Reviewer recorded with the decision, the operating system user by default")
+
+	for _, c := range []*cobra.Command{reviewShowCmd, showCmd} {
+		c.Flags().BoolVar(&showRaw, "raw", false, "Also print the prompt sent to the LLM and its raw completion")
+	}
+	showCmd.Flags().Int64Var(&showID, "id", 0, "Rewrite ID")
+	_ = showCmd.MarkFlagRequired("id")
+}
+
+func showRewrite(arg string) error {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || id <= 0 {
+		return fmt.Errorf("invalid rewrite ID '%s'", arg)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	if err := db.UpgradeAppSchema(context.Background()); err != nil {
+		return fmt.Errorf("failed to upgrade schema: %w", err)
+	}
+
+	engine := analyze.NewOptimizationEngine(db, nil, nil)
+	rewrite, err := engine.GetOptimizationByID(context.Background(), id)
+	if err != nil {
+		return fmt.Errorf("failed to get rewrite %d: %w", id, err)
+	}
+
+	confidence := fmt.Sprintf("confidence %.2f", rewrite.ConfidenceScore)
+	if rewrite.ConfidenceSource != "" {
+		confidence += " from " + rewrite.ConfidenceSource
+	}
+	fmt.Printf("🔎 Rewrite %d (%s, %s)\n", rewrite.ID, rewrite.Status, confidence)
+	if tokens := rewrite.PromptTokens + rewrite.CompletionTokens; tokens > 0 {
+		fmt.Printf("   %d tokens (%d prompt, %d completion), %s\n", tokens, rewrite.PromptTokens, rewrite.CompletionTokens, formatCost(rewrite.EstimatedCost))
+	}
+	if rewrite.PromptVersion != "" {
+		fmt.Printf("   Prompt version %s\n", rewrite.PromptVersion)
+	}
+	if rewrite.Rationale != "" {
+		fmt.Printf("\n%s\n", rewrite.Rationale)
+	}
+	if len(rewrite.SemanticsChanges) > 0 {
+		fmt.Printf("\n⚠️  May change results:\n")
+		for _, line := range rewrite.SemanticsChanges {
+			fmt.Printf("  • %s\n", line)
+		}
+	}
+
+	diff := rewrite.Diff
+	if diff == nil {
+		diff = analyze.DiffSQL(rewrite.OriginalSQL, rewrite.OptimizedSQL)
+	}
+	fmt.Printf("\n📋 Changes:\n")
+	if len(diff.Summary) == 0 {
+		fmt.Printf("  (no clause-level changes detected)\n")
+	}
+	for _, line := range diff.Summary {
+		fmt.Printf("  • %s\n", line)
+	}
+	if diff.Unified != "" {
+		fmt.Printf("\n%s", diff.Unified)
+	}
+
+	if rewrite.ValidationError != "" {
+		fmt.Printf("\n❌ Invalid: %s\n", rewrite.ValidationError)
+	}
+	fmt.Printf("\n🧭 Plan changes:\n")
+	switch {
+	case rewrite.PlanDiff == nil:
+		fmt.Printf("  (plans not available)\n")
+	case len(rewrite.PlanDiff.Summary) == 0:
+		fmt.Printf("  (same operators)\n")
+	}
+	if rewrite.PlanDiff != nil {
+		for _, line := range rewrite.PlanDiff.Summary {
+			fmt.Printf("  • %s\n", line)
+		}
+	}
+
+	if len(rewrite.IndexRecommendations) > 0 {
+		fmt.Printf("\n🗂️  Recommended indexes (create approved ones with apply-index):\n")
+		for _, index := range rewrite.IndexRecommendations {
+			stmt, err := index.CreateSQL()
+			if err != nil {
+				stmt = err.Error()
+			}
+			fmt.Printf("  #%d [%s] %s\n", index.ID, index.Status, stmt)
+		}
+	}
+
+	if showRaw {
+		if err := engine.LoadRawExchange(context.Background(), rewrite); err != nil {
+			return fmt.Errorf("failed to load the prompt of rewrite %d: %w", id, err)
+		}
+		if rewrite.PromptText == "" && rewrite.RawResponse == "" {
+			fmt.Printf("\n📝 No prompt stored (llm.store_raw was off or the rewrite predates it)\n")
+			return nil
+		}
+		fmt.Printf("\n📝 Prompt:\n%s\n", rewrite.PromptText)
+		fmt.Printf("\n💬 Raw response:\n%s\n", rewrite.RawResponse)
+	}
+	return nil
+}
+
+func reviewRewrites(cmd *cobra.Command, args []string, action string) error {
+	ids := make([]int64, len(args))
+	for i, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || id <= 0 {
+			return fmt.Errorf("invalid rewrite ID '%s'", arg)
+		}
+		ids[i] = id
+	}
+
+	reason, err := reviewReasonFor(cmd, action, len(ids))
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	if err := db.UpgradeAppSchema(context.Background()); err != nil {
+		return fmt.Errorf("failed to upgrade schema: %w", err)
+	}
+
+	// Reviews never call the LLM, so the engine needs no providers
+	engine := analyze.NewOptimizationEngine(db, nil, nil)
+	ctx := database.WithActor(context.Background(), database.CLIActor())
+
+	failed := 0
+	for _, id := range ids {
+		if action == database.ActionAccept {
+			err = engine.AcceptOptimization(ctx, id, reviewReviewer, reason)
+		} else {
+			err = engine.RejectOptimization(ctx, id, reviewReviewer, reason)
+		}
+		if err != nil {
+			fmt.Printf("❌ Rewrite %d: %v\n", id, err)
+			failed++
+			continue
+		}
+		fmt.Printf("✅ Rewrite %d %sed\n", id, action)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d rewrite%s could not be %sed", failed, len(ids), plural(len(ids)), action)
+	}
+	return nil
+}
+
+// reviewReasonFor returns --reason, asking for one when rejecting count
+// rewrites without it
+func reviewReasonFor(cmd *cobra.Command, action string, count int) (string, error) {
+	if reviewReason != "" || action != database.ActionReject {
+		return reviewReason, nil
+	}
+	question := "Why is this rewrite rejected"
+	if count > 1 {
+		question = "Why are these rewrites rejected"
+	}
+	p := &prompter{in: bufio.NewReader(cmd.InOrStdin()), out: cmd.OutOrStdout()}
+	reason, err := p.ask(question, "")
+	if err != nil {
+		return "", err
+	}
+	if reason == "" {
+		return "", analyze.ErrReasonRequired
+	}
+	return reason, nil
+}
+
+func retryRewrite(cmd *cobra.Command, arg string) error {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || id <= 0 {
+		return fmt.Errorf("invalid rewrite ID '%s'", arg)
+	}
+
+	reason, err := reviewReasonFor(cmd, database.ActionReject, 1)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	if err := db.UpgradeAppSchema(context.Background()); err != nil {
+		return fmt.Errorf("failed to upgrade schema: %w", err)
+	}
+
+	engine, err := newOptimizationEngine(cfg, db)
+	if err != nil {
+		return err
+	}
+	ctx := database.WithActor(context.Background(), database.CLIActor())
+
+	err = engine.RejectOptimization(ctx, id, reviewReviewer, reason)
+	switch {
+	case err == nil:
+		fmt.Printf("✅ Rewrite %d rejected\n", id)
+	case errors.Is(err, analyze.ErrNotPending):
+		// Already reviewed: ReOptimize refuses it unless it was rejected
+	default:
+		return fmt.Errorf("failed to reject rewrite %d: %w", id, err)
+	}
+
+	fmt.Printf("🤖 Asking for another rewrite...\n")
+	result, err := engine.ReOptimize(ctx, id, reason)
+	if err != nil {
+		return fmt.Errorf("failed to retry rewrite %d: %w", id, err)
+	}
+
+	fmt.Printf("✅ Rewrite %d (%s, confidence %.2f) replaces rewrite %d\n", result.ID, result.Status, result.ConfidenceScore, id)
+	if result.ValidationError != "" {
+		fmt.Printf("❌ Invalid: %s\n", result.ValidationError)
+	}
+	fmt.Printf("   Run 'agent review show %d' to see it\n", result.ID)
+	return nil
+}
@@ -1,9 +1,19 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"time"
 
+	"github.com/matthieukhl/latentia/internal/app"
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/logging"
+	"github.com/matthieukhl/latentia/internal/notify"
+	"github.com/matthieukhl/latentia/internal/tracing"
+	"github.com/matthieukhl/latentia/internal/worker"
 	"github.com/spf13/cobra"
 )
 
@@ -15,12 +25,95 @@ for slow queries and provides AI-powered optimization suggestions.
 
 The agent can run as a server to provide a web interface, or be used via 
 CLI commands to generate test data and analyze slow queries.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		config.SetProfile(profile)
+	},
+}
+
+// profile selects config.<profile>.yaml to merge over the base config
+var profile string
+
+// logLevel, when set, overrides log.level from config, including on reload
+var logLevel string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "Config profile merged over the base config (default $LATENTIA_PROFILE)")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "Log level overriding log.level from config (debug|info|warn|error)")
+}
+
+// setupLogging installs the shared slog pipeline from the log section of cfg
+func setupLogging(cfg *config.Config) error {
+	opts := cfg.Log.Options()
+	if logLevel != "" {
+		opts.Level = logLevel
+	}
+	return logging.Setup(opts)
+}
+
+// setupTracing starts the OTLP exporter when tracing.endpoint is set. The
+// returned function flushes pending spans and must be called before exiting.
+func setupTracing(cfg *config.Config) (func(), error) {
+	shutdown, err := tracing.Setup(cfg.Tracing.Options())
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			slog.Warn("failed to flush traces", "error", err)
+		}
+	}, nil
+}
+
+// registerFlushes makes lc send queued notifications and pending spans on
+// shutdown, after the components registered later have stopped
+func registerFlushes(lc *app.Lifecycle, stopTracing func()) {
+	lc.Register("tracing", nil, func(ctx context.Context) error {
+		stopTracing()
+		return nil
+	})
+	lc.Register("notifications", nil, func(ctx context.Context) error {
+		notify.Flush(5 * time.Second)
+		return nil
+	})
+}
+
+// registerRunner runs the scheduled jobs until shutdown, giving in-flight
+// runs grace to finish their current work once their context is cancelled,
+// and a little more to record what they could not finish
+func registerRunner(lc *app.Lifecycle, runner *worker.Runner, grace time.Duration) {
+	lc.Register("jobs", func(ctx context.Context) error {
+		runner.Run(ctx)
+		return nil
+	}, nil).StopTimeout = grace + 5*time.Second
+}
+
+// exitCodeError ends the process with a specific exit code without being
+// reported as a failure, for commands whose exit code carries meaning
+type exitCodeError struct {
+	code int
+}
+
+func (e *exitCodeError) Error() string {
+	return fmt.Sprintf("exit code %d", e.code)
+}
+
+// exitWithCode returns an error that makes Execute exit with code quietly
+func exitWithCode(cmd *cobra.Command, code int) error {
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+	return &exitCodeError{code: code}
 }
 
 // Execute runs the root command
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
-}
\ No newline at end of file
+}
@@ -1,11 +1,17 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 
+	"github.com/matthieukhl/latentia/internal/app"
 	"github.com/matthieukhl/latentia/internal/config"
 	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/llm"
+	"github.com/matthieukhl/latentia/internal/rag"
 	"github.com/matthieukhl/latentia/internal/server"
+	"github.com/matthieukhl/latentia/internal/worker"
 	"github.com/spf13/cobra"
 )
 
@@ -15,6 +21,8 @@ var runCmd = &cobra.Command{
 	Long: `Start the Latentia Agent server which provides:
 - REST API for slow query analysis
 - Web interface for reviewing optimization suggestions
+- Ingestion of slow queries every ingest.slowquery_interval (0 disables it)
+- Purging of old records every retention.interval (0 disables it)
 - Background processing of slow queries`,
 	RunE: runServer,
 }
@@ -32,22 +40,105 @@ func runServer(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 	
+	if err := setupLogging(cfg); err != nil {
+		return fmt.Errorf("failed to set up logging: %w", err)
+	}
+	stopTracing, err := setupTracing(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	
+	// Components stop in reverse order: the server stops accepting requests,
+	// then scheduled jobs drain, then notifications and spans are flushed and
+	// the database is closed last
+	lc := app.New()
+	defer lc.Close()
+	
 	fmt.Println("🔌 Connecting to database...")
 	db, err := database.NewConnection(&cfg.DB)
 	if err != nil {
+		stopTracing()
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
-	defer db.Close()
+	lc.Register("database", nil, app.CloseFunc(db.Close))
+	registerFlushes(lc, stopTracing)
+	
+	if err := migrateOnStart(lc.Context(), cfg, db); err != nil {
+		return err
+	}
 	
 	fmt.Println("✅ Database connected successfully")
 	
 	fmt.Println("⚙️  Setting up server...")
-	srv := server.NewServer(db)
+	analyzer, err := newOptimizationEngine(cfg, db)
+	if err != nil {
+		slog.Warn("ad-hoc analysis disabled", "error", err)
+	}
+	srv := server.NewServer(db, analyzer)
+	if docStore, err := newSearchStore(cfg, db); err != nil {
+		slog.Warn("document search disabled", "error", err)
+	} else {
+		srv.SetDocuments(docStore)
+	}
 	
-	fmt.Printf("🌐 Starting server on %s...\n", cfg.Server.Addr)
-	if err := srv.Start(cfg.Server.Addr); err != nil {
-		return fmt.Errorf("server failed: %w", err)
+	// Ingestion and purging run alongside the server at their configured
+	// intervals; other jobs only with an explicit schedule
+	var runner *worker.Runner
+	defaults := serverSchedules(cfg)
+	var names []string
+	for _, name := range jobNames() {
+		if _, ok := cfg.Schedules[name]; ok || defaults[name] != nil {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		runner, err = buildRunner(cfg, db, names, defaults)
+		if err != nil {
+			return err
+		}
+		srv.SetRunner(runner)
+		if scheduledIngest != nil {
+			srv.SetIngest(scheduledIngest)
+		}
+		registerRunner(lc, runner, cfg.Worker.ShutdownTimeout)
+		fmt.Printf("⏰ Scheduled %d background job%s\n", len(names), plural(len(names)))
 	}
 	
+	watchConfig(cfg, runner, serverSchedules)
+	
+	scheme := "http"
+	if cfg.Server.TLS.Enabled() {
+		scheme = "https"
+	}
+	lc.Register("server", func(ctx context.Context) error {
+		return srv.Start()
+	}, srv.Shutdown).StopTimeout = cfg.Server.ShutdownTimeout
+	
+	go func() {
+		<-lc.Context().Done()
+		fmt.Println("🛑 Shutting down...")
+	}()
+	
+	fmt.Printf("🌐 Starting server on %s (%s, base path %s/)...\n", cfg.Server.Addr, scheme, cfg.Server.Prefix())
+	// Only a component failure is an error; a signal stops the server cleanly
+	if err := lc.Run(); err != nil {
+		return err
+	}
+	
+	fmt.Println("👋 Server stopped")
 	return nil
-}
\ No newline at end of file
+}
+
+// newSearchStore returns the document store searched by /api/search, whose
+// embedder must match the dimension of app_embeddings
+func newSearchStore(cfg *config.Config, db *database.DB) (*rag.DocumentStore, error) {
+	embedder, err := llm.NewEmbedder(&cfg.LLM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embedder: %w", err)
+	}
+	docStore := rag.NewDocumentStore(db, embedder)
+	if err := docStore.CheckDimension(context.Background()); err != nil {
+		return nil, err
+	}
+	return docStore, nil
+}
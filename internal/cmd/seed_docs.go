@@ -8,7 +8,9 @@ import (
 	"github.com/matthieukhl/latentia/internal/config"
 	"github.com/matthieukhl/latentia/internal/database"
 	"github.com/matthieukhl/latentia/internal/llm"
+	"github.com/matthieukhl/latentia/internal/llm/embed"
 	"github.com/matthieukhl/latentia/internal/rag"
+	"github.com/matthieukhl/latentia/internal/types"
 	"github.com/spf13/cobra"
 )
 
@@ -19,12 +21,21 @@ var seedDocsCmd = &cobra.Command{
 content and generate vector embeddings for semantic search.
 
 This creates the knowledge base that the AI uses to provide context-aware
-optimization suggestions.`,
+optimization suggestions.
+
+With --recreate-embeddings the embeddings table is dropped and created again
+for the configured embedder's dimension before seeding, after switching to a
+model with another embedding size.`,
 	RunE: seedDocumentation,
 }
 
+var recreateEmbeddings bool
+
 func init() {
 	rootCmd.AddCommand(seedDocsCmd)
+	
+	seedDocsCmd.Flags().BoolVar(&recreateEmbeddings, "recreate-embeddings", false, "Drop and re-create app_embeddings for the embedder's dimension")
+	seedDocsCmd.Flags().BoolVar(&noEmbeddingCache, "no-embedding-cache", false, "Embed every chunk with the provider, ignoring app_embedding_cache")
 }
 
 func seedDocumentation(cmd *cobra.Command, args []string) error {
@@ -41,29 +52,42 @@ func seedDocumentation(cmd *cobra.Command, args []string) error {
 	}
 	defer db.Close()
 	
+	if err := db.UpgradeAppSchema(context.Background()); err != nil {
+		return fmt.Errorf("failed to upgrade schema: %w", err)
+	}
+	
 	fmt.Println("🔤 Initializing embedder...")
-	embedder, err := llm.NewEmbedder(&cfg.LLM)
+	embedder, err := newDocEmbedder(cfg, db)
 	if err != nil {
-		return fmt.Errorf("failed to create embedder: %w", err)
+		return err
 	}
 	
 	fmt.Printf("   Using %s/%s (dimension: %d)\n", 
 		cfg.LLM.Embedder.Provider, cfg.LLM.Embedder.Model, embedder.Dim())
 	
 	docStore := rag.NewDocumentStore(db, embedder)
+	if recreateEmbeddings {
+		fmt.Printf("🗑️  Re-creating app_embeddings as VECTOR(%d)...\n", embedder.Dim())
+		if err := db.RecreateEmbeddingsTable(context.Background(), embedder.Dim()); err != nil {
+			return err
+		}
+	} else if err := docStore.CheckDimension(context.Background()); err != nil {
+		return err
+	}
 	
 	fmt.Println("📝 Adding TiDB optimization documentation...")
-	err = docStore.SeedTiDBOptimizationDocs()
+	err = docStore.SeedTiDBOptimizationDocs(context.Background())
 	if err != nil {
 		return fmt.Errorf("failed to seed documentation: %w", err)
 	}
+	printEmbeddingCache(embedder)
 	
 	fmt.Println("🔍 Testing vector search...")
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 	
 	testQuery := "How to optimize slow JOIN queries?"
-	results, err := docStore.Search(ctx, testQuery, 3)
+	results, err := docStore.Search(ctx, testQuery, rag.NewSearchOptions(3))
 	if err != nil {
 		return fmt.Errorf("failed to test search: %w", err)
 	}
@@ -90,4 +114,33 @@ func truncateText(text string, maxLen int) string {
 		return text
 	}
 	return text[:maxLen] + "..."
-}
\ No newline at end of file
+}
+
+// noEmbeddingCache is the --no-embedding-cache flag of the commands that
+// index documents
+var noEmbeddingCache bool
+
+// newDocEmbedder creates the embedder of a command indexing documents; it
+// takes the embeddings of unchanged chunks from app_embedding_cache unless
+// vector.embedding_cache is off or --no-embedding-cache is given
+func newDocEmbedder(cfg *config.Config, db *database.DB) (types.Embedder, error) {
+	embedder, err := llm.NewEmbedder(&cfg.LLM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embedder: %w", err)
+	}
+	if !cfg.Vector.EmbeddingCache || noEmbeddingCache {
+		return embedder, nil
+	}
+	return embed.NewCachedEmbedder(embedder, db), nil
+}
+
+// printEmbeddingCache prints how many chunks embedder found in the cache
+func printEmbeddingCache(embedder types.Embedder) {
+	cached, ok := embedder.(*embed.CachedEmbedder)
+	if !ok {
+		return
+	}
+	if hits, misses := cached.Stats(); hits+misses > 0 {
+		fmt.Printf("💾 Embedding cache: %d chunk%s reused, %d embedded\n", hits, plural(int(hits)), misses)
+	}
+}
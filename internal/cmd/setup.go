@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/matthieukhl/latentia/internal/config"
@@ -19,6 +20,8 @@ var setupCmd = &cobra.Command{
 	Long: `Creates test tables (customers, orders, products, order_items) 
 and populates them with sample data for slow query testing.
 
+The app schema is migrated first, whatever db.auto_migrate says.
+
 This creates realistic e-commerce data that can be used to generate
 various types of slow queries for testing the optimization engine.`,
 	RunE: setupTestData,
@@ -48,14 +51,17 @@ func setupTestData(cmd *cobra.Command, args []string) error {
 	// Drop tables if requested
 	if dropFirst {
 		fmt.Println("🗑️  Dropping existing test tables...")
-		if err := db.DropTestSchema(); err != nil {
+		if err := db.DropTestSchema(context.Background()); err != nil {
 			return fmt.Errorf("failed to drop test schema: %w", err)
 		}
 	}
 	
 	// Create schema
 	fmt.Println("📋 Creating test schema...")
-	if err := db.SetupTestSchema(); err != nil {
+	if _, err := db.Migrate(context.Background(), embeddingDim(cfg), 0); err != nil {
+		return fmt.Errorf("failed to migrate app schema: %w", err)
+	}
+	if err := db.SetupTestSchema(context.Background()); err != nil {
 		return fmt.Errorf("failed to setup test schema: %w", err)
 	}
 	
@@ -111,7 +117,7 @@ func createCustomers(db *database.DB) error {
 	}
 	
 	for _, c := range customers {
-		_, err := db.Exec(`
+		_, err := db.ExecSafe(context.Background(), `
 			INSERT INTO customers (email, first_name, last_name, company, city, country, created_at)
 			VALUES (?, ?, ?, ?, ?, ?, DATE_SUB(NOW(), INTERVAL FLOOR(RAND() * 365) DAY))
 		`, c.email, c.firstName, c.lastName, c.company, c.city, c.country)
@@ -147,7 +153,7 @@ func createProducts(db *database.DB) error {
 	}
 	
 	for _, p := range products {
-		_, err := db.Exec(`
+		_, err := db.ExecSafe(context.Background(), `
 			INSERT INTO products (name, description, category, price, stock_qty, created_at)
 			VALUES (?, ?, ?, ?, ?, DATE_SUB(NOW(), INTERVAL FLOOR(RAND() * 180) DAY))
 		`, p.name, p.description, p.category, p.price, p.stockQty)
@@ -169,7 +175,7 @@ func createOrders(db *database.DB) error {
 		total := 50.0 + float64(i*10) // Varying order totals
 		notes := fmt.Sprintf("Order #%d - Customer requested special handling", i+1000)
 		
-		_, err := db.Exec(`
+		_, err := db.ExecSafe(context.Background(), `
 			INSERT INTO orders (customer_id, status, total, notes, created_at)
 			VALUES (?, ?, ?, ?, DATE_SUB(NOW(), INTERVAL FLOOR(RAND() * 90) DAY))
 		`, customerID, status, total, notes)
@@ -191,7 +197,7 @@ func createOrderItems(db *database.DB) error {
 			quantity := 1 + (item % 3)              // 1-3 quantity
 			price := 10.0 + float64(productID*5)    // Varying prices
 			
-			_, err := db.Exec(`
+			_, err := db.ExecSafe(context.Background(), `
 				INSERT INTO order_items (order_id, product_id, quantity, price)
 				VALUES (?, ?, ?, ?)
 			`, orderID, productID, quantity, price)
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/analyze"
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statsSince  string
+	statsOutput string
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Compare rewrite confidence scores to review decisions",
+	Long: `Print the confidence calibration report: the rewrites bucketed by
+confidence decile with their acceptance rate and average review latency,
+then the same figures for each anti-pattern of the original queries and
+each prompt version, to compare template changes and llm.prompt_style.
+
+  agent stats
+  agent stats --since 30d --output json
+
+A well calibrated scorer accepts more in the higher deciles. Invalid and
+suppressed rewrites were never reviewed and are left out; pending ones
+count in the totals but not in the acceptance rate. GET /api/stats serves
+the same report as its calibration.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printStats()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+
+	statsCmd.Flags().StringVar(&statsSince, "since", "", "Only rewrites created since this RFC 3339 time, date or duration ago")
+	statsCmd.Flags().StringVarP(&statsOutput, "output", "o", "text", "Output format: text or json")
+}
+
+func printStats() error {
+	if statsOutput != "text" && statsOutput != "json" {
+		return fmt.Errorf("invalid output '%s': must be text or json", statsOutput)
+	}
+	var since time.Time
+	if statsSince != "" {
+		var err error
+		if since, err = parseSince(statsSince); err != nil {
+			return fmt.Errorf("invalid --since: %w", err)
+		}
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if err := db.UpgradeAppSchema(ctx); err != nil {
+		return fmt.Errorf("failed to upgrade schema: %w", err)
+	}
+
+	engine := analyze.NewOptimizationEngine(db, nil, nil)
+	calibration, err := engine.ConfidenceCalibration(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	if statsOutput == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(calibration)
+	}
+
+	period := "all time"
+	if calibration.Since != nil {
+		period = "since " + calibration.Since.Format("2006-01-02 15:04")
+	}
+	fmt.Printf("🎯 Confidence calibration, %s\n", period)
+	fmt.Printf("   %d rewrite%s, %d accepted, %d rejected, acceptance %s\n\n",
+		calibration.Rewrites, plural(calibration.Rewrites), calibration.Accepted, calibration.Rejected, formatRate(calibration.AcceptanceRate))
+	if calibration.Rewrites == 0 {
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONFIDENCE\tREWRITES\tPENDING\tACCEPTED\tREJECTED\tACCEPTANCE\tREVIEW LATENCY")
+	for _, b := range calibration.Buckets {
+		fmt.Fprintf(w, "%.1f-%.1f\t%d\t%d\t%d\t%d\t%s\t%s\n", b.MinConfidence, b.MaxConfidence,
+			b.Rewrites, b.Pending, b.Accepted, b.Rejected, formatRate(b.AcceptanceRate), formatLatency(b.AvgReviewSeconds))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\n🧩 By anti-pattern\n")
+	if len(calibration.Patterns) == 0 {
+		fmt.Printf("   (none)\n")
+	} else {
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ANTI-PATTERN\tREWRITES\tACCEPTED\tREJECTED\tACCEPTANCE\tAVG CONFIDENCE\tREVIEW LATENCY")
+		for _, p := range calibration.Patterns {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t%.2f\t%s\n", p.Code, p.Rewrites, p.Accepted, p.Rejected,
+				formatRate(p.AcceptanceRate), p.AvgConfidence, formatLatency(p.AvgReviewSeconds))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("\n🧾 By prompt version\n")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROMPT VERSION\tREWRITES\tPENDING\tACCEPTED\tREJECTED\tACCEPTANCE\tAVG CONFIDENCE\tREVIEW LATENCY")
+	for _, v := range calibration.PromptVersions {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%.2f\t%s\n", promptVersionLabel(v.Version), v.Rewrites, v.Pending, v.Accepted, v.Rejected,
+			formatRate(v.AcceptanceRate), v.AvgConfidence, formatLatency(v.AvgReviewSeconds))
+	}
+	return w.Flush()
+}
+
+// promptVersionLabel names the empty version of rewrites generated before
+// prompt versions were recorded
+func promptVersionLabel(version string) string {
+	if version == "" {
+		return "(unversioned)"
+	}
+	return version
+}
+
+// formatRate prints a rate as a percentage, "-" when there is none
+func formatRate(rate *float64) string {
+	if rate == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f%%", *rate*100)
+}
+
+// formatLatency prints seconds as a duration rounded to the minute past an
+// hour, "-" when there is none
+func formatLatency(seconds *float64) string {
+	if seconds == nil {
+		return "-"
+	}
+	d := time.Duration(*seconds * float64(time.Second))
+	if d >= time.Hour {
+		return d.Round(time.Minute).String()
+	}
+	return d.Round(time.Second).String()
+}
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/spf13/cobra"
+)
+
+var (
+	suppressReason  string
+	suppressUntil   string
+	suppressPattern bool
+	suppressAll     bool
+)
+
+var suppressCmd = &cobra.Command{
+	Use:   "suppress <digest>",
+	Short: "Stop analyzing a query digest",
+	Long: `Suppress a digest that is known to be slow and accepted as is. Its slow
+queries are still ingested but skipped with a reason instead of being
+analyzed, and its pending rewrites are closed as suppressed. With --pattern
+the argument is a case-insensitive regular expression matched against the
+digest, like ingest.filters.exclude_digest_patterns.
+
+--until accepts an RFC 3339 time, a date (YYYY-MM-DD) or a duration from
+now such as 30d. Without it the suppression never expires.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return createSuppression(args[0])
+	},
+}
+
+var suppressListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List active suppressions",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listSuppressions()
+	},
+}
+
+var suppressRemoveCmd = &cobra.Command{
+	Use:   "remove <suppression-id>",
+	Short: "Remove a suppression so its digest is analyzed again",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return removeSuppression(args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(suppressCmd)
+	suppressCmd.AddCommand(suppressListCmd)
+	suppressCmd.AddCommand(suppressRemoveCmd)
+
+	suppressCmd.Flags().StringVar(&suppressReason, "reason", "", "Why the digest is suppressed (required)")
+	suppressCmd.Flags().StringVar(&suppressUntil, "until", "", "Expire the suppression at this time, date or duration from now")
+	suppressCmd.Flags().BoolVar(&suppressPattern, "pattern", false, "Treat the argument as a digest regular expression")
+	suppressCmd.MarkFlagRequired("reason")
+
+	suppressListCmd.Flags().BoolVar(&suppressAll, "all", false, "Include expired suppressions")
+}
+
+// parseUntil reads --until as an RFC 3339 time, a local date or a duration
+// from now
+func parseUntil(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	d, err := config.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected an RFC 3339 time, YYYY-MM-DD or a duration, got '%s'", value)
+	}
+	return time.Now().Add(d), nil
+}
+
+func openSuppressionDB() (*database.DB, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	if err := db.UpgradeAppSchema(context.Background()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to upgrade schema: %w", err)
+	}
+	return db, nil
+}
+
+func createSuppression(arg string) error {
+	s := database.Suppression{Reason: suppressReason}
+	if suppressPattern {
+		s.DigestPattern = arg
+	} else {
+		s.Digest = arg
+	}
+	if suppressUntil != "" {
+		until, err := parseUntil(suppressUntil)
+		if err != nil {
+			return fmt.Errorf("invalid --until: %w", err)
+		}
+		if !until.After(time.Now()) {
+			return fmt.Errorf("invalid --until: %s is in the past", until.Format(time.RFC3339))
+		}
+		s.ExpiresAt = &until
+	}
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	db, err := openSuppressionDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := database.WithActor(context.Background(), database.CLIActor())
+	created, err := db.CreateSuppression(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("🔕 Suppression %d created for %s\n", created.ID, describeSuppression(created))
+	if created.ExpiresAt != nil {
+		fmt.Printf("   Expires: %s\n", created.ExpiresAt.Local().Format("2006-01-02 15:04"))
+	}
+	if n := len(created.ClosedRewrites); n > 0 {
+		fmt.Printf("   Closed %d pending rewrite%s: %v\n", n, plural(n), created.ClosedRewrites)
+	}
+	return nil
+}
+
+func listSuppressions() error {
+	db, err := openSuppressionDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	suppressions, err := db.ListSuppressions(context.Background(), suppressAll)
+	if err != nil {
+		return err
+	}
+
+	if len(suppressions) == 0 {
+		fmt.Printf("🔕 No suppressions\n")
+		return nil
+	}
+
+	fmt.Printf("🔕 %d suppression%s\n", len(suppressions), plural(len(suppressions)))
+	for i := range suppressions {
+		s := &suppressions[i]
+		expires := "never"
+		if s.ExpiresAt != nil {
+			expires = s.ExpiresAt.Local().Format("2006-01-02 15:04")
+			if !s.ExpiresAt.After(time.Now()) {
+				expires += " (expired)"
+			}
+		}
+		fmt.Printf("   %-4d %s\n", s.ID, describeSuppression(s))
+		fmt.Printf("        by %s on %s, expires %s: %s\n",
+			s.CreatedBy, s.CreatedAt.Local().Format("2006-01-02 15:04"), expires, s.Reason)
+	}
+	return nil
+}
+
+func removeSuppression(arg string) error {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || id <= 0 {
+		return fmt.Errorf("invalid suppression ID '%s'", arg)
+	}
+
+	db, err := openSuppressionDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := database.WithActor(context.Background(), database.CLIActor())
+	if err := db.DeleteSuppression(ctx, id); err != nil {
+		if errors.Is(err, database.ErrSuppressionNotFound) {
+			return fmt.Errorf("suppression %d not found", id)
+		}
+		return err
+	}
+
+	fmt.Printf("✅ Suppression %d removed\n", id)
+	return nil
+}
+
+func describeSuppression(s *database.Suppression) string {
+	if s.DigestPattern != "" {
+		return fmt.Sprintf("digests matching /%s/", s.DigestPattern)
+	}
+	return "digest " + s.Digest
+}
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/rag"
+	"github.com/spf13/cobra"
+)
+
+// defaultDocCategory is the category of sources without one
+const defaultDocCategory = "docs"
+
+// errHostNotAllowed skips a source outside ingest.docs.allowed_hosts
+var errHostNotAllowed = errors.New("host is not in ingest.docs.allowed_hosts")
+
+var syncDocsConfirm bool
+
+var syncDocsCmd = &cobra.Command{
+	Use:   "sync-docs",
+	Short: "Fetch the documentation pages under ingest.docs.sources into the RAG store",
+	Long: `Download every page listed under ingest.docs.sources, strip HTML pages to
+their text, and chunk and embed them into the documentation store with their
+URL. Markdown and plain text pages are stored as is.
+
+Pages the server reports unchanged, through ETag or Last-Modified, are not
+downloaded again, and pages whose text did not change are not embedded again.
+
+Every new page is embedded, so sources on a host outside
+ingest.docs.allowed_hosts are skipped unless --confirm is given. A page that
+cannot be fetched is reported and the others are still synced; the command
+fails at the end if any was not.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return syncDocs()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(syncDocsCmd)
+
+	syncDocsCmd.Flags().BoolVar(&syncDocsConfirm, "confirm", false, "Also fetch sources outside ingest.docs.allowed_hosts")
+	syncDocsCmd.Flags().BoolVar(&noEmbeddingCache, "no-embedding-cache", false, "Embed every chunk with the provider, ignoring app_embedding_cache")
+}
+
+func syncDocs() error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	sources := cfg.Ingest.Docs.Sources
+	if len(sources) == 0 {
+		fmt.Println("📚 No documentation sources configured under ingest.docs.sources")
+		return nil
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if err := db.UpgradeAppSchema(ctx); err != nil {
+		return fmt.Errorf("failed to upgrade schema: %w", err)
+	}
+
+	embedder, err := newDocEmbedder(cfg, db)
+	if err != nil {
+		return err
+	}
+	docStore := rag.NewDocumentStore(db, embedder)
+	if err := docStore.CheckDimension(ctx); err != nil {
+		return err
+	}
+
+	fmt.Printf("📚 Syncing %d documentation source%s...\n", len(sources), plural(len(sources)))
+	summary := syncDocSources(ctx, docStore, cfg.Ingest.Docs, syncDocsConfirm, func(src config.SourceConfig, changed bool, err error) {
+		switch {
+		case errors.Is(err, errHostNotAllowed):
+			fmt.Printf("   ⚠️  %s skipped: %v (re-run with --confirm to fetch it)\n", src.URL, err)
+		case err != nil:
+			fmt.Printf("   ❌ %v\n", err)
+		case changed:
+			fmt.Printf("   ✅ %s\n", src.URL)
+		default:
+			fmt.Printf("   ⏭️  %s (unchanged)\n", src.URL)
+		}
+	})
+
+	fmt.Printf("\n%d added, %d unchanged, %d skipped, %d failed\n", summary.Added, summary.Unchanged, summary.Skipped, summary.Failed)
+	printEmbeddingCache(embedder)
+	if summary.Failed > 0 {
+		return fmt.Errorf("%d of %d source%s could not be synced", summary.Failed, len(sources), plural(len(sources)))
+	}
+	return nil
+}
+
+// docSyncSummary counts what became of the sources of a sync
+type docSyncSummary struct {
+	Added, Unchanged, Skipped, Failed int
+}
+
+// syncDocSources fetches the url sources of docs into docStore, calling
+// report with the outcome of each. Sources outside docs.AllowedHosts are
+// skipped with errHostNotAllowed unless confirm is set.
+func syncDocSources(ctx context.Context, docStore *rag.DocumentStore, docs config.DocsConfig, confirm bool,
+	report func(src config.SourceConfig, changed bool, err error)) docSyncSummary {
+	var summary docSyncSummary
+	for _, src := range docs.Sources {
+		if ctx.Err() != nil {
+			break
+		}
+		if !confirm && !docs.Allows(src.URL) {
+			summary.Skipped++
+			report(src, false, errHostNotAllowed)
+			continue
+		}
+
+		category := src.Category
+		if category == "" {
+			category = defaultDocCategory
+		}
+		changed, err := docStore.AddDocumentFromURL(ctx, src.URL, category)
+		switch {
+		case err != nil:
+			summary.Failed++
+		case changed:
+			summary.Added++
+		default:
+			summary.Unchanged++
+		}
+		report(src, changed, err)
+	}
+	return summary
+}
+
+// newSyncDocsJob refetches the documentation sources. It never confirms, so
+// sources outside ingest.docs.allowed_hosts are only synced by sync-docs
+// --confirm.
+func newSyncDocsJob(cfg *config.Config, db *database.DB) (func(ctx context.Context) error, error) {
+	embedder, err := newDocEmbedder(cfg, db)
+	if err != nil {
+		return nil, err
+	}
+	docStore := rag.NewDocumentStore(db, embedder)
+	if err := docStore.CheckDimension(context.Background()); err != nil {
+		return nil, err
+	}
+
+	// ingest.docs is only read at startup
+	docs := cfg.Ingest.Docs
+	return func(ctx context.Context) error {
+		summary := syncDocSources(ctx, docStore, docs, false, func(src config.SourceConfig, changed bool, err error) {
+			switch {
+			case errors.Is(err, errHostNotAllowed):
+				slog.WarnContext(ctx, "documentation source skipped", "url", src.URL, "error", err)
+			case err != nil:
+				slog.WarnContext(ctx, "documentation source failed to sync", "url", src.URL, "error", err)
+			}
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		slog.InfoContext(ctx, "documentation synced",
+			"added", summary.Added, "unchanged", summary.Unchanged, "skipped", summary.Skipped, "failed", summary.Failed)
+		if summary.Failed > 0 {
+			return fmt.Errorf("%d of %d documentation sources failed to sync", summary.Failed, len(docs.Sources))
+		}
+		return nil
+	}, nil
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/matthieukhl/latentia/internal/config"
 	"github.com/matthieukhl/latentia/internal/llm"
+	"github.com/matthieukhl/latentia/internal/types"
 	"github.com/spf13/cobra"
 )
 
@@ -68,16 +69,21 @@ ORDER BY o.created_at DESC;
 
 Respond with just the optimization suggestion in 1-2 sentences.`
 	
-	response, err := generator.Complete(ctx, testPrompt, map[string]any{
-		"max_tokens": 200,
-		"system":     "You are a concise SQL optimization expert.",
+	// Print tokens as they arrive; providers that cannot stream print the
+	// whole response at once
+	fmt.Print("   ✅ Generated response: ")
+	err = types.CompleteStream(ctx, generator, testPrompt, types.GenerationOptions{
+		MaxTokens: 200,
+		System:    "You are a concise SQL optimization expert.",
+	}, func(chunk string) error {
+		fmt.Print(chunk)
+		return nil
 	})
+	fmt.Println()
 	if err != nil {
 		return fmt.Errorf("failed to generate response: %w", err)
 	}
 	
-	fmt.Printf("   ✅ Generated response: %s\n", response)
-	
 	fmt.Println("\n🎉 All LLM providers are working correctly!")
 	return nil
 }
\ No newline at end of file
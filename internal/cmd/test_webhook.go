@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/notify"
+	"github.com/spf13/cobra"
+)
+
+var testWebhookCmd = &cobra.Command{
+	Use:   "test-webhook",
+	Short: "Send a test notification to the configured webhooks",
+	Long: `Post a sample rewrite_created notification to every URL under
+notify.slack and notify.webhook, once each and regardless of their events
+and min_confidence, and report which deliveries failed. Requests are signed
+as usual when a secret is set.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return testWebhooks()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(testWebhookCmd)
+}
+
+func testWebhooks() error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Println("🧪 Sending a test notification...")
+	deliveries := notify.SendTest(ctx, cfg.Notify)
+	if len(deliveries) == 0 {
+		return fmt.Errorf("no webhook configured: set notify.slack.webhook_url or notify.webhook.webhook_url")
+	}
+
+	failed := 0
+	for _, d := range deliveries {
+		if d.Err != nil {
+			failed++
+			fmt.Printf("   ❌ %s: %v\n", d.Notifier, d.Err)
+			continue
+		}
+		fmt.Printf("   ✅ %s\n", d.Notifier)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d deliveries failed", failed, len(deliveries))
+	}
+	fmt.Println("🎉 All webhooks accepted the notification!")
+	return nil
+}
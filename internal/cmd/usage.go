@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/spf13/cobra"
+)
+
+var usageDays int
+
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Show generator tokens and estimated cost per day",
+	Long: `Print the generator calls, tokens and estimated cost of each model per
+UTC day over the last --days days, today included, and their totals.
+
+Costs are estimated when each call is made, from the built-in price of the
+model or llm.generator.input_price_per_mtok and output_price_per_mtok when
+set. Models without a price, such as local ones, count tokens at no cost.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printUsage()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(usageCmd)
+
+	usageCmd.Flags().IntVar(&usageDays, "days", database.DefaultLLMUsageDays, fmt.Sprintf("Days to show (1-%d)", database.MaxLLMUsageDays))
+}
+
+func printUsage() error {
+	if usageDays < 1 || usageDays > database.MaxLLMUsageDays {
+		return fmt.Errorf("--days must be between 1 and %d, got %d", database.MaxLLMUsageDays, usageDays)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if err := db.UpgradeAppSchema(ctx); err != nil {
+		return fmt.Errorf("failed to upgrade schema: %w", err)
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, 1-usageDays)
+	usage, err := db.ListLLMUsage(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("💰 Generator usage since %s (UTC)\n", since.Format(time.DateOnly))
+	if len(usage) == 0 {
+		fmt.Println("   No generator calls recorded")
+		return nil
+	}
+
+	fmt.Printf("\n%-10s %-28s %8s %12s %12s %10s\n", "date", "model", "calls", "prompt", "completion", "cost")
+	for _, u := range usage {
+		fmt.Printf("%-10s %-28s %8d %12d %12d %10s\n", u.Date, u.Model, u.Requests, u.PromptTokens, u.CompletionTokens, formatCost(u.EstimatedCost))
+	}
+	total := database.TotalLLMUsage(usage)
+	fmt.Printf("%-10s %-28s %8d %12d %12d %10s\n", "total", "", total.Requests, total.PromptTokens, total.CompletionTokens, formatCost(total.EstimatedCost))
+	return nil
+}
+
+// formatCost prints US dollars with cents, or more digits below a cent
+func formatCost(usd float64) string {
+	if usd > 0 && usd < 0.01 {
+		return fmt.Sprintf("$%.4f", usd)
+	}
+	return fmt.Sprintf("$%.2f", usd)
+}
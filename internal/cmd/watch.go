@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/app"
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/spf13/cobra"
+)
+
+var watchJobs []string
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Run background ingestion and analysis jobs on a schedule",
+	Long: `Run the background jobs in the foreground until interrupted.
+
+Each job uses the expression configured for it under the schedules section
+of the config file. An expression is either a Go duration ("15m",
+"@every 1h") or a five-field cron spec ("*/30 9-17 * * mon-fri", "@daily").
+Without a schedule, ingest runs every ingest.slowquery_interval, analyze
+every 5 minutes, track, which measures accepted rewrites, every hour and
+purge every retention.interval.
+
+Edits to the config file are picked up without a restart for the log level,
+worker limits, schedules, scoring weights and safety rules.`,
+	RunE: watch,
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+
+	watchCmd.Flags().StringSliceVar(&watchJobs, "jobs", []string{"ingest", "analyze", "track"}, "Jobs to run")
+}
+
+func watch(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewConnection(&cfg.DB)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	lc := app.New()
+	defer lc.Close()
+	lc.Register("database", nil, app.CloseFunc(db.Close))
+
+	if err := migrateOnStart(lc.Context(), cfg, db); err != nil {
+		return err
+	}
+
+	if err := setupLogging(cfg); err != nil {
+		return fmt.Errorf("failed to set up logging: %w", err)
+	}
+	stopTracing, err := setupTracing(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	registerFlushes(lc, stopTracing)
+
+	runner, err := buildRunner(cfg, db, watchJobs, defaultSchedules(cfg))
+	if err != nil {
+		return err
+	}
+	watchConfig(cfg, runner, defaultSchedules)
+
+	runner.OnComplete = func(name string, elapsed time.Duration, err error) {
+		notifyJobFailure(name, elapsed, err)
+		if err != nil {
+			fmt.Printf("❌ %s failed after %v: %v\n", name, elapsed.Round(time.Millisecond), err)
+			return
+		}
+		fmt.Printf("✅ %s finished in %v\n", name, elapsed.Round(time.Millisecond))
+	}
+
+	fmt.Println("👀 Watching for slow queries...")
+	for _, job := range runner.Jobs() {
+		fmt.Printf("   ⏰ %-8s %-24s next run %s\n", job.Name, job.Schedule, formatNextRun(job.NextRun))
+	}
+
+	registerRunner(lc, runner, cfg.Worker.ShutdownTimeout)
+	if err := lc.Run(); err != nil {
+		return err
+	}
+	fmt.Println("\n👋 Stopped watching")
+	return nil
+}
+
+func formatNextRun(next *time.Time) string {
+	if next == nil {
+		return "never"
+	}
+	return next.Format("2006-01-02 15:04:05")
+}
@@ -1,33 +1,276 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/matthieukhl/latentia/internal/logging"
+	"github.com/matthieukhl/latentia/internal/tracing"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Server ServerConfig `mapstructure:"server"`
-	DB     DBConfig     `mapstructure:"db"`
-	LLM    LLMConfig    `mapstructure:"llm"`
-	Ingest IngestConfig `mapstructure:"ingest"`
-	Safety SafetyConfig `mapstructure:"safety"`
-	Vector VectorConfig `mapstructure:"vector"`
+	Server  ServerConfig  `mapstructure:"server"`
+	DB      DBConfig      `mapstructure:"db"`
+	LLM     LLMConfig     `mapstructure:"llm"`
+	Ingest  IngestConfig  `mapstructure:"ingest"`
+	Safety  SafetyConfig  `mapstructure:"safety"`
+	Vector  VectorConfig  `mapstructure:"vector"`
+	Log     LogConfig     `mapstructure:"log"`
+	Worker  WorkerConfig  `mapstructure:"worker"`
+	Scoring ScoringConfig `mapstructure:"scoring"`
+
+	Analysis  AnalysisConfig  `mapstructure:"analysis"`
+	Retention RetentionConfig `mapstructure:"retention"`
+	Tracing   TracingConfig   `mapstructure:"tracing"`
+	Notify    NotifyConfig    `mapstructure:"notify"`
+
+	// Schedules maps a background job name to a duration or cron expression
+	Schedules map[string]string `mapstructure:"schedules"`
+
+	// file is the config file that was read, empty when running on defaults
+	file        string
+	profile     string
+	profileFile string
+
+	// sources maps leaf keys to where their value came from, see Source
+	sources map[string]string
+}
+
+// File returns the path of the base config file that was loaded, or "" when
+// no file was found and the config comes from defaults and environment only
+func (c *Config) File() string {
+	return c.file
+}
+
+// Profile returns the active profile name, "" when none is selected
+func (c *Config) Profile() string {
+	return c.profile
+}
+
+// ProfileFile returns the profile file merged over the base file, if any
+func (c *Config) ProfileFile() string {
+	return c.profileFile
 }
 
 type ServerConfig struct {
 	Addr string `mapstructure:"addr"`
+
+	// BasePath mounts every route under a prefix, e.g. "/latentia" behind
+	// an ingress
+	BasePath string `mapstructure:"base_path"`
+
+	ReadTimeout  time.Duration `mapstructure:"read_timeout"`
+	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+	IdleTimeout  time.Duration `mapstructure:"idle_timeout"`
+
+	// AnalyzeTimeout bounds the analysis of SQL submitted to POST
+	// /api/analyze, LLM call included; it must leave time within
+	// WriteTimeout to write the response
+	AnalyzeTimeout time.Duration `mapstructure:"analyze_timeout"`
+
+	// ShutdownTimeout is how long in-flight requests may take to finish
+	// once the process is told to stop
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+
+	TLS TLSConfig `mapstructure:"tls"`
+
+	// APIKeys are accepted as bearer tokens with the admin role, named in
+	// logs by a fingerprint. Protected endpoints are disabled while neither
+	// this nor auth.keys has an entry.
+	APIKeys []string `mapstructure:"api_keys"`
+
+	Auth AuthConfig `mapstructure:"auth"`
+
+	// UI serves the embedded review app under /ui
+	UI bool `mapstructure:"ui"`
+}
+
+// AuthConfig assigns names and roles to API keys
+type AuthConfig struct {
+	Keys []APIKeyConfig `mapstructure:"keys"`
+}
+
+// API key roles, each allowed everything the previous one is
+const (
+	RoleViewer   = "viewer"   // read-only endpoints
+	RoleReviewer = "reviewer" // plus accepting and rejecting rewrites
+	RoleAdmin    = "admin"    // plus configuration and destructive actions
+)
+
+var APIKeyRoles = []string{RoleViewer, RoleReviewer, RoleAdmin}
+
+// APIKeyConfig is a named bearer token. Like provider keys it is read with
+// precedence api_key > api_key_file > api_key_env.
+type APIKeyConfig struct {
+	Name       string `mapstructure:"name"`
+	Role       string `mapstructure:"role"`
+	APIKey     string `mapstructure:"api_key"`
+	APIKeyFile string `mapstructure:"api_key_file"`
+	APIKeyEnv  string `mapstructure:"api_key_env"`
+
+	resolvedKey string
+}
+
+// TLSConfig enables HTTPS when both files are set
+type TLSConfig struct {
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+}
+
+// Enabled reports whether the server should serve HTTPS
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// Prefix returns BasePath without its trailing slash, "" for the root
+func (s ServerConfig) Prefix() string {
+	return strings.TrimRight(s.BasePath, "/")
 }
 
 type DBConfig struct {
 	DSN          string `mapstructure:"dsn"`
 	MaxOpenConns int    `mapstructure:"maxOpenConns"`
+
+	// MaxIdleConns connections stay open between statements, and each is
+	// replaced once ConnMaxLifetime old; 0 keeps them regardless of age
+	MaxIdleConns    int           `mapstructure:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `mapstructure:"conn_max_lifetime"`
+
+	// ConnectRetries further attempts are made when the database cannot be
+	// reached at startup, waiting ConnectBackoff, doubled after each one
+	ConnectRetries int           `mapstructure:"connect_retries"`
+	ConnectBackoff time.Duration `mapstructure:"connect_backoff"`
+
+	TLS DBTLSConfig `mapstructure:"tls"`
+
+	// AppDSN holds the app_* tables and TargetDSN is the database whose
+	// queries are analyzed: EXPLAIN, benchmarks, table definitions and
+	// INFORMATION_SCHEMA.SLOW_QUERY. Each defaults to DSN.
+	AppDSN    string `mapstructure:"app_dsn"`
+	TargetDSN string `mapstructure:"target_dsn"`
+
+	// Targets replaces TargetDSN with several named databases analyzed by
+	// one agent; the app tables stay in AppDSN. Slow queries are tagged
+	// with the name of the target they were read from.
+	Targets []TargetConfig `mapstructure:"targets"`
+
+	// ReadOnly refuses every statement on the target database that is not
+	// read-only, such as applying an index or creating the demo tables
+	ReadOnly bool `mapstructure:"read_only"`
+
+	// PasswordFile, when set, replaces the password with the contents of a
+	// mounted secret file in every DSN, those of Targets included, whose
+	// user is that of AppDataSource
+	PasswordFile string `mapstructure:"password_file"`
+
+	// AutoMigrate applies pending schema migrations when a command starts;
+	// when off, only "agent migrate" changes the schema
+	AutoMigrate bool `mapstructure:"auto_migrate"`
+}
+
+// DefaultTarget names the target of db.target_dsn, and of slow queries
+// recorded before db.targets existed
+const DefaultTarget = "default"
+
+// TargetConfig is one analyzed database of db.targets
+type TargetConfig struct {
+	Name string `mapstructure:"name"`
+	DSN  string `mapstructure:"dsn"`
+
+	// Enabled defaults to true; a disabled target is neither connected,
+	// ingested nor analyzed, but its rows stay listed
+	Enabled *bool `mapstructure:"enabled"`
+}
+
+// IsEnabled reports whether the target is connected and analyzed
+func (t TargetConfig) IsEnabled() bool {
+	return t.Enabled == nil || *t.Enabled
+}
+
+// DBTLSConfig secures the connections to every DSN. When set, it replaces
+// the tls parameter of the DSNs.
+type DBTLSConfig struct {
+	// CAFile is a PEM bundle verifying the server instead of the system
+	// roots
+	CAFile string `mapstructure:"ca_file"`
+
+	// ServerName is the name the certificate must carry, by default the
+	// host of the DSN
+	ServerName string `mapstructure:"server_name"`
+
+	// SkipVerify accepts any certificate; the connection is encrypted but
+	// not authenticated
+	SkipVerify bool `mapstructure:"skip_verify"`
+}
+
+// Enabled reports whether any TLS setting is set
+func (t DBTLSConfig) Enabled() bool {
+	return t.CAFile != "" || t.ServerName != "" || t.SkipVerify
 }
 
 type LLMConfig struct {
 	Embedder  ProviderConfig `mapstructure:"embedder"`
 	Generator ProviderConfig `mapstructure:"generator"`
+
+	// TemplatesDir holds *.tmpl files overriding the embedded prompt
+	// templates; see internal/prompts
+	TemplatesDir string `mapstructure:"templates_dir"`
+
+	// PromptStyle is "detailed", or "concise" for the shorter _concise
+	// sections on queries of simple complexity
+	PromptStyle string `mapstructure:"prompt_style"`
+
+	Queue  LLMQueueConfig     `mapstructure:"queue"`
+	Schema PromptSchemaConfig `mapstructure:"schema"`
+
+	// StoreRaw keeps the prompt as sent and the completion as received on
+	// each rewrite, each cut to StoreRawMaxBytes
+	StoreRaw         bool `mapstructure:"store_raw"`
+	StoreRawMaxBytes int  `mapstructure:"store_raw_max_bytes"`
+
+	Mock MockConfig `mapstructure:"mock"`
+}
+
+// MockConfig makes the mock generator slow or failing, to exercise
+// timeouts, retries and the handling of bad responses without a provider.
+// Script is the outcome of the first calls; later ones fail with Failure at
+// FailureRate.
+type MockConfig struct {
+	Latency     time.Duration `mapstructure:"latency"`
+	FailureRate float64       `mapstructure:"failure_rate"`
+	Failure     string        `mapstructure:"failure"`
+	Script      []string      `mapstructure:"script"`
+}
+
+// PromptSchemaConfig sets the table definitions, read from
+// information_schema, that optimization prompts include for the tables of
+// the query. MaxChars caps them all together; 0 leaves them out. With
+// ExpandViews, views the query reads are replaced by their definition and
+// the tables behind them are analyzed as the query's own. PlanMaxBytes caps
+// the EXPLAIN ANALYZE output captured for the query, cut after its top
+// operators; 0 leaves it out.
+type PromptSchemaConfig struct {
+	MaxChars     int           `mapstructure:"max_chars"`
+	CacheTTL     time.Duration `mapstructure:"cache_ttl"`
+	ExpandViews  bool          `mapstructure:"expand_views"`
+	PlanMaxBytes int           `mapstructure:"plan_max_bytes"`
+}
+
+// LLMQueueConfig limits generator calls across every caller in the process
+// to the provider's rate limits; 0 leaves a limit off. When interactive (API
+// and CLI) and batch (worker) calls are both waiting, interactive calls get
+// InteractiveShare of the dispatches and batch calls the rest.
+type LLMQueueConfig struct {
+	RequestsPerMinute int     `mapstructure:"requests_per_minute"`
+	TokensPerMinute   int     `mapstructure:"tokens_per_minute"`
+	InteractiveShare  float64 `mapstructure:"interactive_share"`
 }
 
 type ProviderConfig struct {
@@ -35,58 +278,472 @@ type ProviderConfig struct {
 	Model     string `mapstructure:"model"`
 	APIKeyEnv string `mapstructure:"api_key_env"`
 	APIKey    string `mapstructure:"api_key"`
+
+	// APIKeyFile points at a mounted secret; precedence is
+	// api_key > api_key_file > api_key_env
+	APIKeyFile string `mapstructure:"api_key_file"`
+
+	// BaseURL is the server of self-hosted providers; empty uses the
+	// provider default, http://localhost:11434 for ollama and
+	// https://generativelanguage.googleapis.com for gemini. azure-openai
+	// requires the resource endpoint, https://{resource}.openai.azure.com.
+	BaseURL string `mapstructure:"base_url"`
+
+	// Dimensions sizes the embeddings of cohere and voyage models that
+	// support several sizes; 0 keeps the model's default
+	Dimensions int `mapstructure:"dimensions"`
+
+	// Deployment and APIVersion address an azure-openai deployment. Model
+	// stays the deployed model's name, which sizes embeddings and prices
+	// calls; Deployment defaults to it.
+	Deployment string `mapstructure:"deployment"`
+	APIVersion string `mapstructure:"api_version"`
+
+	// Timeout bounds a single HTTP attempt; MaxRetries applies to network
+	// errors, 429, 500, 502, 503, 504 and 529 responses, within the caller's
+	// deadline. MaxTokens (0) and Temperature (unset)
+	// fall back to the provider and engine defaults.
+	Timeout     time.Duration `mapstructure:"timeout"`
+	MaxRetries  int           `mapstructure:"max_retries"`
+	MaxTokens   int           `mapstructure:"max_tokens"`
+	Temperature *float64      `mapstructure:"temperature"`
+
+	// RequestTimeout bounds a whole completion of the generator, its retries
+	// and streamed response included, 0 for no bound beyond the caller's
+	RequestTimeout time.Duration `mapstructure:"request_timeout"`
+
+	// Prices in US dollars per million tokens, used to estimate the cost of
+	// rewrites, "agent usage" and latentia_llm_cost_usd_total. Setting
+	// either overrides the built-in price of the model; otherwise models
+	// without one, such as local ones, are not costed.
+	InputPricePerMTok  float64 `mapstructure:"input_price_per_mtok"`
+	OutputPricePerMTok float64 `mapstructure:"output_price_per_mtok"`
+
+	// RequestsPerMinute and TokensPerMinute limit the calls sent to this
+	// provider and the tokens they carry, 0 for no limit, sharing one
+	// limiter across the process. For the generator they apply on top of
+	// llm.queue.
+	RequestsPerMinute int `mapstructure:"requests_per_minute"`
+	TokensPerMinute   int `mapstructure:"tokens_per_minute"`
+
+	resolvedKey string
+	keySource   string
 }
 
 type IngestConfig struct {
 	SlowQueryInterval time.Duration `mapstructure:"slowquery_interval"`
 	Docs             DocsConfig    `mapstructure:"docs"`
+	Filters           IngestFilters `mapstructure:"filters"`
 }
 
+// IngestFilters restrict which slow queries are ingested. Empty lists do not
+// filter; database names match case-insensitively, users exactly, and digest
+// patterns are regular expressions.
+type IngestFilters struct {
+	IncludeDatabases      []string `mapstructure:"include_databases"`
+	ExcludeDatabases      []string `mapstructure:"exclude_databases"`
+	ExcludeUsers          []string `mapstructure:"exclude_users"`
+	ExcludeDigestPatterns []string `mapstructure:"exclude_digest_patterns"`
+	MinQueryTime          float64  `mapstructure:"min_query_time"`
+
+	excludeDigests []*regexp.Regexp
+}
+
+// DocsConfig lists the documentation fetched by sync-docs. Sources on a host
+// outside AllowedHosts, or a subdomain of one, are only fetched with
+// --confirm, since every new page is embedded.
 type DocsConfig struct {
-	Sources    []SourceConfig `mapstructure:"sources"`
-	OCREnabled bool          `mapstructure:"ocr_enabled"`
+	Sources      []SourceConfig `mapstructure:"sources"`
+	AllowedHosts []string       `mapstructure:"allowed_hosts"`
+	OCREnabled   bool           `mapstructure:"ocr_enabled"`
 }
 
+// SourceConfig is one documentation page. Type is "url": an HTML page,
+// stripped to its text, or raw markdown or plain text.
 type SourceConfig struct {
-	Type string `mapstructure:"type"`
-	URL  string `mapstructure:"url"`
+	Type     string `mapstructure:"type"`
+	URL      string `mapstructure:"url"`
+	Category string `mapstructure:"category"`
+}
+
+// Allows reports whether the host of rawURL is one of AllowedHosts or a
+// subdomain of one
+func (d DocsConfig) Allows(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range d.AllowedHosts {
+		allowed = strings.ToLower(strings.TrimPrefix(allowed, "."))
+		if allowed != "" && (host == allowed || strings.HasSuffix(host, "."+allowed)) {
+			return true
+		}
+	}
+	return false
 }
 
 type SafetyConfig struct {
 	MaxStmtSeconds   int      `mapstructure:"max_stmt_seconds"`
 	ForbidPatterns   []string `mapstructure:"forbid_patterns"`
+
+	// RedactLiterals replaces string and long numeric literals with
+	// placeholders in the SQL sent to the generator
+	RedactLiterals bool `mapstructure:"redact_literals"`
+
+	// AnonymizeIdentifiers replaces schema, table, column and alias names
+	// with stable aliases (t1, c3) in the prompt and maps them back in the
+	// proposed SQL
+	AnonymizeIdentifiers bool `mapstructure:"anonymize_identifiers"`
+
+	// Limits applied by the sandbox executor to statements the agent did not
+	// author, such as proposed rewrites
+	MaxRows     int `mapstructure:"max_rows"`
+	MaxMemoryMB int `mapstructure:"max_memory_mb"`
+
+	forbid []*regexp.Regexp
 }
 
+// VectorConfig sizes the embeddings and tunes documentation search.
+// MinScore is the lowest similarity of a retrieved chunk; with Hybrid,
+// chunks are also found by the terms of the query and scored by
+// KeywordWeight times the share of the terms they contain plus the rest
+// times their similarity.
+// Documents are embedded in chunks of about ChunkWords words, split on
+// sentences, each repeating the last ChunkOverlap sentences of the previous.
 type VectorConfig struct {
-	Dim  int `mapstructure:"dim"`
-	TopK int `mapstructure:"top_k"`
+	Dim           int     `mapstructure:"dim"`
+	TopK          int     `mapstructure:"top_k"`
+	MinScore      float64 `mapstructure:"min_score"`
+	Hybrid        bool    `mapstructure:"hybrid"`
+	KeywordWeight float64 `mapstructure:"keyword_weight"`
+	ChunkWords    int     `mapstructure:"chunk_words"`
+	ChunkOverlap  int     `mapstructure:"chunk_overlap"`
+
+	// EmbedBatchSize is the most chunks sent to the embedder per request
+	EmbedBatchSize int `mapstructure:"embed_batch_size"`
+
+	// EmbeddingCache reuses the stored embeddings of unchanged chunks when
+	// documents are indexed again
+	EmbeddingCache bool `mapstructure:"embedding_cache"`
+}
+
+type LogConfig struct {
+	Level  string `mapstructure:"level"`
+	Format string `mapstructure:"format"`
+
+	// Output is stdout, stderr or a file path; files are rotated at
+	// MaxSizeMB keeping MaxBackups old files
+	Output     string `mapstructure:"output"`
+	MaxSizeMB  int    `mapstructure:"max_size_mb"`
+	MaxBackups int    `mapstructure:"max_backups"`
+}
+
+// NotifyConfig routes pipeline events to Slack and a generic webhook. Each
+// target is enabled by setting its webhook_url or webhook_urls.
+type NotifyConfig struct {
+	// UIURL is the agent UI base URL used for links in messages
+	UIURL   string `mapstructure:"ui_url"`
+	Retries int    `mapstructure:"retries"`
+
+	Slack   NotifierConfig `mapstructure:"slack"`
+	Webhook NotifierConfig `mapstructure:"webhook"`
+}
+
+// NotifierConfig holds the rules of one notification target
+type NotifierConfig struct {
+	WebhookURL string `mapstructure:"webhook_url"`
+	// WebhookURLs are further URLs sent the same messages
+	WebhookURLs []string `mapstructure:"webhook_urls"`
+	Events      []string `mapstructure:"events"`
+
+	// Secret, when set, signs each request body with HMAC-SHA256 in the
+	// X-Latentia-Signature header
+	Secret string `mapstructure:"secret"`
+
+	// MinConfidence applies to rewrite_created events
+	MinConfidence float64 `mapstructure:"min_confidence"`
+
+	// Channels overrides the webhook's default channel per event type
+	// (Slack only)
+	Channels map[string]string `mapstructure:"channels"`
+}
+
+// AppDataSource returns the DSN of the app tables
+func (c DBConfig) AppDataSource() string {
+	if c.AppDSN != "" {
+		return c.AppDSN
+	}
+	return c.DSN
+}
+
+// TargetDataSource returns the DSN of the analyzed database
+func (c DBConfig) TargetDataSource() string {
+	if c.TargetDSN != "" {
+		return c.TargetDSN
+	}
+	return c.DSN
+}
+
+// EnabledTargets returns the analyzed databases in order, the first being
+// the default one: the enabled db.targets, or DefaultTarget on
+// TargetDataSource when the list is empty
+func (c DBConfig) EnabledTargets() []TargetConfig {
+	if len(c.Targets) == 0 {
+		return []TargetConfig{{Name: DefaultTarget, DSN: c.TargetDataSource()}}
+	}
+	var targets []TargetConfig
+	for _, t := range c.Targets {
+		if t.IsEnabled() {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+// URLs returns webhook_url followed by webhook_urls, without empty ones
+func (n NotifierConfig) URLs() []string {
+	var urls []string
+	for _, u := range append([]string{n.WebhookURL}, n.WebhookURLs...) {
+		if u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
 }
 
-// LoadConfig loads configuration from config.yaml and environment variables
+// NotifyEvents lists the event types notifications can subscribe to
+var NotifyEvents = []string{"rewrite_created", "job_failed", "budget_exceeded"}
+
+// TracingConfig enables OTLP tracing when Endpoint is set
+type TracingConfig struct {
+	Endpoint    string  `mapstructure:"endpoint"`
+	SampleRate  float64 `mapstructure:"sample_rate"`
+	ServiceName string  `mapstructure:"service_name"`
+}
+
+// Options converts the section into options for tracing.Setup
+func (t TracingConfig) Options() tracing.Options {
+	return tracing.Options{
+		Endpoint:    t.Endpoint,
+		SampleRate:  t.SampleRate,
+		ServiceName: t.ServiceName,
+	}
+}
+
+// Options converts the section into options for logging.Setup
+func (l LogConfig) Options() logging.Options {
+	return logging.Options{
+		Level:      l.Level,
+		Format:     l.Format,
+		Output:     l.Output,
+		MaxSizeMB:  l.MaxSizeMB,
+		MaxBackups: l.MaxBackups,
+	}
+}
+
+// WorkerConfig bounds how much work each background job run picks up
+type WorkerConfig struct {
+	AnalyzeBatchSize int     `mapstructure:"analyze_batch_size"`
+	IngestMinTime    float64 `mapstructure:"ingest_min_time"`
+	IngestLimit      int     `mapstructure:"ingest_limit"`
+	// AnalyzeMaxAttempts is how many failed analyses skip a slow query; 0
+	// retries it forever
+	AnalyzeMaxAttempts int `mapstructure:"analyze_max_attempts"`
+	// ShutdownTimeout is how long a job may keep working on its current
+	// slow query once the process is told to stop; a query still running
+	// then is interrupted and put back to pending
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+}
+
+// AnalysisConfig sets how much of the pipeline runs unattended. Zero values
+// disable the corresponding rule.
+type AnalysisConfig struct {
+	MinQueryTimeToAnalyze     float64 `mapstructure:"min_query_time_to_analyze"`
+	MaxPendingRewrites        int     `mapstructure:"max_pending_rewrites"`
+	AutoRejectBelowConfidence float64 `mapstructure:"auto_reject_below_confidence"`
+
+	// AutoAcceptAboveConfidence only applies to rewrites that passed both
+	// EXPLAIN validation and the equivalence check. Thresholds below 0.9
+	// require AllowLowAutoAccept.
+	AutoAcceptAboveConfidence float64 `mapstructure:"auto_accept_above_confidence"`
+	AllowLowAutoAccept        bool    `mapstructure:"allow_low_auto_accept"`
+
+	// Tracking compares occurrences of an accepted rewrite's digest within
+	// TrackingWindow before and after acceptance, reporting as inconclusive
+	// until both sides have TrackingMinSamples. A change in average query
+	// time smaller than RegressionThreshold counts as unchanged.
+	TrackingWindow      time.Duration `mapstructure:"tracking_window"`
+	TrackingMinSamples  int           `mapstructure:"tracking_min_samples"`
+	RegressionThreshold float64       `mapstructure:"regression_threshold"`
+
+	// RewriteCacheTTL is how long a pending or accepted rewrite is reused
+	// for new occurrences of its digest instead of calling the LLM again;
+	// older rewrites are regenerated since the schema may have changed
+	RewriteCacheTTL time.Duration `mapstructure:"rewrite_cache_ttl"`
+}
+
+// RetentionConfig sets how long app rows are kept before the purge job
+// deletes them. A zero age keeps that table's rows forever. Accepted
+// rewrites and the slow queries they belong to are never purged.
+type RetentionConfig struct {
+	// SlowQueries applies to completed and skipped slow queries, by
+	// ingestion time
+	SlowQueries time.Duration `mapstructure:"slow_queries"`
+	// RejectedRewrites applies by review time
+	RejectedRewrites time.Duration `mapstructure:"rejected_rewrites"`
+	LLMUsage         time.Duration `mapstructure:"llm_usage"`
+
+	// BatchSize bounds the rows deleted in one transaction
+	BatchSize int `mapstructure:"batch_size"`
+	// Interval is how often run purges; 0 leaves purging to agent purge
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// ScoringConfig holds the weights used to compute a rewrite's confidence score
+type ScoringConfig struct {
+	Base              float64 `mapstructure:"base"`
+	SimpleBonus       float64 `mapstructure:"simple_bonus"`
+	MediumBonus       float64 `mapstructure:"medium_bonus"`
+	ComplexPenalty    float64 `mapstructure:"complex_penalty"`
+	AntiPatternBonus  float64 `mapstructure:"anti_pattern_bonus"`
+	OptimizationBonus float64 `mapstructure:"optimization_bonus"`
+	RationaleBonus    float64 `mapstructure:"rationale_bonus"`
+	PlanChangeBonus   float64 `mapstructure:"plan_change_bonus"`
+
+	// CriticalBonus is added to AntiPatternBonus when a finding is critical,
+	// such as a join without a condition
+	CriticalBonus float64 `mapstructure:"critical_bonus"`
+
+	// DMLPenalty is taken off rewrites of UPDATE, DELETE and INSERT, which
+	// are riskier to change than reads and cannot be EXPLAINed in the sandbox
+	DMLPenalty float64 `mapstructure:"dml_penalty"`
+
+	// When both plans were obtained, these replace RationaleBonus and
+	// PlanChangeBonus. PlanRowsBonus is scaled by the share of estimated
+	// rows read that the rewrite saves, and PlanRowsPenalty by how many
+	// more it reads, in full from twice as many.
+	PlanRowsBonus        float64 `mapstructure:"plan_rows_bonus"`
+	PlanRowsPenalty      float64 `mapstructure:"plan_rows_penalty"`
+	FullScanBonus        float64 `mapstructure:"full_scan_bonus"`
+	FullScanPenalty      float64 `mapstructure:"full_scan_penalty"`
+	TablesChangedPenalty float64 `mapstructure:"tables_changed_penalty"`
+
+	// SemanticsChangeCap is the highest confidence of a rewrite with a
+	// critical semantics change, such as an added LIMIT
+	SemanticsChangeCap float64 `mapstructure:"semantics_change_cap"`
+}
+
+// LoadConfig loads configuration from config.yaml and environment variables.
+// When a profile is selected (SetProfile or LATENTIA_PROFILE), the matching
+// config.<profile>.yaml is merged over the base file. A missing base file is
+// not an error: every key has a default. The merged result is validated
+// before it is returned and becomes the Current config.
 func LoadConfig() (*Config, error) {
-	v := viper.New()
+	v, err := newViper()
+	if err != nil {
+		return nil, err
+	}
 	
 	// Set config file locations
 	v.SetConfigName("config")
 	v.SetConfigType("yaml")
-	v.AddConfigPath("./deploy/")
-	v.AddConfigPath("./")
-	v.AddConfigPath("$HOME/.latentia/")
-	v.AddConfigPath("/etc/latentia/")
-	
-	// Enable environment variable override with LATENTIA_ prefix
-	v.SetEnvPrefix("LATENTIA")
-	v.AutomaticEnv()
+	for _, path := range configSearchPaths {
+		v.AddConfigPath(path)
+	}
 	
 	// Read config file
 	if err := v.ReadInConfig(); err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+	}
+	base := v.ConfigFileUsed()
+	
+	profile := ActiveProfile()
+	var profileFile string
+	if profile != "" {
+		if profileFile, err = findProfileFile(profile, base); err != nil {
+			return nil, err
+		}
+		v.SetConfigFile(profileFile)
+		if err := v.MergeInConfig(); err != nil {
+			return nil, fmt.Errorf("failed to read profile %s: %w", profile, err)
+		}
 	}
 	
+	if base == "" && profileFile == "" {
+		slog.Warn("no config file found, using defaults and LATENTIA_* environment variables", "search_paths", configSearchPaths)
+	}
+	
+	config, err := decode(v)
+	if err != nil {
+		return nil, err
+	}
+	config.file = base
+	config.profile = profile
+	config.profileFile = profileFile
+	config.sources = loadSources(base, profileFile)
+	
+	current.Store(config)
+	return config, nil
+}
+
+// ValidateData checks a YAML config document on its own, without the
+// environment, profiles or secret resolution, so a generated file can be
+// checked before it is written
+func ValidateData(data []byte) error {
+	v := viper.New()
+	setDefaults(v)
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+	
+	var config Config
+	if err := v.Unmarshal(&config); err != nil {
+		return fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	return config.Validate()
+}
+
+// newViper returns a viper instance with defaults and environment bindings
+func newViper() (*viper.Viper, error) {
+	v := viper.New()
+	setDefaults(v)
+	
+	// Enable environment variable override with LATENTIA_ prefix; nested
+	// keys use underscores (LATENTIA_LLM_GENERATOR_API_KEY)
+	v.SetEnvPrefix("LATENTIA")
+	v.SetEnvKeyReplacer(envKeyReplacer)
+	v.AutomaticEnv()
+	if err := bindEnvs(v); err != nil {
+		return nil, fmt.Errorf("failed to bind environment variables: %w", err)
+	}
+	return v, nil
+}
+
+// decode unmarshals and validates whatever v has read
+func decode(v *viper.Viper) (*Config, error) {
 	var config Config
 	if err := v.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 	
+	secretErrs := config.resolveSecrets()
+	if err := config.Validate(); err != nil || len(secretErrs) > 0 {
+		invalid := &ValidationError{Errors: secretErrs}
+		var other *ValidationError
+		if errors.As(err, &other) {
+			invalid.Errors = append(invalid.Errors, other.Errors...)
+		} else if err != nil {
+			return nil, err
+		}
+		return nil, invalid
+	}
+	config.Safety.forbid = compileForbidPatterns(config.Safety.ForbidPatterns)
+	config.Ingest.Filters.excludeDigests = compileDigestPatterns(config.Ingest.Filters.ExcludeDigestPatterns)
+	
 	return &config, nil
-}
\ No newline at end of file
+}
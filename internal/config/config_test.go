@@ -0,0 +1,232 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadFiles runs LoadConfig from a temporary working directory holding
+// files, by name relative to it, with an empty HOME so no config outside
+// the test is found
+func loadFiles(t *testing.T, files map[string]string) (*Config, error) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("LATENTIA_PROFILE", "")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		current.Store(nil)
+		SetProfile("")
+	})
+	return LoadConfig()
+}
+
+// fieldErrors returns the paths of the problems in err, failing the test
+// unless it is a *ValidationError
+func fieldErrors(t *testing.T, err error) []string {
+	t.Helper()
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("error = %v, want a *ValidationError", err)
+	}
+	var paths []string
+	for _, fe := range invalid.Errors {
+		paths = append(paths, fe.Path)
+	}
+	return paths
+}
+
+func TestLoadConfigWithoutFile(t *testing.T) {
+	cfg, err := loadFiles(t, nil)
+	if err != nil {
+		t.Fatalf("LoadConfig without a file: %v", err)
+	}
+	if cfg.File() != "" {
+		t.Errorf("File() = %q, want none", cfg.File())
+	}
+	if cfg.Server.Addr != ":8080" {
+		t.Errorf("server.addr = %q, want the default :8080", cfg.Server.Addr)
+	}
+	if cfg.LLM.Generator.Provider != "mock" || cfg.LLM.Embedder.Provider != "mock" {
+		t.Errorf("providers = %q, %q, want mock", cfg.LLM.Generator.Provider, cfg.LLM.Embedder.Provider)
+	}
+	if cfg.Vector.TopK != 3 {
+		t.Errorf("vector.top_k = %d, want the default 3", cfg.Vector.TopK)
+	}
+	if Current() != cfg {
+		t.Error("LoadConfig did not make the config current")
+	}
+}
+
+func TestLoadConfigEmptyFile(t *testing.T) {
+	cfg, err := loadFiles(t, map[string]string{"config.yaml": ""})
+	if err != nil {
+		t.Fatalf("LoadConfig with an empty file: %v", err)
+	}
+	if want := Default(); cfg.Server.Addr != want.Server.Addr || cfg.DB.DSN != want.DB.DSN {
+		t.Errorf("empty file config differs from the defaults: %+v", cfg.Server)
+	}
+}
+
+func TestLoadConfigPartialFile(t *testing.T) {
+	cfg, err := loadFiles(t, map[string]string{"deploy/config.yaml": `
+server:
+  addr: ":9090"
+vector:
+  top_k: 8
+`})
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if !strings.HasSuffix(cfg.File(), filepath.Join("deploy", "config.yaml")) {
+		t.Errorf("File() = %q, want deploy/config.yaml", cfg.File())
+	}
+	if cfg.Server.Addr != ":9090" || cfg.Vector.TopK != 8 {
+		t.Errorf("set keys = %q, %d, want :9090, 8", cfg.Server.Addr, cfg.Vector.TopK)
+	}
+	// Keys the file leaves out keep their defaults, also beside set ones
+	// in the same section
+	if cfg.Server.ShutdownTimeout != Default().Server.ShutdownTimeout {
+		t.Errorf("server.shutdown_timeout = %v, want the default", cfg.Server.ShutdownTimeout)
+	}
+	if cfg.Vector.Dim != Default().Vector.Dim {
+		t.Errorf("vector.dim = %d, want the default", cfg.Vector.Dim)
+	}
+}
+
+func TestLoadConfigInvalidEnums(t *testing.T) {
+	_, err := loadFiles(t, map[string]string{"config.yaml": `
+llm:
+  generator:
+    provider: opnai
+  embedder:
+    provider: mock
+log:
+  level: loud
+  format: xml
+`})
+	paths := fieldErrors(t, err)
+	for _, want := range []string{"llm.generator.provider", "log.level", "log.format"} {
+		found := false
+		for _, path := range paths {
+			found = found || path == want
+		}
+		if !found {
+			t.Errorf("problems %v miss %s", paths, want)
+		}
+	}
+	if !strings.Contains(err.Error(), "llm.generator.provider: unknown value 'opnai'") {
+		t.Errorf("error = %v, want the field-pathed message", err)
+	}
+	if Current() == nil || Current().LLM.Generator.Provider == "opnai" {
+		t.Error("an invalid config became current")
+	}
+}
+
+func TestLoadConfigAggregatesProblems(t *testing.T) {
+	_, err := loadFiles(t, map[string]string{"config.yaml": `
+server:
+  addr: "no port"
+db:
+  maxOpenConns: 0
+vector:
+  top_k: 0
+  min_score: 2
+`})
+	paths := fieldErrors(t, err)
+	want := []string{"server.addr", "db.maxOpenConns", "vector.top_k", "vector.min_score"}
+	if strings.Join(paths, ",") != strings.Join(want, ",") {
+		t.Errorf("problems = %v, want %v in config order", paths, want)
+	}
+}
+
+func TestLoadConfigUnreadableFile(t *testing.T) {
+	_, err := loadFiles(t, map[string]string{"config.yaml": "server: [unclosed"})
+	if err == nil || !strings.Contains(err.Error(), "failed to read config file") {
+		t.Errorf("LoadConfig = %v, want a read error", err)
+	}
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		t.Errorf("a parse error was reported as a validation error: %v", err)
+	}
+}
+
+func TestValidateData(t *testing.T) {
+	if err := ValidateData([]byte("server:\n  addr: \":8081\"\n")); err != nil {
+		t.Errorf("ValidateData of a valid document: %v", err)
+	}
+	paths := fieldErrors(t, ValidateData([]byte("llm:\n  prompt_style: verbose\n")))
+	if len(paths) != 1 || paths[0] != "llm.prompt_style" {
+		t.Errorf("problems = %v, want llm.prompt_style", paths)
+	}
+}
+
+func TestPasswordFileAppliesToEveryDSNOfTheUser(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		dsns   func(c DBConfig) []string
+		want   []string
+	}{
+		{
+			name: "split",
+			config: `
+db:
+  password_file: tidb_password
+  app_dsn: "agent:old@tcp(app:4000)/latentia"
+  target_dsn: "agent@tcp(replica:4000)/shop"
+`,
+			dsns: func(c DBConfig) []string { return []string{c.AppDSN, c.TargetDSN} },
+			want: []string{"agent:s3cret@tcp(app:4000)/latentia", "agent:s3cret@tcp(replica:4000)/shop"},
+		},
+		{
+			name: "targets",
+			config: `
+db:
+  password_file: tidb_password
+  dsn: "agent@tcp(app:4000)/latentia"
+  targets:
+    - name: billing
+      dsn: "agent:old@tcp(billing:4000)/billing"
+    - name: reporting
+      dsn: "reader:own@tcp(reporting:4000)/reporting"
+`,
+			dsns: func(c DBConfig) []string { return []string{c.DSN, c.Targets[0].DSN, c.Targets[1].DSN} },
+			// The reporting account keeps its own password
+			want: []string{"agent:s3cret@tcp(app:4000)/latentia", "agent:s3cret@tcp(billing:4000)/billing", "reader:own@tcp(reporting:4000)/reporting"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadFiles(t, map[string]string{"tidb_password": "s3cret\n", "config.yaml": tt.config})
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			for i, got := range tt.dsns(cfg.DB) {
+				if !strings.HasPrefix(got, tt.want[i]) {
+					t.Errorf("DSN %d = %q, want %s", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
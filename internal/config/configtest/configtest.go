@@ -0,0 +1,42 @@
+// Package configtest loads configurations for tests of the packages reading
+// config.Current.
+package configtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matthieukhl/latentia/internal/config"
+)
+
+// Load makes yaml, merged over the built-in defaults, the current config for
+// the rest of the test. It is read as config.yaml from a temporary working
+// directory, so tests calling it must not run in parallel.
+func Load(t testing.TB, yaml string) *config.Config {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(yaml), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("failed to enter %s: %v", dir, err)
+	}
+	t.Cleanup(func() {
+		if err := os.Chdir(wd); err != nil {
+			t.Errorf("failed to return to %s: %v", wd, err)
+		}
+	})
+
+	config.SetProfile("")
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	return cfg
+}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/logging"
+	"github.com/matthieukhl/latentia/internal/prompts"
+	"github.com/matthieukhl/latentia/internal/tracing"
+	"github.com/spf13/viper"
+)
+
+// Defaults let the agent start with no config file at all: mock providers,
+// a local TiDB and a small connection pool
+var defaults = map[string]any{
+	"server.addr":             ":8080",
+	"server.base_path":        "",
+	"server.read_timeout":     30 * time.Second,
+	"server.write_timeout":    2 * time.Minute,
+	"server.idle_timeout":     2 * time.Minute,
+	"server.analyze_timeout":  90 * time.Second,
+	"server.shutdown_timeout": 30 * time.Second,
+	"server.tls.cert_file":    "",
+	"server.tls.key_file":     "",
+	"server.api_keys":         []string{},
+	"server.auth.keys":        []map[string]any{},
+	"server.ui":               true,
+
+	"db.dsn":               "root@tcp(127.0.0.1:4000)/test?parseTime=true",
+	"db.maxOpenConns":      10,
+	"db.password_file":     "",
+	"db.auto_migrate":      true,
+	"db.app_dsn":           "",
+	"db.target_dsn":        "",
+	"db.targets":           []map[string]any{},
+	"db.read_only":         false,
+	"db.max_idle_conns":    2,
+	"db.conn_max_lifetime": 5 * time.Minute,
+	"db.connect_retries":   5,
+	"db.connect_backoff":   time.Second,
+	"db.tls.ca_file":       "",
+	"db.tls.server_name":   "",
+	"db.tls.skip_verify":   false,
+
+	"llm.embedder.provider":            "mock",
+	"llm.embedder.model":               "mock-embedding",
+	"llm.embedder.api_key_env":         "",
+	"llm.embedder.api_key":             "",
+	"llm.embedder.api_key_file":        "",
+	"llm.embedder.timeout":             30 * time.Second,
+	"llm.embedder.max_retries":         2,
+	"llm.embedder.max_tokens":          0,
+	"llm.embedder.base_url":            "",
+	"llm.embedder.deployment":          "",
+	"llm.embedder.api_version":         "",
+	"llm.embedder.requests_per_minute": 0,
+	"llm.embedder.tokens_per_minute":   0,
+	"llm.embedder.dimensions":          0,
+
+	"llm.generator.provider":              "mock",
+	"llm.generator.model":                 "mock-generator",
+	"llm.generator.api_key_env":           "",
+	"llm.generator.api_key":               "",
+	"llm.generator.api_key_file":          "",
+	"llm.generator.base_url":              "",
+	"llm.generator.deployment":            "",
+	"llm.generator.api_version":           "",
+	"llm.generator.timeout":               60 * time.Second,
+	"llm.generator.max_retries":           2,
+	"llm.generator.max_tokens":            0,
+	"llm.generator.request_timeout":       3 * time.Minute,
+	"llm.generator.input_price_per_mtok":  0.0,
+	"llm.generator.output_price_per_mtok": 0.0,
+	"llm.generator.requests_per_minute":   0,
+	"llm.generator.tokens_per_minute":     0,
+	"llm.templates_dir":                   "",
+	"llm.prompt_style":                    prompts.StyleDetailed,
+	"llm.queue.requests_per_minute":       0,
+	"llm.queue.tokens_per_minute":         0,
+	"llm.queue.interactive_share":         0.75,
+	"llm.schema.max_chars":                6000,
+	"llm.schema.cache_ttl":                10 * time.Minute,
+	"llm.schema.expand_views":             true,
+	"llm.schema.plan_max_bytes":           8192,
+	"llm.store_raw":                       true,
+	"llm.store_raw_max_bytes":             256 * 1024,
+	"llm.mock.latency":                    500 * time.Millisecond,
+	"llm.mock.failure_rate":               0.0,
+	"llm.mock.failure":                    "rate_limit",
+	"llm.mock.script":                     []string{},
+
+	"ingest.slowquery_interval": 5 * time.Minute,
+	"ingest.docs.sources":       []map[string]any{},
+	"ingest.docs.allowed_hosts": []string{"docs.pingcap.com"},
+	"ingest.docs.ocr_enabled":   false,
+
+	"ingest.filters.include_databases":       []string{},
+	"ingest.filters.exclude_databases":       []string{},
+	"ingest.filters.exclude_users":           []string{},
+	"ingest.filters.exclude_digest_patterns": []string{},
+	"ingest.filters.min_query_time":          0.0,
+
+	"safety.max_stmt_seconds":      10,
+	"safety.forbid_patterns":       []string{"DROP ", "TRUNCATE ", "ALTER "},
+	"safety.redact_literals":       false,
+	"safety.anonymize_identifiers": false,
+	"safety.max_rows":              1000,
+	"safety.max_memory_mb":         1024,
+
+	"vector.dim":              1536,
+	"vector.top_k":            3,
+	"vector.min_score":        0.5,
+	"vector.hybrid":           false,
+	"vector.keyword_weight":   0.3,
+	"vector.chunk_words":      80,
+	"vector.chunk_overlap":    1,
+	"vector.embed_batch_size": 64,
+	"vector.embedding_cache":  true,
+
+	"notify.ui_url":                 "http://localhost:8080/ui",
+	"notify.retries":                3,
+	"notify.slack.webhook_url":      "",
+	"notify.slack.webhook_urls":     []string{},
+	"notify.slack.secret":           "",
+	"notify.slack.events":           NotifyEvents,
+	"notify.slack.min_confidence":   0.8,
+	"notify.slack.channels":         map[string]string{},
+	"notify.webhook.webhook_url":    "",
+	"notify.webhook.webhook_urls":   []string{},
+	"notify.webhook.secret":         "",
+	"notify.webhook.events":         NotifyEvents,
+	"notify.webhook.min_confidence": 0.8,
+	"notify.webhook.channels":       map[string]string{},
+
+	"tracing.endpoint":     "",
+	"tracing.sample_rate":  1.0,
+	"tracing.service_name": tracing.DefaultServiceName,
+
+	"log.level":       "info",
+	"log.format":      "text",
+	"log.output":      "stderr",
+	"log.max_size_mb": logging.DefaultMaxSizeMB,
+	"log.max_backups": logging.DefaultMaxBackups,
+
+	"worker.analyze_batch_size":   10,
+	"worker.analyze_max_attempts": 3,
+	"worker.ingest_min_time":      0.1,
+	"worker.ingest_limit":         100,
+	"worker.shutdown_timeout":     30 * time.Second,
+
+	"scoring.base":                   0.5,
+	"scoring.simple_bonus":           0.3,
+	"scoring.medium_bonus":           0.1,
+	"scoring.complex_penalty":        0.1,
+	"scoring.anti_pattern_bonus":     0.2,
+	"scoring.optimization_bonus":     0.15,
+	"scoring.rationale_bonus":        0.1,
+	"scoring.plan_change_bonus":      0.1,
+	"scoring.dml_penalty":            0.2,
+	"scoring.critical_bonus":         0.1,
+	"scoring.plan_rows_bonus":        0.2,
+	"scoring.plan_rows_penalty":      0.2,
+	"scoring.full_scan_bonus":        0.1,
+	"scoring.full_scan_penalty":      0.1,
+	"scoring.tables_changed_penalty": 0.3,
+	"scoring.semantics_change_cap":   0.4,
+
+	"analysis.min_query_time_to_analyze":    0.0,
+	"analysis.max_pending_rewrites":         0,
+	"analysis.auto_reject_below_confidence": 0.0,
+	"analysis.auto_accept_above_confidence": 0.0,
+	"analysis.allow_low_auto_accept":        false,
+	"analysis.tracking_window":              "168h",
+	"analysis.tracking_min_samples":         5,
+	"analysis.regression_threshold":         0.1,
+	"analysis.rewrite_cache_ttl":            "168h",
+
+	"retention.slow_queries":      "720h",
+	"retention.rejected_rewrites": "2160h",
+	"retention.llm_usage":         "4320h",
+	"retention.batch_size":        1000,
+	"retention.interval":          "24h",
+
+	"schedules": map[string]string{},
+}
+
+func setDefaults(v *viper.Viper) {
+	for key, value := range defaults {
+		v.SetDefault(key, value)
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// envKeyReplacer maps nested keys to environment names, so db.dsn is read
+// from LATENTIA_DB_DSN and llm.generator.api_key from
+// LATENTIA_LLM_GENERATOR_API_KEY
+var envKeyReplacer = strings.NewReplacer(".", "_")
+
+// bindEnvs explicitly binds every leaf key of Config. AutomaticEnv alone only
+// consults the environment for keys viper already knows about, which misses
+// nested keys absent from the config file during Unmarshal.
+func bindEnvs(v *viper.Viper) error {
+	for _, key := range configKeys(reflect.TypeOf(Config{}), "") {
+		if err := v.BindEnv(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configKeys walks the mapstructure tags of t and returns the dotted path of
+// every leaf field. Slices and maps are leaves: their elements cannot be
+// addressed individually from the environment.
+func configKeys(t reflect.Type, prefix string) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := fieldName(f)
+		if name == "" {
+			continue
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		if f.Type.Kind() == reflect.Struct && f.Type.String() != "time.Time" {
+			keys = append(keys, configKeys(f.Type, key)...)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
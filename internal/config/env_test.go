@@ -0,0 +1,125 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEnvOverridesDefaults(t *testing.T) {
+	t.Setenv("LATENTIA_SERVER_ADDR", ":9191")
+	t.Setenv("LATENTIA_VECTOR_TOP_K", "7")
+	t.Setenv("LATENTIA_SERVER_SHUTDOWN_TIMEOUT", "45s")
+	t.Setenv("LATENTIA_SAFETY_MAX_ROWS", "250")
+
+	cfg, err := loadFiles(t, nil)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Server.Addr != ":9191" {
+		t.Errorf("server.addr = %q, want :9191", cfg.Server.Addr)
+	}
+	if cfg.Vector.TopK != 7 {
+		t.Errorf("vector.top_k = %d, want 7", cfg.Vector.TopK)
+	}
+	if cfg.Server.ShutdownTimeout != 45*time.Second {
+		t.Errorf("server.shutdown_timeout = %v, want 45s", cfg.Server.ShutdownTimeout)
+	}
+	if cfg.Safety.MaxRows != 250 {
+		t.Errorf("safety.max_rows = %d, want 250", cfg.Safety.MaxRows)
+	}
+}
+
+// Keys three levels deep, and ones with no default that viper only learns
+// of from bindEnvs
+func TestEnvOverridesNestedKeys(t *testing.T) {
+	t.Setenv("LATENTIA_LLM_GENERATOR_MODEL", "gpt-4o-mini")
+	t.Setenv("LATENTIA_LLM_GENERATOR_TEMPERATURE", "0.3")
+	t.Setenv("LATENTIA_LLM_EMBEDDER_REQUEST_TIMEOUT", "20s")
+	t.Setenv("LATENTIA_LLM_QUEUE_INTERACTIVE_SHARE", "0.5")
+
+	cfg, err := loadFiles(t, nil)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.LLM.Generator.Model != "gpt-4o-mini" {
+		t.Errorf("llm.generator.model = %q", cfg.LLM.Generator.Model)
+	}
+	if cfg.LLM.Generator.Temperature == nil || *cfg.LLM.Generator.Temperature != 0.3 {
+		t.Errorf("llm.generator.temperature = %v, want 0.3", cfg.LLM.Generator.Temperature)
+	}
+	if cfg.LLM.Embedder.RequestTimeout != 20*time.Second {
+		t.Errorf("llm.embedder.request_timeout = %v, want 20s", cfg.LLM.Embedder.RequestTimeout)
+	}
+	if cfg.LLM.Queue.InteractiveShare != 0.5 {
+		t.Errorf("llm.queue.interactive_share = %g, want 0.5", cfg.LLM.Queue.InteractiveShare)
+	}
+}
+
+func TestEnvTakesPrecedenceOverFile(t *testing.T) {
+	t.Setenv("LATENTIA_SERVER_ADDR", ":7070")
+	t.Setenv("LATENTIA_LLM_GENERATOR_MAX_TOKENS", "512")
+
+	cfg, err := loadFiles(t, map[string]string{"config.yaml": `
+server:
+  addr: ":9090"
+  shutdown_timeout: 10s
+llm:
+  generator:
+    provider: mock
+    model: from-file
+    max_tokens: 2048
+`})
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Server.Addr != ":7070" {
+		t.Errorf("server.addr = %q, want the environment's :7070", cfg.Server.Addr)
+	}
+	if cfg.LLM.Generator.MaxTokens != 512 {
+		t.Errorf("llm.generator.max_tokens = %d, want the environment's 512", cfg.LLM.Generator.MaxTokens)
+	}
+	// Keys the environment leaves alone keep the file's values
+	if cfg.Server.ShutdownTimeout != 10*time.Second || cfg.LLM.Generator.Model != "from-file" {
+		t.Errorf("file values lost: shutdown_timeout %v, model %q", cfg.Server.ShutdownTimeout, cfg.LLM.Generator.Model)
+	}
+}
+
+func TestEnvValuesAreValidated(t *testing.T) {
+	t.Setenv("LATENTIA_VECTOR_TOP_K", "0")
+	_, err := loadFiles(t, nil)
+	if paths := fieldErrors(t, err); !slices.Equal(paths, []string{"vector.top_k"}) {
+		t.Errorf("problems = %v, want vector.top_k", paths)
+	}
+}
+
+func TestConfigKeys(t *testing.T) {
+	keys := configKeys(reflect.TypeOf(Config{}), "")
+	for _, want := range []string{
+		"server.addr",
+		"server.tls.cert_file",
+		"db.maxOpenConns",
+		"llm.generator.api_key",
+		"llm.generator.temperature",
+		"llm.queue.interactive_share",
+		"vector.top_k",
+	} {
+		if !slices.Contains(keys, want) {
+			t.Errorf("configKeys has no %s", want)
+		}
+	}
+	for _, key := range keys {
+		// Slices are leaves, and unexported fields are not keys
+		if key == "server.auth.keys.name" || key == "llm.generator.resolvedKey" {
+			t.Errorf("configKeys has %s", key)
+		}
+	}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if seen[key] {
+			t.Errorf("configKeys repeats %s", key)
+		}
+		seen[key] = true
+	}
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// CompileDigestPattern compiles an exclude_digest_patterns entry or a
+// suppression pattern. Digests are hex strings, so patterns are matched
+// case-insensitively.
+func CompileDigestPattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q: %w", pattern, err)
+	}
+	return re, nil
+}
+
+// ExcludeDigestRegexps returns the compiled exclude_digest_patterns, compiling
+// them on demand for configs not produced by LoadConfig
+func (f IngestFilters) ExcludeDigestRegexps() []*regexp.Regexp {
+	if f.excludeDigests != nil || len(f.ExcludeDigestPatterns) == 0 {
+		return f.excludeDigests
+	}
+	return compileDigestPatterns(f.ExcludeDigestPatterns)
+}
+
+func compileDigestPatterns(patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		if re, err := CompileDigestPattern(pattern); err == nil {
+			compiled = append(compiled, re)
+		}
+	}
+	return compiled
+}
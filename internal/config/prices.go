@@ -0,0 +1,63 @@
+package config
+
+import "strings"
+
+// modelPrices are the list prices, in US dollars per million input and
+// output tokens, of common hosted models. Dated snapshots such as
+// gpt-4o-2024-08-06 match by prefix.
+var modelPrices = map[string][2]float64{
+	"gpt-4o":            {2.50, 10.00},
+	"gpt-4o-mini":       {0.15, 0.60},
+	"gpt-4.1":           {2.00, 8.00},
+	"gpt-4.1-mini":      {0.40, 1.60},
+	"gpt-4.1-nano":      {0.10, 0.40},
+	"gpt-4-turbo":       {10.00, 30.00},
+	"gpt-3.5-turbo":     {0.50, 1.50},
+	"o3-mini":           {1.10, 4.40},
+	"claude-3-5-sonnet": {3.00, 15.00},
+	"claude-3-7-sonnet": {3.00, 15.00},
+	"claude-sonnet-4":   {3.00, 15.00},
+	"claude-3-5-haiku":  {0.80, 4.00},
+	"claude-3-haiku":    {0.25, 1.25},
+	"claude-3-opus":     {15.00, 75.00},
+	"claude-opus-4":     {15.00, 75.00},
+	"gemini-1.5-pro":    {1.25, 5.00},
+	"gemini-1.5-flash":  {0.075, 0.30},
+	"gemini-2.0-flash":  {0.10, 0.40},
+	"gemini-2.5-pro":    {1.25, 10.00},
+	"gemini-2.5-flash":  {0.30, 2.50},
+}
+
+// Prices returns the price per million input and output tokens of the
+// provider's model: input_price_per_mtok and output_price_per_mtok when
+// either is set, otherwise the built-in price of the model, and 0 for
+// models without one, such as local ones
+func (p ProviderConfig) Prices() (input, output float64) {
+	if p.InputPricePerMTok > 0 || p.OutputPricePerMTok > 0 {
+		return p.InputPricePerMTok, p.OutputPricePerMTok
+	}
+	if p.Provider != "openai" && p.Provider != "azure-openai" && p.Provider != "anthropic" && p.Provider != "gemini" {
+		return 0, 0
+	}
+
+	// The longest matching prefix wins, so gpt-4o-mini is not priced as
+	// gpt-4o
+	model := strings.ToLower(p.Model)
+	var best string
+	for name := range modelPrices {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return 0, 0
+	}
+	return modelPrices[best][0], modelPrices[best][1]
+}
+
+// EstimateCost returns the cost in US dollars of a call of the provider's
+// model at Prices
+func (p ProviderConfig) EstimateCost(promptTokens, completionTokens int) float64 {
+	input, output := p.Prices()
+	return (float64(promptTokens)*input + float64(completionTokens)*output) / 1e6
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// configSearchPaths are the directories searched for config.yaml, in order
+var configSearchPaths = []string{"./deploy/", "./", "$HOME/.latentia/", "/etc/latentia/"}
+
+var selectedProfile string
+
+// SetProfile selects the profile merged over the base config. It takes
+// precedence over LATENTIA_PROFILE.
+func SetProfile(name string) {
+	selectedProfile = strings.TrimSpace(name)
+}
+
+// ActiveProfile returns the profile LoadConfig will use, "" for none
+func ActiveProfile() string {
+	if selectedProfile != "" {
+		return selectedProfile
+	}
+	return strings.TrimSpace(os.Getenv("LATENTIA_PROFILE"))
+}
+
+// profileDirs lists where profile files are looked up: next to the base
+// config file when there is one, otherwise the regular search paths
+func profileDirs(base string) []string {
+	if base != "" {
+		return []string{filepath.Dir(base)}
+	}
+	dirs := make([]string, len(configSearchPaths))
+	for i, path := range configSearchPaths {
+		dirs[i] = os.ExpandEnv(path)
+	}
+	return dirs
+}
+
+// findProfileFile resolves config.<profile>.yaml (or .yml), failing with the
+// list of available profiles when it does not exist. Profile names holding
+// a path separator or ".." are refused so they cannot reach files outside
+// the config directories.
+func findProfileFile(profile, base string) (string, error) {
+	if strings.ContainsAny(profile, `/\`) || strings.Contains(profile, "..") {
+		return "", fmt.Errorf("invalid profile %q: must not contain path separators or \"..\"", profile)
+	}
+	for _, dir := range profileDirs(base) {
+		for _, ext := range []string{".yaml", ".yml"} {
+			path := filepath.Join(dir, "config."+profile+ext)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path, nil
+			}
+		}
+	}
+
+	available := AvailableProfiles(base)
+	if len(available) == 0 {
+		return "", fmt.Errorf("unknown profile %q: no config.<profile>.yaml files found", profile)
+	}
+	return "", fmt.Errorf("unknown profile %q (available: %s)", profile, strings.Join(available, ", "))
+}
+
+// AvailableProfiles lists the profile names found next to the base config
+func AvailableProfiles(base string) []string {
+	seen := map[string]bool{}
+	for _, dir := range profileDirs(base) {
+		for _, pattern := range []string{"config.*.yaml", "config.*.yml"} {
+			matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+			for _, match := range matches {
+				name := strings.TrimPrefix(filepath.Base(match), "config.")
+				name = strings.TrimSuffix(strings.TrimSuffix(name, ".yaml"), ".yml")
+				// Skip config.yaml.sample and similar
+				if name != "" && !strings.Contains(name, ".") {
+					seen[name] = true
+				}
+			}
+		}
+	}
+
+	profiles := make([]string, 0, len(seen))
+	for name := range seen {
+		profiles = append(profiles, name)
+	}
+	sort.Strings(profiles)
+	return profiles
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProfileMergesOverBase(t *testing.T) {
+	SetProfile("staging")
+	cfg, err := loadFiles(t, map[string]string{
+		"deploy/config.yaml":         "server:\n  addr: \":8080\"\nvector:\n  top_k: 5\n",
+		"deploy/config.staging.yaml": "server:\n  addr: \":8181\"\n",
+	})
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Profile() != "staging" {
+		t.Errorf("Profile() = %q, want staging", cfg.Profile())
+	}
+	if cfg.Server.Addr != ":8181" || cfg.Vector.TopK != 5 {
+		t.Errorf("server.addr, vector.top_k = %q, %d, want the profile's :8181 and the base's 5", cfg.Server.Addr, cfg.Vector.TopK)
+	}
+}
+
+func TestProfileFromEnvironment(t *testing.T) {
+	cfg, err := loadFiles(t, map[string]string{
+		"config.yaml":     "",
+		"config.prod.yml": "vector:\n  top_k: 9\n",
+	})
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Vector.TopK == 9 {
+		t.Fatal("profile applied without being selected")
+	}
+
+	t.Setenv("LATENTIA_PROFILE", "prod")
+	cfg, err = LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig with LATENTIA_PROFILE: %v", err)
+	}
+	if cfg.Vector.TopK != 9 {
+		t.Errorf("vector.top_k = %d, want the prod profile's 9", cfg.Vector.TopK)
+	}
+}
+
+func TestUnknownProfileListsAvailable(t *testing.T) {
+	SetProfile("qa")
+	_, err := loadFiles(t, map[string]string{
+		"config.yaml":         "",
+		"config.dev.yaml":     "",
+		"config.prod.yaml":    "",
+		"config.yaml.sample":  "",
+		"config.old.bak.yaml": "",
+	})
+	if err == nil || !strings.Contains(err.Error(), `unknown profile "qa" (available: dev, prod)`) {
+		t.Errorf("LoadConfig = %v, want the available profiles", err)
+	}
+}
+
+func TestProfileNameCannotLeaveConfigDir(t *testing.T) {
+	for _, profile := range []string{
+		"../../etc/x",
+		"../outside",
+		"..",
+		"sub/dir",
+		`..\windows`,
+		"a..b",
+	} {
+		t.Run(profile, func(t *testing.T) {
+			SetProfile(profile)
+			// deploy/config.../../etc/x.yaml is etc/x.yaml, outside the
+			// config directory: it exists but must not be read
+			_, err := loadFiles(t, map[string]string{
+				"deploy/config.yaml": "",
+				"etc/x.yaml":         "server:\n  addr: \":6666\"\n",
+			})
+			if err == nil || !strings.Contains(err.Error(), "invalid profile") {
+				t.Errorf("LoadConfig with profile %q = %v, want it refused", profile, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"log/slog"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// current is the active configuration, swapped atomically on reload
+var current atomic.Pointer[Config]
+
+var (
+	defaultOnce   sync.Once
+	defaultConfig *Config
+)
+
+// Current returns the active configuration. Components that support hot
+// reload read their settings through it on every use instead of keeping a
+// copy. Before LoadConfig has run it returns the built-in defaults.
+func Current() *Config {
+	if cfg := current.Load(); cfg != nil {
+		return cfg
+	}
+	return Default()
+}
+
+// Default returns a config made of built-in defaults only
+func Default() *Config {
+	defaultOnce.Do(func() {
+		v, err := newViper()
+		if err == nil {
+			defaultConfig, err = decode(v)
+		}
+		if err != nil {
+			panic("config: built-in defaults are invalid: " + err.Error())
+		}
+	})
+	return defaultConfig
+}
+
+// Watch reloads the config whenever the base or profile file changes. The
+// merged result is validated first; an invalid file is rejected and the
+// previous config stays active. Only hot-reloadable settings (log level,
+// worker limits and schedules, scoring weights, safety rules, analysis
+// policy, ingest filters, LLM queue limits) are swapped in.
+// Changes to connection settings are logged as needing a restart.
+//
+// onReload is called after every successful swap with the previous and the
+// new active config. Watch does nothing when the config came from defaults
+// only.
+func (c *Config) Watch(onReload func(old, updated *Config)) {
+	var mu sync.Mutex
+	var pending *time.Timer
+	schedule := func(fsnotify.Event) {
+		// Editors and WriteFile truncate before writing, so coalesce the
+		// burst of events and only read once the file has settled
+		mu.Lock()
+		defer mu.Unlock()
+		if pending != nil {
+			pending.Stop()
+		}
+		pending = time.AfterFunc(reloadDebounce, func() { c.reload(onReload) })
+	}
+
+	for _, file := range []string{c.file, c.profileFile} {
+		if file == "" {
+			continue
+		}
+		watcher := viper.New()
+		watcher.SetConfigFile(file)
+		watcher.OnConfigChange(schedule)
+		watcher.WatchConfig()
+	}
+}
+
+// reloadDebounce is how long the file must stay unchanged before a reload
+const reloadDebounce = 250 * time.Millisecond
+
+var reloadMu sync.Mutex
+
+// reload re-reads the config files and swaps in their hot-reloadable settings
+func (c *Config) reload(onReload func(old, updated *Config)) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	// Re-read into a fresh instance so parse errors are visible and a
+	// half-written file never leaks into the active config
+	next, err := c.readAgain()
+	if err != nil {
+		slog.Error("config reload rejected, keeping previous config", "file", c.file, "profile", c.profile, "error", err)
+		return
+	}
+
+	old := Current()
+	for _, key := range restartRequired(old, next) {
+		slog.Warn("config change requires restart to take effect", "key", key)
+	}
+
+	updated := *old
+	updated.Log = next.Log
+	updated.Worker = next.Worker
+	updated.Scoring = next.Scoring
+	updated.Safety = next.Safety
+	updated.Analysis = next.Analysis
+	updated.Schedules = next.Schedules
+	updated.Ingest.SlowQueryInterval = next.Ingest.SlowQueryInterval
+	updated.Ingest.Filters = next.Ingest.Filters
+	updated.Notify = next.Notify
+	updated.LLM.Queue = next.LLM.Queue
+	if reflect.DeepEqual(&updated, old) {
+		return
+	}
+
+	current.Store(&updated)
+	slog.Info("config reloaded", "file", c.file, "profile", c.profile)
+	if onReload != nil {
+		onReload(old, &updated)
+	}
+}
+
+// readAgain reads the same base and profile files LoadConfig found
+func (c *Config) readAgain() (*Config, error) {
+	v, err := newViper()
+	if err != nil {
+		return nil, err
+	}
+	for i, file := range []string{c.file, c.profileFile} {
+		if file == "" {
+			continue
+		}
+		v.SetConfigFile(file)
+		if i == 0 {
+			err = v.ReadInConfig()
+		} else {
+			err = v.MergeInConfig()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return decode(v)
+}
+
+// restartRequired lists the changed settings that cannot be applied live
+func restartRequired(old, next *Config) []string {
+	var keys []string
+	check := func(key string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			keys = append(keys, key)
+		}
+	}
+	check("server", old.Server, next.Server)
+	check("db", old.DB, next.DB)
+	check("llm.embedder", old.LLM.Embedder, next.LLM.Embedder)
+	check("llm.generator", old.LLM.Generator, next.LLM.Generator)
+	check("llm.templates_dir", old.LLM.TemplatesDir, next.LLM.TemplatesDir)
+	check("ingest.docs", old.Ingest.Docs, next.Ingest.Docs)
+	check("vector", old.Vector, next.Vector)
+	check("tracing", old.Tracing, next.Tracing)
+	// Only the level is applied live; the handler is built once at startup
+	oldLog, nextLog := old.Log, next.Log
+	oldLog.Level, nextLog.Level = "", ""
+	check("log", oldLog, nextLog)
+	return keys
+}
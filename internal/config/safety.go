@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// compileForbidPattern compiles a forbid_patterns entry. Patterns are
+// regular expressions matched case-insensitively against the whole statement.
+func compileForbidPattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q: %w", pattern, err)
+	}
+	return re, nil
+}
+
+// ForbidRegexps returns the compiled forbid_patterns. Configs produced by
+// LoadConfig have them compiled already; others compile them on demand and
+// skip invalid entries, which Validate reports.
+func (s SafetyConfig) ForbidRegexps() []*regexp.Regexp {
+	if s.forbid != nil || len(s.ForbidPatterns) == 0 {
+		return s.forbid
+	}
+	return compileForbidPatterns(s.ForbidPatterns)
+}
+
+func compileForbidPatterns(patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		if re, err := compileForbidPattern(pattern); err == nil {
+			compiled = append(compiled, re)
+		}
+	}
+	return compiled
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Secret sources, in precedence order
+const (
+	SecretFromValue = "api_key"
+	SecretFromFile  = "api_key_file"
+	SecretFromEnv   = "api_key_env"
+)
+
+// ResolvedAPIKey returns the key found by LoadConfig using the precedence
+// api_key > api_key_file > api_key_env
+func (p ProviderConfig) ResolvedAPIKey() string {
+	if p.resolvedKey != "" {
+		return p.resolvedKey
+	}
+	return p.APIKey
+}
+
+// APIKeySource reports which setting supplied the resolved key ("" if none)
+func (p ProviderConfig) APIKeySource() string {
+	return p.keySource
+}
+
+// resolveSecrets reads file-based secrets and resolves provider API keys.
+// Errors are returned as field errors so they are reported together with the
+// rest of the validation.
+func (c *Config) resolveSecrets() []FieldError {
+	var errs []FieldError
+
+	for _, p := range []struct {
+		path     string
+		provider *ProviderConfig
+	}{
+		{"llm.embedder", &c.LLM.Embedder},
+		{"llm.generator", &c.LLM.Generator},
+	} {
+		if err := p.provider.resolveAPIKey(p.path); err != nil {
+			errs = append(errs, *err)
+		}
+	}
+
+	for i := range c.Server.Auth.Keys {
+		if err := c.Server.Auth.Keys[i].resolveKey(fmt.Sprintf("server.auth.keys[%d]", i)); err != nil {
+			errs = append(errs, *err)
+		}
+	}
+
+	if c.DB.PasswordFile != "" {
+		password, err := readSecretFile(c.DB.PasswordFile)
+		if err != nil {
+			errs = append(errs, FieldError{Path: "db.password_file", Message: err.Error()})
+		} else {
+			c.DB.applyPassword(password)
+		}
+	}
+
+	return errs
+}
+
+// applyPassword sets password in every DSN whose user is that of the app
+// tables' DSN, so split and multi-target setups sharing one account get it
+// everywhere. A DSN of another user keeps its own password, and an
+// unparsable one is reported by Validate.
+func (c *DBConfig) applyPassword(password string) {
+	app, err := mysql.ParseDSN(c.AppDataSource())
+	if err != nil {
+		return
+	}
+	user := app.User
+	dsns := []*string{&c.DSN, &c.AppDSN, &c.TargetDSN}
+	for i := range c.Targets {
+		dsns = append(dsns, &c.Targets[i].DSN)
+	}
+	for _, dsn := range dsns {
+		if parsed, err := mysql.ParseDSN(*dsn); *dsn != "" && err == nil && parsed.User == user {
+			parsed.Passwd = password
+			*dsn = parsed.FormatDSN()
+		}
+	}
+}
+
+func (p *ProviderConfig) resolveAPIKey(path string) *FieldError {
+	switch {
+	case p.APIKey != "":
+		p.resolvedKey, p.keySource = p.APIKey, SecretFromValue
+	case p.APIKeyFile != "":
+		key, err := readSecretFile(p.APIKeyFile)
+		if err != nil {
+			return &FieldError{Path: path + ".api_key_file", Message: err.Error()}
+		}
+		p.resolvedKey, p.keySource = key, SecretFromFile
+	case p.APIKeyEnv != "" && os.Getenv(p.APIKeyEnv) != "":
+		p.resolvedKey, p.keySource = os.Getenv(p.APIKeyEnv), SecretFromEnv
+	}
+
+	if p.resolvedKey == "" && !containsString(keylessProviders, p.Provider) && containsString(append(EmbedderProviders, GeneratorProviders...), p.Provider) {
+		checked := "api_key, api_key_file, api_key_env"
+		if p.APIKeyEnv != "" {
+			checked = fmt.Sprintf("api_key, api_key_file, api_key_env (%s is unset)", p.APIKeyEnv)
+		}
+		return &FieldError{
+			Path:    path + ".api_key",
+			Message: fmt.Sprintf("no API key for provider '%s'; checked in order: %s", p.Provider, checked),
+		}
+	}
+	return nil
+}
+
+// readSecretFile reads a mounted secret, dropping the trailing newline most
+// tools add. An existing but unreadable file gets a permission-specific error.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrPermission):
+			return "", fmt.Errorf("secret file %s exists but is not readable by this process (permission denied)", path)
+		case errors.Is(err, fs.ErrNotExist):
+			return "", fmt.Errorf("secret file %s does not exist", path)
+		default:
+			return "", fmt.Errorf("failed to read secret file %s: %w", path, err)
+		}
+	}
+
+	secret := strings.TrimRight(string(data), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("secret file %s is empty", path)
+	}
+	return secret, nil
+}
+
+// Key returns the resolved bearer token
+func (k APIKeyConfig) Key() string {
+	return k.resolvedKey
+}
+
+func (k *APIKeyConfig) resolveKey(path string) *FieldError {
+	switch {
+	case k.APIKey != "":
+		k.resolvedKey = k.APIKey
+	case k.APIKeyFile != "":
+		key, err := readSecretFile(k.APIKeyFile)
+		if err != nil {
+			return &FieldError{Path: path + ".api_key_file", Message: err.Error()}
+		}
+		k.resolvedKey = key
+	case k.APIKeyEnv != "":
+		k.resolvedKey = os.Getenv(k.APIKeyEnv)
+	}
+
+	if strings.TrimSpace(k.resolvedKey) == "" {
+		checked := "api_key, api_key_file, api_key_env"
+		if k.APIKeyEnv != "" {
+			checked = fmt.Sprintf("api_key, api_key_file, api_key_env (%s is unset)", k.APIKeyEnv)
+		}
+		return &FieldError{Path: path + ".api_key", Message: "no key set; checked in order: " + checked}
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/spf13/viper"
+)
+
+// RedactedValue replaces secrets in displayed configuration
+const RedactedValue = "********"
+
+// SourceDefault marks a value nobody set explicitly
+const SourceDefault = "default"
+
+// secretWords mark a field as secret wherever they appear as a word of its
+// key, so api_key, admin_password or auth_token are masked but max_tokens is
+// not. Matching on the key name rather than a list of known fields means a
+// new secret field is masked without anyone remembering to add it here.
+var secretWords = []string{"apikey", "password", "passwd", "secret", "token", "webhook"}
+
+// Leaf is a single resolved configuration value
+type Leaf struct {
+	Key    string `json:"key"`
+	Value  any    `json:"value"`
+	Source string `json:"source"`
+}
+
+// Leaves returns every leaf of the configuration in declaration order with
+// secrets masked, each annotated with where its value came from
+func (c *Config) Leaves() []Leaf {
+	var leaves []Leaf
+	walkLeaves(reflect.ValueOf(*c), "", func(key, name string, v reflect.Value) {
+		leaves = append(leaves, Leaf{Key: key, Value: displayValue(name, v), Source: c.Source(key)})
+	})
+
+	// Keys may be resolved from elsewhere than the api_key field itself
+	providers := map[string]ProviderConfig{
+		"llm.embedder.api_key":  c.LLM.Embedder,
+		"llm.generator.api_key": c.LLM.Generator,
+	}
+	for i := range leaves {
+		if p, ok := providers[leaves[i].Key]; ok {
+			if p.ResolvedAPIKey() != "" {
+				leaves[i].Value = RedactedValue
+			}
+			switch p.APIKeySource() {
+			case SecretFromFile:
+				leaves[i].Source = "file " + p.APIKeyFile
+			case SecretFromEnv:
+				leaves[i].Source = "env " + p.APIKeyEnv
+			}
+		}
+		if leaves[i].Key == "db.dsn" && c.DB.PasswordFile != "" {
+			leaves[i].Source += " (password from " + c.DB.PasswordFile + ")"
+		}
+	}
+	return leaves
+}
+
+// Redacted returns the configuration as nested maps keyed like the config
+// file, with secrets masked. With provenance, each leaf becomes
+// {"value": ..., "source": ...}.
+func (c *Config) Redacted(provenance bool) map[string]any {
+	tree := map[string]any{}
+	for _, leaf := range c.Leaves() {
+		node := tree
+		parts := strings.Split(leaf.Key, ".")
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := node[part].(map[string]any)
+			if !ok {
+				child = map[string]any{}
+				node[part] = child
+			}
+			node = child
+		}
+
+		var value any = leaf.Value
+		if provenance {
+			value = map[string]any{"value": leaf.Value, "source": leaf.Source}
+		}
+		node[parts[len(parts)-1]] = value
+	}
+	return tree
+}
+
+// Source reports where the value of a leaf key came from: an environment
+// variable, the profile or base config file, or the defaults
+func (c *Config) Source(key string) string {
+	if source, ok := c.sources[key]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+// loadSources records which leaf keys were set by each config file and by
+// the environment, later sources overriding earlier ones like viper does
+func loadSources(files ...string) map[string]string {
+	keys := configKeys(reflect.TypeOf(Config{}), "")
+	sources := map[string]string{}
+
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		fv := viper.New()
+		fv.SetConfigFile(file)
+		if err := fv.ReadInConfig(); err != nil {
+			continue
+		}
+		for _, key := range keys {
+			if fv.IsSet(key) {
+				sources[key] = file
+			}
+		}
+	}
+
+	for _, key := range keys {
+		if name := envVarName(key); os.Getenv(name) != "" {
+			sources[key] = "env " + name
+		}
+	}
+	return sources
+}
+
+// envVarName is the environment variable bindEnvs binds key to
+func envVarName(key string) string {
+	return "LATENTIA_" + strings.ToUpper(envKeyReplacer.Replace(key))
+}
+
+// walkLeaves calls fn for every leaf field of v, following the same rules as
+// configKeys
+func walkLeaves(v reflect.Value, prefix string, fn func(key, name string, v reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := fieldName(f)
+		if name == "" {
+			continue
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		if f.Type.Kind() == reflect.Struct && f.Type.String() != "time.Time" {
+			walkLeaves(v.Field(i), key, fn)
+			continue
+		}
+		fn(key, name, v.Field(i))
+	}
+}
+
+// fieldName returns the config key of an exported field, "" when it has none
+func fieldName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	name := strings.Split(f.Tag.Get("mapstructure"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// displayValue converts a field to a plain value for output, masking it when
+// its name marks it as secret
+func displayValue(name string, v reflect.Value) any {
+	if isSecretName(name) {
+		return redact(v)
+	}
+	if (name == "dsn" || strings.HasSuffix(name, "_dsn")) && v.Kind() == reflect.String {
+		return redactDSN(v.String())
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return displayValue(name, v.Elem())
+	case reflect.Struct:
+		out := map[string]any{}
+		for i := 0; i < v.NumField(); i++ {
+			if field := fieldName(v.Type().Field(i)); field != "" {
+				out[field] = displayValue(field, v.Field(i))
+			}
+		}
+		return out
+	case reflect.Slice:
+		if v.IsNil() {
+			return []any{}
+		}
+		out := make([]any, v.Len())
+		for i := range out {
+			out[i] = displayValue(name, v.Index(i))
+		}
+		return out
+	case reflect.Map:
+		out := map[string]any{}
+		iter := v.MapRange()
+		for iter.Next() {
+			key := iter.Key().String()
+			out[key] = displayValue(key, iter.Value())
+		}
+		return out
+	}
+
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	return v.Interface()
+}
+
+// isSecretName reports whether a key holds a secret. Keys naming where a
+// secret lives (api_key_env, password_file) are not secrets themselves.
+func isSecretName(name string) bool {
+	name = strings.ToLower(name)
+	if strings.HasSuffix(name, "_env") || strings.HasSuffix(name, "_file") {
+		return false
+	}
+	// Join api_key(s) into a single word before splitting
+	name = strings.NewReplacer("api_keys", "apikey", "api_key", "apikey").Replace(name)
+	for _, word := range strings.Split(name, "_") {
+		if containsString(secretWords, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact masks every non-empty value so unset secrets still show as unset
+func redact(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Slice:
+		out := make([]any, v.Len())
+		for i := range out {
+			out[i] = redact(v.Index(i))
+		}
+		return out
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	if v.IsZero() {
+		return ""
+	}
+	return RedactedValue
+}
+
+// redactDSN masks the password of a MySQL DSN, or the whole DSN when it
+// cannot be parsed
+func redactDSN(dsn string) string {
+	if dsn == "" {
+		return ""
+	}
+	parsed, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return RedactedValue
+	}
+	if parsed.Passwd != "" {
+		parsed.Passwd = RedactedValue
+	}
+	return parsed.FormatDSN()
+}
@@ -0,0 +1,602 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/matthieukhl/latentia/internal/logging"
+	"github.com/matthieukhl/latentia/internal/prompts"
+	"github.com/matthieukhl/latentia/internal/schedule"
+	"github.com/matthieukhl/latentia/internal/tracing"
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// Supported provider names, kept in sync with internal/llm/factory.go
+var (
+	EmbedderProviders  = []string{"openai", "azure-openai", "cohere", "voyage", "ollama", "mock"}
+	GeneratorProviders = []string{"openai", "azure-openai", "anthropic", "gemini", "ollama", "mock"}
+
+	// keylessProviders run without an API key
+	keylessProviders = []string{"ollama", "mock"}
+
+	// MockFailures are the outcomes llm.mock can simulate, kept in sync
+	// with internal/llm/generate/mock.go
+	MockFailures = []string{"ok", "rate_limit", "malformed", "truncated", "hang"}
+)
+
+// MinSafeAutoAccept is the lowest auto-accept threshold allowed without
+// analysis.allow_low_auto_accept
+const MinSafeAutoAccept = 0.9
+
+// FieldError is a single validation failure tied to a config key path
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationError aggregates every problem found in a Config
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		lines[i] = fe.Error()
+	}
+	return fmt.Sprintf("invalid configuration (%d problem(s)):\n  %s", len(e.Errors), strings.Join(lines, "\n  "))
+}
+
+func (e *ValidationError) add(path, format string, args ...any) {
+	e.Errors = append(e.Errors, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks value types and ranges, returning a *ValidationError that
+// lists every offending field or nil when the config is usable
+func (c *Config) Validate() error {
+	v := &ValidationError{}
+
+	if c.Server.Addr == "" {
+		v.add("server.addr", "must not be empty")
+	} else if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		v.add("server.addr", "invalid listen address %q", c.Server.Addr)
+	}
+
+	if p := c.Server.BasePath; p != "" && (!strings.HasPrefix(p, "/") || strings.ContainsAny(p, " ?#")) {
+		v.add("server.base_path", "must be an absolute URL path like /latentia, got %q", p)
+	}
+	for _, t := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+	} {
+		if t.value < 0 {
+			v.add(t.key, "must be >= 0, got %v", t.value)
+		}
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		v.add("server.shutdown_timeout", "must be > 0, got %v", c.Server.ShutdownTimeout)
+	}
+	if c.Server.AnalyzeTimeout <= 0 {
+		v.add("server.analyze_timeout", "must be > 0, got %v", c.Server.AnalyzeTimeout)
+	} else if w := c.Server.WriteTimeout; w > 0 && c.Server.AnalyzeTimeout >= w {
+		v.add("server.analyze_timeout", "must be shorter than server.write_timeout (%v), got %v", w, c.Server.AnalyzeTimeout)
+	}
+	switch cert, key := c.Server.TLS.CertFile, c.Server.TLS.KeyFile; {
+	case cert != "" && key == "":
+		v.add("server.tls.key_file", "must be set when server.tls.cert_file is set")
+	case key != "" && cert == "":
+		v.add("server.tls.cert_file", "must be set when server.tls.key_file is set")
+	case cert != "":
+		if _, err := tls.LoadX509KeyPair(cert, key); err != nil {
+			v.add("server.tls", "failed to load certificate and key: %v", err)
+		}
+	}
+
+	for i, key := range c.Server.APIKeys {
+		if strings.TrimSpace(key) == "" {
+			v.add(fmt.Sprintf("server.api_keys[%d]", i), "must not be empty")
+		}
+	}
+	names := map[string]bool{}
+	for i, key := range c.Server.Auth.Keys {
+		path := fmt.Sprintf("server.auth.keys[%d]", i)
+		switch {
+		case strings.TrimSpace(key.Name) == "":
+			v.add(path+".name", "must be set")
+		case len(key.Name) > maxKeyNameLength:
+			v.add(path+".name", "must be at most %d characters", maxKeyNameLength)
+		case names[key.Name]:
+			v.add(path+".name", "duplicate key name '%s'", key.Name)
+		}
+		names[key.Name] = true
+		if !containsString(APIKeyRoles, key.Role) {
+			v.add(path+".role", "unknown value '%s' (expected one of: %s)", key.Role, strings.Join(APIKeyRoles, ", "))
+		}
+		if key.Key() == "" {
+			continue
+		}
+		for j, other := range c.Server.Auth.Keys[:i] {
+			if other.Key() == key.Key() {
+				v.add(path+".api_key", "same key as server.auth.keys[%d]; a key can only have one role", j)
+			}
+		}
+		if containsString(c.Server.APIKeys, key.Key()) {
+			v.add(path+".api_key", "also listed in server.api_keys; a key can only have one role")
+		}
+	}
+
+	if c.DB.DSN == "" && (c.DB.AppDSN == "" || c.DB.TargetDSN == "" && len(c.DB.Targets) == 0) {
+		v.add("db.dsn", "must not be empty unless db.app_dsn and db.target_dsn or db.targets are set")
+	}
+	dsns := []struct{ key, value string }{
+		{"db.dsn", c.DB.DSN}, {"db.app_dsn", c.DB.AppDSN}, {"db.target_dsn", c.DB.TargetDSN},
+	}
+	if len(c.DB.Targets) > 0 {
+		if c.DB.TargetDSN != "" {
+			v.add("db.target_dsn", "must not be set with db.targets; name it as a target instead")
+		}
+		if len(c.DB.EnabledTargets()) == 0 {
+			v.add("db.targets", "at least one target must be enabled")
+		}
+	}
+	targets := map[string]bool{}
+	for i, t := range c.DB.Targets {
+		path := fmt.Sprintf("db.targets[%d]", i)
+		switch {
+		case t.Name == "":
+			v.add(path+".name", "must be set")
+		case len(t.Name) > maxTargetNameLength:
+			v.add(path+".name", "must be at most %d characters", maxTargetNameLength)
+		case !targetName.MatchString(t.Name):
+			v.add(path+".name", "must only hold letters, digits, '_', '.' and '-', got '%s'", t.Name)
+		case targets[t.Name]:
+			v.add(path+".name", "duplicate target name '%s'", t.Name)
+		}
+		targets[t.Name] = true
+		if t.DSN == "" {
+			v.add(path+".dsn", "must be set")
+		}
+		dsns = append(dsns, struct{ key, value string }{path + ".dsn", t.DSN})
+	}
+	for _, dsn := range dsns {
+		if dsn.value == "" {
+			continue
+		}
+		if _, err := mysql.ParseDSN(dsn.value); err != nil {
+			v.add(dsn.key, "%v", err)
+		}
+	}
+	if c.DB.MaxOpenConns <= 0 {
+		// database/sql reads 0 as unlimited, which a busy worker turns into
+		// one connection per goroutine
+		v.add("db.maxOpenConns", "must be > 0, got %d", c.DB.MaxOpenConns)
+	}
+	if c.DB.MaxIdleConns < 0 {
+		v.add("db.max_idle_conns", "must be >= 0, got %d", c.DB.MaxIdleConns)
+	}
+	if c.DB.ConnMaxLifetime < 0 {
+		v.add("db.conn_max_lifetime", "must be >= 0, got %v", c.DB.ConnMaxLifetime)
+	}
+	if c.DB.ConnectRetries < 0 {
+		v.add("db.connect_retries", "must be >= 0, got %d", c.DB.ConnectRetries)
+	}
+	if c.DB.ConnectRetries > 0 && c.DB.ConnectBackoff <= 0 {
+		v.add("db.connect_backoff", "must be > 0 when db.connect_retries is set, got %v", c.DB.ConnectBackoff)
+	}
+	if c.DB.TLS.SkipVerify && c.DB.TLS.CAFile != "" {
+		v.add("db.tls.ca_file", "has no effect with db.tls.skip_verify, which verifies nothing")
+	}
+
+	validateProvider(v, "llm.embedder", c.LLM.Embedder, EmbedderProviders)
+	validateProvider(v, "llm.generator", c.LLM.Generator, GeneratorProviders)
+	if _, err := prompts.Load(c.LLM.TemplatesDir); err != nil {
+		v.add("llm.templates_dir", "%v", err)
+	}
+	if !slices.Contains(prompts.Styles, c.LLM.PromptStyle) {
+		v.add("llm.prompt_style", "must be one of %s, got '%s'", strings.Join(prompts.Styles, ", "), c.LLM.PromptStyle)
+	}
+	if c.LLM.Queue.RequestsPerMinute < 0 {
+		v.add("llm.queue.requests_per_minute", "must be >= 0, got %d", c.LLM.Queue.RequestsPerMinute)
+	}
+	if c.LLM.Queue.TokensPerMinute < 0 {
+		v.add("llm.queue.tokens_per_minute", "must be >= 0, got %d", c.LLM.Queue.TokensPerMinute)
+	}
+	if s := c.LLM.Queue.InteractiveShare; s <= 0 || s >= 1 {
+		v.add("llm.queue.interactive_share", "must be between 0 and 1 exclusive so neither class starves, got %g", s)
+	}
+	if c.LLM.Schema.MaxChars < 0 {
+		v.add("llm.schema.max_chars", "must be >= 0, got %d", c.LLM.Schema.MaxChars)
+	}
+	if c.LLM.Schema.PlanMaxBytes < 0 {
+		v.add("llm.schema.plan_max_bytes", "must be >= 0, got %d", c.LLM.Schema.PlanMaxBytes)
+	}
+	if c.LLM.Schema.CacheTTL < 0 {
+		v.add("llm.schema.cache_ttl", "must be >= 0, got %v", c.LLM.Schema.CacheTTL)
+	}
+	if c.LLM.StoreRawMaxBytes < 1 {
+		v.add("llm.store_raw_max_bytes", "must be >= 1, got %d", c.LLM.StoreRawMaxBytes)
+	}
+	if c.LLM.Mock.Latency < 0 {
+		v.add("llm.mock.latency", "must be >= 0, got %v", c.LLM.Mock.Latency)
+	}
+	if r := c.LLM.Mock.FailureRate; r < 0 || r > 1 {
+		v.add("llm.mock.failure_rate", "must be between 0 and 1, got %g", r)
+	}
+	if f := c.LLM.Mock.Failure; !containsString(MockFailures, f) {
+		v.add("llm.mock.failure", "unknown value '%s' (expected one of: %s)", f, strings.Join(MockFailures, ", "))
+	}
+	for i, f := range c.LLM.Mock.Script {
+		if !containsString(MockFailures, f) {
+			v.add(fmt.Sprintf("llm.mock.script[%d]", i), "unknown value '%s' (expected one of: %s)", f, strings.Join(MockFailures, ", "))
+		}
+	}
+
+	if c.Ingest.SlowQueryInterval != 0 && c.Ingest.SlowQueryInterval < time.Second {
+		v.add("ingest.slowquery_interval", "must be at least 1s, got %v", c.Ingest.SlowQueryInterval)
+	}
+	for i, src := range c.Ingest.Docs.Sources {
+		if src.Type != "url" {
+			v.add(fmt.Sprintf("ingest.docs.sources[%d].type", i), "must be \"url\", got %q", src.Type)
+		}
+		if src.URL == "" {
+			v.add(fmt.Sprintf("ingest.docs.sources[%d].url", i), "must not be empty")
+		} else if u, err := url.Parse(src.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.add(fmt.Sprintf("ingest.docs.sources[%d].url", i), "must be an http or https URL, got %q", src.URL)
+		}
+	}
+
+	validateIngestFilters(v, c.Ingest.Filters)
+
+	if c.Safety.MaxStmtSeconds < 0 {
+		v.add("safety.max_stmt_seconds", "must be >= 0, got %d", c.Safety.MaxStmtSeconds)
+	}
+	if c.Safety.MaxRows <= 0 {
+		v.add("safety.max_rows", "must be > 0, got %d", c.Safety.MaxRows)
+	}
+	if c.Safety.MaxMemoryMB < 0 {
+		v.add("safety.max_memory_mb", "must be >= 0, got %d", c.Safety.MaxMemoryMB)
+	}
+	for i, pattern := range c.Safety.ForbidPatterns {
+		if _, err := compileForbidPattern(pattern); err != nil {
+			v.add(fmt.Sprintf("safety.forbid_patterns[%d]", i), "%v", err)
+		}
+	}
+
+	if c.Vector.Dim <= 0 || c.Vector.Dim > 16383 {
+		v.add("vector.dim", "must be between 1 and 16383, got %d", c.Vector.Dim)
+	}
+	if c.Vector.TopK <= 0 || c.Vector.TopK > 100 {
+		v.add("vector.top_k", "must be between 1 and 100, got %d", c.Vector.TopK)
+	}
+	if c.Vector.MinScore < -1 || c.Vector.MinScore > 1 {
+		v.add("vector.min_score", "must be between -1 and 1, got %g", c.Vector.MinScore)
+	}
+	if c.Vector.KeywordWeight < 0 || c.Vector.KeywordWeight > 1 {
+		v.add("vector.keyword_weight", "must be between 0 and 1, got %g", c.Vector.KeywordWeight)
+	}
+	if c.Vector.ChunkWords < 10 || c.Vector.ChunkWords > 2000 {
+		v.add("vector.chunk_words", "must be between 10 and 2000, got %d", c.Vector.ChunkWords)
+	}
+	if c.Vector.ChunkOverlap < 0 || c.Vector.ChunkOverlap > 10 {
+		v.add("vector.chunk_overlap", "must be between 0 and 10 sentences, got %d", c.Vector.ChunkOverlap)
+	}
+	// OpenAI accepts at most 2048 inputs per embeddings request
+	if c.Vector.EmbedBatchSize < 1 || c.Vector.EmbedBatchSize > 2048 {
+		v.add("vector.embed_batch_size", "must be between 1 and 2048, got %d", c.Vector.EmbedBatchSize)
+	}
+
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		v.add("log.level", "unknown value '%s' (expected one of: %s)", c.Log.Level, strings.Join(logging.Levels, ", "))
+	}
+	if logging.ValidateFormat(c.Log.Format) != nil {
+		v.add("log.format", "unknown value '%s' (expected one of: %s)", c.Log.Format, strings.Join(logging.Formats, ", "))
+	}
+	if c.Log.MaxSizeMB < 0 {
+		v.add("log.max_size_mb", "must be >= 0, got %d", c.Log.MaxSizeMB)
+	}
+	if c.Log.MaxBackups < 0 {
+		v.add("log.max_backups", "must be >= 0, got %d", c.Log.MaxBackups)
+	}
+
+	validateNotify(v, c.Notify)
+
+	if c.Tracing.Endpoint != "" {
+		if _, err := tracing.TracesURL(c.Tracing.Endpoint); err != nil {
+			v.add("tracing.endpoint", "%v", err)
+		}
+	}
+	if c.Tracing.SampleRate < 0 || c.Tracing.SampleRate > 1 {
+		v.add("tracing.sample_rate", "must be between 0 and 1, got %g", c.Tracing.SampleRate)
+	}
+
+	if c.Worker.AnalyzeBatchSize <= 0 || c.Worker.AnalyzeBatchSize > 1000 {
+		v.add("worker.analyze_batch_size", "must be between 1 and 1000, got %d", c.Worker.AnalyzeBatchSize)
+	}
+	if c.Worker.AnalyzeMaxAttempts < 0 {
+		v.add("worker.analyze_max_attempts", "must be >= 0, got %d", c.Worker.AnalyzeMaxAttempts)
+	}
+	if c.Worker.IngestMinTime < 0 {
+		v.add("worker.ingest_min_time", "must be >= 0, got %g", c.Worker.IngestMinTime)
+	}
+	if c.Worker.IngestLimit <= 0 || c.Worker.IngestLimit > 10000 {
+		v.add("worker.ingest_limit", "must be between 1 and 10000, got %d", c.Worker.IngestLimit)
+	}
+	if c.Worker.ShutdownTimeout <= 0 {
+		v.add("worker.shutdown_timeout", "must be > 0, got %v", c.Worker.ShutdownTimeout)
+	}
+
+	if c.Scoring.Base < 0 || c.Scoring.Base > 1 {
+		v.add("scoring.base", "must be between 0 and 1, got %g", c.Scoring.Base)
+	}
+	weights := []struct {
+		key   string
+		value float64
+	}{
+		{"simple_bonus", c.Scoring.SimpleBonus},
+		{"medium_bonus", c.Scoring.MediumBonus},
+		{"complex_penalty", c.Scoring.ComplexPenalty},
+		{"anti_pattern_bonus", c.Scoring.AntiPatternBonus},
+		{"optimization_bonus", c.Scoring.OptimizationBonus},
+		{"rationale_bonus", c.Scoring.RationaleBonus},
+		{"plan_change_bonus", c.Scoring.PlanChangeBonus},
+		{"dml_penalty", c.Scoring.DMLPenalty},
+		{"critical_bonus", c.Scoring.CriticalBonus},
+		{"plan_rows_bonus", c.Scoring.PlanRowsBonus},
+		{"plan_rows_penalty", c.Scoring.PlanRowsPenalty},
+		{"full_scan_bonus", c.Scoring.FullScanBonus},
+		{"full_scan_penalty", c.Scoring.FullScanPenalty},
+		{"tables_changed_penalty", c.Scoring.TablesChangedPenalty},
+		{"semantics_change_cap", c.Scoring.SemanticsChangeCap},
+	}
+	for _, w := range weights {
+		if w.value < 0 || w.value > 1 {
+			v.add("scoring."+w.key, "must be between 0 and 1, got %g", w.value)
+		}
+	}
+
+	a := c.Analysis
+	if a.MinQueryTimeToAnalyze < 0 {
+		v.add("analysis.min_query_time_to_analyze", "must be >= 0, got %g", a.MinQueryTimeToAnalyze)
+	}
+	if a.MaxPendingRewrites < 0 {
+		v.add("analysis.max_pending_rewrites", "must be >= 0, got %d", a.MaxPendingRewrites)
+	}
+	if a.AutoRejectBelowConfidence < 0 || a.AutoRejectBelowConfidence > 1 {
+		v.add("analysis.auto_reject_below_confidence", "must be between 0 and 1, got %g", a.AutoRejectBelowConfidence)
+	}
+	if a.AutoAcceptAboveConfidence < 0 || a.AutoAcceptAboveConfidence > 1 {
+		v.add("analysis.auto_accept_above_confidence", "must be between 0 and 1, got %g", a.AutoAcceptAboveConfidence)
+	} else if a.AutoAcceptAboveConfidence > 0 && a.AutoAcceptAboveConfidence < MinSafeAutoAccept && !a.AllowLowAutoAccept {
+		v.add("analysis.auto_accept_above_confidence", "%g is below %g; set analysis.allow_low_auto_accept to use it anyway",
+			a.AutoAcceptAboveConfidence, MinSafeAutoAccept)
+	}
+	if a.AutoAcceptAboveConfidence > 0 && a.AutoRejectBelowConfidence >= a.AutoAcceptAboveConfidence {
+		v.add("analysis.auto_reject_below_confidence", "must be lower than auto_accept_above_confidence (%g)", a.AutoAcceptAboveConfidence)
+	}
+	if a.TrackingWindow < time.Hour {
+		v.add("analysis.tracking_window", "must be at least 1h, got %v", a.TrackingWindow)
+	}
+	if a.TrackingMinSamples < 1 {
+		v.add("analysis.tracking_min_samples", "must be >= 1, got %d", a.TrackingMinSamples)
+	}
+	if a.RegressionThreshold < 0 || a.RegressionThreshold >= 1 {
+		v.add("analysis.regression_threshold", "must be >= 0 and < 1, got %g", a.RegressionThreshold)
+	}
+	if a.RewriteCacheTTL < 0 {
+		v.add("analysis.rewrite_cache_ttl", "must be >= 0, got %v", a.RewriteCacheTTL)
+	}
+
+	r := c.Retention
+	ages := []struct {
+		key   string
+		value time.Duration
+	}{
+		{"slow_queries", r.SlowQueries},
+		{"rejected_rewrites", r.RejectedRewrites},
+		{"llm_usage", r.LLMUsage},
+		{"interval", r.Interval},
+	}
+	for _, age := range ages {
+		if age.value < 0 {
+			v.add("retention."+age.key, "must be >= 0, got %v", age.value)
+		}
+	}
+	if r.BatchSize <= 0 || r.BatchSize > 100000 {
+		v.add("retention.batch_size", "must be between 1 and 100000, got %d", r.BatchSize)
+	}
+
+	jobs := make([]string, 0, len(c.Schedules))
+	for job := range c.Schedules {
+		jobs = append(jobs, job)
+	}
+	sort.Strings(jobs)
+	for _, job := range jobs {
+		if _, err := schedule.Parse(c.Schedules[job]); err != nil {
+			v.add("schedules."+job, "%v", err)
+		}
+	}
+
+	if len(v.Errors) > 0 {
+		return v
+	}
+	return nil
+}
+
+// maxKeyNameLength keeps "api:<name>" within the audit log's actor column
+const maxKeyNameLength = 60
+
+// maxTargetNameLength is the size of app_slow_queries.target
+const maxTargetNameLength = 64
+
+// targetName is the form of a target name, kept plain so it reads the same
+// in URLs, flags and logs
+var targetName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func validateProvider(v *ValidationError, path string, p ProviderConfig, allowed []string) {
+	if p.Provider == "" {
+		v.add(path+".provider", "must be set (one of: %s)", strings.Join(allowed, ", "))
+		return
+	}
+	if !containsString(allowed, p.Provider) {
+		v.add(path+".provider", "unknown value '%s' (expected one of: %s)", p.Provider, strings.Join(allowed, ", "))
+		return
+	}
+	if p.Provider != "mock" && p.Model == "" {
+		v.add(path+".model", "must be set for provider '%s'", p.Provider)
+	}
+	if p.BaseURL != "" && !isHTTPURL(p.BaseURL) {
+		v.add(path+".base_url", "must be an http(s) URL, got '%s'", p.BaseURL)
+	}
+	if p.Provider == "azure-openai" && p.BaseURL == "" {
+		v.add(path+".base_url", "must be set to the resource endpoint for provider 'azure-openai', such as https://{resource}.openai.azure.com")
+	}
+	if p.Timeout < time.Second || p.Timeout > 30*time.Minute {
+		v.add(path+".timeout", "must be between 1s and 30m, got %v", p.Timeout)
+	}
+	if p.MaxRetries < 0 || p.MaxRetries > 10 {
+		v.add(path+".max_retries", "must be between 0 and 10, got %d", p.MaxRetries)
+	}
+	if p.RequestTimeout < 0 {
+		v.add(path+".request_timeout", "must be >= 0, got %v", p.RequestTimeout)
+	}
+	if p.MaxTokens < 0 || p.MaxTokens > 200000 {
+		v.add(path+".max_tokens", "must be between 0 and 200000, got %d", p.MaxTokens)
+	}
+	if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > 2) {
+		v.add(path+".temperature", "must be between 0 and 2, got %g", *p.Temperature)
+	}
+	if p.InputPricePerMTok < 0 {
+		v.add(path+".input_price_per_mtok", "must be >= 0, got %g", p.InputPricePerMTok)
+	}
+	if p.OutputPricePerMTok < 0 {
+		v.add(path+".output_price_per_mtok", "must be >= 0, got %g", p.OutputPricePerMTok)
+	}
+	if p.RequestsPerMinute < 0 {
+		v.add(path+".requests_per_minute", "must be >= 0, got %d", p.RequestsPerMinute)
+	}
+	if p.TokensPerMinute < 0 {
+		v.add(path+".tokens_per_minute", "must be >= 0, got %d", p.TokensPerMinute)
+	}
+	if p.Dimensions < 0 {
+		v.add(path+".dimensions", "must be >= 0, got %d", p.Dimensions)
+	} else if p.Dimensions > 0 && p.Provider != "cohere" && p.Provider != "voyage" {
+		v.add(path+".dimensions", "is only supported by providers cohere and voyage, not '%s'", p.Provider)
+	}
+}
+
+// validateNotify checks URLs, event names and thresholds of the notify section
+func validateNotify(v *ValidationError, n NotifyConfig) {
+	if n.UIURL != "" && !isHTTPURL(n.UIURL) {
+		v.add("notify.ui_url", "must be an http(s) URL, got '%s'", n.UIURL)
+	}
+	if n.Retries < 0 || n.Retries > 10 {
+		v.add("notify.retries", "must be between 0 and 10, got %d", n.Retries)
+	}
+
+	for path, target := range map[string]NotifierConfig{"notify.slack": n.Slack, "notify.webhook": n.Webhook} {
+		if target.WebhookURL != "" && !isHTTPURL(target.WebhookURL) {
+			// The URL embeds a token, so it is not echoed back
+			v.add(path+".webhook_url", "must be an http(s) URL")
+		}
+		for i, u := range target.WebhookURLs {
+			if !isHTTPURL(u) {
+				v.add(fmt.Sprintf("%s.webhook_urls[%d]", path, i), "must be an http(s) URL")
+			}
+		}
+		for i, event := range target.Events {
+			if !containsString(NotifyEvents, event) {
+				v.add(fmt.Sprintf("%s.events[%d]", path, i), "unknown event '%s' (expected one of: %s)", event, strings.Join(NotifyEvents, ", "))
+			}
+		}
+		if target.MinConfidence < 0 || target.MinConfidence > 1 {
+			v.add(path+".min_confidence", "must be between 0 and 1, got %g", target.MinConfidence)
+		}
+		for event := range target.Channels {
+			if !containsString(NotifyEvents, event) {
+				v.add(path+".channels."+event, "unknown event (expected one of: %s)", strings.Join(NotifyEvents, ", "))
+			}
+		}
+	}
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// validateIngestFilters rejects invalid patterns and filters that would
+// exclude everything
+func validateIngestFilters(v *ValidationError, f IngestFilters) {
+	const path = "ingest.filters"
+
+	if f.MinQueryTime < 0 {
+		v.add(path+".min_query_time", "must be >= 0, got %g", f.MinQueryTime)
+	}
+
+	var conflicting []string
+	for _, db := range f.IncludeDatabases {
+		if containsFold(f.ExcludeDatabases, db) {
+			conflicting = append(conflicting, db)
+		}
+	}
+	switch {
+	case len(f.IncludeDatabases) > 0 && len(conflicting) == len(f.IncludeDatabases):
+		v.add(path+".exclude_databases", "excludes every database in include_databases; nothing would be ingested")
+	case len(conflicting) > 0:
+		v.add(path+".exclude_databases", "conflicts with include_databases: %s", strings.Join(conflicting, ", "))
+	}
+
+	for i, pattern := range f.ExcludeDigestPatterns {
+		re, err := CompileDigestPattern(pattern)
+		if err != nil {
+			v.add(fmt.Sprintf("%s.exclude_digest_patterns[%d]", path, i), "%v", err)
+		} else if re.MatchString("") {
+			v.add(fmt.Sprintf("%s.exclude_digest_patterns[%d]", path, i), "%q matches every digest; nothing would be ingested", pattern)
+		}
+	}
+}
+
+// Options converts the tuning fields into provider constructor options
+func (p ProviderConfig) Options() types.ProviderOptions {
+	return types.ProviderOptions{
+		Timeout:     p.Timeout,
+		MaxRetries:  p.MaxRetries,
+		MaxTokens:   p.MaxTokens,
+		Temperature: p.Temperature,
+		Dimensions:  p.Dimensions,
+	}
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
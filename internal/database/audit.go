@@ -0,0 +1,225 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// AuditTable records every review decision on a rewrite, human or automated
+const AuditTable = "app_audit_log"
+
+// Audit actors: API requests are recorded as "api:<key>", CLI commands as
+// "cli:<user>"
+const (
+	ActorPolicy  = "policy"
+	ActorUnknown = "unknown"
+)
+
+// Audit actions
+const (
+	ActionAccept = "accept"
+	ActionReject = "reject"
+	ActionRetry  = "retry"
+)
+
+const auditTableDDL = `CREATE TABLE IF NOT EXISTS app_audit_log (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    actor VARCHAR(64) NOT NULL,
+    action VARCHAR(64) NOT NULL,
+    rewrite_id BIGINT NULL,
+    slow_query_id BIGINT NULL,
+    reason VARCHAR(512) NULL,
+    details JSON NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_actor (actor),
+    INDEX idx_rewrite_id (rewrite_id),
+    INDEX idx_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+// AuditEntry is a single audit log record
+type AuditEntry struct {
+	ID          int64          `json:"id"`
+	Actor       string         `json:"actor"`
+	Action      string         `json:"action"`
+	RewriteID   int64          `json:"rewrite_id,omitempty"`
+	SlowQueryID int64          `json:"slow_query_id,omitempty"`
+	Reason      string         `json:"reason,omitempty"`
+	Details     map[string]any `json:"details,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+}
+
+type actorKey struct{}
+
+type roleKey struct{}
+
+// WithActor returns a context attributing audited changes made with it to actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set by WithActor, ActorUnknown if none
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return ActorUnknown
+}
+
+// WithRole returns a context recording the role of the API key behind the
+// actor in audit details
+func WithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+// RoleFromContext returns the role set by WithRole, "" if none
+func RoleFromContext(ctx context.Context) string {
+	role, _ := ctx.Value(roleKey{}).(string)
+	return role
+}
+
+// CLIActor identifies the operating system user running a command
+func CLIActor() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		name = u.Username
+	}
+	if name == "" {
+		return "cli"
+	}
+	return "cli:" + name
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// RecordAudit appends an entry to the audit log. Zero IDs are stored as NULL.
+func (db *DB) RecordAudit(ctx context.Context, entry AuditEntry) error {
+	return recordAudit(ctx, db, entry)
+}
+
+// RecordAuditTx appends an entry within tx, so it is only kept if the change
+// it describes is committed
+func RecordAuditTx(ctx context.Context, tx *sql.Tx, entry AuditEntry) error {
+	return recordAudit(ctx, tx, entry)
+}
+
+func recordAudit(ctx context.Context, exec execer, entry AuditEntry) error {
+	if role := RoleFromContext(ctx); role != "" {
+		withRole := map[string]any{"role": role}
+		for k, v := range entry.Details {
+			withRole[k] = v
+		}
+		entry.Details = withRole
+	}
+
+	var details sql.NullString
+	if len(entry.Details) > 0 {
+		data, err := json.Marshal(entry.Details)
+		if err != nil {
+			return fmt.Errorf("failed to serialize audit details: %w", err)
+		}
+		details = sql.NullString{String: string(data), Valid: true}
+	}
+	if entry.Actor == "" {
+		entry.Actor = ActorFromContext(ctx)
+	}
+
+	_, err := exec.ExecContext(ctx, `
+		INSERT INTO app_audit_log (actor, action, rewrite_id, slow_query_id, reason, details)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		entry.Actor, entry.Action, nullID(entry.RewriteID), nullID(entry.SlowQueryID),
+		sql.NullString{String: entry.Reason, Valid: entry.Reason != ""}, details)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+// AuditFilter selects audit entries; zero fields match everything
+type AuditFilter struct {
+	Actor     string
+	Action    string
+	RewriteID int64
+	// RewriteIDs matches entries of any of the rewrites
+	RewriteIDs []int64
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+}
+
+// DefaultAuditLimit caps ListAudit when the filter sets no limit
+const DefaultAuditLimit = 100
+
+// ListAudit returns matching audit entries, most recent first
+func (db *DB) ListAudit(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	var where []string
+	var args []any
+	if filter.Actor != "" {
+		where, args = append(where, "actor = ?"), append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		where, args = append(where, "action = ?"), append(args, filter.Action)
+	}
+	if filter.RewriteID != 0 {
+		where, args = append(where, "rewrite_id = ?"), append(args, filter.RewriteID)
+	}
+	if len(filter.RewriteIDs) > 0 {
+		in, ids := inClause(filter.RewriteIDs)
+		where, args = append(where, "rewrite_id IN "+in), append(args, ids...)
+	}
+	if !filter.Since.IsZero() {
+		where, args = append(where, "created_at >= ?"), append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		where, args = append(where, "created_at < ?"), append(args, filter.Until)
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultAuditLimit
+	}
+
+	query := `
+		SELECT id, actor, action, COALESCE(rewrite_id, 0), COALESCE(slow_query_id, 0),
+		       COALESCE(reason, ''), COALESCE(details, '{}'), created_at
+		FROM app_audit_log`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id DESC LIMIT ?"
+
+	rows, err := db.QueryContext(ctx, query, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var details string
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.RewriteID,
+			&entry.SlowQueryID, &entry.Reason, &details, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		if err := json.Unmarshal([]byte(details), &entry.Details); err != nil {
+			return nil, fmt.Errorf("failed to parse audit details: %w", err)
+		}
+		if len(entry.Details) == 0 {
+			entry.Details = nil
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
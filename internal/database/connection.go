@@ -1,36 +1,349 @@
 package database
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/safety"
 )
 
+// ErrTargetReadOnly is returned by ExecSafe for a statement that is not
+// read-only while db.read_only is set
+var ErrTargetReadOnly = errors.New("the target database is read-only (db.read_only)")
+
+// ErrUnknownTarget is returned by ForTarget for a name that is not an
+// enabled entry of db.targets
+var ErrUnknownTarget = errors.New("unknown target")
+
+// ErrNeverConnected is returned when a database could not be reached at
+// all, and ErrConnectionLost when one that was reached no longer answers
+var (
+	ErrNeverConnected = errors.New("never connected")
+	ErrConnectionLost = errors.New("connection lost")
+)
+
+// DB is the connection to the app tables, which its methods use, and,
+// through TargetDB, to the database whose queries are analyzed. Both are
+// the same pool unless db.app_dsn or db.target_dsn sets them apart. With
+// db.targets, TargetDB is the first enabled target and ForTarget returns a
+// DB on another one.
+//
+// Its ExecContext, QueryContext and QueryRowContext bound a statement whose
+// context has no deadline, such as the context.Background() of a CLI
+// command, by safety.max_stmt_seconds, so a stalled statement cannot hang
+// its caller.
 type DB struct {
 	*sql.DB
+
+	target     *sql.DB
+	targetName string
+	readOnly   bool
+
+	// targets are the enabled targets in order, shared by every DB
+	// returned by ForTarget
+	targets []*targetPool
+
+	// appReached holds when the app pool last answered a ping, in Unix
+	// nanoseconds
+	appReached *atomic.Int64
 }
 
-// NewConnection creates a new database connection using the provided config
+// targetPool is the connection to one target, the app pool itself when
+// they share a DSN
+type targetPool struct {
+	name    string
+	role    string
+	pool    *sql.DB
+	reached *atomic.Int64
+}
+
+// NewConnection connects to the app and target databases, retrying with
+// backoff up to db.connect_retries times while they cannot be reached, as
+// when TiDB Serverless resumes from idle
 func NewConnection(cfg *config.DBConfig) (*DB, error) {
-	db, err := sql.Open("mysql", cfg.DSN)
+	if err := registerTLS(cfg.TLS); err != nil {
+		return nil, err
+	}
+	app, err := open("app", cfg.AppDataSource(), cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, err
 	}
-	
+	db := &DB{DB: app, readOnly: cfg.ReadOnly, appReached: reachedNow()}
+	byDSN := map[string]*targetPool{}
+	for _, t := range cfg.EnabledTargets() {
+		tp := &targetPool{name: t.Name, role: "target", pool: app, reached: db.appReached}
+		if len(cfg.Targets) > 0 {
+			tp.role = "target " + t.Name
+		}
+		if shared, ok := byDSN[t.DSN]; ok {
+			tp.pool, tp.reached = shared.pool, shared.reached
+		} else if t.DSN != cfg.AppDataSource() {
+			pool, err := open(tp.role, t.DSN, cfg)
+			if err != nil {
+				db.Close()
+				return nil, err
+			}
+			tp.pool, tp.reached = pool, reachedNow()
+		}
+		byDSN[t.DSN] = tp
+		db.targets = append(db.targets, tp)
+	}
+	db.target, db.targetName = db.targets[0].pool, db.targets[0].name
+	return db, nil
+}
+
+func reachedNow() *atomic.Int64 {
+	reached := new(atomic.Int64)
+	reached.Store(time.Now().UnixNano())
+	return reached
+}
+
+// pingTimeout bounds the ping checking a new connection
+const pingTimeout = 10 * time.Second
+
+// maxConnectBackoff caps the wait between two connection attempts
+const maxConnectBackoff = 30 * time.Second
+
+func open(role, dsn string, cfg *config.DBConfig) (*sql.DB, error) {
+	if cfg.TLS.Enabled() {
+		parsed, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s DSN: %w", role, err)
+		}
+		parsed.TLSConfig = tlsConfigName
+		dsn = parsed.FormatDSN()
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s database: %w", role, err)
+	}
+
 	// Configure connection pool
 	db.SetMaxOpenConns(cfg.MaxOpenConns)
-	
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
 	// Test connection
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+	backoff := cfg.ConnectBackoff
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		err = db.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return db, nil
+		}
+		if attempt >= cfg.ConnectRetries || !retryableConnect(err) {
+			db.Close()
+			return nil, fmt.Errorf("failed to ping %s database (%w, attempts: %d): %v",
+				role, ErrNeverConnected, attempt+1, err)
+		}
+		slog.Warn("database not reachable, retrying", "database", role, "attempt", attempt+1, "retry_in", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxConnectBackoff)
+	}
+}
+
+// retryableConnect reports whether a failed ping may succeed later; a
+// server refusing the credentials or the database will not change its mind
+func retryableConnect(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1044, 1045, 1049: // access denied to the database, bad credentials, unknown database
+			return false
+		}
+	}
+	return true
+}
+
+// tlsConfigName is the name db.tls is registered under with the driver
+const tlsConfigName = "latentia"
+
+// registerTLS registers db.tls with the driver when it is set
+func registerTLS(t config.DBTLSConfig) error {
+	if !t.Enabled() {
+		return nil
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.SkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read db.tls.ca_file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("db.tls.ca_file %s holds no PEM certificate", t.CAFile)
+		}
 	}
-	
-	return &DB{db}, nil
+	return mysql.RegisterTLSConfig(tlsConfigName, tlsConfig)
+}
+
+// ExecContext runs a statement on the app tables, bounded by
+// statementContext
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, cancel := statementContext(ctx)
+	defer cancel()
+	return db.DB.ExecContext(ctx, query, args...)
+}
+
+// QueryContext runs a query on the app tables, bounded by statementContext.
+// The deadline also covers reading the rows, which outlive this call, so it
+// is released when it passes rather than when they are closed.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, _ = statementContext(ctx)
+	return db.DB.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext runs a query expected to return at most one row on the
+// app tables, bounded by statementContext like QueryContext
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, _ = statementContext(ctx)
+	return db.DB.QueryRowContext(ctx, query, args...)
 }
 
-// HealthCheck performs a simple health check on the database
-func (db *DB) HealthCheck() error {
-	return db.Ping()
-}
\ No newline at end of file
+type unboundedKey struct{}
+
+// withoutStatementTimeout marks ctx so that statementContext leaves the
+// statements run with it unbounded, for migrations, whose DDL may take as
+// long as the tables are large
+func withoutStatementTimeout(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unboundedKey{}, true)
+}
+
+// statementContext gives ctx the safety.max_stmt_seconds deadline when it
+// has none of its own; a caller's deadline, even a longer one, is kept
+func statementContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := safety.StatementTimeout()
+	if _, ok := ctx.Deadline(); ok || timeout <= 0 || ctx.Value(unboundedKey{}) != nil {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// AppDB returns the pool of the app tables
+func (db *DB) AppDB() *sql.DB {
+	return db.DB
+}
+
+// TargetDB returns the pool of the analyzed database. Statements that may
+// write must go through ExecSafe, which honours db.read_only.
+func (db *DB) TargetDB() *sql.DB {
+	return db.target
+}
+
+// Split reports whether the app tables and the analyzed database are
+// separate connections
+func (db *DB) Split() bool {
+	return db.target != db.DB
+}
+
+// TargetName returns the name of the target TargetDB connects to,
+// config.DefaultTarget without db.targets
+func (db *DB) TargetName() string {
+	return db.targetName
+}
+
+// TargetNames returns the names of the enabled targets, the default first
+func (db *DB) TargetNames() []string {
+	names := make([]string, len(db.targets))
+	for i, t := range db.targets {
+		names[i] = t.name
+	}
+	return names
+}
+
+// ForTarget returns a DB on the same app tables whose TargetDB is the named
+// target, "" being the default one. It shares the connections of db and
+// must not be closed.
+func (db *DB) ForTarget(name string) (*DB, error) {
+	if name == "" {
+		name = db.targets[0].name
+	}
+	for _, t := range db.targets {
+		if t.name == name {
+			view := *db
+			view.target, view.targetName = t.pool, t.name
+			return &view, nil
+		}
+	}
+	return nil, fmt.Errorf("%w '%s' (enabled: %s)", ErrUnknownTarget, name, strings.Join(db.TargetNames(), ", "))
+}
+
+// targetPools returns the target connections apart from the app one, each
+// once
+func (db *DB) targetPools() []*targetPool {
+	var pools []*targetPool
+	seen := map[*sql.DB]bool{db.DB: true}
+	for _, t := range db.targets {
+		if !seen[t.pool] {
+			seen[t.pool] = true
+			pools = append(pools, t)
+		}
+	}
+	return pools
+}
+
+// ExecSafe runs a statement on the target database, refusing any that is
+// not read-only with ErrTargetReadOnly while db.read_only is set
+func (db *DB) ExecSafe(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if db.readOnly {
+		if _, err := safety.VerifyReadOnly(query); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrTargetReadOnly, err)
+		}
+	}
+	return db.target.ExecContext(ctx, query, args...)
+}
+
+// Close closes the target connections, when separate, and the app one
+func (db *DB) Close() error {
+	var errs []error
+	for _, t := range db.targetPools() {
+		errs = append(errs, t.pool.Close())
+	}
+	return errors.Join(append(errs, db.DB.Close())...)
+}
+
+// HealthCheck pings the app database and, when separate, each target.
+// A database that stopped answering fails with ErrConnectionLost, one never
+// reached with ErrNeverConnected.
+func (db *DB) HealthCheck(ctx context.Context) error {
+	ctx, cancel := statementContext(ctx)
+	defer cancel()
+	if err := ping(ctx, "app", db.DB, db.appReached); err != nil {
+		return err
+	}
+	for _, t := range db.targetPools() {
+		if err := ping(ctx, t.role, t.pool, t.reached); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ping(ctx context.Context, role string, pool *sql.DB, reached *atomic.Int64) error {
+	if err := pool.PingContext(ctx); err != nil {
+		last := reached.Load()
+		if last == 0 {
+			return fmt.Errorf("%s database %w: %v", role, ErrNeverConnected, err)
+		}
+		ago := time.Since(time.Unix(0, last)).Round(time.Second)
+		return fmt.Errorf("%s database %w, last reached %v ago: %v", role, ErrConnectionLost, ago, err)
+	}
+	reached.Store(time.Now().UnixNano())
+	return nil
+}
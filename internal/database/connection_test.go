@@ -0,0 +1,315 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config/configtest"
+)
+
+// stalledDB is a driver whose statements run until their context is done,
+// recording each one and how long it was given, 0 for no deadline
+type stalledDB struct {
+	mu        sync.Mutex
+	deadlines []time.Duration
+	queries   []string
+	// instant answers at once instead of stalling
+	instant bool
+}
+
+func (d *stalledDB) Connect(context.Context) (driver.Conn, error) { return stalledConn{d}, nil }
+func (d *stalledDB) Driver() driver.Driver                        { return nil }
+
+// stall waits out ctx, unless the driver answers at once
+func (d *stalledDB) stall(ctx context.Context) error {
+	var left time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		left = time.Until(deadline)
+	}
+	d.mu.Lock()
+	d.deadlines = append(d.deadlines, left)
+	d.mu.Unlock()
+	if d.instant {
+		return nil
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (d *stalledDB) record(query string) {
+	d.mu.Lock()
+	d.queries = append(d.queries, query)
+	d.mu.Unlock()
+}
+
+// ran lists the statements the driver received
+func (d *stalledDB) ran() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.queries...)
+}
+
+func (d *stalledDB) given() []time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]time.Duration(nil), d.deadlines...)
+}
+
+type stalledConn struct{ db *stalledDB }
+
+func (c stalledConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c stalledConn) Close() error                        { return nil }
+func (c stalledConn) Begin() (driver.Tx, error)           { return noTx{}, nil }
+
+func (c stalledConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.record(query)
+	if err := c.db.stall(ctx); err != nil {
+		return nil, err
+	}
+	return oneRow{}, nil
+}
+
+func (c stalledConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.record(query)
+	if err := c.db.stall(ctx); err != nil {
+		return nil, err
+	}
+	return noRows{}, nil
+}
+
+type noTx struct{}
+
+func (noTx) Commit() error   { return nil }
+func (noTx) Rollback() error { return nil }
+
+// oneRow is the result of a statement that wrote row 1
+type oneRow struct{}
+
+func (oneRow) LastInsertId() (int64, error) { return 1, nil }
+func (oneRow) RowsAffected() (int64, error) { return 1, nil }
+
+type noRows struct{}
+
+func (noRows) Columns() []string         { return []string{"id"} }
+func (noRows) Close() error              { return nil }
+func (noRows) Next([]driver.Value) error { return io.EOF }
+
+// statements runs each kind of statement of db with ctx
+var statements = map[string]func(ctx context.Context, db *DB) error{
+	"ExecContext": func(ctx context.Context, db *DB) error {
+		_, err := db.ExecContext(ctx, "DELETE FROM app_rewrites")
+		return err
+	},
+	"QueryContext": func(ctx context.Context, db *DB) error {
+		rows, err := db.QueryContext(ctx, "SELECT id FROM app_rewrites")
+		if err == nil {
+			rows.Close()
+		}
+		return err
+	},
+	"QueryRowContext": func(ctx context.Context, db *DB) error {
+		var id int64
+		err := db.QueryRowContext(ctx, "SELECT id FROM app_rewrites").Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	},
+}
+
+func TestCancellationInterruptsStatement(t *testing.T) {
+	configtest.Load(t, "safety:\n  max_stmt_seconds: 60\n")
+	for name, run := range statements {
+		t.Run(name, func(t *testing.T) {
+			db := &DB{DB: sql.OpenDB(&stalledDB{})}
+			defer db.DB.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			result := make(chan error, 1)
+			go func() { result <- run(ctx, db) }()
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+
+			select {
+			case err := <-result:
+				if !errors.Is(err, context.Canceled) {
+					t.Errorf("%s = %v, want the cancellation", name, err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s still running after its context was cancelled", name)
+			}
+		})
+	}
+}
+
+func TestStatementTimeoutWithoutDeadline(t *testing.T) {
+	configtest.Load(t, "safety:\n  max_stmt_seconds: 1\n")
+	for name, run := range statements {
+		t.Run(name, func(t *testing.T) {
+			db := &DB{DB: sql.OpenDB(&stalledDB{})}
+			defer db.DB.Close()
+
+			started := time.Now()
+			result := make(chan error, 1)
+			go func() { result <- run(context.Background(), db) }()
+			select {
+			case err := <-result:
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("%s = %v, want safety.max_stmt_seconds to cut it off", name, err)
+				}
+				if elapsed := time.Since(started); elapsed < time.Second {
+					t.Errorf("%s cut off after %v, before safety.max_stmt_seconds", name, elapsed)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s without a deadline still running past safety.max_stmt_seconds", name)
+			}
+		})
+	}
+}
+
+func TestStatementContextKeepsDeadlines(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		ctx    func() (context.Context, context.CancelFunc)
+		// want is how long statements are given, 0 for no deadline
+		want time.Duration
+	}{
+		{"bounded by default", "", func() (context.Context, context.CancelFunc) {
+			return context.Background(), func() {}
+		}, 10 * time.Second},
+		{"caller deadline kept", "", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), time.Hour)
+		}, time.Hour},
+		{"no timeout configured", "safety:\n  max_stmt_seconds: 0\n", func() (context.Context, context.CancelFunc) {
+			return context.Background(), func() {}
+		}, 0},
+		{"migrations unbounded", "", func() (context.Context, context.CancelFunc) {
+			return withoutStatementTimeout(context.Background()), func() {}
+		}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configtest.Load(t, tt.config)
+			stalled := &stalledDB{instant: true}
+			db := &DB{DB: sql.OpenDB(stalled)}
+			defer db.DB.Close()
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			for name, run := range statements {
+				if err := run(ctx, db); err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+			}
+			for i, got := range stalled.given() {
+				if got > tt.want || got < tt.want-time.Second {
+					t.Errorf("statement %d given %v, want %v", i+1, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// splitDB returns a read-only DB whose app and target are separate fake
+// pools, as with db.app_dsn and db.target_dsn
+func splitDB(t *testing.T) (db *DB, app, target *stalledDB) {
+	t.Helper()
+	app, target = &stalledDB{instant: true}, &stalledDB{instant: true}
+	db = &DB{DB: sql.OpenDB(app), target: sql.OpenDB(target), targetName: "default", readOnly: true}
+	t.Cleanup(func() { db.DB.Close(); db.target.Close() })
+	return db, app, target
+}
+
+func TestExecSafeRefusesWritesOnReadOnlyTarget(t *testing.T) {
+	configtest.Load(t, "")
+	db, app, target := splitDB(t)
+	ctx := context.Background()
+
+	for _, query := range []string{
+		"UPDATE orders SET status = 'paid' WHERE id = 1",
+		"INSERT INTO orders (id) VALUES (1)",
+		"DELETE FROM orders",
+		"CREATE INDEX idx_created_at ON orders (created_at)",
+		"ALTER TABLE orders ADD COLUMN note TEXT",
+		"DROP TABLE orders",
+	} {
+		if _, err := db.ExecSafe(ctx, query); !errors.Is(err, ErrTargetReadOnly) {
+			t.Errorf("ExecSafe(%q) = %v, want ErrTargetReadOnly", query, err)
+		}
+	}
+	if got := target.ran(); len(got) != 0 {
+		t.Errorf("target received %q, want nothing", got)
+	}
+	if got := app.ran(); len(got) != 0 {
+		t.Errorf("app received %q, want nothing", got)
+	}
+
+	// Reads still reach the target
+	if _, err := db.ExecSafe(ctx, "SELECT 1"); err != nil {
+		t.Fatalf("ExecSafe(SELECT 1) = %v", err)
+	}
+	if got := target.ran(); len(got) != 1 || got[0] != "SELECT 1" {
+		t.Errorf("target received %q, want the SELECT", got)
+	}
+}
+
+func TestSplitModeWritesOnlyReachApp(t *testing.T) {
+	configtest.Load(t, "")
+	db, app, target := splitDB(t)
+	ctx := context.Background()
+
+	writes := map[string]func() error{
+		// The ingesters and the engine store through db.ExecContext
+		"slow query ingestion": func() error {
+			_, err := db.ExecContext(ctx, "INSERT INTO app_slow_queries (digest, sample_sql) VALUES (?, ?)", "d1", "SELECT 1")
+			return err
+		},
+		"slow query occurrence": func() error {
+			return db.RecordSlowQueryOccurrence(ctx, "d1", "SELECT 1", "shop", 1.5, time.Now())
+		},
+		"rewrite storage": func() error {
+			_, err := db.ExecContext(ctx, "INSERT INTO app_rewrites (slow_query_id, optimized_sql) VALUES (?, ?)", 1, "SELECT 1")
+			return err
+		},
+		"index recommendations": func() error {
+			return db.SaveIndexRecommendations(ctx, 1, []IndexRecommendation{{Table: "orders", Columns: []string{"created_at"}}})
+		},
+		"audit": func() error {
+			return db.RecordAudit(ctx, AuditEntry{Action: ActionAccept, RewriteID: 1})
+		},
+		"audit within a review": func() error {
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				return err
+			}
+			if err := RecordAuditTx(ctx, tx, AuditEntry{Action: ActionReject, RewriteID: 1, Reason: "wrong"}); err != nil {
+				tx.Rollback()
+				return err
+			}
+			return tx.Commit()
+		},
+		"llm usage": func() error {
+			return db.RecordLLMUsage(ctx, "test-mock", 10, 5, 0, time.Now())
+		},
+	}
+	for name, write := range writes {
+		before := len(app.ran())
+		if err := write(); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(app.ran()) == before {
+			t.Errorf("%s did not reach the app pool", name)
+		}
+	}
+	if got := target.ran(); len(got) != 0 {
+		t.Errorf("target received %q, want nothing", got)
+	}
+}
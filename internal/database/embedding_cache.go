@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// embeddingCacheTableDDL keeps document embeddings by the hash of their
+// model, dimension, input type and text, so that indexing unchanged text
+// again does not pay for it. The vector has no fixed dimension, as entries
+// of several models may coexist; model and dim are kept to check them.
+const embeddingCacheTableDDL = `CREATE TABLE IF NOT EXISTS app_embedding_cache (
+    hash CHAR(64) PRIMARY KEY, -- hex sha256
+    model VARCHAR(128) NOT NULL,
+    dim INT NOT NULL,
+    embedding VECTOR NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_model_dim (model, dim)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+// embeddingCacheBatch is the most rows read or written per statement
+const embeddingCacheBatch = 500
+
+// CachedEmbeddings returns the embeddings cached under hashes by model with
+// dim dimensions, by hash. Entries of another model or dimension, or whose
+// vector does not have dim dimensions, are never returned.
+func (db *DB) CachedEmbeddings(ctx context.Context, model string, dim int, hashes []string) (map[string][]float32, error) {
+	found := make(map[string][]float32, len(hashes))
+	for start := 0; start < len(hashes); start += embeddingCacheBatch {
+		batch := hashes[start:min(start+embeddingCacheBatch, len(hashes))]
+		args := make([]any, 0, len(batch)+2)
+		args = append(args, model, dim)
+		for _, hash := range batch {
+			args = append(args, hash)
+		}
+		rows, err := db.QueryContext(ctx, `
+			SELECT hash, VEC_AS_TEXT(embedding) FROM app_embedding_cache
+			WHERE model = ? AND dim = ? AND hash IN (`+strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")+`)
+		`, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read embedding cache: %w", err)
+		}
+		err = scanCachedEmbeddings(rows, dim, found)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+func scanCachedEmbeddings(rows *sql.Rows, dim int, found map[string][]float32) error {
+	for rows.Next() {
+		var hash, text string
+		if err := rows.Scan(&hash, &text); err != nil {
+			return fmt.Errorf("failed to scan cached embedding: %w", err)
+		}
+		var vector []float32
+		if err := json.Unmarshal([]byte(text), &vector); err != nil || len(vector) != dim {
+			continue
+		}
+		found[hash] = vector
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read embedding cache: %w", err)
+	}
+	return nil
+}
+
+// CacheEmbeddings stores embeddings, by hash, made by model with dim
+// dimensions; hashes already cached are left as they are
+func (db *DB) CacheEmbeddings(ctx context.Context, model string, dim int, embeddings map[string][]float32) error {
+	hashes := make([]string, 0, len(embeddings))
+	for hash := range embeddings {
+		hashes = append(hashes, hash)
+	}
+	for start := 0; start < len(hashes); start += embeddingCacheBatch {
+		batch := hashes[start:min(start+embeddingCacheBatch, len(hashes))]
+		args := make([]any, 0, 4*len(batch))
+		for _, hash := range batch {
+			vector, err := json.Marshal(embeddings[hash])
+			if err != nil {
+				return fmt.Errorf("failed to marshal embedding: %w", err)
+			}
+			args = append(args, hash, model, dim, string(vector))
+		}
+		values := strings.TrimSuffix(strings.Repeat("(?, ?, ?, VEC_FROM_TEXT(?)), ", len(batch)), ", ")
+		if _, err := db.ExecContext(ctx,
+			"INSERT IGNORE INTO app_embedding_cache (hash, model, dim, embedding) VALUES "+values, args...); err != nil {
+			return fmt.Errorf("failed to write embedding cache: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/matthieukhl/latentia/internal/safety"
+)
+
+// IdentifierAliasesTable maps schema, table, column and alias names to the
+// aliases sent to the generator under safety.anonymize_identifiers. Rows are
+// never deleted, so an identifier keeps its alias for the life of the
+// deployment.
+const IdentifierAliasesTable = "app_identifier_aliases"
+
+const identifierAliasesTableDDL = `CREATE TABLE IF NOT EXISTS app_identifier_aliases (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    kind ENUM('schema', 'table', 'column', 'alias') NOT NULL,
+    scope VARCHAR(64) NOT NULL DEFAULT '',
+    name VARCHAR(64) NOT NULL,
+    alias VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_identifier (kind, scope, name),
+    UNIQUE KEY uk_alias (alias)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+// maxAliasAttempts bounds the retries when another process allocates the
+// same alias concurrently
+const maxAliasAttempts = 3
+
+// IdentifierAliases returns the alias of every identifier, allocating the
+// next free alias of its kind, such as t4 or c17, to those seen for the first
+// time
+func (db *DB) IdentifierAliases(ctx context.Context, ids []safety.Identifier) (map[safety.Identifier]string, error) {
+	var err error
+	for attempt := 0; attempt < maxAliasAttempts; attempt++ {
+		var aliases map[safety.Identifier]string
+		aliases, err = db.identifierAliases(ctx, ids)
+		if err == nil {
+			return aliases, nil
+		}
+		var mysqlErr *mysql.MySQLError
+		if !errors.As(err, &mysqlErr) || mysqlErr.Number != 1062 {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+func (db *DB) identifierAliases(ctx context.Context, ids []safety.Identifier) (map[safety.Identifier]string, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin identifier mapping: %w", err)
+	}
+	defer tx.Rollback()
+
+	names := make([]any, 0, len(ids))
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if !seen[id.Name] {
+			seen[id.Name] = true
+			names = append(names, id.Name)
+		}
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT kind, scope, name, alias FROM app_identifier_aliases
+		WHERE name IN (?`+strings.Repeat(", ?", len(names)-1)+`)`, names...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identifier aliases: %w", err)
+	}
+	aliases := map[safety.Identifier]string{}
+	for rows.Next() {
+		var id safety.Identifier
+		var alias string
+		if err := rows.Scan(&id.Kind, &id.Scope, &id.Name, &alias); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan identifier alias: %w", err)
+		}
+		aliases[id] = alias
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read identifier aliases: %w", err)
+	}
+
+	next := map[string]int{}
+	for _, id := range ids {
+		if _, ok := aliases[id]; ok {
+			continue
+		}
+		prefix, ok := safety.IdentifierPrefixes[id.Kind]
+		if !ok {
+			return nil, fmt.Errorf("unknown identifier kind %q", id.Kind)
+		}
+		if _, ok := next[id.Kind]; !ok {
+			var count int
+			if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM app_identifier_aliases WHERE kind = ?`, id.Kind).Scan(&count); err != nil {
+				return nil, fmt.Errorf("failed to count identifier aliases: %w", err)
+			}
+			next[id.Kind] = count + 1
+		}
+
+		alias := fmt.Sprintf("%s%d", prefix, next[id.Kind])
+		next[id.Kind]++
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO app_identifier_aliases (kind, scope, name, alias) VALUES (?, ?, ?, ?)`,
+			id.Kind, id.Scope, id.Name, alias); err != nil {
+			return nil, fmt.Errorf("failed to store identifier alias: %w", err)
+		}
+		aliases[id] = alias
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit identifier aliases: %w", err)
+	}
+	return aliases, nil
+}
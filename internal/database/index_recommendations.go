@@ -0,0 +1,389 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// IndexRecommendationsTable holds the indexes proposed alongside rewrites.
+// Approving one never creates it; "agent apply-index" does, explicitly.
+const IndexRecommendationsTable = "app_index_recommendations"
+
+// Audit actions for index recommendations
+const (
+	ActionApproveIndex = "approve-index"
+	ActionRejectIndex  = "reject-index"
+	ActionApplyIndex   = "apply-index"
+)
+
+// Index recommendation statuses
+const (
+	IndexPending  = "pending"
+	IndexApproved = "approved"
+	IndexRejected = "rejected"
+	IndexApplied  = "applied"
+)
+
+// Index types a recommendation can have
+const (
+	IndexTypeIndex  = "index"
+	IndexTypeUnique = "unique"
+)
+
+const indexRecommendationsTableDDL = `CREATE TABLE IF NOT EXISTS app_index_recommendations (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    rewrite_id BIGINT NOT NULL,
+    table_name VARCHAR(129) NOT NULL,
+    column_names JSON NOT NULL,
+    index_type VARCHAR(16) NOT NULL DEFAULT 'index',
+    rationale TEXT NOT NULL,
+    status ENUM('pending', 'approved', 'rejected', 'applied') DEFAULT 'pending',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    reviewed_at TIMESTAMP NULL,
+    applied_at TIMESTAMP NULL,
+    INDEX idx_rewrite_id (rewrite_id),
+    INDEX idx_status (status)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+var (
+	// ErrIndexNotFound is returned for an unknown index recommendation
+	ErrIndexNotFound = errors.New("index recommendation not found")
+	// ErrIndexNotPending is returned when reviewing a reviewed recommendation
+	ErrIndexNotPending = errors.New("index recommendation is not pending")
+	// ErrIndexNotApproved is returned when applying a recommendation that
+	// was not approved, or was already applied
+	ErrIndexNotApproved = errors.New("index recommendation is not approved")
+)
+
+// indexIdentifierRegex matches the unquoted table and column names a
+// recommendation may use; anything else is refused rather than quoted
+var indexIdentifierRegex = regexp.MustCompile(`^[A-Za-z0-9_$]{1,64}$`)
+
+// maxIndexNameLength is the longest index name TiDB accepts
+const maxIndexNameLength = 64
+
+// IndexRecommendation is an index the model proposed for a rewrite. Table
+// may be qualified by a schema; the slow query's database is used otherwise.
+type IndexRecommendation struct {
+	ID         int64      `json:"id"`
+	RewriteID  int64      `json:"rewrite_id"`
+	Table      string     `json:"table"`
+	Columns    []string   `json:"columns"`
+	Type       string     `json:"type"`
+	Rationale  string     `json:"rationale"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+	AppliedAt  *time.Time `json:"applied_at,omitempty"`
+
+	// Schema is the database of the slow query the rewrite came from
+	Schema string `json:"schema,omitempty"`
+}
+
+// Normalize trims the names, defaults the type to a plain index and checks
+// that every identifier is a plain name
+func (r *IndexRecommendation) Normalize() error {
+	r.Table = strings.Trim(strings.TrimSpace(r.Table), "`")
+	r.Type = strings.ToLower(strings.TrimSpace(r.Type))
+	if r.Type != IndexTypeUnique {
+		r.Type = IndexTypeIndex
+	}
+	r.Rationale = strings.TrimSpace(r.Rationale)
+
+	parts := strings.Split(r.Table, ".")
+	if len(parts) > 2 {
+		return fmt.Errorf("invalid table name '%s'", r.Table)
+	}
+	for i, part := range parts {
+		parts[i] = strings.Trim(part, "`")
+		if !indexIdentifierRegex.MatchString(parts[i]) {
+			return fmt.Errorf("invalid table name '%s'", r.Table)
+		}
+	}
+	r.Table = strings.Join(parts, ".")
+
+	if len(r.Columns) == 0 {
+		return fmt.Errorf("index on %s has no columns", r.Table)
+	}
+	for i, column := range r.Columns {
+		column = strings.Trim(strings.TrimSpace(column), "`")
+		if !indexIdentifierRegex.MatchString(column) {
+			return fmt.Errorf("invalid column name '%s'", column)
+		}
+		r.Columns[i] = column
+	}
+	return nil
+}
+
+// Name returns the index name, idx_<table>_<columns> cut to the length
+// TiDB accepts
+func (r *IndexRecommendation) Name() string {
+	table := r.Table
+	if dot := strings.LastIndexByte(table, '.'); dot >= 0 {
+		table = table[dot+1:]
+	}
+	name := "idx_" + table + "_" + strings.Join(r.Columns, "_")
+	if r.Type == IndexTypeUnique {
+		name = "uk_" + strings.TrimPrefix(name, "idx_")
+	}
+	if len(name) > maxIndexNameLength {
+		name = name[:maxIndexNameLength]
+	}
+	return strings.ToLower(name)
+}
+
+// CreateSQL returns the CREATE INDEX statement for the recommendation, with
+// every identifier quoted
+func (r *IndexRecommendation) CreateSQL() (string, error) {
+	if err := r.Normalize(); err != nil {
+		return "", err
+	}
+
+	table := r.Table
+	if !strings.Contains(table, ".") && r.Schema != "" {
+		if !indexIdentifierRegex.MatchString(r.Schema) {
+			return "", fmt.Errorf("invalid schema name '%s'", r.Schema)
+		}
+		table = r.Schema + "." + table
+	}
+	quoted := strings.Split(table, ".")
+	for i := range quoted {
+		quoted[i] = "`" + quoted[i] + "`"
+	}
+	columns := make([]string, len(r.Columns))
+	for i, column := range r.Columns {
+		columns[i] = "`" + column + "`"
+	}
+
+	kind := "INDEX"
+	if r.Type == IndexTypeUnique {
+		kind = "UNIQUE INDEX"
+	}
+	return fmt.Sprintf("CREATE %s `%s` ON %s (%s)", kind, r.Name(), strings.Join(quoted, "."), strings.Join(columns, ", ")), nil
+}
+
+// SaveIndexRecommendations stores the recommendations of a rewrite as
+// pending, skipping any with invalid names, and sets their IDs
+func (db *DB) SaveIndexRecommendations(ctx context.Context, rewriteID int64, recommendations []IndexRecommendation) error {
+	for i := range recommendations {
+		r := &recommendations[i]
+		if err := r.Normalize(); err != nil {
+			continue
+		}
+		columns, err := json.Marshal(r.Columns)
+		if err != nil {
+			return fmt.Errorf("failed to serialize index columns: %w", err)
+		}
+		r.RewriteID = rewriteID
+		r.Status = IndexPending
+		r.CreatedAt = time.Now()
+		res, err := db.ExecContext(ctx, `
+			INSERT INTO app_index_recommendations (rewrite_id, table_name, column_names, index_type, rationale, status, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			rewriteID, r.Table, string(columns), r.Type, r.Rationale, r.Status, r.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to store index recommendation: %w", err)
+		}
+		if r.ID, err = res.LastInsertId(); err != nil {
+			return fmt.Errorf("failed to get index recommendation id: %w", err)
+		}
+	}
+	return nil
+}
+
+const indexRecommendationColumns = `
+	SELECT ir.id, ir.rewrite_id, ir.table_name, ir.column_names, ir.index_type, ir.rationale,
+	       ir.status, ir.created_at, ir.reviewed_at, ir.applied_at, COALESCE(sq.db, '')
+	FROM app_index_recommendations ir
+	LEFT JOIN app_rewrites r ON r.id = ir.rewrite_id
+	LEFT JOIN app_slow_queries sq ON sq.id = r.slow_query_id`
+
+// ListIndexRecommendations returns recommendations newest first, only those
+// of one rewrite when rewriteID is set and of one status when status is set
+func (db *DB) ListIndexRecommendations(ctx context.Context, rewriteID int64, status string, limit int) ([]IndexRecommendation, error) {
+	var where []string
+	var args []any
+	if rewriteID > 0 {
+		where = append(where, "ir.rewrite_id = ?")
+		args = append(args, rewriteID)
+	}
+	if status != "" {
+		where = append(where, "ir.status = ?")
+		args = append(args, status)
+	}
+	query := indexRecommendationColumns
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY ir.id DESC"
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query index recommendations: %w", err)
+	}
+	defer rows.Close()
+
+	recommendations := []IndexRecommendation{}
+	for rows.Next() {
+		r, err := scanIndexRecommendation(rows)
+		if err != nil {
+			return nil, err
+		}
+		recommendations = append(recommendations, *r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query index recommendations: %w", err)
+	}
+	return recommendations, nil
+}
+
+// GetIndexRecommendation returns one recommendation
+func (db *DB) GetIndexRecommendation(ctx context.Context, id int64) (*IndexRecommendation, error) {
+	r, err := scanIndexRecommendation(db.QueryRowContext(ctx, indexRecommendationColumns+" WHERE ir.id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrIndexNotFound
+	}
+	return r, err
+}
+
+func scanIndexRecommendation(row interface{ Scan(...any) error }) (*IndexRecommendation, error) {
+	var r IndexRecommendation
+	var columns string
+	var reviewedAt, appliedAt sql.NullTime
+	err := row.Scan(&r.ID, &r.RewriteID, &r.Table, &columns, &r.Type, &r.Rationale,
+		&r.Status, &r.CreatedAt, &reviewedAt, &appliedAt, &r.Schema)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan index recommendation: %w", err)
+	}
+	if err := json.Unmarshal([]byte(columns), &r.Columns); err != nil {
+		return nil, fmt.Errorf("failed to parse index columns: %w", err)
+	}
+	if reviewedAt.Valid {
+		r.ReviewedAt = &reviewedAt.Time
+	}
+	if appliedAt.Valid {
+		r.AppliedAt = &appliedAt.Time
+	}
+	return &r, nil
+}
+
+// ReviewIndexRecommendation approves or rejects a pending recommendation,
+// auditing it under the actor carried by ctx. Approving only records the
+// decision; the index is created by ApplyIndexRecommendation.
+func (db *DB) ReviewIndexRecommendation(ctx context.Context, id int64, action, reason string) error {
+	status := map[string]string{
+		ActionApproveIndex: IndexApproved,
+		ActionRejectIndex:  IndexRejected,
+	}[action]
+	if status == "" {
+		return fmt.Errorf("unknown index review action '%s'", action)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin review: %w", err)
+	}
+	defer tx.Rollback()
+
+	var rewriteID int64
+	var current string
+	err = tx.QueryRowContext(ctx,
+		"SELECT rewrite_id, status FROM app_index_recommendations WHERE id = ? FOR UPDATE", id).Scan(&rewriteID, &current)
+	if err == sql.ErrNoRows {
+		return ErrIndexNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load index recommendation: %w", err)
+	}
+	if current != IndexPending {
+		return ErrIndexNotPending
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE app_index_recommendations SET status = ?, reviewed_at = NOW() WHERE id = ? AND status = 'pending'", status, id); err != nil {
+		return fmt.Errorf("failed to review index recommendation: %w", err)
+	}
+	err = RecordAuditTx(ctx, tx, AuditEntry{
+		Action:    action,
+		RewriteID: rewriteID,
+		Reason:    reason,
+		Details:   map[string]any{"index_recommendation_id": id},
+	})
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit review: %w", err)
+	}
+	return nil
+}
+
+// ApplyIndexRecommendation creates the index of an approved recommendation
+// on the target of its rewrite's slow query and marks it applied, auditing
+// the statement under the actor carried by ctx. DDL commits on its own, so a failure to record the outcome leaves the
+// index in place with the recommendation still approved.
+func (db *DB) ApplyIndexRecommendation(ctx context.Context, id int64) (string, error) {
+	r, err := db.GetIndexRecommendation(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if r.Status != IndexApproved {
+		return "", ErrIndexNotApproved
+	}
+	stmt, err := r.CreateSQL()
+	if err != nil {
+		return "", err
+	}
+
+	// The index belongs on the analyzed database, the record on the app one
+	var target string
+	err = db.QueryRowContext(ctx, `
+		SELECT s.target FROM app_rewrites r JOIN app_slow_queries s ON s.id = r.slow_query_id
+		WHERE r.id = ?`, r.RewriteID).Scan(&target)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return stmt, fmt.Errorf("failed to look up the target of rewrite %d: %w", r.RewriteID, err)
+	}
+	tdb, err := db.ForTarget(target)
+	if err != nil {
+		return stmt, err
+	}
+	if _, err := tdb.ExecSafe(ctx, stmt); err != nil {
+		return stmt, fmt.Errorf("failed to create index on target %s: %w", tdb.TargetName(), err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return stmt, fmt.Errorf("failed to begin recording applied index: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE app_index_recommendations SET status = 'applied', applied_at = NOW() WHERE id = ? AND status = 'approved'", id); err != nil {
+		return stmt, fmt.Errorf("failed to mark index recommendation applied: %w", err)
+	}
+	err = RecordAuditTx(ctx, tx, AuditEntry{
+		Action:    ActionApplyIndex,
+		RewriteID: r.RewriteID,
+		Details:   map[string]any{"index_recommendation_id": id, "statement": stmt, "target": tdb.TargetName()},
+	})
+	if err != nil {
+		return stmt, err
+	}
+	if err := tx.Commit(); err != nil {
+		return stmt, fmt.Errorf("failed to commit applied index: %w", err)
+	}
+	return stmt, nil
+}
@@ -0,0 +1,247 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTableUnavailable is returned for tables that do not exist or that the
+// agent's user cannot see
+var ErrTableUnavailable = errors.New("schema unavailable")
+
+// TableSchema is the trimmed definition of a table shown to the generator
+type TableSchema struct {
+	Schema  string
+	Name    string
+	Columns []ColumnSchema
+	Indexes []IndexSchema
+
+	// RowEstimate is information_schema.tables.table_rows, which TiDB
+	// derives from statistics; 0 when unknown
+	RowEstimate int64
+}
+
+// ColumnSchema is one column of a TableSchema
+type ColumnSchema struct {
+	Name     string
+	Type     string
+	Nullable bool
+}
+
+// IndexSchema is one index of a TableSchema, columns in index order
+type IndexSchema struct {
+	Name    string
+	Unique  bool
+	Columns []string
+}
+
+// DDL renders the table as a CREATE TABLE statement without options,
+// followed by the row estimate as a comment
+func (t *TableSchema) DDL() string {
+	var lines []string
+	for _, c := range t.Columns {
+		line := "  " + c.Name + " " + c.Type
+		if !c.Nullable {
+			line += " NOT NULL"
+		}
+		lines = append(lines, line)
+	}
+	for _, index := range t.Indexes {
+		columns := "(" + strings.Join(index.Columns, ", ") + ")"
+		switch {
+		case strings.EqualFold(index.Name, "PRIMARY"):
+			lines = append(lines, "  PRIMARY KEY "+columns)
+		case index.Unique:
+			lines = append(lines, "  UNIQUE KEY "+index.Name+" "+columns)
+		default:
+			lines = append(lines, "  KEY "+index.Name+" "+columns)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "CREATE TABLE %s (\n%s\n)", t.Name, strings.Join(lines, ",\n"))
+	if t.RowEstimate > 0 {
+		fmt.Fprintf(&b, " -- about %d rows", t.RowEstimate)
+	}
+	return b.String()
+}
+
+// ViewSchema is the definition of a view, its SELECT as TiDB stores it
+type ViewSchema struct {
+	Schema     string
+	Name       string
+	Definition string
+}
+
+// DDL renders the view as a CREATE VIEW statement
+func (v *ViewSchema) DDL() string {
+	return fmt.Sprintf("CREATE VIEW %s AS %s", v.Name, v.Definition)
+}
+
+// SchemaIntrospector reads table and view definitions from
+// information_schema, caching each definition, or its absence, for ttl
+type SchemaIntrospector struct {
+	db  *sql.DB
+	ttl time.Duration
+
+	mu     sync.Mutex
+	tables map[string]cachedTable
+	views  map[string]cachedView
+}
+
+type cachedTable struct {
+	schema  *TableSchema
+	err     error
+	expires time.Time
+}
+
+type cachedView struct {
+	view    *ViewSchema
+	err     error
+	expires time.Time
+}
+
+// NewSchemaIntrospector returns an introspector of the database behind db,
+// normally DB.TargetDB, caching for ttl; 0 reads information_schema every
+// time
+func NewSchemaIntrospector(db *sql.DB, ttl time.Duration) *SchemaIntrospector {
+	return &SchemaIntrospector{db: db, ttl: ttl, tables: map[string]cachedTable{}, views: map[string]cachedView{}}
+}
+
+// Table returns the definition of table, which may be qualified by its
+// schema and quoted with backticks; unqualified tables are looked up in the
+// connection's current database. Missing and inaccessible tables fail with
+// ErrTableUnavailable.
+func (si *SchemaIntrospector) Table(ctx context.Context, table string) (*TableSchema, error) {
+	schema, name := splitTableName(table)
+	key := strings.ToLower(schema + "." + name)
+
+	si.mu.Lock()
+	cached, ok := si.tables[key]
+	si.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.schema, cached.err
+	}
+
+	t, err := si.load(ctx, schema, name)
+	if err != nil && !errors.Is(err, ErrTableUnavailable) {
+		// Not cached: the next prompt tries again
+		return nil, err
+	}
+	si.mu.Lock()
+	si.tables[key] = cachedTable{schema: t, err: err, expires: time.Now().Add(si.ttl)}
+	si.mu.Unlock()
+	return t, err
+}
+
+// View returns the definition of view, named like the tables of Table.
+// Tables that are not views, and views the agent's user cannot see, fail
+// with ErrTableUnavailable.
+func (si *SchemaIntrospector) View(ctx context.Context, view string) (*ViewSchema, error) {
+	schema, name := splitTableName(view)
+	key := strings.ToLower(schema + "." + name)
+
+	si.mu.Lock()
+	cached, ok := si.views[key]
+	si.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.view, cached.err
+	}
+
+	v := &ViewSchema{Name: name}
+	err := si.db.QueryRowContext(ctx, `
+		SELECT table_schema, view_definition FROM information_schema.views
+		WHERE table_schema = COALESCE(NULLIF(?, ''), DATABASE()) AND table_name = ?`,
+		schema, name).Scan(&v.Schema, &v.Definition)
+	switch {
+	case err == sql.ErrNoRows:
+		v, err = nil, fmt.Errorf("%w: view %s not found", ErrTableUnavailable, name)
+	case err != nil:
+		// Not cached: the next prompt tries again
+		return nil, fmt.Errorf("failed to read the definition of view %s: %w", name, err)
+	case strings.TrimSpace(v.Definition) == "":
+		// Definitions are hidden from users without SHOW VIEW
+		v, err = nil, fmt.Errorf("%w: no access to the definition of view %s", ErrTableUnavailable, name)
+	}
+	si.mu.Lock()
+	si.views[key] = cachedView{view: v, err: err, expires: time.Now().Add(si.ttl)}
+	si.mu.Unlock()
+	return v, err
+}
+
+func (si *SchemaIntrospector) load(ctx context.Context, schema, name string) (*TableSchema, error) {
+	t := &TableSchema{Schema: schema, Name: name}
+	err := si.db.QueryRowContext(ctx, `
+		SELECT table_schema, COALESCE(table_rows, 0) FROM information_schema.tables
+		WHERE table_schema = COALESCE(NULLIF(?, ''), DATABASE()) AND table_name = ?`,
+		schema, name).Scan(&t.Schema, &t.RowEstimate)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: table %s not found", ErrTableUnavailable, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the definition of table %s: %w", name, err)
+	}
+
+	rows, err := si.db.QueryContext(ctx, `
+		SELECT column_name, column_type, is_nullable = 'YES' FROM information_schema.columns
+		WHERE table_schema = ? AND table_name = ?
+		ORDER BY ordinal_position`, t.Schema, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the columns of table %s: %w", name, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c ColumnSchema
+		if err := rows.Scan(&c.Name, &c.Type, &c.Nullable); err != nil {
+			return nil, fmt.Errorf("failed to scan column: %w", err)
+		}
+		t.Columns = append(t.Columns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the columns of table %s: %w", name, err)
+	}
+	if len(t.Columns) == 0 {
+		// Listed in tables but no column is visible to this user
+		return nil, fmt.Errorf("%w: no access to the columns of table %s", ErrTableUnavailable, name)
+	}
+
+	rows, err = si.db.QueryContext(ctx, `
+		SELECT index_name, non_unique = 0, COALESCE(column_name, CONCAT('(', expression, ')')) FROM information_schema.statistics
+		WHERE table_schema = ? AND table_name = ?
+		ORDER BY index_name = 'PRIMARY' DESC, index_name, seq_in_index`, t.Schema, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the indexes of table %s: %w", name, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var indexName, column string
+		var unique bool
+		if err := rows.Scan(&indexName, &unique, &column); err != nil {
+			return nil, fmt.Errorf("failed to scan index: %w", err)
+		}
+		if n := len(t.Indexes); n == 0 || t.Indexes[n-1].Name != indexName {
+			t.Indexes = append(t.Indexes, IndexSchema{Name: indexName, Unique: unique})
+		}
+		last := &t.Indexes[len(t.Indexes)-1]
+		last.Columns = append(last.Columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the indexes of table %s: %w", name, err)
+	}
+	return t, nil
+}
+
+// splitTableName separates the schema of a possibly qualified and quoted
+// table name
+func splitTableName(table string) (schema, name string) {
+	table = strings.ReplaceAll(table, "`", "")
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		return table[:i], table[i+1:]
+	}
+	return "", table
+}
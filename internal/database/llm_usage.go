@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// LLMUsageTable aggregates generator calls per UTC day and model, so the
+// cost of running the worker continuously can be followed over time
+const llmUsageTableDDL = `CREATE TABLE IF NOT EXISTS app_llm_usage (
+    usage_date DATE NOT NULL,
+    model VARCHAR(128) NOT NULL,
+    requests BIGINT NOT NULL DEFAULT 0,
+    prompt_tokens BIGINT NOT NULL DEFAULT 0,
+    completion_tokens BIGINT NOT NULL DEFAULT 0,
+    estimated_cost DOUBLE NOT NULL DEFAULT 0, -- US dollars
+    PRIMARY KEY (usage_date, model)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+// Days of usage reported by default, and at most
+const (
+	DefaultLLMUsageDays = 30
+	MaxLLMUsageDays     = 366
+)
+
+// LLMUsage is the generator usage of one model on one day. EstimatedCost is
+// in US dollars, from the prices in effect when each call was made.
+type LLMUsage struct {
+	Date             string  `json:"date"` // YYYY-MM-DD, UTC
+	Model            string  `json:"model"`
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	EstimatedCost    float64 `json:"estimated_cost"`
+}
+
+// RecordLLMUsage adds one generator call to the usage of model on the UTC
+// day of at
+func (db *DB) RecordLLMUsage(ctx context.Context, model string, promptTokens, completionTokens int, cost float64, at time.Time) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO app_llm_usage (usage_date, model, requests, prompt_tokens, completion_tokens, estimated_cost)
+		VALUES (?, ?, 1, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			requests = requests + 1,
+			prompt_tokens = prompt_tokens + VALUES(prompt_tokens),
+			completion_tokens = completion_tokens + VALUES(completion_tokens),
+			estimated_cost = estimated_cost + VALUES(estimated_cost)`,
+		at.UTC().Format(time.DateOnly), model, promptTokens, completionTokens, cost)
+	if err != nil {
+		return fmt.Errorf("failed to record LLM usage: %w", err)
+	}
+	return nil
+}
+
+// ListLLMUsage returns the usage of every model per day from the UTC day of
+// since, most recent day first
+func (db *DB) ListLLMUsage(ctx context.Context, since time.Time) ([]LLMUsage, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT DATE_FORMAT(usage_date, '%Y-%m-%d'), model, requests, prompt_tokens, completion_tokens, estimated_cost
+		FROM app_llm_usage
+		WHERE usage_date >= ?
+		ORDER BY usage_date DESC, model`, since.UTC().Format(time.DateOnly))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query LLM usage: %w", err)
+	}
+	defer rows.Close()
+
+	usage := []LLMUsage{}
+	for rows.Next() {
+		var u LLMUsage
+		if err := rows.Scan(&u.Date, &u.Model, &u.Requests, &u.PromptTokens, &u.CompletionTokens, &u.EstimatedCost); err != nil {
+			return nil, fmt.Errorf("failed to scan LLM usage: %w", err)
+		}
+		usage = append(usage, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query LLM usage: %w", err)
+	}
+	return usage, nil
+}
+
+// TotalLLMUsage sums usage over its days and models
+func TotalLLMUsage(usage []LLMUsage) LLMUsage {
+	var total LLMUsage
+	for _, u := range usage {
+		total.Requests += u.Requests
+		total.PromptTokens += u.PromptTokens
+		total.CompletionTokens += u.CompletionTokens
+		total.EstimatedCost += u.EstimatedCost
+	}
+	return total
+}
@@ -0,0 +1,317 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config"
+)
+
+// MigrationsTable records the app schema migrations applied
+const MigrationsTable = "app_schema_migrations"
+
+const migrationsTableDDL = `CREATE TABLE IF NOT EXISTS app_schema_migrations (
+    version INT PRIMARY KEY,
+    name VARCHAR(128) NOT NULL,
+    baseline BOOLEAN NOT NULL DEFAULT FALSE, -- stamped on an existing schema, not run
+    applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+// migration is one step of the app schema. DDL is not transactional, so a
+// step interrupted halfway is run again in full: every step must tolerate
+// finding its changes partly applied.
+type migration struct {
+	version int
+	name    string
+	up      func(ctx context.Context, db *DB, dim int) error
+}
+
+// appMigrations are applied in order; append new ones, never edit or
+// reorder those released
+var appMigrations = []migration{
+	{
+		version: 1,
+		name:    "baseline",
+		up: func(ctx context.Context, db *DB, dim int) error {
+			for _, stmt := range appTablesSQL(dim) {
+				if _, err := db.ExecContext(ctx, stmt); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// Tables, columns, ENUM values and indexes added before migrations
+		// were tracked; each is only applied when missing
+		version: 2,
+		name:    "pre-migration upgrades",
+		up: func(ctx context.Context, db *DB, dim int) error {
+			return db.upgradeLegacySchema(ctx)
+		},
+	},
+	{
+		version: 3,
+		name:    "embedding cache",
+		up: func(ctx context.Context, db *DB, dim int) error {
+			_, err := db.ExecContext(ctx, embeddingCacheTableDDL)
+			return err
+		},
+	},
+	{
+		version: 4,
+		name:    "rewrite reviewer",
+		up: func(ctx context.Context, db *DB, dim int) error {
+			return db.addColumns(ctx, reviewerColumns)
+		},
+	},
+	{
+		version: 5,
+		name:    "actual plans",
+		up: func(ctx context.Context, db *DB, dim int) error {
+			return db.addColumns(ctx, actualPlanColumns)
+		},
+	},
+	{
+		version: 6,
+		name:    "slow query targets",
+		up: func(ctx context.Context, db *DB, dim int) error {
+			return db.addColumns(ctx, targetColumns)
+		},
+	},
+	{
+		version: 7,
+		name:    "prompt versions",
+		up: func(ctx context.Context, db *DB, dim int) error {
+			return db.addColumns(ctx, promptVersionColumns)
+		},
+	},
+	{
+		version: 8,
+		name:    "unsupported slow queries",
+		up: func(ctx context.Context, db *DB, dim int) error {
+			return db.addEnumValues(ctx, unsupportedStatus)
+		},
+	},
+}
+
+// reviewerColumns record who accepted or rejected a rewrite and why
+var reviewerColumns = []columnUpgrade{
+	{
+		table:  "app_rewrites",
+		column: "reviewed_by",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN reviewed_by VARCHAR(128) NULL AFTER reviewed_at",
+		},
+	},
+	{
+		table:  "app_rewrites",
+		column: "review_comment",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN review_comment TEXT NULL AFTER reviewed_by",
+		},
+	},
+}
+
+// actualPlanColumns keep the EXPLAIN ANALYZE output captured when a slow
+// query was generated
+var actualPlanColumns = []columnUpgrade{
+	{
+		table:  "app_slow_queries",
+		column: "actual_plan",
+		ddl: []string{
+			"ALTER TABLE app_slow_queries ADD COLUMN actual_plan MEDIUMTEXT NULL AFTER best_rewrite_id",
+		},
+	},
+}
+
+// targetColumns tag each slow query with the db.targets entry it was read
+// from; existing rows belong to the default target
+var targetColumns = []columnUpgrade{
+	{
+		table:  "app_slow_queries",
+		column: "target",
+		ddl: []string{
+			"ALTER TABLE app_slow_queries ADD COLUMN target VARCHAR(64) NOT NULL DEFAULT 'default' AFTER id",
+			"ALTER TABLE app_slow_queries ADD INDEX idx_target_digest (target, digest)",
+		},
+	},
+}
+
+// unsupportedStatus settles the slow queries holding several statements,
+// which are never analyzed
+var unsupportedStatus = []enumUpgrade{
+	{
+		table:  "app_slow_queries",
+		column: "status",
+		value:  "unsupported",
+		ddl:    "ALTER TABLE app_slow_queries MODIFY COLUMN status ENUM('pending', 'analyzing', 'completed', 'skipped', 'unsupported') DEFAULT 'pending'",
+	},
+}
+
+// promptVersionColumns record the prompt version of each rewrite; rewrites
+// generated before keep an empty version
+var promptVersionColumns = []columnUpgrade{
+	{
+		table:  "app_rewrites",
+		column: "prompt_version",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN prompt_version VARCHAR(128) NOT NULL DEFAULT '' AFTER estimated_cost",
+			"ALTER TABLE app_rewrites ADD INDEX idx_prompt_version (prompt_version)",
+		},
+	},
+}
+
+// LatestSchemaVersion is the version Migrate reaches by default
+func LatestSchemaVersion() int {
+	return appMigrations[len(appMigrations)-1].version
+}
+
+// Migration is a known migration and when it was applied, nil when it was
+// not
+type Migration struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Baseline  bool       `json:"baseline"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// SchemaVersion returns the latest migration applied, 0 when there is none
+func (db *DB) SchemaVersion(ctx context.Context) (int, error) {
+	exists, err := db.TableExists(ctx, MigrationsTable)
+	if err != nil || !exists {
+		return 0, err
+	}
+	var version sql.NullInt64
+	if err := db.QueryRowContext(ctx, "SELECT MAX(version) FROM app_schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// Migrations lists every known migration, oldest first, with when it was
+// applied
+func (db *DB) Migrations(ctx context.Context) ([]Migration, error) {
+	applied := map[int]Migration{}
+	exists, err := db.TableExists(ctx, MigrationsTable)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		rows, err := db.QueryContext(ctx, "SELECT version, baseline, applied_at FROM app_schema_migrations")
+		if err != nil {
+			return nil, fmt.Errorf("failed to query migrations: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var m Migration
+			var appliedAt time.Time
+			if err := rows.Scan(&m.Version, &m.Baseline, &appliedAt); err != nil {
+				return nil, fmt.Errorf("failed to scan migration: %w", err)
+			}
+			m.AppliedAt = &appliedAt
+			applied[m.Version] = m
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to query migrations: %w", err)
+		}
+	}
+
+	out := make([]Migration, len(appMigrations))
+	for i, m := range appMigrations {
+		out[i] = applied[m.version]
+		out[i].Version, out[i].Name = m.version, m.name
+	}
+	return out, nil
+}
+
+// Migrate applies the migrations after the current version up to to, or
+// to the latest when to is 0, and returns those it applied. A new
+// app_embeddings is sized for dim dimensions. A schema created before
+// migrations were tracked is detected by its app_slow_queries table and
+// stamped with the baseline instead of having it run. Migrations are never
+// reverted: a to below the current version is an error. Its statements are
+// not bounded by safety.max_stmt_seconds.
+func (db *DB) Migrate(ctx context.Context, dim, to int) ([]Migration, error) {
+	ctx = withoutStatementTimeout(ctx)
+	if to == 0 {
+		to = LatestSchemaVersion()
+	}
+	if to < 0 || to > LatestSchemaVersion() {
+		return nil, fmt.Errorf("unknown schema version %d (latest is %d)", to, LatestSchemaVersion())
+	}
+
+	if _, err := db.ExecContext(ctx, migrationsTableDDL); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", MigrationsTable, err)
+	}
+	current, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if to < current {
+		return nil, fmt.Errorf("schema is at version %d; migrations cannot be reverted to %d", current, to)
+	}
+
+	var applied []Migration
+	if current == 0 {
+		existing, err := db.TableExists(ctx, "app_slow_queries")
+		if err != nil {
+			return nil, err
+		}
+		if existing {
+			baseline := appMigrations[0]
+			if err := db.recordMigration(ctx, baseline, true); err != nil {
+				return nil, err
+			}
+			applied = append(applied, Migration{Version: baseline.version, Name: baseline.name, Baseline: true})
+			current = baseline.version
+		}
+	}
+
+	for _, m := range appMigrations {
+		if m.version <= current || m.version > to {
+			continue
+		}
+		if err := m.up(ctx, db, dim); err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+		if err := db.recordMigration(ctx, m, false); err != nil {
+			return applied, err
+		}
+		applied = append(applied, Migration{Version: m.version, Name: m.name})
+	}
+	return applied, nil
+}
+
+// recordMigration marks m applied; a concurrent Migrate that recorded it
+// first is not an error
+func (db *DB) recordMigration(ctx context.Context, m migration, baseline bool) error {
+	if _, err := db.ExecContext(ctx,
+		"INSERT IGNORE INTO app_schema_migrations (version, name, baseline) VALUES (?, ?, ?)",
+		m.version, m.name, baseline); err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", m.version, err)
+	}
+	return nil
+}
+
+// UpgradeAppSchema brings the app schema to the latest version before a
+// command uses it, sizing a new app_embeddings for vector.dim. With
+// db.auto_migrate off it changes nothing and fails when migrations are
+// pending, so the schema is only changed by "agent migrate".
+func (db *DB) UpgradeAppSchema(ctx context.Context) error {
+	cfg := config.Current()
+	if cfg.DB.AutoMigrate {
+		_, err := db.Migrate(ctx, cfg.Vector.Dim, 0)
+		return err
+	}
+	version, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if version < LatestSchemaVersion() {
+		return fmt.Errorf("app schema is at version %d of %d and db.auto_migrate is off: run 'agent migrate'", version, LatestSchemaVersion())
+	}
+	return nil
+}
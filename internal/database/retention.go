@@ -0,0 +1,346 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LLMUsageTable holds per-day LLM token usage aggregates
+const LLMUsageTable = "app_llm_usage"
+
+// RetentionPolicy describes which app rows are old enough to purge. A zero
+// duration disables purging for that table. SlowQueriesOlderThan applies
+// to completed and skipped slow queries only.
+type RetentionPolicy struct {
+	SlowQueriesOlderThan      time.Duration
+	RejectedRewritesOlderThan time.Duration
+	LLMUsageOlderThan         time.Duration
+	BatchSize                 int
+	DryRun                    bool
+
+	// Progress, when set, is called after every deleted batch
+	Progress func(table string, deleted, total int64)
+}
+
+// PurgeResult reports what happened (or would happen) to a single table
+type PurgeResult struct {
+	Table   string   `json:"table"`
+	Cutoff  string   `json:"cutoff,omitempty"`
+	Matched int64    `json:"matched"`
+	Deleted int64    `json:"deleted"`
+	Sample  []string `json:"sample,omitempty"`
+	Skipped string   `json:"skipped,omitempty"`
+}
+
+const purgeSampleSize = 5
+
+// Rejected rewrites are aged by review time, falling back to creation time
+const rejectedRewritesWhere = `status = 'rejected' AND COALESCE(reviewed_at, created_at) < ?`
+
+// Slow queries are only eligible once their analysis is over and when no
+// accepted rewrite belongs to them or is linked to them by digest
+const purgeableSlowQueriesWhere = `s.created_at < ?
+	AND s.status IN ('completed', 'skipped', 'unsupported')
+	AND NOT EXISTS (
+		SELECT 1 FROM app_rewrites r
+		WHERE (r.slow_query_id = s.id OR r.id = s.best_rewrite_id) AND r.status = 'accepted'
+	)`
+
+// PurgeOldRecords deletes app rows older than the policy allows, in batches.
+// Accepted rewrites and the slow queries they belong to are never removed,
+// and best_rewrite_id is cleared before the rewrite it points to is deleted.
+func (db *DB) PurgeOldRecords(ctx context.Context, policy RetentionPolicy) ([]PurgeResult, error) {
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = 1000
+	}
+
+	now := time.Now()
+	var results []PurgeResult
+
+	if policy.RejectedRewritesOlderThan > 0 {
+		res, err := db.purgeRejectedRewrites(ctx, policy, now.Add(-policy.RejectedRewritesOlderThan))
+		if err != nil {
+			return results, fmt.Errorf("failed to purge rejected rewrites: %w", err)
+		}
+		results = append(results, res)
+	}
+
+	if policy.SlowQueriesOlderThan > 0 {
+		res, err := db.purgeSlowQueries(ctx, policy, now.Add(-policy.SlowQueriesOlderThan))
+		if err != nil {
+			return results, fmt.Errorf("failed to purge slow queries: %w", err)
+		}
+		results = append(results, res)
+	}
+
+	if policy.LLMUsageOlderThan > 0 {
+		res, err := db.purgeLLMUsage(ctx, policy, now.Add(-policy.LLMUsageOlderThan))
+		if err != nil {
+			return results, fmt.Errorf("failed to purge LLM usage: %w", err)
+		}
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+func (db *DB) purgeRejectedRewrites(ctx context.Context, policy RetentionPolicy, cutoff time.Time) (PurgeResult, error) {
+	res := PurgeResult{Table: "app_rewrites", Cutoff: cutoff.Format(time.RFC3339)}
+
+	if err := db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM app_rewrites WHERE "+rejectedRewritesWhere, cutoff,
+	).Scan(&res.Matched); err != nil {
+		return res, err
+	}
+
+	if policy.DryRun {
+		sample, err := db.sampleRows(ctx, `
+			SELECT CONCAT('rewrite #', id, ' (slow query #', slow_query_id, ', reviewed ',
+			       COALESCE(reviewed_at, created_at), ')')
+			FROM app_rewrites WHERE `+rejectedRewritesWhere+`
+			ORDER BY id LIMIT ?`, cutoff, purgeSampleSize)
+		res.Sample = sample
+		return res, err
+	}
+
+	for res.Deleted < res.Matched {
+		ids, err := db.selectIDs(ctx,
+			"SELECT id FROM app_rewrites WHERE "+rejectedRewritesWhere+" ORDER BY id LIMIT ?",
+			cutoff, policy.BatchSize)
+		if err != nil || len(ids) == 0 {
+			return res, err
+		}
+
+		deleted, err := db.deleteRewriteBatch(ctx, ids)
+		if err != nil {
+			return res, err
+		}
+		res.Deleted += deleted
+		if policy.Progress != nil {
+			policy.Progress(res.Table, res.Deleted, res.Matched)
+		}
+	}
+
+	return res, nil
+}
+
+func (db *DB) purgeSlowQueries(ctx context.Context, policy RetentionPolicy, cutoff time.Time) (PurgeResult, error) {
+	res := PurgeResult{Table: "app_slow_queries", Cutoff: cutoff.Format(time.RFC3339)}
+
+	if err := db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM app_slow_queries s WHERE "+purgeableSlowQueriesWhere, cutoff,
+	).Scan(&res.Matched); err != nil {
+		return res, err
+	}
+
+	if policy.DryRun {
+		sample, err := db.sampleRows(ctx, `
+			SELECT CONCAT('slow query #', s.id, ' [', s.status, '] ', s.created_at, ' ',
+			       LEFT(REPLACE(s.sample_sql, '\n', ' '), 60))
+			FROM app_slow_queries s WHERE `+purgeableSlowQueriesWhere+`
+			ORDER BY s.id LIMIT ?`, cutoff, purgeSampleSize)
+		res.Sample = sample
+		return res, err
+	}
+
+	for res.Deleted < res.Matched {
+		ids, err := db.selectIDs(ctx,
+			"SELECT s.id FROM app_slow_queries s WHERE "+purgeableSlowQueriesWhere+" ORDER BY s.id LIMIT ?",
+			cutoff, policy.BatchSize)
+		if err != nil || len(ids) == 0 {
+			return res, err
+		}
+
+		deleted, err := db.deleteSlowQueryBatch(ctx, ids)
+		if err != nil {
+			return res, err
+		}
+		res.Deleted += deleted
+		if policy.Progress != nil {
+			policy.Progress(res.Table, res.Deleted, res.Matched)
+		}
+	}
+
+	return res, nil
+}
+
+func (db *DB) purgeLLMUsage(ctx context.Context, policy RetentionPolicy, cutoff time.Time) (PurgeResult, error) {
+	res := PurgeResult{Table: LLMUsageTable, Cutoff: cutoff.Format("2006-01-02")}
+
+	exists, err := db.TableExists(ctx, LLMUsageTable)
+	if err != nil {
+		return res, err
+	}
+	if !exists {
+		res.Skipped = "table not present"
+		return res, nil
+	}
+
+	where := "usage_date < ?"
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+LLMUsageTable+" WHERE "+where, cutoff).Scan(&res.Matched); err != nil {
+		return res, err
+	}
+
+	if policy.DryRun {
+		sample, err := db.sampleRows(ctx, `
+			SELECT CONCAT(usage_date, ' ', model)
+			FROM `+LLMUsageTable+` WHERE `+where+`
+			ORDER BY usage_date LIMIT ?`, cutoff, purgeSampleSize)
+		res.Sample = sample
+		return res, err
+	}
+
+	for res.Deleted < res.Matched {
+		result, err := db.ExecContext(ctx, "DELETE FROM "+LLMUsageTable+" WHERE "+where+" LIMIT ?", cutoff, policy.BatchSize)
+		if err != nil {
+			return res, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil || n == 0 {
+			return res, err
+		}
+		res.Deleted += n
+		if policy.Progress != nil {
+			policy.Progress(res.Table, res.Deleted, res.Matched)
+		}
+	}
+
+	return res, nil
+}
+
+// deleteRewriteBatch removes rewrites after detaching any slow query that
+// still points at them through best_rewrite_id
+func (db *DB) deleteRewriteBatch(ctx context.Context, ids []int64) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	in, args := inClause(ids)
+	if _, err := tx.ExecContext(ctx, "UPDATE app_slow_queries SET best_rewrite_id = NULL WHERE best_rewrite_id IN "+in, args...); err != nil {
+		return 0, err
+	}
+
+	if err := db.deleteIndexRecommendationsTx(ctx, tx, "rewrite_id IN "+in, args); err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM app_rewrites WHERE id IN "+in, args...)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, tx.Commit()
+}
+
+// deleteSlowQueryBatch removes slow queries together with their remaining
+// (never accepted) rewrites, which the foreign key would otherwise block
+func (db *DB) deleteSlowQueryBatch(ctx context.Context, ids []int64) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	in, args := inClause(ids)
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE app_slow_queries SET best_rewrite_id = NULL
+		WHERE best_rewrite_id IN (SELECT id FROM app_rewrites WHERE slow_query_id IN `+in+`)`, args...); err != nil {
+		return 0, err
+	}
+
+	if err := db.deleteIndexRecommendationsTx(ctx, tx,
+		"rewrite_id IN (SELECT id FROM app_rewrites WHERE slow_query_id IN "+in+")", args); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM app_rewrites WHERE slow_query_id IN "+in, args...); err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM app_slow_queries WHERE id IN "+in, args...)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, tx.Commit()
+}
+
+// deleteIndexRecommendationsTx removes the index recommendations of
+// rewrites about to be deleted; the audit log keeps any that were applied
+func (db *DB) deleteIndexRecommendationsTx(ctx context.Context, tx *sql.Tx, where string, args []any) error {
+	exists, err := db.TableExists(ctx, IndexRecommendationsTable)
+	if err != nil || !exists {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM app_index_recommendations WHERE "+where, args...)
+	return err
+}
+
+// TableExists reports whether a table exists in the current database
+func (db *DB) TableExists(ctx context.Context, table string) (bool, error) {
+	var count int
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM information_schema.tables
+		WHERE table_schema = DATABASE() AND table_name = ?`, table).Scan(&count)
+	return count > 0, err
+}
+
+func (db *DB) selectIDs(ctx context.Context, query string, args ...any) ([]int64, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (db *DB) sampleRows(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sample []string
+	for rows.Next() {
+		var line sql.NullString
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		sample = append(sample, line.String)
+	}
+	return sample, rows.Err()
+}
+
+// inClause builds a "(?, ?, ...)" placeholder list for the given ids
+func inClause(ids []int64) (string, []any) {
+	placeholders := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	return "(" + strings.Join(placeholders, ", ") + ")", args
+}
@@ -0,0 +1,287 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// retentionTables are trimmed-down app tables holding just the columns
+// PurgeOldRecords reads, so the tests run on any MySQL-compatible server
+var retentionTables = []string{
+	`CREATE TABLE app_slow_queries (
+		id BIGINT PRIMARY KEY,
+		sample_sql TEXT,
+		status VARCHAR(20) NOT NULL,
+		best_rewrite_id BIGINT NULL,
+		created_at DATETIME NOT NULL
+	)`,
+	`CREATE TABLE app_rewrites (
+		id BIGINT PRIMARY KEY,
+		slow_query_id BIGINT NOT NULL,
+		status VARCHAR(20) NOT NULL,
+		created_at DATETIME NOT NULL,
+		reviewed_at DATETIME NULL
+	)`,
+}
+
+const retentionLLMUsageTable = `CREATE TABLE app_llm_usage (
+	usage_date DATE NOT NULL,
+	model VARCHAR(100) NOT NULL,
+	PRIMARY KEY (usage_date, model)
+)`
+
+// openRetentionDB connects to LATENTIA_TEST_DSN and recreates the fixture
+// tables in it. The database must be a scratch one whose name ends in
+// _test, since its app tables are dropped.
+func openRetentionDB(t *testing.T, withLLMUsage bool) *DB {
+	t.Helper()
+	dsn := os.Getenv("LATENTIA_TEST_DSN")
+	if dsn == "" {
+		t.Skip("LATENTIA_TEST_DSN is not set")
+	}
+	pool, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+
+	ctx := context.Background()
+	var name sql.NullString
+	if err := pool.QueryRowContext(ctx, "SELECT DATABASE()").Scan(&name); err != nil {
+		t.Fatalf("failed to reach database: %v", err)
+	}
+	if !strings.HasSuffix(name.String, "_test") {
+		t.Fatalf("LATENTIA_TEST_DSN selects database %q; use a scratch database ending in _test", name.String)
+	}
+
+	statements := []string{
+		"DROP TABLE IF EXISTS app_slow_queries",
+		"DROP TABLE IF EXISTS app_rewrites",
+		"DROP TABLE IF EXISTS " + LLMUsageTable,
+		"DROP TABLE IF EXISTS " + IndexRecommendationsTable,
+	}
+	statements = append(statements, retentionTables...)
+	if withLLMUsage {
+		statements = append(statements, retentionLLMUsageTable)
+	}
+	for _, stmt := range statements {
+		if _, err := pool.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("failed to prepare fixtures: %v\n%s", err, stmt)
+		}
+	}
+	return &DB{DB: pool}
+}
+
+// retentionFixtures inserts rows on both sides of a 30 day cutoff
+func retentionFixtures(t *testing.T, db *DB, now time.Time) {
+	t.Helper()
+	fresh := now.Add(-24 * time.Hour)
+	stale := now.Add(-60 * 24 * time.Hour)
+
+	slowQueries := []struct {
+		id      int64
+		status  string
+		best    any
+		created time.Time
+		note    string
+	}{
+		{1, "completed", int64(12), fresh, "fresh"},
+		{2, "completed", nil, stale, "stale"},
+		{3, "pending", nil, stale, "stale but not analyzed yet"},
+		{4, "completed", int64(11), stale, "stale with an accepted rewrite"},
+		{5, "skipped", nil, stale, "stale with a rejected rewrite"},
+		{6, "unsupported", nil, stale, "stale with a fresh rejected rewrite"},
+		{7, "completed", int64(11), stale, "stale, linked to an accepted rewrite by digest"},
+	}
+	for _, q := range slowQueries {
+		if _, err := db.ExecContext(context.Background(),
+			"INSERT INTO app_slow_queries (id, sample_sql, status, best_rewrite_id, created_at) VALUES (?, ?, ?, ?, ?)",
+			q.id, "SELECT /* "+q.note+" */ 1", q.status, q.best, q.created); err != nil {
+			t.Fatalf("failed to insert slow query %d: %v", q.id, err)
+		}
+	}
+
+	rewrites := []struct {
+		id, slowQueryID int64
+		status          string
+		created         time.Time
+		reviewed        any
+	}{
+		{11, 4, "accepted", stale, stale},
+		{12, 1, "rejected", stale, stale},
+		{13, 1, "rejected", stale, fresh}, // reviewed recently
+		{14, 5, "rejected", stale, nil},   // aged by creation
+		{15, 2, "proposed", stale, nil},
+		{16, 6, "rejected", fresh, nil},
+	}
+	for _, r := range rewrites {
+		if _, err := db.ExecContext(context.Background(),
+			"INSERT INTO app_rewrites (id, slow_query_id, status, created_at, reviewed_at) VALUES (?, ?, ?, ?, ?)",
+			r.id, r.slowQueryID, r.status, r.created, r.reviewed); err != nil {
+			t.Fatalf("failed to insert rewrite %d: %v", r.id, err)
+		}
+	}
+}
+
+func retentionIDs(t *testing.T, db *DB, table string) []int64 {
+	t.Helper()
+	ids, err := db.selectIDs(context.Background(), "SELECT id FROM "+table+" ORDER BY id")
+	if err != nil {
+		t.Fatalf("failed to list %s: %v", table, err)
+	}
+	return ids
+}
+
+var thirtyDays = RetentionPolicy{
+	SlowQueriesOlderThan:      30 * 24 * time.Hour,
+	RejectedRewritesOlderThan: 30 * 24 * time.Hour,
+	LLMUsageOlderThan:         30 * 24 * time.Hour,
+}
+
+func TestPurgeOldRecords(t *testing.T) {
+	db := openRetentionDB(t, true)
+	now := time.Now().UTC()
+	retentionFixtures(t, db, now)
+	for _, day := range []time.Time{now.AddDate(0, 0, -1), now.AddDate(0, 0, -45), now.AddDate(0, 0, -90)} {
+		if _, err := db.ExecContext(context.Background(),
+			"INSERT INTO app_llm_usage (usage_date, model) VALUES (?, ?)", day.Format("2006-01-02"), "m"); err != nil {
+			t.Fatalf("failed to insert LLM usage: %v", err)
+		}
+	}
+
+	var progress []string
+	policy := thirtyDays
+	policy.BatchSize = 1
+	policy.Progress = func(table string, deleted, total int64) {
+		progress = append(progress, table)
+	}
+	results, err := db.PurgeOldRecords(context.Background(), policy)
+	if err != nil {
+		t.Fatalf("PurgeOldRecords: %v", err)
+	}
+
+	deleted := map[string]int64{}
+	for _, res := range results {
+		if res.Matched != res.Deleted {
+			t.Errorf("%s: matched %d, deleted %d", res.Table, res.Matched, res.Deleted)
+		}
+		deleted[res.Table] = res.Deleted
+	}
+	// Rejected rewrites 12 and 14, then slow queries 2, 5 and 6 with their
+	// remaining rewrites, however recent, then two days of usage
+	want := map[string]int64{"app_rewrites": 2, "app_slow_queries": 3, LLMUsageTable: 2}
+	if !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deleted = %v, want %v", deleted, want)
+	}
+	if len(progress) != 7 {
+		t.Errorf("Progress called %d times for batches of one, want 7: %v", len(progress), progress)
+	}
+
+	if got, want := retentionIDs(t, db, "app_slow_queries"), []int64{1, 3, 4, 7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("slow queries left = %v, want %v", got, want)
+	}
+	if got, want := retentionIDs(t, db, "app_rewrites"), []int64{11, 13}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rewrites left = %v, want %v", got, want)
+	}
+
+	// The fresh slow query pointed at a purged rewrite
+	var best sql.NullInt64
+	if err := db.QueryRowContext(context.Background(), "SELECT best_rewrite_id FROM app_slow_queries WHERE id = 1").Scan(&best); err != nil {
+		t.Fatalf("failed to read slow query 1: %v", err)
+	}
+	if best.Valid {
+		t.Errorf("best_rewrite_id = %d, want it cleared with the rewrite", best.Int64)
+	}
+
+	var usage int
+	if err := db.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM app_llm_usage").Scan(&usage); err != nil {
+		t.Fatalf("failed to count LLM usage: %v", err)
+	}
+	if usage != 1 {
+		t.Errorf("%d days of LLM usage left, want the fresh one", usage)
+	}
+}
+
+func TestPurgeOldRecordsDryRun(t *testing.T) {
+	db := openRetentionDB(t, true)
+	retentionFixtures(t, db, time.Now().UTC())
+
+	policy := thirtyDays
+	policy.DryRun = true
+	results, err := db.PurgeOldRecords(context.Background(), policy)
+	if err != nil {
+		t.Fatalf("PurgeOldRecords: %v", err)
+	}
+
+	for _, res := range results {
+		if res.Deleted != 0 {
+			t.Errorf("%s: dry run deleted %d rows", res.Table, res.Deleted)
+		}
+		if int64(len(res.Sample)) != res.Matched {
+			t.Errorf("%s: sample %q for %d matched rows", res.Table, res.Sample, res.Matched)
+		}
+	}
+	if results[0].Matched != 2 || !strings.HasPrefix(results[0].Sample[0], "rewrite #12 (slow query #1") {
+		t.Errorf("rejected rewrites = %+v, want #12 and #14", results[0])
+	}
+	// Slow queries 5 and 6 still have their rejected rewrites, which do
+	// not protect them
+	if results[1].Matched != 3 || !strings.HasPrefix(results[1].Sample[0], "slow query #2 [completed]") {
+		t.Errorf("slow queries = %+v, want #2, #5 and #6", results[1])
+	}
+
+	if got := retentionIDs(t, db, "app_slow_queries"); len(got) != 7 {
+		t.Errorf("slow queries left = %v, want all 7", got)
+	}
+	if got := retentionIDs(t, db, "app_rewrites"); len(got) != 6 {
+		t.Errorf("rewrites left = %v, want all 6", got)
+	}
+}
+
+func TestPurgeOldRecordsWithoutLLMUsageTable(t *testing.T) {
+	db := openRetentionDB(t, false)
+
+	results, err := db.PurgeOldRecords(context.Background(), RetentionPolicy{LLMUsageOlderThan: time.Hour})
+	if err != nil {
+		t.Fatalf("PurgeOldRecords: %v", err)
+	}
+	if len(results) != 1 || results[0].Skipped != "table not present" {
+		t.Errorf("results = %+v, want %s skipped", results, LLMUsageTable)
+	}
+}
+
+func TestPurgeOldRecordsNothingToPurge(t *testing.T) {
+	db := openRetentionDB(t, true)
+	retentionFixtures(t, db, time.Now().UTC())
+
+	// Every fixture is younger than this policy
+	results, err := db.PurgeOldRecords(context.Background(), RetentionPolicy{
+		SlowQueriesOlderThan:      365 * 24 * time.Hour,
+		RejectedRewritesOlderThan: 365 * 24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("PurgeOldRecords: %v", err)
+	}
+	for _, res := range results {
+		if res.Matched != 0 || res.Deleted != 0 {
+			t.Errorf("%s: matched %d, deleted %d, want nothing", res.Table, res.Matched, res.Deleted)
+		}
+	}
+}
+
+func TestInClause(t *testing.T) {
+	in, args := inClause([]int64{3, 1, 2})
+	if in != "(?, ?, ?)" {
+		t.Errorf("placeholders = %q", in)
+	}
+	if !reflect.DeepEqual(args, []any{int64(3), int64(1), int64(2)}) {
+		t.Errorf("args = %v", args)
+	}
+}
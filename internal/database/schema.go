@@ -1,11 +1,15 @@
 package database
 
+import "context"
+
 const AppSlowQueriesSQL = `
 -- App slow queries table - compatible with both generated and INFORMATION_SCHEMA data
 CREATE TABLE IF NOT EXISTS app_slow_queries (
     id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    target VARCHAR(64) NOT NULL DEFAULT 'default', -- db.targets entry the query was read from
     digest VARCHAR(64) NOT NULL,
     sample_sql TEXT NOT NULL,
+    normalized_sql TEXT NULL,
     started_at TIMESTAMP NOT NULL,
     query_time DOUBLE NOT NULL,
     db VARCHAR(64),
@@ -14,16 +18,20 @@ CREATE TABLE IF NOT EXISTS app_slow_queries (
     user VARCHAR(64),
     host VARCHAR(64),
     tables JSON,
-    source ENUM('generated', 'information_schema') NOT NULL,
-    status ENUM('pending', 'analyzing', 'completed') DEFAULT 'pending',
+    source ENUM('generated', 'information_schema', 'adhoc', 'slowlog') NOT NULL,
+    status ENUM('pending', 'analyzing', 'completed', 'skipped', 'unsupported') DEFAULT 'pending',
+    skip_reason VARCHAR(512) NULL,
+    analysis_attempts INT NOT NULL DEFAULT 0,
     last_analyzed_at TIMESTAMP NULL,
     best_rewrite_id BIGINT NULL,
+    actual_plan MEDIUMTEXT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    INDEX idx_digest (digest),
+    INDEX idx_digest_started_at (digest, started_at),
     INDEX idx_started_at (started_at),
     INDEX idx_query_time (query_time),
     INDEX idx_source_status (source, status),
-    INDEX idx_db (db)
+    INDEX idx_db (db),
+    INDEX idx_target_digest (target, digest)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 -- Documents table for RAG
@@ -33,6 +41,9 @@ CREATE TABLE IF NOT EXISTS app_documents (
     content LONGTEXT NOT NULL,
     category VARCHAR(100),
     url VARCHAR(512),
+    content_hash CHAR(64) NULL, -- SHA-256 of category, url and content
+    etag VARCHAR(255) NULL, -- validators of documents fetched from a URL
+    last_modified VARCHAR(64) NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     INDEX idx_category (category),
     UNIQUE KEY uk_title (title)
@@ -44,7 +55,7 @@ CREATE TABLE IF NOT EXISTS app_embeddings (
     doc_id BIGINT NOT NULL,
     chunk_id INT NOT NULL,
     text TEXT NOT NULL,
-    embedding VECTOR(1536) NOT NULL,
+    embedding VECTOR(1536) NOT NULL, -- the embedder's dimension, see vector.dim
     metadata JSON,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (doc_id) REFERENCES app_documents(id),
@@ -63,15 +74,140 @@ CREATE TABLE IF NOT EXISTS app_rewrites (
     expected_improvement TEXT NOT NULL,
     caveats TEXT NOT NULL,
     confidence_score DECIMAL(3,2) NOT NULL DEFAULT 0.50,
-    status ENUM('pending', 'accepted', 'rejected') DEFAULT 'pending',
+    confidence_source VARCHAR(16) NULL, -- plan or heuristic; NULL for invalid rewrites
+    status ENUM('pending', 'accepted', 'rejected', 'suppressed', 'invalid') DEFAULT 'pending',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     reviewed_at TIMESTAMP NULL,
+    reviewed_by VARCHAR(128) NULL, -- reviewer named by the request, or the API key or CLI user
+    review_comment TEXT NULL,
+    realized_status VARCHAR(16) NULL,
+    before_avg_time DOUBLE NULL,
+    after_avg_time DOUBLE NULL,
+    before_samples INT NULL,
+    after_samples INT NULL,
+    minutes_saved_per_day DOUBLE NULL,
+    tracked_at TIMESTAMP NULL,
+    metadata JSON NULL,
+    sql_diff JSON NULL,
+    validation_error TEXT NULL,
+    plan_original JSON NULL,
+    plan_optimized JSON NULL,
+    max_severity TINYINT NOT NULL DEFAULT 0, -- 0 none, 1 info, 2 warn, 3 critical
+    benchmark_runs INT NULL, -- latencies in seconds
+    benchmark_original_min DOUBLE NULL,
+    benchmark_original_median DOUBLE NULL,
+    benchmark_original_max DOUBLE NULL,
+    benchmark_original_rows INT NULL,
+    benchmark_optimized_min DOUBLE NULL,
+    benchmark_optimized_median DOUBLE NULL,
+    benchmark_optimized_max DOUBLE NULL,
+    benchmark_optimized_rows INT NULL,
+    benchmark_rows_match BOOLEAN NULL,
+    benchmarked_at TIMESTAMP NULL,
+    prompt_tokens INT NULL,
+    completion_tokens INT NULL,
+    estimated_cost DOUBLE NULL, -- US dollars
+    prompt_version VARCHAR(128) NOT NULL DEFAULT '', -- templates, version, style and instruction hash; '' before versioning
+    parent_rewrite_id BIGINT NULL, -- rejected rewrite this one was retried from
+    prompt_text LONGTEXT NULL, -- as sent, after redaction; NULL with llm.store_raw off
+    raw_response LONGTEXT NULL,
     FOREIGN KEY (slow_query_id) REFERENCES app_slow_queries(id),
     INDEX idx_slow_query_id (slow_query_id),
     INDEX idx_status (status),
     INDEX idx_confidence_score (confidence_score),
+    INDEX idx_created_at (created_at),
+    INDEX idx_parent_rewrite_id (parent_rewrite_id),
+    INDEX idx_prompt_version (prompt_version)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Audit log of review decisions, kept when rewrites are purged
+CREATE TABLE IF NOT EXISTS app_audit_log (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    actor VARCHAR(64) NOT NULL,
+    action VARCHAR(64) NOT NULL,
+    rewrite_id BIGINT NULL,
+    slow_query_id BIGINT NULL,
+    reason VARCHAR(512) NULL,
+    details JSON NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_actor (actor),
+    INDEX idx_rewrite_id (rewrite_id),
     INDEX idx_created_at (created_at)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Digests that are never analyzed, by exact digest or pattern
+CREATE TABLE IF NOT EXISTS app_suppressions (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    digest VARCHAR(64) NULL,
+    digest_pattern VARCHAR(255) NULL,
+    reason VARCHAR(512) NOT NULL,
+    created_by VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NULL,
+    INDEX idx_digest (digest),
+    INDEX idx_expires_at (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Stable aliases of identifiers sent to the generator when anonymized
+CREATE TABLE IF NOT EXISTS app_identifier_aliases (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    kind ENUM('schema', 'table', 'column', 'alias') NOT NULL,
+    scope VARCHAR(64) NOT NULL DEFAULT '',
+    name VARCHAR(64) NOT NULL,
+    alias VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_identifier (kind, scope, name),
+    UNIQUE KEY uk_alias (alias)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Occurrence statistics per digest, ordered by total time for prioritizing
+CREATE TABLE IF NOT EXISTS app_slow_query_stats (
+    digest VARCHAR(64) PRIMARY KEY,
+    sample_sql TEXT NOT NULL,
+    db VARCHAR(64),
+    exec_count BIGINT NOT NULL DEFAULT 0,
+    total_query_time DOUBLE NOT NULL DEFAULT 0,
+    max_query_time DOUBLE NOT NULL DEFAULT 0,
+    first_seen TIMESTAMP NOT NULL,
+    last_seen TIMESTAMP NOT NULL,
+    INDEX idx_total_query_time (total_query_time),
+    INDEX idx_last_seen (last_seen)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Indexes proposed with rewrites; approval never creates them
+CREATE TABLE IF NOT EXISTS app_index_recommendations (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    rewrite_id BIGINT NOT NULL,
+    table_name VARCHAR(129) NOT NULL,
+    column_names JSON NOT NULL,
+    index_type VARCHAR(16) NOT NULL DEFAULT 'index',
+    rationale TEXT NOT NULL,
+    status ENUM('pending', 'approved', 'rejected', 'applied') DEFAULT 'pending',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    reviewed_at TIMESTAMP NULL,
+    applied_at TIMESTAMP NULL,
+    INDEX idx_rewrite_id (rewrite_id),
+    INDEX idx_status (status)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Generator calls per UTC day and model
+CREATE TABLE IF NOT EXISTS app_llm_usage (
+    usage_date DATE NOT NULL,
+    model VARCHAR(128) NOT NULL,
+    requests BIGINT NOT NULL DEFAULT 0,
+    prompt_tokens BIGINT NOT NULL DEFAULT 0,
+    completion_tokens BIGINT NOT NULL DEFAULT 0,
+    estimated_cost DOUBLE NOT NULL DEFAULT 0, -- US dollars
+    PRIMARY KEY (usage_date, model)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Schema migrations applied, see agent migrate
+CREATE TABLE IF NOT EXISTS app_schema_migrations (
+    version INT PRIMARY KEY,
+    name VARCHAR(128) NOT NULL,
+    baseline BOOLEAN NOT NULL DEFAULT FALSE, -- stamped on an existing schema, not run
+    applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 `
 
 const TestSchemaSQL = `
@@ -135,13 +271,17 @@ CREATE TABLE IF NOT EXISTS order_items (
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 `
 
-// SetupTestSchema creates the test tables
-func (db *DB) SetupTestSchema() error {
-	statements := []string{
+// appTablesSQL creates the app tables of the baseline migration, with
+// embeddings of dim dimensions. It is frozen: tables and columns added
+// since are created by later migrations.
+func appTablesSQL(dim int) []string {
+	return []string{
 		`CREATE TABLE IF NOT EXISTS app_slow_queries (
 		    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+		    target VARCHAR(64) NOT NULL DEFAULT 'default',
 		    digest VARCHAR(64) NOT NULL,
 		    sample_sql TEXT NOT NULL,
+		    normalized_sql TEXT NULL,
 		    started_at TIMESTAMP NOT NULL,
 		    query_time DOUBLE NOT NULL,
 		    db VARCHAR(64),
@@ -150,16 +290,20 @@ func (db *DB) SetupTestSchema() error {
 		    user VARCHAR(64),
 		    host VARCHAR(64),
 		    tables JSON,
-		    source ENUM('generated', 'information_schema') NOT NULL,
-		    status ENUM('pending', 'analyzing', 'completed') DEFAULT 'pending',
+		    source ENUM('generated', 'information_schema', 'adhoc', 'slowlog') NOT NULL,
+		    status ENUM('pending', 'analyzing', 'completed', 'skipped', 'unsupported') DEFAULT 'pending',
+		    skip_reason VARCHAR(512) NULL,
+		    analysis_attempts INT NOT NULL DEFAULT 0,
 		    last_analyzed_at TIMESTAMP NULL,
 		    best_rewrite_id BIGINT NULL,
+		    actual_plan MEDIUMTEXT NULL,
 		    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		    INDEX idx_digest (digest),
+		    INDEX idx_digest_started_at (digest, started_at),
 		    INDEX idx_started_at (started_at),
 		    INDEX idx_query_time (query_time),
 		    INDEX idx_source_status (source, status),
-		    INDEX idx_db (db)
+		    INDEX idx_db (db),
+		    INDEX idx_target_digest (target, digest)
 		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
 		
 		`CREATE TABLE IF NOT EXISTS app_documents (
@@ -168,23 +312,15 @@ func (db *DB) SetupTestSchema() error {
 		    content LONGTEXT NOT NULL,
 		    category VARCHAR(100),
 		    url VARCHAR(512),
+		    content_hash CHAR(64) NULL,
+		    etag VARCHAR(255) NULL,
+		    last_modified VARCHAR(64) NULL,
 		    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		    INDEX idx_category (category),
 		    UNIQUE KEY uk_title (title)
 		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
 		
-		`CREATE TABLE IF NOT EXISTS app_embeddings (
-		    id BIGINT PRIMARY KEY AUTO_INCREMENT,
-		    doc_id BIGINT NOT NULL,
-		    chunk_id INT NOT NULL,
-		    text TEXT NOT NULL,
-		    embedding VECTOR(1536) NOT NULL,
-		    metadata JSON,
-		    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		    FOREIGN KEY (doc_id) REFERENCES app_documents(id),
-		    VECTOR INDEX vec_idx ((VEC_COSINE_DISTANCE(embedding))),
-		    INDEX idx_doc_chunk (doc_id, chunk_id)
-		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+		embeddingsTableSQL(dim),
 		
 		`CREATE TABLE IF NOT EXISTS app_rewrites (
 		    id BIGINT PRIMARY KEY AUTO_INCREMENT,
@@ -196,15 +332,59 @@ func (db *DB) SetupTestSchema() error {
 		    expected_improvement TEXT NOT NULL,
 		    caveats TEXT NOT NULL,
 		    confidence_score DECIMAL(3,2) NOT NULL DEFAULT 0.50,
-		    status ENUM('pending', 'accepted', 'rejected') DEFAULT 'pending',
+		    confidence_source VARCHAR(16) NULL,
+		    status ENUM('pending', 'accepted', 'rejected', 'suppressed', 'invalid') DEFAULT 'pending',
 		    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		    reviewed_at TIMESTAMP NULL,
+		    reviewed_by VARCHAR(128) NULL,
+		    review_comment TEXT NULL,
+		    realized_status VARCHAR(16) NULL,
+		    before_avg_time DOUBLE NULL,
+		    after_avg_time DOUBLE NULL,
+		    before_samples INT NULL,
+		    after_samples INT NULL,
+		    minutes_saved_per_day DOUBLE NULL,
+		    tracked_at TIMESTAMP NULL,
+		    metadata JSON NULL,
+		    sql_diff JSON NULL,
+		    validation_error TEXT NULL,
+		    plan_original JSON NULL,
+		    plan_optimized JSON NULL,
+		    max_severity TINYINT NOT NULL DEFAULT 0,
+		    benchmark_runs INT NULL,
+		    benchmark_original_min DOUBLE NULL,
+		    benchmark_original_median DOUBLE NULL,
+		    benchmark_original_max DOUBLE NULL,
+		    benchmark_original_rows INT NULL,
+		    benchmark_optimized_min DOUBLE NULL,
+		    benchmark_optimized_median DOUBLE NULL,
+		    benchmark_optimized_max DOUBLE NULL,
+		    benchmark_optimized_rows INT NULL,
+		    benchmark_rows_match BOOLEAN NULL,
+		    benchmarked_at TIMESTAMP NULL,
+		    prompt_tokens INT NULL,
+		    completion_tokens INT NULL,
+		    estimated_cost DOUBLE NULL,
+		    prompt_version VARCHAR(128) NOT NULL DEFAULT '',
+		    parent_rewrite_id BIGINT NULL,
+		    prompt_text LONGTEXT NULL,
+		    raw_response LONGTEXT NULL,
 		    FOREIGN KEY (slow_query_id) REFERENCES app_slow_queries(id),
 		    INDEX idx_slow_query_id (slow_query_id),
 		    INDEX idx_status (status),
 		    INDEX idx_confidence_score (confidence_score),
-		    INDEX idx_created_at (created_at)
+		    INDEX idx_created_at (created_at),
+		    INDEX idx_parent_rewrite_id (parent_rewrite_id),
+		    INDEX idx_prompt_version (prompt_version)
 		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	}
+}
+
+// SetupTestSchema creates the e-commerce demo tables slow queries are
+// generated against on the target database; the app tables are created by
+// Migrate
+func (db *DB) SetupTestSchema(ctx context.Context) error {
+	statements := []string{
 		`CREATE TABLE IF NOT EXISTS customers (
 		    id BIGINT PRIMARY KEY AUTO_INCREMENT,
 		    email VARCHAR(255) NOT NULL,
@@ -260,7 +440,7 @@ func (db *DB) SetupTestSchema() error {
 	}
 	
 	for _, stmt := range statements {
-		if _, err := db.Exec(stmt); err != nil {
+		if _, err := db.ExecSafe(ctx, stmt); err != nil {
 			return err
 		}
 	}
@@ -269,7 +449,7 @@ func (db *DB) SetupTestSchema() error {
 }
 
 // CleanupTestData removes all test data (but keeps schema)
-func (db *DB) CleanupTestData() error {
+func (db *DB) CleanupTestData(ctx context.Context) error {
 	queries := []string{
 		"DELETE FROM order_items",
 		"DELETE FROM orders", 
@@ -278,7 +458,7 @@ func (db *DB) CleanupTestData() error {
 	}
 	
 	for _, query := range queries {
-		if _, err := db.Exec(query); err != nil {
+		if _, err := db.ExecSafe(ctx, query); err != nil {
 			return err
 		}
 	}
@@ -287,7 +467,7 @@ func (db *DB) CleanupTestData() error {
 }
 
 // DropTestSchema removes all test tables
-func (db *DB) DropTestSchema() error {
+func (db *DB) DropTestSchema(ctx context.Context) error {
 	queries := []string{
 		"DROP TABLE IF EXISTS order_items",
 		"DROP TABLE IF EXISTS orders",
@@ -296,7 +476,7 @@ func (db *DB) DropTestSchema() error {
 	}
 	
 	for _, query := range queries {
-		if _, err := db.Exec(query); err != nil {
+		if _, err := db.ExecSafe(ctx, query); err != nil {
 			return err
 		}
 	}
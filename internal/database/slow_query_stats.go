@@ -0,0 +1,160 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SlowQueryStatsTable aggregates slow query occurrences per digest, so a hot
+// query is counted by how often and how long it runs rather than by rows
+const SlowQueryStatsTable = "app_slow_query_stats"
+
+const slowQueryStatsTableDDL = `CREATE TABLE IF NOT EXISTS app_slow_query_stats (
+    digest VARCHAR(64) PRIMARY KEY,
+    sample_sql TEXT NOT NULL,
+    db VARCHAR(64),
+    exec_count BIGINT NOT NULL DEFAULT 0,
+    total_query_time DOUBLE NOT NULL DEFAULT 0,
+    max_query_time DOUBLE NOT NULL DEFAULT 0,
+    first_seen TIMESTAMP NOT NULL,
+    last_seen TIMESTAMP NOT NULL,
+    INDEX idx_total_query_time (total_query_time),
+    INDEX idx_last_seen (last_seen)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+// SlowQueryStats is the aggregate of every ingested occurrence of a digest.
+// SampleSQL and DB are those of its slowest occurrence.
+type SlowQueryStats struct {
+	Digest         string    `json:"digest"`
+	SampleSQL      string    `json:"sample_sql"`
+	DB             string    `json:"db"`
+	ExecCount      int64     `json:"exec_count"`
+	TotalQueryTime float64   `json:"total_query_time"`
+	AvgQueryTime   float64   `json:"avg_query_time"`
+	MaxQueryTime   float64   `json:"max_query_time"`
+	FirstSeen      time.Time `json:"first_seen"`
+	LastSeen       time.Time `json:"last_seen"`
+}
+
+// RecordSlowQueryOccurrence adds one occurrence of digest to its statistics
+func (db *DB) RecordSlowQueryOccurrence(ctx context.Context, digest, sampleSQL, dbName string, queryTime float64, at time.Time) error {
+	// sample_sql is assigned before max_query_time so it still compares
+	// against the previous maximum
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO app_slow_query_stats (digest, sample_sql, db, exec_count, total_query_time, max_query_time, first_seen, last_seen)
+		VALUES (?, ?, ?, 1, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			sample_sql = IF(VALUES(max_query_time) > max_query_time, VALUES(sample_sql), sample_sql),
+			db = IF(VALUES(max_query_time) > max_query_time, VALUES(db), db),
+			exec_count = exec_count + 1,
+			total_query_time = total_query_time + VALUES(total_query_time),
+			max_query_time = GREATEST(max_query_time, VALUES(max_query_time)),
+			first_seen = LEAST(first_seen, VALUES(first_seen)),
+			last_seen = GREATEST(last_seen, VALUES(last_seen))`,
+		digest, sampleSQL, dbName, queryTime, queryTime, at, at)
+	if err != nil {
+		return fmt.Errorf("failed to record slow query statistics: %w", err)
+	}
+	return nil
+}
+
+// ListSlowQueryStats returns the limit digests with the highest total query
+// time, the ones worth optimizing first
+func (db *DB) ListSlowQueryStats(ctx context.Context, limit int) ([]SlowQueryStats, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT digest, sample_sql, COALESCE(db, ''), exec_count, total_query_time, max_query_time, first_seen, last_seen
+		FROM app_slow_query_stats
+		ORDER BY total_query_time DESC
+		LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query slow query statistics: %w", err)
+	}
+	defer rows.Close()
+
+	stats := []SlowQueryStats{}
+	for rows.Next() {
+		var s SlowQueryStats
+		if err := rows.Scan(&s.Digest, &s.SampleSQL, &s.DB, &s.ExecCount, &s.TotalQueryTime, &s.MaxQueryTime, &s.FirstSeen, &s.LastSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan slow query statistics: %w", err)
+		}
+		if s.ExecCount > 0 {
+			s.AvgQueryTime = s.TotalQueryTime / float64(s.ExecCount)
+		}
+		stats = append(stats, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query slow query statistics: %w", err)
+	}
+	return stats, nil
+}
+
+// backfillSlowQueryStats aggregates the occurrences ingested before the
+// statistics table existed, with the latest occurrence as sample
+func (db *DB) backfillSlowQueryStats(ctx context.Context) error {
+	exists, err := db.TableExists(ctx, "app_slow_queries")
+	if err != nil || !exists {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT IGNORE INTO app_slow_query_stats (digest, sample_sql, db, exec_count, total_query_time, max_query_time, first_seen, last_seen)
+		SELECT s.digest, s.sample_sql, s.db, a.exec_count, a.total_query_time, a.max_query_time, a.first_seen, a.last_seen
+		FROM (
+			SELECT digest, COUNT(*) AS exec_count, SUM(query_time) AS total_query_time, MAX(query_time) AS max_query_time,
+				MIN(started_at) AS first_seen, MAX(started_at) AS last_seen, MAX(id) AS sample_id
+			FROM app_slow_queries
+			GROUP BY digest
+		) a
+		JOIN app_slow_queries s ON s.id = a.sample_id`)
+	if err != nil {
+		return fmt.Errorf("failed to backfill slow query statistics: %w", err)
+	}
+	return nil
+}
+
+// RenameDigest moves the slow queries with digest from to digest to, when
+// the way digests are computed changed, storing normalizedSQL as their
+// normalized text. Their statistics are merged into those of to, and exact
+// suppressions of from follow them so they stay suppressed. It returns how
+// many slow queries were moved.
+func (db *DB) RenameDigest(ctx context.Context, from, to, normalizedSQL string) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		"UPDATE app_slow_queries SET digest = ?, normalized_sql = ? WHERE digest = ?", to, normalizedSQL, from)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update slow query digests: %w", err)
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	// The merge matches RecordSlowQueryOccurrence, adding counts and times
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO app_slow_query_stats (digest, sample_sql, db, exec_count, total_query_time, max_query_time, first_seen, last_seen)
+		SELECT ?, sample_sql, db, exec_count, total_query_time, max_query_time, first_seen, last_seen
+		FROM app_slow_query_stats WHERE digest = ?
+		ON DUPLICATE KEY UPDATE
+			sample_sql = IF(VALUES(max_query_time) > max_query_time, VALUES(sample_sql), sample_sql),
+			db = IF(VALUES(max_query_time) > max_query_time, VALUES(db), db),
+			exec_count = exec_count + VALUES(exec_count),
+			total_query_time = total_query_time + VALUES(total_query_time),
+			max_query_time = GREATEST(max_query_time, VALUES(max_query_time)),
+			first_seen = LEAST(first_seen, VALUES(first_seen)),
+			last_seen = GREATEST(last_seen, VALUES(last_seen))`, to, from); err != nil {
+		return 0, fmt.Errorf("failed to merge slow query statistics: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM app_slow_query_stats WHERE digest = ?", from); err != nil {
+		return 0, fmt.Errorf("failed to merge slow query statistics: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE app_suppressions SET digest = ? WHERE digest = ?", to, from); err != nil {
+		return 0, fmt.Errorf("failed to update suppressed digests: %w", err)
+	}
+	return moved, tx.Commit()
+}
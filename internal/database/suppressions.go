@@ -0,0 +1,355 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config"
+)
+
+// SuppressionsTable lists digests that are known, accepted costs: they are
+// tagged at ingestion, never analyzed, and their pending rewrites are closed
+const SuppressionsTable = "app_suppressions"
+
+// Audit actions for suppressions
+const (
+	ActionSuppress   = "suppress"
+	ActionUnsuppress = "unsuppress"
+)
+
+// SuppressedPrefix starts the skip_reason of suppressed slow queries
+const SuppressedPrefix = "suppressed: "
+
+const suppressionsTableDDL = `CREATE TABLE IF NOT EXISTS app_suppressions (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    digest VARCHAR(64) NULL,
+    digest_pattern VARCHAR(255) NULL,
+    reason VARCHAR(512) NOT NULL,
+    created_by VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NULL,
+    INDEX idx_digest (digest),
+    INDEX idx_expires_at (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+// ErrSuppressionNotFound is returned when deleting an unknown suppression
+var ErrSuppressionNotFound = errors.New("suppression not found")
+
+// Suppression matches either one digest or every digest matching
+// DigestPattern, a case-insensitive regular expression like
+// ingest.filters.exclude_digest_patterns. It stops applying at ExpiresAt.
+type Suppression struct {
+	ID            int64      `json:"id"`
+	Digest        string     `json:"digest,omitempty"`
+	DigestPattern string     `json:"digest_pattern,omitempty"`
+	Reason        string     `json:"reason"`
+	CreatedBy     string     `json:"created_by"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+
+	// ClosedRewrites is set by CreateSuppression to the pending rewrites it
+	// closed as suppressed
+	ClosedRewrites []int64 `json:"closed_rewrites,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Validate checks that exactly one of Digest and DigestPattern is set, the
+// pattern compiles and a reason is given
+func (s *Suppression) Validate() error {
+	switch {
+	case s.Digest == "" && s.DigestPattern == "":
+		return fmt.Errorf("a digest or digest pattern is required")
+	case s.Digest != "" && s.DigestPattern != "":
+		return fmt.Errorf("set either a digest or a digest pattern, not both")
+	case len(s.Digest) > 64:
+		return fmt.Errorf("digest must be at most 64 characters")
+	case len(s.DigestPattern) > 255:
+		return fmt.Errorf("digest pattern must be at most 255 characters")
+	case strings.TrimSpace(s.Reason) == "":
+		return fmt.Errorf("a reason is required")
+	case len(s.Reason) > 512:
+		return fmt.Errorf("reason must be at most 512 characters")
+	}
+	if s.DigestPattern != "" {
+		re, err := config.CompileDigestPattern(s.DigestPattern)
+		if err != nil {
+			return err
+		}
+		s.pattern = re
+	}
+	return nil
+}
+
+// Matches reports whether the suppression applies to digest
+func (s *Suppression) Matches(digest string) bool {
+	if s.Digest != "" {
+		return strings.EqualFold(s.Digest, digest)
+	}
+	if s.pattern == nil {
+		re, err := config.CompileDigestPattern(s.DigestPattern)
+		if err != nil {
+			return false
+		}
+		s.pattern = re
+	}
+	return s.pattern.MatchString(digest)
+}
+
+// Suppressions is a set of suppressions loaded for one ingestion or analysis
+// run
+type Suppressions []Suppression
+
+// Match returns the first suppression applying to digest, nil if none
+func (ss Suppressions) Match(digest string) *Suppression {
+	for i := range ss {
+		if ss[i].Matches(digest) {
+			return &ss[i]
+		}
+	}
+	return nil
+}
+
+// ActiveSuppressions returns the suppressions that have not expired
+func (db *DB) ActiveSuppressions(ctx context.Context) (Suppressions, error) {
+	return db.ListSuppressions(ctx, false)
+}
+
+// ListSuppressions returns suppressions newest first, including expired
+// ones when includeExpired is set
+func (db *DB) ListSuppressions(ctx context.Context, includeExpired bool) (Suppressions, error) {
+	query := `
+		SELECT id, COALESCE(digest, ''), COALESCE(digest_pattern, ''), reason, created_by, created_at, expires_at
+		FROM app_suppressions`
+	var args []any
+	if !includeExpired {
+		query += " WHERE expires_at IS NULL OR expires_at > ?"
+		args = append(args, time.Now())
+	}
+	rows, err := db.QueryContext(ctx, query+" ORDER BY id DESC", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query suppressions: %w", err)
+	}
+	defer rows.Close()
+
+	suppressions := Suppressions{}
+	for rows.Next() {
+		var s Suppression
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&s.ID, &s.Digest, &s.DigestPattern, &s.Reason, &s.CreatedBy, &s.CreatedAt, &expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan suppression: %w", err)
+		}
+		if expiresAt.Valid {
+			s.ExpiresAt = &expiresAt.Time
+		}
+		suppressions = append(suppressions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query suppressions: %w", err)
+	}
+	return suppressions, nil
+}
+
+// CreateSuppression stores a suppression attributed to the actor carried by
+// ctx. In the same transaction it closes the pending rewrites of matching
+// digests as suppressed, skips their pending slow queries and audits both.
+func (db *DB) CreateSuppression(ctx context.Context, s Suppression) (*Suppression, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	if s.ExpiresAt != nil && !s.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("expiry %s is in the past", s.ExpiresAt.Format(time.RFC3339))
+	}
+	s.CreatedBy = ActorFromContext(ctx)
+	s.CreatedAt = time.Now()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin suppression: %w", err)
+	}
+	defer tx.Rollback()
+
+	var expiresAt sql.NullTime
+	if s.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: *s.ExpiresAt, Valid: true}
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO app_suppressions (digest, digest_pattern, reason, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		sql.NullString{String: s.Digest, Valid: s.Digest != ""},
+		sql.NullString{String: s.DigestPattern, Valid: s.DigestPattern != ""},
+		s.Reason, s.CreatedBy, s.CreatedAt, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store suppression: %w", err)
+	}
+	if s.ID, err = res.LastInsertId(); err != nil {
+		return nil, fmt.Errorf("failed to get suppression id: %w", err)
+	}
+
+	if err := closeSuppressedTx(ctx, tx, &s); err != nil {
+		return nil, err
+	}
+
+	details := map[string]any{"suppression_id": s.ID, "closed_rewrites": len(s.ClosedRewrites)}
+	if s.Digest != "" {
+		details["digest"] = s.Digest
+	} else {
+		details["digest_pattern"] = s.DigestPattern
+	}
+	if s.ExpiresAt != nil {
+		details["expires_at"] = s.ExpiresAt.Format(time.RFC3339)
+	}
+	if err := RecordAuditTx(ctx, tx, AuditEntry{Action: ActionSuppress, Reason: s.Reason, Details: details}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit suppression: %w", err)
+	}
+	return &s, nil
+}
+
+// closeSuppressedTx closes the pending rewrites and skips the pending slow
+// queries of every digest s matches
+func closeSuppressedTx(ctx context.Context, tx *sql.Tx, s *Suppression) error {
+	digests := []string{s.Digest}
+	if s.DigestPattern != "" {
+		var err error
+		if digests, err = pendingDigestsTx(ctx, tx, s); err != nil {
+			return err
+		}
+	}
+
+	skipReason := SuppressedPrefix + s.Reason
+	if len(skipReason) > 512 {
+		skipReason = skipReason[:512]
+	}
+	for _, digest := range digests {
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE app_slow_queries SET status = 'skipped', skip_reason = ? WHERE digest = ? AND status = 'pending'",
+			skipReason, digest); err != nil {
+			return fmt.Errorf("failed to skip suppressed slow queries: %w", err)
+		}
+
+		rows, err := tx.QueryContext(ctx, `
+			SELECT r.id, r.slow_query_id FROM app_rewrites r
+			JOIN app_slow_queries sq ON sq.id = r.slow_query_id
+			WHERE sq.digest = ? AND r.status = 'pending'
+			FOR UPDATE`, digest)
+		if err != nil {
+			return fmt.Errorf("failed to find pending rewrites: %w", err)
+		}
+		var pending [][2]int64
+		for rows.Next() {
+			var ids [2]int64
+			if err := rows.Scan(&ids[0], &ids[1]); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan pending rewrite: %w", err)
+			}
+			pending = append(pending, ids)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to find pending rewrites: %w", err)
+		}
+
+		for _, ids := range pending {
+			if _, err := tx.ExecContext(ctx,
+				"UPDATE app_rewrites SET status = 'suppressed', reviewed_at = NOW() WHERE id = ? AND status = 'pending'", ids[0]); err != nil {
+				return fmt.Errorf("failed to close rewrite %d: %w", ids[0], err)
+			}
+			err := RecordAuditTx(ctx, tx, AuditEntry{
+				Action:      ActionSuppress,
+				RewriteID:   ids[0],
+				SlowQueryID: ids[1],
+				Reason:      s.Reason,
+				Details:     map[string]any{"suppression_id": s.ID},
+			})
+			if err != nil {
+				return err
+			}
+			s.ClosedRewrites = append(s.ClosedRewrites, ids[0])
+		}
+	}
+	return nil
+}
+
+// pendingDigestsTx returns the digests with pending work that match s
+func pendingDigestsTx(ctx context.Context, tx *sql.Tx, s *Suppression) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT DISTINCT digest FROM app_slow_queries WHERE status = 'pending'
+		UNION
+		SELECT DISTINCT sq.digest FROM app_rewrites r
+		JOIN app_slow_queries sq ON sq.id = r.slow_query_id
+		WHERE r.status = 'pending'`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find suppressed digests: %w", err)
+	}
+	defer rows.Close()
+
+	var digests []string
+	for rows.Next() {
+		var digest string
+		if err := rows.Scan(&digest); err != nil {
+			return nil, fmt.Errorf("failed to scan digest: %w", err)
+		}
+		if s.Matches(digest) {
+			digests = append(digests, digest)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find suppressed digests: %w", err)
+	}
+	return digests, nil
+}
+
+// DeleteSuppression removes a suppression, auditing it under the actor
+// carried by ctx. Slow queries skipped and rewrites closed while it applied
+// stay as they are.
+func (db *DB) DeleteSuppression(ctx context.Context, id int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin deletion: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM app_suppressions WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete suppression: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to delete suppression: %w", err)
+	} else if n == 0 {
+		return ErrSuppressionNotFound
+	}
+
+	err = RecordAuditTx(ctx, tx, AuditEntry{Action: ActionUnsuppress, Details: map[string]any{"suppression_id": id}})
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit deletion: %w", err)
+	}
+	return nil
+}
+
+// CountSuppressedSlowQueries counts slow queries skipped by a suppression,
+// optionally only those that started since a time
+func (db *DB) CountSuppressedSlowQueries(ctx context.Context, since time.Time) (int, error) {
+	query := "SELECT COUNT(*) FROM app_slow_queries WHERE status = 'skipped' AND skip_reason LIKE ?"
+	args := []any{SuppressedPrefix + "%"}
+	if !since.IsZero() {
+		query += " AND started_at >= ?"
+		args = append(args, since)
+	}
+	var count int
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count suppressed slow queries: %w", err)
+	}
+	return count, nil
+}
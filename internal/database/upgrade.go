@@ -0,0 +1,445 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// columnUpgrade adds a column that was introduced after a table was first
+// created, before schema changes were migrations. CREATE TABLE IF NOT
+// EXISTS never touches existing tables, so installations created by older
+// versions need these applied explicitly.
+type columnUpgrade struct {
+	table  string
+	column string
+	ddl    []string
+}
+
+var appColumnUpgrades = []columnUpgrade{
+	{
+		table:  "app_slow_queries",
+		column: "skip_reason",
+		ddl: []string{
+			"ALTER TABLE app_slow_queries MODIFY COLUMN status ENUM('pending', 'analyzing', 'completed', 'skipped') DEFAULT 'pending'",
+			"ALTER TABLE app_slow_queries ADD COLUMN skip_reason VARCHAR(512) NULL AFTER status",
+		},
+	},
+	{
+		table:  "app_slow_queries",
+		column: "analysis_attempts",
+		ddl: []string{
+			"ALTER TABLE app_slow_queries ADD COLUMN analysis_attempts INT NOT NULL DEFAULT 0 AFTER skip_reason",
+		},
+	},
+	{
+		table:  "app_rewrites",
+		column: "metadata",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN metadata JSON NULL AFTER reviewed_at",
+		},
+	},
+	{
+		table:  "app_rewrites",
+		column: "sql_diff",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN sql_diff JSON NULL AFTER metadata",
+		},
+	},
+	{
+		table:  "app_rewrites",
+		column: "validation_error",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN validation_error TEXT NULL AFTER sql_diff",
+		},
+	},
+	{
+		table:  "app_rewrites",
+		column: "plan_original",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN plan_original JSON NULL AFTER validation_error",
+		},
+	},
+	{
+		table:  "app_rewrites",
+		column: "plan_optimized",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN plan_optimized JSON NULL AFTER plan_original",
+		},
+	},
+	{
+		table:  "app_rewrites",
+		column: "realized_status",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN realized_status VARCHAR(16) NULL AFTER reviewed_at",
+		},
+	},
+	{
+		table:  "app_rewrites",
+		column: "before_avg_time",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN before_avg_time DOUBLE NULL AFTER realized_status",
+		},
+	},
+	{
+		table:  "app_rewrites",
+		column: "after_avg_time",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN after_avg_time DOUBLE NULL AFTER before_avg_time",
+		},
+	},
+	{
+		table:  "app_rewrites",
+		column: "before_samples",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN before_samples INT NULL AFTER after_avg_time",
+		},
+	},
+	{
+		table:  "app_rewrites",
+		column: "after_samples",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN after_samples INT NULL AFTER before_samples",
+		},
+	},
+	{
+		table:  "app_rewrites",
+		column: "minutes_saved_per_day",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN minutes_saved_per_day DOUBLE NULL AFTER after_samples",
+		},
+	},
+	{
+		table:  "app_rewrites",
+		column: "tracked_at",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN tracked_at TIMESTAMP NULL AFTER minutes_saved_per_day",
+		},
+	},
+	{
+		table:  "app_rewrites",
+		column: "max_severity",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN max_severity TINYINT NOT NULL DEFAULT 0 AFTER plan_optimized",
+			// Ranks of the anti-patterns known when findings were added:
+			// critical 3, other warnings 2, informational 1
+			`UPDATE app_rewrites SET max_severity = CASE
+			    WHEN JSON_CONTAINS(pattern_analysis, '"missing-where"', '$.anti_patterns')
+			      OR JSON_CONTAINS(pattern_analysis, '"cartesian-join"', '$.anti_patterns') THEN 3
+			    WHEN JSON_LENGTH(pattern_analysis, '$.anti_patterns') >
+			         JSON_CONTAINS(pattern_analysis, '"select-star"', '$.anti_patterns')
+			         + JSON_CONTAINS(pattern_analysis, '"missing-limit"', '$.anti_patterns')
+			         + JSON_CONTAINS(pattern_analysis, '"order-without-limit"', '$.anti_patterns') THEN 2
+			    WHEN JSON_LENGTH(pattern_analysis, '$.anti_patterns') > 0 THEN 1
+			    ELSE 0
+			END`,
+		},
+	},
+	{
+		table:  "app_rewrites",
+		column: "benchmarked_at",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN benchmark_runs INT NULL AFTER max_severity",
+			"ALTER TABLE app_rewrites ADD COLUMN benchmark_original_min DOUBLE NULL AFTER benchmark_runs",
+			"ALTER TABLE app_rewrites ADD COLUMN benchmark_original_median DOUBLE NULL AFTER benchmark_original_min",
+			"ALTER TABLE app_rewrites ADD COLUMN benchmark_original_max DOUBLE NULL AFTER benchmark_original_median",
+			"ALTER TABLE app_rewrites ADD COLUMN benchmark_original_rows INT NULL AFTER benchmark_original_max",
+			"ALTER TABLE app_rewrites ADD COLUMN benchmark_optimized_min DOUBLE NULL AFTER benchmark_original_rows",
+			"ALTER TABLE app_rewrites ADD COLUMN benchmark_optimized_median DOUBLE NULL AFTER benchmark_optimized_min",
+			"ALTER TABLE app_rewrites ADD COLUMN benchmark_optimized_max DOUBLE NULL AFTER benchmark_optimized_median",
+			"ALTER TABLE app_rewrites ADD COLUMN benchmark_optimized_rows INT NULL AFTER benchmark_optimized_max",
+			"ALTER TABLE app_rewrites ADD COLUMN benchmark_rows_match BOOLEAN NULL AFTER benchmark_optimized_rows",
+			"ALTER TABLE app_rewrites ADD COLUMN benchmarked_at TIMESTAMP NULL AFTER benchmark_rows_match",
+		},
+	},
+	{
+		table:  "app_rewrites",
+		column: "estimated_cost",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN prompt_tokens INT NULL AFTER benchmarked_at",
+			"ALTER TABLE app_rewrites ADD COLUMN completion_tokens INT NULL AFTER prompt_tokens",
+			"ALTER TABLE app_rewrites ADD COLUMN estimated_cost DOUBLE NULL AFTER completion_tokens",
+		},
+	},
+	{
+		table:  "app_rewrites",
+		column: "parent_rewrite_id",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN parent_rewrite_id BIGINT NULL AFTER estimated_cost",
+			"ALTER TABLE app_rewrites ADD INDEX idx_parent_rewrite_id (parent_rewrite_id)",
+		},
+	},
+	{
+		table:  "app_slow_queries",
+		column: "normalized_sql",
+		ddl:    []string{"ALTER TABLE app_slow_queries ADD COLUMN normalized_sql TEXT NULL AFTER sample_sql"},
+	},
+	{
+		table:  "app_rewrites",
+		column: "raw_response",
+		ddl: []string{
+			"ALTER TABLE app_rewrites ADD COLUMN prompt_text LONGTEXT NULL AFTER parent_rewrite_id",
+			"ALTER TABLE app_rewrites ADD COLUMN raw_response LONGTEXT NULL AFTER prompt_text",
+		},
+	},
+	{
+		table:  "app_rewrites",
+		column: "confidence_source",
+		ddl:    []string{"ALTER TABLE app_rewrites ADD COLUMN confidence_source VARCHAR(16) NULL AFTER confidence_score"},
+	},
+	{
+		table:  "app_documents",
+		column: "content_hash",
+		ddl: []string{
+			"ALTER TABLE app_documents ADD COLUMN content_hash CHAR(64) NULL AFTER url",
+		},
+	},
+	{
+		table:  "app_documents",
+		column: "last_modified",
+		ddl: []string{
+			"ALTER TABLE app_documents ADD COLUMN etag VARCHAR(255) NULL AFTER content_hash",
+			"ALTER TABLE app_documents ADD COLUMN last_modified VARCHAR(64) NULL AFTER etag",
+		},
+	},
+	{
+		table:  AuditTable,
+		column: "reason",
+		ddl: []string{
+			"ALTER TABLE app_audit_log ADD COLUMN reason VARCHAR(512) NULL AFTER slow_query_id",
+		},
+	},
+}
+
+// appTableUpgrades creates app tables introduced after the initial schema
+var appTableUpgrades = []string{
+	auditTableDDL,
+	suppressionsTableDDL,
+	identifierAliasesTableDDL,
+	slowQueryStatsTableDDL,
+	indexRecommendationsTableDDL,
+	llmUsageTableDDL,
+}
+
+// enumUpgrade adds a value to an ENUM column by redefining it
+type enumUpgrade struct {
+	table  string
+	column string
+	value  string
+	ddl    string
+}
+
+var appEnumUpgrades = []enumUpgrade{
+	{
+		table:  "app_rewrites",
+		column: "status",
+		value:  "suppressed",
+		ddl:    "ALTER TABLE app_rewrites MODIFY COLUMN status ENUM('pending', 'accepted', 'rejected', 'suppressed') DEFAULT 'pending'",
+	},
+	{
+		table:  "app_rewrites",
+		column: "status",
+		value:  "invalid",
+		ddl:    "ALTER TABLE app_rewrites MODIFY COLUMN status ENUM('pending', 'accepted', 'rejected', 'suppressed', 'invalid') DEFAULT 'pending'",
+	},
+	{
+		table:  "app_slow_queries",
+		column: "source",
+		value:  "adhoc",
+		ddl:    "ALTER TABLE app_slow_queries MODIFY COLUMN source ENUM('generated', 'information_schema', 'adhoc') NOT NULL",
+	},
+	{
+		table:  "app_slow_queries",
+		column: "source",
+		value:  "slowlog",
+		ddl:    "ALTER TABLE app_slow_queries MODIFY COLUMN source ENUM('generated', 'information_schema', 'adhoc', 'slowlog') NOT NULL",
+	},
+}
+
+// indexUpgrade adds an index that was introduced after a table was first
+// created
+type indexUpgrade struct {
+	table string
+	index string
+	ddl   string
+}
+
+var appIndexUpgrades = []indexUpgrade{
+	{
+		// Serves the history of a digest, most recent occurrences first
+		table: "app_slow_queries",
+		index: "idx_digest_started_at",
+		ddl:   "ALTER TABLE app_slow_queries ADD INDEX idx_digest_started_at (digest, started_at)",
+	},
+}
+
+// upgradeLegacySchema applies any missing additive changes to existing app
+// tables and creates app tables added since the baseline; it is migration
+// 2. It is idempotent and skips column changes for tables that do not exist
+// yet. Later schema changes are migrations of their own.
+func (db *DB) upgradeLegacySchema(ctx context.Context) error {
+	hadStats, err := db.TableExists(ctx, SlowQueryStatsTable)
+	if err != nil {
+		return err
+	}
+	for _, ddl := range appTableUpgrades {
+		if _, err := db.ExecContext(ctx, ddl); err != nil {
+			return fmt.Errorf("failed to create app table: %w", err)
+		}
+	}
+	if !hadStats {
+		if err := db.backfillSlowQueryStats(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := db.addColumns(ctx, appColumnUpgrades); err != nil {
+		return err
+	}
+
+	if err := db.addEnumValues(ctx, appEnumUpgrades); err != nil {
+		return err
+	}
+
+	for _, upgrade := range appIndexUpgrades {
+		has, err := db.indexExists(ctx, upgrade)
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, upgrade.ddl); err != nil {
+			return fmt.Errorf("failed to add index %s to %s: %w", upgrade.index, upgrade.table, err)
+		}
+	}
+	return nil
+}
+
+// indexExists reports whether a table has an index; a missing table counts
+// as up to date
+func (db *DB) indexExists(ctx context.Context, upgrade indexUpgrade) (bool, error) {
+	exists, err := db.TableExists(ctx, upgrade.table)
+	if err != nil || !exists {
+		return true, err
+	}
+	var count int
+	err = db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM information_schema.statistics
+		WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?`, upgrade.table, upgrade.index).Scan(&count)
+	return count > 0, err
+}
+
+// enumHasValue reports whether an ENUM column accepts a value; a missing
+// table or column counts as up to date
+func (db *DB) enumHasValue(ctx context.Context, upgrade enumUpgrade) (bool, error) {
+	var columnType string
+	err := db.QueryRowContext(ctx, `
+		SELECT column_type FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?`, upgrade.table, upgrade.column).Scan(&columnType)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(columnType, "'"+upgrade.value+"'"), nil
+}
+
+// addColumns applies the upgrades whose column is missing from an existing
+// table
+func (db *DB) addColumns(ctx context.Context, upgrades []columnUpgrade) error {
+	for _, upgrade := range upgrades {
+		exists, err := db.TableExists(ctx, upgrade.table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+
+		has, err := db.ColumnExists(ctx, upgrade.table, upgrade.column)
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+
+		for _, stmt := range upgrade.ddl {
+			if _, err := db.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("failed to add %s.%s: %w", upgrade.table, upgrade.column, err)
+			}
+		}
+	}
+	return nil
+}
+
+// addEnumValues applies the ENUM upgrades whose value is missing
+func (db *DB) addEnumValues(ctx context.Context, upgrades []enumUpgrade) error {
+	for _, upgrade := range upgrades {
+		has, err := db.enumHasValue(ctx, upgrade)
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, upgrade.ddl); err != nil {
+			return fmt.Errorf("failed to add '%s' to %s.%s: %w", upgrade.value, upgrade.table, upgrade.column, err)
+		}
+	}
+	return nil
+}
+
+// MissingAppColumns lists columns upgradeLegacySchema would add to existing app
+// tables, as table.column, ENUM values it would add, as
+// table.column('value'), and indexes, as table(index)
+func (db *DB) MissingAppColumns(ctx context.Context) ([]string, error) {
+	var missing []string
+	for _, upgrade := range appColumnUpgrades {
+		exists, err := db.TableExists(ctx, upgrade.table)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		has, err := db.ColumnExists(ctx, upgrade.table, upgrade.column)
+		if err != nil {
+			return nil, err
+		}
+		if !has {
+			missing = append(missing, upgrade.table+"."+upgrade.column)
+		}
+	}
+	for _, upgrade := range appEnumUpgrades {
+		has, err := db.enumHasValue(ctx, upgrade)
+		if err != nil {
+			return nil, err
+		}
+		if !has {
+			missing = append(missing, fmt.Sprintf("%s.%s('%s')", upgrade.table, upgrade.column, upgrade.value))
+		}
+	}
+	for _, upgrade := range appIndexUpgrades {
+		has, err := db.indexExists(ctx, upgrade)
+		if err != nil {
+			return nil, err
+		}
+		if !has {
+			missing = append(missing, fmt.Sprintf("%s(%s)", upgrade.table, upgrade.index))
+		}
+	}
+	return missing, nil
+}
+
+// ColumnExists reports whether a column exists on a table in the current database
+func (db *DB) ColumnExists(ctx context.Context, table, column string) (bool, error) {
+	var count int
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?`, table, column).Scan(&count)
+	return count > 0, err
+}
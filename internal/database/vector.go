@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EmbeddingDimension returns the declared dimension of app_embeddings.embedding,
+// or 0 if the table does not exist yet
+func (db *DB) EmbeddingDimension(ctx context.Context) (int, error) {
+	var columnType string
+	err := db.QueryRowContext(ctx, `
+		SELECT column_type FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = 'app_embeddings' AND column_name = 'embedding'
+	`).Scan(&columnType)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read embedding column type: %w", err)
+	}
+
+	return parseVectorDimension(columnType)
+}
+
+// parseVectorDimension extracts N from a column type such as "vector(1536)"
+func parseVectorDimension(columnType string) (int, error) {
+	columnType = strings.ToLower(strings.TrimSpace(columnType))
+	open := strings.Index(columnType, "(")
+	end := strings.Index(columnType, ")")
+	if !strings.HasPrefix(columnType, "vector") || open < 0 || end < open {
+		return 0, fmt.Errorf("embedding column is %q, not a fixed-dimension VECTOR", columnType)
+	}
+
+	dim, err := strconv.Atoi(columnType[open+1 : end])
+	if err != nil {
+		return 0, fmt.Errorf("invalid vector dimension in %q", columnType)
+	}
+	return dim, nil
+}
+
+// embeddingsTableSQL creates app_embeddings for vectors of dim dimensions
+func embeddingsTableSQL(dim int) string {
+	return `CREATE TABLE IF NOT EXISTS app_embeddings (
+		    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+		    doc_id BIGINT NOT NULL,
+		    chunk_id INT NOT NULL,
+		    text TEXT NOT NULL,
+		    embedding VECTOR(` + strconv.Itoa(dim) + `) NOT NULL,
+		    metadata JSON,
+		    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		    FOREIGN KEY (doc_id) REFERENCES app_documents(id),
+		    VECTOR INDEX vec_idx ((VEC_COSINE_DISTANCE(embedding))),
+		    INDEX idx_doc_chunk (doc_id, chunk_id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+}
+
+// CheckEmbeddingDimension fails when app_embeddings exists with another
+// dimension than dim, which every insert and search would otherwise hit
+func (db *DB) CheckEmbeddingDimension(ctx context.Context, dim int) error {
+	columnDim, err := db.EmbeddingDimension(ctx)
+	if err != nil {
+		return err
+	}
+	if columnDim != 0 && columnDim != dim {
+		return fmt.Errorf("app_embeddings.embedding is VECTOR(%d) but the embedder produces %d dims; run 'agent seed-docs --recreate-embeddings' to rebuild it", columnDim, dim)
+	}
+	return nil
+}
+
+// RecreateEmbeddingsTable drops app_embeddings and creates it again for
+// vectors of dim dimensions. Documents are kept; their embeddings are gone
+// until they are seeded again.
+func (db *DB) RecreateEmbeddingsTable(ctx context.Context, dim int) error {
+	if _, err := db.ExecContext(ctx, "DROP TABLE IF EXISTS app_embeddings"); err != nil {
+		return fmt.Errorf("failed to drop app_embeddings: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, embeddingsTableSQL(dim)); err != nil {
+		return fmt.Errorf("failed to create app_embeddings: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/models"
+)
+
+// ErrSlowQueryNotFound is returned, wrapped with the id, for a slow query
+// that does not exist
+var ErrSlowQueryNotFound = errors.New("not found")
+
+// Page sizes of ListSlowQueries when the filter sets none, and at most
+const (
+	DefaultSlowQueryPageSize = 50
+	MaxSlowQueryPageSize     = 200
+)
+
+// SlowQueryStatuses and SlowQuerySources are the values the status and
+// source of a slow query can take
+var (
+	SlowQueryStatuses = []string{models.StatusPending, models.StatusAnalyzing, models.StatusCompleted, models.StatusSkipped, models.StatusUnsupported}
+	SlowQuerySources  = []string{models.SourceGenerated, models.SourceInformationSchema, models.SourceAdhoc, models.SourceSlowLog}
+)
+
+// SlowQueryFilter selects slow queries; zero fields match everything. Page
+// counts from 1.
+type SlowQueryFilter struct {
+	Target       string
+	Digest       string
+	Status       string
+	Source       string
+	DB           string
+	MinQueryTime float64
+	Since        time.Time
+	Page         int
+	PageSize     int
+}
+
+// ListSlowQueries returns a page of the matching slow queries, most recent
+// first, and how many match in all
+func (s *SlowQueryIngester) ListSlowQueries(ctx context.Context, filter SlowQueryFilter) ([]models.SlowQuery, int, error) {
+	var where []string
+	var args []any
+	if filter.Target != "" {
+		where, args = append(where, "target = ?"), append(args, filter.Target)
+	}
+	if filter.Digest != "" {
+		where, args = append(where, "digest = ?"), append(args, filter.Digest)
+	}
+	if filter.Status != "" {
+		where, args = append(where, "status = ?"), append(args, filter.Status)
+	}
+	if filter.Source != "" {
+		where, args = append(where, "source = ?"), append(args, filter.Source)
+	}
+	if filter.DB != "" {
+		where, args = append(where, "db = ?"), append(args, filter.DB)
+	}
+	if filter.MinQueryTime > 0 {
+		where, args = append(where, "query_time >= ?"), append(args, filter.MinQueryTime)
+	}
+	if !filter.Since.IsZero() {
+		where, args = append(where, "started_at >= ?"), append(args, filter.Since)
+	}
+	clause := ""
+	if len(where) > 0 {
+		clause = "WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM app_slow_queries "+clause, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count slow queries: %w", err)
+	}
+
+	page, pageSize := max(filter.Page, 1), filter.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultSlowQueryPageSize
+	}
+	pageSize = min(pageSize, MaxSlowQueryPageSize)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+slowQueryColumns+`
+		FROM app_slow_queries
+		`+clause+`
+		ORDER BY started_at DESC, id DESC
+		LIMIT ? OFFSET ?`, append(args, pageSize, (page-1)*pageSize)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list slow queries: %w", err)
+	}
+	defer rows.Close()
+
+	queries, err := scanSlowQueries(rows)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to scan slow queries: %w", err)
+	}
+	if queries == nil {
+		queries = []models.SlowQuery{}
+	}
+	return queries, total, nil
+}
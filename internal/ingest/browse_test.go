@@ -0,0 +1,234 @@
+package ingest
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/models"
+)
+
+// openBrowseDB returns an ingester on LATENTIA_TEST_DSN with an empty
+// app_slow_queries, skipping the test when the variable is not set
+func openBrowseDB(t *testing.T) (*SlowQueryIngester, *sql.DB) {
+	t.Helper()
+	dsn := os.Getenv("LATENTIA_TEST_DSN")
+	if dsn == "" {
+		t.Skip("LATENTIA_TEST_DSN is not set")
+	}
+	pool, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+
+	ctx := context.Background()
+	var name sql.NullString
+	if err := pool.QueryRowContext(ctx, "SELECT DATABASE()").Scan(&name); err != nil {
+		t.Fatalf("failed to reach database: %v", err)
+	}
+	if !strings.HasSuffix(name.String, "_test") {
+		t.Fatalf("LATENTIA_TEST_DSN selects database %q; use a scratch database ending in _test", name.String)
+	}
+	// The schema starts with app_slow_queries
+	create, _, _ := strings.Cut(database.AppSlowQueriesSQL, ";")
+	for _, stmt := range []string{"DROP TABLE IF EXISTS app_slow_queries", create} {
+		if _, err := pool.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("failed to prepare fixtures: %v\n%s", err, stmt)
+		}
+	}
+	return NewSlowQueryIngester(&database.DB{DB: pool}), pool
+}
+
+// browseFixture is a slow query of the listing fixtures
+type browseFixture struct {
+	target, digest, status, source, db string
+	queryTime                          float64
+	startedAt                          time.Time
+}
+
+var browseStart = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+// browseFixtures vary every filtered column, each value shared by several
+// rows so that combinations narrow the result step by step
+func browseFixtures() []browseFixture {
+	targets := []string{"default", "replica"}
+	digests := []string{"d1", "d2", "d3"}
+	dbs := []string{"shop", "billing", ""}
+	var fixtures []browseFixture
+	for i := range 30 {
+		fixtures = append(fixtures, browseFixture{
+			target:    targets[i%len(targets)],
+			digest:    digests[i%len(digests)],
+			status:    SlowQueryStatuses[i%len(SlowQueryStatuses)],
+			source:    SlowQuerySources[i%len(SlowQuerySources)],
+			db:        dbs[(i/2)%len(dbs)],
+			queryTime: float64(i%7) * 0.5,
+			startedAt: browseStart.Add(time.Duration(i%10) * time.Hour),
+		})
+	}
+	return fixtures
+}
+
+func insertBrowseFixtures(t *testing.T, pool *sql.DB, fixtures []browseFixture) {
+	t.Helper()
+	for i, f := range fixtures {
+		db := sql.NullString{String: f.db, Valid: f.db != ""}
+		_, err := pool.Exec(`INSERT INTO app_slow_queries (id, target, digest, sample_sql, started_at, query_time, db, source, status)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			i+1, f.target, f.digest, fmt.Sprintf("SELECT %d", i+1), f.startedAt, f.queryTime, db, f.source, f.status)
+		if err != nil {
+			t.Fatalf("failed to insert fixture %d: %v", i+1, err)
+		}
+	}
+}
+
+// matches is the reference for what filter selects
+func (f browseFixture) matches(filter SlowQueryFilter) bool {
+	return (filter.Target == "" || f.target == filter.Target) &&
+		(filter.Digest == "" || f.digest == filter.Digest) &&
+		(filter.Status == "" || f.status == filter.Status) &&
+		(filter.Source == "" || f.source == filter.Source) &&
+		(filter.DB == "" || f.db == filter.DB) &&
+		(filter.MinQueryTime <= 0 || f.queryTime >= filter.MinQueryTime) &&
+		(filter.Since.IsZero() || !f.startedAt.Before(filter.Since))
+}
+
+// expectedIDs lists the fixtures filter selects, most recent first
+func expectedIDs(fixtures []browseFixture, filter SlowQueryFilter) []int64 {
+	ids := []int64{}
+	for i, f := range fixtures {
+		if f.matches(filter) {
+			ids = append(ids, int64(i+1))
+		}
+	}
+	// The highest id first among equal times
+	slices.SortFunc(ids, func(a, b int64) int {
+		if c := fixtures[b-1].startedAt.Compare(fixtures[a-1].startedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(b, a)
+	})
+	return ids
+}
+
+// browseFilters set each filter field to a value some fixtures have
+var browseFilters = []func(*SlowQueryFilter){
+	func(f *SlowQueryFilter) { f.Target = "replica" },
+	func(f *SlowQueryFilter) { f.Digest = "d2" },
+	func(f *SlowQueryFilter) { f.Status = models.StatusPending },
+	func(f *SlowQueryFilter) { f.Source = models.SourceGenerated },
+	func(f *SlowQueryFilter) { f.DB = "shop" },
+	func(f *SlowQueryFilter) { f.MinQueryTime = 1.5 },
+	func(f *SlowQueryFilter) { f.Since = browseStart.Add(4 * time.Hour) },
+}
+
+func TestListSlowQueriesFilterCombinations(t *testing.T) {
+	s, pool := openBrowseDB(t)
+	fixtures := browseFixtures()
+	insertBrowseFixtures(t, pool, fixtures)
+
+	// Every subset of the filters, from none to all of them
+	for set := 0; set < 1<<len(browseFilters); set++ {
+		filter := SlowQueryFilter{PageSize: MaxSlowQueryPageSize}
+		for i, apply := range browseFilters {
+			if set&(1<<i) != 0 {
+				apply(&filter)
+			}
+		}
+		queries, total, err := s.ListSlowQueries(context.Background(), filter)
+		if err != nil {
+			t.Fatalf("ListSlowQueries(%+v): %v", filter, err)
+		}
+		ids := []int64{}
+		for _, q := range queries {
+			ids = append(ids, q.ID)
+		}
+		want := expectedIDs(fixtures, filter)
+		if total != len(want) || !reflect.DeepEqual(ids, want) {
+			t.Errorf("ListSlowQueries(%+v) = %v of %d, want %v", filter, ids, total, want)
+		}
+	}
+}
+
+func TestListSlowQueriesRows(t *testing.T) {
+	s, pool := openBrowseDB(t)
+	fixtures := browseFixtures()
+	insertBrowseFixtures(t, pool, fixtures)
+
+	queries, total, err := s.ListSlowQueries(context.Background(), SlowQueryFilter{Digest: "d1", DB: "shop", Target: "default"})
+	if err != nil {
+		t.Fatalf("ListSlowQueries: %v", err)
+	}
+	if total != len(queries) || total == 0 {
+		t.Fatalf("%d queries of %d", len(queries), total)
+	}
+	for _, q := range queries {
+		f := fixtures[q.ID-1]
+		if q.Target != f.target || q.Digest != f.digest || q.DB != f.db || q.Status != f.status || q.Source != f.source ||
+			q.QueryTime != f.queryTime || !q.StartedAt.Equal(f.startedAt) || q.SampleSQL != fmt.Sprintf("SELECT %d", q.ID) {
+			t.Errorf("row %d = %+v, want %+v", q.ID, q, f)
+		}
+	}
+
+	// No match is an empty page, not a null one
+	queries, total, err = s.ListSlowQueries(context.Background(), SlowQueryFilter{Digest: "unknown"})
+	if err != nil || total != 0 || queries == nil || len(queries) != 0 {
+		t.Errorf("ListSlowQueries of nothing = %v, %d, %v", queries, total, err)
+	}
+}
+
+func TestListSlowQueriesPages(t *testing.T) {
+	s, pool := openBrowseDB(t)
+	var fixtures []browseFixture
+	for i := range MaxSlowQueryPageSize + 10 {
+		fixtures = append(fixtures, browseFixture{
+			target: "default", digest: "d", status: models.StatusPending, source: models.SourceSlowLog,
+			startedAt: browseStart.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	insertBrowseFixtures(t, pool, fixtures)
+	all := expectedIDs(fixtures, SlowQueryFilter{})
+
+	tests := []struct {
+		name   string
+		filter SlowQueryFilter
+		want   []int64
+	}{
+		{"default page size", SlowQueryFilter{}, all[:DefaultSlowQueryPageSize]},
+		{"second page", SlowQueryFilter{Page: 2, PageSize: 15}, all[15:30]},
+		{"page 0 is the first", SlowQueryFilter{Page: 0, PageSize: 5}, all[:5]},
+		{"last partial page", SlowQueryFilter{Page: 14, PageSize: 16}, all[208:]},
+		{"past the end", SlowQueryFilter{Page: 100, PageSize: 10}, []int64{}},
+		{"size capped", SlowQueryFilter{PageSize: 1000}, all[:MaxSlowQueryPageSize]},
+		{"filters and pages together", SlowQueryFilter{Since: browseStart.Add(200 * time.Minute), Page: 2, PageSize: 4}, all[4:8]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries, total, err := s.ListSlowQueries(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatalf("ListSlowQueries: %v", err)
+			}
+			ids := []int64{}
+			for _, q := range queries {
+				ids = append(ids, q.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("ids = %v, want %v", ids, tt.want)
+			}
+			if want := len(expectedIDs(fixtures, tt.filter)); total != want {
+				t.Errorf("total = %d, want %d whatever the page", total, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package ingest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/matthieukhl/latentia/internal/config"
+)
+
+// Filter rule names, as reported in ingestion summaries
+const (
+	RuleMinQueryTime     = "min_query_time"
+	RuleIncludeDatabases = "include_databases"
+	RuleExcludeDatabases = "exclude_databases"
+	RuleExcludeUsers     = "exclude_users"
+	RuleExcludeDigests   = "exclude_digest_patterns"
+)
+
+// Filter applies ingest.filters to slow queries from any source and counts
+// how many each rule excluded
+type Filter struct {
+	filters  config.IngestFilters
+	excluded map[string]int
+}
+
+// NewFilter returns a filter for the given rules. Sources should build one
+// per ingestion run from config.Current() so reloaded filters take effect.
+func NewFilter(filters config.IngestFilters) *Filter {
+	return &Filter{filters: filters, excluded: map[string]int{}}
+}
+
+// Allow reports whether a slow query passes every rule, counting it against
+// the first rule that excludes it
+func (f *Filter) Allow(db, user, digest string, queryTime float64) bool {
+	rule := f.rule(db, user, digest, queryTime)
+	if rule == "" {
+		return true
+	}
+	f.excluded[rule]++
+	return false
+}
+
+func (f *Filter) rule(db, user, digest string, queryTime float64) string {
+	rules := f.filters
+	switch {
+	case queryTime < rules.MinQueryTime:
+		return RuleMinQueryTime
+	case len(rules.IncludeDatabases) > 0 && !containsFold(rules.IncludeDatabases, db):
+		return RuleIncludeDatabases
+	case containsFold(rules.ExcludeDatabases, db):
+		return RuleExcludeDatabases
+	case contains(rules.ExcludeUsers, user):
+		return RuleExcludeUsers
+	}
+	for _, re := range rules.ExcludeDigestRegexps() {
+		if re.MatchString(digest) {
+			return RuleExcludeDigests
+		}
+	}
+	return ""
+}
+
+// Excluded returns the number of slow queries excluded per rule
+func (f *Filter) Excluded() map[string]int {
+	return f.excluded
+}
+
+// ExcludedTotal returns the number of slow queries excluded by any rule
+func (f *Filter) ExcludedTotal() int {
+	total := 0
+	for _, n := range f.excluded {
+		total += n
+	}
+	return total
+}
+
+// FormatExcluded renders per-rule counts as "rule=n, ..." sorted by rule
+func FormatExcluded(excluded map[string]int) string {
+	rules := make([]string, 0, len(excluded))
+	for rule := range excluded {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+
+	parts := make([]string, len(rules))
+	for i, rule := range rules {
+		parts[i] = fmt.Sprintf("%s=%d", rule, excluded[rule])
+	}
+	return strings.Join(parts, ", ")
+}
+
+func containsFold(slice []string, item string) bool {
+	for _, s := range slice {
+		if strings.EqualFold(s, item) {
+			return true
+		}
+	}
+	return false
+}
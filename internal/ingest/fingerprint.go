@@ -0,0 +1,318 @@
+package ingest
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"strings"
+
+	"github.com/matthieukhl/latentia/internal/models"
+)
+
+// generateSQLDigest fingerprints a statement for queries TiDB gave no
+// digest, such as generated, ad-hoc and MySQL slow log ones: the MD5 of its
+// normalized text, so occurrences that only differ in their parameters
+// share a digest
+func generateSQLDigest(query string) string {
+	return normalizedDigest(normalizeSQL(query))
+}
+
+func normalizedDigest(normalized string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(normalized)))
+}
+
+// isGeneratedDigest reports whether digest was made by generateSQLDigest,
+// an MD5, rather than by TiDB, whose digests are SHA-256
+func isGeneratedDigest(digest string) bool {
+	if len(digest) != 2*md5.Size {
+		return false
+	}
+	for i := 0; i < len(digest); i++ {
+		if !strings.ContainsRune("0123456789abcdef", rune(digest[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// DigestBackfill reports what BackfillDigests did
+type DigestBackfill struct {
+	// Normalized counts the slow queries given their normalized text
+	Normalized int64
+	// Renamed counts the digests replaced, and Moved the slow queries that
+	// had them
+	Renamed int
+	Moved   int64
+}
+
+// BackfillDigests stores the normalized text of the slow queries ingested
+// before it was, batchSize at a time. Digests made by an earlier version of
+// generateSQLDigest are recomputed, merging the statistics and suppressions
+// of queries that only differed in their literals; TiDB's digests are kept.
+// Rows are only read while they lack a normalized text, so it can be
+// interrupted and run again.
+func (s *SlowQueryIngester) BackfillDigests(ctx context.Context, batchSize int) (*DigestBackfill, error) {
+	summary := &DigestBackfill{}
+	for {
+		batch, err := s.unnormalizedSlowQueries(ctx, batchSize)
+		if err != nil || len(batch) == 0 {
+			return summary, err
+		}
+
+		renamed := map[string]bool{}
+		for _, q := range batch {
+			if renamed[q.Digest] {
+				// Moved along with an earlier row of the batch
+				continue
+			}
+			normalized := normalizeSQL(q.SampleSQL)
+			if digest := normalizedDigest(normalized); isGeneratedDigest(q.Digest) && digest != q.Digest {
+				moved, err := s.db.RenameDigest(ctx, q.Digest, digest, normalized)
+				if err != nil {
+					return summary, fmt.Errorf("failed to rename digest %s: %w", q.Digest, err)
+				}
+				renamed[q.Digest] = true
+				summary.Renamed++
+				summary.Moved += moved
+				summary.Normalized += moved
+				continue
+			}
+			if _, err := s.db.ExecContext(ctx,
+				"UPDATE app_slow_queries SET normalized_sql = ? WHERE id = ?", normalized, q.ID); err != nil {
+				return summary, fmt.Errorf("failed to update slow query %d: %w", q.ID, err)
+			}
+			summary.Normalized++
+		}
+	}
+}
+
+// unnormalizedSlowQueries returns the id, digest and SQL of up to limit slow
+// queries without a normalized text
+func (s *SlowQueryIngester) unnormalizedSlowQueries(ctx context.Context, limit int) ([]models.SlowQuery, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, digest, sample_sql FROM app_slow_queries
+		WHERE normalized_sql IS NULL
+		ORDER BY id LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query slow queries: %w", err)
+	}
+	defer rows.Close()
+
+	var queries []models.SlowQuery
+	for rows.Next() {
+		var q models.SlowQuery
+		if err := rows.Scan(&q.ID, &q.Digest, &q.SampleSQL); err != nil {
+			return nil, fmt.Errorf("failed to scan slow query: %w", err)
+		}
+		queries = append(queries, q)
+	}
+	return queries, rows.Err()
+}
+
+// normalizeSQL returns the text a statement's digest is computed from,
+// close to TiDB's normalized SQL: comments, hints included, are dropped,
+// string, numeric and hex literals become ?, the lists of IN and VALUES
+// collapse to (...), and words are lower-cased and separated by single
+// spaces.
+//
+//	SELECT * FROM t WHERE id IN (1, 2, 3) AND name = 'x'  -- comment
+//	select * from t where id in (...) and name = ?
+func normalizeSQL(query string) string {
+	var tokens []string
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '#' || strings.HasPrefix(query[i:], "-- ") || strings.HasPrefix(query[i:], "--\n"):
+			if nl := strings.IndexByte(query[i:], '\n'); nl >= 0 {
+				i += nl + 1
+			} else {
+				i = len(query)
+			}
+		case strings.HasPrefix(query[i:], "/*"):
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += 2 + end + 2
+			} else {
+				i = len(query)
+			}
+		case c == '\'' || c == '"':
+			tokens = append(tokens, "?")
+			i = quotedEnd(query, i)
+		case c == '`':
+			end := quotedEnd(query, i)
+			tokens = append(tokens, strings.ToLower(strings.ReplaceAll(strings.Trim(query[i:end], "`"), "``", "`")))
+			i = end
+		case (c == 'x' || c == 'X' || c == 'b' || c == 'B') && i+1 < len(query) && query[i+1] == '\'':
+			// X'0A' and b'101'
+			tokens = append(tokens, "?")
+			i = quotedEnd(query, i+1)
+		case isDigit(c) || c == '.' && i+1 < len(query) && isDigit(query[i+1]) && !afterName(tokens):
+			start := i
+			for i < len(query) && (isWordChar(query[i]) || query[i] == '.' ||
+				(query[i] == '+' || query[i] == '-') && (query[i-1] == 'e' || query[i-1] == 'E')) {
+				i++
+			}
+			qualified := len(tokens) > 1 && tokens[len(tokens)-1] == "." && afterName(tokens[:len(tokens)-1])
+			if isNumeric(query[start:i]) && !qualified {
+				tokens = append(tokens, "?")
+			} else {
+				// An identifier starting with digits, like 1st_quarter or
+				// the 5 of t.5
+				tokens = append(tokens, strings.ToLower(query[start:i]))
+			}
+		case isWordChar(c):
+			start := i
+			for i < len(query) && isWordChar(query[i]) {
+				i++
+			}
+			tokens = append(tokens, strings.ToLower(query[start:i]))
+		default:
+			op := string(c)
+			for _, candidate := range []string{"<=>", "<>", "!=", "<=", ">=", ":=", "||", "&&", "<<", ">>"} {
+				if strings.HasPrefix(query[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			tokens = append(tokens, op)
+			i += len(op)
+		}
+	}
+	if n := len(tokens); n > 0 && tokens[n-1] == ";" {
+		tokens = tokens[:n-1]
+	}
+	return joinNormalized(collapseLists(tokens))
+}
+
+// collapseLists replaces the parenthesized lists of placeholders after IN
+// and VALUES with (...), so IN lists and multi-row inserts of any length
+// share a digest
+func collapseLists(tokens []string) []string {
+	var out []string
+	for i := 0; i < len(tokens); i++ {
+		out = append(out, tokens[i])
+		if tokens[i] != "in" && tokens[i] != "values" && tokens[i] != "value" {
+			continue
+		}
+		rows := 0
+		j := i + 1
+		for {
+			end, ok := placeholderList(tokens, j)
+			if !ok {
+				break
+			}
+			rows++
+			j = end
+			if tokens[i] == "in" || j >= len(tokens) || tokens[j] != "," {
+				break
+			}
+			if _, ok := placeholderList(tokens, j+1); !ok {
+				break
+			}
+			j++
+		}
+		if rows > 0 {
+			out = append(out, "(", "...", ")")
+			i = j - 1
+		}
+	}
+	return out
+}
+
+// placeholderList returns the index past the "( ?, ?, ... )" starting at
+// start, with ok false when tokens[start:] does not start with one
+func placeholderList(tokens []string, start int) (end int, ok bool) {
+	if start >= len(tokens) || tokens[start] != "(" {
+		return 0, false
+	}
+	for i := start + 1; i < len(tokens); i += 2 {
+		if tokens[i] != "?" && tokens[i] != "..." || i+1 >= len(tokens) {
+			return 0, false
+		}
+		switch tokens[i+1] {
+		case ")":
+			return i + 2, true
+		case ",":
+		default:
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// joinNormalized separates tokens by single spaces except around dots of
+// qualified names, after an opening and before a closing parenthesis or a
+// comma
+func joinNormalized(tokens []string) string {
+	var b strings.Builder
+	for i, tok := range tokens {
+		if i > 0 {
+			prev := tokens[i-1]
+			if prev != "(" && prev != "." && tok != ")" && tok != "," && tok != "." {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(tok)
+	}
+	return b.String()
+}
+
+// quotedEnd returns the index just past the quoted token starting at start,
+// honouring doubled quotes and backslash escapes
+func quotedEnd(query string, start int) int {
+	quote := query[start]
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// afterName reports whether the last token is a name, so ".5" after t is
+// the qualified name t.5 rather than a number
+func afterName(tokens []string) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	last := tokens[len(tokens)-1]
+	return last == ")" || last != "?" && isWordChar(last[len(last)-1])
+}
+
+// isNumeric reports whether word is a decimal, exponent or 0x hexadecimal
+// literal
+func isNumeric(word string) bool {
+	if hex, ok := strings.CutPrefix(strings.ToLower(word), "0x"); ok {
+		return hex != "" && strings.Trim(hex, "0123456789abcdef") == ""
+	}
+	mantissa, exponent, hasExponent := strings.Cut(strings.ToLower(word), "e")
+	if hasExponent {
+		exponent = strings.TrimLeft(exponent, "+-")
+		if exponent == "" || strings.Trim(exponent, "0123456789") != "" {
+			return false
+		}
+	}
+	if strings.Count(mantissa, ".") > 1 {
+		return false
+	}
+	digits := strings.ReplaceAll(mantissa, ".", "")
+	return digits != "" && strings.Trim(digits, "0123456789") == ""
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || isDigit(c) || c == '_' || c == '$' || c >= 0x80
+}
@@ -0,0 +1,126 @@
+package ingest
+
+import "testing"
+
+func TestNormalizeSQLLiterals(t *testing.T) {
+	tests := []struct{ sql, want string }{
+		{"SELECT * FROM orders WHERE id = 1", "select * from orders where id = ?"},
+		{"SELECT * FROM orders WHERE total > 10.5", "select * from orders where total > ?"},
+		{"SELECT * FROM orders WHERE total > .5", "select * from orders where total > ?"},
+		{"SELECT * FROM orders WHERE total < 1e-3", "select * from orders where total < ?"},
+		{"SELECT * FROM t WHERE flags = 0xFF", "select * from t where flags = ?"},
+		{"SELECT * FROM t WHERE data = X'0A0B' OR bits = b'101'", "select * from t where data = ? or bits = ?"},
+		{"SELECT * FROM customers WHERE name = 'O''Brien'", "select * from customers where name = ?"},
+		{`SELECT * FROM customers WHERE name = "it's \"x\""`, "select * from customers where name = ?"},
+		{`SELECT * FROM t WHERE a = 'back\'slash' AND b = 2`, "select * from t where a = ? and b = ?"},
+		{"SELECT * FROM orders WHERE id = -1", "select * from orders where id = - ?"},
+		{"SELECT * FROM orders LIMIT 10 OFFSET 20", "select * from orders limit ? offset ?"},
+		// Digits in names are not literals
+		{"SELECT t.5, col1 FROM t2 JOIN q1_2024 ON 1st_quarter = 3", "select t.5, col1 from t2 join q1_2024 on 1st_quarter = ?"},
+		{"SELECT `order`.`id` FROM `Order` `order`", "select order.id from order order"},
+		{"SELECT id FROM orders;", "select id from orders"},
+	}
+	for _, tt := range tests {
+		if got := normalizeSQL(tt.sql); got != tt.want {
+			t.Errorf("normalizeSQL(%q)\n = %q\nwant %q", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeSQLCollapsesLists(t *testing.T) {
+	tests := []struct{ sql, want string }{
+		{"SELECT * FROM t WHERE id IN (1)", "select * from t where id in (...)"},
+		{"SELECT * FROM t WHERE id IN (1, 2, 3)", "select * from t where id in (...)"},
+		{"SELECT * FROM t WHERE name IN ('a','b') AND id NOT IN (4,5)", "select * from t where name in (...) and id not in (...)"},
+		{"INSERT INTO t (a, b) VALUES (1, 'x')", "insert into t (a, b) values (...)"},
+		{"INSERT INTO t (a, b) VALUES (1, 'x'), (2, 'y'), (3, 'z')", "insert into t (a, b) values (...)"},
+		{"INSERT INTO t VALUE (1)", "insert into t value (...)"},
+		// Lists holding anything but literals are kept
+		{"SELECT * FROM t WHERE id IN (SELECT id FROM u)", "select * from t where id in (select id from u)"},
+		{"SELECT * FROM t WHERE id IN (a, 2)", "select * from t where id in (a, ?)"},
+		{"SELECT * FROM t WHERE (a, b) IN ((1, 2), (3, 4))", "select * from t where (a, b) in ((?, ?), (?, ?))"},
+		{"INSERT INTO t VALUES (1, NOW())", "insert into t values (?, now ())"},
+	}
+	for _, tt := range tests {
+		if got := normalizeSQL(tt.sql); got != tt.want {
+			t.Errorf("normalizeSQL(%q)\n = %q\nwant %q", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestDigestCollapsesParameterVariants(t *testing.T) {
+	groups := [][]string{
+		{
+			"SELECT * FROM orders WHERE id = 1",
+			"SELECT * FROM orders WHERE id = 42",
+			"SELECT * FROM orders WHERE id = '42'",
+		},
+		{
+			"SELECT * FROM orders WHERE customer_id IN (1, 2)",
+			"SELECT * FROM orders WHERE customer_id IN (7, 8, 9, 10)",
+		},
+		{
+			"INSERT INTO events (kind) VALUES ('a')",
+			"INSERT INTO events (kind) VALUES ('b'), ('c')",
+		},
+	}
+	for _, group := range groups {
+		want := generateSQLDigest(group[0])
+		for _, sql := range group[1:] {
+			if got := generateSQLDigest(sql); got != want {
+				t.Errorf("digest of %q = %s, want the digest of %q, %s", sql, got, group[0], want)
+			}
+		}
+	}
+}
+
+// Case, spacing and comments are not part of a statement, as in TiDB's
+// digests: they do not split one. Anything else does.
+func TestDigestCasingAndComments(t *testing.T) {
+	base := "SELECT id FROM orders WHERE status = 'paid'"
+	for _, same := range []string{
+		"select id from orders where status = 'paid'",
+		"Select Id From Orders Where Status = 'paid'",
+		"SELECT id\n\tFROM   orders\nWHERE status='paid'",
+		"SELECT id FROM orders WHERE status = 'paid' -- dashboard",
+		"SELECT id FROM orders WHERE status = 'paid' # dashboard",
+		"/* app=checkout */ SELECT id FROM orders WHERE status = 'paid'",
+		"SELECT /*+ USE_INDEX(orders, idx_status) */ id FROM orders WHERE status = 'paid'",
+		"SELECT id FROM orders WHERE status = 'PAID'",
+		"SELECT `id` FROM `orders` WHERE status = 'paid';",
+	} {
+		if generateSQLDigest(same) != generateSQLDigest(base) {
+			t.Errorf("%q got its own digest, want the digest of %q:\n%q\n%q", same, base, normalizeSQL(same), normalizeSQL(base))
+		}
+	}
+
+	for _, other := range []string{
+		"SELECT id FROM orders WHERE state = 'paid'",
+		"SELECT id FROM orders WHERE status <> 'paid'",
+		"SELECT id, total FROM orders WHERE status = 'paid'",
+		"SELECT id FROM orders_archive WHERE status = 'paid'",
+		"SELECT id FROM orders WHERE status = 'paid' ORDER BY id",
+		// Not comments: inside a literal, or "--" without a space
+		"SELECT id FROM orders WHERE status = 'paid' AND note = '-- x' OR id = 1--1",
+		"SELECT id FROM orders WHERE status = paid",
+	} {
+		if generateSQLDigest(other) == generateSQLDigest(base) {
+			t.Errorf("%q shares the digest of %q, both normalized to %q", other, base, normalizeSQL(base))
+		}
+	}
+}
+
+func TestIsGeneratedDigest(t *testing.T) {
+	for digest, want := range map[string]bool{
+		generateSQLDigest("SELECT 1"):                                      true,
+		"0123456789abcdef0123456789abcdef":                                 true,
+		"0123456789ABCDEF0123456789ABCDEF":                                 false,
+		"e5796985fb93de3d9e8d2c3df1b8a3f0b2b3c1c4e5796985fb93de3d9e8d2c3d": false,
+		"":                                 false,
+		"0123456789abcdef0123456789abcdeg": false,
+	} {
+		if got := isGeneratedDigest(digest); got != want {
+			t.Errorf("isGeneratedDigest(%q) = %v, want %v", digest, got, want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config"
+)
+
+// ErrSlowQueryUnavailable is returned by IngestFromInformationSchema when
+// INFORMATION_SCHEMA.SLOW_QUERY cannot be read, as in managed TiDB
+var ErrSlowQueryUnavailable = errors.New("INFORMATION_SCHEMA.SLOW_QUERY is not accessible (common in managed TiDB)")
+
+// IngestStatus is the outcome of the latest scheduled ingestion
+type IngestStatus struct {
+	LastRun  *time.Time `json:"last_run"`
+	Duration float64    `json:"duration_seconds"`
+
+	// Available is false while INFORMATION_SCHEMA.SLOW_QUERY cannot be read
+	// on any of the targets; UnavailableTargets names those where it cannot
+	Available          bool     `json:"available"`
+	UnavailableTargets []string `json:"unavailable_targets,omitempty"`
+	Error              string   `json:"error,omitempty"`
+
+	Fetched    int            `json:"fetched"`
+	Inserted   int            `json:"inserted"`
+	Duplicates int            `json:"duplicates"`
+	Suppressed int            `json:"suppressed"`
+	Linked     int            `json:"linked"`
+	Excluded   map[string]int `json:"excluded,omitempty"`
+	RunCount   int            `json:"run_count"`
+}
+
+// ScheduledIngest runs IngestFromInformationSchema on every target as a
+// background job with the limits under worker, keeping the outcome of its
+// latest run summed over the targets. A cluster whose slow query table
+// cannot be read is warned about once, not on every run, until it can be
+// read again.
+type ScheduledIngest struct {
+	ingesters []*SlowQueryIngester
+
+	mu          sync.Mutex
+	status      IngestStatus
+	unavailable map[string]bool
+}
+
+// NewScheduledIngest wraps the ingesters of each target for scheduling
+func NewScheduledIngest(ingesters ...*SlowQueryIngester) *ScheduledIngest {
+	return &ScheduledIngest{ingesters: ingesters, unavailable: map[string]bool{}}
+}
+
+// Run ingests once from each target, going on with the others when one
+// fails; it is the job's run function
+func (s *ScheduledIngest) Run(ctx context.Context) error {
+	started := time.Now()
+	limits := config.Current().Worker
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = IngestStatus{LastRun: &started, Available: true, RunCount: s.status.RunCount + 1}
+	var errs []error
+	for _, ingester := range s.ingesters {
+		target := ingester.Target()
+		summary, err := ingester.IngestFromInformationSchema(ctx, limits.IngestMinTime, limits.IngestLimit)
+		if summary != nil {
+			s.status.Fetched += summary.Fetched
+			s.status.Inserted += summary.Inserted
+			s.status.Duplicates += summary.Duplicates
+			s.status.Suppressed += summary.Suppressed
+			s.status.Linked += summary.Linked
+			for rule, count := range summary.Excluded {
+				if s.status.Excluded == nil {
+					s.status.Excluded = map[string]int{}
+				}
+				s.status.Excluded[rule] += count
+			}
+		}
+
+		switch {
+		case errors.Is(err, ErrSlowQueryUnavailable):
+			if !s.unavailable[target] {
+				slog.WarnContext(ctx, "slow query ingestion skipped until INFORMATION_SCHEMA.SLOW_QUERY is accessible", "target", target, "error", err)
+			}
+			s.unavailable[target] = true
+			s.status.UnavailableTargets = append(s.status.UnavailableTargets, target)
+			continue
+		case s.unavailable[target]:
+			slog.InfoContext(ctx, "INFORMATION_SCHEMA.SLOW_QUERY is accessible again", "target", target)
+			delete(s.unavailable, target)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("target %s: %w", target, err))
+			continue
+		}
+
+		slog.InfoContext(ctx, "slow queries ingested", "target", target,
+			"fetched", summary.Fetched, "inserted", summary.Inserted, "duplicates", summary.Duplicates, "suppressed", summary.Suppressed, "linked", summary.Linked,
+			"excluded", FormatExcluded(summary.Excluded))
+	}
+	s.status.Duration = time.Since(started).Seconds()
+	s.status.Available = len(s.status.UnavailableTargets) < len(s.ingesters)
+
+	err := errors.Join(errs...)
+	switch {
+	case err != nil:
+		s.status.Error = err.Error()
+	case !s.status.Available:
+		s.status.Error = ErrSlowQueryUnavailable.Error()
+	}
+	return err
+}
+
+// Status returns the outcome of the latest run, with a nil LastRun before
+// the first
+func (s *ScheduledIngest) Status() IngestStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
@@ -1,78 +1,208 @@
 package ingest
 
 import (
-	"crypto/md5"
+	"context"
+	"database/sql"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/matthieukhl/latentia/internal/config"
 	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/metrics"
 	"github.com/matthieukhl/latentia/internal/models"
 )
 
+// SlowQueryIngester reads and stores the slow queries of the target of its
+// DB, which tags the rows it inserts; see database.DB.ForTarget
 type SlowQueryIngester struct {
 	db *database.DB
+
+	// target serves INFORMATION_SCHEMA.SLOW_QUERY; the slow queries are
+	// stored through db
+	target *sql.DB
 }
 
 func NewSlowQueryIngester(db *database.DB) *SlowQueryIngester {
-	return &SlowQueryIngester{db: db}
+	return &SlowQueryIngester{db: db, target: db.TargetDB()}
+}
+
+// Target returns the name of the target the ingester reads and tags
+func (s *SlowQueryIngester) Target() string {
+	return s.db.TargetName()
 }
 
-// RecordGeneratedSlowQuery records a slow query that we generated ourselves
-func (s *SlowQueryIngester) RecordGeneratedSlowQuery(query string, startTime time.Time, queryTime float64, database string, user string) error {
+// RecordGeneratedSlowQuery records a slow query that we generated ourselves,
+// with plan the EXPLAIN ANALYZE output captured for it or "" when none was
+func (s *SlowQueryIngester) RecordGeneratedSlowQuery(ctx context.Context, query string, startTime time.Time, queryTime float64, database string, user string, plan string) error {
 	digest := generateSQLDigest(query)
 	
-	_, err := s.db.Exec(`
+	_, err := s.db.ExecContext(ctx, `
 		INSERT INTO app_slow_queries (
-			digest, sample_sql, started_at, query_time, db, 
-			index_names, is_internal, user, host, tables, source
-		) VALUES (?, ?, ?, ?, ?, '', FALSE, ?, '', '[]', ?)
-	`, digest, query, startTime, queryTime, database, user, models.SourceGenerated)
+			target, digest, sample_sql, normalized_sql, started_at, query_time, db, 
+			index_names, is_internal, user, host, tables, source, actual_plan
+		) VALUES (?, ?, ?, ?, ?, ?, ?, '', FALSE, ?, '', '[]', ?, NULLIF(?, ''))
+	`, s.db.TargetName(), digest, query, normalizeSQL(query), startTime, queryTime, database, user, models.SourceGenerated, plan)
+	if err != nil {
+		return err
+	}
+	metrics.SlowQueriesIngested.Inc(models.SourceGenerated, models.StatusPending)
 	
-	return err
+	return s.db.RecordSlowQueryOccurrence(ctx, digest, query, database, queryTime, startTime)
 }
 
-// IngestFromInformationSchema reads slow queries from INFORMATION_SCHEMA.SLOW_QUERY
-func (s *SlowQueryIngester) IngestFromInformationSchema(minQueryTime float64, limit int) error {
+// RecordAdhocQuery records SQL submitted for analysis rather than captured
+// from the cluster. It is stored as analyzing, so the analyze job never
+// picks it up, and without an occurrence: it has no real execution time to
+// count in statistics.
+func (s *SlowQueryIngester) RecordAdhocQuery(ctx context.Context, query string, database string) (*models.SlowQuery, error) {
+	q := &models.SlowQuery{
+		Target:        s.db.TargetName(),
+		Digest:        generateSQLDigest(query),
+		SampleSQL:     query,
+		NormalizedSQL: normalizeSQL(query),
+		StartedAt:     time.Now(),
+		DB:            database,
+		Tables:        []byte("[]"),
+		Source:        models.SourceAdhoc,
+		Status:        models.StatusAnalyzing,
+	}
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO app_slow_queries (
+			target, digest, sample_sql, normalized_sql, started_at, query_time, db,
+			index_names, is_internal, user, host, tables, source, status
+		) VALUES (?, ?, ?, ?, ?, 0, ?, '', FALSE, '', '', ?, ?, ?)
+	`, q.Target, q.Digest, q.SampleSQL, q.NormalizedSQL, q.StartedAt, q.DB, string(q.Tables), q.Source, q.Status)
+	if err != nil {
+		return nil, err
+	}
+	if q.ID, err = result.LastInsertId(); err != nil {
+		return nil, err
+	}
+	metrics.SlowQueriesIngested.Inc(q.Source, q.Status)
+	return q, nil
+}
+
+// IngestSummary reports what one ingestion run did
+type IngestSummary struct {
+	Fetched    int
+	Inserted   int
+	Duplicates int
+	Excluded   map[string]int
+
+	// Suppressed counts inserted slow queries tagged as skipped because an
+	// active suppression matched their digest
+	Suppressed int
+
+	// Linked counts inserted slow queries stored as completed because their
+	// digest was already analyzed; they share its best rewrite
+	Linked int
+}
+
+// IngestFromInformationSchema reads slow queries from INFORMATION_SCHEMA.SLOW_QUERY,
+// applying the configured ingest.filters. Occurrences of suppressed digests
+// are stored as skipped so they still count in statistics, and those of
+// already analyzed digests as completed, linked to the same rewrite, so each
+// digest is sent to the LLM once.
+func (s *SlowQueryIngester) IngestFromInformationSchema(ctx context.Context, minQueryTime float64, limit int) (*IngestSummary, error) {
 	// First, check if we can access INFORMATION_SCHEMA.SLOW_QUERY
-	canAccess, err := s.canAccessInformationSchema()
+	canAccess, err := s.canAccessInformationSchema(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to check INFORMATION_SCHEMA access: %w", err)
+		return nil, fmt.Errorf("failed to check INFORMATION_SCHEMA access: %w", err)
 	}
 	
 	if !canAccess {
-		return fmt.Errorf("INFORMATION_SCHEMA.SLOW_QUERY is not accessible (common in managed TiDB)")
+		return nil, ErrSlowQueryUnavailable
 	}
 	
 	// Fetch slow queries from INFORMATION_SCHEMA
-	queries, err := s.fetchFromInformationSchema(minQueryTime, limit)
+	queries, err := s.fetchFromInformationSchema(ctx, minQueryTime, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch from INFORMATION_SCHEMA: %w", err)
+	}
+	
+	converted := make([]models.SlowQuery, 0, len(queries))
+	for _, q := range queries {
+		startTime, err := time.Parse("2006-01-02 15:04:05", q.StartTime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse start time: %w", err)
+		}
+		converted = append(converted, models.SlowQuery{
+			Digest:     q.Digest,
+			SampleSQL:  q.Query,
+			StartedAt:  startTime,
+			QueryTime:  q.QueryTime,
+			DB:         q.DB,
+			IndexNames: q.IndexNames,
+			IsInternal: q.IsInternal,
+			User:       q.User,
+			Host:       q.Host,
+			Source:     models.SourceInformationSchema,
+		})
+	}
+	return s.ingest(ctx, converted)
+}
+
+// ingest inserts slow queries read from the cluster, applying the
+// configured ingest.filters and skipping those already stored with the same
+// digest and start time
+func (s *SlowQueryIngester) ingest(ctx context.Context, queries []models.SlowQuery) (*IngestSummary, error) {
+	suppressions, err := s.db.ActiveSuppressions(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to fetch from INFORMATION_SCHEMA: %w", err)
+		return nil, err
 	}
 	
+	filter := NewFilter(config.Current().Ingest.Filters)
+	summary := &IngestSummary{Fetched: len(queries), Excluded: filter.Excluded()}
+	
 	// Insert new queries (avoid duplicates based on digest + start_time)
-	inserted := 0
 	for _, query := range queries {
-		exists, err := s.slowQueryExists(query.Digest, query.StartTime)
+		if !filter.Allow(query.DB, query.User, query.Digest, query.QueryTime) {
+			continue
+		}
+		
+		// started_at has no fractional seconds
+		query.StartedAt = query.StartedAt.Truncate(time.Second)
+		exists, err := s.slowQueryExists(ctx, query.Digest, query.StartedAt)
 		if err != nil {
-			return fmt.Errorf("failed to check if query exists: %w", err)
+			return summary, fmt.Errorf("failed to check if query exists: %w", err)
+		}
+		
+		if exists {
+			summary.Duplicates++
+			continue
 		}
 		
-		if !exists {
-			err = s.insertInformationSchemaQuery(query)
+		var skipReason string
+		if suppression := suppressions.Match(query.Digest); suppression != nil {
+			skipReason = database.SuppressedPrefix + suppression.Reason
+			summary.Suppressed++
+		}
+		
+		var analyzed *analyzedDigest
+		if skipReason == "" {
+			analyzed, err = s.analyzedDigest(ctx, query.Digest)
 			if err != nil {
-				return fmt.Errorf("failed to insert query: %w", err)
+				return summary, fmt.Errorf("failed to check if digest was analyzed: %w", err)
+			}
+			if analyzed != nil {
+				summary.Linked++
 			}
-			inserted++
 		}
+		
+		err = s.insertSlowQuery(ctx, query, skipReason, analyzed)
+		if err != nil {
+			return summary, fmt.Errorf("failed to insert query: %w", err)
+		}
+		summary.Inserted++
 	}
 	
-	return nil
+	return summary, nil
 }
 
 // canAccessInformationSchema checks if we can read from INFORMATION_SCHEMA.SLOW_QUERY
-func (s *SlowQueryIngester) canAccessInformationSchema() (bool, error) {
-	_, err := s.db.Exec("SELECT 1 FROM INFORMATION_SCHEMA.SLOW_QUERY LIMIT 1")
+func (s *SlowQueryIngester) canAccessInformationSchema(ctx context.Context) (bool, error) {
+	_, err := s.target.ExecContext(ctx, "SELECT 1 FROM INFORMATION_SCHEMA.SLOW_QUERY LIMIT 1")
 	if err != nil {
 		if strings.Contains(err.Error(), "command denied") || 
 		   strings.Contains(err.Error(), "Unknown table") ||
@@ -85,7 +215,7 @@ func (s *SlowQueryIngester) canAccessInformationSchema() (bool, error) {
 }
 
 // fetchFromInformationSchema retrieves slow queries from INFORMATION_SCHEMA
-func (s *SlowQueryIngester) fetchFromInformationSchema(minQueryTime float64, limit int) ([]models.InformationSchemaSlowQuery, error) {
+func (s *SlowQueryIngester) fetchFromInformationSchema(ctx context.Context, minQueryTime float64, limit int) ([]models.InformationSchemaSlowQuery, error) {
 	query := `
 		SELECT 
 			Start_time,
@@ -102,7 +232,7 @@ func (s *SlowQueryIngester) fetchFromInformationSchema(minQueryTime float64, lim
 		ORDER BY Start_time DESC 
 		LIMIT ?`
 	
-	rows, err := s.db.Query(query, minQueryTime, limit)
+	rows, err := s.target.QueryContext(ctx, query, minQueryTime, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -134,96 +264,326 @@ func (s *SlowQueryIngester) fetchFromInformationSchema(minQueryTime float64, lim
 }
 
 // slowQueryExists checks if a slow query with the same digest and start time already exists
-func (s *SlowQueryIngester) slowQueryExists(digest, startTime string) (bool, error) {
+// in the same target
+func (s *SlowQueryIngester) slowQueryExists(ctx context.Context, digest string, startTime time.Time) (bool, error) {
 	var count int
-	err := s.db.QueryRow(
-		"SELECT COUNT(*) FROM app_slow_queries WHERE digest = ? AND started_at = ?",
-		digest, startTime,
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM app_slow_queries WHERE target = ? AND digest = ? AND started_at = ?",
+		s.db.TargetName(), digest, startTime,
 	).Scan(&count)
 	
 	return count > 0, err
 }
 
-// insertInformationSchemaQuery inserts a query from INFORMATION_SCHEMA into our app table
-func (s *SlowQueryIngester) insertInformationSchemaQuery(q models.InformationSchemaSlowQuery) error {
-	// Parse start time
-	startTime, err := time.Parse("2006-01-02 15:04:05", q.StartTime)
+// analyzedDigest is the analysis an earlier occurrence of a digest got
+type analyzedDigest struct {
+	analyzedAt    sql.NullTime
+	bestRewriteID sql.NullInt64
+}
+
+// analyzedDigest returns the latest completed analysis of digest in the
+// same target, nil if it was never analyzed there
+func (s *SlowQueryIngester) analyzedDigest(ctx context.Context, digest string) (*analyzedDigest, error) {
+	var a analyzedDigest
+	err := s.db.QueryRowContext(ctx, `
+		SELECT last_analyzed_at, best_rewrite_id FROM app_slow_queries
+		WHERE target = ? AND digest = ? AND status = ?
+		ORDER BY last_analyzed_at DESC LIMIT 1`, s.db.TargetName(), digest, models.StatusCompleted).Scan(&a.analyzedAt, &a.bestRewriteID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to parse start time: %w", err)
+		return nil, err
 	}
-	
+	return &a, nil
+}
+
+// insertSlowQuery inserts a slow query read from the cluster into our app
+// table, as skipped when skipReason is set and as completed when analyzed is
+// set
+func (s *SlowQueryIngester) insertSlowQuery(ctx context.Context, q models.SlowQuery, skipReason string, analyzed *analyzedDigest) error {
 	// Extract table names from query (simplified)
-	tables := extractTableNames(q.Query)
+	tables := extractTableNames(q.SampleSQL)
 	tablesJSON := fmt.Sprintf(`["%s"]`, strings.Join(tables, `","`))
 	
-	_, err = s.db.Exec(`
+	status := models.StatusPending
+	if skipReason != "" {
+		status = models.StatusSkipped
+		skipReason = truncateReason(skipReason)
+	}
+	if analyzed == nil {
+		analyzed = &analyzedDigest{}
+	} else {
+		status = models.StatusCompleted
+	}
+	
+	_, err := s.db.ExecContext(ctx, `
 		INSERT INTO app_slow_queries (
-			digest, sample_sql, started_at, query_time, db, 
-			index_names, is_internal, user, host, tables, source
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, q.Digest, q.Query, startTime, q.QueryTime, q.DB, q.IndexNames, 
-		q.IsInternal, q.User, q.Host, tablesJSON, models.SourceInformationSchema)
+			target, digest, sample_sql, normalized_sql, started_at, query_time, db, 
+			index_names, is_internal, user, host, tables, source,
+			status, skip_reason, last_analyzed_at, best_rewrite_id
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, s.db.TargetName(), q.Digest, q.SampleSQL, normalizeSQL(q.SampleSQL), q.StartedAt, q.QueryTime, q.DB, q.IndexNames, 
+		q.IsInternal, q.User, q.Host, tablesJSON, q.Source,
+		status, sql.NullString{String: skipReason, Valid: skipReason != ""},
+		analyzed.analyzedAt, analyzed.bestRewriteID)
+	if err != nil {
+		return err
+	}
+	metrics.SlowQueriesIngested.Inc(q.Source, status)
 	
-	return err
+	return s.db.RecordSlowQueryOccurrence(ctx, q.Digest, q.SampleSQL, q.DB, q.QueryTime, q.StartedAt)
 }
 
 // GetSlowQueries retrieves slow queries from our app table for processing
-func (s *SlowQueryIngester) GetSlowQueries(status string, limit int) ([]models.SlowQuery, error) {
+func (s *SlowQueryIngester) GetSlowQueries(ctx context.Context, status string, limit int) ([]models.SlowQuery, error) {
+	return s.querySlowQueries(ctx, "status = ?", status, limit)
+}
+
+// GetSlowQueriesToAnalyze retrieves pending slow queries of the enabled
+// targets that took at least minQueryTime seconds, slowest first. Only the
+// slowest pending occurrence of each digest in a target is returned, and
+// none of a digest being analyzed there, since completing one completes
+// them all.
+func (s *SlowQueryIngester) GetSlowQueriesToAnalyze(ctx context.Context, minQueryTime float64, limit int) ([]models.SlowQuery, error) {
+	filter, args := s.enabledTargets()
+	return s.querySlowQueries(ctx, onePerDigest(filter), append(append([]any{models.StatusPending, minQueryTime}, args...), models.StatusAnalyzing, limit)...)
+}
+
+// GetSlowQueriesToAnalyzeInDB is GetSlowQueriesToAnalyze limited to queries
+// that ran in database dbName
+func (s *SlowQueryIngester) GetSlowQueriesToAnalyzeInDB(ctx context.Context, dbName string, minQueryTime float64, limit int) ([]models.SlowQuery, error) {
+	filter, args := s.enabledTargets()
+	return s.querySlowQueries(ctx, onePerDigest(filter+" AND db = ?"), append(append([]any{models.StatusPending, minQueryTime}, args...), dbName, models.StatusAnalyzing, limit)...)
+}
+
+// enabledTargets returns the onePerDigest filter limiting slow queries to
+// the enabled targets, which are the only ones that can be explained
+func (s *SlowQueryIngester) enabledTargets() (string, []any) {
+	names := s.db.TargetNames()
+	args := make([]any, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+	return "AND target IN (?" + strings.Repeat(", ?", len(names)-1) + ")", args
+}
+
+// onePerDigest selects the slowest pending occurrence of each digest of a
+// target with no occurrence being analyzed in that target; its args are the
+// pending status, the minimum query time, those of filter and the analyzing
+// status
+func onePerDigest(filter string) string {
+	return `id IN (
+			SELECT id FROM (
+				SELECT id, target, digest, ROW_NUMBER() OVER (PARTITION BY target, digest ORDER BY query_time DESC, started_at DESC) AS occurrence
+				FROM app_slow_queries
+				WHERE status = ? AND query_time >= ? ` + filter + `
+			) candidates
+			WHERE occurrence = 1 AND (target, digest) NOT IN (SELECT target, digest FROM app_slow_queries WHERE status = ?)
+		)`
+}
+
+// querySlowQueries runs the shared slow query select; the last arg is the limit
+func (s *SlowQueryIngester) querySlowQueries(ctx context.Context, where string, args ...any) ([]models.SlowQuery, error) {
 	query := `
-		SELECT 
-			id, digest, sample_sql, started_at, query_time, 
-			COALESCE(db, '') as db,
-			COALESCE(index_names, '') as index_names,
-			is_internal, 
-			COALESCE(user, '') as user, 
-			COALESCE(host, '') as host,
-			COALESCE(tables, '[]') as tables,
-			source, status,
-			last_analyzed_at, best_rewrite_id
+		SELECT ` + slowQueryColumns + `
 		FROM app_slow_queries 
-		WHERE status = ? 
+		WHERE ` + where + ` 
 		ORDER BY query_time DESC, started_at DESC 
 		LIMIT ?`
 	
-	rows, err := s.db.Query(query, status, limit)
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	
+	return scanSlowQueries(rows)
+}
+
+// slowQueryColumns are the columns of app_slow_queries scanSlowQuery reads
+const slowQueryColumns = `
+			id, target, digest, sample_sql, COALESCE(normalized_sql, '') as normalized_sql,
+			started_at, query_time, 
+			COALESCE(db, '') as db,
+			COALESCE(index_names, '') as index_names,
+			is_internal, 
+			COALESCE(user, '') as user, 
+			COALESCE(host, '') as host,
+			COALESCE(tables, '[]') as tables,
+			source, status, COALESCE(skip_reason, '') as skip_reason,
+			last_analyzed_at, best_rewrite_id`
+
+// scanSlowQuery reads a row of slowQueryColumns
+func scanSlowQuery(row interface{ Scan(dest ...any) error }) (models.SlowQuery, error) {
+	var q models.SlowQuery
+	err := row.Scan(
+		&q.ID, &q.Target, &q.Digest, &q.SampleSQL, &q.NormalizedSQL, &q.StartedAt, &q.QueryTime,
+		&q.DB, &q.IndexNames, &q.IsInternal, &q.User, &q.Host,
+		&q.Tables, &q.Source, &q.Status, &q.SkipReason,
+		&q.LastAnalyzedAt, &q.BestRewriteID,
+	)
+	return q, err
+}
+
+// scanSlowQueries reads every row of slowQueryColumns
+func scanSlowQueries(rows *sql.Rows) ([]models.SlowQuery, error) {
 	var queries []models.SlowQuery
 	for rows.Next() {
-		var q models.SlowQuery
-		
-		err := rows.Scan(
-			&q.ID, &q.Digest, &q.SampleSQL, &q.StartedAt, &q.QueryTime,
-			&q.DB, &q.IndexNames, &q.IsInternal, &q.User, &q.Host,
-			&q.Tables, &q.Source, &q.Status,
-			&q.LastAnalyzedAt, &q.BestRewriteID,
-		)
+		q, err := scanSlowQuery(rows)
 		if err != nil {
 			return nil, err
 		}
-		
 		queries = append(queries, q)
 	}
+	return queries, rows.Err()
+}
+
+// GetSlowQueryByID retrieves a single slow query from our app table
+func (s *SlowQueryIngester) GetSlowQueryByID(ctx context.Context, id int64) (*models.SlowQuery, error) {
+	query := `
+		SELECT ` + slowQueryColumns + `
+		FROM app_slow_queries 
+		WHERE id = ?`
 	
-	return queries, nil
+	q, err := scanSlowQuery(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("slow query %d %w", id, ErrSlowQueryNotFound)
+		}
+		return nil, err
+	}
+	
+	return &q, nil
 }
 
-// generateSQLDigest creates a simple digest/fingerprint for a SQL query
-func generateSQLDigest(query string) string {
-	// Normalize the query by removing extra whitespace and converting to lowercase
-	normalized := strings.ToLower(strings.TrimSpace(query))
-	normalized = strings.ReplaceAll(normalized, "\n", " ")
-	normalized = strings.ReplaceAll(normalized, "\t", " ")
-	for strings.Contains(normalized, "  ") {
-		normalized = strings.ReplaceAll(normalized, "  ", " ")
+// GetActualPlan returns the EXPLAIN ANALYZE output captured for slow query
+// id, "" when none was. It is left out of the listings, being large.
+func (s *SlowQueryIngester) GetActualPlan(ctx context.Context, id int64) (string, error) {
+	var plan sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT actual_plan FROM app_slow_queries WHERE id = ?`, id).Scan(&plan)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("slow query %d %w", id, ErrSlowQueryNotFound)
+	}
+	return plan.String, err
+}
+
+// UpdateSlowQueryStatus moves a slow query through pending/analyzing/completed
+func (s *SlowQueryIngester) UpdateSlowQueryStatus(ctx context.Context, id int64, status string) error {
+	query := `UPDATE app_slow_queries SET status = ?, last_analyzed_at = IF(? = 'completed', NOW(), last_analyzed_at) WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, query, status, status, id)
+	return err
+}
+
+// ClaimSlowQuery marks slow query id analyzing, recording the claim time in
+// last_analyzed_at, only while it is pending; ok is false when another
+// process claimed or settled it first
+func (s *SlowQueryIngester) ClaimSlowQuery(ctx context.Context, id int64) (ok bool, err error) {
+	query := `UPDATE app_slow_queries SET status = ?, last_analyzed_at = NOW() WHERE id = ? AND status = ?`
+	result, err := s.db.ExecContext(ctx, query, models.StatusAnalyzing, id, models.StatusPending)
+	if err != nil {
+		return false, err
+	}
+	claimed, err := result.RowsAffected()
+	return claimed == 1, err
+}
+
+// ResetStaleAnalyses puts slow queries claimed more than olderThan ago and
+// still analyzing, left so by a process that crashed or was killed, back to
+// pending: until then their digests are never picked again. Ad hoc queries,
+// which the analyze job does not run, are skipped instead. It returns how
+// many were reset.
+func (s *SlowQueryIngester) ResetStaleAnalyses(ctx context.Context, olderThan time.Duration) (int64, error) {
+	query := `
+		UPDATE app_slow_queries
+		SET skip_reason = IF(source = ?, ?, skip_reason), status = IF(source = ?, ?, ?)
+		WHERE status = ? AND COALESCE(last_analyzed_at, created_at) < NOW() - INTERVAL ? SECOND`
+	result, err := s.db.ExecContext(ctx, query,
+		models.SourceAdhoc, "analysis interrupted", models.SourceAdhoc, models.StatusSkipped, models.StatusPending,
+		models.StatusAnalyzing, int64(olderThan.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// CompleteSlowQuery marks a slow query and the other pending occurrences of
+// its digest in the same target as analyzed with rewriteID as their best
+// rewrite; rewriteID 0 leaves best_rewrite_id unchanged
+func (s *SlowQueryIngester) CompleteSlowQuery(ctx context.Context, id int64, rewriteID int64) error {
+	query := `
+		UPDATE app_slow_queries s
+		JOIN app_slow_queries analyzed ON analyzed.id = ?
+		SET s.status = ?, s.last_analyzed_at = NOW(), s.best_rewrite_id = IF(? = 0, s.best_rewrite_id, ?)
+		WHERE s.id = analyzed.id
+		   OR (s.target = analyzed.target AND s.digest = analyzed.digest AND s.status = ?)`
+	_, err := s.db.ExecContext(ctx, query, id, models.StatusCompleted, rewriteID, rewriteID, models.StatusPending)
+	return err
+}
+
+// RecordAnalysisFailure counts a failed analysis of a slow query and puts it
+// back to pending, or skips it and the other pending occurrences of its
+// digest in the same target once it failed maxAttempts times. maxAttempts 0
+// never skips. It reports whether the query was skipped.
+func (s *SlowQueryIngester) RecordAnalysisFailure(ctx context.Context, id int64, maxAttempts int, cause error) (bool, error) {
+	var attempts int
+	var target, digest string
+	err := s.db.QueryRowContext(ctx, `SELECT analysis_attempts, target, digest FROM app_slow_queries WHERE id = ?`, id).Scan(&attempts, &target, &digest)
+	if err != nil {
+		return false, err
+	}
+	attempts++
+	
+	if maxAttempts > 0 && attempts >= maxAttempts {
+		reason := fmt.Sprintf("analysis failed %d times: %v", attempts, cause)
+		reason = truncateReason(reason)
+		query := `
+			UPDATE app_slow_queries
+			SET status = ?, skip_reason = ?, analysis_attempts = IF(id = ?, ?, analysis_attempts)
+			WHERE id = ? OR (target = ? AND digest = ? AND status = ?)`
+		_, err := s.db.ExecContext(ctx, query, models.StatusSkipped, reason, id, attempts, id, target, digest, models.StatusPending)
+		return err == nil, err
 	}
 	
-	// Create MD5 hash
-	hash := md5.Sum([]byte(normalized))
-	return fmt.Sprintf("%x", hash)
+	query := `UPDATE app_slow_queries SET status = ?, analysis_attempts = ? WHERE id = ?`
+	_, err = s.db.ExecContext(ctx, query, models.StatusPending, attempts, id)
+	return false, err
+}
+
+// SkipSlowQuery marks a slow query as never to be analyzed and records why,
+// so reviewers can see the reason
+func (s *SlowQueryIngester) SkipSlowQuery(ctx context.Context, id int64, reason string) error {
+	return s.settleUnanalyzed(ctx, id, models.StatusSkipped, reason)
+}
+
+// MarkUnsupported settles a slow query whose sample cannot be analyzed at
+// all, such as one holding several statements, and records why
+func (s *SlowQueryIngester) MarkUnsupported(ctx context.Context, id int64, reason string) error {
+	return s.settleUnanalyzed(ctx, id, models.StatusUnsupported, reason)
+}
+
+// settleUnanalyzed sets the terminal status of a slow query left without a
+// rewrite, with its reason cut to fit skip_reason
+func (s *SlowQueryIngester) settleUnanalyzed(ctx context.Context, id int64, status, reason string) error {
+	query := `UPDATE app_slow_queries SET status = ?, skip_reason = ? WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, query, status, truncateReason(reason), id)
+	return err
+}
+
+// maxReasonLength is the size in characters of the skip_reason column
+const maxReasonLength = 512
+
+// truncateReason cuts reason to the first maxReasonLength runes, so a
+// multi-byte character is never split
+func truncateReason(reason string) string {
+	runes := 0
+	for i := range reason {
+		if runes == maxReasonLength {
+			return reason[:i]
+		}
+		runes++
+	}
+	return reason
 }
 
 // extractTableNames extracts table names from a SQL query (simplified implementation)
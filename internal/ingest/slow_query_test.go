@@ -0,0 +1,101 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/matthieukhl/latentia/internal/models"
+)
+
+func TestTruncateReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		reason string
+		want   string
+	}{
+		{"short", "digest is ignored", "digest is ignored"},
+		{"exact", strings.Repeat("a", maxReasonLength), strings.Repeat("a", maxReasonLength)},
+		{"ascii", strings.Repeat("a", maxReasonLength+10), strings.Repeat("a", maxReasonLength)},
+		{"multi-byte", strings.Repeat("é", maxReasonLength+1), strings.Repeat("é", maxReasonLength)},
+		// A byte cut at 512 would split the 3-byte rune straddling it
+		{"rune at the byte limit", strings.Repeat("a", 511) + "€€", strings.Repeat("a", 511) + "€"},
+		{"multi-byte under the length", strings.Repeat("日", 300), strings.Repeat("日", 300)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateReason(tt.reason)
+			if got != tt.want {
+				t.Errorf("truncateReason = %q (%d runes), want %d runes", got, utf8.RuneCountInString(got), utf8.RuneCountInString(tt.want))
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("truncateReason returned invalid UTF-8")
+			}
+		})
+	}
+}
+
+func TestClaimSlowQueryOnce(t *testing.T) {
+	ingester, pool := openBrowseDB(t)
+	ctx := context.Background()
+	insertBrowseFixtures(t, pool, []browseFixture{
+		{target: "default", digest: "d1", status: models.StatusPending, source: models.SourceGenerated},
+		{target: "default", digest: "d2", status: models.StatusCompleted, source: models.SourceGenerated},
+	})
+
+	if ok, err := ingester.ClaimSlowQuery(ctx, 1); err != nil || !ok {
+		t.Fatalf("first claim = %v, %v, want true", ok, err)
+	}
+	// A concurrent worker picked the same pending row
+	if ok, err := ingester.ClaimSlowQuery(ctx, 1); err != nil || ok {
+		t.Errorf("second claim = %v, %v, want false", ok, err)
+	}
+	if ok, err := ingester.ClaimSlowQuery(ctx, 2); err != nil || ok {
+		t.Errorf("claim of a completed query = %v, %v, want false", ok, err)
+	}
+	if got := slowQueryStatus(t, pool, 1); got != models.StatusAnalyzing {
+		t.Errorf("status = %s, want analyzing", got)
+	}
+}
+
+func TestResetStaleAnalyses(t *testing.T) {
+	ingester, pool := openBrowseDB(t)
+	ctx := context.Background()
+	insertBrowseFixtures(t, pool, []browseFixture{
+		{target: "default", digest: "d1", status: models.StatusAnalyzing, source: models.SourceGenerated},
+		{target: "default", digest: "d2", status: models.StatusAnalyzing, source: models.SourceGenerated},
+		{target: "default", digest: "d3", status: models.StatusAnalyzing, source: models.SourceAdhoc},
+		{target: "default", digest: "d4", status: models.StatusCompleted, source: models.SourceGenerated},
+	})
+	for id, claimed := range map[int]string{1: "NOW() - INTERVAL 2 HOUR", 2: "NOW()", 3: "NULL", 4: "NOW() - INTERVAL 2 HOUR"} {
+		// An ad hoc query has no claim time until it completes
+		if _, err := pool.Exec(`UPDATE app_slow_queries SET last_analyzed_at = `+claimed+`, created_at = NOW() - INTERVAL 3 HOUR WHERE id = ?`, id); err != nil {
+			t.Fatalf("failed to age fixture %d: %v", id, err)
+		}
+	}
+
+	reset, err := ingester.ResetStaleAnalyses(ctx, time.Hour)
+	if err != nil {
+		t.Fatalf("ResetStaleAnalyses: %v", err)
+	}
+	if reset != 2 {
+		t.Errorf("reset = %d, want 2", reset)
+	}
+	for id, want := range map[int]string{1: models.StatusPending, 2: models.StatusAnalyzing, 3: models.StatusSkipped, 4: models.StatusCompleted} {
+		if got := slowQueryStatus(t, pool, id); got != want {
+			t.Errorf("slow query %d status = %s, want %s", id, got, want)
+		}
+	}
+}
+
+func slowQueryStatus(t *testing.T, pool *sql.DB, id int) string {
+	t.Helper()
+	var status string
+	if err := pool.QueryRow(`SELECT status FROM app_slow_queries WHERE id = ?`, id).Scan(&status); err != nil {
+		t.Fatalf("failed to read slow query %d: %v", id, err)
+	}
+	return status
+}
@@ -0,0 +1,361 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/models"
+)
+
+// ParseSlowLogFile reads every entry of the TiDB or MySQL slow log at path.
+// Blocks that cannot be read as an entry, without a start time, a query
+// time or SQL, are skipped and counted in malformed.
+func ParseSlowLogFile(path string) (queries []models.SlowQuery, malformed int, err error) {
+	tail, err := OpenSlowLog(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tail.Close()
+	return tail.Read(true)
+}
+
+// SlowLogTail reads a slow log file as it grows, following it when it is
+// rotated or truncated
+type SlowLogTail struct {
+	path    string
+	file    *os.File
+	reader  *bufio.Reader
+	offset  int64
+	partial string
+	parser  slowLogParser
+}
+
+// OpenSlowLog opens the slow log at path for reading from its start
+func OpenSlowLog(path string) (*SlowLogTail, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open slow log: %w", err)
+	}
+	return &SlowLogTail{path: path, file: file, reader: bufio.NewReader(file)}, nil
+}
+
+// Read returns the entries written since the last call and how many
+// malformed blocks were skipped. The last entry is only returned once it
+// ends with a semicolon, or with final once the end of the file is reached;
+// one a rotated or truncated file ends with unfinished is counted as
+// malformed.
+func (t *SlowLogTail) Read(final bool) ([]models.SlowQuery, int, error) {
+	for {
+		if err := t.readToEOF(); err != nil {
+			return nil, 0, err
+		}
+		rotated, err := t.reopenIfRotated()
+		if err != nil {
+			return nil, 0, err
+		}
+		if !rotated {
+			break
+		}
+	}
+
+	if final {
+		t.parser.line(t.partial)
+		t.partial = ""
+		t.parser.flush()
+	} else if t.parser.complete() {
+		t.parser.flush()
+	}
+	queries, malformed := t.parser.take()
+	return queries, malformed, nil
+}
+
+// readToEOF feeds the parser every complete line up to the end of the file
+func (t *SlowLogTail) readToEOF() error {
+	for {
+		chunk, err := t.reader.ReadString('\n')
+		t.offset += int64(len(chunk))
+		if err == io.EOF {
+			t.partial += chunk
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read slow log: %w", err)
+		}
+		t.parser.line(strings.TrimRight(t.partial+chunk, "\r\n"))
+		t.partial = ""
+	}
+}
+
+// reopenIfRotated starts over on the file now at path when it is a new one,
+// or when the open one was truncated, and reports whether it did
+func (t *SlowLogTail) reopenIfRotated() (bool, error) {
+	current, err := t.file.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to stat slow log: %w", err)
+	}
+	latest, err := os.Stat(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		// Rotated away and not recreated yet
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to stat slow log: %w", err)
+	}
+
+	if os.SameFile(current, latest) {
+		if latest.Size() >= t.offset {
+			return false, nil
+		}
+		if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+			return false, fmt.Errorf("failed to rewind truncated slow log: %w", err)
+		}
+	} else {
+		file, err := os.Open(t.path)
+		if err != nil {
+			return false, fmt.Errorf("failed to open rotated slow log: %w", err)
+		}
+		t.file.Close()
+		t.file = file
+	}
+	t.endFile()
+	t.reader.Reset(t.file)
+	t.offset = 0
+	return true, nil
+}
+
+// endFile settles the entry the previous file ended with before reading
+// another one, so nothing of it carries over to the first lines read next.
+// It is kept when its SQL ended with a semicolon; otherwise the writer was
+// cut off mid-entry, and it is counted as malformed rather than ingested
+// with truncated SQL.
+func (t *SlowLogTail) endFile() {
+	if t.partial != "" {
+		t.parser.line(t.partial)
+		t.partial = ""
+	}
+	if t.parser.complete() {
+		t.parser.flush()
+	} else {
+		t.parser.discard()
+	}
+}
+
+// Close closes the file
+func (t *SlowLogTail) Close() error {
+	return t.file.Close()
+}
+
+// IngestSlowLog inserts the slow log entries that took at least
+// minQueryTime seconds like IngestFromInformationSchema does
+func (s *SlowQueryIngester) IngestSlowLog(ctx context.Context, queries []models.SlowQuery, minQueryTime float64) (*IngestSummary, error) {
+	var kept []models.SlowQuery
+	for _, q := range queries {
+		if q.QueryTime >= minQueryTime {
+			kept = append(kept, q)
+		}
+	}
+	return s.ingest(ctx, kept)
+}
+
+// slowLogParser groups slow log lines into entries. An entry is a block of
+// "# Key: value" header lines followed by its SQL; it starts at "# Time:",
+// or at any header line after SQL since MySQL leaves out the time of
+// entries logged in the same second.
+type slowLogParser struct {
+	block     []string
+	hasSQL    bool
+	queries   []models.SlowQuery
+	malformed int
+}
+
+func (p *slowLogParser) line(line string) {
+	header := strings.HasPrefix(line, "# ")
+	if header && (strings.HasPrefix(line, "# Time:") || p.hasSQL) {
+		p.flush()
+	}
+	if p.block == nil && !header {
+		// Preamble such as the startup lines of a MySQL slow log
+		return
+	}
+	p.block = append(p.block, line)
+	if !header && strings.TrimSpace(line) != "" {
+		p.hasSQL = true
+	}
+}
+
+// complete reports whether the pending block ends its SQL with a
+// semicolon, as entries are written
+func (p *slowLogParser) complete() bool {
+	if !p.hasSQL {
+		return false
+	}
+	last := strings.TrimSpace(p.block[len(p.block)-1])
+	return strings.HasSuffix(last, ";") && !isSessionStatement(last)
+}
+
+func (p *slowLogParser) flush() {
+	if p.block == nil {
+		return
+	}
+	q, err := parseSlowLogEntry(p.block)
+	if err != nil {
+		p.malformed++
+		slog.Debug("malformed slow log entry skipped", "error", err, "first_line", p.block[0])
+	} else {
+		p.queries = append(p.queries, q)
+	}
+	p.block, p.hasSQL = nil, false
+}
+
+// discard drops the pending block, counting it as malformed
+func (p *slowLogParser) discard() {
+	if p.block == nil {
+		return
+	}
+	p.malformed++
+	slog.Debug("incomplete slow log entry skipped", "first_line", p.block[0])
+	p.block, p.hasSQL = nil, false
+}
+
+// take returns the entries parsed and the blocks skipped since the last call
+func (p *slowLogParser) take() ([]models.SlowQuery, int) {
+	queries, malformed := p.queries, p.malformed
+	p.queries, p.malformed = nil, 0
+	return queries, malformed
+}
+
+// slowLogTimeLayouts are the formats of "# Time:": TiDB and MySQL 5.7+
+// write RFC 3339, older MySQL versions "YYMMDD hh:mm:ss"
+var slowLogTimeLayouts = []string{time.RFC3339Nano, "060102 15:04:05"}
+
+// parseSlowLogEntry reads one block of header lines and SQL
+func parseSlowLogEntry(block []string) (models.SlowQuery, error) {
+	fields := map[string]string{}
+	var sqlLines []string
+	for _, line := range block {
+		if header, ok := strings.CutPrefix(line, "# "); ok && sqlLines == nil {
+			parseSlowLogHeader(header, fields)
+			continue
+		}
+		sqlLines = append(sqlLines, line)
+	}
+
+	q := models.SlowQuery{
+		Digest:     fields["Digest"],
+		DB:         fields["DB"],
+		IndexNames: strings.Trim(fields["Index_names"], "[]"),
+		IsInternal: fields["Is_internal"] == "true",
+		Source:     models.SourceSlowLog,
+		Status:     models.StatusPending,
+	}
+	q.User, q.Host = parseUserHost(fields["User@Host"])
+
+	var err error
+	if q.QueryTime, err = strconv.ParseFloat(fields["Query_time"], 64); err != nil {
+		return q, fmt.Errorf("invalid Query_time %q", fields["Query_time"])
+	}
+
+	// The session statements before the SQL set its database and time
+	var timestamp string
+	var statement []string
+	for _, line := range sqlLines {
+		trimmed := strings.TrimSpace(line)
+		if statement == nil && isSessionStatement(trimmed) {
+			value := strings.TrimSuffix(trimmed, ";")
+			if strings.HasPrefix(strings.ToLower(value), "use ") {
+				if q.DB == "" {
+					q.DB = strings.Trim(strings.TrimSpace(value[len("use "):]), "`")
+				}
+			} else {
+				timestamp = value[len("set timestamp="):]
+			}
+			continue
+		}
+		if statement == nil && trimmed == "" {
+			continue
+		}
+		statement = append(statement, line)
+	}
+	q.SampleSQL = strings.TrimSuffix(strings.TrimSpace(strings.Join(statement, "\n")), ";")
+	if q.SampleSQL == "" {
+		return q, fmt.Errorf("no SQL")
+	}
+
+	if q.StartedAt, err = parseSlowLogTime(fields["Time"], timestamp); err != nil {
+		return q, err
+	}
+	if q.Digest == "" {
+		q.Digest = generateSQLDigest(q.SampleSQL)
+	}
+	return q, nil
+}
+
+// parseSlowLogHeader adds the "Key: value" pairs of a header line to
+// fields; MySQL puts several on one line, as in "Query_time: 2.1
+// Lock_time: 0.0"
+func parseSlowLogHeader(header string, fields map[string]string) {
+	var key string
+	var value []string
+	save := func() {
+		if key != "" {
+			fields[key] = strings.Join(value, " ")
+		}
+	}
+	for _, word := range strings.Fields(header) {
+		if name, ok := strings.CutSuffix(word, ":"); ok && name != "" {
+			save()
+			key, value = name, nil
+			continue
+		}
+		value = append(value, word)
+	}
+	save()
+}
+
+// parseUserHost splits "root[root] @ localhost [127.0.0.1]" into the user
+// and the host, the address when there is one
+func parseUserHost(value string) (user, host string) {
+	account, address, _ := strings.Cut(value, "@")
+	user = strings.TrimSpace(account)
+	if i := strings.IndexByte(user, '['); i >= 0 {
+		user = user[:i]
+	}
+	address = strings.TrimSpace(address)
+	host = address
+	if i := strings.IndexByte(address, '['); i >= 0 {
+		host = strings.TrimSpace(address[:i])
+		if ip := strings.TrimSpace(strings.Trim(address[i:], "[] ")); ip != "" {
+			host = ip
+		}
+	}
+	return user, host
+}
+
+func parseSlowLogTime(value, timestamp string) (time.Time, error) {
+	if value != "" {
+		for _, layout := range slowLogTimeLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t.UTC(), nil
+			}
+		}
+	}
+	if seconds, err := strconv.ParseInt(timestamp, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid Time %q", value)
+}
+
+// isSessionStatement reports whether line is a "use db;" or "SET
+// timestamp=...;" logged before an entry's SQL
+func isSessionStatement(line string) bool {
+	lower := strings.ToLower(line)
+	return strings.HasPrefix(lower, "use ") || strings.HasPrefix(lower, "set timestamp=")
+}
@@ -0,0 +1,163 @@
+package ingest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// slowLogEntry is a TiDB slow log entry for sql
+func slowLogEntry(sql string) string {
+	return "# Time: 2026-03-01T10:00:00.123456+00:00\n" +
+		"# Query_time: 1.5\n" +
+		"# DB: shop\n" +
+		sql + ";\n"
+}
+
+func writeSlowLog(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write slow log: %v", err)
+	}
+}
+
+func appendSlowLog(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open slow log: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("failed to append to slow log: %v", err)
+	}
+}
+
+// readSQL reads the entries since the last call and returns their SQL
+func readSQL(t *testing.T, tail *SlowLogTail) ([]string, int) {
+	t.Helper()
+	queries, malformed, err := tail.Read(false)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	var sqls []string
+	for _, q := range queries {
+		sqls = append(sqls, q.SampleSQL)
+	}
+	return sqls, malformed
+}
+
+func equalSQL(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSlowLogTailFollowsRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tidb-slow.log")
+	writeSlowLog(t, path, slowLogEntry("SELECT 1 FROM orders"))
+
+	tail, err := OpenSlowLog(path)
+	if err != nil {
+		t.Fatalf("OpenSlowLog: %v", err)
+	}
+	defer tail.Close()
+	if got, _ := readSQL(t, tail); !equalSQL(got, []string{"SELECT 1 FROM orders"}) {
+		t.Fatalf("first read = %q", got)
+	}
+
+	// The old file gets one more entry before being renamed away
+	appendSlowLog(t, path, slowLogEntry("SELECT 2 FROM orders"))
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	writeSlowLog(t, path, slowLogEntry("SELECT 3 FROM customers"))
+
+	got, malformed := readSQL(t, tail)
+	if want := []string{"SELECT 2 FROM orders", "SELECT 3 FROM customers"}; !equalSQL(got, want) {
+		t.Errorf("read after rotation = %q, want %q", got, want)
+	}
+	if malformed != 0 {
+		t.Errorf("malformed = %d, want 0", malformed)
+	}
+}
+
+func TestSlowLogTailDropsEntryCutByRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tidb-slow.log")
+	// The old file ends mid-line in the SQL of its last entry
+	writeSlowLog(t, path, slowLogEntry("SELECT 1 FROM orders")+
+		"# Time: 2026-03-01T10:00:01+00:00\n# Query_time: 2.5\nSELECT id FROM orders WHE")
+
+	tail, err := OpenSlowLog(path)
+	if err != nil {
+		t.Fatalf("OpenSlowLog: %v", err)
+	}
+	defer tail.Close()
+	if got, _ := readSQL(t, tail); !equalSQL(got, []string{"SELECT 1 FROM orders"}) {
+		t.Fatalf("first read = %q", got)
+	}
+
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	// The new file starts with a bare SQL line, as after a header-less
+	// continuation: none of it may be appended to the cut entry
+	writeSlowLog(t, path, "RE id = 1;\n"+slowLogEntry("SELECT 2 FROM customers"))
+
+	got, malformed := readSQL(t, tail)
+	if want := []string{"SELECT 2 FROM customers"}; !equalSQL(got, want) {
+		t.Errorf("read after rotation = %q, want %q", got, want)
+	}
+	if malformed != 1 {
+		t.Errorf("malformed = %d, want the cut entry", malformed)
+	}
+}
+
+func TestSlowLogTailRestartsAfterTruncation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "slow.log")
+	writeSlowLog(t, path, slowLogEntry("SELECT 1 FROM orders")+slowLogEntry("SELECT 2 FROM orders"))
+
+	tail, err := OpenSlowLog(path)
+	if err != nil {
+		t.Fatalf("OpenSlowLog: %v", err)
+	}
+	defer tail.Close()
+	if got, _ := readSQL(t, tail); len(got) != 2 {
+		t.Fatalf("first read = %q", got)
+	}
+
+	// copytruncate empties the file in place
+	writeSlowLog(t, path, slowLogEntry("SELECT 3 FROM customers"))
+	if got, _ := readSQL(t, tail); !equalSQL(got, []string{"SELECT 3 FROM customers"}) {
+		t.Errorf("read after truncation = %q", got)
+	}
+}
+
+func TestSlowLogTailWaitsForUnfinishedEntry(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tidb-slow.log")
+	writeSlowLog(t, path, "# Time: 2026-03-01T10:00:00+00:00\n# Query_time: 1.5\nSELECT id\n")
+
+	tail, err := OpenSlowLog(path)
+	if err != nil {
+		t.Fatalf("OpenSlowLog: %v", err)
+	}
+	defer tail.Close()
+	if got, _ := readSQL(t, tail); len(got) != 0 {
+		t.Fatalf("unfinished entry returned: %q", got)
+	}
+
+	appendSlowLog(t, path, "FROM orders;\n")
+	if got, _ := readSQL(t, tail); !equalSQL(got, []string{"SELECT id\nFROM orders"}) {
+		t.Errorf("read = %q", got)
+	}
+}
@@ -0,0 +1,37 @@
+package embed
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// DefaultAzureAPIVersion is the Azure OpenAI REST API version used when
+// none is configured
+const DefaultAzureAPIVersion = "2024-10-21"
+
+// NewAzureOpenAIEmbedder returns an OpenAI embedder for the embeddings
+// deployment of an Azure OpenAI resource. model is the deployed model's
+// name, which sizes the embeddings, and defaults deployment; baseURL is the
+// resource endpoint, such as https://{resource}.openai.azure.com.
+func NewAzureOpenAIEmbedder(model, deployment, baseURL, apiVersion, apiKeyEnv, directAPIKey string, options types.ProviderOptions) (*OpenAIEmbedder, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("azure-openai needs the resource endpoint in base_url")
+	}
+	e, err := NewOpenAIEmbedder(model, apiKeyEnv, directAPIKey, options)
+	if err != nil {
+		return nil, err
+	}
+	if deployment == "" {
+		deployment = model
+	}
+	if apiVersion == "" {
+		apiVersion = DefaultAzureAPIVersion
+	}
+	e.endpoint = fmt.Sprintf("%s/openai/deployments/%s/embeddings?api-version=%s",
+		strings.TrimRight(baseURL, "/"), url.PathEscape(deployment), url.QueryEscape(apiVersion))
+	e.azure = true
+	return e, nil
+}
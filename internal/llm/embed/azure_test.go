@@ -0,0 +1,175 @@
+package embed
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// capturedRequest is what a fake provider received
+type capturedRequest struct {
+	Path   string
+	Query  string
+	Header http.Header
+	Body   map[string]any
+}
+
+// fakeProvider answers each request with what respond returns for its
+// decoded body, recording every request
+type fakeProvider struct {
+	respond func(body map[string]any) (status int, response any)
+
+	mu       sync.Mutex
+	requests []capturedRequest
+}
+
+func (f *fakeProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := capturedRequest{Path: r.URL.EscapedPath(), Query: r.URL.RawQuery, Header: r.Header.Clone()}
+	if err := json.NewDecoder(r.Body).Decode(&req.Body); err != nil {
+		http.Error(w, "request body is not a JSON object", http.StatusTeapot)
+		return
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	f.mu.Unlock()
+
+	status, response := f.respond(req.Body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if s, ok := response.(string); ok {
+		w.Write([]byte(s))
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// received returns the requests so far
+func (f *fakeProvider) received() []capturedRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]capturedRequest(nil), f.requests...)
+}
+
+// serveFake starts a fake provider and returns its URL
+func serveFake(t *testing.T, respond func(body map[string]any) (int, any)) (string, *fakeProvider) {
+	t.Helper()
+	fake := &fakeProvider{respond: respond}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return srv.URL, fake
+}
+
+// texts returns the strings of a list in a decoded request body
+func texts(body map[string]any, key string) []string {
+	list, _ := body[key].([]any)
+	out := make([]string, len(list))
+	for i, v := range list {
+		out[i], _ = v.(string)
+	}
+	return out
+}
+
+// openAIEmbeddings answers an OpenAI embeddings request with one vector
+// per input, in reverse order to check the index is honoured
+func openAIEmbeddings(body map[string]any) (int, any) {
+	input := texts(body, "input")
+	var data []map[string]any
+	for i := len(input) - 1; i >= 0; i-- {
+		data = append(data, map[string]any{"object": "embedding", "index": i, "embedding": []float32{float32(i), 0.5}})
+	}
+	return http.StatusOK, map[string]any{"object": "list", "data": data, "usage": map[string]any{"prompt_tokens": 4}}
+}
+
+func TestAzureOpenAIEmbedderRequest(t *testing.T) {
+	url, fake := serveFake(t, openAIEmbeddings)
+	e, err := NewAzureOpenAIEmbedder("text-embedding-3-large", "prod-embeddings", url+"/", "2024-06-01", "", "azure-test-key", types.ProviderOptions{})
+	if err != nil {
+		t.Fatalf("NewAzureOpenAIEmbedder: %v", err)
+	}
+
+	vectors, err := e.Embed(context.Background(), []string{"first", "second"})
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if want := [][]float32{{0, 0.5}, {1, 0.5}}; !reflect.DeepEqual(vectors, want) {
+		t.Errorf("vectors = %v, want %v", vectors, want)
+	}
+	if e.Dim() != 3072 || e.Model() != "text-embedding-3-large" {
+		t.Errorf("Dim() = %d, Model() = %q: the deployed model sizes the embeddings", e.Dim(), e.Model())
+	}
+
+	req := fake.received()[0]
+	if req.Path != "/openai/deployments/prod-embeddings/embeddings" || req.Query != "api-version=2024-06-01" {
+		t.Errorf("request = %s?%s", req.Path, req.Query)
+	}
+	if got := req.Header.Get("api-key"); got != "azure-test-key" {
+		t.Errorf("api-key = %q", got)
+	}
+	if got := req.Header.Get("Authorization"); got != "" {
+		t.Errorf("Authorization = %q, want the key only in api-key", got)
+	}
+	if got := texts(req.Body, "input"); !reflect.DeepEqual(got, []string{"first", "second"}) {
+		t.Errorf("input = %q", got)
+	}
+}
+
+func TestAzureOpenAIEmbedderDefaults(t *testing.T) {
+	url, fake := serveFake(t, openAIEmbeddings)
+	e, err := NewAzureOpenAIEmbedder("text-embedding-3-small", "", url, "", "", "azure-test-key", types.ProviderOptions{})
+	if err != nil {
+		t.Fatalf("NewAzureOpenAIEmbedder: %v", err)
+	}
+	if _, err := e.Embed(context.Background(), []string{"text"}); err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	req := fake.received()[0]
+	if req.Path != "/openai/deployments/text-embedding-3-small/embeddings" || req.Query != "api-version="+DefaultAzureAPIVersion {
+		t.Errorf("request = %s?%s, want the model as deployment and the default version", req.Path, req.Query)
+	}
+}
+
+func TestAzureOpenAIEmbedderAPIError(t *testing.T) {
+	url, _ := serveFake(t, func(map[string]any) (int, any) {
+		return http.StatusUnauthorized, `{"error": {"code": "401", "message": "Access denied due to invalid subscription key."}}`
+	})
+	e, err := NewAzureOpenAIEmbedder("text-embedding-3-small", "", url, "", "", "wrong-key", types.ProviderOptions{})
+	if err != nil {
+		t.Fatalf("NewAzureOpenAIEmbedder: %v", err)
+	}
+	_, err = e.Embed(context.Background(), []string{"text"})
+	if want := `OpenAI API error 401: {"error": {"code": "401", "message": "Access denied due to invalid subscription key."}}`; err == nil || err.Error() != want {
+		t.Errorf("Embed error = %v, want %s", err, want)
+	}
+}
+
+func TestNewAzureOpenAIEmbedderNeedsEndpoint(t *testing.T) {
+	if _, err := NewAzureOpenAIEmbedder("text-embedding-3-small", "", "", "", "", "azure-test-key", types.ProviderOptions{}); err == nil {
+		t.Error("NewAzureOpenAIEmbedder without base_url succeeded")
+	}
+}
+
+func TestOpenAIEmbedderSendsBearerToken(t *testing.T) {
+	url, fake := serveFake(t, openAIEmbeddings)
+	e, err := NewOpenAIEmbedder("text-embedding-3-small", "", "openai-test-key", types.ProviderOptions{})
+	if err != nil {
+		t.Fatalf("NewOpenAIEmbedder: %v", err)
+	}
+	e.endpoint = url + "/v1/embeddings"
+
+	if _, err := e.Embed(context.Background(), []string{"text"}); err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	req := fake.received()[0]
+	if got := req.Header.Get("Authorization"); got != "Bearer openai-test-key" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if got := req.Header.Get("api-key"); got != "" {
+		t.Errorf("api-key = %q, want it only for Azure", got)
+	}
+}
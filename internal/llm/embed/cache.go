@@ -0,0 +1,113 @@
+package embed
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/matthieukhl/latentia/internal/metrics"
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// Cache stores embeddings by key for CachedEmbedder; database.DB
+// implements it with app_embedding_cache
+type Cache interface {
+	CachedEmbeddings(ctx context.Context, model string, dim int, hashes []string) (map[string][]float32, error)
+	CacheEmbeddings(ctx context.Context, model string, dim int, embeddings map[string][]float32) error
+}
+
+// CachedEmbedder decorates an embedder with a cache of document embeddings,
+// keyed by the sha256 of model, dimension, input type and text, so that
+// indexing unchanged documents again sends nothing to the provider. Only
+// texts embedded as types.EmbedDocument are cached; search queries go to
+// the provider. A cache that fails is logged and bypassed.
+type CachedEmbedder struct {
+	types.Embedder
+	cache Cache
+
+	hits, misses atomic.Int64
+}
+
+func NewCachedEmbedder(embedder types.Embedder, cache Cache) *CachedEmbedder {
+	return &CachedEmbedder{Embedder: embedder, cache: cache}
+}
+
+func (e *CachedEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	input := types.EmbedInputOf(ctx)
+	if input != types.EmbedDocument || len(texts) == 0 {
+		return e.Embedder.Embed(ctx, texts)
+	}
+
+	model, dim := e.Model(), e.Dim()
+	keys := make([]string, len(texts))
+	for i, text := range texts {
+		keys[i] = cacheKey(model, dim, input, text)
+	}
+	cached, err := e.cache.CachedEmbeddings(ctx, model, dim, keys)
+	if err != nil {
+		slog.WarnContext(ctx, "embedding cache unavailable, embedding every text", "error", err)
+		cached = nil
+	}
+
+	vectors := make([][]float32, len(texts))
+	var missing []int
+	for i, key := range keys {
+		if vector, ok := cached[key]; ok {
+			vectors[i] = vector
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	hits := len(texts) - len(missing)
+	e.hits.Add(int64(hits))
+	e.misses.Add(int64(len(missing)))
+	metrics.EmbeddingCacheLookups.Add(float64(hits), "hit")
+	metrics.EmbeddingCacheLookups.Add(float64(len(missing)), "miss")
+	slog.DebugContext(ctx, "embedding cache looked up", "model", model, "hits", hits, "misses", len(missing))
+	if len(missing) == 0 {
+		return vectors, nil
+	}
+
+	batch := make([]string, len(missing))
+	for j, i := range missing {
+		batch[j] = texts[i]
+	}
+	embedded, err := e.Embedder.Embed(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+	// A short answer is left to the caller to reject, uncached
+	if len(embedded) != len(batch) {
+		return embedded, nil
+	}
+	fresh := make(map[string][]float32, len(missing))
+	for j, i := range missing {
+		vectors[i] = embedded[j]
+		if len(embedded[j]) == dim {
+			fresh[keys[i]] = embedded[j]
+		}
+	}
+	if err := e.cache.CacheEmbeddings(ctx, model, dim, fresh); err != nil {
+		slog.WarnContext(ctx, "embeddings not cached", "model", model, "error", err)
+	}
+	return vectors, nil
+}
+
+// Stats returns the texts found in the cache and those embedded by the
+// provider since the embedder was created
+func (e *CachedEmbedder) Stats() (hits, misses int64) {
+	return e.hits.Load(), e.misses.Load()
+}
+
+// cacheKey identifies the embedding of text; a model or dimension change,
+// such as llm.embedder.dimensions, gives new keys
+func cacheKey(model string, dim int, input types.EmbedInput, text string) string {
+	sum := sha256.Sum256([]byte(model + "\x00" + strconv.Itoa(dim) + "\x00" + string(input) + "\x00" + text))
+	return hex.EncodeToString(sum[:])
+}
+
+// Compile-time interface check
+var _ types.Embedder = (*CachedEmbedder)(nil)
@@ -0,0 +1,173 @@
+package embed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/llm/retry"
+	"github.com/matthieukhl/latentia/internal/tracing"
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// DefaultCohereURL is the Cohere API
+const DefaultCohereURL = "https://api.cohere.com"
+
+// cohereMaxTexts is the most texts /v2/embed takes per request
+const cohereMaxTexts = 96
+
+// CohereEmbedder calls Cohere's /v2/embed endpoint, embedding texts as
+// search_document or search_query depending on types.EmbedInputOf
+type CohereEmbedder struct {
+	apiKey  string
+	baseURL string
+	model   string
+	client  *http.Client
+	options types.ProviderOptions
+}
+
+type cohereEmbedRequest struct {
+	Model           string   `json:"model"`
+	Texts           []string `json:"texts"`
+	InputType       string   `json:"input_type"`
+	EmbeddingTypes  []string `json:"embedding_types"`
+	OutputDimension int      `json:"output_dimension,omitempty"`
+}
+
+type cohereEmbedResponse struct {
+	Embeddings struct {
+		Float [][]float32 `json:"float"`
+	} `json:"embeddings"`
+	Meta struct {
+		BilledUnits struct {
+			InputTokens int `json:"input_tokens"`
+		} `json:"billed_units"`
+	} `json:"meta"`
+}
+
+func NewCohereEmbedder(model string, apiKeyEnv string, directAPIKey string, baseURL string, options types.ProviderOptions) (*CohereEmbedder, error) {
+	apiKey := directAPIKey
+	if apiKey == "" && apiKeyEnv != "" {
+		apiKey = os.Getenv(apiKeyEnv)
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("API key not found in config or environment variable %s", apiKeyEnv)
+	}
+	if baseURL == "" {
+		baseURL = DefaultCohereURL
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 30 * time.Second
+	}
+
+	return &CohereEmbedder{
+		apiKey:  apiKey,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		model:   model,
+		client:  &http.Client{Timeout: options.Timeout},
+		options: options,
+	}, nil
+}
+
+func (e *CohereEmbedder) Embed(ctx context.Context, texts []string) (vectors [][]float32, err error) {
+	ctx, span := tracing.StartKind(ctx, "embeddings "+e.model, tracing.KindClient,
+		tracing.String("gen_ai.system", "cohere"), tracing.String("gen_ai.request.model", e.model))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("no texts provided")
+	}
+
+	inputType := "search_document"
+	if types.EmbedInputOf(ctx) == types.EmbedQuery {
+		inputType = "search_query"
+	}
+
+	embeddings := make([][]float32, 0, len(texts))
+	var tokens int
+	for start := 0; start < len(texts); start += cohereMaxTexts {
+		batch := texts[start:min(start+cohereMaxTexts, len(texts))]
+		response, err := e.embedBatch(ctx, cohereEmbedRequest{
+			Model:           e.model,
+			Texts:           batch,
+			InputType:       inputType,
+			EmbeddingTypes:  []string{"float"},
+			OutputDimension: e.options.Dimensions,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(response.Embeddings.Float) != len(batch) {
+			return nil, fmt.Errorf("Cohere returned %d embeddings for %d texts", len(response.Embeddings.Float), len(batch))
+		}
+		embeddings = append(embeddings, response.Embeddings.Float...)
+		tokens += response.Meta.BilledUnits.InputTokens
+	}
+	span.SetAttributes(tracing.Int("gen_ai.usage.input_tokens", int64(tokens)))
+
+	return embeddings, nil
+}
+
+func (e *CohereEmbedder) embedBatch(ctx context.Context, req cohereEmbedRequest) (*cohereEmbedResponse, error) {
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := retry.Do(ctx, e.client, e.options.MaxRetries, func() (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", e.baseURL+"/v2/embed", bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("Authorization", "Bearer "+e.apiKey)
+		return httpReq, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Cohere API error %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var response cohereEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &response, nil
+}
+
+func (e *CohereEmbedder) Dim() int {
+	if e.options.Dimensions > 0 {
+		return e.options.Dimensions
+	}
+	switch e.model {
+	case "embed-v4.0":
+		return 1536
+	case "embed-english-light-v3.0", "embed-multilingual-light-v3.0":
+		return 384
+	default:
+		// embed-english-v3.0 and embed-multilingual-v3.0
+		return 1024
+	}
+}
+
+func (e *CohereEmbedder) Model() string {
+	return e.model
+}
+
+// Compile-time interface check
+var _ types.Embedder = (*CohereEmbedder)(nil)
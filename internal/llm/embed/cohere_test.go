@@ -0,0 +1,172 @@
+package embed
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// cohereEmbeddings answers a Cohere embed request with one vector per text
+// holding its length
+func cohereEmbeddings(body map[string]any) (int, any) {
+	var vectors [][]float32
+	for _, text := range texts(body, "texts") {
+		vectors = append(vectors, []float32{float32(len(text))})
+	}
+	return http.StatusOK, map[string]any{
+		"embeddings": map[string]any{"float": vectors},
+		"meta":       map[string]any{"billed_units": map[string]any{"input_tokens": len(vectors)}},
+	}
+}
+
+func newTestCohere(t *testing.T, url string, options types.ProviderOptions) *CohereEmbedder {
+	t.Helper()
+	e, err := NewCohereEmbedder("embed-english-v3.0", "", "cohere-test-key", url+"/", options)
+	if err != nil {
+		t.Fatalf("NewCohereEmbedder: %v", err)
+	}
+	return e
+}
+
+func TestCohereEmbedRequest(t *testing.T) {
+	url, fake := serveFake(t, cohereEmbeddings)
+	e := newTestCohere(t, url, types.ProviderOptions{})
+
+	vectors, err := e.Embed(context.Background(), []string{"a", "bcd"})
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if want := [][]float32{{1}, {3}}; !reflect.DeepEqual(vectors, want) {
+		t.Errorf("vectors = %v, want %v", vectors, want)
+	}
+
+	req := fake.received()[0]
+	if req.Path != "/v2/embed" {
+		t.Errorf("path = %s", req.Path)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer cohere-test-key" {
+		t.Errorf("Authorization = %q", got)
+	}
+	want := map[string]any{
+		"model":           "embed-english-v3.0",
+		"texts":           []any{"a", "bcd"},
+		"input_type":      "search_document",
+		"embedding_types": []any{"float"},
+	}
+	if !reflect.DeepEqual(req.Body, want) {
+		t.Errorf("body = %v, want %v", req.Body, want)
+	}
+}
+
+func TestCohereEmbedInputType(t *testing.T) {
+	url, fake := serveFake(t, cohereEmbeddings)
+	e := newTestCohere(t, url, types.ProviderOptions{Dimensions: 256})
+
+	for _, input := range []types.EmbedInput{types.EmbedDocument, types.EmbedQuery} {
+		if _, err := e.Embed(types.WithEmbedInput(context.Background(), input), []string{"orders by day"}); err != nil {
+			t.Fatalf("Embed: %v", err)
+		}
+	}
+	requests := fake.received()
+	if got := requests[0].Body["input_type"]; got != "search_document" {
+		t.Errorf("document input_type = %v", got)
+	}
+	if got := requests[1].Body["input_type"]; got != "search_query" {
+		t.Errorf("query input_type = %v", got)
+	}
+	if got := requests[1].Body["output_dimension"]; got != float64(256) {
+		t.Errorf("output_dimension = %v, want 256", got)
+	}
+	if e.Dim() != 256 {
+		t.Errorf("Dim() = %d, want the configured 256", e.Dim())
+	}
+}
+
+func TestCohereEmbedBatches(t *testing.T) {
+	url, fake := serveFake(t, cohereEmbeddings)
+	e := newTestCohere(t, url, types.ProviderOptions{})
+
+	input := make([]string, cohereMaxTexts+4)
+	for i := range input {
+		input[i] = strings.Repeat("x", i)
+	}
+	vectors, err := e.Embed(context.Background(), input)
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	requests := fake.received()
+	if len(requests) != 2 || len(texts(requests[0].Body, "texts")) != cohereMaxTexts || len(texts(requests[1].Body, "texts")) != 4 {
+		t.Fatalf("got %d requests, want batches of %d and 4", len(requests), cohereMaxTexts)
+	}
+	for i, vector := range vectors {
+		if vector[0] != float32(i) {
+			t.Fatalf("vector %d = %v, want the embeddings in input order", i, vector)
+		}
+	}
+}
+
+func TestCohereEmbedErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		respond func(map[string]any) (int, any)
+		want    string
+	}{
+		{
+			"API error",
+			func(map[string]any) (int, any) {
+				return http.StatusBadRequest, `{"message": "invalid input_type"}` + "\n"
+			},
+			`Cohere API error 400: {"message": "invalid input_type"}`,
+		},
+		{
+			"too few embeddings",
+			func(map[string]any) (int, any) {
+				return http.StatusOK, map[string]any{"embeddings": map[string]any{"float": [][]float32{{1}}}}
+			},
+			"Cohere returned 1 embeddings for 2 texts",
+		},
+		{
+			"undecodable response",
+			func(map[string]any) (int, any) { return http.StatusOK, `{"embeddings": [` },
+			"failed to decode response: unexpected EOF",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, _ := serveFake(t, tt.respond)
+			_, err := newTestCohere(t, url, types.ProviderOptions{}).Embed(context.Background(), []string{"a", "b"})
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("Embed error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := newTestCohere(t, "http://127.0.0.1:1", types.ProviderOptions{}).Embed(context.Background(), nil); err == nil || err.Error() != "no texts provided" {
+		t.Errorf("Embed(nil) error = %v", err)
+	}
+}
+
+func TestCohereDim(t *testing.T) {
+	for model, want := range map[string]int{
+		"embed-v4.0":                    1536,
+		"embed-english-v3.0":            1024,
+		"embed-multilingual-v3.0":       1024,
+		"embed-english-light-v3.0":      384,
+		"embed-multilingual-light-v3.0": 384,
+	} {
+		e, err := NewCohereEmbedder(model, "", "key", "", types.ProviderOptions{})
+		if err != nil {
+			t.Fatalf("NewCohereEmbedder: %v", err)
+		}
+		if e.Dim() != want {
+			t.Errorf("%s: Dim() = %d, want %d", model, e.Dim(), want)
+		}
+		if e.baseURL != DefaultCohereURL {
+			t.Errorf("base URL = %q, want the default", e.baseURL)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package embed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/llm/retry"
+	"github.com/matthieukhl/latentia/internal/tracing"
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// DefaultOllamaURL is where a local "ollama serve" listens
+const DefaultOllamaURL = "http://localhost:11434"
+
+// OllamaEmbedder talks to an Ollama server's /api/embeddings endpoint; it
+// needs no API key. The model decides the vector size, so dim must match it.
+type OllamaEmbedder struct {
+	baseURL string
+	model   string
+	dim     int
+	client  *http.Client
+	options types.ProviderOptions
+}
+
+type ollamaEmbedRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+}
+
+type ollamaEmbedResponse struct {
+	Embedding []float32 `json:"embedding"`
+	Error     string    `json:"error"`
+}
+
+func NewOllamaEmbedder(model string, baseURL string, dim int, options types.ProviderOptions) *OllamaEmbedder {
+	if baseURL == "" {
+		baseURL = DefaultOllamaURL
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 30 * time.Second
+	}
+
+	return &OllamaEmbedder{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		model:   model,
+		dim:     dim,
+		client:  &http.Client{Timeout: options.Timeout},
+		options: options,
+	}
+}
+
+func (e *OllamaEmbedder) Embed(ctx context.Context, texts []string) (vectors [][]float32, err error) {
+	ctx, span := tracing.StartKind(ctx, "embeddings "+e.model, tracing.KindClient,
+		tracing.String("gen_ai.system", "ollama"), tracing.String("gen_ai.request.model", e.model))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("no texts provided")
+	}
+
+	// /api/embeddings takes one prompt per request
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		embedding, err := e.embedOne(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		if len(embedding) != e.dim {
+			return nil, fmt.Errorf("model %s returned %d dimensions, expected %d (set vector.dim to match the model)", e.model, len(embedding), e.dim)
+		}
+		embeddings[i] = embedding
+	}
+
+	return embeddings, nil
+}
+
+func (e *OllamaEmbedder) embedOne(ctx context.Context, text string) ([]float32, error) {
+	jsonData, err := json.Marshal(ollamaEmbedRequest{Model: e.model, Prompt: text})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := retry.Do(ctx, e.client, e.options.MaxRetries, func() (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", e.baseURL+"/api/embeddings", bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		httpReq.Header.Set("Content-Type", "application/json")
+		return httpReq, nil
+	})
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return nil, fmt.Errorf("no Ollama server at %s (is 'ollama serve' running?): %w", e.baseURL, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Ollama API error %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var response ollamaEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("Ollama error: %s", response.Error)
+	}
+	return response.Embedding, nil
+}
+
+func (e *OllamaEmbedder) Dim() int {
+	return e.dim
+}
+
+func (e *OllamaEmbedder) Model() string {
+	return e.model
+}
+
+// Compile-time interface check
+var _ types.Embedder = (*OllamaEmbedder)(nil)
@@ -10,15 +10,26 @@ import (
 	"os"
 	"time"
 
+	"github.com/matthieukhl/latentia/internal/llm/retry"
+	"github.com/matthieukhl/latentia/internal/tracing"
 	"github.com/matthieukhl/latentia/internal/types"
 )
 
 type OpenAIEmbedder struct {
-	apiKey string
-	model  string
-	client *http.Client
+	apiKey  string
+	model   string
+	client  *http.Client
+	options types.ProviderOptions
+
+	// endpoint is the embeddings URL; azure sends the key in the api-key
+	// header instead of as a bearer token
+	endpoint string
+	azure    bool
 }
 
+// openAIEmbeddingsURL is the embeddings endpoint of api.openai.com
+const openAIEmbeddingsURL = "https://api.openai.com/v1/embeddings"
+
 type openAIEmbedRequest struct {
 	Input []string `json:"input"`
 	Model string   `json:"model"`
@@ -38,7 +49,7 @@ type openAIEmbedResponse struct {
 	} `json:"usage"`
 }
 
-func NewOpenAIEmbedder(model string, apiKeyEnv string, directAPIKey string) (*OpenAIEmbedder, error) {
+func NewOpenAIEmbedder(model string, apiKeyEnv string, directAPIKey string, options types.ProviderOptions) (*OpenAIEmbedder, error) {
 	var apiKey string
 	
 	// First try direct API key from config
@@ -53,16 +64,27 @@ func NewOpenAIEmbedder(model string, apiKeyEnv string, directAPIKey string) (*Op
 		return nil, fmt.Errorf("API key not found in config or environment variable %s", apiKeyEnv)
 	}
 	
+	if options.Timeout <= 0 {
+		options.Timeout = 30 * time.Second
+	}
+	
 	return &OpenAIEmbedder{
-		apiKey: apiKey,
-		model:  model,
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		apiKey:   apiKey,
+		model:    model,
+		client:   &http.Client{Timeout: options.Timeout},
+		options:  options,
+		endpoint: openAIEmbeddingsURL,
 	}, nil
 }
 
-func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) (vectors [][]float32, err error) {
+	ctx, span := tracing.StartKind(ctx, "embeddings "+e.model, tracing.KindClient,
+		tracing.String("gen_ai.system", "openai"), tracing.String("gen_ai.request.model", e.model))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	
 	if len(texts) == 0 {
 		return nil, fmt.Errorf("no texts provided")
 	}
@@ -77,15 +99,20 @@ func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 	
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/embeddings", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", e.apiKey))
-	
-	resp, err := e.client.Do(httpReq)
+	resp, err := retry.Do(ctx, e.client, e.options.MaxRetries, func() (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", e.endpoint, bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		
+		httpReq.Header.Set("Content-Type", "application/json")
+		if e.azure {
+			httpReq.Header.Set("api-key", e.apiKey)
+		} else {
+			httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", e.apiKey))
+		}
+		return httpReq, nil
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
@@ -101,6 +128,8 @@ func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 	
+	span.SetAttributes(tracing.Int("gen_ai.usage.input_tokens", int64(response.Usage.PromptTokens)))
+	
 	embeddings := make([][]float32, len(response.Data))
 	for _, data := range response.Data {
 		if data.Index >= len(embeddings) {
@@ -0,0 +1,50 @@
+package embed
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+func TestEmbedderClientTimeout(t *testing.T) {
+	embedders := []struct {
+		name string
+		make func(t *testing.T, options types.ProviderOptions) *http.Client
+	}{
+		{"openai", func(t *testing.T, options types.ProviderOptions) *http.Client {
+			e, err := NewOpenAIEmbedder("text-embedding-3-small", "", "key", options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return e.client
+		}},
+		{"azure-openai", func(t *testing.T, options types.ProviderOptions) *http.Client {
+			e, err := NewAzureOpenAIEmbedder("text-embedding-3-small", "embeddings", "https://example.openai.azure.com", "", "", "key", options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return e.client
+		}},
+		{"cohere", func(t *testing.T, options types.ProviderOptions) *http.Client {
+			return newTestCohere(t, "http://127.0.0.1:1", options).client
+		}},
+		{"voyage", func(t *testing.T, options types.ProviderOptions) *http.Client {
+			return newTestVoyage(t, "http://127.0.0.1:1", options).client
+		}},
+		{"ollama", func(t *testing.T, options types.ProviderOptions) *http.Client {
+			return NewOllamaEmbedder("nomic-embed-text", "http://127.0.0.1:1", 768, options).client
+		}},
+	}
+	for _, e := range embedders {
+		t.Run(e.name, func(t *testing.T) {
+			if got := e.make(t, types.ProviderOptions{Timeout: 2 * time.Minute}).Timeout; got != 2*time.Minute {
+				t.Errorf("configured client timeout = %v, want 2m", got)
+			}
+			if got := e.make(t, types.ProviderOptions{}).Timeout; got != 30*time.Second {
+				t.Errorf("default client timeout = %v, want 30s", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package embed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/llm/retry"
+	"github.com/matthieukhl/latentia/internal/tracing"
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// DefaultVoyageURL is the Voyage AI API
+const DefaultVoyageURL = "https://api.voyageai.com"
+
+// voyageMaxTexts is the most texts /v1/embeddings takes per request
+const voyageMaxTexts = 1000
+
+// VoyageEmbedder calls Voyage AI's /v1/embeddings endpoint, embedding texts
+// as document or query depending on types.EmbedInputOf
+type VoyageEmbedder struct {
+	apiKey  string
+	baseURL string
+	model   string
+	client  *http.Client
+	options types.ProviderOptions
+}
+
+type voyageEmbedRequest struct {
+	Input           []string `json:"input"`
+	Model           string   `json:"model"`
+	InputType       string   `json:"input_type"`
+	OutputDimension int      `json:"output_dimension,omitempty"`
+}
+
+type voyageEmbedResponse struct {
+	Data []struct {
+		Embedding []float32 `json:"embedding"`
+		Index     int       `json:"index"`
+	} `json:"data"`
+	Usage struct {
+		TotalTokens int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+func NewVoyageEmbedder(model string, apiKeyEnv string, directAPIKey string, baseURL string, options types.ProviderOptions) (*VoyageEmbedder, error) {
+	apiKey := directAPIKey
+	if apiKey == "" && apiKeyEnv != "" {
+		apiKey = os.Getenv(apiKeyEnv)
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("API key not found in config or environment variable %s", apiKeyEnv)
+	}
+	if baseURL == "" {
+		baseURL = DefaultVoyageURL
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 30 * time.Second
+	}
+
+	return &VoyageEmbedder{
+		apiKey:  apiKey,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		model:   model,
+		client:  &http.Client{Timeout: options.Timeout},
+		options: options,
+	}, nil
+}
+
+func (e *VoyageEmbedder) Embed(ctx context.Context, texts []string) (vectors [][]float32, err error) {
+	ctx, span := tracing.StartKind(ctx, "embeddings "+e.model, tracing.KindClient,
+		tracing.String("gen_ai.system", "voyage"), tracing.String("gen_ai.request.model", e.model))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("no texts provided")
+	}
+
+	embeddings := make([][]float32, 0, len(texts))
+	var tokens int
+	for start := 0; start < len(texts); start += voyageMaxTexts {
+		batch := texts[start:min(start+voyageMaxTexts, len(texts))]
+		response, err := e.embedBatch(ctx, voyageEmbedRequest{
+			Input:           batch,
+			Model:           e.model,
+			InputType:       string(types.EmbedInputOf(ctx)),
+			OutputDimension: e.options.Dimensions,
+		})
+		if err != nil {
+			return nil, err
+		}
+		vectors := make([][]float32, len(batch))
+		for _, data := range response.Data {
+			if data.Index < 0 || data.Index >= len(vectors) {
+				return nil, fmt.Errorf("invalid embedding index %d", data.Index)
+			}
+			vectors[data.Index] = data.Embedding
+		}
+		for i, vector := range vectors {
+			if vector == nil {
+				return nil, fmt.Errorf("Voyage returned no embedding for text %d", start+i)
+			}
+		}
+		embeddings = append(embeddings, vectors...)
+		tokens += response.Usage.TotalTokens
+	}
+	span.SetAttributes(tracing.Int("gen_ai.usage.input_tokens", int64(tokens)))
+
+	return embeddings, nil
+}
+
+func (e *VoyageEmbedder) embedBatch(ctx context.Context, req voyageEmbedRequest) (*voyageEmbedResponse, error) {
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := retry.Do(ctx, e.client, e.options.MaxRetries, func() (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", e.baseURL+"/v1/embeddings", bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("Authorization", "Bearer "+e.apiKey)
+		return httpReq, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Voyage API error %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var response voyageEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &response, nil
+}
+
+func (e *VoyageEmbedder) Dim() int {
+	if e.options.Dimensions > 0 {
+		return e.options.Dimensions
+	}
+	switch e.model {
+	case "voyage-3-lite":
+		return 512
+	case "voyage-code-2":
+		return 1536
+	default:
+		// voyage-3.5, voyage-3-large, voyage-code-3 and the domain models
+		return 1024
+	}
+}
+
+func (e *VoyageEmbedder) Model() string {
+	return e.model
+}
+
+// Compile-time interface check
+var _ types.Embedder = (*VoyageEmbedder)(nil)
@@ -0,0 +1,128 @@
+package embed
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// voyageEmbeddings answers a Voyage embeddings request with one vector per
+// input holding its position, listed in reverse order
+func voyageEmbeddings(body map[string]any) (int, any) {
+	input := texts(body, "input")
+	var data []map[string]any
+	for i := len(input) - 1; i >= 0; i-- {
+		data = append(data, map[string]any{"object": "embedding", "index": i, "embedding": []float32{float32(i)}})
+	}
+	return http.StatusOK, map[string]any{"data": data, "usage": map[string]any{"total_tokens": len(input)}}
+}
+
+func newTestVoyage(t *testing.T, url string, options types.ProviderOptions) *VoyageEmbedder {
+	t.Helper()
+	e, err := NewVoyageEmbedder("voyage-3.5", "", "voyage-test-key", url, options)
+	if err != nil {
+		t.Fatalf("NewVoyageEmbedder: %v", err)
+	}
+	return e
+}
+
+func TestVoyageEmbedRequest(t *testing.T) {
+	url, fake := serveFake(t, voyageEmbeddings)
+	e := newTestVoyage(t, url, types.ProviderOptions{Dimensions: 512})
+
+	vectors, err := e.Embed(types.WithEmbedInput(context.Background(), types.EmbedQuery), []string{"first", "second", "third"})
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if want := [][]float32{{0}, {1}, {2}}; !reflect.DeepEqual(vectors, want) {
+		t.Errorf("vectors = %v, want them by index", vectors)
+	}
+
+	req := fake.received()[0]
+	if req.Path != "/v1/embeddings" {
+		t.Errorf("path = %s", req.Path)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer voyage-test-key" {
+		t.Errorf("Authorization = %q", got)
+	}
+	want := map[string]any{
+		"model":            "voyage-3.5",
+		"input":            []any{"first", "second", "third"},
+		"input_type":       "query",
+		"output_dimension": float64(512),
+	}
+	if !reflect.DeepEqual(req.Body, want) {
+		t.Errorf("body = %v, want %v", req.Body, want)
+	}
+}
+
+func TestVoyageEmbedInputTypeDefaultsToDocument(t *testing.T) {
+	url, fake := serveFake(t, voyageEmbeddings)
+	if _, err := newTestVoyage(t, url, types.ProviderOptions{}).Embed(context.Background(), []string{"text"}); err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	body := fake.received()[0].Body
+	if got := body["input_type"]; got != "document" {
+		t.Errorf("input_type = %v, want document", got)
+	}
+	if _, ok := body["output_dimension"]; ok {
+		t.Errorf("output_dimension = %v, want the model's default", body["output_dimension"])
+	}
+}
+
+func TestVoyageEmbedErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		respond func(map[string]any) (int, any)
+		want    string
+	}{
+		{
+			"API error",
+			func(map[string]any) (int, any) {
+				return http.StatusUnauthorized, `{"detail": "Provided API key is invalid."}`
+			},
+			`Voyage API error 401: {"detail": "Provided API key is invalid."}`,
+		},
+		{
+			"index out of range",
+			func(map[string]any) (int, any) {
+				return http.StatusOK, map[string]any{"data": []map[string]any{{"index": 5, "embedding": []float32{1}}}}
+			},
+			"invalid embedding index 5",
+		},
+		{
+			"missing embedding",
+			func(map[string]any) (int, any) {
+				return http.StatusOK, map[string]any{"data": []map[string]any{{"index": 0, "embedding": []float32{1}}}}
+			},
+			"Voyage returned no embedding for text 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, _ := serveFake(t, tt.respond)
+			_, err := newTestVoyage(t, url, types.ProviderOptions{}).Embed(context.Background(), []string{"a", "b"})
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("Embed error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVoyageDim(t *testing.T) {
+	for model, want := range map[string]int{"voyage-3.5": 1024, "voyage-3-lite": 512, "voyage-code-2": 1536} {
+		e, err := NewVoyageEmbedder(model, "", "key", "", types.ProviderOptions{})
+		if err != nil {
+			t.Fatalf("NewVoyageEmbedder: %v", err)
+		}
+		if e.Dim() != want {
+			t.Errorf("%s: Dim() = %d, want %d", model, e.Dim(), want)
+		}
+	}
+	if _, err := NewVoyageEmbedder("voyage-3.5", "LATENTIA_TEST_UNSET_KEY", "", "", types.ProviderOptions{}); err == nil {
+		t.Error("NewVoyageEmbedder without a key succeeded")
+	}
+}
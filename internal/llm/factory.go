@@ -1,36 +1,113 @@
 package llm
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/matthieukhl/latentia/internal/config"
 	"github.com/matthieukhl/latentia/internal/llm/embed"
 	"github.com/matthieukhl/latentia/internal/llm/generate"
+	"github.com/matthieukhl/latentia/internal/metrics"
 	"github.com/matthieukhl/latentia/internal/types"
 )
 
-// NewEmbedder creates an embedder based on configuration
+// NewEmbedder creates an embedder based on configuration. Its calls are
+// recorded in the metrics and limited to llm.embedder.requests_per_minute
+// and tokens_per_minute across every embedder of the process.
 func NewEmbedder(cfg *config.LLMConfig) (types.Embedder, error) {
+	var embedder types.Embedder
+	var err error
 	switch cfg.Embedder.Provider {
 	case "openai":
-		return embed.NewOpenAIEmbedder(cfg.Embedder.Model, cfg.Embedder.APIKeyEnv, cfg.Embedder.APIKey)
+		embedder, err = embed.NewOpenAIEmbedder(cfg.Embedder.Model, cfg.Embedder.APIKeyEnv, cfg.Embedder.ResolvedAPIKey(), cfg.Embedder.Options())
+	case "azure-openai":
+		embedder, err = embed.NewAzureOpenAIEmbedder(cfg.Embedder.Model, cfg.Embedder.Deployment, cfg.Embedder.BaseURL, cfg.Embedder.APIVersion, cfg.Embedder.APIKeyEnv, cfg.Embedder.ResolvedAPIKey(), cfg.Embedder.Options())
+	case "cohere":
+		embedder, err = embed.NewCohereEmbedder(cfg.Embedder.Model, cfg.Embedder.APIKeyEnv, cfg.Embedder.ResolvedAPIKey(), cfg.Embedder.BaseURL, cfg.Embedder.Options())
+	case "voyage":
+		embedder, err = embed.NewVoyageEmbedder(cfg.Embedder.Model, cfg.Embedder.APIKeyEnv, cfg.Embedder.ResolvedAPIKey(), cfg.Embedder.BaseURL, cfg.Embedder.Options())
+	case "ollama":
+		embedder = embed.NewOllamaEmbedder(cfg.Embedder.Model, cfg.Embedder.BaseURL, config.Current().Vector.Dim, cfg.Embedder.Options())
 	case "mock":
-		return embed.NewMockEmbedder(cfg.Embedder.Model, 1536), nil
+		embedder = embed.NewMockEmbedder(cfg.Embedder.Model, config.Current().Vector.Dim)
 	default:
 		return nil, fmt.Errorf("unsupported embedder provider: %s", cfg.Embedder.Provider)
 	}
+	if err != nil {
+		return nil, err
+	}
+	return &measuredEmbedder{Embedder: embedder, provider: cfg.Embedder.Provider, limiter: embedLimiter}, nil
+}
+
+// embedLimiter is shared by every embedder created by NewEmbedder, like
+// defaultQueue for generators
+var embedLimiter = newRateLimiter()
+
+// measuredEmbedder records the calls of an embedder in the metrics, within
+// the provider's rate limit
+type measuredEmbedder struct {
+	types.Embedder
+	provider string
+	limiter  *rateLimiter
+}
+
+func (e *measuredEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	tokens := 0
+	for _, text := range texts {
+		tokens += len(text) / 4
+	}
+	if err := e.limiter.wait(ctx, providerLimits(config.Current().LLM.Embedder), tokens); err != nil {
+		return nil, err
+	}
+	started := time.Now()
+	vectors, err := e.Embedder.Embed(ctx, texts)
+	metrics.EmbeddingDuration.Observe(time.Since(started).Seconds(), e.provider)
+	metrics.EmbeddingRequests.Inc(e.provider, metrics.Outcome(err))
+	metrics.EmbeddingTexts.Add(float64(len(texts)), e.provider)
+	return vectors, err
 }
 
-// NewGenerator creates a generator based on configuration
+// NewGenerator creates a generator based on configuration. Its calls go
+// through the process-wide queue enforcing llm.queue, then the limiter of
+// the provider; mark background calls with WithPriority(ctx, PriorityBatch).
 func NewGenerator(cfg *config.LLMConfig) (types.Generator, error) {
+	var generator types.Generator
+	var err error
 	switch cfg.Generator.Provider {
 	case "openai":
-		return generate.NewOpenAIGenerator(cfg.Generator.Model, cfg.Generator.APIKeyEnv, cfg.Generator.APIKey)
+		generator, err = generate.NewOpenAIGenerator(cfg.Generator.Model, cfg.Generator.APIKeyEnv, cfg.Generator.ResolvedAPIKey(), cfg.Generator.Options())
+	case "azure-openai":
+		generator, err = generate.NewAzureOpenAIGenerator(cfg.Generator.Model, cfg.Generator.Deployment, cfg.Generator.BaseURL, cfg.Generator.APIVersion, cfg.Generator.APIKeyEnv, cfg.Generator.ResolvedAPIKey(), cfg.Generator.Options())
 	case "anthropic":
-		return generate.NewAnthropicGenerator(cfg.Generator.Model, cfg.Generator.APIKeyEnv, cfg.Generator.APIKey)
+		generator, err = generate.NewAnthropicGenerator(cfg.Generator.Model, cfg.Generator.APIKeyEnv, cfg.Generator.ResolvedAPIKey(), cfg.Generator.Options())
+	case "gemini":
+		generator, err = generate.NewGeminiGenerator(cfg.Generator.Model, cfg.Generator.APIKeyEnv, cfg.Generator.ResolvedAPIKey(), cfg.Generator.BaseURL, cfg.Generator.Options())
+	case "ollama":
+		generator = generate.NewOllamaGenerator(cfg.Generator.Model, cfg.Generator.BaseURL, cfg.Generator.Options())
 	case "mock":
-		return generate.NewMockGenerator(cfg.Generator.Model), nil
+		generator = generate.NewMockGenerator(cfg.Generator.Model, mockOptions(cfg.Mock))
 	default:
 		return nil, fmt.Errorf("unsupported generator provider: %s", cfg.Generator.Provider)
 	}
-}
\ No newline at end of file
+	if err != nil {
+		return nil, err
+	}
+	return &queuedGenerator{Generator: generator, provider: cfg.Generator.Provider, queue: defaultQueue, limiter: generatorLimiter, maxTokens: cfg.Generator.MaxTokens}, nil
+}
+
+// generatorLimiter enforces llm.generator.requests_per_minute and
+// tokens_per_minute for every generator created by NewGenerator
+var generatorLimiter = newRateLimiter()
+// mockOptions converts llm.mock, validated by config, for the mock generator
+func mockOptions(cfg config.MockConfig) generate.MockOptions {
+	options := generate.MockOptions{
+		Latency:     cfg.Latency,
+		FailureRate: cfg.FailureRate,
+		Failure:     generate.MockFailure(cfg.Failure),
+	}
+	for _, step := range cfg.Script {
+		options.Script = append(options.Script, generate.MockFailure(step))
+	}
+	return options
+}
@@ -10,13 +10,16 @@ import (
 	"os"
 	"time"
 
+	"github.com/matthieukhl/latentia/internal/llm/retry"
+	"github.com/matthieukhl/latentia/internal/tracing"
 	"github.com/matthieukhl/latentia/internal/types"
 )
 
 type AnthropicGenerator struct {
-	apiKey string
-	model  string
-	client *http.Client
+	apiKey  string
+	model   string
+	client  *http.Client
+	options types.ProviderOptions
 }
 
 type anthropicMessage struct {
@@ -25,19 +28,44 @@ type anthropicMessage struct {
 }
 
 type anthropicRequest struct {
-	Model     string             `json:"model"`
-	MaxTokens int                `json:"max_tokens"`
-	Messages  []anthropicMessage `json:"messages"`
-	System    string             `json:"system,omitempty"`
+	Model       string             `json:"model"`
+	MaxTokens   int                `json:"max_tokens"`
+	Messages    []anthropicMessage `json:"messages"`
+	System      string             `json:"system,omitempty"`
+	Temperature *float64           `json:"temperature,omitempty"`
+	TopP        float64            `json:"top_p,omitempty"`
+	Stop        []string           `json:"stop_sequences,omitempty"`
+
+	Tools      []anthropicTool      `json:"tools,omitempty"`
+	ToolChoice *anthropicToolChoice `json:"tool_choice,omitempty"`
+
+	Stream bool `json:"stream,omitempty"`
 }
 
+// anthropicTool is the tool the model is forced to call in JSON mode; its
+// input is the JSON object returned as the completion
+type anthropicTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+type anthropicToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// anthropicJSONTool names the tool used for JSON responses
+const anthropicJSONTool = "respond"
+
 type anthropicResponse struct {
 	ID      string `json:"id"`
 	Type    string `json:"type"`
 	Role    string `json:"role"`
 	Content []struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
+		Type  string          `json:"type"`
+		Text  string          `json:"text"`
+		Input json.RawMessage `json:"input,omitempty"`
 	} `json:"content"`
 	Model        string `json:"model"`
 	StopReason   string `json:"stop_reason"`
@@ -48,7 +76,31 @@ type anthropicResponse struct {
 	} `json:"usage"`
 }
 
-func NewAnthropicGenerator(model string, apiKeyEnv string, directAPIKey string) (*AnthropicGenerator, error) {
+// anthropicStreamEvent is the data of one event of a streamed message;
+// which fields are set depends on Type
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+	} `json:"delta"`
+	Usage struct {
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func NewAnthropicGenerator(model string, apiKeyEnv string, directAPIKey string, options types.ProviderOptions) (*AnthropicGenerator, error) {
 	var apiKey string
 	
 	// First try direct API key from config
@@ -63,78 +115,213 @@ func NewAnthropicGenerator(model string, apiKeyEnv string, directAPIKey string)
 		return nil, fmt.Errorf("API key not found in config or environment variable %s", apiKeyEnv)
 	}
 	
+	if options.Timeout <= 0 {
+		options.Timeout = 60 * time.Second
+	}
+	
 	return &AnthropicGenerator{
-		apiKey: apiKey,
-		model:  model,
-		client: &http.Client{
-			Timeout: 60 * time.Second,
-		},
+		apiKey:  apiKey,
+		model:   model,
+		client:  &http.Client{Timeout: options.Timeout},
+		options: options,
 	}, nil
 }
 
-func (g *AnthropicGenerator) Complete(ctx context.Context, prompt string, opts map[string]any) (string, error) {
-	maxTokens := 4000
-	if val, ok := opts["max_tokens"].(int); ok && val > 0 {
-		maxTokens = val
+func (g *AnthropicGenerator) Complete(ctx context.Context, prompt string, opts types.GenerationOptions) (text string, err error) {
+	ctx, span := tracing.StartKind(ctx, "chat "+g.model, tracing.KindClient,
+		tracing.String("gen_ai.system", "anthropic"), tracing.String("gen_ai.request.model", g.model))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	ctx, cancel := completionContext(ctx, opts)
+	defer cancel()
+	
+	resp, err := g.send(ctx, g.request(prompt, opts))
+	if err != nil {
+		return "", err
 	}
+	defer resp.Body.Close()
 	
-	system := "You are a TiDB performance expert specializing in SQL optimization."
-	if val, ok := opts["system"].(string); ok && val != "" {
-		system = val
+	var response anthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	
+	types.RecordUsage(ctx, response.Usage.InputTokens, response.Usage.OutputTokens)
+	span.SetAttributes(
+		tracing.Int("gen_ai.usage.input_tokens", int64(response.Usage.InputTokens)),
+		tracing.Int("gen_ai.usage.output_tokens", int64(response.Usage.OutputTokens)))
+	
+	if len(response.Content) == 0 {
+		return "", fmt.Errorf("no content in response")
+	}
+	
+	for _, block := range response.Content {
+		if block.Type == "tool_use" && len(block.Input) > 0 {
+			return string(block.Input), nil
+		}
+	}
+	return response.Content[0].Text, nil
+}
+
+// CompleteStream streams the completion of prompt, calling onChunk with
+// each piece of text as it arrives. In JSON mode the pieces are those of
+// the forced tool call's input.
+func (g *AnthropicGenerator) CompleteStream(ctx context.Context, prompt string, opts types.GenerationOptions, onChunk func(chunk string) error) (err error) {
+	ctx, span := tracing.StartKind(ctx, "chat "+g.model, tracing.KindClient,
+		tracing.String("gen_ai.system", "anthropic"), tracing.String("gen_ai.request.model", g.model))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	ctx, cancel := completionContext(ctx, opts)
+	defer cancel()
+
+	req := g.request(prompt, opts)
+	req.Stream = true
+	resp, err := g.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var inputTokens, outputTokens int
+	done := false
+	err = readSSE(resp.Body, func(event, data string) error {
+		var e anthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return fmt.Errorf("failed to decode %s event: %w", event, err)
+		}
+		switch e.Type {
+		case "message_start":
+			inputTokens = e.Message.Usage.InputTokens
+			outputTokens = e.Message.Usage.OutputTokens
+		case "content_block_delta":
+			chunk := e.Delta.Text
+			if e.Delta.Type == "input_json_delta" {
+				chunk = e.Delta.PartialJSON
+			}
+			if chunk != "" {
+				return onChunk(chunk)
+			}
+		case "message_delta":
+			outputTokens = e.Usage.OutputTokens
+		case "message_stop":
+			done = true
+			return errStreamDone
+		case "error":
+			return fmt.Errorf("Anthropic stream error (%s): %s", e.Error.Type, e.Error.Message)
+		}
+		return nil
+	})
+
+	types.RecordUsage(ctx, inputTokens, outputTokens)
+	span.SetAttributes(
+		tracing.Int("gen_ai.usage.input_tokens", int64(inputTokens)),
+		tracing.Int("gen_ai.usage.output_tokens", int64(outputTokens)))
+	if err != nil {
+		return err
+	}
+	if !done {
+		return errStreamIncomplete
+	}
+	return nil
+}
+
+// request builds the messages request for prompt and opts; the API has no
+// seed, so opts.Seed is ignored
+func (g *AnthropicGenerator) request(prompt string, opts types.GenerationOptions) anthropicRequest {
+	maxTokens := 4000
+	if g.options.MaxTokens > 0 {
+		maxTokens = g.options.MaxTokens
+	}
+	if opts.MaxTokens > 0 {
+		maxTokens = opts.MaxTokens
 	}
 	
 	req := anthropicRequest{
 		Model:     g.model,
 		MaxTokens: maxTokens,
-		System:    system,
+		System:    systemPrompt(opts),
 		Messages: []anthropicMessage{
 			{
 				Role:    "user",
 				Content: prompt,
 			},
 		},
+		TopP: opts.TopP,
+		Stop: opts.Stop,
 	}
 	
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+	if g.options.Temperature != nil {
+		req.Temperature = g.options.Temperature
+	}
+	if opts.Temperature != nil {
+		req.Temperature = opts.Temperature
 	}
 	
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", "https://api.anthropic.com/v1/messages", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+	// There is no JSON mode; forcing a tool call gets the same result
+	if opts.JSON {
+		schema := opts.JSONSchema
+		if schema == nil {
+			schema = map[string]any{"type": "object"}
+		}
+		req.Tools = []anthropicTool{{
+			Name:        anthropicJSONTool,
+			Description: "Submit the response as a JSON object",
+			InputSchema: schema,
+		}}
+		req.ToolChoice = &anthropicToolChoice{Type: "tool", Name: anthropicJSONTool}
 	}
 	
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("x-api-key", g.apiKey)
-	httpReq.Header.Set("anthropic-version", "2023-06-01")
+	return req
+}
+
+// send posts req, retrying as configured, and returns the response once the
+// API accepted it
+func (g *AnthropicGenerator) send(ctx context.Context, req anthropicRequest) (*http.Response, error) {
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
 	
-	resp, err := g.client.Do(httpReq)
+	resp, err := retry.Do(ctx, g.client, g.options.MaxRetries, func() (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", "https://api.anthropic.com/v1/messages", bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("x-api-key", g.apiKey)
+		httpReq.Header.Set("anthropic-version", "2023-06-01")
+		return httpReq, nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to make request: %w", err)
+		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
-	defer resp.Body.Close()
 	
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("Anthropic API error %d: %s", resp.StatusCode, string(body))
+		resp.Body.Close()
+		return nil, fmt.Errorf("Anthropic API error %d: %s", resp.StatusCode, string(body))
 	}
 	
-	var response anthropicResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
-	}
-	
-	if len(response.Content) == 0 {
-		return "", fmt.Errorf("no content in response")
-	}
-	
-	return response.Content[0].Text, nil
+	return resp, nil
 }
 
 func (g *AnthropicGenerator) Model() string {
 	return g.model
 }
 
+// SupportsJSON is true: the "json" option forces a tool call whose input is
+// the response
+func (g *AnthropicGenerator) SupportsJSON() bool {
+	return true
+}
+
 // Compile-time interface check
-var _ types.Generator = (*AnthropicGenerator)(nil)
\ No newline at end of file
+var (
+	_ types.JSONGenerator      = (*AnthropicGenerator)(nil)
+	_ types.StreamingGenerator = (*AnthropicGenerator)(nil)
+)
\ No newline at end of file
@@ -0,0 +1,37 @@
+package generate
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// DefaultAzureAPIVersion is the Azure OpenAI REST API version used when
+// none is configured
+const DefaultAzureAPIVersion = "2024-10-21"
+
+// NewAzureOpenAIGenerator returns an OpenAI generator for the chat
+// deployment of an Azure OpenAI resource. model is the deployed model's
+// name and defaults deployment; baseURL is the resource endpoint, such as
+// https://{resource}.openai.azure.com.
+func NewAzureOpenAIGenerator(model, deployment, baseURL, apiVersion, apiKeyEnv, directAPIKey string, options types.ProviderOptions) (*OpenAIGenerator, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("azure-openai needs the resource endpoint in base_url")
+	}
+	g, err := NewOpenAIGenerator(model, apiKeyEnv, directAPIKey, options)
+	if err != nil {
+		return nil, err
+	}
+	if deployment == "" {
+		deployment = model
+	}
+	if apiVersion == "" {
+		apiVersion = DefaultAzureAPIVersion
+	}
+	g.endpoint = fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s",
+		strings.TrimRight(baseURL, "/"), url.PathEscape(deployment), url.QueryEscape(apiVersion))
+	g.azure = true
+	return g, nil
+}
@@ -0,0 +1,125 @@
+package generate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+const openAIAnswer = `{"choices": [{"index": 0, "message": {"role": "assistant", "content": "SELECT 1"}, "finish_reason": "stop"}],
+	"usage": {"prompt_tokens": 7, "completion_tokens": 3, "total_tokens": 10}}`
+
+func TestAzureOpenAIGeneratorRequest(t *testing.T) {
+	tests := []struct {
+		name                  string
+		model, deployment     string
+		apiVersion            string
+		wantPath, wantVersion string
+	}{
+		{"deployment and version", "gpt-4o", "prod-gpt4o", "2024-06-01", "/openai/deployments/prod-gpt4o/chat/completions", "2024-06-01"},
+		{"defaults", "gpt-4o-mini", "", "", "/openai/deployments/gpt-4o-mini/chat/completions", DefaultAzureAPIVersion},
+		{"escaped deployment", "gpt-4o", "team a/gpt", "", "/openai/deployments/team%20a%2Fgpt/chat/completions", DefaultAzureAPIVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, fake := serveFake(t, http.StatusOK, openAIAnswer)
+			g, err := NewAzureOpenAIGenerator(tt.model, tt.deployment, srv.URL+"/", tt.apiVersion, "", "azure-test-key", types.ProviderOptions{})
+			if err != nil {
+				t.Fatalf("NewAzureOpenAIGenerator: %v", err)
+			}
+
+			text, err := g.Complete(context.Background(), "prompt", types.GenerationOptions{})
+			if err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			if text != "SELECT 1" {
+				t.Errorf("text = %q", text)
+			}
+
+			req := fake.request(t)
+			if req.Path != tt.wantPath || req.Query != "api-version="+tt.wantVersion {
+				t.Errorf("request = %s?%s, want %s?api-version=%s", req.Path, req.Query, tt.wantPath, tt.wantVersion)
+			}
+			if got := req.Header.Get("api-key"); got != "azure-test-key" {
+				t.Errorf("api-key = %q", got)
+			}
+			if got := req.Header.Get("Authorization"); got != "" {
+				t.Errorf("Authorization = %q, want the key only in api-key", got)
+			}
+			// The deployment picks the model; the body still names it
+			if got := field(req.Body, "model"); got != tt.model {
+				t.Errorf("model = %v, want %s", got, tt.model)
+			}
+			if g.Model() != tt.model {
+				t.Errorf("Model() = %q, want %q", g.Model(), tt.model)
+			}
+		})
+	}
+}
+
+func TestAzureOpenAIGeneratorStream(t *testing.T) {
+	fake := &fakeProvider{contentType: "text/event-stream", body: "" +
+		`data: {"choices": [{"delta": {"content": "SELECT 1"}}]}` + "\n\n" +
+		"data: [DONE]\n\n"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	g, err := NewAzureOpenAIGenerator("gpt-4o", "prod", srv.URL, "", "", "azure-test-key", types.ProviderOptions{})
+	if err != nil {
+		t.Fatalf("NewAzureOpenAIGenerator: %v", err)
+	}
+
+	var text string
+	if err := g.CompleteStream(context.Background(), "prompt", types.GenerationOptions{}, func(chunk string) error {
+		text += chunk
+		return nil
+	}); err != nil {
+		t.Fatalf("CompleteStream: %v", err)
+	}
+	req := fake.request(t)
+	if text != "SELECT 1" || req.Path != "/openai/deployments/prod/chat/completions" || req.Header.Get("api-key") != "azure-test-key" {
+		t.Errorf("text %q from %s with api-key %q", text, req.Path, req.Header.Get("api-key"))
+	}
+	if field(req.Body, "stream") != true {
+		t.Errorf("stream = %v", field(req.Body, "stream"))
+	}
+}
+
+func TestAzureOpenAIGeneratorAPIError(t *testing.T) {
+	srv, _ := serveFake(t, http.StatusNotFound, `{"error": {"code": "DeploymentNotFound"}}`)
+	g, err := NewAzureOpenAIGenerator("gpt-4o", "missing", srv.URL, "", "", "azure-test-key", types.ProviderOptions{})
+	if err != nil {
+		t.Fatalf("NewAzureOpenAIGenerator: %v", err)
+	}
+	if _, err := g.Complete(context.Background(), "prompt", types.GenerationOptions{}); err == nil || err.Error() != `OpenAI API error 404: {"error": {"code": "DeploymentNotFound"}}` {
+		t.Errorf("Complete error = %v", err)
+	}
+}
+
+func TestNewAzureOpenAIGeneratorNeedsEndpoint(t *testing.T) {
+	if _, err := NewAzureOpenAIGenerator("gpt-4o", "prod", "", "", "", "azure-test-key", types.ProviderOptions{}); err == nil {
+		t.Error("NewAzureOpenAIGenerator without base_url succeeded")
+	}
+}
+
+func TestOpenAIGeneratorSendsBearerToken(t *testing.T) {
+	srv, fake := serveFake(t, http.StatusOK, openAIAnswer)
+	g, err := NewOpenAIGenerator("gpt-4o", "", "openai-test-key", types.ProviderOptions{})
+	if err != nil {
+		t.Fatalf("NewOpenAIGenerator: %v", err)
+	}
+	g.endpoint = srv.URL + "/v1/chat/completions"
+
+	if _, err := g.Complete(context.Background(), "prompt", types.GenerationOptions{}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	req := fake.request(t)
+	if got := req.Header.Get("Authorization"); got != "Bearer openai-test-key" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if got := req.Header.Get("api-key"); got != "" {
+		t.Errorf("api-key = %q, want it only for Azure", got)
+	}
+}
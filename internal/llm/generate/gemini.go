@@ -0,0 +1,335 @@
+package generate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/llm/retry"
+	"github.com/matthieukhl/latentia/internal/tracing"
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// DefaultGeminiURL is the Gemini API of Google AI Studio keys
+const DefaultGeminiURL = "https://generativelanguage.googleapis.com"
+
+// GeminiGenerator talks to the v1beta generateContent endpoint of the
+// Gemini API
+type GeminiGenerator struct {
+	apiKey  string
+	baseURL string
+	model   string
+	client  *http.Client
+	options types.ProviderOptions
+}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiRequest struct {
+	Contents          []geminiContent        `json:"contents"`
+	SystemInstruction *geminiContent         `json:"systemInstruction,omitempty"`
+	GenerationConfig  geminiGenerationConfig `json:"generationConfig"`
+}
+
+type geminiGenerationConfig struct {
+	MaxOutputTokens  int      `json:"maxOutputTokens,omitempty"`
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             float64  `json:"topP,omitempty"`
+	StopSequences    []string `json:"stopSequences,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	ResponseMimeType string   `json:"responseMimeType,omitempty"`
+}
+
+type geminiSafetyRating struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability"`
+	Blocked     bool   `json:"blocked"`
+}
+
+// geminiResponse is a generateContent response, or one event of a
+// streamGenerateContent response. A blocked prompt has no candidates and
+// promptFeedback.blockReason set.
+type geminiResponse struct {
+	Candidates []struct {
+		Content       geminiContent        `json:"content"`
+		FinishReason  string               `json:"finishReason"`
+		SafetyRatings []geminiSafetyRating `json:"safetyRatings"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason   string               `json:"blockReason"`
+		SafetyRatings []geminiSafetyRating `json:"safetyRatings"`
+	} `json:"promptFeedback"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+// geminiBlockedReasons are the finish reasons of a response withheld by
+// Gemini's filters rather than completed
+var geminiBlockedReasons = map[string]bool{
+	"SAFETY":             true,
+	"RECITATION":         true,
+	"BLOCKLIST":          true,
+	"PROHIBITED_CONTENT": true,
+	"SPII":               true,
+	"IMAGE_SAFETY":       true,
+}
+
+func NewGeminiGenerator(model string, apiKeyEnv string, directAPIKey string, baseURL string, options types.ProviderOptions) (*GeminiGenerator, error) {
+	apiKey := directAPIKey
+	if apiKey == "" && apiKeyEnv != "" {
+		apiKey = os.Getenv(apiKeyEnv)
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("API key not found in config or environment variable %s", apiKeyEnv)
+	}
+	if baseURL == "" {
+		baseURL = DefaultGeminiURL
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 60 * time.Second
+	}
+
+	return &GeminiGenerator{
+		apiKey:  apiKey,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		model:   model,
+		client:  &http.Client{Timeout: options.Timeout},
+		options: options,
+	}, nil
+}
+
+func (g *GeminiGenerator) Complete(ctx context.Context, prompt string, opts types.GenerationOptions) (text string, err error) {
+	ctx, span := tracing.StartKind(ctx, "chat "+g.model, tracing.KindClient,
+		tracing.String("gen_ai.system", "gemini"), tracing.String("gen_ai.request.model", g.model))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	ctx, cancel := completionContext(ctx, opts)
+	defer cancel()
+
+	resp, err := g.send(ctx, "generateContent", g.request(prompt, opts))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var response geminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	types.RecordUsage(ctx, response.UsageMetadata.PromptTokenCount, response.UsageMetadata.CandidatesTokenCount)
+	span.SetAttributes(
+		tracing.Int("gen_ai.usage.input_tokens", int64(response.UsageMetadata.PromptTokenCount)),
+		tracing.Int("gen_ai.usage.output_tokens", int64(response.UsageMetadata.CandidatesTokenCount)))
+
+	text, _, err = response.text()
+	if err != nil {
+		return "", err
+	}
+	if text == "" {
+		return "", fmt.Errorf("no content in response")
+	}
+	return text, nil
+}
+
+// CompleteStream streams the completion of prompt, calling onChunk with
+// each piece of text as it arrives
+func (g *GeminiGenerator) CompleteStream(ctx context.Context, prompt string, opts types.GenerationOptions, onChunk func(chunk string) error) (err error) {
+	ctx, span := tracing.StartKind(ctx, "chat "+g.model, tracing.KindClient,
+		tracing.String("gen_ai.system", "gemini"), tracing.String("gen_ai.request.model", g.model))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	ctx, cancel := completionContext(ctx, opts)
+	defer cancel()
+
+	resp, err := g.send(ctx, "streamGenerateContent", g.request(prompt, opts))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Every event repeats the usage so far; the last one has the totals
+	var inputTokens, outputTokens int
+	done := false
+	err = readSSE(resp.Body, func(_, data string) error {
+		var response geminiResponse
+		if err := json.Unmarshal([]byte(data), &response); err != nil {
+			return fmt.Errorf("failed to decode stream event: %w", err)
+		}
+		if response.Error != nil {
+			return fmt.Errorf("Gemini stream error (%s): %s", response.Error.Status, response.Error.Message)
+		}
+		inputTokens = response.UsageMetadata.PromptTokenCount
+		outputTokens = response.UsageMetadata.CandidatesTokenCount
+
+		text, finished, err := response.text()
+		if err != nil {
+			return err
+		}
+		if text != "" {
+			if err := onChunk(text); err != nil {
+				return err
+			}
+		}
+		if finished {
+			done = true
+			return errStreamDone
+		}
+		return nil
+	})
+
+	types.RecordUsage(ctx, inputTokens, outputTokens)
+	span.SetAttributes(
+		tracing.Int("gen_ai.usage.input_tokens", int64(inputTokens)),
+		tracing.Int("gen_ai.usage.output_tokens", int64(outputTokens)))
+	if err != nil {
+		return err
+	}
+	if !done {
+		return errStreamIncomplete
+	}
+	return nil
+}
+
+// request builds the generateContent request for prompt and opts
+func (g *GeminiGenerator) request(prompt string, opts types.GenerationOptions) geminiRequest {
+	req := geminiRequest{
+		Contents:          []geminiContent{{Role: "user", Parts: []geminiPart{{Text: prompt}}}},
+		SystemInstruction: &geminiContent{Parts: []geminiPart{{Text: systemPrompt(opts)}}},
+		GenerationConfig: geminiGenerationConfig{
+			MaxOutputTokens: 4000,
+			Temperature:     g.options.Temperature,
+			TopP:            opts.TopP,
+			StopSequences:   opts.Stop,
+			Seed:            opts.Seed,
+		},
+	}
+	if g.options.MaxTokens > 0 {
+		req.GenerationConfig.MaxOutputTokens = g.options.MaxTokens
+	}
+	if opts.MaxTokens > 0 {
+		req.GenerationConfig.MaxOutputTokens = opts.MaxTokens
+	}
+	if opts.Temperature != nil {
+		req.GenerationConfig.Temperature = opts.Temperature
+	}
+	// Gemini's response schemas are a subset of JSON Schema, so only the
+	// MIME type is set; the prompt describes the object
+	if opts.JSON {
+		req.GenerationConfig.ResponseMimeType = "application/json"
+	}
+	return req
+}
+
+// send posts req to method of the model, retrying as configured, and
+// returns the response once the API accepted it
+func (g *GeminiGenerator) send(ctx context.Context, method string, req geminiRequest) (*http.Response, error) {
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/v1beta/models/%s:%s", g.baseURL, url.PathEscape(g.model), method)
+	if method == "streamGenerateContent" {
+		endpoint += "?alt=sse"
+	}
+	resp, err := retry.Do(ctx, g.client, g.options.MaxRetries, func() (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("x-goog-api-key", g.apiKey)
+		return httpReq, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("Gemini API error %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// text returns the text of the first candidate and whether it finished. A
+// blocked prompt or a candidate stopped by Gemini's filters is an error
+// naming the reason and the categories that triggered it, since its text is
+// empty or cut short.
+func (r *geminiResponse) text() (string, bool, error) {
+	if reason := r.PromptFeedback.BlockReason; reason != "" {
+		return "", true, fmt.Errorf("Gemini blocked the prompt (%s%s)", reason, blockedCategories(r.PromptFeedback.SafetyRatings))
+	}
+	if len(r.Candidates) == 0 {
+		return "", false, nil
+	}
+
+	candidate := r.Candidates[0]
+	if geminiBlockedReasons[candidate.FinishReason] {
+		return "", true, fmt.Errorf("Gemini withheld the response (finish reason %s%s)", candidate.FinishReason, blockedCategories(candidate.SafetyRatings))
+	}
+	var b strings.Builder
+	for _, part := range candidate.Content.Parts {
+		b.WriteString(part.Text)
+	}
+	return b.String(), candidate.FinishReason != "", nil
+}
+
+// blockedCategories lists the safety categories that blocked a response,
+// as a suffix for an error message
+func blockedCategories(ratings []geminiSafetyRating) string {
+	var categories []string
+	for _, rating := range ratings {
+		if rating.Blocked {
+			categories = append(categories, strings.TrimPrefix(rating.Category, "HARM_CATEGORY_"))
+		}
+	}
+	if len(categories) == 0 {
+		return ""
+	}
+	return ": " + strings.Join(categories, ", ")
+}
+
+func (g *GeminiGenerator) Model() string {
+	return g.model
+}
+
+// SupportsJSON is true: the "json" option sets responseMimeType to
+// application/json
+func (g *GeminiGenerator) SupportsJSON() bool {
+	return true
+}
+
+// Compile-time interface check
+var (
+	_ types.JSONGenerator      = (*GeminiGenerator)(nil)
+	_ types.StreamingGenerator = (*GeminiGenerator)(nil)
+)
@@ -0,0 +1,337 @@
+package generate
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// capturedRequest is what a fake provider received
+type capturedRequest struct {
+	Method string
+	Path   string
+	Query  string
+	Header http.Header
+	Body   map[string]any
+}
+
+// fakeProvider answers every request with status and body, after delay,
+// recording the last request it received
+type fakeProvider struct {
+	status      int
+	contentType string
+	body        string
+	delay       time.Duration
+
+	mu   sync.Mutex
+	last *capturedRequest
+}
+
+func (f *fakeProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	raw, _ := io.ReadAll(r.Body)
+	req := &capturedRequest{Method: r.Method, Path: r.URL.EscapedPath(), Query: r.URL.RawQuery, Header: r.Header.Clone()}
+	if err := json.Unmarshal(raw, &req.Body); err != nil {
+		http.Error(w, "request body is not a JSON object", http.StatusTeapot)
+		return
+	}
+	f.mu.Lock()
+	f.last = req
+	f.mu.Unlock()
+
+	select {
+	case <-time.After(f.delay):
+	case <-r.Context().Done():
+		return
+	}
+	contentType := f.contentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	status := f.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	io.WriteString(w, f.body)
+}
+
+// request returns the last request, failing the test if there was none
+func (f *fakeProvider) request(t *testing.T) *capturedRequest {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.last == nil {
+		t.Fatal("the provider got no request")
+	}
+	return f.last
+}
+
+// serveFake starts a fake provider answering body with status
+func serveFake(t *testing.T, status int, body string) (*httptest.Server, *fakeProvider) {
+	t.Helper()
+	fake := &fakeProvider{status: status, body: body}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return srv, fake
+}
+
+// field returns the value at a dotted path of a decoded JSON body, with
+// list elements addressed by index
+func field(body map[string]any, path string) any {
+	var value any = body
+	for _, key := range strings.Split(path, ".") {
+		switch node := value.(type) {
+		case map[string]any:
+			value = node[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i >= len(node) {
+				return nil
+			}
+			value = node[i]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+func newTestGemini(t *testing.T, baseURL string, options types.ProviderOptions) *GeminiGenerator {
+	t.Helper()
+	g, err := NewGeminiGenerator("gemini-1.5-pro", "", "gemini-test-key", baseURL+"/", options)
+	if err != nil {
+		t.Fatalf("NewGeminiGenerator: %v", err)
+	}
+	return g
+}
+
+func TestGeminiCompleteRequest(t *testing.T) {
+	srv, fake := serveFake(t, http.StatusOK, `{
+		"candidates": [{"content": {"role": "model", "parts": [{"text": "SELECT 1"}, {"text": " FROM dual"}]}, "finishReason": "STOP"}],
+		"usageMetadata": {"promptTokenCount": 12, "candidatesTokenCount": 5}
+	}`)
+	g := newTestGemini(t, srv.URL, types.ProviderOptions{MaxTokens: 2000})
+
+	ctx, usage := types.WithUsage(context.Background())
+	temperature := 0.2
+	text, err := g.Complete(ctx, "optimize SELECT 1", types.GenerationOptions{Temperature: &temperature, System: "Be brief."})
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if text != "SELECT 1 FROM dual" {
+		t.Errorf("text = %q, want the parts joined", text)
+	}
+	if usage.PromptTokens != 12 || usage.CompletionTokens != 5 {
+		t.Errorf("usage = %+v, want 12 and 5 tokens", *usage)
+	}
+
+	req := fake.request(t)
+	if req.Method != http.MethodPost || req.Path != "/v1beta/models/gemini-1.5-pro:generateContent" || req.Query != "" {
+		t.Errorf("request = %s %s?%s", req.Method, req.Path, req.Query)
+	}
+	if got := req.Header.Get("x-goog-api-key"); got != "gemini-test-key" {
+		t.Errorf("x-goog-api-key = %q", got)
+	}
+	if got := req.Header.Get("Authorization"); got != "" {
+		t.Errorf("Authorization = %q, want the key only in x-goog-api-key", got)
+	}
+	if strings.Contains(req.Query, "key=") {
+		t.Errorf("query %q carries the key", req.Query)
+	}
+	for path, want := range map[string]any{
+		"contents.0.role":                   "user",
+		"contents.0.parts.0.text":           "optimize SELECT 1",
+		"systemInstruction.parts.0.text":    "Be brief.",
+		"generationConfig.maxOutputTokens":  float64(2000),
+		"generationConfig.temperature":      0.2,
+		"generationConfig.responseMimeType": nil,
+		"generationConfig.stopSequences":    nil,
+		"generationConfig.topP":             nil,
+		"generationConfig.seed":             nil,
+		"systemInstruction.role":            nil,
+	} {
+		if got := field(req.Body, path); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestGeminiCompleteDefaults(t *testing.T) {
+	srv, fake := serveFake(t, http.StatusOK, `{"candidates": [{"content": {"parts": [{"text": "ok"}]}, "finishReason": "STOP"}]}`)
+	g := newTestGemini(t, srv.URL, types.ProviderOptions{})
+
+	if _, err := g.Complete(context.Background(), "prompt", types.GenerationOptions{}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	body := fake.request(t).Body
+	if got := field(body, "generationConfig.maxOutputTokens"); got != float64(4000) {
+		t.Errorf("maxOutputTokens = %v, want the 4000 default", got)
+	}
+	if got := field(body, "generationConfig.temperature"); got != nil {
+		t.Errorf("temperature = %v, want Gemini's default", got)
+	}
+	if got := field(body, "systemInstruction.parts.0.text"); got != defaultSystemPrompt {
+		t.Errorf("system instruction = %v, want the default", got)
+	}
+}
+
+func TestGeminiCompleteWithheld(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     string
+	}{
+		{
+			"finish reason SAFETY",
+			`{"candidates": [{"content": {"parts": [{"text": "partial"}]}, "finishReason": "SAFETY",
+				"safetyRatings": [
+					{"category": "HARM_CATEGORY_HARASSMENT", "probability": "HIGH", "blocked": true},
+					{"category": "HARM_CATEGORY_HATE_SPEECH", "probability": "NEGLIGIBLE"},
+					{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "probability": "MEDIUM", "blocked": true}
+				]}]}`,
+			"Gemini withheld the response (finish reason SAFETY: HARASSMENT, DANGEROUS_CONTENT)",
+		},
+		{
+			"finish reason RECITATION without ratings",
+			`{"candidates": [{"content": {"parts": []}, "finishReason": "RECITATION"}]}`,
+			"Gemini withheld the response (finish reason RECITATION)",
+		},
+		{
+			"blocked prompt",
+			`{"promptFeedback": {"blockReason": "SAFETY",
+				"safetyRatings": [{"category": "HARM_CATEGORY_SEXUALLY_EXPLICIT", "probability": "HIGH", "blocked": true}]}}`,
+			"Gemini blocked the prompt (SAFETY: SEXUALLY_EXPLICIT)",
+		},
+		{
+			"no candidates",
+			`{"candidates": [], "usageMetadata": {"promptTokenCount": 3}}`,
+			"no content in response",
+		},
+		{
+			"empty text",
+			`{"candidates": [{"content": {"parts": [{"text": ""}]}, "finishReason": "STOP"}]}`,
+			"no content in response",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := serveFake(t, http.StatusOK, tt.response)
+			text, err := newTestGemini(t, srv.URL, types.ProviderOptions{}).Complete(context.Background(), "prompt", types.GenerationOptions{})
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("Complete = %q, %v; want error %q", text, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeminiCompleteAPIError(t *testing.T) {
+	srv, _ := serveFake(t, http.StatusBadRequest, `{"error": {"code": 400, "message": "API key not valid.", "status": "INVALID_ARGUMENT"}}`)
+	_, err := newTestGemini(t, srv.URL, types.ProviderOptions{}).Complete(context.Background(), "prompt", types.GenerationOptions{})
+	if err == nil || !strings.HasPrefix(err.Error(), "Gemini API error 400: ") || !strings.Contains(err.Error(), "API key not valid.") {
+		t.Errorf("error = %v, want the status and the API's message", err)
+	}
+}
+
+func TestGeminiCompleteJSON(t *testing.T) {
+	srv, fake := serveFake(t, http.StatusOK, `{"candidates": [{"content": {"parts": [{"text": "{\"proposed_sql\": \"SELECT 1\"}"}]}, "finishReason": "STOP"}]}`)
+	g := newTestGemini(t, srv.URL, types.ProviderOptions{})
+	if !g.SupportsJSON() {
+		t.Fatal("SupportsJSON = false")
+	}
+
+	text, err := g.Complete(context.Background(), "prompt", types.GenerationOptions{JSON: true, JSONSchema: map[string]any{"type": "object"}})
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if text != `{"proposed_sql": "SELECT 1"}` {
+		t.Errorf("text = %q", text)
+	}
+	body := fake.request(t).Body
+	if got := field(body, "generationConfig.responseMimeType"); got != "application/json" {
+		t.Errorf("responseMimeType = %v", got)
+	}
+	// The schema is left out, Gemini only takes a subset of JSON Schema
+	if got := field(body, "generationConfig.responseSchema"); got != nil {
+		t.Errorf("responseSchema = %v", got)
+	}
+}
+
+func TestGeminiCompleteStream(t *testing.T) {
+	fake := &fakeProvider{contentType: "text/event-stream", body: "" +
+		`data: {"candidates": [{"content": {"parts": [{"text": "SELECT "}]}}], "usageMetadata": {"promptTokenCount": 9, "candidatesTokenCount": 1}}` + "\n\n" +
+		`data: {"candidates": [{"content": {"parts": [{"text": "1"}]}, "finishReason": "STOP"}], "usageMetadata": {"promptTokenCount": 9, "candidatesTokenCount": 2}}` + "\n\n"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	g := newTestGemini(t, srv.URL, types.ProviderOptions{})
+
+	ctx, usage := types.WithUsage(context.Background())
+	var chunks []string
+	err := g.CompleteStream(ctx, "prompt", types.GenerationOptions{}, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("CompleteStream: %v", err)
+	}
+	if !reflect.DeepEqual(chunks, []string{"SELECT ", "1"}) {
+		t.Errorf("chunks = %q", chunks)
+	}
+	if usage.PromptTokens != 9 || usage.CompletionTokens != 2 {
+		t.Errorf("usage = %+v, want the totals of the last event", *usage)
+	}
+	req := fake.request(t)
+	if req.Path != "/v1beta/models/gemini-1.5-pro:streamGenerateContent" || req.Query != "alt=sse" {
+		t.Errorf("request = %s?%s", req.Path, req.Query)
+	}
+}
+
+func TestGeminiCompleteStreamWithheld(t *testing.T) {
+	fake := &fakeProvider{contentType: "text/event-stream", body: "" +
+		`data: {"candidates": [{"content": {"parts": [{"text": "SELECT "}]}}]}` + "\n\n" +
+		`data: {"candidates": [{"finishReason": "SAFETY", "safetyRatings": [{"category": "HARM_CATEGORY_HARASSMENT", "blocked": true}]}]}` + "\n\n"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	err := newTestGemini(t, srv.URL, types.ProviderOptions{}).CompleteStream(context.Background(), "prompt", types.GenerationOptions{},
+		func(string) error { return nil })
+	if err == nil || err.Error() != "Gemini withheld the response (finish reason SAFETY: HARASSMENT)" {
+		t.Errorf("CompleteStream error = %v", err)
+	}
+}
+
+func TestGeminiCompleteStreamIncomplete(t *testing.T) {
+	fake := &fakeProvider{contentType: "text/event-stream", body: `data: {"candidates": [{"content": {"parts": [{"text": "SELECT "}]}}]}` + "\n\n"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	err := newTestGemini(t, srv.URL, types.ProviderOptions{}).CompleteStream(context.Background(), "prompt", types.GenerationOptions{},
+		func(string) error { return nil })
+	if err != errStreamIncomplete {
+		t.Errorf("CompleteStream error = %v, want %v", err, errStreamIncomplete)
+	}
+}
+
+func TestNewGeminiGeneratorKey(t *testing.T) {
+	t.Setenv("LATENTIA_TEST_GEMINI_KEY", "from-env")
+	g, err := NewGeminiGenerator("gemini-1.5-flash", "LATENTIA_TEST_GEMINI_KEY", "", "", types.ProviderOptions{})
+	if err != nil {
+		t.Fatalf("NewGeminiGenerator: %v", err)
+	}
+	if g.apiKey != "from-env" || g.baseURL != DefaultGeminiURL {
+		t.Errorf("key %q, base URL %q", g.apiKey, g.baseURL)
+	}
+	if _, err := NewGeminiGenerator("gemini-1.5-flash", "LATENTIA_TEST_UNSET_KEY", "", "", types.ProviderOptions{}); err == nil {
+		t.Error("NewGeminiGenerator without a key succeeded")
+	}
+}
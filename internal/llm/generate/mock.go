@@ -2,47 +2,181 @@ package generate
 
 import (
 	"context"
+	"errors"
+	"math/rand/v2"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/matthieukhl/latentia/internal/tracing"
 	"github.com/matthieukhl/latentia/internal/types"
 )
 
+// MockFailure is an outcome MockGenerator can simulate for a call
+type MockFailure string
+
+const (
+	MockOK          MockFailure = "ok"         // a normal response
+	MockRateLimited MockFailure = "rate_limit" // ErrMockRateLimited, as a provider answering 429
+	MockMalformed   MockFailure = "malformed"  // a response without PROPOSED_SQL
+	MockTruncated   MockFailure = "truncated"  // a response cut off midway, as at max_tokens
+	MockHang        MockFailure = "hang"       // no response until the context is done
+)
+
+// ErrMockRateLimited is the error of a MockRateLimited call
+var ErrMockRateLimited = errors.New("mock API error 429: rate limit exceeded")
+
+// MockOptions make a MockGenerator slow or failing, to exercise timeouts,
+// retries and the handling of bad responses without a provider. The zero
+// value answers every call at once.
+type MockOptions struct {
+	// Latency is the simulated API delay, which cancellation cuts short
+	Latency time.Duration
+
+	// Script is the outcome of the first calls, in order; the calls after
+	// it fail with Failure at FailureRate, a share between 0 and 1
+	Script      []MockFailure
+	FailureRate float64
+	Failure     MockFailure
+}
+
 type MockGenerator struct {
-	model string
+	model   string
+	options MockOptions
+
+	mu        sync.Mutex
+	calls     int
+	responses []mockResponse
+}
+
+// mockResponse answers the prompts containing match
+type mockResponse struct {
+	match    string
+	response string
+}
+
+func NewMockGenerator(model string, options MockOptions) *MockGenerator {
+	if options.Failure == "" {
+		options.Failure = MockRateLimited
+	}
+	return &MockGenerator{model: model, options: options}
+}
+
+// Respond makes the calls whose prompt contains match, an exact prompt
+// included, answer response instead of a canned one; the first match added
+// wins. Failures still apply.
+func (g *MockGenerator) Respond(match, response string) *MockGenerator {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.responses = append(g.responses, mockResponse{match: match, response: response})
+	return g
+}
+
+// Calls returns the number of calls made so far, retries included
+func (g *MockGenerator) Calls() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.calls
+}
+
+// outcome counts a call and picks what becomes of it
+func (g *MockGenerator) outcome() MockFailure {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.calls++
+	if g.calls <= len(g.options.Script) {
+		return g.options.Script[g.calls-1]
+	}
+	if g.options.FailureRate > 0 && rand.Float64() < g.options.FailureRate {
+		return g.options.Failure
+	}
+	return MockOK
 }
 
-func NewMockGenerator(model string) *MockGenerator {
-	return &MockGenerator{model: model}
+// preloaded returns the response added with Respond for prompt
+func (g *MockGenerator) preloaded(prompt string) (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, r := range g.responses {
+		if strings.Contains(prompt, r.match) {
+			return r.response, true
+		}
+	}
+	return "", false
 }
 
-func (g *MockGenerator) Complete(ctx context.Context, prompt string, opts map[string]any) (string, error) {
-	// Simulate API delay
-	time.Sleep(500 * time.Millisecond)
+func (g *MockGenerator) Complete(ctx context.Context, prompt string, opts types.GenerationOptions) (string, error) {
+	_, span := tracing.StartKind(ctx, "chat "+g.Model(), tracing.KindClient,
+		tracing.String("gen_ai.system", "mock"), tracing.String("gen_ai.request.model", g.Model()))
+	defer span.End()
+	ctx, cancel := completionContext(ctx, opts)
+	defer cancel()
+	
+	outcome := g.outcome()
+	span.SetAttributes(tracing.String("mock.outcome", string(outcome)))
+	
+	// Simulate API delay, which cancellation and opts.Timeout cut short
+	var delay <-chan time.Time
+	if outcome != MockHang {
+		delay = time.After(g.options.Latency)
+	}
+	select {
+	case <-delay:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if outcome == MockRateLimited {
+		span.RecordError(ErrMockRateLimited)
+		return "", ErrMockRateLimited
+	}
 	
-	// Generate contextual response based on the prompt content
-	prompt = strings.ToLower(prompt)
+	response, ok := g.preloaded(prompt)
+	if !ok {
+		response = g.respond(strings.ToLower(prompt))
+	}
+	switch outcome {
+	case MockMalformed:
+		response = mockMalformedResponse
+	case MockTruncated:
+		response = response[:len(response)/3]
+	}
 	
+	// Report usage like a real provider, at ~4 characters per token
+	promptTokens, completionTokens := (len(prompt)+3)/4, (len(response)+3)/4
+	types.RecordUsage(ctx, promptTokens, completionTokens)
+	span.SetAttributes(
+		tracing.Int("gen_ai.usage.input_tokens", int64(promptTokens)),
+		tracing.Int("gen_ai.usage.output_tokens", int64(completionTokens)))
+	return response, nil
+}
+
+// respond generates a contextual response based on the prompt content
+func (g *MockGenerator) respond(prompt string) string {
 	if strings.Contains(prompt, "join") {
-		return g.generateJoinOptimization(prompt), nil
+		return g.generateJoinOptimization(prompt)
 	}
 	
 	if strings.Contains(prompt, "select *") {
-		return g.generateSelectOptimization(prompt), nil
+		return g.generateSelectOptimization(prompt)
 	}
 	
 	if strings.Contains(prompt, "group by") || strings.Contains(prompt, "order by") {
-		return g.generateAggregationOptimization(prompt), nil
+		return g.generateAggregationOptimization(prompt)
 	}
 	
 	if strings.Contains(prompt, "sleep") {
-		return g.generateSleepOptimization(prompt), nil
+		return g.generateSleepOptimization(prompt)
 	}
 	
 	// Default optimization response
-	return g.generateGenericOptimization(prompt), nil
+	return g.generateGenericOptimization(prompt)
 }
 
+// mockMalformedResponse is the answer of a MockMalformed call, prose
+// without the PROPOSED_SQL section
+const mockMalformedResponse = `This query could likely be made faster with an index on the filtered
+columns and by selecting only the columns it needs.`
+
 func (g *MockGenerator) Model() string {
 	return g.model + "-mock"
 }
@@ -0,0 +1,105 @@
+package generate
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+func TestMockScriptThenFailureRate(t *testing.T) {
+	const answer = "PROPOSED_SQL:\nSELECT id FROM orders\n\nRATIONALE:\nfewer columns"
+	g := NewMockGenerator("test", MockOptions{
+		Script:      []MockFailure{MockOK, MockMalformed, MockRateLimited},
+		FailureRate: 1,
+		Failure:     MockTruncated,
+	}).Respond("orders", answer)
+	tests := []struct {
+		outcome string
+		check   func(response string, err error) bool
+	}{
+		{"ok", func(r string, err error) bool { return err == nil && r == answer }},
+		{"malformed", func(r string, err error) bool { return err == nil && r == mockMalformedResponse }},
+		{"rate_limit", func(r string, err error) bool { return errors.Is(err, ErrMockRateLimited) && r == "" }},
+		{"truncated past the script", func(r string, err error) bool { return err == nil && r == answer[:len(answer)/3] }},
+	}
+	for _, tt := range tests {
+		response, err := g.Complete(context.Background(), "SELECT * FROM orders", types.GenerationOptions{})
+		if !tt.check(response, err) {
+			t.Errorf("%s call = %q, %v", tt.outcome, response, err)
+		}
+	}
+	if g.Calls() != len(tests) {
+		t.Errorf("Calls = %d, want %d", g.Calls(), len(tests))
+	}
+}
+
+func TestMockRespondFirstMatchWins(t *testing.T) {
+	g := NewMockGenerator("test", MockOptions{}).Respond("orders", "first").Respond("FROM orders", "second")
+	if got, _ := g.Complete(context.Background(), "SELECT * FROM orders", types.GenerationOptions{}); got != "first" {
+		t.Errorf("response = %q, want the first match", got)
+	}
+	// Other prompts get a canned answer
+	got, _ := g.Complete(context.Background(), "SELECT SLEEP(5)", types.GenerationOptions{})
+	if !strings.Contains(got, "Removed SLEEP()") {
+		t.Errorf("response = %q, want the canned SLEEP optimization", got)
+	}
+}
+
+func TestMockLatencyHonorsContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		options MockOptions
+		ctx     func() (context.Context, context.CancelFunc)
+		opts    types.GenerationOptions
+		want    error
+	}{
+		{"cancelled during latency", MockOptions{Latency: time.Minute}, func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(20*time.Millisecond, cancel)
+			return ctx, cancel
+		}, types.GenerationOptions{}, context.Canceled},
+		{"hang until the deadline", MockOptions{Script: []MockFailure{MockHang}}, func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 20*time.Millisecond)
+		}, types.GenerationOptions{}, context.DeadlineExceeded},
+		{"hang until the request timeout", MockOptions{Script: []MockFailure{MockHang}}, func() (context.Context, context.CancelFunc) {
+			return context.Background(), func() {}
+		}, types.GenerationOptions{Timeout: 20 * time.Millisecond}, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			started := time.Now()
+			if _, err := NewMockGenerator("test", tt.options).Complete(ctx, "SELECT 1", tt.opts); !errors.Is(err, tt.want) {
+				t.Errorf("Complete = %v, want %v", err, tt.want)
+			}
+			if elapsed := time.Since(started); elapsed > time.Second {
+				t.Errorf("Complete returned after %v", elapsed)
+			}
+		})
+	}
+
+	// The latency itself is waited out
+	started := time.Now()
+	if _, err := NewMockGenerator("test", MockOptions{Latency: 30 * time.Millisecond}).Complete(context.Background(), "SELECT 1", types.GenerationOptions{}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed < 30*time.Millisecond {
+		t.Errorf("Complete returned after %v, before its latency", elapsed)
+	}
+}
+
+func TestMockReportsUsage(t *testing.T) {
+	ctx, usage := types.WithUsage(context.Background())
+	g := NewMockGenerator("test", MockOptions{}).Respond("", "12345678")
+	if _, err := g.Complete(ctx, "SELECT 1", types.GenerationOptions{}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if usage.PromptTokens != 2 || usage.CompletionTokens != 2 {
+		t.Errorf("usage = %d/%d, want 2/2 at four characters a token", usage.PromptTokens, usage.CompletionTokens)
+	}
+}
@@ -0,0 +1,210 @@
+package generate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/llm/retry"
+	"github.com/matthieukhl/latentia/internal/tracing"
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// DefaultOllamaURL is where a local "ollama serve" listens
+const DefaultOllamaURL = "http://localhost:11434"
+
+// OllamaGenerator talks to an Ollama server's /api/chat endpoint; it needs
+// no API key
+type OllamaGenerator struct {
+	baseURL string
+	model   string
+	client  *http.Client
+	options types.ProviderOptions
+}
+
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Format   string          `json:"format,omitempty"`
+	Options  ollamaOptions   `json:"options"`
+}
+
+type ollamaOptions struct {
+	NumPredict  int      `json:"num_predict,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        float64  `json:"top_p,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+}
+
+// ollamaChunk is one line of the NDJSON stream; the last one has Done set
+// and the token counts
+type ollamaChunk struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	Error           string        `json:"error"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+}
+
+func NewOllamaGenerator(model string, baseURL string, options types.ProviderOptions) *OllamaGenerator {
+	if baseURL == "" {
+		baseURL = DefaultOllamaURL
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 5 * time.Minute
+	}
+
+	return &OllamaGenerator{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		model:   model,
+		client:  &http.Client{Timeout: options.Timeout},
+		options: options,
+	}
+}
+
+func (g *OllamaGenerator) Complete(ctx context.Context, prompt string, opts types.GenerationOptions) (string, error) {
+	var b strings.Builder
+	err := g.CompleteStream(ctx, prompt, opts, func(chunk string) error {
+		b.WriteString(chunk)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// CompleteStream streams the completion of prompt, calling onChunk with
+// each piece of content as it arrives
+func (g *OllamaGenerator) CompleteStream(ctx context.Context, prompt string, opts types.GenerationOptions, onChunk func(chunk string) error) (err error) {
+	ctx, span := tracing.StartKind(ctx, "chat "+g.model, tracing.KindClient,
+		tracing.String("gen_ai.system", "ollama"), tracing.String("gen_ai.request.model", g.model))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	ctx, cancel := completionContext(ctx, opts)
+	defer cancel()
+
+	req := ollamaRequest{
+		Model:  g.model,
+		Stream: true,
+		Options: ollamaOptions{
+			NumPredict:  g.options.MaxTokens,
+			Temperature: g.options.Temperature,
+			TopP:        opts.TopP,
+			Stop:        opts.Stop,
+			Seed:        opts.Seed,
+		},
+	}
+	if opts.MaxTokens > 0 {
+		req.Options.NumPredict = opts.MaxTokens
+	}
+	if opts.Temperature != nil {
+		req.Options.Temperature = opts.Temperature
+	}
+	if opts.JSON {
+		req.Format = "json"
+	}
+
+	req.Messages = []ollamaMessage{
+		{Role: "system", Content: systemPrompt(opts)},
+		{Role: "user", Content: prompt},
+	}
+
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := retry.Do(ctx, g.client, g.options.MaxRetries, func() (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", g.baseURL+"/api/chat", bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		httpReq.Header.Set("Content-Type", "application/json")
+		return httpReq, nil
+	})
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("no Ollama server at %s (is 'ollama serve' running?): %w", g.baseURL, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("Ollama API error %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var last ollamaChunk
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk ollamaChunk
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return fmt.Errorf("failed to decode response chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return fmt.Errorf("Ollama error: %s", chunk.Error)
+		}
+		last = chunk
+		if chunk.Message.Content != "" {
+			if err := onChunk(chunk.Message.Content); err != nil {
+				return err
+			}
+		}
+		if chunk.Done {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if !last.Done {
+		return errStreamIncomplete
+	}
+
+	types.RecordUsage(ctx, last.PromptEvalCount, last.EvalCount)
+	span.SetAttributes(
+		tracing.Int("gen_ai.usage.input_tokens", int64(last.PromptEvalCount)),
+		tracing.Int("gen_ai.usage.output_tokens", int64(last.EvalCount)))
+
+	return nil
+}
+
+func (g *OllamaGenerator) Model() string {
+	return g.model
+}
+
+// SupportsJSON is true: the "json" option sets format to json
+func (g *OllamaGenerator) SupportsJSON() bool {
+	return true
+}
+
+// Compile-time interface check
+var (
+	_ types.JSONGenerator      = (*OllamaGenerator)(nil)
+	_ types.StreamingGenerator = (*OllamaGenerator)(nil)
+)
@@ -10,15 +10,26 @@ import (
 	"os"
 	"time"
 
+	"github.com/matthieukhl/latentia/internal/llm/retry"
+	"github.com/matthieukhl/latentia/internal/tracing"
 	"github.com/matthieukhl/latentia/internal/types"
 )
 
 type OpenAIGenerator struct {
-	apiKey string
-	model  string
-	client *http.Client
+	apiKey  string
+	model   string
+	client  *http.Client
+	options types.ProviderOptions
+
+	// endpoint is the chat completions URL; azure sends the key in the
+	// api-key header instead of as a bearer token
+	endpoint string
+	azure    bool
 }
 
+// openAIChatURL is the chat completions endpoint of api.openai.com
+const openAIChatURL = "https://api.openai.com/v1/chat/completions"
+
 type openAIMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
@@ -28,9 +39,45 @@ type openAIRequest struct {
 	Model       string          `json:"model"`
 	Messages    []openAIMessage `json:"messages"`
 	MaxTokens   int             `json:"max_tokens,omitempty"`
-	Temperature float64         `json:"temperature,omitempty"`
+	Temperature float64         `json:"temperature"`
 	TopP        float64         `json:"top_p,omitempty"`
 	Stop        []string        `json:"stop,omitempty"`
+	Seed        *int            `json:"seed,omitempty"`
+
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+
+	Stream        bool                 `json:"stream,omitempty"`
+	StreamOptions *openAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+// openAIStreamOptions with include_usage adds a last chunk carrying the
+// token usage of a streamed completion
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// openAIStreamChunk is the data of one event of a streamed completion. A
+// stream failing after it started sends an error object instead.
+type openAIStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+// openAIResponseFormat with type json_object makes the model answer with
+// a valid JSON object
+type openAIResponseFormat struct {
+	Type string `json:"type"`
 }
 
 type openAIResponse struct {
@@ -53,7 +100,7 @@ type openAIResponse struct {
 	} `json:"usage"`
 }
 
-func NewOpenAIGenerator(model string, apiKeyEnv string, directAPIKey string) (*OpenAIGenerator, error) {
+func NewOpenAIGenerator(model string, apiKeyEnv string, directAPIKey string, options types.ProviderOptions) (*OpenAIGenerator, error) {
 	var apiKey string
 	
 	// First try direct API key from config
@@ -68,35 +115,128 @@ func NewOpenAIGenerator(model string, apiKeyEnv string, directAPIKey string) (*O
 		return nil, fmt.Errorf("API key not found in config or environment variable %s", apiKeyEnv)
 	}
 	
+	if options.Timeout <= 0 {
+		options.Timeout = 60 * time.Second
+	}
+	
 	return &OpenAIGenerator{
-		apiKey: apiKey,
-		model:  model,
-		client: &http.Client{
-			Timeout: 60 * time.Second,
-		},
+		apiKey:   apiKey,
+		model:    model,
+		client:   &http.Client{Timeout: options.Timeout},
+		options:  options,
+		endpoint: openAIChatURL,
 	}, nil
 }
 
-func (g *OpenAIGenerator) Complete(ctx context.Context, prompt string, opts map[string]any) (string, error) {
+func (g *OpenAIGenerator) Complete(ctx context.Context, prompt string, opts types.GenerationOptions) (text string, err error) {
+	ctx, span := tracing.StartKind(ctx, "chat "+g.model, tracing.KindClient,
+		tracing.String("gen_ai.system", "openai"), tracing.String("gen_ai.request.model", g.model))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	ctx, cancel := completionContext(ctx, opts)
+	defer cancel()
+	
+	resp, err := g.send(ctx, g.request(prompt, opts))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	
+	var response openAIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	
+	types.RecordUsage(ctx, response.Usage.PromptTokens, response.Usage.CompletionTokens)
+	span.SetAttributes(
+		tracing.Int("gen_ai.usage.input_tokens", int64(response.Usage.PromptTokens)),
+		tracing.Int("gen_ai.usage.output_tokens", int64(response.Usage.CompletionTokens)))
+	
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("no choices in response")
+	}
+	
+	return response.Choices[0].Message.Content, nil
+}
+
+// CompleteStream streams the completion of prompt, calling onChunk with
+// each piece of content as it arrives
+func (g *OpenAIGenerator) CompleteStream(ctx context.Context, prompt string, opts types.GenerationOptions, onChunk func(chunk string) error) (err error) {
+	ctx, span := tracing.StartKind(ctx, "chat "+g.model, tracing.KindClient,
+		tracing.String("gen_ai.system", "openai"), tracing.String("gen_ai.request.model", g.model))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+	ctx, cancel := completionContext(ctx, opts)
+	defer cancel()
+
+	req := g.request(prompt, opts)
+	req.Stream = true
+	req.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	resp, err := g.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	done := false
+	err = readSSE(resp.Body, func(_, data string) error {
+		if data == "[DONE]" {
+			done = true
+			return errStreamDone
+		}
+		var chunk openAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("failed to decode stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("OpenAI stream error (%s): %s", chunk.Error.Type, chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			types.RecordUsage(ctx, chunk.Usage.PromptTokens, chunk.Usage.CompletionTokens)
+			span.SetAttributes(
+				tracing.Int("gen_ai.usage.input_tokens", int64(chunk.Usage.PromptTokens)),
+				tracing.Int("gen_ai.usage.output_tokens", int64(chunk.Usage.CompletionTokens)))
+		}
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			return onChunk(chunk.Choices[0].Delta.Content)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !done {
+		return errStreamIncomplete
+	}
+	return nil
+}
+
+// request builds the chat completion request for prompt and opts
+func (g *OpenAIGenerator) request(prompt string, opts types.GenerationOptions) openAIRequest {
 	maxTokens := 4000
-	if val, ok := opts["max_tokens"].(int); ok && val > 0 {
-		maxTokens = val
+	if g.options.MaxTokens > 0 {
+		maxTokens = g.options.MaxTokens
+	}
+	if opts.MaxTokens > 0 {
+		maxTokens = opts.MaxTokens
 	}
 	
 	temperature := 0.7
-	if val, ok := opts["temperature"].(float64); ok {
-		temperature = val
+	if g.options.Temperature != nil {
+		temperature = *g.options.Temperature
 	}
-	
-	system := "You are a TiDB performance expert specializing in SQL optimization."
-	if val, ok := opts["system"].(string); ok && val != "" {
-		system = val
+	if opts.Temperature != nil {
+		temperature = *opts.Temperature
 	}
 	
 	messages := []openAIMessage{
 		{
 			Role:    "system",
-			Content: system,
+			Content: systemPrompt(opts),
 		},
 		{
 			Role:    "user",
@@ -109,55 +249,66 @@ func (g *OpenAIGenerator) Complete(ctx context.Context, prompt string, opts map[
 		Messages:    messages,
 		MaxTokens:   maxTokens,
 		Temperature: temperature,
+		TopP:        opts.TopP,
+		Stop:        opts.Stop,
+		Seed:        opts.Seed,
 	}
 	
-	if val, ok := opts["top_p"].(float64); ok {
-		req.TopP = val
-	}
-	
-	if val, ok := opts["stop"].([]string); ok {
-		req.Stop = val
+	// JSON mode requires the word JSON in the messages, which the prompts
+	// asking for it contain
+	if opts.JSON {
+		req.ResponseFormat = &openAIResponseFormat{Type: "json_object"}
 	}
 	
+	return req
+}
+
+// send posts req, retrying as configured, and returns the response once the
+// API accepted it
+func (g *OpenAIGenerator) send(ctx context.Context, req openAIRequest) (*http.Response, error) {
 	jsonData, err := json.Marshal(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 	
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
+	resp, err := retry.Do(ctx, g.client, g.options.MaxRetries, func() (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", g.endpoint, bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		
+		httpReq.Header.Set("Content-Type", "application/json")
+		if g.azure {
+			httpReq.Header.Set("api-key", g.apiKey)
+		} else {
+			httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", g.apiKey))
+		}
+		return httpReq, nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
 	
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", g.apiKey))
-	
-	resp, err := g.client.Do(httpReq)
-	if err != nil {
-		return "", fmt.Errorf("failed to make request: %w", err)
-	}
-	defer resp.Body.Close()
-	
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("OpenAI API error %d: %s", resp.StatusCode, string(body))
+		resp.Body.Close()
+		return nil, fmt.Errorf("OpenAI API error %d: %s", resp.StatusCode, string(body))
 	}
 	
-	var response openAIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
-	}
-	
-	if len(response.Choices) == 0 {
-		return "", fmt.Errorf("no choices in response")
-	}
-	
-	return response.Choices[0].Message.Content, nil
+	return resp, nil
 }
 
 func (g *OpenAIGenerator) Model() string {
 	return g.model
 }
 
+// SupportsJSON is true: the "json" option turns on JSON mode
+func (g *OpenAIGenerator) SupportsJSON() bool {
+	return true
+}
+
 // Compile-time interface check
-var _ types.Generator = (*OpenAIGenerator)(nil)
\ No newline at end of file
+var (
+	_ types.JSONGenerator      = (*OpenAIGenerator)(nil)
+	_ types.StreamingGenerator = (*OpenAIGenerator)(nil)
+)
\ No newline at end of file
@@ -0,0 +1,28 @@
+package generate
+
+import (
+	"context"
+
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// defaultSystemPrompt is the system prompt when GenerationOptions.System is
+// empty
+const defaultSystemPrompt = "You are a TiDB performance expert specializing in SQL optimization."
+
+// systemPrompt returns the system prompt of a completion
+func systemPrompt(opts types.GenerationOptions) string {
+	if opts.System != "" {
+		return opts.System
+	}
+	return defaultSystemPrompt
+}
+
+// completionContext bounds ctx by opts.Timeout, when set, for the whole
+// completion including retries and reading a stream
+func completionContext(ctx context.Context, opts types.GenerationOptions) (context.Context, context.CancelFunc) {
+	if opts.Timeout > 0 {
+		return context.WithTimeout(ctx, opts.Timeout)
+	}
+	return ctx, func() {}
+}
@@ -0,0 +1,257 @@
+package generate
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// redirectTransport sends every request to target, for providers whose
+// endpoint is fixed
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = rt.target.Scheme, rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// optionProviders builds each HTTP generator with options against a fake
+// provider at baseURL, with the canned answer it needs to complete a JSON
+// request
+var optionProviders = []struct {
+	name   string
+	answer string
+	stream bool
+	make   func(t *testing.T, baseURL string, options types.ProviderOptions) types.Generator
+}{
+	{
+		name:   "openai",
+		answer: `{"choices": [{"message": {"content": "{}"}}]}`,
+		make: func(t *testing.T, baseURL string, options types.ProviderOptions) types.Generator {
+			g, err := NewOpenAIGenerator("gpt-4o", "", "key", options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g.endpoint = baseURL + "/v1/chat/completions"
+			return g
+		},
+	},
+	{
+		name:   "azure-openai",
+		answer: `{"choices": [{"message": {"content": "{}"}}]}`,
+		make: func(t *testing.T, baseURL string, options types.ProviderOptions) types.Generator {
+			g, err := NewAzureOpenAIGenerator("gpt-4o", "prod", baseURL, "", "", "key", options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return g
+		},
+	},
+	{
+		name:   "anthropic",
+		answer: `{"content": [{"type": "tool_use", "name": "respond", "input": {}}]}`,
+		make: func(t *testing.T, baseURL string, options types.ProviderOptions) types.Generator {
+			g, err := NewAnthropicGenerator("claude-sonnet-4", "", "key", options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target, _ := url.Parse(baseURL)
+			g.client.Transport = redirectTransport{target: target}
+			return g
+		},
+	},
+	{
+		name:   "gemini",
+		answer: `{"candidates": [{"content": {"parts": [{"text": "{}"}]}, "finishReason": "STOP"}]}`,
+		make: func(t *testing.T, baseURL string, options types.ProviderOptions) types.Generator {
+			return newTestGemini(t, baseURL, options)
+		},
+	},
+	{
+		name:   "ollama",
+		answer: `{"message": {"role": "assistant", "content": "{}"}, "done": true}` + "\n",
+		stream: true,
+		make: func(t *testing.T, baseURL string, options types.ProviderOptions) types.Generator {
+			return NewOllamaGenerator("llama3.1", baseURL, options)
+		},
+	},
+}
+
+// everyOption sets every GenerationOptions field to a non-default value
+func everyOption() types.GenerationOptions {
+	temperature, seed := 0.15, 42
+	return types.GenerationOptions{
+		MaxTokens:   321,
+		Temperature: &temperature,
+		TopP:        0.9,
+		Stop:        []string{"\n\n", "END"},
+		System:      "Answer with SQL only.",
+		Seed:        &seed,
+		Timeout:     5 * time.Second,
+		JSON:        true,
+		JSONSchema:  map[string]any{"type": "object", "required": []any{"proposed_sql"}},
+	}
+}
+
+// optionFields is where each provider puts every option of everyOption in
+// its request body, nil for an option it does not support. Timeout bounds
+// the call rather than travel in the body; TestGenerationOptionsTimeout
+// covers it.
+var optionFields = map[string]map[string]any{
+	"openai": {
+		"max_tokens":           float64(321),
+		"temperature":          0.15,
+		"top_p":                0.9,
+		"stop":                 []any{"\n\n", "END"},
+		"messages.0.role":      "system",
+		"messages.0.content":   "Answer with SQL only.",
+		"seed":                 float64(42),
+		"response_format.type": "json_object",
+	},
+	"anthropic": {
+		"max_tokens":           float64(321),
+		"temperature":          0.15,
+		"top_p":                0.9,
+		"stop_sequences":       []any{"\n\n", "END"},
+		"system":               "Answer with SQL only.",
+		"seed":                 nil,
+		"tools.0.name":         "respond",
+		"tools.0.input_schema": map[string]any{"type": "object", "required": []any{"proposed_sql"}},
+		"tool_choice.type":     "tool",
+		"tool_choice.name":     "respond",
+		"messages.0.role":      "user",
+		"messages.0.content":   "prompt",
+		"response_format":      nil,
+	},
+	"gemini": {
+		"generationConfig.maxOutputTokens":  float64(321),
+		"generationConfig.temperature":      0.15,
+		"generationConfig.topP":             0.9,
+		"generationConfig.stopSequences":    []any{"\n\n", "END"},
+		"systemInstruction.parts.0.text":    "Answer with SQL only.",
+		"generationConfig.seed":             float64(42),
+		"generationConfig.responseMimeType": "application/json",
+	},
+	"ollama": {
+		"options.num_predict": float64(321),
+		"options.temperature": 0.15,
+		"options.top_p":       0.9,
+		"options.stop":        []any{"\n\n", "END"},
+		"messages.0.role":     "system",
+		"messages.0.content":  "Answer with SQL only.",
+		"options.seed":        float64(42),
+		"format":              "json",
+	},
+}
+
+func init() {
+	optionFields["azure-openai"] = optionFields["openai"]
+}
+
+func TestGenerationOptionsInRequestBody(t *testing.T) {
+	for _, p := range optionProviders {
+		t.Run(p.name, func(t *testing.T) {
+			srv, fake := serveFake(t, http.StatusOK, p.answer)
+			g := p.make(t, srv.URL, types.ProviderOptions{})
+			if !types.SupportsJSON(g) {
+				t.Fatalf("%s does not support JSON", p.name)
+			}
+
+			text, err := g.Complete(context.Background(), "prompt", everyOption())
+			if err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			if text != "{}" {
+				t.Errorf("text = %q, want the JSON answer", text)
+			}
+			body := fake.request(t).Body
+			for path, want := range optionFields[p.name] {
+				if got := field(body, path); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %#v, want %#v", path, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerationOptionsDefaults(t *testing.T) {
+	// With no options, no provider sends what only the options set
+	unset := map[string][]string{
+		"openai":       {"top_p", "stop", "seed", "response_format"},
+		"azure-openai": {"top_p", "stop", "seed", "response_format"},
+		"anthropic":    {"temperature", "top_p", "stop_sequences", "tools", "tool_choice"},
+		"gemini":       {"generationConfig.temperature", "generationConfig.topP", "generationConfig.stopSequences", "generationConfig.seed", "generationConfig.responseMimeType"},
+		"ollama":       {"options.num_predict", "options.temperature", "options.top_p", "options.stop", "options.seed", "format"},
+	}
+	for _, p := range optionProviders {
+		t.Run(p.name, func(t *testing.T) {
+			srv, fake := serveFake(t, http.StatusOK, p.answer)
+			if _, err := p.make(t, srv.URL, types.ProviderOptions{}).Complete(context.Background(), "prompt", types.GenerationOptions{}); err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			body := fake.request(t).Body
+			for _, path := range unset[p.name] {
+				if got := field(body, path); got != nil {
+					t.Errorf("%s = %#v, want it left out", path, got)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerationOptionsTimeout(t *testing.T) {
+	for _, p := range optionProviders {
+		t.Run(p.name, func(t *testing.T) {
+			fake := &fakeProvider{body: p.answer, delay: 2 * time.Second}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			started := time.Now()
+			_, err := p.make(t, srv.URL, types.ProviderOptions{}).Complete(context.Background(), "prompt", types.GenerationOptions{Timeout: 50 * time.Millisecond})
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Complete error = %v, want the deadline", err)
+			}
+			if elapsed := time.Since(started); elapsed > time.Second {
+				t.Errorf("Complete took %v, want it cut off by the 50ms timeout", elapsed)
+			}
+		})
+	}
+}
+
+func TestOptionsFromMap(t *testing.T) {
+	temperature, seed := 0.15, 42
+	got := types.OptionsFromMap(map[string]any{
+		"max_tokens":  321,
+		"temperature": temperature,
+		"top_p":       0.9,
+		"stop":        []string{"END"},
+		"system":      "sys",
+		"seed":        seed,
+		"timeout":     time.Second,
+		"json":        true,
+		"json_schema": map[string]any{"type": "object"},
+		"unknown":     "ignored",
+		"top_k":       5,
+	})
+	want := types.GenerationOptions{
+		MaxTokens: 321, Temperature: &temperature, TopP: 0.9, Stop: []string{"END"}, System: "sys",
+		Seed: &seed, Timeout: time.Second, JSON: true, JSONSchema: map[string]any{"type": "object"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("OptionsFromMap =\n%+v\nwant\n%+v", got, want)
+	}
+	// Values of another type are ignored
+	if got := types.OptionsFromMap(map[string]any{"max_tokens": "100", "temperature": 1}); !reflect.DeepEqual(got, types.GenerationOptions{}) {
+		t.Errorf("OptionsFromMap with wrong types = %+v", got)
+	}
+}
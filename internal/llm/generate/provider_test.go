@@ -0,0 +1,144 @@
+package generate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config/configtest"
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// configuredOptions are the ProviderOptions of an llm.generator section
+// tuning every setting
+func configuredOptions(t *testing.T) types.ProviderOptions {
+	t.Helper()
+	cfg := configtest.Load(t, `
+llm:
+  generator:
+    provider: ollama
+    model: llama3.1
+    timeout: 7m
+    max_retries: 1
+    max_tokens: 1234
+    temperature: 0.3
+`)
+	options := cfg.LLM.Generator.Options()
+	temperature := 0.3
+	want := types.ProviderOptions{Timeout: 7 * time.Minute, MaxRetries: 1, MaxTokens: 1234, Temperature: &temperature}
+	if !reflect.DeepEqual(options, want) {
+		t.Fatalf("Options = %+v, want %+v", options, want)
+	}
+	return options
+}
+
+// providerClient returns the HTTP client of a generator built by
+// optionProviders
+func providerClient(t *testing.T, g types.Generator) *http.Client {
+	t.Helper()
+	switch g := g.(type) {
+	case *OpenAIGenerator:
+		return g.client
+	case *AnthropicGenerator:
+		return g.client
+	case *GeminiGenerator:
+		return g.client
+	case *OllamaGenerator:
+		return g.client
+	}
+	t.Fatalf("no client for %T", g)
+	return nil
+}
+
+func TestProviderClientTimeout(t *testing.T) {
+	options := configuredOptions(t)
+	// Local models get longer than hosted APIs by default
+	defaults := map[string]time.Duration{
+		"openai":       60 * time.Second,
+		"azure-openai": 60 * time.Second,
+		"anthropic":    60 * time.Second,
+		"gemini":       60 * time.Second,
+		"ollama":       5 * time.Minute,
+	}
+	for _, p := range optionProviders {
+		t.Run(p.name, func(t *testing.T) {
+			if got := providerClient(t, p.make(t, "http://127.0.0.1:1", options)).Timeout; got != options.Timeout {
+				t.Errorf("configured client timeout = %v, want %v", got, options.Timeout)
+			}
+			if got := providerClient(t, p.make(t, "http://127.0.0.1:1", types.ProviderOptions{})).Timeout; got != defaults[p.name] {
+				t.Errorf("default client timeout = %v, want %v", got, defaults[p.name])
+			}
+		})
+	}
+}
+
+// requestTuning is where each provider puts max_tokens and temperature in
+// its request body
+var requestTuning = map[string][2]string{
+	"openai":       {"max_tokens", "temperature"},
+	"azure-openai": {"max_tokens", "temperature"},
+	"anthropic":    {"max_tokens", "temperature"},
+	"gemini":       {"generationConfig.maxOutputTokens", "generationConfig.temperature"},
+	"ollama":       {"options.num_predict", "options.temperature"},
+}
+
+func TestProviderOptionsInRequestBody(t *testing.T) {
+	options := configuredOptions(t)
+	temperature := 0.9
+	for _, p := range optionProviders {
+		t.Run(p.name, func(t *testing.T) {
+			maxTokens, temperaturePath := requestTuning[p.name][0], requestTuning[p.name][1]
+
+			// The configured values apply to requests that set none
+			srv, fake := serveFake(t, http.StatusOK, p.answer)
+			if _, err := p.make(t, srv.URL, options).Complete(context.Background(), "prompt", types.GenerationOptions{}); err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			body := fake.request(t).Body
+			if got := field(body, maxTokens); got != float64(1234) {
+				t.Errorf("%s = %v, want the configured 1234", maxTokens, got)
+			}
+			if got := field(body, temperaturePath); got != 0.3 {
+				t.Errorf("%s = %v, want the configured 0.3", temperaturePath, got)
+			}
+
+			// and the request's own values override them
+			if _, err := p.make(t, srv.URL, options).Complete(context.Background(), "prompt", types.GenerationOptions{MaxTokens: 50, Temperature: &temperature}); err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			body = fake.request(t).Body
+			if got := field(body, maxTokens); got != float64(50) {
+				t.Errorf("%s = %v, want the request's 50", maxTokens, got)
+			}
+			if got := field(body, temperaturePath); got != 0.9 {
+				t.Errorf("%s = %v, want the request's 0.9", temperaturePath, got)
+			}
+		})
+	}
+}
+
+func TestProviderMaxRetries(t *testing.T) {
+	options := configuredOptions(t)
+	for _, p := range optionProviders {
+		t.Run(p.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				http.Error(w, `{"error": {"message": "overloaded"}}`, http.StatusServiceUnavailable)
+			}))
+			t.Cleanup(srv.Close)
+
+			if _, err := p.make(t, srv.URL, options).Complete(context.Background(), "prompt", types.GenerationOptions{}); err == nil {
+				t.Fatal("Complete succeeded against a failing provider")
+			}
+			// The first attempt and the one configured retry
+			if got := attempts.Load(); got != 2 {
+				t.Errorf("%d attempts, want 2", got)
+			}
+		})
+	}
+}
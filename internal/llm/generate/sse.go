@@ -0,0 +1,69 @@
+package generate
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// errStreamDone is returned by a readSSE callback at the event that ends
+// the stream, such as OpenAI's [DONE]
+var errStreamDone = errors.New("stream done")
+
+// errStreamIncomplete is returned when a stream ends before the event that
+// marks its end, for example because the connection dropped
+var errStreamIncomplete = errors.New("response stream ended before completion")
+
+// readSSE reads server-sent events from r and calls fn with the name and
+// data of each, until r ends or fn returns an error. errStreamDone from fn
+// ends reading without an error. Reads fail once the request's context is
+// done, so a cancelled stream returns promptly without leaving anything
+// running.
+func readSSE(r io.Reader, fn func(event, data string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	var event string
+	var data []string
+	dispatch := func() error {
+		if event == "" && len(data) == 0 {
+			return nil
+		}
+		err := fn(event, strings.Join(data, "\n"))
+		event, data = "", nil
+		return err
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if err := dispatch(); err != nil {
+				if errors.Is(err, errStreamDone) {
+					return nil
+				}
+				return err
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read response stream: %w", err)
+	}
+	if err := dispatch(); err != nil && !errors.Is(err, errStreamDone) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package llm
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/metrics"
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// Priority is the class a generator call is queued in
+type Priority int
+
+const (
+	// PriorityInteractive is for calls someone is waiting on, from the API
+	// or the CLI. It is the default.
+	PriorityInteractive Priority = iota
+	// PriorityBatch is for background work of the worker jobs
+	PriorityBatch
+)
+
+func (p Priority) String() string {
+	if p == PriorityBatch {
+		return "batch"
+	}
+	return "interactive"
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose generator calls are queued as p
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext returns the class set by WithPriority, interactive if
+// none was
+func PriorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return PriorityInteractive
+}
+
+// rateWindow is the period the llm.queue limits apply to
+const rateWindow = time.Minute
+
+// defaultQueue is shared by every generator created by NewGenerator, so the
+// limits hold across the API, the worker and the CLI in one process
+var defaultQueue = newQueue()
+
+// Queue dispatches generator calls within the requests and tokens per minute
+// of llm.queue, read on every dispatch so reloads apply at once. Calls wait
+// in one FIFO per priority class; when both have calls waiting, interactive
+// calls get llm.queue.interactive_share of the dispatches.
+type Queue struct {
+	mu      sync.Mutex
+	waiting [2][]*queued
+	sent    []*dispatch
+	served  [2]int
+	timer   *time.Timer
+	now     func() time.Time
+}
+
+type queued struct {
+	priority Priority
+	tokens   int
+	enqueued time.Time
+	ready    chan *dispatch
+}
+
+// dispatch is a call counted against the limits for rateWindow
+type dispatch struct {
+	at     time.Time
+	tokens int
+}
+
+func newQueue() *Queue {
+	return &Queue{now: time.Now}
+}
+
+// acquire waits until a call of p estimated at tokens can be sent. A call
+// whose ctx is done leaves the queue straight away.
+func (q *Queue) acquire(ctx context.Context, p Priority, tokens int) (*dispatch, error) {
+	w := &queued{priority: p, tokens: tokens, enqueued: q.now(), ready: make(chan *dispatch, 1)}
+
+	q.mu.Lock()
+	q.waiting[p] = append(q.waiting[p], w)
+	q.recordDepthLocked(p)
+	q.dispatchLocked()
+	q.mu.Unlock()
+
+	select {
+	case d := <-w.ready:
+		return d, nil
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.removeLocked(w) {
+		// Dispatched while ctx was being cancelled; give the slot back
+		d := <-w.ready
+		q.releaseLocked(d)
+		q.dispatchLocked()
+	}
+	return nil, ctx.Err()
+}
+
+// release forgets a dispatched call that was not sent after all
+func (q *Queue) release(d *dispatch) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked(d)
+	q.dispatchLocked()
+}
+
+// settle replaces the estimate of a sent call by the tokens it used
+func (q *Queue) settle(d *dispatch, tokens int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	d.tokens = tokens
+	q.dispatchLocked()
+}
+
+func (q *Queue) removeLocked(w *queued) bool {
+	list := q.waiting[w.priority]
+	for i, other := range list {
+		if other == w {
+			q.waiting[w.priority] = append(list[:i], list[i+1:]...)
+			q.recordDepthLocked(w.priority)
+			return true
+		}
+	}
+	return false
+}
+
+func (q *Queue) releaseLocked(d *dispatch) {
+	for i, other := range q.sent {
+		if other == d {
+			q.sent = append(q.sent[:i], q.sent[i+1:]...)
+			return
+		}
+	}
+}
+
+// dispatchLocked sends as many waiting calls as the limits allow and, when
+// some must keep waiting, arms a timer for when the oldest sent call leaves
+// the window
+func (q *Queue) dispatchLocked() {
+	limits := config.Current().LLM.Queue
+	now := q.now()
+
+	for {
+		cutoff := now.Add(-rateWindow)
+		for len(q.sent) > 0 && !q.sent[0].at.After(cutoff) {
+			q.sent = q.sent[1:]
+		}
+
+		p, ok := q.nextLocked(limits.InteractiveShare)
+		if !ok {
+			return
+		}
+		w := q.waiting[p][0]
+		if !q.fitsLocked(limits, w.tokens) {
+			q.armLocked(q.sent[0].at.Add(rateWindow).Sub(now))
+			return
+		}
+
+		// Shares only count while both classes compete
+		if len(q.waiting[PriorityInteractive]) > 0 && len(q.waiting[PriorityBatch]) > 0 {
+			q.served[p]++
+		} else {
+			q.served = [2]int{}
+		}
+		q.waiting[p] = q.waiting[p][1:]
+		q.recordDepthLocked(p)
+
+		d := &dispatch{at: now, tokens: w.tokens}
+		q.sent = append(q.sent, d)
+		metrics.LLMQueueWait.Observe(now.Sub(w.enqueued).Seconds(), p.String())
+		w.ready <- d
+	}
+}
+
+// nextLocked picks the class to serve: the only one waiting, or the one
+// furthest below its share of the calls served while both were waiting
+func (q *Queue) nextLocked(interactiveShare float64) (Priority, bool) {
+	interactive, batch := len(q.waiting[PriorityInteractive]) > 0, len(q.waiting[PriorityBatch]) > 0
+	switch {
+	case !interactive && !batch:
+		return 0, false
+	case !batch:
+		return PriorityInteractive, true
+	case !interactive:
+		return PriorityBatch, true
+	}
+
+	total := q.served[PriorityInteractive] + q.served[PriorityBatch]
+	if float64(q.served[PriorityInteractive]) <= interactiveShare*float64(total) {
+		return PriorityInteractive, true
+	}
+	return PriorityBatch, true
+}
+
+// fitsLocked reports whether a call of tokens can be sent now, within
+// llm.queue. A call larger than the whole token limit is sent once the
+// window is empty rather than never.
+func (q *Queue) fitsLocked(limits config.LLMQueueConfig, tokens int) bool {
+	if len(q.sent) == 0 {
+		return true
+	}
+	if limits.RequestsPerMinute > 0 && len(q.sent) >= limits.RequestsPerMinute {
+		return false
+	}
+	if limits.TokensPerMinute > 0 {
+		used := tokens
+		for _, d := range q.sent {
+			used += d.tokens
+		}
+		if used > limits.TokensPerMinute {
+			return false
+		}
+	}
+	return true
+}
+
+func (q *Queue) armLocked(wait time.Duration) {
+	if q.timer != nil {
+		q.timer.Stop()
+	}
+	q.timer = time.AfterFunc(wait, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.dispatchLocked()
+	})
+}
+
+func (q *Queue) recordDepthLocked(p Priority) {
+	metrics.LLMQueueDepth.Set(float64(len(q.waiting[p])), p.String())
+}
+
+// queuedGenerator sends every completion through a Queue, then the limiter
+// of provider, and records its calls in the metrics
+type queuedGenerator struct {
+	types.Generator
+	provider  string
+	queue     *Queue
+	limiter   *rateLimiter
+	maxTokens int
+}
+
+func (g *queuedGenerator) Complete(ctx context.Context, prompt string, opts types.GenerationOptions) (string, error) {
+	var completion string
+	err := g.send(ctx, prompt, opts, func(ctx context.Context) (err error) {
+		completion, err = g.Generator.Complete(ctx, prompt, opts)
+		return err
+	})
+	return completion, err
+}
+
+// CompleteStream streams from the wrapped provider when it can, and
+// otherwise passes its buffered completion on in one piece
+func (g *queuedGenerator) CompleteStream(ctx context.Context, prompt string, opts types.GenerationOptions, onChunk func(chunk string) error) error {
+	return g.send(ctx, prompt, opts, func(ctx context.Context) error {
+		return types.CompleteStream(ctx, g.Generator, prompt, opts, onChunk)
+	})
+}
+
+// send runs call once the queue dispatches it and the provider's limits
+// allow it
+func (g *queuedGenerator) send(ctx context.Context, prompt string, opts types.GenerationOptions, call func(ctx context.Context) error) error {
+	estimate := g.estimateTokens(prompt, opts)
+	d, err := g.queue.acquire(ctx, PriorityFromContext(ctx), estimate)
+	if err != nil {
+		return err
+	}
+	limits := providerLimits(config.Current().LLM.Generator)
+	if err := g.limiter.wait(ctx, limits, estimate); err != nil {
+		g.queue.release(d)
+		return err
+	}
+
+	// Count the actual usage against the limits and still report it to the
+	// caller's Usage
+	usageCtx, usage := types.WithUsage(ctx)
+	started := time.Now()
+	err = call(usageCtx)
+	metrics.LLMRequestDuration.Observe(time.Since(started).Seconds(), g.provider)
+	metrics.LLMRequests.Inc(g.provider, metrics.Outcome(err))
+	types.RecordUsage(ctx, usage.PromptTokens, usage.CompletionTokens)
+	if used := usage.PromptTokens + usage.CompletionTokens; used > 0 {
+		g.queue.settle(d, used)
+		g.limiter.adjust(limits, used-estimate)
+	}
+	return err
+}
+
+// SupportsJSON passes through whether the wrapped provider honours the
+// "json" option
+func (g *queuedGenerator) SupportsJSON() bool {
+	return types.SupportsJSON(g.Generator)
+}
+
+// defaultCompletionTokens is assumed for the completion when neither the
+// call nor llm.generator.max_tokens bounds it
+const defaultCompletionTokens = 1024
+
+// estimateTokens guesses the tokens of a call before it is sent, at about
+// four characters of prompt per token plus the completion budget
+func (g *queuedGenerator) estimateTokens(prompt string, opts types.GenerationOptions) int {
+	completion := g.maxTokens
+	if n := opts.MaxTokens; n > 0 {
+		completion = n
+	}
+	if completion <= 0 {
+		completion = defaultCompletionTokens
+	}
+	return len(prompt)/4 + completion
+}
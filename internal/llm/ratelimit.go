@@ -0,0 +1,130 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config"
+)
+
+// ErrRateLimited is returned when the provider's rate limit would hold a
+// call past the deadline of its context. Nothing was sent, so the call can
+// be retried later.
+var ErrRateLimited = errors.New("provider rate limit would delay the call past its deadline")
+
+// rateBurst is how much of a minute's allowance a limiter lets through at
+// once; beyond it calls are spaced at the configured rate
+const rateBurst = 6 * time.Second
+
+// rateLimits are the calls and tokens a provider allows per rateWindow, 0
+// for no limit
+type rateLimits struct {
+	requests int
+	tokens   int
+}
+
+// providerLimits returns the limits of llm.generator or llm.embedder
+func providerLimits(p config.ProviderConfig) rateLimits {
+	return rateLimits{requests: p.RequestsPerMinute, tokens: p.TokensPerMinute}
+}
+
+// rateLimiter keeps one token bucket for calls and one for tokens, each
+// refilled continuously at its limit per rateWindow and holding rateBurst of
+// it, so bursts are smoothed to the configured rate. Calls reserve their
+// cost when they arrive, so concurrent callers sharing a limiter are spaced
+// in order rather than woken together. The limits are passed on every call
+// so reloads apply at once. now and after are the clock, replaced in tests.
+type rateLimiter struct {
+	mu       sync.Mutex
+	requests bucket
+	tokens   bucket
+	now      func() time.Time
+	after    func(d time.Duration) <-chan time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{now: time.Now, after: time.After}
+}
+
+// bucket is the allowance left at a time, negative once calls reserved more
+// than it held
+type bucket struct {
+	level float64
+	at    time.Time
+}
+
+// take reserves cost from b, refilled at limit per rateWindow, and returns how
+// long until the reservation is covered. A cost larger than the bucket waits
+// for a full one rather than forever.
+func (b *bucket) take(now time.Time, limit int, cost float64) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+	rate := float64(limit) / rateWindow.Seconds()
+	capacity := max(1, rate*rateBurst.Seconds())
+	if b.at.IsZero() {
+		b.level = capacity
+	} else {
+		b.level = min(capacity, b.level+now.Sub(b.at).Seconds()*rate)
+	}
+	b.at = now
+
+	need := min(cost, capacity)
+	wait := time.Duration(max(0, need-b.level) / rate * float64(time.Second))
+	b.level -= cost
+	return wait
+}
+
+// reserve takes a call of tokens from both buckets and returns how long it
+// must wait before being sent
+func (l *rateLimiter) reserve(limits rateLimits, tokens int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	return max(l.requests.take(now, limits.requests, 1), l.tokens.take(now, limits.tokens, float64(tokens)))
+}
+
+// adjust corrects the tokens reserved for a call once its usage is known
+func (l *rateLimiter) adjust(limits rateLimits, delta int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limits.tokens > 0 && !l.tokens.at.IsZero() {
+		l.tokens.level -= float64(delta)
+	}
+}
+
+// cancel gives back the reservation of a call that was not sent
+func (l *rateLimiter) cancel(limits rateLimits, tokens int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limits.requests > 0 {
+		l.requests.level++
+	}
+	if limits.tokens > 0 {
+		l.tokens.level += float64(tokens)
+	}
+}
+
+// wait blocks until a call of tokens can be sent within limits, failing with
+// ErrRateLimited straight away when that is after the deadline of ctx
+func (l *rateLimiter) wait(ctx context.Context, limits rateLimits, tokens int) error {
+	delay := l.reserve(limits, tokens)
+	if delay == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && l.now().Add(delay).After(deadline) {
+		l.cancel(limits, tokens)
+		return fmt.Errorf("%w: it would wait %v", ErrRateLimited, delay.Round(time.Millisecond))
+	}
+
+	select {
+	case <-l.after(delay):
+		return nil
+	case <-ctx.Done():
+		l.cancel(limits, tokens)
+		return ctx.Err()
+	}
+}
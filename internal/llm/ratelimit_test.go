@@ -0,0 +1,222 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock stands still unless advanced. Its waits fire at once, recording
+// when the call would have been sent, or never when never is set.
+type fakeClock struct {
+	mu    sync.Mutex
+	at    time.Time
+	sends []time.Duration
+	never bool
+	start time.Time
+}
+
+func newFakeClock(start time.Time) *fakeClock {
+	return &fakeClock{at: start, start: start}
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.at
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.at = c.at.Add(d)
+}
+
+func (c *fakeClock) after(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fired := make(chan time.Time, 1)
+	if c.never {
+		return fired
+	}
+	c.sends = append(c.sends, c.at.Add(d).Sub(c.start))
+	fired <- c.at.Add(d)
+	return fired
+}
+
+// sent returns when each call that waited was sent, since the start
+func (c *fakeClock) sent() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sends := append([]time.Duration(nil), c.sends...)
+	sort.Slice(sends, func(i, j int) bool { return sends[i] < sends[j] })
+	return sends
+}
+
+func newTestLimiter(start time.Time) (*rateLimiter, *fakeClock) {
+	clock := newFakeClock(start)
+	l := newRateLimiter()
+	l.now, l.after = clock.now, clock.after
+	return l, clock
+}
+
+func TestRateLimiterSmoothsBurstOfRequests(t *testing.T) {
+	l, clock := newTestLimiter(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	limits := rateLimits{requests: 60}
+
+	// 20 workers sharing the limiter call at the same instant
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.wait(context.Background(), limits, 0); err != nil {
+				t.Errorf("wait: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// rateBurst lets 6 through at once; the other 14 are spaced a second
+	// apart, one per second being 60 per minute
+	sent := clock.sent()
+	if len(sent) != 14 {
+		t.Fatalf("%d calls waited, want 14 beyond the burst: %v", len(sent), sent)
+	}
+	for i, at := range sent {
+		if want := time.Duration(i+1) * time.Second; at != want {
+			t.Errorf("call %d sent at %v, want %v", i+7, at, want)
+		}
+	}
+}
+
+func TestRateLimiterSmoothsTokens(t *testing.T) {
+	l, clock := newTestLimiter(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	// 100 tokens a second, up to 600 at once
+	limits := rateLimits{requests: 1000, tokens: 6000}
+
+	for range 5 {
+		if err := l.wait(context.Background(), limits, 300); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	}
+	// Two calls fit the burst, then each waits for its 300 tokens
+	want := []time.Duration{3 * time.Second, 6 * time.Second, 9 * time.Second}
+	if got := clock.sent(); !reflect.DeepEqual(got, want) {
+		t.Errorf("sent at %v, want %v", got, want)
+	}
+}
+
+func TestRateLimiterRefillsUpToBurst(t *testing.T) {
+	l, clock := newTestLimiter(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	limits := rateLimits{requests: 60}
+
+	for range 6 {
+		if d := l.reserve(limits, 0); d != 0 {
+			t.Fatalf("call within the burst waits %v", d)
+		}
+	}
+	if d := l.reserve(limits, 0); d != time.Second {
+		t.Errorf("call past the burst waits %v, want 1s", d)
+	}
+
+	// An idle hour refills the bucket to the burst, not to an hour of calls
+	clock.advance(time.Hour)
+	for i := range 7 {
+		d := l.reserve(limits, 0)
+		if i < 6 && d != 0 {
+			t.Errorf("call %d after the idle hour waits %v", i+1, d)
+		}
+		if i == 6 && d != time.Second {
+			t.Errorf("call past the refilled burst waits %v, want 1s", d)
+		}
+	}
+}
+
+func TestRateLimiterCallLargerThanBurst(t *testing.T) {
+	l, _ := newTestLimiter(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	// 10 tokens a second, up to 60 at once
+	limits := rateLimits{tokens: 600}
+
+	if d := l.reserve(limits, 30); d != 0 {
+		t.Fatalf("first call waits %v", d)
+	}
+	// A call of more than the bucket holds waits for a full bucket
+	if d := l.reserve(limits, 1000); d != 3*time.Second {
+		t.Errorf("oversized call waits %v, want 3s for the bucket to refill", d)
+	}
+}
+
+func TestRateLimiterFailsFastPastDeadline(t *testing.T) {
+	l, clock := newTestLimiter(time.Now())
+	limits := rateLimits{requests: 60}
+	for range 6 {
+		l.reserve(limits, 0)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx, limits, 0); err != nil {
+		t.Fatalf("call within the deadline: %v", err)
+	}
+	err := l.wait(ctx, limits, 0)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("call past the deadline = %v, want ErrRateLimited", err)
+	}
+	if got := clock.sent(); len(got) != 1 {
+		t.Errorf("refused call waited: sent at %v", got)
+	}
+
+	// The refused call gave its place back
+	if d := l.reserve(limits, 0); d != 2*time.Second {
+		t.Errorf("next call waits %v, want 2s", d)
+	}
+}
+
+func TestRateLimiterCancelledWhileWaiting(t *testing.T) {
+	l, clock := newTestLimiter(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.never = true
+	limits := rateLimits{requests: 60, tokens: 6000}
+	for range 6 {
+		l.reserve(limits, 0)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- l.wait(ctx, limits, 100) }()
+	cancel()
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Fatalf("wait = %v, want the cancellation", err)
+	}
+	if d := l.reserve(limits, 0); d != time.Second {
+		t.Errorf("next call waits %v, want 1s as if the cancelled one never came", d)
+	}
+}
+
+func TestRateLimiterAdjustsToActualUsage(t *testing.T) {
+	l, _ := newTestLimiter(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	limits := rateLimits{tokens: 6000}
+
+	l.reserve(limits, 100)
+	// The call used 500 more tokens than estimated, leaving none
+	l.adjust(limits, 500)
+	if d := l.reserve(limits, 100); d != time.Second {
+		t.Errorf("next call waits %v, want 1s for its 100 tokens", d)
+	}
+}
+
+func TestRateLimiterWithoutLimits(t *testing.T) {
+	l, clock := newTestLimiter(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	for range 1000 {
+		if err := l.wait(context.Background(), rateLimits{}, 10000); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	}
+	if got := clock.sent(); len(got) != 0 {
+		t.Errorf("unlimited calls waited: %v", got)
+	}
+}
@@ -0,0 +1,116 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/tracing"
+)
+
+// maxBackoff caps the wait between two attempts
+const maxBackoff = 30 * time.Second
+
+// Do sends the request built by newRequest, retrying up to maxRetries times on
+// network errors and transient statuses with exponential backoff and jitter.
+// A Retry-After header, in seconds or as a date, takes precedence over the
+// computed backoff. When the wait would outlast the context deadline, the
+// last response or error is returned instead of waiting for nothing.
+// newRequest is called once per attempt so the body can be replayed.
+func Do(ctx context.Context, client *http.Client, maxRetries int, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	backoff := 500 * time.Millisecond
+
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := send(client, req, attempt)
+		if attempt >= maxRetries || !retryable(ctx, resp, err) {
+			return resp, err
+		}
+
+		// Equal jitter keeps concurrent callers from retrying in lockstep
+		wait := backoff/2 + rand.N(backoff/2+1)
+		if resp != nil {
+			if after := retryAfter(resp, time.Now()); after > 0 {
+				wait = after
+			}
+		}
+		if wait > maxBackoff {
+			wait = maxBackoff
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return resp, err
+		}
+		if resp != nil {
+			// Drain so the connection can be reused
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// send performs one attempt, traced as a client span of the provider call
+func send(client *http.Client, req *http.Request, attempt int) (*http.Response, error) {
+	ctx, span := tracing.StartKind(req.Context(), "HTTP "+req.Method, tracing.KindClient,
+		tracing.String("http.request.method", req.Method),
+		tracing.String("server.address", req.URL.Host),
+		tracing.Int("http.request.resend_count", int64(attempt)))
+	defer span.End()
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		return resp, err
+	}
+	span.SetAttributes(tracing.Int("http.response.status_code", int64(resp.StatusCode)))
+	if resp.StatusCode >= 400 {
+		span.RecordError(fmt.Errorf("HTTP %d", resp.StatusCode))
+	}
+	return resp, nil
+}
+
+func retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		// Our own cancellation or deadline is not worth retrying
+		return ctx.Err() == nil
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout, statusOverloaded:
+		return true
+	}
+	return false
+}
+
+// statusOverloaded is Anthropic's "overloaded_error" status
+const statusOverloaded = 529
+
+func retryAfter(resp *http.Response, now time.Time) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return at.Sub(now)
+	}
+	return 0
+}
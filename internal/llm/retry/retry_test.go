@@ -0,0 +1,152 @@
+package retry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer answers 429 with the given Retry-After to the first failures
+// requests, then 200, recording when each request arrived
+type flakyServer struct {
+	failures   int
+	retryAfter string
+
+	mu       sync.Mutex
+	arrivals []time.Time
+}
+
+func (f *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.arrivals = append(f.arrivals, time.Now())
+	n := len(f.arrivals)
+	f.mu.Unlock()
+
+	if n <= f.failures {
+		w.Header().Set("Retry-After", f.retryAfter)
+		http.Error(w, `{"error":"rate limited"}`, http.StatusTooManyRequests)
+		return
+	}
+	w.Write([]byte(`{"ok":true}`))
+}
+
+func (f *flakyServer) requests() []time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]time.Time(nil), f.arrivals...)
+}
+
+func get(url string) func() (*http.Request, error) {
+	return func() (*http.Request, error) { return http.NewRequest(http.MethodGet, url, nil) }
+}
+
+func TestDoRetriesRateLimitsHonouringRetryAfter(t *testing.T) {
+	flaky := &flakyServer{failures: 2, retryAfter: "1"}
+	srv := httptest.NewServer(flaky)
+	defer srv.Close()
+
+	resp, err := Do(context.Background(), srv.Client(), 3, get(srv.URL))
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+
+	arrivals := flaky.requests()
+	if len(arrivals) != 3 {
+		t.Fatalf("server got %d requests, want 3", len(arrivals))
+	}
+	for i := 1; i < len(arrivals); i++ {
+		// Retry-After: 1 replaces the 250-500ms first backoff
+		if gap := arrivals[i].Sub(arrivals[i-1]); gap < 950*time.Millisecond {
+			t.Errorf("attempt %d came %v after the previous one, want at least the 1s Retry-After", i+1, gap)
+		}
+	}
+}
+
+func TestDoGivesUpAfterMaxRetries(t *testing.T) {
+	flaky := &flakyServer{failures: 10, retryAfter: "0"}
+	srv := httptest.NewServer(flaky)
+	defer srv.Close()
+
+	resp, err := Do(context.Background(), srv.Client(), 1, get(srv.URL))
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want the last 429", resp.StatusCode)
+	}
+	if n := len(flaky.requests()); n != 2 {
+		t.Errorf("server got %d requests, want 2", n)
+	}
+}
+
+func TestDoDoesNotWaitPastDeadline(t *testing.T) {
+	flaky := &flakyServer{failures: 10, retryAfter: "20"}
+	srv := httptest.NewServer(flaky)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	started := time.Now()
+	resp, err := Do(ctx, srv.Client(), 5, get(srv.URL))
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", resp.StatusCode)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Do took %v, want it to return the 429 at once", elapsed)
+	}
+	if n := len(flaky.requests()); n != 1 {
+		t.Errorf("server got %d requests, want 1", n)
+	}
+}
+
+func TestDoDoesNotRetryClientErrors(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	resp, err := Do(context.Background(), srv.Client(), 3, get(srv.URL))
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	resp.Body.Close()
+	if requests != 1 {
+		t.Errorf("server got %d requests, want 1", requests)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"0", 0},
+		{"-1", 0},
+		{now.Add(10 * time.Second).Format(http.TimeFormat), 10 * time.Second},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("Retry-After", tt.value)
+		if got := retryAfter(resp, now); got != tt.want {
+			t.Errorf("retryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/matthieukhl/latentia/internal/tracing"
+)
+
+// Levels lists the accepted log level names
+var Levels = []string{"debug", "info", "warn", "error"}
+
+// Formats lists the accepted log formats
+var Formats = []string{"text", "json"}
+
+// Default rotation limits for file output
+const (
+	DefaultMaxSizeMB  = 100
+	DefaultMaxBackups = 5
+)
+
+// level is shared by the default handler so it can be changed at runtime
+var level = new(slog.LevelVar)
+
+// output is the file opened by the last Setup, closed when Setup runs again
+var output io.Closer
+
+// Options configures the shared slog pipeline
+type Options struct {
+	Level  string
+	Format string // text (default) or json
+
+	// Output is stdout, stderr (default) or a file path. Files are rotated
+	// once they reach MaxSizeMB, keeping MaxBackups old files.
+	Output     string
+	MaxSizeMB  int
+	MaxBackups int
+}
+
+// Setup installs the default slog logger described by opts
+func Setup(opts Options) error {
+	if err := SetLevel(opts.Level); err != nil {
+		return err
+	}
+	if err := ValidateFormat(opts.Format); err != nil {
+		return err
+	}
+
+	w, closer, err := openOutput(opts)
+	if err != nil {
+		return err
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(w, handlerOpts)
+	if strings.EqualFold(opts.Format, "json") {
+		handler = slog.NewJSONHandler(w, handlerOpts)
+	}
+	slog.SetDefault(slog.New(traceHandler{handler}))
+
+	if output != nil {
+		output.Close()
+	}
+	output = closer
+	return nil
+}
+
+// traceHandler adds trace_id and span_id to records logged with a context
+// carrying a sampled span, so log lines can be matched to traces
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if traceID, spanID, ok := tracing.IDs(ctx); ok {
+		r.AddAttrs(slog.String("trace_id", traceID), slog.String("span_id", spanID))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
+
+func openOutput(opts Options) (io.Writer, io.Closer, error) {
+	switch strings.ToLower(strings.TrimSpace(opts.Output)) {
+	case "", "stderr":
+		return os.Stderr, nil, nil
+	case "stdout":
+		return os.Stdout, nil, nil
+	}
+
+	maxSize := opts.MaxSizeMB
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSizeMB
+	}
+	maxBackups := opts.MaxBackups
+	if maxBackups <= 0 {
+		maxBackups = DefaultMaxBackups
+	}
+	file, err := OpenRotatingFile(opts.Output, int64(maxSize)<<20, maxBackups)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, file, nil
+}
+
+// SetLevel changes the level of the default logger without replacing it
+func SetLevel(name string) error {
+	lvl, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	level.Set(lvl)
+	return nil
+}
+
+// ParseLevel converts a level name (case-insensitive) to a slog.Level
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q (expected one of: %s)", name, strings.Join(Levels, ", "))
+	}
+}
+
+// ValidateFormat checks a format name; empty means text
+func ValidateFormat(name string) error {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "text", "json":
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q (expected one of: %s)", name, strings.Join(Formats, ", "))
+	}
+}
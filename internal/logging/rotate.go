@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// rotateRetryInterval spaces the attempts to rotate after one failed
+const rotateRetryInterval = time.Minute
+
+// RotatingFile is an append-only log file that is renamed to path.1 once it
+// would grow past maxSize, shifting older backups up to path.<maxBackups>.
+// A rotation that fails is reported on stderr and the file keeps growing
+// until a later attempt succeeds, so no backup is overwritten or lost.
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	// retryAt holds off rotating again after a failure
+	retryAt time.Time
+}
+
+// OpenRotatingFile opens (or creates) path for appending, creating its
+// directory if needed
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	r := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+// Write appends p, rotating first when p would push the file past maxSize
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize && !time.Now().Before(r.retryAt) {
+		if err := r.rotate(); err != nil {
+			// The logger writes here, so the failure goes to stderr
+			fmt.Fprintf(os.Stderr, "log rotation failed, still writing to %s: %v\n", r.path, err)
+			r.retryAt = time.Now().Add(rotateRetryInterval)
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups and renames the current file to path.1, while
+// it is still open: a step that fails stops there, the current file being
+// kept for the next writes
+func (r *RotatingFile) rotate() error {
+	if err := os.Remove(r.backup(r.maxBackups)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove the oldest log backup: %w", err)
+	}
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(r.backup(i), r.backup(i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to shift log backup %d: %w", i, err)
+		}
+	}
+	if err := os.Rename(r.path, r.backup(1)); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+
+	previous := r.file
+	if err := r.open(); err != nil {
+		// Writes go on to the renamed file rather than nowhere
+		return err
+	}
+	return previous.Close()
+}
+
+func (r *RotatingFile) backup(n int) string {
+	return fmt.Sprintf("%s.%d", r.path, n)
+}
+
+// Close closes the current file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
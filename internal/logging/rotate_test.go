@@ -0,0 +1,162 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func write(t *testing.T, r *RotatingFile, line string) {
+	t.Helper()
+	if n, err := r.Write([]byte(line)); err != nil || n != len(line) {
+		t.Fatalf("Write(%q) = %d, %v", line, n, err)
+	}
+}
+
+func TestRotatingFileRotatesAtMaxSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "agent.log")
+	r, err := OpenRotatingFile(path, 10, 3)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile: %v", err)
+	}
+	defer r.Close()
+
+	write(t, r, "aaaa\n")
+	write(t, r, "bbbb\n")
+	// Exactly maxSize: no rotation yet
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Fatalf("rotated at exactly max size: %v", err)
+	}
+	write(t, r, "cccc\n")
+
+	if got := readFile(t, path+".1"); got != "aaaa\nbbbb\n" {
+		t.Errorf("backup 1 = %q", got)
+	}
+	if got := readFile(t, path); got != "cccc\n" {
+		t.Errorf("current file = %q", got)
+	}
+}
+
+func TestRotatingFilePrunesOldBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	r, err := OpenRotatingFile(path, 4, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile: %v", err)
+	}
+	defer r.Close()
+
+	// Every write fills the file, so each following one rotates
+	for _, line := range []string{"one\n", "two\n", "thr\n", "fou\n", "fiv\n"} {
+		write(t, r, line)
+	}
+
+	want := map[string]string{path: "fiv\n", path + ".1": "fou\n", path + ".2": "thr\n"}
+	for file, content := range want {
+		if got := readFile(t, file); got != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), got, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("backup beyond max_backups kept: %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("log directory holds %v, want the file and 2 backups", names)
+	}
+}
+
+func TestRotatingFileCountsExistingContent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	if err := os.WriteFile(path, []byte("earlier\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile: %v", err)
+	}
+	defer r.Close()
+
+	// The 8 bytes written before the restart count towards max size
+	write(t, r, "later\n")
+	if got := readFile(t, path+".1"); got != "earlier\n" {
+		t.Errorf("backup = %q, want the content from before the restart", got)
+	}
+	if got := readFile(t, path); got != "later\n" {
+		t.Errorf("current file = %q", got)
+	}
+}
+
+func TestRotatingFileKeepsOversizedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	r, err := OpenRotatingFile(path, 4, 1)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile: %v", err)
+	}
+	defer r.Close()
+
+	// A write larger than max size still lands whole in an empty file
+	long := strings.Repeat("x", 16) + "\n"
+	write(t, r, long)
+	if got := readFile(t, path); got != long {
+		t.Errorf("current file = %q", got)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("an empty file was rotated: %v", err)
+	}
+}
+
+func TestRotatingFileKeepsBackupsWhenShiftFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	r, err := OpenRotatingFile(path, 4, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile: %v", err)
+	}
+	defer r.Close()
+
+	write(t, r, "one\n")
+	write(t, r, "two\n")
+	// A non-empty directory where the oldest backup goes cannot be removed
+	if err := os.MkdirAll(filepath.Join(path+".2", "stuck"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write(t, r, "thr\n")
+	write(t, r, "fou\n")
+
+	if got := readFile(t, path+".1"); got != "one\n" {
+		t.Errorf("backup 1 = %q, want it untouched", got)
+	}
+	if got := readFile(t, path); got != "two\nthr\nfou\n" {
+		t.Errorf("current file = %q, want the writes since the failed rotation", got)
+	}
+
+	// Once the obstacle is gone, the next attempt rotates
+	if err := os.RemoveAll(path + ".2"); err != nil {
+		t.Fatal(err)
+	}
+	r.retryAt = time.Time{}
+	write(t, r, "fiv\n")
+	want := map[string]string{path: "fiv\n", path + ".1": "two\nthr\nfou\n", path + ".2": "one\n"}
+	for file, content := range want {
+		if got := readFile(t, file); got != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), got, content)
+		}
+	}
+}
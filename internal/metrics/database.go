@@ -0,0 +1,29 @@
+package metrics
+
+import "database/sql"
+
+var (
+	DBConnections = Default.NewGaugeVec("latentia_db_connections",
+		"Connections of the app database pool, by state (in_use, idle).", "state")
+	DBMaxOpenConnections = Default.NewGaugeVec("latentia_db_max_open_connections",
+		"Most connections the app database pool opens, 0 for unlimited.")
+	DBWaits = Default.NewCounterVec("latentia_db_waits_total",
+		"Connections the app waited for because the pool was exhausted.")
+	DBWaitDuration = Default.NewCounterVec("latentia_db_wait_duration_seconds_total",
+		"Time the app spent waiting for a pool connection.")
+	DBConnectionsClosed = Default.NewCounterVec("latentia_db_connections_closed_total",
+		"Pool connections closed, by reason (max_idle, max_idle_time, max_lifetime).", "reason")
+)
+
+// RecordDBStats copies the statistics of the app database pool into the
+// latentia_db_* series; /metrics calls it before every scrape
+func RecordDBStats(stats sql.DBStats) {
+	DBConnections.Set(float64(stats.InUse), "in_use")
+	DBConnections.Set(float64(stats.Idle), "idle")
+	DBMaxOpenConnections.Set(float64(stats.MaxOpenConnections))
+	DBWaits.Set(float64(stats.WaitCount))
+	DBWaitDuration.Set(stats.WaitDuration.Seconds())
+	DBConnectionsClosed.Set(float64(stats.MaxIdleClosed), "max_idle")
+	DBConnectionsClosed.Set(float64(stats.MaxIdleTimeClosed), "max_idle_time")
+	DBConnectionsClosed.Set(float64(stats.MaxLifetimeClosed), "max_lifetime")
+}
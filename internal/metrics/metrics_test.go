@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLabelValuesEscaping(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"plain", "openai", `"openai"`},
+		{"backslash", `C:\tmp`, `"C:\\tmp"`},
+		{"double quote", `say "hi"`, `"say \"hi\""`},
+		{"newline", "a\nb", `"a\nb"`},
+		{"tab kept", "a\tb", "\"a\tb\""},
+		{"utf-8 kept", "café", `"café"`},
+		{"control kept", "a\x01b", "\"a\x01b\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Registry{}
+			c := r.NewCounterVec("test_total", "Test.", "label")
+			c.Inc(tt.value)
+
+			var b strings.Builder
+			r.WriteText(&b)
+			want := "test_total{label=" + tt.want + "} 1\n"
+			if !strings.HasSuffix(b.String(), want) {
+				t.Errorf("exposition =\n%s\nwant it to end with\n%s", b.String(), want)
+			}
+		})
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	r := &Registry{}
+	counter := r.NewCounterVec("test_requests_total", "Requests.", "provider", "outcome")
+	unlabelled := r.NewCounterVec("test_started_total", "Started.")
+	gauge := r.NewGaugeVec("test_depth", "Depth.", "class")
+	histogram := r.NewHistogramVec("test_duration_seconds", "Duration.", []float64{0.1, 1}, "stage")
+
+	counter.Inc("openai", "ok")
+	counter.Add(2, "anthropic", "error")
+	gauge.Set(3, "batch")
+	histogram.Observe(0.05, "parse")
+	histogram.Observe(0.5, "parse")
+	histogram.Observe(5, "parse")
+
+	var b strings.Builder
+	r.WriteText(&b)
+	want := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{provider="anthropic",outcome="error"} 2
+test_requests_total{provider="openai",outcome="ok"} 1
+# HELP test_started_total Started.
+# TYPE test_started_total counter
+test_started_total 0
+# HELP test_depth Depth.
+# TYPE test_depth gauge
+test_depth{class="batch"} 3
+# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{stage="parse",le="0.1"} 1
+test_duration_seconds_bucket{stage="parse",le="1"} 2
+test_duration_seconds_bucket{stage="parse",le="+Inf"} 3
+test_duration_seconds_sum{stage="parse"} 5.55
+test_duration_seconds_count{stage="parse"} 3
+`
+	if b.String() != want {
+		t.Errorf("exposition =\n%s\nwant\n%s", b.String(), want)
+	}
+	if got := unlabelled.Value(); got != 0 {
+		t.Errorf("unlabelled counter = %v, want 0", got)
+	}
+}
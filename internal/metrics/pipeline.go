@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"strings"
+	"time"
+)
+
+// Optimization stages, used both as the failure stage of
+// latentia_optimizations_failed_total and the stage label of
+// latentia_optimization_stage_duration_seconds
+const (
+	StageAnalysis   = "analysis"
+	StageRetrieval  = "retrieval"
+	StagePrompt     = "prompt"
+	StageGeneration = "generation"
+	StageParse      = "parse"
+	StageValidate   = "validate"
+	StageStore      = "store"
+)
+
+// Results of rewrite cache lookups, the result label of
+// latentia_rewrite_cache_lookups_total
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+// Outcomes of provider calls, the outcome label of latentia_llm_requests_total
+// and latentia_embedding_requests_total
+const (
+	OutcomeOK    = "ok"
+	OutcomeError = "error"
+)
+
+var (
+	SlowQueriesIngested = Default.NewCounterVec("latentia_slow_queries_ingested_total",
+		"Slow queries stored, by source (generated, information_schema, adhoc) and initial status (pending, analyzing, completed, skipped, unsupported).", "source", "status")
+
+	OptimizationsStarted = Default.NewCounterVec("latentia_optimizations_started_total",
+		"Optimizations started.")
+	OptimizationsSucceeded = Default.NewCounterVec("latentia_optimizations_succeeded_total",
+		"Optimizations stored as a rewrite.")
+	OptimizationsFailed = Default.NewCounterVec("latentia_optimizations_failed_total",
+		"Optimizations that failed, by the stage that failed (prompt, generation, parse, validate, store).", "stage")
+	StageDuration = Default.NewHistogramVec("latentia_optimization_stage_duration_seconds",
+		"Time spent in each optimization stage.", DurationBuckets, "stage")
+	RewriteCacheLookups = Default.NewCounterVec("latentia_rewrite_cache_lookups_total",
+		"Lookups of an existing rewrite of the digest before calling the generator, by result (hit, miss).", "result")
+
+	Reviews = Default.NewCounterVec("latentia_reviews_total",
+		"Rewrite review decisions, by action and kind of actor (api, cli, policy).", "action", "actor")
+
+	LLMRequests = Default.NewCounterVec("latentia_llm_requests_total",
+		"Generator calls sent to the provider, by provider and outcome (ok, error).", "provider", "outcome")
+	LLMRequestDuration = Default.NewHistogramVec("latentia_llm_request_duration_seconds",
+		"Time generator calls took once sent to the provider, queue wait excluded.", DurationBuckets, "provider")
+	LLMTokens = Default.NewCounterVec("latentia_llm_tokens_total",
+		"Generator tokens, by provider, model and direction (prompt, completion).", "provider", "model", "direction")
+	LLMCost = Default.NewCounterVec("latentia_llm_cost_usd_total",
+		"Estimated generator cost in US dollars from the configured per-token prices.", "provider", "model")
+
+	EmbeddingRequests = Default.NewCounterVec("latentia_embedding_requests_total",
+		"Embedder calls, by provider and outcome (ok, error).", "provider", "outcome")
+	EmbeddingTexts = Default.NewCounterVec("latentia_embedding_texts_total",
+		"Texts sent to the embedder, by provider.", "provider")
+	EmbeddingDuration = Default.NewHistogramVec("latentia_embedding_request_duration_seconds",
+		"Time embedder calls took, by provider.", DurationBuckets, "provider")
+	EmbeddingCacheLookups = Default.NewCounterVec("latentia_embedding_cache_lookups_total",
+		"Document texts looked up in the embedding cache, by result (hit, miss).", "result")
+
+	VectorSearchDuration = Default.NewHistogramVec("latentia_vector_search_duration_seconds",
+		"Time documentation searches took, query embedding included, by mode (vector, hybrid).", DurationBuckets, "mode")
+
+	LLMQueueDepth = Default.NewGaugeVec("latentia_llm_queue_depth",
+		"Generator calls waiting for the rate limits, by priority class (interactive, batch).", "class")
+	LLMQueueWait = Default.NewHistogramVec("latentia_llm_queue_wait_seconds",
+		"Time generator calls waited in the queue before being sent, by priority class.", DurationBuckets, "class")
+)
+
+// StageTimer measures consecutive stages of one optimization
+type StageTimer struct {
+	stage string
+	start time.Time
+}
+
+// StartStage begins timing stage
+func StartStage(stage string) *StageTimer {
+	return &StageTimer{stage: stage, start: time.Now()}
+}
+
+// Next records the current stage and starts timing the following one
+func (t *StageTimer) Next(stage string) {
+	t.Done()
+	t.stage, t.start = stage, time.Now()
+}
+
+// Done records the current stage
+func (t *StageTimer) Done() {
+	StageDuration.Observe(time.Since(t.start).Seconds(), t.stage)
+}
+
+// Fail records the current stage and counts the optimization as failed in it
+func (t *StageTimer) Fail() {
+	t.Done()
+	OptimizationsFailed.Inc(t.stage)
+}
+
+// RecordReview counts a review decision. Actors such as "api:1a2b3c4d" and
+// "cli:alice" are reduced to their kind to bound cardinality.
+func RecordReview(action, actor string) {
+	kind, _, _ := strings.Cut(actor, ":")
+	Reviews.Inc(action, kind)
+}
+
+// RecordTokens counts the tokens of one completion and their cost at the
+// given prices per million tokens
+func RecordTokens(provider, model string, promptTokens, completionTokens int, inputPricePerMTok, outputPricePerMTok float64) {
+	LLMTokens.Add(float64(promptTokens), provider, model, "prompt")
+	LLMTokens.Add(float64(completionTokens), provider, model, "completion")
+	if cost := (float64(promptTokens)*inputPricePerMTok + float64(completionTokens)*outputPricePerMTok) / 1e6; cost > 0 {
+		LLMCost.Add(cost, provider, model)
+	}
+}
+
+// Outcome is the outcome label of a provider call that returned err
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeOK
+}
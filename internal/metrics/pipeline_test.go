@@ -0,0 +1,52 @@
+package metrics
+
+import "testing"
+
+func TestRecordReviewReducesActor(t *testing.T) {
+	before := Reviews.Value("accept", "cli")
+	RecordReview("accept", "cli:alice")
+	RecordReview("accept", "cli:bob")
+	if got := Reviews.Value("accept", "cli") - before; got != 2 {
+		t.Errorf("reviews by cli actors +%v, want +2", got)
+	}
+	if got := Reviews.Value("accept", "cli:alice"); got != 0 {
+		t.Errorf("review counted under the full actor: %v", got)
+	}
+}
+
+func TestRecordTokens(t *testing.T) {
+	RecordTokens("test", "priced", 1000, 200, 3, 15)
+	if got := LLMTokens.Value("test", "priced", "prompt"); got != 1000 {
+		t.Errorf("prompt tokens = %v", got)
+	}
+	if got := LLMTokens.Value("test", "priced", "completion"); got != 200 {
+		t.Errorf("completion tokens = %v", got)
+	}
+	if got, want := LLMCost.Value("test", "priced"), 0.006; got < want-1e-12 || got > want+1e-12 {
+		t.Errorf("cost = %v, want %v", got, want)
+	}
+
+	// Without prices no cost series is created
+	RecordTokens("test", "free", 1000, 200, 0, 0)
+	if got := LLMCost.Value("test", "free"); got != 0 {
+		t.Errorf("cost of unpriced model = %v", got)
+	}
+}
+
+func TestStageTimerFail(t *testing.T) {
+	timer := StartStage("test_parse")
+	timer.Next("test_validate")
+	timer.Fail()
+	if got := StageDuration.Count("test_parse"); got != 1 {
+		t.Errorf("first stage timed %d times, want once", got)
+	}
+	if got := StageDuration.Count("test_validate"); got != 1 {
+		t.Errorf("failed stage timed %d times, want once", got)
+	}
+	if got := OptimizationsFailed.Value("test_validate"); got != 1 {
+		t.Errorf("failures in the stage = %v, want 1", got)
+	}
+	if got := OptimizationsFailed.Value("test_parse"); got != 0 {
+		t.Errorf("failures in the stage that succeeded = %v", got)
+	}
+}
@@ -7,8 +7,10 @@ import (
 
 type SlowQuery struct {
 	ID               int64           `json:"id" db:"id"`
+	Target           string          `json:"target" db:"target"` // the db.targets entry it was read from
 	Digest           string          `json:"digest" db:"digest"`
 	SampleSQL        string          `json:"sample_sql" db:"sample_sql"`
+	NormalizedSQL    string          `json:"normalized_sql,omitempty" db:"normalized_sql"` // the text Digest is computed from, with literals as ?
 	StartedAt        time.Time       `json:"started_at" db:"started_at"`
 	QueryTime        float64         `json:"query_time" db:"query_time"` // in seconds, matches INFORMATION_SCHEMA
 	DB               string          `json:"db" db:"db"`
@@ -17,8 +19,9 @@ type SlowQuery struct {
 	User             string          `json:"user" db:"user"`
 	Host             string          `json:"host" db:"host"`
 	Tables           json.RawMessage `json:"tables" db:"tables"`
-	Source           string          `json:"source" db:"source"` // 'generated' or 'information_schema'
+	Source           string          `json:"source" db:"source"` // 'generated', 'information_schema', 'adhoc' or 'slowlog'
 	Status           string          `json:"status" db:"status"`
+	SkipReason       string          `json:"skip_reason,omitempty" db:"skip_reason"`
 	LastAnalyzedAt   *time.Time      `json:"last_analyzed_at" db:"last_analyzed_at"`
 	BestRewriteID    *int64          `json:"best_rewrite_id" db:"best_rewrite_id"`
 }
@@ -40,9 +43,18 @@ const (
 	StatusPending   = "pending"
 	StatusAnalyzing = "analyzing"
 	StatusCompleted = "completed"
+	StatusSkipped   = "skipped"
+
+	// StatusUnsupported settles slow queries whose sample holds several
+	// statements, which are never analyzed
+	StatusUnsupported = "unsupported"
 )
 
 const (
 	SourceGenerated        = "generated"
 	SourceInformationSchema = "information_schema"
+	// SourceAdhoc is SQL submitted to POST /api/analyze
+	SourceAdhoc = "adhoc"
+	// SourceSlowLog is read from a TiDB or MySQL slow log file
+	SourceSlowLog = "slowlog"
 )
\ No newline at end of file
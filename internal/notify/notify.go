@@ -0,0 +1,216 @@
+// Package notify delivers pipeline events, such as new rewrites and failed
+// jobs, to Slack and to a generic JSON webhook.
+//
+// Publish never blocks: events are queued and sent by a background
+// goroutine that retries failed deliveries and then drops them, so a slow or
+// unreachable webhook cannot stall the pipeline.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config"
+)
+
+// Event types, as listed in config.NotifyEvents
+const (
+	RewriteCreated = "rewrite_created"
+	JobFailed      = "job_failed"
+	BudgetExceeded = "budget_exceeded"
+)
+
+// Event is one notification; fields that do not apply to its type are empty
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+
+	RewriteID    int64   `json:"rewrite_id,omitempty"`
+	SlowQueryID  int64   `json:"slow_query_id,omitempty"`
+	Digest       string  `json:"digest,omitempty"`
+	Confidence   float64 `json:"confidence,omitempty"`
+	OriginalSQL  string  `json:"original_sql,omitempty"`
+	OptimizedSQL string  `json:"optimized_sql,omitempty"`
+	Rationale    string  `json:"rationale,omitempty"`
+
+	Job   string `json:"job,omitempty"`
+	Error string `json:"error,omitempty"`
+
+	// URL links to the rewrite in the agent UI
+	URL string `json:"url,omitempty"`
+
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// Notifier sends an event to one target
+type Notifier interface {
+	Name() string
+	Send(ctx context.Context, event Event) error
+}
+
+// queueSize bounds the events waiting for delivery; more are dropped
+const queueSize = 256
+
+// sendTimeout bounds one delivery attempt
+const sendTimeout = 10 * time.Second
+
+var (
+	startOnce sync.Once
+	queue     = make(chan Event, queueSize)
+	pending   sync.WaitGroup
+	client    = &http.Client{Timeout: sendTimeout}
+)
+
+// Publish queues event for every target subscribed to its type under the
+// current notify configuration
+func Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	startOnce.Do(func() { go deliver() })
+
+	pending.Add(1)
+	select {
+	case queue <- event:
+	default:
+		pending.Done()
+		slog.Warn("notification dropped: queue full", "type", event.Type)
+	}
+}
+
+// Flush waits until queued events are delivered or timeout elapses
+func Flush(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+func deliver() {
+	for event := range queue {
+		cfg := config.Current().Notify
+		if event.Type == RewriteCreated && event.URL == "" && cfg.UIURL != "" && event.RewriteID > 0 {
+			event.URL = rewriteURL(cfg.UIURL, event.RewriteID)
+		}
+		for _, n := range notifiers(cfg, event) {
+			send(n, event, cfg.Retries)
+		}
+		pending.Done()
+	}
+}
+
+// notifiers returns the configured targets whose rules accept event, one
+// per URL
+func notifiers(cfg config.NotifyConfig, event Event) []Notifier {
+	var out []Notifier
+	if accepts(cfg.Slack, event) {
+		out = append(out, slackNotifiers(cfg.Slack)...)
+	}
+	if accepts(cfg.Webhook, event) {
+		out = append(out, webhookNotifiers(cfg.Webhook)...)
+	}
+	return out
+}
+
+func slackNotifiers(target config.NotifierConfig) []Notifier {
+	var out []Notifier
+	urls := target.URLs()
+	for i, u := range urls {
+		out = append(out, &slackNotifier{name: targetName("slack", i, len(urls)), url: u, secret: target.Secret, channels: target.Channels})
+	}
+	return out
+}
+
+func webhookNotifiers(target config.NotifierConfig) []Notifier {
+	var out []Notifier
+	urls := target.URLs()
+	for i, u := range urls {
+		out = append(out, &webhookNotifier{name: targetName("webhook", i, len(urls)), url: u, secret: target.Secret})
+	}
+	return out
+}
+
+// targetName numbers the URLs of a target that has several, in the order
+// of config.NotifierConfig.URLs
+func targetName(kind string, i, n int) string {
+	if n == 1 {
+		return kind
+	}
+	return fmt.Sprintf("%s #%d", kind, i+1)
+}
+
+func accepts(target config.NotifierConfig, event Event) bool {
+	if len(target.URLs()) == 0 {
+		return false
+	}
+	subscribed := false
+	for _, t := range target.Events {
+		if t == event.Type {
+			subscribed = true
+			break
+		}
+	}
+	if !subscribed {
+		return false
+	}
+	return event.Type != RewriteCreated || event.Confidence >= target.MinConfidence
+}
+
+// send delivers event with exponential backoff, giving up after retries
+// further attempts
+func send(n Notifier, event Event, retries int) {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err := n.Send(ctx, event)
+		cancel()
+		if err == nil {
+			return
+		}
+		if attempt >= retries {
+			slog.Warn("notification dropped", "notifier", n.Name(), "type", event.Type, "attempts", attempt+1, "error", err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Delivery is the outcome of sending a test event to one target
+type Delivery struct {
+	Notifier string
+	Err      error
+}
+
+// SendTest posts a sample rewrite_created event to every configured URL,
+// once and synchronously, whatever the events and min_confidence of its
+// target, so a webhook can be checked before an optimization triggers it
+func SendTest(ctx context.Context, cfg config.NotifyConfig) []Delivery {
+	event := Event{
+		Type:         RewriteCreated,
+		Time:         time.Now().UTC(),
+		Digest:       "0000000000000000000000000000000000000000000000000000000000000000",
+		Confidence:   1,
+		OriginalSQL:  "SELECT * FROM orders WHERE YEAR(created_at) = 2024",
+		OptimizedSQL: "SELECT * FROM orders WHERE created_at >= '2024-01-01' AND created_at < '2025-01-01'",
+		Rationale:    "Test notification from agent test-webhook: comparing the column to a range lets TiDB use an index on created_at.",
+		Details:      map[string]any{"test": true},
+	}
+
+	var deliveries []Delivery
+	for _, n := range append(slackNotifiers(cfg.Slack), webhookNotifiers(cfg.Webhook)...) {
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		deliveries = append(deliveries, Delivery{Notifier: n.Name(), Err: n.Send(sendCtx, event)})
+		cancel()
+	}
+	return deliveries
+}
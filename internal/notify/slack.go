@@ -0,0 +1,153 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Slack limits a text object to 3000 characters; diffs are cut well below
+const (
+	maxDiffLines      = 40
+	maxDiffChars      = 2500
+	maxRationaleChars = 1000
+)
+
+// slackNotifier posts Block Kit messages to an incoming webhook
+type slackNotifier struct {
+	name     string
+	url      string
+	secret   string
+	channels map[string]string
+}
+
+func (s *slackNotifier) Name() string { return s.name }
+
+func (s *slackNotifier) Send(ctx context.Context, event Event) error {
+	return postJSON(ctx, s.url, s.secret, slackMessage(event, s.channels[event.Type]))
+}
+
+type slackPayload struct {
+	Channel string       `json:"channel,omitempty"`
+	Text    string       `json:"text"`
+	Blocks  []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type     string        `json:"type"`
+	Text     *slackText    `json:"text,omitempty"`
+	Fields   []slackText   `json:"fields,omitempty"`
+	Elements []slackButton `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackButton struct {
+	Type  string    `json:"type"`
+	Text  slackText `json:"text"`
+	URL   string    `json:"url"`
+	Style string    `json:"style,omitempty"`
+}
+
+func markdown(text string) *slackText {
+	return &slackText{Type: "mrkdwn", Text: text}
+}
+
+// slackMessage renders event; channel overrides the webhook's default when
+// not empty
+func slackMessage(event Event, channel string) slackPayload {
+	var summary string
+	var blocks []slackBlock
+
+	switch event.Type {
+	case RewriteCreated:
+		summary = fmt.Sprintf("New rewrite #%d for digest %s (confidence %.2f)", event.RewriteID, shortDigest(event.Digest), event.Confidence)
+		blocks = append(blocks,
+			slackBlock{Type: "section", Text: markdown(fmt.Sprintf("*New rewrite #%d*", event.RewriteID))},
+			slackBlock{Type: "section", Fields: []slackText{
+				{Type: "mrkdwn", Text: "*Digest*\n`" + shortDigest(event.Digest) + "`"},
+				{Type: "mrkdwn", Text: fmt.Sprintf("*Confidence*\n%.2f", event.Confidence)},
+			}},
+			slackBlock{Type: "section", Text: markdown("```\n" + sqlDiff(event.OriginalSQL, event.OptimizedSQL) + "\n```")},
+		)
+		if event.Rationale != "" {
+			blocks = append(blocks, slackBlock{Type: "section", Text: markdown("*Rationale*\n" + snippet(event.Rationale, maxRationaleChars))})
+		}
+		if event.URL != "" {
+			blocks = append(blocks, slackBlock{Type: "actions", Elements: []slackButton{
+				{Type: "button", Text: slackText{Type: "plain_text", Text: "Accept"}, URL: event.URL + "?action=accept", Style: "primary"},
+				{Type: "button", Text: slackText{Type: "plain_text", Text: "Reject"}, URL: event.URL + "?action=reject", Style: "danger"},
+			}})
+		}
+	case JobFailed:
+		summary = fmt.Sprintf("Job %s failed: %s", event.Job, event.Error)
+		blocks = append(blocks, slackBlock{Type: "section", Text: markdown(fmt.Sprintf("*Job `%s` failed*\n%s", event.Job, event.Error))})
+	default:
+		summary = strings.ReplaceAll(event.Type, "_", " ")
+		if event.Error != "" {
+			summary += ": " + event.Error
+		}
+		blocks = append(blocks, slackBlock{Type: "section", Text: markdown(summary)})
+	}
+
+	return slackPayload{Channel: channel, Text: summary, Blocks: blocks}
+}
+
+func shortDigest(digest string) string {
+	if digest == "" {
+		return "unknown"
+	}
+	if len(digest) > 16 {
+		return digest[:16]
+	}
+	return digest
+}
+
+// sqlDiff is a line diff of two statements: common leading and trailing lines
+// are context, the lines in between are shown removed and added. Long diffs
+// are truncated.
+func sqlDiff(original, optimized string) string {
+	a := strings.Split(strings.TrimSpace(original), "\n")
+	b := strings.Split(strings.TrimSpace(optimized), "\n")
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var lines []string
+	for _, line := range a[:prefix] {
+		lines = append(lines, "  "+line)
+	}
+	for _, line := range a[prefix : len(a)-suffix] {
+		lines = append(lines, "- "+line)
+	}
+	for _, line := range b[prefix : len(b)-suffix] {
+		lines = append(lines, "+ "+line)
+	}
+	for _, line := range a[len(a)-suffix:] {
+		lines = append(lines, "  "+line)
+	}
+
+	truncated := false
+	if len(lines) > maxDiffLines {
+		lines, truncated = lines[:maxDiffLines], true
+	}
+	diff := strings.Join(lines, "\n")
+	if len(diff) > maxDiffChars {
+		diff, truncated = strings.ToValidUTF8(diff[:maxDiffChars], ""), true
+	}
+	// A backtick run would close the code block early
+	diff = strings.ReplaceAll(diff, "```", "'''")
+	if truncated {
+		diff += "\n… (truncated)"
+	}
+	return diff
+}
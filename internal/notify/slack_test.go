@@ -0,0 +1,123 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// goldenRewrite is a rewrite_created event with every field set
+func goldenRewrite() Event {
+	return Event{
+		Type:         RewriteCreated,
+		Time:         time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC),
+		RewriteID:    42,
+		SlowQueryID:  7,
+		Digest:       "4bf92f3577b34da6a3ce929d0e0e4736c1e7a2b9d5f0e8a3b6c4d2e1f0a9b8c7",
+		Confidence:   0.875,
+		OriginalSQL:  "SELECT id, total\nFROM orders\nWHERE YEAR(created_at) = 2024\nORDER BY id",
+		OptimizedSQL: "SELECT id, total\nFROM orders\nWHERE created_at >= '2024-01-01' AND created_at < '2025-01-01'\nORDER BY id",
+		Rationale:    "Comparing the column to a range lets TiDB use idx_created_at.",
+		URL:          "https://latentia.example.com/rewrites/42",
+		Details:      map[string]any{"target": "shop"},
+	}
+}
+
+// checkGolden compares payload, indented, to testdata/name.golden.json
+func checkGolden(t *testing.T, name string, payload any) {
+	t.Helper()
+	got, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		t.Fatalf("failed to encode payload: %v", err)
+	}
+	got = append(got, '\n')
+
+	path := filepath.Join("testdata", name+".golden.json")
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("payload =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSlackMessageGolden(t *testing.T) {
+	minimal := goldenRewrite()
+	minimal.Digest, minimal.Rationale, minimal.URL = "", "", ""
+
+	tests := []struct {
+		name    string
+		event   Event
+		channel string
+	}{
+		{"slack_rewrite_created", goldenRewrite(), "#db-reviews"},
+		{"slack_rewrite_created_minimal", minimal, ""},
+		{"slack_job_failed", Event{Type: JobFailed, Job: "analyze", Error: "failed to connect to database: dial tcp 10.0.0.5:4000: i/o timeout"}, "#db-alerts"},
+		{"slack_budget_exceeded", Event{Type: BudgetExceeded, Error: "daily LLM budget of $5.00 reached", Details: map[string]any{"spent": 5.02}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkGolden(t, tt.name, slackMessage(tt.event, tt.channel))
+		})
+	}
+}
+
+func TestSlackMessageTruncatesLongDiff(t *testing.T) {
+	event := goldenRewrite()
+	var columns []string
+	for i := range 60 {
+		columns = append(columns, "  column_with_a_long_name_"+strings.Repeat("x", i%7))
+	}
+	event.OriginalSQL = "SELECT ```\n" + strings.Join(columns, ",\n") + "\nFROM t"
+	event.OptimizedSQL = "SELECT\n" + strings.Join(columns, ",\n") + "\nFROM t USE INDEX (idx)"
+	event.Rationale = strings.Repeat("é", maxRationaleChars)
+
+	var diff, rationale string
+	for _, b := range slackMessage(event, "").Blocks {
+		switch {
+		case b.Text != nil && strings.HasPrefix(b.Text.Text, "```"):
+			diff = b.Text.Text
+		case b.Text != nil && strings.HasPrefix(b.Text.Text, "*Rationale*"):
+			rationale = b.Text.Text
+		}
+	}
+
+	// Cut to maxDiffLines, which the fences and marker stay outside of
+	lines := strings.Split(strings.TrimSuffix(strings.TrimPrefix(diff, "```\n"), "\n```"), "\n")
+	if len(lines) != maxDiffLines+1 || lines[len(lines)-1] != "… (truncated)" {
+		t.Errorf("diff of %d lines ending %q, want %d and the truncation marker", len(lines), lines[len(lines)-1], maxDiffLines)
+	}
+	if strings.Count(diff, "```") != 2 {
+		t.Errorf("backticks of the SQL close the code block early:\n%s", diff)
+	}
+	if len(diff) > 3000 || len(rationale) > 3000 {
+		t.Errorf("text objects of %d and %d characters, over Slack's limit", len(diff), len(rationale))
+	}
+	if !strings.HasSuffix(rationale, "…") || strings.Contains(rationale, "�") {
+		t.Errorf("rationale not cut on a character boundary: %q", rationale[len(rationale)-10:])
+	}
+}
+
+func TestSQLDiff(t *testing.T) {
+	got := sqlDiff("SELECT id\nFROM t\nWHERE a = 1\nLIMIT 1", "SELECT id\nFROM t\nWHERE b = 2\nAND c = 3\nLIMIT 1")
+	want := "  SELECT id\n  FROM t\n- WHERE a = 1\n+ WHERE b = 2\n+ AND c = 3\n  LIMIT 1"
+	if got != want {
+		t.Errorf("sqlDiff =\n%s\nwant\n%s", got, want)
+	}
+	if got := sqlDiff("SELECT 1", "SELECT 1"); got != "  SELECT 1" {
+		t.Errorf("sqlDiff of equal statements = %q", got)
+	}
+}
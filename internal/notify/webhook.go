@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxSnippetChars bounds the SQL and rationale of a webhook payload, so a
+// huge generated statement does not make a huge request
+const maxSnippetChars = 4000
+
+// signatureHeader carries "sha256=" and the hex HMAC-SHA256 of the request
+// body, keyed with the target's secret
+const signatureHeader = "X-Latentia-Signature"
+
+// webhookNotifier posts the event as JSON
+type webhookNotifier struct {
+	name   string
+	url    string
+	secret string
+}
+
+func (w *webhookNotifier) Name() string { return w.name }
+
+func (w *webhookNotifier) Send(ctx context.Context, event Event) error {
+	event.OriginalSQL = snippet(event.OriginalSQL, maxSnippetChars)
+	event.OptimizedSQL = snippet(event.OptimizedSQL, maxSnippetChars)
+	event.Rationale = snippet(event.Rationale, maxSnippetChars)
+	return postJSON(ctx, w.url, w.secret, event)
+}
+
+// snippet cuts text to at most max bytes, marking that it did
+func snippet(text string, max int) string {
+	if len(text) <= max {
+		return text
+	}
+	return strings.ToValidUTF8(text[:max], "") + "…"
+}
+
+// sign returns the signatureHeader value of body
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// postJSON posts payload, signed when secret is not empty
+func postJSON(ctx context.Context, url, secret string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(signatureHeader, sign(secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// The error embeds the URL, which carries the webhook's secret
+		return fmt.Errorf("request failed: %w", redactURL(err, url))
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type redactedError struct{ msg string }
+
+func (e redactedError) Error() string { return e.msg }
+
+func redactURL(err error, url string) error {
+	return redactedError{strings.ReplaceAll(err.Error(), url, "<webhook_url>")}
+}
+
+func rewriteURL(uiURL string, id int64) string {
+	return fmt.Sprintf("%s/rewrites/%d", strings.TrimRight(uiURL, "/"), id)
+}
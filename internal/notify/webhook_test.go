@@ -0,0 +1,128 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// receiver records the requests of a webhook target
+type receiver struct {
+	status  int
+	bodies  [][]byte
+	headers []http.Header
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.bodies = append(r.bodies, body)
+	r.headers = append(r.headers, req.Header.Clone())
+	if r.status != 0 {
+		w.WriteHeader(r.status)
+	}
+}
+
+func serveReceiver(t *testing.T, status int) (*receiver, string) {
+	t.Helper()
+	r := &receiver{status: status}
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return r, srv.URL
+}
+
+// received checks a request body is JSON, keeping its field order for the
+// comparison with a golden file
+func received(t *testing.T, body []byte) json.RawMessage {
+	t.Helper()
+	if !json.Valid(body) {
+		t.Fatalf("body is not JSON:\n%s", body)
+	}
+	return body
+}
+
+func TestWebhookPayloadGolden(t *testing.T) {
+	r, url := serveReceiver(t, http.StatusOK)
+	w := &webhookNotifier{name: "webhook", url: url}
+
+	if err := w.Send(context.Background(), goldenRewrite()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := w.Send(context.Background(), Event{Type: JobFailed, Time: goldenRewrite().Time, Job: "ingest", Error: "slow log not readable"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	checkGolden(t, "webhook_rewrite_created", received(t, r.bodies[0]))
+	checkGolden(t, "webhook_job_failed", received(t, r.bodies[1]))
+
+	if got := r.headers[0].Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := r.headers[0].Get(signatureHeader); got != "" {
+		t.Errorf("unsigned target sent %s: %q", signatureHeader, got)
+	}
+}
+
+func TestSlackPayloadSent(t *testing.T) {
+	r, url := serveReceiver(t, http.StatusOK)
+	s := &slackNotifier{name: "slack", url: url, channels: map[string]string{RewriteCreated: "#db-reviews"}}
+
+	if err := s.Send(context.Background(), goldenRewrite()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	// What goes on the wire is the golden message, in the event's channel
+	checkGolden(t, "slack_rewrite_created", received(t, r.bodies[0]))
+}
+
+func TestWebhookSignsBody(t *testing.T) {
+	r, url := serveReceiver(t, http.StatusOK)
+	w := &webhookNotifier{name: "webhook", url: url, secret: "s3cret"}
+	if err := w.Send(context.Background(), goldenRewrite()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	got := r.headers[0].Get(signatureHeader)
+	if want := sign("s3cret", r.bodies[0]); got != want || !strings.HasPrefix(got, "sha256=") {
+		t.Errorf("%s = %q, want %q", signatureHeader, got, want)
+	}
+	// The HMAC-SHA256 of a known body, as a receiver computes it
+	if got, want := sign("key", []byte(`{"type":"job_failed"}`)), "sha256=2a6a2989c4a61363be223dae89938300587ff6fe131317e935baa35404f9ff98"; got != want {
+		t.Errorf("sign = %q, want %q", got, want)
+	}
+}
+
+func TestWebhookTruncatesSnippets(t *testing.T) {
+	r, url := serveReceiver(t, http.StatusOK)
+	w := &webhookNotifier{name: "webhook", url: url}
+	event := goldenRewrite()
+	event.OptimizedSQL = "SELECT '" + strings.Repeat("ü", maxSnippetChars) + "'"
+	if err := w.Send(context.Background(), event); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	var sent Event
+	if err := json.Unmarshal(r.bodies[0], &sent); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent.OptimizedSQL) > maxSnippetChars+len("…") || !strings.HasSuffix(sent.OptimizedSQL, "…") {
+		t.Errorf("optimized SQL of %d bytes, want it cut to %d", len(sent.OptimizedSQL), maxSnippetChars)
+	}
+	if sent.OriginalSQL != event.OriginalSQL {
+		t.Errorf("short SQL changed to %q", sent.OriginalSQL)
+	}
+}
+
+func TestWebhookErrorsHideURL(t *testing.T) {
+	_, url := serveReceiver(t, http.StatusInternalServerError)
+	err := (&webhookNotifier{url: url + "/T000/B000/secret"}).Send(context.Background(), goldenRewrite())
+	if err == nil || err.Error() != "webhook returned status 500" {
+		t.Errorf("Send = %v, want the status", err)
+	}
+
+	err = (&webhookNotifier{url: "http://127.0.0.1:1/hooks/secret-token"}).Send(context.Background(), goldenRewrite())
+	if err == nil || strings.Contains(err.Error(), "secret-token") || !strings.Contains(err.Error(), "<webhook_url>") {
+		t.Errorf("Send to an unreachable URL = %v, want the URL redacted", err)
+	}
+}
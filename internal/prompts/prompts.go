@@ -0,0 +1,377 @@
+// Package prompts renders the optimization prompt from text/template files.
+//
+// The defaults are embedded from templates/. Setting llm.templates_dir
+// overrides them file by file: a system.tmpl in that directory replaces the
+// embedded system.tmpl, and any other *.tmpl files are parsed as well so they
+// can redefine individual sections.
+//
+// Each prompt section is a named template executed with Data:
+//
+//	system        role and task description
+//	analysis      .Pattern (Type, Complexity, Tables, AntiPatterns, OptimizationOps, Keywords,
+//	              FilterFunctions, HighSeverity)
+//	query         .SQL
+//	schema        .Schema, table DDL when available
+//	plan          .Plan, EXPLAIN ANALYZE output captured with the slow query
+//	knowledge     .Context, RAG results (Document, Category, Text, URL)
+//	examples      .Examples, few-shot pairs (SQL, OptimizedSQL, Rationale)
+//	feedback      .Feedback and .PreviousSQL when re-optimizing
+//	instructions  closing instructions; mentions placeholders when .Redacted
+//	format        response format; format_json is used instead when .JSONMode.
+//	              Both ask for CREATE INDEX statements when the pattern has
+//	              index-where-columns or index-join-columns opportunities.
+//	focus         pattern-specific guidance
+//
+// Sections that render empty are left out of the prompt. When .Concise is
+// set, a section named with a _concise suffix, such as instructions_concise,
+// is used instead of the section where it is defined; concise.tmpl defines
+// the shorter variants used under llm.prompt_style "concise".
+package prompts
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var defaultFS embed.FS
+
+// DefaultName identifies the embedded templates in rewrite metadata
+const DefaultName = "default"
+
+// Version is the version of the embedded templates. Bump it whenever their
+// instructions change, so rewrites generated before and after compare apart.
+const Version = 1
+
+// Prompt styles of llm.prompt_style
+const (
+	StyleDetailed = "detailed"
+	StyleConcise  = "concise"
+)
+
+// Styles lists the prompt styles
+var Styles = []string{StyleDetailed, StyleConcise}
+
+// instructionSections are the sections whose text does not come from the
+// query; their rendering identifies a prompt version
+var instructionSections = []string{"system", "instructions", "format", "format_json", "focus"}
+
+// queryTypes are the pattern types the focus section tells apart
+var queryTypes = []string{
+	"basic-select", "filtered-select", "full-select", "simple-join", "complex-join",
+	"aggregation", "pattern-search", "update", "delete", "insert", "sleep-test",
+}
+
+// maxVersionName caps the templates name in a prompt version
+const maxVersionName = 64
+
+// Sections lists the section templates in prompt order
+var Sections = []string{
+	"system", "analysis", "query", "schema", "plan", "knowledge",
+	"examples", "feedback", "instructions", "format", "focus",
+}
+
+// Pattern mirrors analyze.QueryPattern for templates
+type Pattern struct {
+	Type            string
+	Complexity      string
+	Tables          []string
+	AntiPatterns    []string
+	OptimizationOps []string
+	Keywords        []string
+	FilterFunctions []string
+	// HighSeverity lists the anti-patterns that make the statement
+	// dangerous, such as missing-where
+	HighSeverity []string
+}
+
+// Doc is one retrieved documentation chunk
+type Doc struct {
+	Document string
+	Category string
+	Text     string
+	URL      string
+}
+
+// Example is a few-shot rewrite shown to the model
+type Example struct {
+	SQL          string
+	OptimizedSQL string
+	Rationale    string
+}
+
+// Data holds every variable available to the templates
+type Data struct {
+	SQL         string
+	Pattern     Pattern
+	Schema      string
+	Plan        string
+	Context     []Doc
+	Examples    []Example
+	Feedback    string
+	PreviousSQL string
+	JSONMode    bool
+
+	// Concise selects the _concise variants of the sections that have one
+	Concise bool
+
+	// Redacted is set when literals in SQL were replaced by placeholders
+	Redacted bool
+
+	// Anonymized is set when identifiers in SQL were replaced by aliases
+	Anonymized bool
+}
+
+// Section is one rendered block of the prompt
+type Section struct {
+	Name    string
+	Content string
+}
+
+// Set is a parsed template set
+type Set struct {
+	name     string
+	hash     string
+	tmpl     *template.Template
+	versions map[string]string // by style
+}
+
+var funcs = template.FuncMap{
+	"join": strings.Join,
+	"inc":  func(i int) int { return i + 1 },
+	"has": func(list []string, item string) bool {
+		for _, s := range list {
+			if s == item {
+				return true
+			}
+		}
+		return false
+	},
+}
+
+// Load parses the embedded templates, overridden by the *.tmpl files in dir
+// when dir is not empty. Every section is rendered once with sample data so
+// template errors surface at load time rather than at the first optimization.
+func Load(dir string) (*Set, error) {
+	defaults, err := readTemplates(defaultFS, "templates")
+	if err != nil {
+		return nil, err
+	}
+
+	name := DefaultName
+	var overrides []source
+	if dir != "" {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, fmt.Errorf("templates directory: %w", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("templates directory %s is not a directory", dir)
+		}
+		if overrides, err = readTemplates(os.DirFS(dir), "."); err != nil {
+			return nil, err
+		}
+		name = filepath.Base(filepath.Clean(dir))
+	}
+
+	// Defaults first so overrides redefine their sections; a file with the
+	// same name replaces the default entirely
+	var files []source
+	for _, def := range defaults {
+		if !containsFile(overrides, def.file) {
+			files = append(files, def)
+		}
+	}
+	files = append(files, overrides...)
+
+	tmpl := template.New("prompt").Funcs(funcs)
+	hash := sha256.New()
+	for _, src := range files {
+		if _, err := tmpl.New(src.file).Parse(src.text); err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %w", src.file, err)
+		}
+		fmt.Fprintf(hash, "%s\x00%s\x00", src.file, src.text)
+	}
+
+	set := &Set{name: name, hash: hex.EncodeToString(hash.Sum(nil))[:12], tmpl: tmpl}
+	for _, section := range append(Sections, "format_json") {
+		if tmpl.Lookup(section) == nil {
+			return nil, fmt.Errorf("template %q is not defined", section)
+		}
+	}
+	for _, data := range []Data{sampleData(false, false), sampleData(true, false), sampleData(false, true), sampleData(true, true)} {
+		if _, err := set.Render(data); err != nil {
+			return nil, err
+		}
+	}
+
+	set.versions = make(map[string]string, len(Styles))
+	for _, style := range Styles {
+		hash, err := set.instructionHash(style == StyleConcise)
+		if err != nil {
+			return nil, err
+		}
+		set.versions[style] = fmt.Sprintf("%s.v%d.%s.%s", truncateName(name), Version, style, hash)
+	}
+	return set, nil
+}
+
+// MustDefault returns the embedded templates, which are known to be valid
+func MustDefault() *Set {
+	set, err := Load("")
+	if err != nil {
+		panic(err)
+	}
+	return set
+}
+
+type source struct {
+	file string
+	text string
+}
+
+// readTemplates returns the *.tmpl files of dir sorted by name
+func readTemplates(fsys fs.FS, dir string) ([]source, error) {
+	matches, err := fs.Glob(fsys, path.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+
+	sources := make([]source, 0, len(matches))
+	for _, match := range matches {
+		data, err := fs.ReadFile(fsys, match)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template %s: %w", match, err)
+		}
+		sources = append(sources, source{file: path.Base(match), text: string(data)})
+	}
+	return sources, nil
+}
+
+func containsFile(sources []source, file string) bool {
+	for _, src := range sources {
+		if src.file == file {
+			return true
+		}
+	}
+	return false
+}
+
+// Name is "default" for the embedded templates, otherwise the base name of
+// the templates directory
+func (s *Set) Name() string {
+	return s.name
+}
+
+// Hash identifies the exact template sources, so results stay attributable
+// to a prompt version
+func (s *Set) Hash() string {
+	return s.hash
+}
+
+// Version identifies the templates of a style: their name, Version and a
+// hash of their instruction sections rendered for every query type. It
+// stays the same across queries and only changes with the instructions.
+func (s *Set) Version(style string) string {
+	if version, ok := s.versions[style]; ok {
+		return version
+	}
+	return s.versions[StyleDetailed]
+}
+
+// Render executes every section with data, skipping empty ones
+func (s *Set) Render(data Data) ([]Section, error) {
+	var sections []Section
+	for _, name := range Sections {
+		content, err := s.execute(name, data)
+		if err != nil {
+			return nil, err
+		}
+		if content != "" {
+			sections = append(sections, Section{Name: name, Content: content})
+		}
+	}
+	return sections, nil
+}
+
+// execute renders section name with data, using format_json in JSON mode
+// and the _concise variant of the section when data.Concise and defined
+func (s *Set) execute(name string, data Data) (string, error) {
+	tmplName := name
+	if name == "format" && data.JSONMode {
+		tmplName = "format_json"
+	}
+	if data.Concise && s.tmpl.Lookup(tmplName+"_concise") != nil {
+		tmplName += "_concise"
+	}
+
+	var b strings.Builder
+	if err := s.tmpl.ExecuteTemplate(&b, tmplName, data); err != nil {
+		return "", fmt.Errorf("failed to render %s section: %w", name, err)
+	}
+	return b.String(), nil
+}
+
+// instructionHash hashes the instruction sections rendered for each query
+// type with redaction and anonymization on
+func (s *Set) instructionHash(concise bool) (string, error) {
+	hash := sha256.New()
+	for _, queryType := range queryTypes {
+		data := sampleData(false, concise)
+		data.Pattern.Type = queryType
+		data.Anonymized = true
+		for _, name := range instructionSections {
+			content, err := s.execute(name, data)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(hash, "%s\x00%s\x00", name, content)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:12], nil
+}
+
+// truncateName keeps prompt versions short whatever the templates directory
+// is called
+func truncateName(name string) string {
+	if len(name) > maxVersionName {
+		return name[:maxVersionName]
+	}
+	return name
+}
+
+// sampleData fills every variable so a dry run exercises all branches
+func sampleData(jsonMode, concise bool) Data {
+	return Data{
+		SQL: "SELECT * FROM orders WHERE customer_id = 1",
+		Pattern: Pattern{
+			Type:            "simple-join",
+			Complexity:      "simple",
+			Tables:          []string{"orders"},
+			AntiPatterns:    []string{"select-star"},
+			OptimizationOps: []string{"index-column", "index-where-columns"},
+			Keywords:        []string{"WHERE"},
+			FilterFunctions: []string{"DATE"},
+			HighSeverity:    []string{"missing-where"},
+		},
+		Schema:      "CREATE TABLE orders (id BIGINT PRIMARY KEY)",
+		Plan:        "id | estRows | actRows | task\nTableReader_5 | 10000.00 | 9876 | root\n",
+		Context:     []Doc{{Document: "doc", Category: "category", Text: "text", URL: "https://example.com"}},
+		Examples:    []Example{{SQL: "SELECT 1", OptimizedSQL: "SELECT 1", Rationale: "example"}},
+		Feedback:    "feedback",
+		PreviousSQL: "SELECT 1",
+		JSONMode:    jsonMode,
+		Concise:     concise,
+		Redacted:    true,
+	}
+}
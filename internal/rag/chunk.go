@@ -0,0 +1,195 @@
+package rag
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Chunking splits documents into chunks of about Words words, each starting
+// with the last Overlap sentences of the previous one. Words approximate
+// tokens closely enough to size chunks for an embedder.
+type Chunking struct {
+	Words   int
+	Overlap int
+}
+
+// key identifies the chunking in document hashes, so changing it embeds
+// documents again
+func (c Chunking) key() string {
+	return fmt.Sprintf("sentences/%d/%d", c.Words, c.Overlap)
+}
+
+// unit is a sentence, a line of a list or a whole code block: the pieces
+// chunks are made of, never split unless a sentence alone is over budget
+type unit struct {
+	text  string
+	words int
+	// sep joins the unit to the previous one in a chunk: a space between
+	// sentences, a newline between lines, a blank line between paragraphs
+	sep  string
+	code bool
+}
+
+// chunkText splits text on paragraph, line and sentence boundaries into
+// chunks of about c.Words words. Fenced code blocks are never split, even
+// when longer than a chunk, and a sentence longer than a chunk is split
+// between words.
+func chunkText(text string, c Chunking) []string {
+	if c.Words <= 0 {
+		c.Words = 1
+	}
+	units := splitUnits(text, c.Words)
+	if len(units) == 0 {
+		return nil
+	}
+
+	var chunks []string
+	var current []unit
+	var words int
+	flush := func() {
+		var b strings.Builder
+		for i, u := range current {
+			if i > 0 {
+				b.WriteString(u.sep)
+			}
+			b.WriteString(u.text)
+		}
+		chunks = append(chunks, b.String())
+	}
+
+	for i := 0; i < len(units); i++ {
+		u := units[i]
+		if len(current) == 0 || words+u.words <= c.Words {
+			current = append(current, u)
+			words += u.words
+			continue
+		}
+		flush()
+
+		// Carry the last sentences over, as long as they leave room for
+		// new text; code blocks are too large to repeat
+		var overlap []unit
+		var overlapWords int
+		for j := len(current) - 1; j >= 0 && len(overlap) < c.Overlap; j-- {
+			if current[j].code || overlapWords+current[j].words+u.words > c.Words {
+				break
+			}
+			overlap = append([]unit{current[j]}, overlap...)
+			overlapWords += current[j].words
+		}
+		current = append(overlap, u)
+		words = overlapWords + u.words
+	}
+	flush()
+	return chunks
+}
+
+// splitUnits splits text into paragraphs, fenced code blocks, lines and
+// sentences. Sentences of more than maxWords words are split between words.
+func splitUnits(text string, maxWords int) []unit {
+	var units []unit
+	sep := "\n\n"
+	add := func(text, unitSep string, code bool) {
+		if len(units) == 0 {
+			unitSep = ""
+		}
+		units = append(units, unit{text: text, words: len(strings.Fields(text)), sep: unitSep, code: code})
+	}
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		if strings.TrimSpace(line) == "" {
+			sep = "\n\n"
+			continue
+		}
+
+		// A fenced code block, up to its closing fence or the end of text
+		if fence := strings.TrimSpace(line); strings.HasPrefix(fence, "```") || strings.HasPrefix(fence, "~~~") {
+			marker := fence[:3]
+			block := []string{line}
+			for i+1 < len(lines) {
+				i++
+				block = append(block, strings.TrimRight(lines[i], " \t"))
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), marker) {
+					break
+				}
+			}
+			add(strings.Join(block, "\n"), "\n\n", true)
+			sep = "\n\n"
+			continue
+		}
+
+		// Indentation and list markers stay with the first sentence of
+		// their line
+		for j, sentence := range splitSentences(line) {
+			unitSep := " "
+			if j == 0 {
+				unitSep = sep
+			}
+			for k, piece := range splitWords(sentence, maxWords) {
+				if k > 0 {
+					unitSep = " "
+				}
+				add(piece, unitSep, false)
+			}
+		}
+		sep = "\n"
+	}
+	return units
+}
+
+// splitSentences splits line after each period, question or exclamation
+// mark followed by a space and an upper case letter, a digit or an opening
+// quote or parenthesis, so decimals, abbreviations such as "e.g." and
+// identifiers such as t.id stay whole, as do the numbers of ordered lists
+func splitSentences(line string) []string {
+	var sentences []string
+	start := 0
+	for i := 0; i+2 < len(line); i++ {
+		switch line[i] {
+		case '.', '?', '!':
+		default:
+			continue
+		}
+		if line[i+1] != ' ' || isListNumber(line[start:i]) {
+			continue
+		}
+		next := line[i+2]
+		if (next >= 'A' && next <= 'Z') || (next >= '0' && next <= '9') || next == '"' || next == '(' || next == '`' {
+			sentences = append(sentences, line[start:i+1])
+			start = i + 2
+		}
+	}
+	return append(sentences, line[start:])
+}
+
+// isListNumber reports whether text is the indentation and number of an
+// ordered list item, such as "  2"
+func isListNumber(text string) bool {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return false
+	}
+	for _, r := range text {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// splitWords splits a sentence of more than maxWords words into pieces of
+// maxWords words
+func splitWords(sentence string, maxWords int) []string {
+	words := strings.Fields(sentence)
+	if len(words) <= maxWords {
+		return []string{sentence}
+	}
+	var pieces []string
+	for len(words) > maxWords {
+		pieces = append(pieces, strings.Join(words[:maxWords], " "))
+		words = words[maxWords:]
+	}
+	return append(pieces, strings.Join(words, " "))
+}
@@ -0,0 +1,213 @@
+package rag
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// countingEmbedder embeds every text as its length, counting its requests
+type countingEmbedder struct {
+	requests int
+}
+
+func (e *countingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.requests++
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = []float32{float32(len(text))}
+	}
+	return vectors, nil
+}
+
+func (e *countingEmbedder) Dim() int      { return 1 }
+func (e *countingEmbedder) Model() string { return "counting" }
+
+func TestChunkTextBoundaries(t *testing.T) {
+	const text = "One two three. Four five six. Seven eight nine.\n\nTen eleven."
+	tests := []struct {
+		name     string
+		chunking Chunking
+		want     []string
+	}{
+		{
+			"overlap of one sentence",
+			Chunking{Words: 6, Overlap: 1},
+			[]string{"One two three. Four five six.", "Four five six. Seven eight nine.", "Seven eight nine.\n\nTen eleven."},
+		},
+		{
+			"no overlap",
+			Chunking{Words: 6},
+			[]string{"One two three. Four five six.", "Seven eight nine.\n\nTen eleven."},
+		},
+		{
+			"overlap leaving no room is dropped",
+			Chunking{Words: 4, Overlap: 1},
+			[]string{"One two three.", "Four five six.", "Seven eight nine.", "Ten eleven."},
+		},
+		{
+			"overlap of more sentences than there are",
+			Chunking{Words: 9, Overlap: 5},
+			[]string{"One two three. Four five six. Seven eight nine.", "Four five six. Seven eight nine.\n\nTen eleven."},
+		},
+		{
+			"whole text in one chunk",
+			Chunking{Words: 100, Overlap: 1},
+			[]string{text},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chunkText(text, tt.chunking); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunkText =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkTextEmpty(t *testing.T) {
+	for _, text := range []string{"", "   ", "\n\n\t\n", "\r\n\r\n"} {
+		if got := chunkText(text, Chunking{Words: 80, Overlap: 1}); got != nil {
+			t.Errorf("chunkText(%q) = %q, want no chunks", text, got)
+		}
+	}
+	// A chunking of no words still makes progress, a word at a time
+	if got, want := chunkText("a b", Chunking{}), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("chunkText with no words = %q, want %q", got, want)
+	}
+}
+
+func TestChunkTextKeepsCodeBlocks(t *testing.T) {
+	code := "```sql\nSELECT a, b, c, d\nFROM t\n```"
+	text := "Intro text here.\n\n" + code + "\n\nAfter it."
+
+	// The block is longer than a chunk but kept whole, and not repeated as
+	// overlap
+	got := chunkText(text, Chunking{Words: 3, Overlap: 1})
+	want := []string{"Intro text here.", code, "After it."}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("chunkText =\n%q\nwant\n%q", got, want)
+	}
+
+	// An unclosed fence runs to the end of the text
+	got = chunkText("Before.\n\n~~~\nSELECT 1\n\nSELECT 2", Chunking{Words: 2})
+	want = []string{"Before.", "~~~\nSELECT 1\n\nSELECT 2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("chunkText with an unclosed fence =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestChunkTextSplitsLongSentence(t *testing.T) {
+	got := chunkText("a b c d e f g.", Chunking{Words: 3, Overlap: 1})
+	want := []string{"a b c", "d e f", "g."}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("chunkText = %q, want %q", got, want)
+	}
+}
+
+func TestSplitSentences(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"Use e.g. an index on t.id. It takes 3.5 s. 2 runs!", []string{"Use e.g. an index on t.id.", "It takes 3.5 s.", "2 runs!"}},
+		{"1. Index Usage: Ensure indexes. Use EXPLAIN.", []string{"1. Index Usage: Ensure indexes.", "Use EXPLAIN."}},
+		{"  12. Twelfth item", []string{"  12. Twelfth item"}},
+		{`Why? "Quoted" next. (Aside) here.`, []string{"Why?", `"Quoted" next.`, "(Aside) here."}},
+		{"No boundary at the end.", []string{"No boundary at the end."}},
+	}
+	for _, tt := range tests {
+		if got := splitSentences(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitSentences(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestChunkTextMultibyte(t *testing.T) {
+	text := "Les index composés accélèrent les requêtes multi-colonnes. Évitez les fonctions — sur les colonnes indexées."
+	for _, chunk := range chunkText(text, Chunking{Words: 2}) {
+		if !utf8.ValidString(chunk) {
+			t.Errorf("chunk %q is not valid UTF-8", chunk)
+		}
+	}
+}
+
+func TestChunkSeededDocs(t *testing.T) {
+	docs := tidbOptimizationDocs()
+	embedder := &countingEmbedder{}
+	ds := &DocumentStore{embedder: embedder, chunking: Chunking{Words: 80, Overlap: 1}, batchSize: 3}
+	for _, chunking := range []Chunking{ds.chunking, {Words: 10, Overlap: 2}, {Words: 25}} {
+		for _, doc := range docs {
+			chunks := chunkText(doc.Content, chunking)
+			if len(chunks) == 0 {
+				t.Fatalf("%s: no chunks", doc.Title)
+			}
+
+			var words []string
+			for _, chunk := range chunks {
+				if !utf8.ValidString(chunk) {
+					t.Errorf("%s: chunk %q is not valid UTF-8", doc.Title, chunk)
+				}
+				// The first word of a chunk is a whole word of the document
+				first := strings.Fields(chunk)[0]
+				if !strings.HasPrefix(doc.Content, first) && !strings.Contains(doc.Content, " "+first) && !strings.Contains(doc.Content, "\n"+first) {
+					t.Errorf("%s: chunk starts mid-word: %q", doc.Title, chunk)
+				}
+				if n := len(strings.Fields(chunk)); n > chunking.Words && !strings.HasPrefix(chunk, "```") {
+					t.Errorf("%s: chunk of %d words, over %d: %q", doc.Title, n, chunking.Words, chunk)
+				}
+				words = append(words, strings.Fields(chunk)...)
+			}
+			// Every word of the document is in a chunk, in order
+			if !isSubsequence(strings.Fields(doc.Content), words) {
+				t.Errorf("%s: chunks %q lose words of the document", doc.Title, chunks)
+			}
+
+			embedder.requests = 0
+			embeddings, err := ds.embedChunks(context.Background(), chunks)
+			if err != nil {
+				t.Fatalf("embedChunks: %v", err)
+			}
+			if len(embeddings) != len(chunks) {
+				t.Errorf("%s: %d embeddings for %d chunks", doc.Title, len(embeddings), len(chunks))
+			}
+			for i, vector := range embeddings {
+				if vector[0] != float32(len(chunks[i])) {
+					t.Errorf("%s: embedding %d is not that of chunk %d", doc.Title, i, i)
+				}
+			}
+			if want := (len(chunks) + 2) / 3; embedder.requests != want {
+				t.Errorf("%s: %d requests for %d chunks in batches of 3, want %d", doc.Title, embedder.requests, len(chunks), want)
+			}
+		}
+	}
+}
+
+// isSubsequence reports whether sub appears in order within all
+func isSubsequence(sub, all []string) bool {
+	i := 0
+	for _, w := range all {
+		if i < len(sub) && w == sub[i] {
+			i++
+		}
+	}
+	return i == len(sub)
+}
+
+// shortEmbedder drops the last embedding of every batch
+type shortEmbedder struct{ countingEmbedder }
+
+func (e *shortEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors, err := e.countingEmbedder.Embed(ctx, texts)
+	return vectors[:len(vectors)-1], err
+}
+
+func TestEmbedChunksRefusesMissingEmbeddings(t *testing.T) {
+	ds := &DocumentStore{embedder: &shortEmbedder{}, batchSize: 3}
+	_, err := ds.embedChunks(context.Background(), []string{"a", "b", "c", "d"})
+	if err == nil || err.Error() != "embedder returned 2 embeddings for 3 chunks" {
+		t.Errorf("embedChunks = %v, want the count mismatch", err)
+	}
+}
@@ -2,9 +2,12 @@ package rag
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
-	"encoding/json"
+	"log/slog"
+	"time"
 
+	"github.com/matthieukhl/latentia/internal/config"
 	"github.com/matthieukhl/latentia/internal/database"
 	"github.com/matthieukhl/latentia/internal/types"
 )
@@ -12,6 +15,10 @@ import (
 type DocumentStore struct {
 	db       *database.DB
 	embedder types.Embedder
+	chunking Chunking
+
+	// batchSize is the most chunks embedded per request
+	batchSize int
 }
 
 type Document struct {
@@ -20,6 +27,13 @@ type Document struct {
 	Content  string `json:"content" db:"content"`
 	Category string `json:"category" db:"category"`
 	URL      string `json:"url" db:"url"`
+
+	// ContentHash identifies the stored version, so re-adding an unchanged
+	// document does not embed it again
+	ContentHash string `json:"content_hash,omitempty" db:"content_hash"`
+
+	// Chunks is the number of embedded chunks, set by ListDocuments
+	Chunks int `json:"chunks" db:"-"`
 }
 
 type DocumentChunk struct {
@@ -39,16 +53,42 @@ type SearchResult struct {
 	URL        string  `json:"url"`
 }
 
+// CheckDimension fails when the embeddings table was created for another
+// embedding size than the embedder's
+func (ds *DocumentStore) CheckDimension(ctx context.Context) error {
+	return ds.db.CheckEmbeddingDimension(ctx, ds.embedder.Dim())
+}
+
+// vectorType is the column type the embedder's vectors are cast to
+func (ds *DocumentStore) vectorType() string {
+	return fmt.Sprintf("VECTOR(%d)", ds.embedder.Dim())
+}
+
 func NewDocumentStore(db *database.DB, embedder types.Embedder) *DocumentStore {
+	vector := config.Current().Vector
 	return &DocumentStore{
-		db:       db,
-		embedder: embedder,
+		db:        db,
+		embedder:  embedder,
+		chunking:  Chunking{Words: vector.ChunkWords, Overlap: vector.ChunkOverlap},
+		batchSize: vector.EmbedBatchSize,
 	}
 }
 
 // SeedTiDBOptimizationDocs adds curated TiDB optimization documentation
-func (ds *DocumentStore) SeedTiDBOptimizationDocs() error {
-	docs := []Document{
+func (ds *DocumentStore) SeedTiDBOptimizationDocs(ctx context.Context) error {
+	for _, doc := range tidbOptimizationDocs() {
+		_, err := ds.addDocument(ctx, doc)
+		if err != nil {
+			return fmt.Errorf("failed to add document %s: %w", doc.Title, err)
+		}
+	}
+	
+	return nil
+}
+
+// tidbOptimizationDocs is the curated documentation of seed-docs
+func tidbOptimizationDocs() []Document {
+	return []Document{
 		{
 			Title:    "TiDB Query Performance Optimization",
 			Category: "performance",
@@ -188,181 +228,130 @@ func (ds *DocumentStore) SeedTiDBOptimizationDocs() error {
    - USE_TOJA(boolean): Control subquery optimization
    - TIDB_SMJ(table_names): Force sort merge join`,
 		},
+		{
+			Title:    "TiDB Predicates That Prevent Index Use",
+			Category: "indexes",
+			URL:      "https://docs.pingcap.com/tidb/stable/choose-index",
+			Content: `Predicates that keep TiDB from using an index:
+
+1. Functions on Indexed Columns:
+   - DATE(created_at) = '2024-01-01' cannot use an index on created_at
+   - Rewrite as a range: created_at >= '2024-01-01' AND created_at < '2024-01-02'
+   - LOWER(email) = ... or other unavoidable expressions need an expression index
+
+2. Implicit Type Conversion:
+   - Comparing a VARCHAR column to a number (phone = 123) converts every row
+   - Quote the literal to match the column type: phone = '123'
+   - Compare join columns of the same type and collation
+
+3. OR Across Different Columns:
+   - a = 1 OR b = 2 cannot be served by a single index range
+   - IndexMerge can combine indexes on a and b (USE_INDEX_MERGE hint)
+   - Or rewrite as UNION ALL of one query per column, excluding duplicates
+   - OR on the same column is better written as IN (...)`,
+		},
+		{
+			Title:    "TiDB UPDATE and DELETE Best Practices",
+			Category: "dml",
+			URL:      "https://docs.pingcap.com/tidb/stable/dev-guide-delete-data",
+			Content: `Writing UPDATE and DELETE statements for TiDB:
+
+1. Locating Rows:
+   - Index the WHERE columns so the rows to change are found with an index range
+   - UPDATE or DELETE without WHERE touches every row; confirm it is intended
+   - Prefer joins on indexed columns over correlated subqueries in the WHERE
+
+2. Batching Large Changes:
+   - Large transactions hit the transaction size limit and hold locks for long
+   - Delete or update in batches by primary key range, a few thousand rows each
+   - Use non-transactional DML (BATCH ON id LIMIT 1000 DELETE ...) for bulk changes
+   - Use TTL tables instead of periodic DELETE of expired rows
+
+3. Safety:
+   - A rewrite must change exactly the same rows in the same way
+   - Check the rows affected with an equivalent SELECT before running it`,
+		},
 	}
-	
-	for _, doc := range docs {
-		err := ds.addDocument(doc)
-		if err != nil {
-			return fmt.Errorf("failed to add document %s: %w", doc.Title, err)
-		}
-	}
-	
-	return nil
 }
 
-func (ds *DocumentStore) addDocument(doc Document) error {
+// addDocument stores doc and embeds its chunks, replacing the document of
+// the same title. It reports false, and leaves everything as is, when the
+// stored version has the same hash and still has its embeddings. The chunks
+// are embedded first and the document written with its embeddings in one
+// transaction, so a failure leaves the previous version in place.
+func (ds *DocumentStore) addDocument(ctx context.Context, doc Document) (bool, error) {
+	if doc.ContentHash == "" {
+		doc.ContentHash = doc.hash(ds.chunking)
+	}
+	
 	// First, check if document exists
 	var docID int64
-	err := ds.db.QueryRow(`
-		SELECT id FROM app_documents WHERE title = ?
-	`, doc.Title).Scan(&docID)
+	var storedHash sql.NullString
+	err := ds.db.QueryRowContext(ctx, `
+		SELECT id, content_hash FROM app_documents WHERE title = ?
+	`, doc.Title).Scan(&docID, &storedHash)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
 	
-	if err != nil {
-		// Document doesn't exist, insert it
-		result, err := ds.db.Exec(`
-			INSERT INTO app_documents (title, content, category, url, created_at)
-			VALUES (?, ?, ?, ?, NOW())
-		`, doc.Title, doc.Content, doc.Category, doc.URL)
-		
-		if err != nil {
-			return err
+	// Unchanged and still embedded; embeddings are gone after
+	// seed-docs --recreate-embeddings
+	if exists && storedHash.String == doc.ContentHash {
+		var chunks int
+		if err := ds.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM app_embeddings WHERE doc_id = ?`, docID).Scan(&chunks); err != nil {
+			return false, err
 		}
-		
-		docID, err = result.LastInsertId()
-		if err != nil {
-			return err
-		}
-	} else {
-		// Document exists, update it and clear old embeddings
-		_, err = ds.db.Exec(`
-			UPDATE app_documents 
-			SET content = ?, category = ?, url = ?
-			WHERE id = ?
-		`, doc.Content, doc.Category, doc.URL, docID)
-		if err != nil {
-			return err
-		}
-		
-		// Delete existing embeddings for this document
-		_, err = ds.db.Exec(`DELETE FROM app_embeddings WHERE doc_id = ?`, docID)
-		if err != nil {
-			return err
+		if chunks > 0 {
+			return false, nil
 		}
 	}
 	
-	// Chunk the content and create embeddings
-	chunks := chunkText(doc.Content, 400, 50) // 400 chars with 50 char overlap
-	if len(chunks) == 0 {
-		return nil
+	started := time.Now()
+	chunks := chunkText(doc.Content, ds.chunking)
+	embeddings, err := ds.embedChunks(ctx, chunks)
+	if err != nil {
+		return false, err
 	}
 	
-	ctx := context.Background()
-	embeddings, err := ds.embedder.Embed(ctx, chunks)
+	tx, err := ds.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to generate embeddings: %w", err)
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 	
-	// Store chunks and embeddings
-	for i, chunk := range chunks {
-		if i >= len(embeddings) {
-			break
-		}
-		
-		metadata := fmt.Sprintf(`{"doc_title": "%s", "category": "%s", "chunk": %d}`, 
-			doc.Title, doc.Category, i)
-		
-		// Convert embedding to JSON string for TiDB VECTOR type
-		embeddingJSON, err := json.Marshal(embeddings[i])
+	if exists {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE app_documents 
+			SET content = ?, category = ?, url = ?, content_hash = ?
+			WHERE id = ?
+		`, doc.Content, doc.Category, doc.URL, doc.ContentHash, docID)
 		if err != nil {
-			return fmt.Errorf("failed to marshal embedding %d: %w", i, err)
+			return false, err
 		}
-		
-		_, err = ds.db.Exec(`
-			INSERT INTO app_embeddings (doc_id, chunk_id, text, embedding, metadata)
-			VALUES (?, ?, ?, CAST(? AS VECTOR(1536)), ?)
-		`, docID, i, chunk, string(embeddingJSON), metadata)
-		
+	} else {
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO app_documents (title, content, category, url, content_hash, created_at)
+			VALUES (?, ?, ?, ?, ?, NOW())
+		`, doc.Title, doc.Content, doc.Category, doc.URL, doc.ContentHash)
 		if err != nil {
-			return fmt.Errorf("failed to store chunk %d: %w", i, err)
+			return false, err
 		}
-	}
-	
-	return nil
-}
-
-// Search performs vector similarity search for relevant documentation
-func (ds *DocumentStore) Search(ctx context.Context, query string, topK int) ([]SearchResult, error) {
-	// Generate embedding for the query
-	embeddings, err := ds.embedder.Embed(ctx, []string{query})
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate query embedding: %w", err)
-	}
-	
-	if len(embeddings) == 0 {
-		return nil, fmt.Errorf("no embedding generated for query")
-	}
-	
-	queryEmbedding := embeddings[0]
-	
-	// Convert query embedding to JSON string for TiDB VECTOR comparison
-	queryEmbeddingJSON, err := json.Marshal(queryEmbedding)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal query embedding: %w", err)
-	}
-	
-	// Search for similar embeddings using TiDB vector search
-	searchSQL := `
-		SELECT 
-			e.text,
-			d.title as document,
-			d.category,
-			d.url,
-			VEC_COSINE_DISTANCE(e.embedding, CAST(? AS VECTOR(1536))) as distance
-		FROM app_embeddings e
-		JOIN app_documents d ON e.doc_id = d.id
-		WHERE VEC_COSINE_DISTANCE(e.embedding, CAST(? AS VECTOR(1536))) < 0.5
-		ORDER BY distance ASC
-		LIMIT ?`
-	
-	queryVector := string(queryEmbeddingJSON)
-	rows, err := ds.db.Query(searchSQL, queryVector, queryVector, topK)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute vector search: %w", err)
-	}
-	defer rows.Close()
-	
-	var results []SearchResult
-	for rows.Next() {
-		var result SearchResult
-		var distance float64
 		
-		err := rows.Scan(&result.Text, &result.Document, &result.Category, &result.URL, &distance)
+		docID, err = result.LastInsertId()
 		if err != nil {
-			return nil, err
+			return false, err
 		}
-		
-		// Convert distance to similarity score (1 - distance)
-		result.Score = 1.0 - distance
-		results = append(results, result)
 	}
 	
-	return results, nil
-}
-
-// chunkText splits text into overlapping chunks
-func chunkText(text string, chunkSize, overlap int) []string {
-	if len(text) <= chunkSize {
-		return []string{text}
+	if err := ds.writeChunks(ctx, tx, docID, doc, chunks, embeddings); err != nil {
+		return false, err
 	}
-	
-	var chunks []string
-	start := 0
-	
-	for start < len(text) {
-		end := start + chunkSize
-		if end > len(text) {
-			end = len(text)
-		}
-		
-		chunk := text[start:end]
-		chunks = append(chunks, chunk)
-		
-		if end >= len(text) {
-			break
-		}
-		
-		start += chunkSize - overlap
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit document: %w", err)
 	}
 	
-	return chunks
-}
\ No newline at end of file
+	slog.InfoContext(ctx, "document indexed", "doc_id", docID, "title", doc.Title, "chunks", len(chunks),
+		"elapsed_ms", time.Since(started).Milliseconds())
+	return true, nil
+}
@@ -0,0 +1,337 @@
+package rag
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxPageBytes bounds the size of a fetched page, so a misconfigured source
+// cannot fill app_documents or the embedding bill
+const maxPageBytes = 4 << 20
+
+var fetchClient = &http.Client{Timeout: 30 * time.Second}
+
+// AddDocumentFromURL fetches the page at rawURL and stores it under category
+// with its URL. HTML pages are stripped to their text, preferring their
+// <main> or <article> element, and titled by their <title>; markdown and
+// plain text are stored as is, titled by their first heading. A page keeps
+// the title it was first stored under, so it replaces itself when its title
+// changes.
+//
+// The ETag and Last-Modified of the response are stored with the document
+// and sent back on the next fetch, so a page the server reports unchanged is
+// not downloaded again. It reports false when nothing was embedded: the page
+// was not modified, or its text is the same.
+func (ds *DocumentStore) AddDocumentFromURL(ctx context.Context, rawURL string, category string) (bool, error) {
+	var (
+		storedID           int64
+		title              string
+		etag, lastModified string
+		embedded           bool
+	)
+	err := ds.db.QueryRowContext(ctx, `
+		SELECT id, title, COALESCE(etag, ''), COALESCE(last_modified, '') FROM app_documents WHERE url = ? LIMIT 1
+	`, rawURL).Scan(&storedID, &title, &etag, &lastModified)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return false, fmt.Errorf("failed to look up %s: %w", rawURL, err)
+	default:
+		var chunks int
+		if err := ds.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM app_embeddings WHERE doc_id = ?`, storedID).Scan(&chunks); err != nil {
+			return false, fmt.Errorf("failed to look up %s: %w", rawURL, err)
+		}
+		embedded = chunks > 0
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request for %s: %w", rawURL, err)
+	}
+	req.Header.Set("Accept", "text/html, text/markdown;q=0.9, text/plain;q=0.8")
+	req.Header.Set("User-Agent", "latentia-sync-docs")
+	// Without embeddings, left behind by seed-docs --recreate-embeddings,
+	// the page is needed even when unchanged
+	if embedded {
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("failed to fetch %s: HTTP %d", rawURL, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPageBytes+1))
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", rawURL, err)
+	}
+	if len(body) > maxPageBytes {
+		return false, fmt.Errorf("%s is larger than %d MiB", rawURL, maxPageBytes>>20)
+	}
+
+	pageTitle, text, err := pageText(rawURL, resp.Header.Get("Content-Type"), body)
+	if err != nil {
+		return false, err
+	}
+	if text == "" {
+		return false, fmt.Errorf("%s has no text", rawURL)
+	}
+	if title == "" {
+		title = pageTitle
+	}
+
+	changed, err := ds.addDocument(ctx, Document{
+		Title:    title,
+		Content:  text,
+		Category: category,
+		URL:      rawURL,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to add %s: %w", rawURL, err)
+	}
+
+	_, err = ds.db.ExecContext(ctx, `
+		UPDATE app_documents SET etag = NULLIF(?, ''), last_modified = NULLIF(?, '') WHERE title = ?
+	`, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), title)
+	if err != nil {
+		return changed, fmt.Errorf("failed to store the validators of %s: %w", rawURL, err)
+	}
+	return changed, nil
+}
+
+// pageText returns the title and text of a fetched page by its content
+// type, or by its extension when the server sent none
+func pageText(rawURL, contentType string, body []byte) (string, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid URL %s: %w", rawURL, err)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "" || mediaType == "application/octet-stream" {
+		switch strings.ToLower(path.Ext(u.Path)) {
+		case ".md", ".markdown":
+			mediaType = "text/markdown"
+		default:
+			mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+		}
+	}
+
+	switch mediaType {
+	case "text/html", "application/xhtml+xml":
+		title, text, err := htmlText(body)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to parse %s: %w", rawURL, err)
+		}
+		if title == "" {
+			title = rawURL
+		}
+		return title, text, nil
+	case "text/markdown", "text/x-markdown", "text/plain":
+		text := strings.TrimSpace(string(body))
+		title := markdownTitle(text, u.Path)
+		if title == "" || title == "/" || title == "." {
+			title = rawURL
+		}
+		return title, text, nil
+	default:
+		return "", "", fmt.Errorf("%s is %s, not HTML, markdown or plain text", rawURL, mediaType)
+	}
+}
+
+// skippedElements hold navigation, scripts and other text that is not part
+// of a page's content
+var skippedElements = map[atom.Atom]bool{
+	atom.Head: true, atom.Script: true, atom.Style: true, atom.Noscript: true,
+	atom.Template: true, atom.Svg: true, atom.Nav: true, atom.Header: true,
+	atom.Footer: true, atom.Aside: true, atom.Form: true, atom.Button: true,
+	atom.Iframe: true,
+}
+
+// blockElements start on a line of their own, and paragraphs after a blank
+// line: they map to the separator written before and after them
+var blockElements = map[atom.Atom]string{
+	atom.Div: "\n", atom.Section: "\n", atom.Article: "\n", atom.Main: "\n",
+	atom.Br: "\n", atom.Li: "\n", atom.Dt: "\n", atom.Dd: "\n", atom.Tr: "\n",
+	atom.P: "\n\n", atom.Hr: "\n\n", atom.Ul: "\n\n", atom.Ol: "\n\n",
+	atom.Dl: "\n\n", atom.Table: "\n\n", atom.Blockquote: "\n\n",
+	atom.Pre: "\n\n", atom.H1: "\n\n", atom.H2: "\n\n", atom.H3: "\n\n",
+	atom.H4: "\n\n", atom.H5: "\n\n", atom.H6: "\n\n",
+}
+
+var headingLevels = map[atom.Atom]int{
+	atom.H1: 1, atom.H2: 2, atom.H3: 3, atom.H4: 4, atom.H5: 5, atom.H6: 6,
+}
+
+// htmlText returns the title of an HTML page, from its <title> or first
+// <h1>, and the text of its <main> or <article> element, or of its body.
+// Headings keep their markdown level and list items their dash, which is
+// enough structure for chunking and for the model to read.
+func htmlText(body []byte) (string, string, error) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return "", "", err
+	}
+
+	title := nodeText(findElement(doc, atom.Title))
+	if title == "" {
+		title = nodeText(findElement(doc, atom.H1))
+	}
+
+	root := findElement(doc, atom.Main)
+	if root == nil {
+		root = findElement(doc, atom.Article)
+	}
+	if root == nil {
+		root = doc
+	}
+
+	var b strings.Builder
+	writeText(&b, root, false)
+
+	// Trim the lines and keep at most one blank line between paragraphs,
+	// which <pre> elements may have
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(b.String(), "\n") {
+		line = strings.TrimRight(line, " \t")
+		if line == "" && blank {
+			continue
+		}
+		blank = line == ""
+		lines = append(lines, line)
+	}
+	return title, strings.TrimSpace(strings.Join(lines, "\n")), nil
+}
+
+// writeText writes the text under n to b, collapsing whitespace outside
+// <pre> elements
+func writeText(b *strings.Builder, n *html.Node, pre bool) {
+	switch n.Type {
+	case html.TextNode:
+		if pre {
+			b.WriteString(n.Data)
+			return
+		}
+		words := strings.Fields(n.Data)
+		if len(words) == 0 || isSpace(n.Data[0]) {
+			writeSpace(b)
+		}
+		if len(words) > 0 {
+			b.WriteString(strings.Join(words, " "))
+			if isSpace(n.Data[len(n.Data)-1]) {
+				writeSpace(b)
+			}
+		}
+		return
+	case html.ElementNode:
+		if skippedElements[n.DataAtom] {
+			return
+		}
+	case html.CommentNode, html.DoctypeNode:
+		return
+	}
+
+	separator := ""
+	if n.Type == html.ElementNode {
+		separator = blockElements[n.DataAtom]
+	}
+	if separator != "" {
+		writeSeparator(b, separator)
+		if level := headingLevels[n.DataAtom]; level > 0 {
+			b.WriteString(strings.Repeat("#", level) + " ")
+		}
+		if n.DataAtom == atom.Li {
+			b.WriteString("- ")
+		}
+	}
+	pre = pre || n.DataAtom == atom.Pre
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		writeText(b, c, pre)
+	}
+	if separator != "" {
+		writeSeparator(b, separator)
+	}
+}
+
+// writeSeparator ends b with separator, a newline or a blank line, unless it
+// is empty or already ends with one
+func writeSeparator(b *strings.Builder, separator string) {
+	s := strings.TrimRight(b.String(), " \t")
+	if s == "" {
+		return
+	}
+	for !strings.HasSuffix(s, separator) {
+		b.WriteByte('\n')
+		s += "\n"
+	}
+}
+
+// writeSpace separates words of adjacent text, unless b is at the start of
+// a line or already ends with a space
+func writeSpace(b *strings.Builder) {
+	if s := b.String(); s != "" && !strings.HasSuffix(s, " ") && !strings.HasSuffix(s, "\n") {
+		b.WriteByte(' ')
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// findElement returns the first element a under n, depth first
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// nodeText returns the text under n with its whitespace collapsed
+func nodeText(n *html.Node) string {
+	if n == nil {
+		return ""
+	}
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data + " ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
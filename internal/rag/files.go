@@ -0,0 +1,101 @@
+package rag
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hash identifies the content of doc as stored: its category, URL and text,
+// and the chunking of its embeddings
+func (doc Document) hash(chunking Chunking) string {
+	sum := sha256.Sum256([]byte(doc.Category + "\x00" + doc.URL + "\x00" + doc.Content + "\x00" + chunking.key()))
+	return hex.EncodeToString(sum[:])
+}
+
+// AddDocumentFromFile adds a markdown file to the store under category,
+// titled by its first heading, or its file name when it has none. The title
+// identifies the document, so a file with the title of a stored document
+// replaces it. It reports false when the file is unchanged since it was last
+// added, in which case nothing is embedded again.
+func (ds *DocumentStore) AddDocumentFromFile(ctx context.Context, path string, category string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	text := strings.TrimSpace(string(content))
+	if text == "" {
+		return false, fmt.Errorf("%s is empty", path)
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+	doc := Document{
+		Title:    markdownTitle(text, path),
+		Content:  text,
+		Category: category,
+		URL:      "file://" + filepath.ToSlash(abs),
+	}
+	changed, err := ds.addDocument(ctx, doc)
+	if err != nil {
+		return false, fmt.Errorf("failed to add %s: %w", path, err)
+	}
+	return changed, nil
+}
+
+// markdownTitle returns the text of the first ATX heading of text, or the
+// file name of path without its extension
+func markdownTitle(text, path string) string {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		heading := strings.TrimLeft(line, "#")
+		if len(heading) < len(line) && len(line)-len(heading) <= 6 && strings.HasPrefix(heading, " ") {
+			if title := strings.TrimSpace(strings.TrimRight(heading, "# ")); title != "" {
+				return title
+			}
+		}
+	}
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+// MarkdownFiles returns path when it is a file, or the .md and .markdown
+// files under it, recursively and in lexical order, when it is a directory.
+// Hidden directories such as .git are skipped.
+func MarkdownFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != path && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(p)) {
+		case ".md", ".markdown":
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", path, err)
+	}
+	return files, nil
+}
@@ -0,0 +1,196 @@
+package rag
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// ActionDeleteDocument is the audit action of DeleteDocument
+const ActionDeleteDocument = "delete-document"
+
+// ErrDocumentNotFound is returned when deleting an unknown document
+var ErrDocumentNotFound = errors.New("document not found")
+
+// embedChunks embeds chunks in batches of at most ds.batchSize texts, the
+// most some providers accept in one request
+func (ds *DocumentStore) embedChunks(ctx context.Context, chunks []string) ([][]float32, error) {
+	embeddings := make([][]float32, 0, len(chunks))
+	for start := 0; start < len(chunks); start += ds.batchSize {
+		end := min(start+ds.batchSize, len(chunks))
+		batch, err := ds.embedder.Embed(types.WithEmbedInput(ctx, types.EmbedDocument), chunks[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate embeddings for chunks %d-%d: %w", start, end-1, err)
+		}
+		// A chunk without its embedding could never be retrieved
+		if len(batch) != end-start {
+			return nil, fmt.Errorf("embedder returned %d embeddings for %d chunks", len(batch), end-start)
+		}
+		embeddings = append(embeddings, batch...)
+	}
+	return embeddings, nil
+}
+
+// writeChunks replaces the embeddings of document docID with chunks and
+// their embeddings, within tx
+func (ds *DocumentStore) writeChunks(ctx context.Context, tx *sql.Tx, docID int64, doc Document, chunks []string, embeddings [][]float32) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM app_embeddings WHERE doc_id = ?`, docID); err != nil {
+		return fmt.Errorf("failed to delete old embeddings: %w", err)
+	}
+
+	for i, chunk := range chunks {
+		metadata, err := json.Marshal(map[string]any{"doc_title": doc.Title, "category": doc.Category, "chunk": i})
+		if err != nil {
+			return fmt.Errorf("failed to marshal metadata of chunk %d: %w", i, err)
+		}
+		// Convert embedding to JSON string for TiDB VECTOR type
+		embeddingJSON, err := json.Marshal(embeddings[i])
+		if err != nil {
+			return fmt.Errorf("failed to marshal embedding %d: %w", i, err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO app_embeddings (doc_id, chunk_id, text, embedding, metadata)
+			VALUES (?, ?, ?, CAST(? AS `+ds.vectorType()+`), ?)
+		`, docID, i, chunk, string(embeddingJSON), string(metadata))
+		if err != nil {
+			return fmt.Errorf("failed to store chunk %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// ReindexDocument embeds document docID again from its stored content,
+// replacing its embeddings in one transaction, and returns its number of
+// chunks. It rebuilds documents left without embeddings or chunked by an
+// earlier vector.chunk_words or vector.chunk_overlap.
+func (ds *DocumentStore) ReindexDocument(ctx context.Context, docID int64) (int, error) {
+	var doc Document
+	var url sql.NullString
+	err := ds.db.QueryRowContext(ctx, `
+		SELECT id, title, content, COALESCE(category, ''), url FROM app_documents WHERE id = ?
+	`, docID).Scan(&doc.ID, &doc.Title, &doc.Content, &doc.Category, &url)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("document %d not found", docID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load document %d: %w", docID, err)
+	}
+	doc.URL = url.String
+
+	started := time.Now()
+	chunks := chunkText(doc.Content, ds.chunking)
+	embeddings, err := ds.embedChunks(ctx, chunks)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reindex %s: %w", doc.Title, err)
+	}
+
+	tx, err := ds.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin reindex of %s: %w", doc.Title, err)
+	}
+	defer tx.Rollback()
+
+	// The stored hash follows the chunking the embeddings were made with
+	if _, err := tx.ExecContext(ctx, `UPDATE app_documents SET content_hash = ? WHERE id = ?`, doc.hash(ds.chunking), docID); err != nil {
+		return 0, fmt.Errorf("failed to update %s: %w", doc.Title, err)
+	}
+	if err := ds.writeChunks(ctx, tx, docID, doc, chunks, embeddings); err != nil {
+		return 0, fmt.Errorf("failed to reindex %s: %w", doc.Title, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit reindex of %s: %w", doc.Title, err)
+	}
+
+	slog.InfoContext(ctx, "document reindexed", "doc_id", docID, "title", doc.Title, "chunks", len(chunks),
+		"elapsed_ms", time.Since(started).Milliseconds())
+	return len(chunks), nil
+}
+
+// ListDocuments returns the stored documents by id with their number of
+// chunks, without their content
+func (ds *DocumentStore) ListDocuments(ctx context.Context) ([]Document, error) {
+	rows, err := ds.db.QueryContext(ctx, `
+		SELECT d.id, d.title, COALESCE(d.category, ''), COALESCE(d.url, ''), COUNT(e.id)
+		FROM app_documents d
+		LEFT JOIN app_embeddings e ON e.doc_id = d.id
+		GROUP BY d.id, d.title, d.category, d.url
+		ORDER BY d.id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list documents: %w", err)
+	}
+	defer rows.Close()
+
+	var docs []Document
+	for rows.Next() {
+		var doc Document
+		if err := rows.Scan(&doc.ID, &doc.Title, &doc.Category, &doc.URL, &doc.Chunks); err != nil {
+			return nil, fmt.Errorf("failed to scan document: %w", err)
+		}
+		docs = append(docs, doc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list documents: %w", err)
+	}
+	return docs, nil
+}
+
+// HasEmbeddings reports whether any chunk is embedded, so that searching an
+// empty store can skip embedding the query
+func (ds *DocumentStore) HasEmbeddings(ctx context.Context) (bool, error) {
+	var exists bool
+	if err := ds.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM app_embeddings)`).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to count embeddings: %w", err)
+	}
+	return exists, nil
+}
+
+// DeleteDocument removes document docID and its embeddings in one
+// transaction, auditing it under the actor carried by ctx
+func (ds *DocumentStore) DeleteDocument(ctx context.Context, docID int64) error {
+	tx, err := ds.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin deletion of document %d: %w", docID, err)
+	}
+	defer tx.Rollback()
+
+	var title string
+	err = tx.QueryRowContext(ctx, `SELECT title FROM app_documents WHERE id = ? FOR UPDATE`, docID).Scan(&title)
+	if err == sql.ErrNoRows {
+		return ErrDocumentNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load document %d: %w", docID, err)
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM app_embeddings WHERE doc_id = ?`, docID)
+	if err != nil {
+		return fmt.Errorf("failed to delete embeddings of %s: %w", title, err)
+	}
+	chunks, _ := res.RowsAffected()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM app_documents WHERE id = ?`, docID); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", title, err)
+	}
+
+	err = database.RecordAuditTx(ctx, tx, database.AuditEntry{
+		Action:  ActionDeleteDocument,
+		Details: map[string]any{"doc_id": docID, "title": title, "chunks": chunks},
+	})
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit deletion of %s: %w", title, err)
+	}
+
+	slog.InfoContext(ctx, "document deleted", "doc_id", docID, "title", title, "chunks", chunks)
+	return nil
+}
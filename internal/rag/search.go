@@ -0,0 +1,278 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/metrics"
+	"github.com/matthieukhl/latentia/internal/tracing"
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// SearchOptions tune a documentation search
+type SearchOptions struct {
+	// TopK is the largest number of chunks returned
+	TopK int
+
+	// Categories restricts the search to documents of these categories;
+	// empty searches them all
+	Categories []string
+
+	// PreferredCategories rank chunks of these categories above chunks of
+	// other categories with a close score
+	PreferredCategories []string
+
+	// MinScore is the lowest score returned, before the preference bonus.
+	// Vector scores are cosine similarities, from -1 to 1.
+	MinScore float64
+
+	// HybridKeyword also finds chunks containing the terms of the query,
+	// however far their embeddings are, and scores every chunk as
+	// KeywordWeight times the share of the terms it contains plus the rest
+	// times its vector similarity
+	HybridKeyword bool
+	KeywordWeight float64
+}
+
+// preferredCategoryBonus is added to the score of chunks of a preferred
+// category when ranking them, so they win over close matches elsewhere
+// without displacing much better ones
+const preferredCategoryBonus = 0.1
+
+// candidatesPerResult is how many chunks are fetched per result returned
+// when chunks are reranked after the vector search
+const candidatesPerResult = 4
+
+// NewSearchOptions returns the options of a search for topK chunks with
+// vector.min_score, vector.hybrid and vector.keyword_weight
+func NewSearchOptions(topK int) SearchOptions {
+	vector := config.Current().Vector
+	return SearchOptions{
+		TopK:          topK,
+		MinScore:      vector.MinScore,
+		HybridKeyword: vector.Hybrid,
+		KeywordWeight: vector.KeywordWeight,
+	}
+}
+
+// candidate is a chunk found by the vector or the keyword search
+type candidate struct {
+	id       int64
+	result   SearchResult
+	distance float64
+}
+
+// Search returns the chunks most relevant to query, best first
+func (ds *DocumentStore) Search(ctx context.Context, query string, opts SearchOptions) (results []SearchResult, err error) {
+	ctx, span := tracing.Start(ctx, "rag.search", tracing.Int("rag.top_k", int64(opts.TopK)),
+		tracing.Bool("rag.hybrid", opts.HybridKeyword))
+	started := time.Now()
+	defer func() {
+		mode := "vector"
+		if opts.HybridKeyword {
+			mode = "hybrid"
+		}
+		metrics.VectorSearchDuration.Observe(time.Since(started).Seconds(), mode)
+		span.SetAttributes(tracing.Int("rag.results", int64(len(results))))
+		span.RecordError(err)
+		span.End()
+	}()
+	if opts.TopK <= 0 {
+		return nil, fmt.Errorf("top k must be positive, got %d", opts.TopK)
+	}
+
+	// Generate embedding for the query
+	embeddings, err := ds.embedder.Embed(types.WithEmbedInput(ctx, types.EmbedQuery), []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate query embedding: %w", err)
+	}
+	if len(embeddings) == 0 {
+		return nil, fmt.Errorf("no embedding generated for query")
+	}
+	queryEmbeddingJSON, err := json.Marshal(embeddings[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query embedding: %w", err)
+	}
+	queryVector := string(queryEmbeddingJSON)
+
+	var terms []string
+	if opts.HybridKeyword {
+		terms = queryTerms(query)
+	}
+	limit := opts.TopK
+	if len(terms) > 0 || len(opts.PreferredCategories) > 0 {
+		limit *= candidatesPerResult
+	}
+
+	categoryFilter, categoryArgs := "", []any{}
+	if len(opts.Categories) > 0 {
+		categoryFilter = "d.category IN (?" + strings.Repeat(", ?", len(opts.Categories)-1) + ")"
+		for _, category := range opts.Categories {
+			categoryArgs = append(categoryArgs, category)
+		}
+	}
+
+	// Nearest chunks by cosine distance
+	vectorSQL := `
+		SELECT e.id, e.text, d.title, d.category, d.url,
+			VEC_COSINE_DISTANCE(e.embedding, CAST(? AS ` + ds.vectorType() + `)) AS distance
+		FROM app_embeddings e
+		JOIN app_documents d ON e.doc_id = d.id`
+	if categoryFilter != "" {
+		vectorSQL += `
+		WHERE ` + categoryFilter
+	}
+	vectorSQL += `
+		ORDER BY distance ASC
+		LIMIT ?`
+	args := append(append([]any{queryVector}, categoryArgs...), limit)
+	candidates, err := ds.searchChunks(ctx, vectorSQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute vector search: %w", err)
+	}
+
+	// Chunks containing the most terms, which the embeddings may rank far
+	// away, above all with local or mock embedders
+	if len(terms) > 0 {
+		likes := make([]string, len(terms))
+		likeArgs := make([]any, len(terms))
+		for i, term := range terms {
+			likes[i] = "LOWER(e.text) LIKE ?"
+			likeArgs[i] = "%" + strings.ReplaceAll(term, "_", `\_`) + "%"
+		}
+		keywordSQL := `
+		SELECT e.id, e.text, d.title, d.category, d.url,
+			VEC_COSINE_DISTANCE(e.embedding, CAST(? AS ` + ds.vectorType() + `)) AS distance
+		FROM app_embeddings e
+		JOIN app_documents d ON e.doc_id = d.id
+		WHERE (` + strings.Join(likes, " OR ") + `)`
+		if categoryFilter != "" {
+			keywordSQL += ` AND ` + categoryFilter
+		}
+		keywordSQL += `
+		ORDER BY (` + strings.Join(likes, ") + (") + `) DESC
+		LIMIT ?`
+		args := []any{queryVector}
+		args = append(args, likeArgs...)
+		args = append(args, categoryArgs...)
+		args = append(args, likeArgs...)
+		args = append(args, limit)
+		matches, err := ds.searchChunks(ctx, keywordSQL, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute keyword search: %w", err)
+		}
+		candidates = append(candidates, matches...)
+	}
+
+	return rankCandidates(candidates, terms, opts), nil
+}
+
+// searchChunks runs a chunk search selecting the id, text, document title,
+// category, URL and cosine distance of each chunk
+func (ds *DocumentStore) searchChunks(ctx context.Context, query string, args ...any) ([]candidate, error) {
+	rows, err := ds.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		if err := rows.Scan(&c.id, &c.result.Text, &c.result.Document, &c.result.Category, &c.result.URL, &c.distance); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
+}
+
+// rankCandidates scores the distinct candidates, drops those under
+// opts.MinScore and returns the opts.TopK best, preferred categories first
+// among close scores
+func rankCandidates(candidates []candidate, terms []string, opts SearchOptions) []SearchResult {
+	preferred := map[string]bool{}
+	for _, category := range opts.PreferredCategories {
+		preferred[category] = true
+	}
+
+	type ranked struct {
+		result SearchResult
+		rank   float64
+	}
+	seen := map[int64]bool{}
+	var kept []ranked
+	for _, c := range candidates {
+		if seen[c.id] {
+			continue
+		}
+		seen[c.id] = true
+
+		score := 1.0 - c.distance
+		if len(terms) > 0 {
+			score = (1-opts.KeywordWeight)*score + opts.KeywordWeight*keywordScore(c.result.Text, terms)
+		}
+		if score < opts.MinScore {
+			continue
+		}
+		c.result.Score = score
+		rank := score
+		if preferred[c.result.Category] {
+			rank += preferredCategoryBonus
+		}
+		kept = append(kept, ranked{c.result, rank})
+	}
+
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].rank > kept[j].rank })
+	if len(kept) > opts.TopK {
+		kept = kept[:opts.TopK]
+	}
+	results := make([]SearchResult, len(kept))
+	for i, k := range kept {
+		results[i] = k.result
+	}
+	return results
+}
+
+// keywordStopWords are too common in search queries to tell chunks apart
+var keywordStopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "how": true,
+	"sql": true, "query": true, "queries": true, "optimization": true,
+	"optimize": true, "performance": true,
+}
+
+// queryTerms returns the distinct lowercase words of query of at least
+// three letters, digits or underscores, without stop words
+func queryTerms(query string) []string {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	var terms []string
+	seen := map[string]bool{}
+	for _, word := range words {
+		if len(word) < 3 || keywordStopWords[word] || seen[word] {
+			continue
+		}
+		seen[word] = true
+		terms = append(terms, word)
+	}
+	return terms
+}
+
+// keywordScore is the share of terms text contains, ignoring case
+func keywordScore(text string, terms []string) float64 {
+	text = strings.ToLower(text)
+	var matched int
+	for _, term := range terms {
+		if strings.Contains(text, term) {
+			matched++
+		}
+	}
+	return float64(matched) / float64(len(terms))
+}
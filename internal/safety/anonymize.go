@@ -0,0 +1,501 @@
+package safety
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Kinds of identifiers replaced by safety.anonymize_identifiers, with the
+// prefix of their aliases
+const (
+	IdentifierSchema = "schema" // s1, s2, ...
+	IdentifierTable  = "table"  // t1, t2, ...
+	IdentifierColumn = "column" // c1, c2, ...
+	IdentifierAlias  = "alias"  // a1, a2, ...
+)
+
+// IdentifierPrefixes maps each kind to the prefix of its aliases
+var IdentifierPrefixes = map[string]string{
+	IdentifierSchema: "s",
+	IdentifierTable:  "t",
+	IdentifierColumn: "c",
+	IdentifierAlias:  "a",
+}
+
+// Identifier is a name that must not leave the process. Columns are scoped
+// by their lower-cased table so the same column name in two tables gets two
+// aliases; Scope is empty for columns whose table the statement does not
+// determine and for every other kind. Name is lower-cased.
+type Identifier struct {
+	Kind  string
+	Scope string
+	Name  string
+}
+
+// IdentifierStore hands out stable aliases such as t1 or c3, allocating
+// new ones for identifiers it has not seen before
+type IdentifierStore interface {
+	IdentifierAliases(ctx context.Context, ids []Identifier) (map[Identifier]string, error)
+}
+
+var aliasRegex = regexp.MustCompile(`\b[stca][0-9]+\b`)
+
+// Anonymization maps the aliases of an anonymized statement back to the
+// identifiers they replaced, as written in the statement
+type Anonymization struct {
+	names  map[string]string // alias -> identifier as written
+	tables []string          // table aliases in order of appearance
+}
+
+// Count returns the number of distinct identifiers replaced
+func (a *Anonymization) Count() int {
+	if a == nil {
+		return 0
+	}
+	return len(a.names)
+}
+
+// Tables returns the aliases of the tables the statement references
+func (a *Anonymization) Tables() []string {
+	return a.tables
+}
+
+// Merge adds the aliases of other, made for a statement shown alongside
+// this one such as a previous rewrite of it, so Restore covers both
+func (a *Anonymization) Merge(other *Anonymization) {
+	for alias, name := range other.names {
+		if _, ok := a.names[alias]; !ok {
+			a.names[alias] = name
+		}
+	}
+}
+
+// AnonymizeIdentifiers replaces schema, table, column and alias names in sql
+// by aliases from store. String literals and function names are kept, plain
+// comments are dropped since they can name anything, and identifiers in
+// optimizer hints are replaced like the others.
+func AnonymizeIdentifiers(ctx context.Context, store IdentifierStore, sql string) (string, *Anonymization, error) {
+	pieces := lexIdentifiers(sql)
+	refs := classifyIdentifiers(pieces)
+
+	// Hints name tables, aliases and indexes of the statement
+	hints := map[int][]piece{}
+	for i, p := range pieces {
+		if p.kind == pieceHint {
+			hints[i] = lexIdentifiers(strings.TrimSuffix(strings.TrimPrefix(p.text, "/*+"), "*/"))
+		}
+	}
+	known := map[string]Identifier{}
+	for _, ref := range refs {
+		if _, ok := known[ref.id.Name]; !ok || ref.id.Kind != IdentifierColumn {
+			known[ref.id.Name] = ref.id
+		}
+	}
+	hintIDs := map[string]Identifier{}
+	for _, body := range hints {
+		for i, p := range body {
+			if !p.nameable() || i+1 < len(body) && body[i+1].text == "(" {
+				continue
+			}
+			name := p.name()
+			id, ok := known[name]
+			if !ok {
+				// Most likely an index
+				id = Identifier{Kind: IdentifierColumn, Name: name}
+			}
+			hintIDs[name] = id
+		}
+	}
+
+	var ids []Identifier
+	seen := map[Identifier]bool{}
+	for _, ref := range refs {
+		if !seen[ref.id] {
+			seen[ref.id] = true
+			ids = append(ids, ref.id)
+		}
+	}
+	for _, id := range hintIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return rebuild(pieces), &Anonymization{names: map[string]string{}}, nil
+	}
+
+	aliases, err := store.IdentifierAliases(ctx, ids)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to map identifiers: %w", err)
+	}
+
+	a := &Anonymization{names: map[string]string{}}
+	replace := func(p *piece, id Identifier) error {
+		alias, ok := aliases[id]
+		if !ok {
+			return fmt.Errorf("no alias for %s %s", id.Kind, id.Name)
+		}
+		if _, ok := a.names[alias]; !ok {
+			a.names[alias] = p.text
+			if id.Kind == IdentifierTable {
+				a.tables = append(a.tables, alias)
+			}
+		}
+		p.text = alias
+		return nil
+	}
+	for _, ref := range refs {
+		if err := replace(&pieces[ref.piece], ref.id); err != nil {
+			return "", nil, err
+		}
+	}
+	for i, body := range hints {
+		for j := range body {
+			if id, ok := hintIDs[body[j].name()]; ok && body[j].nameable() && !(j+1 < len(body) && body[j+1].text == "(") {
+				if err := replace(&body[j], id); err != nil {
+					return "", nil, err
+				}
+			}
+		}
+		pieces[i].text = "/*+" + rebuild(body) + "*/"
+	}
+	return rebuild(pieces), a, nil
+}
+
+// Restore puts the original identifiers back in place of the aliases in sql,
+// typically SQL proposed by the model. String literals are left alone.
+func (a *Anonymization) Restore(sql string) string {
+	if a == nil {
+		return sql
+	}
+	pieces := lexIdentifiers(sql)
+	for i := range pieces {
+		switch pieces[i].kind {
+		case pieceWord, pieceQuoted:
+			if name, ok := a.names[pieces[i].name()]; ok {
+				pieces[i].text = name
+			}
+		case pieceHint:
+			body := strings.TrimSuffix(strings.TrimPrefix(pieces[i].text, "/*+"), "*/")
+			pieces[i].text = "/*+" + a.Restore(body) + "*/"
+		}
+	}
+	return rebuild(pieces)
+}
+
+// RestoreText puts the original identifiers back in prose such as the
+// rationale, where quotes are not SQL literals
+func (a *Anonymization) RestoreText(text string) string {
+	if a == nil {
+		return text
+	}
+	return aliasRegex.ReplaceAllStringFunc(text, func(alias string) string {
+		if name, ok := a.names[alias]; ok {
+			return name
+		}
+		return alias
+	})
+}
+
+type pieceKind int
+
+const (
+	pieceOther  pieceKind = iota // punctuation, whitespace and numbers
+	pieceWord                    // bare word, keyword or identifier
+	pieceQuoted                  // backtick identifier
+	pieceString                  // string literal
+	pieceHint                    // optimizer hint comment
+)
+
+type piece struct {
+	text string
+	kind pieceKind
+}
+
+// nameable reports whether the piece can be an identifier
+func (p piece) nameable() bool {
+	switch p.kind {
+	case pieceQuoted:
+		return true
+	case pieceWord:
+		// Words starting with a digit are numbers such as 1e5 or 0x1F
+		return !reservedWords[strings.ToUpper(p.text)] && !isDigit(p.text[0]) && p.text[0] != '@'
+	}
+	return false
+}
+
+// name returns the lower-cased identifier without backticks
+func (p piece) name() string {
+	if p.kind == pieceQuoted {
+		return strings.ToLower(strings.ReplaceAll(strings.Trim(p.text, "`"), "``", "`"))
+	}
+	return strings.ToLower(p.text)
+}
+
+// lexIdentifiers splits sql into pieces that concatenate back to it, except
+// for plain comments, which become a space
+func lexIdentifiers(sql string) []piece {
+	var pieces []piece
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"':
+			end, _ := quotedEnd(sql, i)
+			pieces = append(pieces, piece{text: sql[i:end], kind: pieceString})
+			i = end
+		case c == '`':
+			end, _ := quotedEnd(sql, i)
+			pieces = append(pieces, piece{text: sql[i:end], kind: pieceQuoted})
+			i = end
+		case c == '#' || isDashComment(sql, i):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			pieces = append(pieces, piece{text: " "})
+			i += end
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			next := len(sql)
+			if end >= 0 {
+				next = i + 2 + end + 2
+			}
+			if strings.HasPrefix(sql[i:], "/*+") {
+				pieces = append(pieces, piece{text: sql[i:next], kind: pieceHint})
+			} else {
+				pieces = append(pieces, piece{text: " "})
+			}
+			i = next
+		case isWordChar(c):
+			end := i
+			for end < len(sql) && isWordChar(sql[end]) {
+				end++
+			}
+			pieces = append(pieces, piece{text: sql[i:end], kind: pieceWord})
+			i = end
+		default:
+			pieces = append(pieces, piece{text: string(c)})
+			i++
+		}
+	}
+	return pieces
+}
+
+func rebuild(pieces []piece) string {
+	var b strings.Builder
+	for _, p := range pieces {
+		b.WriteString(p.text)
+	}
+	return b.String()
+}
+
+// identifierRef is one identifier of a statement and what it names
+type identifierRef struct {
+	piece int
+	id    Identifier
+}
+
+// namePart is one part of a possibly qualified name such as db.t.col
+type namePart struct {
+	piece int
+	name  string
+}
+
+type qualifiedName struct {
+	parts []namePart
+	role  string // IdentifierTable, IdentifierAlias or IdentifierColumn
+}
+
+// Keywords after which a name is a table
+var tableIntroducers = map[string]bool{
+	"FROM": true, "JOIN": true, "STRAIGHT_JOIN": true, "UPDATE": true, "INTO": true, "TABLE": true,
+}
+
+// Keywords ending the table list of a FROM clause
+var fromListEnd = map[string]bool{
+	"WHERE": true, "GROUP": true, "ORDER": true, "HAVING": true, "LIMIT": true, "ON": true, "USING": true,
+	"UNION": true, "EXCEPT": true, "INTERSECT": true, "SET": true, "WINDOW": true, "FOR": true, "LOCK": true,
+	"SELECT": true, "VALUES": true,
+}
+
+// classifyIdentifiers finds the identifiers of a statement. Tables are the
+// names after FROM, JOIN and the like, or defined by WITH; a name right
+// after a table, or after AS, is an alias; every other name is a column,
+// scoped by its qualifier or by the only table of the statement.
+func classifyIdentifiers(pieces []piece) []identifierRef {
+	var sig []int
+	for i, p := range pieces {
+		if p.kind != pieceOther || strings.TrimSpace(p.text) != "" {
+			sig = append(sig, i)
+		}
+	}
+	upperAt := func(k int) string {
+		if k < 0 || k >= len(sig) || pieces[sig[k]].kind != pieceWord {
+			return ""
+		}
+		return strings.ToUpper(pieces[sig[k]].text)
+	}
+	textAt := func(k int) string {
+		if k < 0 || k >= len(sig) {
+			return ""
+		}
+		return pieces[sig[k]].text
+	}
+
+	var names []qualifiedName
+	depth, fromDepth := 0, -1
+	lastTable := -1 // sig index just past the last table name
+	for k := 0; k < len(sig); k++ {
+		p := pieces[sig[k]]
+		switch {
+		case p.text == "(":
+			depth++
+			continue
+		case p.text == ")":
+			depth--
+			if depth < fromDepth {
+				fromDepth = -1
+			}
+			continue
+		case !p.nameable():
+			if upper := upperAt(k); upper == "FROM" {
+				fromDepth = depth
+			} else if fromListEnd[upper] && depth == fromDepth {
+				fromDepth = -1
+			}
+			continue
+		}
+
+		start := k
+		parts := []namePart{{piece: sig[k], name: p.name()}}
+		for textAt(k+1) == "." && k+2 < len(sig) && pieces[sig[k+2]].nameable() {
+			k += 2
+			parts = append(parts, namePart{piece: sig[k], name: pieces[sig[k]].name()})
+		}
+
+		// Function calls have the parenthesis right after the name, and
+		// charset introducers such as _utf8mb4 the literal
+		prev := upperAt(start - 1)
+		if len(parts) == 1 && p.kind == pieceWord && sig[k]+1 < len(pieces) {
+			next := pieces[sig[k]+1]
+			if next.kind == pieceString || next.text == "(" && !tableIntroducers[prev] && prev != "WITH" && prev != "RECURSIVE" {
+				continue
+			}
+		}
+
+		role := IdentifierColumn
+		switch {
+		case tableIntroducers[prev] || textAt(start-1) == "," && depth == fromDepth:
+			role = IdentifierTable
+		case prev == "WITH" || prev == "RECURSIVE" || upperAt(k+1) == "AS" && textAt(k+2) == "(":
+			// Common table expression
+			role = IdentifierTable
+		case len(parts) == 1 && (lastTable == start || prev == "AS" && lastTable == start-1):
+			role = IdentifierAlias
+		case len(parts) == 1 && prev == "AS":
+			role = IdentifierAlias
+		}
+		if role == IdentifierTable && textAt(k+1) != "(" {
+			lastTable = k + 1
+		} else if role == IdentifierAlias && lastTable >= start-1 {
+			// A table list continues after the alias
+			lastTable = k + 1
+		}
+		names = append(names, qualifiedName{parts: parts, role: role})
+	}
+
+	// Collect tables and aliases first so later references resolve
+	tables := map[string]bool{}
+	var tableOrder []string
+	aliases := map[string]string{} // alias -> table it stands for, "" for others
+	for i, n := range names {
+		switch n.role {
+		case IdentifierTable:
+			table := n.parts[len(n.parts)-1].name
+			if !tables[table] {
+				tables[table] = true
+				tableOrder = append(tableOrder, table)
+			}
+		case IdentifierAlias:
+			aliases[n.parts[0].name] = ""
+			if i > 0 && names[i-1].role == IdentifierTable {
+				aliases[n.parts[0].name] = names[i-1].parts[len(names[i-1].parts)-1].name
+			}
+		}
+	}
+
+	var refs []identifierRef
+	add := func(part namePart, kind, scope string) {
+		refs = append(refs, identifierRef{piece: part.piece, id: Identifier{Kind: kind, Scope: scope, Name: part.name}})
+	}
+	qualifier := func(part namePart) string {
+		if table, ok := aliases[part.name]; ok {
+			// Columns of derived tables are not scoped, like their
+			// unqualified definitions
+			add(part, IdentifierAlias, "")
+			return table
+		}
+		add(part, IdentifierTable, "")
+		return part.name
+	}
+	for _, n := range names {
+		parts := n.parts
+		switch n.role {
+		case IdentifierTable:
+			for _, part := range parts[:len(parts)-1] {
+				add(part, IdentifierSchema, "")
+			}
+			add(parts[len(parts)-1], IdentifierTable, "")
+		case IdentifierAlias:
+			add(parts[0], IdentifierAlias, "")
+		default:
+			last := parts[len(parts)-1]
+			switch len(parts) {
+			case 1:
+				switch _, isAlias := aliases[last.name]; {
+				case isAlias:
+					add(last, IdentifierAlias, "")
+				case tables[last.name]:
+					add(last, IdentifierTable, "")
+				case len(tableOrder) == 1:
+					add(last, IdentifierColumn, tableOrder[0])
+				default:
+					add(last, IdentifierColumn, "")
+				}
+			default:
+				for _, part := range parts[:len(parts)-2] {
+					add(part, IdentifierSchema, "")
+				}
+				add(last, IdentifierColumn, qualifier(parts[len(parts)-2]))
+			}
+		}
+	}
+	return refs
+}
+
+// reservedWords are never identifiers. Words that are only non-reserved
+// keywords, such as status or date, are common column names and are not
+// listed.
+var reservedWords = map[string]bool{}
+
+func init() {
+	for _, word := range strings.Fields(`
+		SELECT DISTINCT DISTINCTROW ALL FROM WHERE GROUP BY HAVING ORDER LIMIT OFFSET
+		UNION EXCEPT INTERSECT WITH RECURSIVE AS ON USING JOIN INNER LEFT RIGHT FULL
+		OUTER CROSS NATURAL STRAIGHT_JOIN AND OR NOT XOR IN IS NULL LIKE ESCAPE REGEXP
+		RLIKE BETWEEN EXISTS CASE WHEN THEN ELSE END ASC DESC INTERVAL TRUE FALSE
+		ANY SOME FOR UPDATE SHARE LOCK MODE WINDOW OVER PARTITION ROWS RANGE
+		PRECEDING FOLLOWING UNBOUNDED CURRENT ROW SQL_CALC_FOUND_ROWS SQL_NO_CACHE
+		HIGH_PRIORITY DIV MOD USE FORCE IGNORE INDEX KEY INSERT INTO VALUES VALUE SET
+		DELETE REPLACE DUPLICATE TABLE EXPLAIN ANALYZE FORMAT BINARY COLLATE UNIQUE
+		PRIMARY DEFAULT CAST CONVERT SIGNED UNSIGNED CHAR DECIMAL INTEGER INT DATETIME
+		MICROSECOND SECOND MINUTE HOUR DAY WEEK MONTH QUARTER YEAR DAY_HOUR
+		HOUR_MINUTE MINUTE_SECOND YEAR_MONTH CURRENT_DATE CURRENT_TIME
+		CURRENT_TIMESTAMP LOCALTIME LOCALTIMESTAMP UTC_DATE UTC_TIME UTC_TIMESTAMP
+		MATCH AGAINST LATERAL DUAL NOWAIT OF`) {
+		reservedWords[word] = true
+	}
+}
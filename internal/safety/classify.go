@@ -0,0 +1,73 @@
+package safety
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CodeNotOptimizable identifies administrative and DDL statements, which
+// the optimizer leaves alone
+const CodeNotOptimizable = "SAFETY_NOT_OPTIMIZABLE"
+
+// Kinds of statements told apart by Optimizable
+const (
+	kindQuery          = "query"
+	kindDML            = "dml"
+	kindDDL            = "ddl"
+	kindAdministrative = "administrative"
+)
+
+// statementKinds maps the first keyword of a statement to its kind; any
+// other keyword, such as SET, ANALYZE, SHOW or EXPLAIN, starts an
+// administrative statement
+var statementKinds = map[string]string{
+	"SELECT":   kindQuery,
+	"WITH":     kindQuery,
+	"TABLE":    kindQuery,
+	"VALUES":   kindQuery,
+	"(":        kindQuery,
+	"INSERT":   kindDML,
+	"REPLACE":  kindDML,
+	"UPDATE":   kindDML,
+	"DELETE":   kindDML,
+	"CREATE":   kindDDL,
+	"ALTER":    kindDDL,
+	"DROP":     kindDDL,
+	"TRUNCATE": kindDDL,
+	"RENAME":   kindDDL,
+}
+
+// classifyTokens returns the kind of a scanned statement and its first
+// keyword
+func classifyTokens(tokens []sqlToken) (kind, keyword string) {
+	keyword = tokens[0].text
+	if kind, ok := statementKinds[keyword]; ok {
+		return kind, keyword
+	}
+	return kindAdministrative, keyword
+}
+
+// Optimizable accepts exactly one SELECT or DML statement, not matching
+// safety.forbid_patterns, and returns it without its trailing semicolons.
+// Several statements are refused with CodeMultiStatement, administrative
+// and DDL statements with CodeNotOptimizable.
+func Optimizable(sql string) (string, error) {
+	tokens, end, err := scanStatement(sql)
+	if err != nil {
+		return "", err
+	}
+	switch kind, keyword := classifyTokens(tokens); kind {
+	case kindDDL:
+		return "", &Violation{Code: CodeNotOptimizable, Reason: fmt.Sprintf("%s is a DDL statement: only SELECT and DML statements are optimized", keyword)}
+	case kindAdministrative:
+		return "", &Violation{Code: CodeNotOptimizable, Reason: fmt.Sprintf("%s is an administrative statement: only SELECT and DML statements are optimized", keyword)}
+	}
+	return strings.TrimSpace(sql[:end]), nil
+}
+
+// IsMultiStatement reports whether err refused input holding more than one
+// statement
+func IsMultiStatement(err error) bool {
+	violation, ok := AsViolation(err)
+	return ok && violation.Code == CodeMultiStatement
+}
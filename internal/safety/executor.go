@@ -0,0 +1,147 @@
+package safety
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/tracing"
+)
+
+// Purposes of sandboxed executions, logged with every statement
+const (
+	PurposeExplain     = "explain"
+	PurposeBenchmark   = "benchmark"
+	PurposeEquivalence = "equivalence"
+	PurposeCapture     = "capture"
+)
+
+// DefaultSandboxTimeout bounds sandboxed statements when
+// safety.max_stmt_seconds is 0; the sandbox always has a deadline
+const DefaultSandboxTimeout = 60 * time.Second
+
+// Result holds the rows of a sandboxed statement, NULLs as invalid strings
+type Result struct {
+	Columns []string
+	Rows    [][]sql.NullString
+
+	// Truncated is set when more than safety.max_rows rows were available
+	Truncated bool
+	Duration  time.Duration
+}
+
+// SafeExecutor runs SQL the agent did not author, such as proposed rewrites
+// for EXPLAIN, benchmarks and equivalence checks. Each statement must pass
+// VerifyReadOnly and runs on its own connection inside a read-only
+// transaction that is always rolled back, with MAX_EXECUTION_TIME and
+// MEMORY_QUOTA hints, a row limit and a wall-clock deadline.
+type SafeExecutor struct {
+	db *sql.DB
+}
+
+func NewSafeExecutor(db *sql.DB) *SafeExecutor {
+	return &SafeExecutor{db: db}
+}
+
+// readOnlyFallback warns once when the server refuses read-only transactions
+var readOnlyFallback sync.Once
+
+// Query runs query for purpose and returns at most safety.max_rows rows
+func (e *SafeExecutor) Query(ctx context.Context, purpose, query string) (result *Result, err error) {
+	ctx, span := tracing.Start(ctx, "safety.execute", tracing.String("safety.purpose", purpose))
+	defer func() {
+		span.RecordError(err)
+		span.End()
+	}()
+
+	stmt, err := VerifyReadOnly(query)
+	if err != nil {
+		slog.WarnContext(ctx, "sandboxed statement refused", "purpose", purpose, "error", err)
+		return nil, err
+	}
+
+	limits := config.Current().Safety
+	timeout := StatementTimeout()
+	if timeout <= 0 {
+		timeout = DefaultSandboxTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	started := time.Now()
+	// One row past the limit tells a truncated result from an exact fit
+	result, err = e.run(ctx, stmt.Sandboxed(timeout.Milliseconds(), limits.MaxMemoryMB, limits.MaxRows+1), limits.MaxRows)
+	elapsed := time.Since(started)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("statement exceeded the sandbox deadline of %v: %w", timeout, err)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "sandboxed statement failed", "purpose", purpose, "duration", elapsed, "error", err)
+		return nil, err
+	}
+
+	result.Duration = elapsed
+	span.SetAttributes(tracing.Int("db.rows", int64(len(result.Rows))), tracing.Bool("safety.truncated", result.Truncated))
+	slog.InfoContext(ctx, "sandboxed statement executed", "purpose", purpose, "duration", elapsed, "rows", len(result.Rows), "truncated", result.Truncated)
+	return result, nil
+}
+
+func (e *SafeExecutor) run(ctx context.Context, query string, maxRows int) (*Result, error) {
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil && ctx.Err() == nil {
+		// TiDB only accepts START TRANSACTION READ ONLY with
+		// tidb_enable_noop_functions; the statement was verified read-only
+		// and the transaction is never committed
+		readOnlyFallback.Do(func() {
+			slog.WarnContext(ctx, "server refused a read-only transaction, sandboxed statements run in a transaction that is rolled back", "error", err)
+		})
+		tx, err = conn.BeginTx(ctx, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Columns: columns}
+	for rows.Next() {
+		if maxRows > 0 && len(result.Rows) >= maxRows {
+			result.Truncated = true
+			break
+		}
+		row := make([]sql.NullString, len(columns))
+		dest := make([]any, len(columns))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,196 @@
+package safety
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MinRedactedDigits is the length from which numeric literals are redacted;
+// shorter numbers (limits, flags, small ids) rarely identify anyone and help
+// the model reason about the query
+const MinRedactedDigits = 5
+
+var placeholderRegex = regexp.MustCompile(`<(str|num):(\d+)>`)
+
+// Redaction maps the placeholders of a redacted statement back to the
+// literals they replaced
+type Redaction struct {
+	literals []string
+	kinds    []string
+	seen     map[string]int
+}
+
+// Count returns the number of distinct literals replaced
+func (r *Redaction) Count() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.literals)
+}
+
+// RedactLiterals replaces the contents of string literals with <str:N> and
+// numeric literals of MinRedactedDigits or more digits with <num:N>, keeping
+// quotes, LIKE wildcards at either end, identifiers, comments and hints so
+// the statement keeps its structure. Identical literals share a placeholder.
+func RedactLiterals(sql string) (string, *Redaction) {
+	r := &Redaction{seen: map[string]int{}}
+	return r.Redact(sql), r
+}
+
+// Redact replaces the literals of another statement shown alongside the
+// first, such as a previous rewrite of it, adding to the same placeholders
+// so Restore covers both and literals they share keep one placeholder
+func (r *Redaction) Redact(sql string) string {
+	placeholder := func(kind, literal string) string {
+		key := kind + "\x00" + literal
+		n, ok := r.seen[key]
+		if !ok {
+			r.literals = append(r.literals, literal)
+			r.kinds = append(r.kinds, kind)
+			n = len(r.literals)
+			r.seen[key] = n
+		}
+		return fmt.Sprintf("<%s:%d>", kind, n)
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"':
+			end, closed := quotedEnd(sql, i)
+			body := sql[i+1 : end]
+			if closed {
+				body = sql[i+1 : end-1]
+			}
+			prefix, suffix := wildcards(body)
+			b.WriteByte(c)
+			b.WriteString(prefix)
+			if inner := body[len(prefix) : len(body)-len(suffix)]; inner != "" {
+				b.WriteString(placeholder("str", inner))
+			}
+			b.WriteString(suffix)
+			if closed {
+				b.WriteByte(c)
+			}
+			i = end
+		case c == '`':
+			end, _ := quotedEnd(sql, i)
+			b.WriteString(sql[i:end])
+			i = end
+		case c == '#' || c == '-' && strings.HasPrefix(sql[i:], "-- "):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			b.WriteString(sql[i : i+end])
+			i += end
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				end = len(sql) - i - 2
+			} else {
+				end += 2
+			}
+			b.WriteString(sql[i : i+2+end])
+			i += 2 + end
+		case isIdentChar(c):
+			end := i
+			for end < len(sql) && (isIdentChar(sql[end]) || sql[end] == '.' && isNumber(sql[i:end]) && end+1 < len(sql) && isDigit(sql[end+1])) {
+				end++
+			}
+			word := sql[i:end]
+			if isNumber(word) && countDigits(word) >= MinRedactedDigits {
+				b.WriteString(placeholder("num", word))
+			} else {
+				b.WriteString(word)
+			}
+			i = end
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// Restore puts the original literals back in place of the placeholders of
+// text, typically SQL proposed by the model. It fails when text references a
+// placeholder this redaction did not produce, since the statement could not
+// be run as intended.
+func (r *Redaction) Restore(text string) (string, error) {
+	if r == nil {
+		return text, nil
+	}
+	var unknown string
+	restored := placeholderRegex.ReplaceAllStringFunc(text, func(match string) string {
+		parts := placeholderRegex.FindStringSubmatch(match)
+		n, err := strconv.Atoi(parts[2])
+		if err != nil || n < 1 || n > len(r.literals) || r.kinds[n-1] != parts[1] {
+			if unknown == "" {
+				unknown = match
+			}
+			return match
+		}
+		return r.literals[n-1]
+	})
+	if unknown != "" {
+		return "", fmt.Errorf("unknown redaction placeholder %s", unknown)
+	}
+	return restored, nil
+}
+
+// quotedEnd returns the index just past the quoted token starting at start,
+// honouring doubled quotes and backslash escapes, and whether it is closed
+func quotedEnd(sql string, start int) (int, bool) {
+	quote := sql[start]
+	for i := start + 1; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1, true
+		}
+	}
+	return len(sql), false
+}
+
+// wildcards returns the leading and trailing LIKE wildcards of a literal so
+// anti-pattern advice such as leading-wildcard-like stays valid
+func wildcards(body string) (string, string) {
+	prefix := body[:len(body)-len(strings.TrimLeft(body, "%_"))]
+	rest := body[len(prefix):]
+	suffix := rest[len(strings.TrimRight(rest, "%_")):]
+	return prefix, suffix
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isNumber reports whether word is a decimal literal such as 12345 or 3.14
+func isNumber(word string) bool {
+	dot := false
+	for i := 0; i < len(word); i++ {
+		switch {
+		case isDigit(word[i]):
+		case word[i] == '.' && !dot && i > 0:
+			dot = true
+		default:
+			return false
+		}
+	}
+	return word != ""
+}
+
+func countDigits(word string) int {
+	return len(strings.ReplaceAll(word, ".", ""))
+}
@@ -0,0 +1,140 @@
+package safety
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/config"
+)
+
+// CodeForbiddenPattern identifies SQL rejected because it matched one of
+// safety.forbid_patterns
+const CodeForbiddenPattern = "SAFETY_FORBIDDEN_PATTERN"
+
+// Codes of statements refused by the sandbox executor, see VerifyReadOnly
+const (
+	CodeUnparsable      = "SAFETY_UNPARSABLE"
+	CodeMultiStatement  = "SAFETY_MULTI_STATEMENT"
+	CodeNotReadOnly     = "SAFETY_NOT_READ_ONLY"
+	CodeForbiddenClause = "SAFETY_FORBIDDEN_CLAUSE"
+)
+
+// Violation is returned when SQL may not be analyzed or executed
+type Violation struct {
+	Code    string
+	Pattern string // the forbid pattern matched, for CodeForbiddenPattern
+	Reason  string
+}
+
+func (v *Violation) Error() string {
+	if v.Reason != "" {
+		return fmt.Sprintf("%s: %s", v.Code, v.Reason)
+	}
+	return fmt.Sprintf("%s: statement matches forbidden pattern %q", v.Code, v.Pattern)
+}
+
+// AsViolation reports whether err is (or wraps) a safety violation
+func AsViolation(err error) (*Violation, bool) {
+	var v *Violation
+	if errors.As(err, &v) {
+		return v, true
+	}
+	return nil, false
+}
+
+// Check rejects SQL matching any of the configured forbid patterns. It must
+// be called before SQL is analyzed, benchmarked or applied.
+func Check(sql string) error {
+	safety := config.Current().Safety
+	for i, re := range safety.ForbidRegexps() {
+		if re.MatchString(sql) {
+			pattern := re.String()
+			if i < len(safety.ForbidPatterns) {
+				pattern = safety.ForbidPatterns[i]
+			}
+			return &Violation{Code: CodeForbiddenPattern, Pattern: pattern}
+		}
+	}
+	return nil
+}
+
+// StatementTimeout returns the configured per-statement limit, 0 when unlimited
+func StatementTimeout() time.Duration {
+	return time.Duration(config.Current().Safety.MaxStmtSeconds) * time.Second
+}
+
+// StatementContext bounds ctx by safety.max_stmt_seconds for one statement
+// executed on behalf of a query
+func StatementContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout := StatementTimeout(); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// LimitStatement adds a MAX_EXECUTION_TIME optimizer hint to a SELECT so the
+// server stops it even if the client goes away. Other statements are returned
+// unchanged and rely on StatementContext.
+func LimitStatement(sql string) string {
+	timeout := StatementTimeout()
+	if timeout <= 0 {
+		return sql
+	}
+
+	trimmed := strings.TrimLeft(sql, " \t\r\n")
+	if len(trimmed) < 7 || !strings.EqualFold(trimmed[:6], "select") || isIdentChar(trimmed[6]) {
+		return sql
+	}
+	// Leave statements that already carry their own hint block alone
+	if strings.HasPrefix(strings.TrimLeft(trimmed[6:], " \t\r\n"), "/*+") {
+		return sql
+	}
+
+	offset := len(sql) - len(trimmed) + 6
+	return fmt.Sprintf("%s /*+ MAX_EXECUTION_TIME(%d) */%s", sql[:offset], timeout.Milliseconds(), sql[offset:])
+}
+
+// Querier runs queries; *sql.DB, *sql.Conn and *sql.Tx implement it
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// Rows are the rows of a QuerySafe statement; closing them ends its deadline
+type Rows struct {
+	*sql.Rows
+	cancel context.CancelFunc
+}
+
+// Close closes the rows and releases the statement deadline
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	r.cancel()
+	return err
+}
+
+// QuerySafe runs SQL derived from user input, such as generated load or a
+// recorded slow query: it is refused when it matches safety.forbid_patterns
+// and bounded by safety.max_stmt_seconds both client-side and, for a
+// SELECT, through MAX_EXECUTION_TIME. The deadline covers reading the rows.
+// SQL the agent did not author at all goes through SafeExecutor instead.
+func QuerySafe(ctx context.Context, db Querier, query string, args ...any) (*Rows, error) {
+	if err := Check(query); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := StatementContext(ctx)
+	rows, err := db.QueryContext(ctx, LimitStatement(query), args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &Rows{Rows: rows, cancel: cancel}, nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
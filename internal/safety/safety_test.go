@@ -0,0 +1,119 @@
+package safety
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/matthieukhl/latentia/internal/config/configtest"
+)
+
+// refusingQuerier fails the test when a statement reaches the database
+type refusingQuerier struct{ t *testing.T }
+
+func (q refusingQuerier) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	q.t.Errorf("statement reached the database: %s", query)
+	return nil, sql.ErrConnDone
+}
+
+func TestCheckDefaultPatterns(t *testing.T) {
+	configtest.Load(t, "")
+
+	for _, stmt := range []string{
+		"DROP TABLE customers",
+		"drop table if exists customers",
+		"  DROP DATABASE shop",
+		"TRUNCATE TABLE orders",
+		"ALTER TABLE orders ADD COLUMN x INT",
+		"SELECT 1; DROP TABLE customers",
+	} {
+		err := Check(stmt)
+		v, ok := AsViolation(err)
+		if !ok || v.Code != CodeForbiddenPattern {
+			t.Errorf("Check(%q) = %v, want %s", stmt, err, CodeForbiddenPattern)
+		}
+	}
+	// DELETE is only blocked when configured
+	for _, stmt := range []string{
+		"DELETE FROM customers WHERE id = 1",
+		"SELECT * FROM customers WHERE dropped = 1",
+	} {
+		if err := Check(stmt); err != nil {
+			t.Errorf("Check(%q) = %v, want it allowed by default", stmt, err)
+		}
+	}
+}
+
+func TestCheckConfiguredPatterns(t *testing.T) {
+	configtest.Load(t, `
+safety:
+  forbid_patterns:
+    - "DROP "
+    - "TRUNCATE "
+    - '\bDELETE\s+FROM\s+customers\b'
+`)
+
+	tests := []struct {
+		sql     string
+		pattern string
+	}{
+		{"DROP TABLE orders", "DROP "},
+		{"TRUNCATE orders", "TRUNCATE "},
+		{"DELETE FROM customers", `\bDELETE\s+FROM\s+customers\b`},
+		{"delete   from customers where id = 7", `\bDELETE\s+FROM\s+customers\b`},
+	}
+	for _, tt := range tests {
+		v, ok := AsViolation(Check(tt.sql))
+		if !ok || v.Code != CodeForbiddenPattern || v.Pattern != tt.pattern {
+			t.Errorf("Check(%q) = %v, want pattern %q", tt.sql, v, tt.pattern)
+		}
+	}
+	for _, stmt := range []string{
+		"DELETE FROM orders WHERE id = 1",
+		"DELETE FROM customers_archive",
+		"ALTER TABLE orders ADD INDEX idx_total (total)",
+	} {
+		if err := Check(stmt); err != nil {
+			t.Errorf("Check(%q) = %v, want it allowed", stmt, err)
+		}
+	}
+}
+
+func TestQuerySafeRefusesBeforeExecuting(t *testing.T) {
+	configtest.Load(t, `
+safety:
+  forbid_patterns: ["DROP ", "TRUNCATE ", 'DELETE\s+FROM\s+customers']
+`)
+
+	for _, stmt := range []string{"DROP TABLE customers", "TRUNCATE TABLE customers", "DELETE FROM customers"} {
+		rows, err := QuerySafe(context.Background(), refusingQuerier{t}, stmt)
+		if rows != nil {
+			rows.Close()
+		}
+		if v, ok := AsViolation(err); !ok || v.Code != CodeForbiddenPattern {
+			t.Errorf("QuerySafe(%q) = %v, want %s", stmt, err, CodeForbiddenPattern)
+		}
+	}
+}
+
+func TestLimitStatement(t *testing.T) {
+	configtest.Load(t, "safety:\n  max_stmt_seconds: 5\n")
+
+	tests := []struct{ sql, want string }{
+		{"SELECT * FROM orders", "SELECT /*+ MAX_EXECUTION_TIME(5000) */ * FROM orders"},
+		{"  select id from orders", "  select /*+ MAX_EXECUTION_TIME(5000) */ id from orders"},
+		{"SELECT /*+ USE_INDEX(orders, idx) */ id FROM orders", "SELECT /*+ USE_INDEX(orders, idx) */ id FROM orders"},
+		{"DELETE FROM orders", "DELETE FROM orders"},
+		{"SELECTED", "SELECTED"},
+	}
+	for _, tt := range tests {
+		if got := LimitStatement(tt.sql); got != tt.want {
+			t.Errorf("LimitStatement(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+
+	configtest.Load(t, "safety:\n  max_stmt_seconds: 0\n")
+	if got := LimitStatement("SELECT 1"); got != "SELECT 1" {
+		t.Errorf("LimitStatement without a limit = %q", got)
+	}
+}
@@ -0,0 +1,301 @@
+package safety
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Functions with effects outside the statement: advisory locks and reading
+// files from the server
+var forbiddenFunctions = map[string]bool{
+	"GET_LOCK":          true,
+	"RELEASE_LOCK":      true,
+	"RELEASE_ALL_LOCKS": true,
+	"LOAD_FILE":         true,
+}
+
+// Statement is a single read-only statement accepted by VerifyReadOnly
+type Statement struct {
+	// SQL is the statement without its trailing semicolon
+	SQL string
+
+	// Explain is set for EXPLAIN [ANALYZE] statements
+	Explain bool
+
+	selectEnd int  // offset just past the SELECT keyword hints go on
+	hintEnd   int  // offset just past "/*+" of that SELECT's hints, -1 if none
+	wrap      bool // a row limit needs a derived table rather than a LIMIT
+}
+
+// SingleStatement accepts exactly one statement of any kind, not matching
+// safety.forbid_patterns, and returns it without its trailing semicolons.
+// Refusals are *Violation errors.
+func SingleStatement(sql string) (string, error) {
+	_, end, err := scanStatement(sql)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(sql[:end]), nil
+}
+
+// scanStatement checks sql against safety.forbid_patterns and tokenizes it,
+// refusing more than one statement. It returns the tokens without trailing
+// semicolons and the offset the statement ends at.
+func scanStatement(sql string) ([]sqlToken, int, error) {
+	if err := Check(sql); err != nil {
+		return nil, 0, err
+	}
+
+	tokens, err := scanSQL(sql)
+	if err != nil {
+		return nil, 0, &Violation{Code: CodeUnparsable, Reason: err.Error()}
+	}
+
+	// One trailing semicolon is fine, anything after it is another statement
+	end := len(sql)
+	for len(tokens) > 0 && tokens[len(tokens)-1].text == ";" {
+		end = tokens[len(tokens)-1].pos
+		tokens = tokens[:len(tokens)-1]
+	}
+	for _, tok := range tokens {
+		if tok.text == ";" {
+			return nil, 0, &Violation{Code: CodeMultiStatement, Reason: "only a single statement may be executed"}
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, 0, &Violation{Code: CodeUnparsable, Reason: "empty statement"}
+	}
+	return tokens, end, nil
+}
+
+type sqlToken struct {
+	text  string // upper-cased word, punctuation character, or "/*+" for hints
+	pos   int
+	depth int // parenthesis depth the token is at
+}
+
+// VerifyReadOnly accepts exactly one SELECT, WITH ... SELECT or EXPLAIN
+// [ANALYZE] of one, without INTO, locking reads, session variable
+// assignments, executable comments or lock and file functions, and not
+// matching safety.forbid_patterns. Refusals are *Violation errors.
+func VerifyReadOnly(sql string) (*Statement, error) {
+	tokens, end, err := scanStatement(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := &Statement{SQL: strings.TrimRight(sql[:end], " \t\r\n"), hintEnd: -1}
+
+	i := 0
+	if word := tokens[0].text; word == "EXPLAIN" || word == "DESC" || word == "DESCRIBE" {
+		stmt.Explain = true
+		i = skipExplainOptions(tokens, 1)
+	}
+	if i >= len(tokens) {
+		return nil, &Violation{Code: CodeNotReadOnly, Reason: "nothing to explain"}
+	}
+
+	main := -1
+	switch tokens[i].text {
+	case "SELECT":
+		main = i
+	case "WITH":
+		// The statement the CTEs feed is the first top-level one after WITH
+		for j := i + 1; j < len(tokens); j++ {
+			if tokens[j].depth != tokens[i].depth {
+				continue
+			}
+			switch tokens[j].text {
+			case "SELECT":
+				main = j
+			case "INSERT", "UPDATE", "DELETE", "REPLACE", "TABLE", "VALUES":
+				return nil, &Violation{Code: CodeNotReadOnly, Reason: fmt.Sprintf("WITH ... %s is not a read-only statement", tokens[j].text)}
+			default:
+				continue
+			}
+			break
+		}
+	case "(":
+		for j := i + 1; j < len(tokens); j++ {
+			if tokens[j].text == "SELECT" {
+				main = j
+				break
+			}
+			if tokens[j].text != "(" && tokens[j].text != "WITH" {
+				break
+			}
+		}
+		stmt.wrap = true
+	}
+	if main < 0 {
+		return nil, &Violation{Code: CodeNotReadOnly, Reason: fmt.Sprintf("only SELECT statements may be executed, got %s", tokens[i].text)}
+	}
+
+	selectTok := tokens[main]
+	stmt.selectEnd = selectTok.pos + len("SELECT")
+	if main+1 < len(tokens) && tokens[main+1].text == "/*+" {
+		stmt.hintEnd = tokens[main+1].pos + len("/*+")
+	}
+
+	for j, tok := range tokens {
+		next := ""
+		if j+1 < len(tokens) {
+			next = tokens[j+1].text
+		}
+		switch {
+		case tok.text == "INTO":
+			return nil, &Violation{Code: CodeForbiddenClause, Reason: "SELECT ... INTO writes to files or variables"}
+		case tok.text == "FOR" && (next == "UPDATE" || next == "SHARE"):
+			return nil, &Violation{Code: CodeForbiddenClause, Reason: "locking read FOR " + next}
+		case tok.text == "LOCK" && next == "IN":
+			return nil, &Violation{Code: CodeForbiddenClause, Reason: "locking read LOCK IN SHARE MODE"}
+		case tok.text == ":" && next == "=" && tokens[j+1].pos == tok.pos+1:
+			return nil, &Violation{Code: CodeForbiddenClause, Reason: "variable assignment :="}
+		case forbiddenFunctions[tok.text] && next == "(":
+			return nil, &Violation{Code: CodeForbiddenClause, Reason: "function " + tok.text + " is not allowed"}
+		case tok.text == "LIMIT" && tok.depth == 0:
+			stmt.wrap = true
+		}
+	}
+
+	return stmt, nil
+}
+
+// skipExplainOptions returns the index of the statement explained after
+// ANALYZE and FORMAT = ... options starting at i
+func skipExplainOptions(tokens []sqlToken, i int) int {
+	for i < len(tokens) {
+		switch tokens[i].text {
+		case "ANALYZE", "EXTENDED", "PARTITIONS":
+			i++
+		case "FORMAT":
+			i++
+			if i < len(tokens) && tokens[i].text == "=" {
+				i++
+			}
+			// Quoted values are skipped by the scanner, bare ones are words
+			if i < len(tokens) && tokens[i].text != "SELECT" && tokens[i].text != "WITH" && tokens[i].text != "(" {
+				i++
+			}
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// Sandboxed returns the statement with MAX_EXECUTION_TIME and MEMORY_QUOTA
+// hints on its outermost SELECT and, unless it is an EXPLAIN, at most maxRows
+// rows. Statements with their own top-level LIMIT, which could be larger,
+// are wrapped in a derived table; others get a LIMIT appended, which for a
+// UNION applies to the whole result.
+func (s *Statement) Sandboxed(timeoutMs int64, memoryMB, maxRows int) string {
+	var hints []string
+	if timeoutMs > 0 {
+		hints = append(hints, fmt.Sprintf("MAX_EXECUTION_TIME(%d)", timeoutMs))
+	}
+	if memoryMB > 0 {
+		hints = append(hints, fmt.Sprintf("MEMORY_QUOTA(%d MB)", memoryMB))
+	}
+	hint := strings.Join(hints, " ")
+
+	if s.Explain || maxRows <= 0 {
+		return s.withHint(hint)
+	}
+	if s.wrap {
+		if hint != "" {
+			hint = " /*+ " + hint + " */"
+		}
+		return fmt.Sprintf("SELECT%s * FROM (\n%s\n) AS latentia_sandbox LIMIT %d", hint, s.SQL, maxRows)
+	}
+	return fmt.Sprintf("%s\nLIMIT %d", s.withHint(hint), maxRows)
+}
+
+func (s *Statement) withHint(hint string) string {
+	switch {
+	case hint == "":
+		return s.SQL
+	case s.hintEnd >= 0:
+		return s.SQL[:s.hintEnd] + " " + hint + s.SQL[s.hintEnd:]
+	default:
+		return s.SQL[:s.selectEnd] + " /*+ " + hint + " */" + s.SQL[s.selectEnd:]
+	}
+}
+
+// scanSQL splits sql into words and punctuation, skipping literals, quoted
+// identifiers and comments. It fails on unterminated tokens, unbalanced
+// parentheses and executable comments, MySQL's /*! and TiDB's /*T!, whose
+// contents would run.
+func scanSQL(sql string) ([]sqlToken, error) {
+	var tokens []sqlToken
+	depth := 0
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '\'' || c == '"' || c == '`':
+			end, closed := quotedEnd(sql, i)
+			if !closed {
+				return nil, fmt.Errorf("unterminated %c at offset %d", c, i)
+			}
+			i = end
+		case c == '#' || isDashComment(sql, i):
+			if nl := strings.IndexByte(sql[i:], '\n'); nl >= 0 {
+				i += nl + 1
+			} else {
+				i = len(sql)
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			if strings.HasPrefix(sql[i:], "/*!") || strings.HasPrefix(sql[i:], "/*T!") {
+				return nil, fmt.Errorf("executable comment at offset %d", i)
+			}
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated comment at offset %d", i)
+			}
+			if strings.HasPrefix(sql[i:], "/*+") {
+				tokens = append(tokens, sqlToken{text: "/*+", pos: i, depth: depth})
+			}
+			i += end + 4
+		case isWordChar(c):
+			start := i
+			for i < len(sql) && isWordChar(sql[i]) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{text: strings.ToUpper(sql[start:i]), pos: start, depth: depth})
+		case c == '(':
+			tokens = append(tokens, sqlToken{text: "(", pos: i, depth: depth})
+			depth++
+			i++
+		case c == ')':
+			if depth--; depth < 0 {
+				return nil, fmt.Errorf("unbalanced ')' at offset %d", i)
+			}
+			tokens = append(tokens, sqlToken{text: ")", pos: i, depth: depth})
+			i++
+		default:
+			tokens = append(tokens, sqlToken{text: string(c), pos: i, depth: depth})
+			i++
+		}
+	}
+
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced '('")
+	}
+	return tokens, nil
+}
+
+// isDashComment reports whether a -- comment, which needs whitespace or the
+// end of the input after the dashes, starts at i
+func isDashComment(sql string, i int) bool {
+	if !strings.HasPrefix(sql[i:], "--") {
+		return false
+	}
+	return i+2 == len(sql) || sql[i+2] == ' ' || sql[i+2] == '\t' || sql[i+2] == '\r' || sql[i+2] == '\n'
+}
+
+func isWordChar(c byte) bool {
+	return isIdentChar(c) || c == '$' || c == '@' || c >= 0x80
+}
@@ -0,0 +1,142 @@
+package safety
+
+import "testing"
+
+func TestVerifyReadOnlyRefuses(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		code string
+	}{
+		{"stacked statements", "SELECT 1; DELETE FROM orders", CodeMultiStatement},
+		{"stacked after trailing semicolon", "SELECT 1;; UPDATE orders SET total = 0", CodeMultiStatement},
+		{"stacked after line comment", "SELECT 1; -- harmless\nDELETE FROM orders", CodeMultiStatement},
+		{"DML behind executable comment", "SELECT 1 /*! ; DELETE FROM orders */", CodeUnparsable},
+		{"versioned executable comment", "SELECT /*!50000 SLEEP(10), */ 1", CodeUnparsable},
+		{"INTO OUTFILE behind TiDB comment", "SELECT * FROM orders /*T![clustered_index] INTO OUTFILE '/tmp/x' */", CodeUnparsable},
+		{"FOR UPDATE behind TiDB comment", "SELECT * FROM orders /*T![clustered_index] FOR UPDATE */", CodeUnparsable},
+		{"DML with comment mentioning SELECT", "/* SELECT */ DELETE FROM orders", CodeNotReadOnly},
+		{"DML hidden after dash comment", "-- SELECT 1\nDELETE FROM orders", CodeNotReadOnly},
+		{"DML hidden after hash comment", "# SELECT 1\nUPDATE orders SET total = 0", CodeNotReadOnly},
+		{"INTO OUTFILE", "SELECT * FROM orders INTO OUTFILE '/tmp/orders.csv'", CodeForbiddenClause},
+		{"INTO DUMPFILE", "SELECT id FROM orders LIMIT 1 INTO DUMPFILE '/tmp/o'", CodeForbiddenClause},
+		{"INTO variable", "SELECT COUNT(*) INTO @n FROM orders", CodeForbiddenClause},
+		{"FOR UPDATE", "SELECT * FROM orders WHERE id = 1 FOR UPDATE", CodeForbiddenClause},
+		{"FOR SHARE", "SELECT * FROM orders WHERE id = 1 FOR SHARE", CodeForbiddenClause},
+		{"lowercase for update", "select * from orders for update", CodeForbiddenClause},
+		{"LOCK IN SHARE MODE", "SELECT * FROM orders LOCK IN SHARE MODE", CodeForbiddenClause},
+		{"CTE-wrapped DELETE", "WITH old AS (SELECT id FROM orders WHERE total = 0) DELETE FROM orders WHERE id IN (SELECT id FROM old)", CodeNotReadOnly},
+		{"CTE-wrapped UPDATE", "WITH x AS (SELECT 1) UPDATE orders SET total = 0", CodeNotReadOnly},
+		{"recursive CTE-wrapped INSERT", "WITH RECURSIVE n AS (SELECT 1 AS i UNION ALL SELECT i + 1 FROM n WHERE i < 5) INSERT INTO t SELECT i FROM n", CodeNotReadOnly},
+		{"explained DELETE", "EXPLAIN DELETE FROM orders", CodeNotReadOnly},
+		{"explain of nothing", "EXPLAIN ANALYZE", CodeNotReadOnly},
+		{"variable assignment", "SELECT @n := COUNT(*) FROM orders", CodeForbiddenClause},
+		{"advisory lock", "SELECT GET_LOCK('l', 10)", CodeForbiddenClause},
+		{"file read", "SELECT load_file('/etc/passwd')", CodeForbiddenClause},
+		{"unterminated string", "SELECT 'oops FROM orders", CodeUnparsable},
+		{"unbalanced parenthesis", "SELECT (1 FROM orders", CodeUnparsable},
+		{"forbidden pattern", "DROP TABLE orders", CodeForbiddenPattern},
+		{"empty", " ; ", CodeUnparsable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, err := VerifyReadOnly(tt.sql)
+			if err == nil {
+				t.Fatalf("VerifyReadOnly(%q) accepted %q", tt.sql, stmt.SQL)
+			}
+			violation, ok := AsViolation(err)
+			if !ok {
+				t.Fatalf("error %v is not a *Violation", err)
+			}
+			if violation.Code != tt.code {
+				t.Errorf("code = %s, want %s (%v)", violation.Code, tt.code, err)
+			}
+		})
+	}
+}
+
+func TestVerifyReadOnlyAccepts(t *testing.T) {
+	tests := []struct {
+		name    string
+		sql     string
+		explain bool
+	}{
+		{"select", "SELECT * FROM orders WHERE id = 1;", false},
+		{"keywords in literals", "SELECT 'DELETE FROM orders; INTO OUTFILE' AS s FROM orders", false},
+		{"keywords in quoted identifiers", "SELECT `into`, `for` FROM `update`", false},
+		{"keywords in comments", "SELECT 1 /* FOR UPDATE */ -- INTO @x\n", false},
+		{"cte", "WITH paid AS (SELECT * FROM orders WHERE status = 'paid') SELECT COUNT(*) FROM paid", false},
+		{"parenthesized union", "(SELECT id FROM a) UNION (SELECT id FROM b)", false},
+		{"explain analyze", "EXPLAIN ANALYZE SELECT * FROM orders", true},
+		{"explain format", "EXPLAIN FORMAT = 'brief' SELECT * FROM orders", true},
+		{"optimizer hint", "SELECT /*+ USE_INDEX(orders, idx_status) */ * FROM orders", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, err := VerifyReadOnly(tt.sql)
+			if err != nil {
+				t.Fatalf("VerifyReadOnly(%q): %v", tt.sql, err)
+			}
+			if stmt.Explain != tt.explain {
+				t.Errorf("Explain = %v, want %v", stmt.Explain, tt.explain)
+			}
+		})
+	}
+}
+
+func TestSandboxed(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{
+			"hint and limit",
+			"SELECT * FROM orders;",
+			"SELECT /*+ MAX_EXECUTION_TIME(5000) MEMORY_QUOTA(256 MB) */ * FROM orders\nLIMIT 100",
+		},
+		{
+			"existing hint kept",
+			"SELECT /*+ USE_INDEX(orders, idx_status) */ * FROM orders",
+			"SELECT /*+ MAX_EXECUTION_TIME(5000) MEMORY_QUOTA(256 MB) USE_INDEX(orders, idx_status) */ * FROM orders\nLIMIT 100",
+		},
+		{
+			"own limit wrapped",
+			"SELECT * FROM orders LIMIT 5000",
+			"SELECT /*+ MAX_EXECUTION_TIME(5000) MEMORY_QUOTA(256 MB) */ * FROM (\nSELECT * FROM orders LIMIT 5000\n) AS latentia_sandbox LIMIT 100",
+		},
+		{
+			// An appended LIMIT applies to the whole UNION, not its last
+			// branch
+			"union limited as a whole",
+			"SELECT id FROM orders UNION SELECT id FROM archived_orders",
+			"SELECT /*+ MAX_EXECUTION_TIME(5000) MEMORY_QUOTA(256 MB) */ id FROM orders UNION SELECT id FROM archived_orders\nLIMIT 100",
+		},
+		{
+			"union branch with its own limit",
+			"SELECT id FROM orders UNION ALL (SELECT id FROM archived_orders LIMIT 100000)",
+			"SELECT /*+ MAX_EXECUTION_TIME(5000) MEMORY_QUOTA(256 MB) */ id FROM orders UNION ALL (SELECT id FROM archived_orders LIMIT 100000)\nLIMIT 100",
+		},
+		{
+			"union with a larger limit wrapped",
+			"SELECT id FROM orders UNION ALL SELECT id FROM archived_orders LIMIT 999999",
+			"SELECT /*+ MAX_EXECUTION_TIME(5000) MEMORY_QUOTA(256 MB) */ * FROM (\nSELECT id FROM orders UNION ALL SELECT id FROM archived_orders LIMIT 999999\n) AS latentia_sandbox LIMIT 100",
+		},
+		{
+			"explain not limited",
+			"EXPLAIN SELECT * FROM orders",
+			"EXPLAIN SELECT /*+ MAX_EXECUTION_TIME(5000) MEMORY_QUOTA(256 MB) */ * FROM orders",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, err := VerifyReadOnly(tt.sql)
+			if err != nil {
+				t.Fatalf("VerifyReadOnly(%q): %v", tt.sql, err)
+			}
+			if got := stmt.Sandboxed(5000, 256, 100); got != tt.want {
+				t.Errorf("Sandboxed() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule computes the next activation time after a given instant
+type Schedule interface {
+	Next(t time.Time) time.Time
+	String() string
+}
+
+// Parse accepts either a plain duration ("15m", "@every 1h") or a standard
+// five-field cron expression ("*/15 9-17 * * mon-fri") using the same syntax
+// as robfig/cron's standard parser, including @hourly/@daily/@weekly/@monthly
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("empty schedule")
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		return parseInterval(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")), spec)
+	}
+	if d, err := time.ParseDuration(spec); err == nil {
+		return newInterval(d, spec)
+	}
+
+	if descriptor, ok := descriptors[spec]; ok {
+		return parseCron(descriptor, spec)
+	}
+	return parseCron(spec, spec)
+}
+
+// Every returns a fixed-interval schedule
+func Every(d time.Duration) Schedule {
+	return &interval{every: d, spec: d.String()}
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// interval fires every fixed duration after the previous activation
+type interval struct {
+	every time.Duration
+	spec  string
+}
+
+func parseInterval(value, spec string) (Schedule, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interval %q: %w", spec, err)
+	}
+	return newInterval(d, spec)
+}
+
+func newInterval(d time.Duration, spec string) (Schedule, error) {
+	if d < time.Second {
+		return nil, fmt.Errorf("interval %q must be at least 1s", spec)
+	}
+	return &interval{every: d, spec: spec}, nil
+}
+
+func (i *interval) Next(t time.Time) time.Time {
+	return t.Add(i.every)
+}
+
+func (i *interval) String() string {
+	return i.spec
+}
+
+// cron is a parsed five-field expression; each field is a bitmask of the
+// allowed values
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+	spec                          string
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day-of-month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is accepted as an alias for Sunday, folded into bit 0 after parsing
+	dowField = field{name: "day-of-week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+func parseCron(expr, spec string) (Schedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected a duration or 5 cron fields, got %d fields", spec, len(parts))
+	}
+
+	c := &cron{spec: spec}
+	var err error
+	if c.minute, _, err = parseField(parts[0], minuteField); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+	}
+	if c.hour, _, err = parseField(parts[1], hourField); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+	}
+	if c.dom, c.domStar, err = parseField(parts[2], domField); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+	}
+	if c.month, _, err = parseField(parts[3], monthField); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+	}
+	if c.dow, c.dowStar, err = parseField(parts[4], dowField); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow = c.dow&^(1<<7) | 1
+	}
+
+	return c, nil
+}
+
+// parseField parses a comma-separated list of values, ranges and steps. It
+// reports whether the field is unrestricted, as robfig/cron's star bit:
+// some item is "*" or "?" without a step other than 1, so "*/2" restricts
+// the field while "*/1" does not.
+func parseField(expr string, f field) (mask uint64, star bool, err error) {
+	for _, item := range strings.Split(expr, ",") {
+		bitsForItem, itemStar, err := parseItem(strings.ToLower(item), f)
+		if err != nil {
+			return 0, false, err
+		}
+		mask |= bitsForItem
+		star = star || itemStar
+	}
+	return mask, star, nil
+}
+
+func parseItem(item string, f field) (uint64, bool, error) {
+	rangePart, step := item, 1
+	if idx := strings.Index(item, "/"); idx >= 0 {
+		rangePart = item[:idx]
+		n, err := strconv.Atoi(item[idx+1:])
+		if err != nil || n <= 0 {
+			return 0, false, fmt.Errorf("%s: invalid step in %q", f.name, item)
+		}
+		step = n
+	}
+	star := (rangePart == "*" || rangePart == "?") && step == 1
+
+	lo, hi := f.min, f.max
+	switch {
+	case rangePart == "*" || rangePart == "?":
+	case strings.Contains(rangePart, "-"):
+		bounds := strings.SplitN(rangePart, "-", 2)
+		var err error
+		if lo, err = f.value(bounds[0]); err != nil {
+			return 0, false, err
+		}
+		if hi, err = f.value(bounds[1]); err != nil {
+			return 0, false, err
+		}
+		if lo > hi {
+			return 0, false, fmt.Errorf("%s: range %q is inverted", f.name, rangePart)
+		}
+	default:
+		v, err := f.value(rangePart)
+		if err != nil {
+			return 0, false, err
+		}
+		lo = v
+		// "5/10" means starting at 5 through the max, like robfig/cron
+		if step == 1 {
+			hi = v
+		}
+	}
+
+	var mask uint64
+	for v := lo; v <= hi; v += step {
+		mask |= 1 << uint(v)
+	}
+	return mask, star, nil
+}
+
+func (f field) value(s string) (int, error) {
+	if v, ok := f.names[s]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid value %q", f.name, s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s: %d out of range %d-%d", f.name, v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Next returns the first matching minute strictly after t, or the zero time
+// if the expression can never match (e.g. "0 0 30 2 *")
+func (c *cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches applies the usual cron rule: when both day fields are
+// restricted, steps such as "*/2" included, a day matching either one
+// qualifies
+func (c *cron) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func (c *cron) String() string {
+	return c.spec
+}
@@ -0,0 +1,178 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// at is a UTC minute in March 2026; March 2 is a Monday
+func at(day, hour, minute int) time.Time {
+	return time.Date(2026, time.March, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestParseIntervals(t *testing.T) {
+	for spec, want := range map[string]time.Duration{
+		"15m":         15 * time.Minute,
+		"1h30m":       90 * time.Minute,
+		"@every 1h":   time.Hour,
+		"@every  10s": 10 * time.Second,
+		" 2h ":        2 * time.Hour,
+	} {
+		s, err := Parse(spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", spec, err)
+			continue
+		}
+		from := at(2, 10, 0)
+		if got := s.Next(from).Sub(from); got != want {
+			t.Errorf("Parse(%q) fires after %v, want %v", spec, got, want)
+		}
+	}
+}
+
+func TestParseRefuses(t *testing.T) {
+	for spec, want := range map[string]string{
+		"":                 "empty schedule",
+		"500ms":            "at least 1s",
+		"@every 0s":        "at least 1s",
+		"@every soon":      "invalid interval",
+		"* * * *":          "got 4 fields",
+		"* * * * * *":      "got 6 fields",
+		"60 * * * *":       "minute: 60 out of range 0-59",
+		"* 24 * * *":       "hour: 24 out of range 0-23",
+		"* * 0 * *":        "day-of-month: 0 out of range 1-31",
+		"* * * 13 *":       "month: 13 out of range 1-12",
+		"* * * * 8":        "day-of-week: 8 out of range 0-7",
+		"* * * * funday":   `day-of-week: invalid value "funday"`,
+		"*/0 * * * *":      "invalid step",
+		"*/x * * * *":      "invalid step",
+		"30-10 * * * *":    "inverted",
+		"@fortnightly":     "expected a duration or 5 cron fields",
+		"0 9-17 * * mon-x": "invalid value",
+	} {
+		_, err := Parse(spec)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse(%q) = %v, want an error containing %q", spec, err, want)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", at(2, 10, 7), at(2, 10, 15)},
+		{"*/15 * * * *", at(2, 10, 45), at(2, 11, 0)},
+		// Strictly after: a matching minute moves on to the next one
+		{"0 * * * *", at(2, 10, 0), at(2, 11, 0)},
+		{"5/20 * * * *", at(2, 10, 30), at(2, 10, 45)},
+		{"0,30 9 * * *", at(2, 9, 10), at(2, 9, 30)},
+		{"*/15 9-17 * * mon-fri", at(2, 17, 50), at(3, 9, 0)},
+		{"*/15 9-17 * * mon-fri", at(6, 18, 0), at(9, 9, 0)},
+		{"0 8 * * MON", at(3, 8, 0), at(9, 8, 0)},
+		// 7 is Sunday, as 0
+		{"0 8 * * 7", at(2, 8, 0), at(8, 8, 0)},
+		{"0 0 1 * *", at(2, 0, 0), time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * jun *", at(2, 0, 0), time.Date(2026, time.June, 1, 12, 0, 0, 0, time.UTC)},
+		{"@hourly", at(2, 10, 59), at(2, 11, 0)},
+		{"@daily", at(2, 10, 0), at(3, 0, 0)},
+		{"@weekly", at(2, 10, 0), at(8, 0, 0)},
+		{"@monthly", at(2, 10, 0), time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", at(2, 10, 0), time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		// February 29 of the next leap year
+		{"0 0 29 2 *", at(2, 0, 0), time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := s.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q after %v = %v, want %v", tt.spec, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestCronNeverMatches(t *testing.T) {
+	s, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got := s.Next(at(2, 0, 0)); !got.IsZero() {
+		t.Errorf("Next = %v, want the zero time", got)
+	}
+}
+
+// When both day fields are restricted a day matching either one fires; an
+// unrestricted one leaves the other alone, as in robfig/cron
+func TestCronDayFields(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []int // days of March 2026 that fire, from March 1
+	}{
+		// Day 15, or any Monday
+		{"0 0 15 * mon", []int{2, 9, 15, 16}},
+		// A step restricts the field: odd days, or any Monday
+		{"0 0 */2 * mon", []int{1, 2, 3, 5, 7, 9, 11, 13, 15, 16, 17}},
+		{"0 0 * * mon", []int{2, 9, 16}},
+		{"0 0 ? * mon", []int{2, 9, 16}},
+		// "*/1" is as unrestricted as "*"
+		{"0 0 */1 * mon", []int{2, 9, 16}},
+		{"0 0 10-12 * *", []int{10, 11, 12}},
+		{"0 0 10-12 * ?", []int{10, 11, 12}},
+		// Odd weekdays, or day 10
+		{"0 0 10 * */2", []int{1, 3, 5, 7, 8, 10, 12, 14, 15, 17}},
+		// A star in a list also leaves the field unrestricted
+		{"0 0 *,15 * mon", []int{2, 9, 16}},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.spec, err)
+			continue
+		}
+		var got []int
+		next := at(1, 0, 0).Add(-time.Minute)
+		for len(got) < len(tt.want) {
+			next = s.Next(next)
+			if next.IsZero() || next.Month() != time.March {
+				break
+			}
+			got = append(got, next.Day())
+		}
+		if !equalInts(got, tt.want) {
+			t.Errorf("%q fires on March %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStringIsTheSpec(t *testing.T) {
+	for _, spec := range []string{"15m", "@every 1h", "@daily", "*/15 9-17 * * mon-fri"} {
+		s, err := Parse(spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", spec, err)
+		}
+		if s.String() != spec {
+			t.Errorf("String() = %q, want %q", s.String(), spec)
+		}
+	}
+	if got := Every(time.Minute).String(); got != "1m0s" {
+		t.Errorf("Every(time.Minute).String() = %q", got)
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/matthieukhl/latentia/internal/config"
+	"github.com/matthieukhl/latentia/internal/database"
+)
+
+// access is the least role allowed to call a route. The zero value is not a
+// valid declaration, so a route added without one fails at startup.
+type access int
+
+const (
+	accessUndeclared access = iota
+	accessPublic
+	accessViewer
+	accessReviewer
+	accessAdmin
+)
+
+var roleAccess = map[string]access{
+	config.RoleViewer:   accessViewer,
+	config.RoleReviewer: accessReviewer,
+	config.RoleAdmin:    accessAdmin,
+}
+
+func (a access) String() string {
+	for role, level := range roleAccess {
+		if level == a {
+			return role
+		}
+	}
+	if a == accessPublic {
+		return "public"
+	}
+	return "undeclared"
+}
+
+// credential is a configured API key with the name it is logged under
+type credential struct {
+	key   string
+	name  string
+	actor string
+	role  string
+}
+
+// credentials lists server.auth.keys followed by the legacy server.api_keys,
+// which have the admin role and are named by a fingerprint
+func credentials(cfg config.ServerConfig) []credential {
+	creds := make([]credential, 0, len(cfg.Auth.Keys)+len(cfg.APIKeys))
+	for _, k := range cfg.Auth.Keys {
+		creds = append(creds, credential{key: k.Key(), name: k.Name, actor: "api:" + k.Name, role: k.Role})
+	}
+	for _, key := range cfg.APIKeys {
+		actor := apiKeyActor(key)
+		creds = append(creds, credential{key: key, name: strings.TrimPrefix(actor, "api:"), actor: actor, role: config.RoleAdmin})
+	}
+	return creds
+}
+
+// apiKeyActor names an unnamed key in the audit log by a short fingerprint,
+// never the key itself
+func apiKeyActor(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "api:" + hex.EncodeToString(sum[:4])
+}
+
+// matchCredential returns the credential whose key is token, or nil. Keys
+// are compared by digest against every credential, so the time taken tells
+// neither the length of a key nor which one matched.
+func matchCredential(creds []credential, token string) *credential {
+	tokenSum := sha256.Sum256([]byte(token))
+	var match *credential
+	for i := range creds {
+		keySum := sha256.Sum256([]byte(creds[i].key))
+		if subtle.ConstantTimeCompare(tokenSum[:], keySum[:]) == 1 && match == nil {
+			match = &creds[i]
+		}
+	}
+	return match
+}
+
+// authorize only lets through requests presenting a configured API key as a
+// bearer token whose role is at least required, attributing audited changes
+// to that key. An unknown key gets 401, a known key with too low a role 403.
+// With no keys configured protected routes are disabled.
+func authorize(required access) gin.HandlerFunc {
+	if required == accessPublic {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return func(c *gin.Context) {
+		creds := credentials(config.Current().Server)
+		if len(creds) == 0 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "endpoint disabled: configure server.auth.keys or server.api_keys to enable it",
+			})
+			return
+		}
+
+		var cred *credential
+		if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+			cred = matchCredential(creds, token)
+		}
+		if cred == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "missing or invalid API key",
+			})
+			return
+		}
+
+		ctx := database.WithRole(database.WithActor(c.Request.Context(), cred.actor), cred.role)
+		if roleAccess[cred.role] < required {
+			slog.WarnContext(ctx, "api request denied", "method", c.Request.Method, "route", c.FullPath(),
+				"key", cred.name, "role", cred.role, "required", required.String())
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": fmt.Sprintf("API key '%s' has role %s; this endpoint requires %s", cred.name, cred.role, required),
+			})
+			return
+		}
+
+		// logRequests records the request with its actor once it is served
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
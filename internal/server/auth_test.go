@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/matthieukhl/latentia/internal/config/configtest"
+)
+
+const authConfig = `
+server:
+  api_keys: ["legacy-key-0123"]
+  auth:
+    keys:
+      - name: ci
+        role: admin
+        api_key: "ci-key-4567"
+      - name: rotated
+        role: admin
+        api_key: "rotated-key-89ab"
+`
+
+// errorBody decodes the JSON error of a refused request
+func errorBody(t *testing.T, body string) string {
+	t.Helper()
+	var resp struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("response is not a JSON error: %q", body)
+	}
+	return resp.Error
+}
+
+func TestAuthRejectsMissingKey(t *testing.T) {
+	configtest.Load(t, authConfig)
+	s := newTestServer(t)
+
+	for _, header := range []string{"", "Bearer", "Bearer ", "Basic Y2kta2V5LTQ1Njc=", "ci-key-4567", "bearer ci-key-4567"} {
+		req, _ := http.NewRequest(http.MethodGet, "/api/config", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		w := serveRequest(s, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status = %d, want 401", header, w.Code)
+			continue
+		}
+		if got := errorBody(t, w.Body.String()); got != "missing or invalid API key" {
+			t.Errorf("Authorization %q: error = %q", header, got)
+		}
+	}
+}
+
+func TestAuthRejectsWrongKey(t *testing.T) {
+	configtest.Load(t, authConfig)
+	s := newTestServer(t)
+
+	for _, key := range []string{
+		"wrong-key",
+		"ci-key-456",        // a prefix
+		"ci-key-45678",      // a longer key
+		"CI-KEY-4567",       // keys are case-sensitive
+		"ci-key-4567 ",      // no trimming
+		"legacy-key-0123\n", // nor of newlines
+		"ci",                // the key's name
+	} {
+		w := serve(s, http.MethodGet, "/api/config", key, nil)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("key %q: status = %d, want 401", key, w.Code)
+			continue
+		}
+		if strings.Contains(w.Body.String(), "ci-key-4567") {
+			t.Errorf("key %q: response leaks a configured key: %s", key, w.Body.String())
+		}
+	}
+}
+
+func TestAuthAcceptsValidKeys(t *testing.T) {
+	configtest.Load(t, authConfig)
+	s := newTestServer(t)
+
+	// Several keys are valid at once, so one can be rotated out without
+	// downtime; legacy server.api_keys still work
+	for _, key := range []string{"ci-key-4567", "rotated-key-89ab", "legacy-key-0123"} {
+		for _, path := range []string{"/api/config", "/api/jobs"} {
+			if w := serve(s, http.MethodGet, path, key, nil); w.Code != http.StatusOK {
+				t.Errorf("GET %s with key %q: status = %d, want 200: %s", path, key, w.Code, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestAuthDisabledWithoutKeys(t *testing.T) {
+	configtest.Load(t, "")
+	s := newTestServer(t)
+
+	w := serve(s, http.MethodGet, "/api/config", "any-key", nil)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", w.Code)
+	}
+	if got := errorBody(t, w.Body.String()); !strings.HasPrefix(got, "endpoint disabled") {
+		t.Errorf("error = %q", got)
+	}
+}
+
+func TestAuthLeavesPublicRoutesOpen(t *testing.T) {
+	configtest.Load(t, authConfig)
+	s := newTestServer(t)
+
+	for _, path := range []string{"/metrics", "/api/openapi.json", "/api/docs"} {
+		if w := serve(s, http.MethodGet, path, "", nil); w.Code != http.StatusOK {
+			t.Errorf("GET %s without a key: status = %d, want 200", path, w.Code)
+		}
+	}
+	// Health is public too: it reports the unreachable database, not 401
+	if w := serve(s, http.MethodGet, "/api/health", "", nil); w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden {
+		t.Errorf("GET /api/health without a key: status = %d", w.Code)
+	}
+}
+
+func TestMatchCredential(t *testing.T) {
+	creds := []credential{{key: "a-key", name: "a"}, {key: "b-key", name: "b"}, {key: "a-key", name: "duplicate"}}
+	if got := matchCredential(creds, "b-key"); got == nil || got.name != "b" {
+		t.Errorf("matchCredential(b-key) = %+v, want b", got)
+	}
+	// The first credential with the key wins
+	if got := matchCredential(creds, "a-key"); got == nil || got.name != "a" {
+		t.Errorf("matchCredential(a-key) = %+v, want a", got)
+	}
+	if got := matchCredential(creds, ""); got != nil {
+		t.Errorf("matchCredential(\"\") = %+v, want nil", got)
+	}
+	if got := apiKeyActor("legacy-key-0123"); !strings.HasPrefix(got, "api:") || strings.Contains(got, "legacy") || len(got) != len("api:")+8 {
+		t.Errorf("apiKeyActor = %q, want api: and an 8 digit fingerprint", got)
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/matthieukhl/latentia/internal/rag"
+	"github.com/matthieukhl/latentia/internal/server/dto"
+)
+
+const (
+	defaultSearchTopK = 5
+	maxSearchTopK     = 50
+)
+
+// SetDocuments exposes the knowledge base through /api/search and
+// /api/documents, which answer 503 until it is set
+func (s *Server) SetDocuments(docs *rag.DocumentStore) {
+	s.docs = docs
+}
+
+// documentsAvailable answers 503 when no document store was set
+func (s *Server) documentsAvailable(c *gin.Context) bool {
+	if s.docs == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "document search is unavailable: the embedder failed to start or does not match app_embeddings"})
+		return false
+	}
+	return true
+}
+
+// searchDocuments returns the chunks the optimizer would retrieve for q,
+// with the search options of vector.* restricted to the comma-separated
+// categories of category
+func (s *Server) searchDocuments(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	topK := defaultSearchTopK
+	if v := c.Query("top_k"); v != "" {
+		var err error
+		if topK, err = strconv.Atoi(v); err != nil || topK < 1 || topK > maxSearchTopK {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid top_k: must be between 1 and %d", maxSearchTopK)})
+			return
+		}
+	}
+
+	opts := rag.NewSearchOptions(topK)
+	for _, category := range strings.Split(c.Query("category"), ",") {
+		if category = strings.TrimSpace(category); category != "" {
+			opts.Categories = append(opts.Categories, category)
+		}
+	}
+
+	if !s.documentsAvailable(c) {
+		return
+	}
+	ctx := c.Request.Context()
+	results := []rag.SearchResult{}
+	embedded, err := s.docs.HasEmbeddings(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if embedded {
+		found, err := s.docs.Search(ctx, query, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		results = append(results, found...)
+	}
+
+	c.JSON(http.StatusOK, dto.Search{Query: query, Results: results})
+}
+
+// listDocuments returns the stored documents with their number of chunks
+func (s *Server) listDocuments(c *gin.Context) {
+	if !s.documentsAvailable(c) {
+		return
+	}
+	docs, err := s.docs.ListDocuments(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	documents := make([]dto.Document, len(docs))
+	for i, doc := range docs {
+		documents[i] = dto.Document{ID: doc.ID, Title: doc.Title, Category: doc.Category, URL: doc.URL, Chunks: doc.Chunks}
+	}
+	c.JSON(http.StatusOK, dto.Documents{Documents: documents})
+}
+
+// deleteDocument removes document :id and its embeddings
+func (s *Server) deleteDocument(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid document id"})
+		return
+	}
+	if !s.documentsAvailable(c) {
+		return
+	}
+
+	err = s.docs.DeleteDocument(c.Request.Context(), id)
+	if errors.Is(err, rag.ErrDocumentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Deleted{ID: id, Deleted: true})
+}
@@ -0,0 +1,216 @@
+// Package dto holds the request and response bodies of the agent API. The
+// handlers, the OpenAPI document served at /api/openapi.json and Go clients
+// share these types, so they cannot drift apart.
+package dto
+
+import (
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/analyze"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/ingest"
+	"github.com/matthieukhl/latentia/internal/models"
+	"github.com/matthieukhl/latentia/internal/rag"
+	"github.com/matthieukhl/latentia/internal/worker"
+)
+
+// Error is the body of every 4xx and 5xx response
+type Error struct {
+	Error string `json:"error"`
+}
+
+// ReviewRequest is the optional body of the accept and reject endpoints of
+// index recommendations
+type ReviewRequest struct {
+	Reason string `json:"reason"`
+}
+
+// RewriteReviewRequest is the body of the accept and reject endpoints of
+// rewrites, optional on accept. Reviewer names who decided when the API key
+// is shared, and defaults to the key.
+type RewriteReviewRequest struct {
+	Reason   string `json:"reason"`
+	Reviewer string `json:"reviewer,omitempty"`
+}
+
+// BenchmarkRequest is the optional body of POST /api/rewrites/{id}/benchmark
+type BenchmarkRequest struct {
+	Runs int `json:"runs"`
+}
+
+// AnalyzeRequest is the body of POST /api/analyze
+type AnalyzeRequest struct {
+	SQL string `json:"sql"`
+	DB  string `json:"db"`
+
+	// Target is the db.targets entry the query is explained on, the
+	// default one when empty
+	Target string `json:"target"`
+
+	// Force calls the LLM even when the digest has a recent rewrite
+	Force bool `json:"force"`
+}
+
+// RetryRequest is the optional body of POST /api/rewrites/{id}/retry
+type RetryRequest struct {
+	Feedback string `json:"feedback"`
+}
+
+// SuppressionRequest is the body of POST /api/suppressions
+type SuppressionRequest struct {
+	Digest        string     `json:"digest"`
+	DigestPattern string     `json:"digest_pattern"`
+	Reason        string     `json:"reason"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+}
+
+// Health answers GET /api/health; Error is set when the database does not
+// answer
+type Health struct {
+	Status  string `json:"status"`
+	Service string `json:"service,omitempty"`
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Jobs answers GET /api/jobs
+type Jobs struct {
+	Jobs []worker.JobStatus `json:"jobs"`
+}
+
+// Targets answers GET /api/targets with the names of the enabled targets
+type Targets struct {
+	Targets []string `json:"targets"`
+	Default string   `json:"default"`
+}
+
+// IngestStatus answers GET /api/ingest/status. Status is nil when ingestion
+// is not scheduled; the schedule fields are those of the ingest job.
+type IngestStatus struct {
+	Scheduled bool                 `json:"scheduled"`
+	Status    *ingest.IngestStatus `json:"status,omitempty"`
+	Schedule  string               `json:"schedule,omitempty"`
+	NextRun   *time.Time           `json:"next_run,omitempty"`
+	Running   bool                 `json:"running,omitempty"`
+}
+
+// Config answers GET /api/config with secrets masked
+type Config struct {
+	File    string         `json:"file"`
+	Profile string         `json:"profile"`
+	Config  map[string]any `json:"config"`
+}
+
+// Audit answers GET /api/audit
+type Audit struct {
+	Entries []database.AuditEntry `json:"entries"`
+}
+
+// Stats answers GET /api/stats
+type Stats struct {
+	Rewrites    map[string]int         `json:"rewrites"` // by status
+	Realized    *analyze.RealizedStats `json:"realized"`
+	Suppressed  SuppressedStats        `json:"suppressed"`
+	Calibration *analyze.Calibration   `json:"calibration"`
+}
+
+// SuppressedStats counts what suppressions are hiding
+type SuppressedStats struct {
+	ActiveSuppressions int `json:"active_suppressions"`
+	SlowQueries        int `json:"slow_queries"`
+	Rewrites           int `json:"rewrites"`
+}
+
+// Rewrites answers GET /api/rewrites
+type Rewrites struct {
+	Rewrites []analyze.OptimizationResult `json:"rewrites"`
+}
+
+// RewriteHistory answers GET /api/rewrites/{id}/history
+type RewriteHistory struct {
+	RewriteID int64                    `json:"rewrite_id"`
+	History   []analyze.RewriteSummary `json:"history"`
+}
+
+// SlowQueryStats answers GET /api/slow-queries/stats
+type SlowQueryStats struct {
+	Digests []database.SlowQueryStats `json:"digests"`
+}
+
+// SlowQueries answers GET /api/slow-queries with a page of the matches
+type SlowQueries struct {
+	SlowQueries []models.SlowQuery `json:"slow_queries"`
+	Total       int                `json:"total"`
+	Page        int                `json:"page"`
+	PageSize    int                `json:"page_size"`
+}
+
+// SlowQuery answers GET /api/slow-queries/{id}
+type SlowQuery struct {
+	SlowQuery *models.SlowQuery        `json:"slow_query"`
+	Rewrites  []analyze.RewriteSummary `json:"rewrites"`
+}
+
+// DigestHistory answers GET /api/digests/{digest}/history with a page of
+// the digest's occurrences
+type DigestHistory struct {
+	Digest          string                  `json:"digest"`
+	AcceptedRewrite *analyze.DigestRewrite  `json:"accepted_rewrite"`
+	Rewrites        []analyze.DigestRewrite `json:"rewrites"`
+	Occurrences     []models.SlowQuery      `json:"occurrences"`
+	Total           int                     `json:"total"`
+	Page            int                     `json:"page"`
+	PageSize        int                     `json:"page_size"`
+}
+
+// Usage answers GET /api/usage
+type Usage struct {
+	Since string              `json:"since"` // YYYY-MM-DD, UTC
+	Usage []database.LLMUsage `json:"usage"`
+	Total UsageTotal          `json:"total"`
+}
+
+// UsageTotal sums the usage of every day and model
+type UsageTotal struct {
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	EstimatedCost    float64 `json:"estimated_cost"`
+}
+
+// Suppressions answers GET /api/suppressions
+type Suppressions struct {
+	Suppressions database.Suppressions `json:"suppressions"`
+}
+
+// Deleted answers a DELETE
+type Deleted struct {
+	ID      int64 `json:"id"`
+	Deleted bool  `json:"deleted"`
+}
+
+// IndexRecommendations answers GET /api/index-recommendations
+type IndexRecommendations struct {
+	IndexRecommendations []database.IndexRecommendation `json:"index_recommendations"`
+}
+
+// Search answers GET /api/search with the chunks the optimizer would
+// retrieve for the query, best first
+type Search struct {
+	Query   string             `json:"query"`
+	Results []rag.SearchResult `json:"results"`
+}
+
+// Document is a stored document of the knowledge base, without its content
+type Document struct {
+	ID       int64  `json:"id"`
+	Title    string `json:"title"`
+	Category string `json:"category"`
+	URL      string `json:"url,omitempty"`
+	Chunks   int    `json:"chunks"`
+}
+
+// Documents answers GET /api/documents
+type Documents struct {
+	Documents []Document `json:"documents"`
+}
@@ -0,0 +1,533 @@
+package server
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/matthieukhl/latentia/internal/analyze"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/ingest"
+	"github.com/matthieukhl/latentia/internal/server/dto"
+)
+
+// version is the API version reported by /api/health and the OpenAPI
+// document
+const version = "0.1.0"
+
+// routeDoc describes a route in the OpenAPI document. Every route declares
+// one, so the document served at /api/openapi.json lists exactly the routes
+// of the router.
+type routeDoc struct {
+	summary string
+	query   []param
+
+	// request and response are values of the JSON bodies, nil for none;
+	// contentType replaces the JSON response of routes serving text
+	request     any
+	response    any
+	contentType string
+
+	// statuses are the success statuses, 200 when empty, and errors the
+	// failures besides those of authentication
+	statuses []int
+	errors   []int
+}
+
+// param is a query parameter
+type param struct {
+	name        string
+	typ         string // string, integer, number or boolean
+	format      string
+	enum        []string
+	required    bool
+	description string
+}
+
+var (
+	limitParam    = param{name: "limit", typ: "integer", description: "Most results returned"}
+	pageParam     = param{name: "page", typ: "integer", description: "Page, from 1"}
+	pageSizeParam = param{name: "page_size", typ: "integer", description: "Results per page"}
+	includeParam  = param{name: "include", typ: "string", enum: []string{"raw"}, description: "raw adds the prompt and completion of rewrites"}
+	targetParam   = param{name: "target", typ: "string", description: "Only those of this db.targets entry"}
+)
+
+var (
+	metricsDoc = &routeDoc{summary: "Pipeline metrics in the Prometheus text format", contentType: "text/plain"}
+	healthDoc  = &routeDoc{summary: "Report whether the database answers", response: dto.Health{}, errors: []int{503}}
+	jobsDoc    = &routeDoc{summary: "List background jobs with their schedule and next run", response: dto.Jobs{}}
+	ingestDoc  = &routeDoc{summary: "Report the last run of the scheduled ingestion", response: dto.IngestStatus{}}
+	targetsDoc = &routeDoc{summary: "List the enabled targets, the default one first", response: dto.Targets{}}
+	configDoc  = &routeDoc{
+		summary:  "Show the resolved configuration with secrets masked",
+		query:    []param{{name: "provenance", typ: "boolean", description: "Annotate every value with its source"}},
+		response: dto.Config{},
+	}
+	auditDoc = &routeDoc{
+		summary: "List audit log entries, most recent first",
+		query: []param{
+			{name: "actor", typ: "string"},
+			{name: "action", typ: "string"},
+			{name: "rewrite_id", typ: "integer"},
+			{name: "since", typ: "string", format: "date-time"},
+			{name: "until", typ: "string", format: "date-time"},
+			limitParam,
+		},
+		response: dto.Audit{},
+		errors:   []int{400},
+	}
+	statsDoc    = &routeDoc{summary: "Count rewrites by status, their acceptance by confidence decile and what suppressions hide", response: dto.Stats{}}
+	rewritesDoc = &routeDoc{
+		summary: "List rewrites in a status, pending by default",
+		query: []param{
+			{name: "status", typ: "string", enum: analyze.RewriteStatuses},
+			{name: "sort", typ: "string", enum: analyze.RewriteSorts},
+			targetParam,
+			limitParam,
+		},
+		response: dto.Rewrites{},
+		errors:   []int{400},
+	}
+	rewriteDoc = &routeDoc{
+		summary:  "Get a rewrite with its SQL diff",
+		query:    []param{includeParam},
+		response: analyze.OptimizationResult{},
+		errors:   []int{400, 404},
+	}
+	acceptDoc = &routeDoc{
+		summary:  "Accept a pending rewrite",
+		request:  dto.RewriteReviewRequest{},
+		response: analyze.OptimizationResult{},
+		errors:   []int{400, 404, 409},
+	}
+	rejectDoc = &routeDoc{
+		summary:  "Reject a pending rewrite, giving a reason",
+		request:  dto.RewriteReviewRequest{},
+		response: analyze.OptimizationResult{},
+		errors:   []int{400, 404, 409},
+	}
+	benchmarkDoc = &routeDoc{
+		summary:  "Time the original and optimized SQL of a rewrite in the sandbox",
+		request:  dto.BenchmarkRequest{},
+		response: analyze.Benchmark{},
+		errors:   []int{400, 404, 422},
+	}
+	retryDoc = &routeDoc{
+		summary:  "Ask the LLM again for a rejected or invalid rewrite",
+		query:    []param{includeParam},
+		request:  dto.RetryRequest{},
+		response: analyze.OptimizationResult{},
+		statuses: []int{201},
+		errors:   []int{400, 404, 409, 422, 429, 503, 504},
+	}
+	historyDoc = &routeDoc{
+		summary:  "List the retries a rewrite belongs to, first proposal first",
+		response: dto.RewriteHistory{},
+		errors:   []int{400, 404},
+	}
+	analyzeDoc = &routeDoc{
+		summary:  "Optimize submitted SQL; 200 returns a recent rewrite of its digest",
+		query:    []param{includeParam},
+		request:  dto.AnalyzeRequest{},
+		response: analyze.OptimizationResult{},
+		statuses: []int{201, 200},
+		errors:   []int{400, 422, 429, 503, 504},
+	}
+	slowQueryStatsDoc = &routeDoc{
+		summary:  "List digests by total query time",
+		query:    []param{limitParam},
+		response: dto.SlowQueryStats{},
+		errors:   []int{400},
+	}
+	slowQueriesDoc = &routeDoc{
+		summary: "List captured slow queries, most recent first",
+		query: []param{
+			targetParam,
+			{name: "digest", typ: "string"},
+			{name: "status", typ: "string", enum: ingest.SlowQueryStatuses},
+			{name: "source", typ: "string", enum: ingest.SlowQuerySources},
+			{name: "db", typ: "string"},
+			{name: "min_query_time", typ: "number", description: "Seconds"},
+			{name: "since", typ: "string", format: "date-time"},
+			pageParam, pageSizeParam,
+		},
+		response: dto.SlowQueries{},
+		errors:   []int{400},
+	}
+	slowQueryDoc = &routeDoc{
+		summary:  "Get a slow query with the rewrites proposed for it",
+		response: dto.SlowQuery{},
+		errors:   []int{400, 404},
+	}
+	digestHistoryDoc = &routeDoc{
+		summary:  "Get the rewrites and occurrences of a digest",
+		query:    []param{pageParam, pageSizeParam},
+		response: dto.DigestHistory{},
+		errors:   []int{400, 404},
+	}
+	usageDoc = &routeDoc{
+		summary:  "Report generator tokens and cost per day and model",
+		query:    []param{{name: "days", typ: "integer", description: "Days reported, today included"}},
+		response: dto.Usage{},
+		errors:   []int{400},
+	}
+	exportDoc = &routeDoc{
+		summary: "Export the latest rewrite of every digest in a status as Markdown, SQL or JSON",
+		query: []param{
+			{name: "format", typ: "string", enum: analyze.ExportFormats, description: "json by default"},
+			{name: "status", typ: "string", enum: analyze.RewriteStatuses, description: "accepted by default"},
+			{name: "since", typ: "string", description: "RFC 3339 time or YYYY-MM-DD, UTC"},
+		},
+		response: analyze.Export{},
+		errors:   []int{400},
+	}
+	suppressionsDoc = &routeDoc{
+		summary:  "List active suppressions",
+		query:    []param{{name: "all", typ: "boolean", description: "Include expired suppressions"}},
+		response: dto.Suppressions{},
+	}
+	createSuppressionDoc = &routeDoc{
+		summary:  "Suppress a digest or digest pattern",
+		request:  dto.SuppressionRequest{},
+		response: database.Suppression{},
+		statuses: []int{201},
+		errors:   []int{400},
+	}
+	deleteSuppressionDoc = &routeDoc{
+		summary:  "Delete a suppression",
+		response: dto.Deleted{},
+		errors:   []int{400, 404},
+	}
+	indexRecommendationsDoc = &routeDoc{
+		summary: "List index recommendations, newest first",
+		query: []param{
+			{name: "rewrite_id", typ: "integer"},
+			{name: "status", typ: "string", enum: []string{database.IndexPending, database.IndexApproved, database.IndexRejected, database.IndexApplied}},
+			limitParam,
+		},
+		response: dto.IndexRecommendations{},
+		errors:   []int{400},
+	}
+	approveIndexDoc = &routeDoc{
+		summary:  "Approve a pending index recommendation",
+		request:  dto.ReviewRequest{},
+		response: database.IndexRecommendation{},
+		errors:   []int{400, 404, 409},
+	}
+	rejectIndexDoc = &routeDoc{
+		summary:  "Reject a pending index recommendation",
+		request:  dto.ReviewRequest{},
+		response: database.IndexRecommendation{},
+		errors:   []int{400, 404, 409},
+	}
+	searchDoc = &routeDoc{
+		summary: "Search the knowledge base as the optimizer does when building a prompt",
+		query: []param{
+			{name: "q", typ: "string", required: true},
+			{name: "top_k", typ: "integer", description: "Most chunks returned, 5 by default and at most 50"},
+			{name: "category", typ: "string", description: "Comma-separated categories searched, all by default"},
+		},
+		response: dto.Search{},
+		errors:   []int{400, 503},
+	}
+	documentsDoc = &routeDoc{
+		summary:  "List stored documents with their number of embedded chunks",
+		response: dto.Documents{},
+		errors:   []int{503},
+	}
+	deleteDocumentDoc = &routeDoc{
+		summary:  "Delete a document and its embeddings",
+		response: dto.Deleted{},
+		errors:   []int{400, 404, 503},
+	}
+	openAPIDoc = &routeDoc{summary: "This OpenAPI document", contentType: "application/json"}
+	apiDocsDoc = &routeDoc{summary: "Swagger UI for this API", contentType: "text/html"}
+)
+
+// openAPI builds the OpenAPI 3 document of routes under prefix
+func openAPI(prefix string, routes []route) map[string]any {
+	schemas := &schemaSet{components: map[string]any{}, names: map[reflect.Type]string{}}
+	paths := map[string]map[string]any{}
+	for _, r := range routes {
+		path := openAPIPath(r.path)
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(r.method)] = operation(r, schemas)
+	}
+
+	server := prefix
+	if server == "" {
+		server = "/"
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Latentia agent API",
+			"version":     version,
+			"description": "Review the SQL rewrites proposed for slow TiDB queries.",
+		},
+		"servers": []any{map[string]any{"url": server}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "A key of server.auth.keys, whose role must allow the route",
+				},
+			},
+		},
+	}
+}
+
+// openAPIPath turns gin's :param segments into {param}
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if name, ok := strings.CutPrefix(s, ":"); ok {
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func operation(r route, schemas *schemaSet) map[string]any {
+	doc := r.doc
+	op := map[string]any{
+		"summary":     doc.summary,
+		"operationId": operationID(r.method, r.path),
+		"tags":        []string{operationTag(r.path)},
+	}
+
+	var params []any
+	for _, s := range strings.Split(r.path, "/") {
+		if name, ok := strings.CutPrefix(s, ":"); ok {
+			schema := map[string]any{"type": "string"}
+			if name == "id" {
+				schema = map[string]any{"type": "integer", "format": "int64", "minimum": 1}
+			}
+			params = append(params, map[string]any{"name": name, "in": "path", "required": true, "schema": schema})
+		}
+	}
+	for _, p := range doc.query {
+		schema := map[string]any{"type": p.typ}
+		if p.format != "" {
+			schema["format"] = p.format
+		}
+		if len(p.enum) > 0 {
+			schema["enum"] = p.enum
+		}
+		param := map[string]any{"name": p.name, "in": "query", "schema": schema}
+		if p.required {
+			param["required"] = true
+		}
+		if p.description != "" {
+			param["description"] = p.description
+		}
+		params = append(params, param)
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if doc.request != nil {
+		op["requestBody"] = map[string]any{
+			"content": jsonContent(schemas.of(reflect.TypeOf(doc.request))),
+		}
+	}
+
+	responses := map[string]any{}
+	statuses := doc.statuses
+	if len(statuses) == 0 {
+		statuses = []int{http.StatusOK}
+	}
+	for _, status := range statuses {
+		response := map[string]any{"description": http.StatusText(status)}
+		switch {
+		case doc.contentType != "":
+			response["content"] = map[string]any{doc.contentType: map[string]any{"schema": map[string]any{"type": "string"}}}
+		case doc.response != nil:
+			response["content"] = jsonContent(schemas.of(reflect.TypeOf(doc.response)))
+		}
+		responses[strconv.Itoa(status)] = response
+	}
+	failures := doc.errors
+	if r.access != accessPublic {
+		failures = append([]int{http.StatusUnauthorized, http.StatusForbidden}, failures...)
+	}
+	for _, status := range failures {
+		responses[strconv.Itoa(status)] = map[string]any{
+			"description": http.StatusText(status),
+			"content":     jsonContent(schemas.of(reflect.TypeOf(dto.Error{}))),
+		}
+	}
+	op["responses"] = responses
+
+	if r.access == accessPublic {
+		op["security"] = []any{}
+	} else {
+		op["security"] = []any{map[string]any{"apiKey": []string{}}}
+		op["x-required-role"] = r.access.String()
+	}
+	return op
+}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+// operationID names an operation after its method and path, such as
+// get_api_rewrites_id_history
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, s := range strings.Split(path, "/") {
+		s = strings.TrimPrefix(s, ":")
+		if s != "" {
+			id += "_" + strings.NewReplacer("-", "_", ".", "_").Replace(s)
+		}
+	}
+	return id
+}
+
+// operationTag groups operations by the resource after /api
+func operationTag(path string) string {
+	resource, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(path, "/api"), "/"), "/")
+	return resource
+}
+
+// schemaSet derives JSON schemas from Go types as encoding/json marshals
+// them. Structs become components named after their package and type, such
+// as analyze.OptimizationResult, and are referenced wherever they appear.
+type schemaSet struct {
+	components map[string]any
+	names      map[reflect.Type]string
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (s *schemaSet) of(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(json.RawMessage{}):
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := s.of(t.Elem())
+		if _, ref := schema["$ref"]; ref {
+			return map[string]any{"allOf": []any{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		name, ok := s.names[t]
+		if !ok {
+			name = t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:] + "." + t.Name()
+			s.names[t] = name
+			// Registered before its fields so that recursive types end
+			s.components[name] = map[string]any{}
+			s.components[name] = s.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	default:
+		// Interfaces hold any JSON value
+		return map[string]any{}
+	}
+}
+
+// object is the schema of struct t, embedded structs flattened
+func (s *schemaSet) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	s.fields(t, properties)
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+func (s *schemaSet) fields(t reflect.Type, properties map[string]any) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				s.fields(embedded, properties)
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema := s.of(field.Type)
+		if strings.Contains(options, "string") {
+			schema = map[string]any{"type": "string"}
+		}
+		properties[name] = schema
+	}
+}
+
+// serveOpenAPI serves the OpenAPI document built by setupRoutes
+func (s *Server) serveOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", s.openAPI)
+}
+
+// apiDocsPage is Swagger UI, loaded from a CDN, reading the OpenAPI document
+var apiDocsPage = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Latentia API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: {{.}}, dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`))
+
+// serveAPIDocs serves Swagger UI for /api/openapi.json
+func (s *Server) serveAPIDocs(c *gin.Context) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := apiDocsPage.Execute(c.Writer, s.cfg.Prefix()+"/api/openapi.json"); err != nil {
+		c.Error(err)
+	}
+}
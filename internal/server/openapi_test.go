@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/matthieukhl/latentia/internal/config/configtest"
+)
+
+// spec is the part of the OpenAPI document the tests read
+type spec struct {
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	Paths map[string]map[string]struct {
+		OperationID string `json:"operationId"`
+		Parameters  []struct {
+			Name string `json:"name"`
+			In   string `json:"in"`
+		} `json:"parameters"`
+	} `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]any `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+// loadSpec serves the OpenAPI document of s under prefix and decodes it
+func loadSpec(t *testing.T, s *Server, prefix string) (spec, []byte) {
+	t.Helper()
+	w := serve(s, http.MethodGet, prefix+"/api/openapi.json", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s/api/openapi.json = %d", prefix, w.Code)
+	}
+	var doc spec
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	return doc, w.Body.Bytes()
+}
+
+func TestOpenAPIMatchesRouter(t *testing.T) {
+	configtest.Load(t, "server:\n  base_path: /agent/\n  ui: false\n")
+	s := newTestServer(t)
+	doc, _ := loadSpec(t, s, "/agent")
+
+	if len(doc.Servers) != 1 || doc.Servers[0].URL != "/agent" {
+		t.Errorf("servers = %+v, want the base path", doc.Servers)
+	}
+
+	// Every operation of the document is a route of the router, and every
+	// route of the router is documented
+	var documented, routed []string
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+			for _, p := range op.Parameters {
+				if p.In == "path" && !strings.Contains(path, "{"+p.Name+"}") {
+					t.Errorf("%s %s documents path parameter %q it does not have", method, path, p.Name)
+				}
+			}
+		}
+	}
+	for _, r := range s.router.Routes() {
+		path, ok := strings.CutPrefix(r.Path, "/agent")
+		if !ok {
+			t.Errorf("route %s %s is outside the base path", r.Method, r.Path)
+		}
+		routed = append(routed, r.Method+" "+openAPIPath(path))
+	}
+	slices.Sort(documented)
+	slices.Sort(routed)
+	if !reflect.DeepEqual(documented, routed) {
+		t.Errorf("documented operations\n%q\ndiffer from the routes\n%q", documented, routed)
+	}
+}
+
+func TestOpenAPIRoundTrips(t *testing.T) {
+	configtest.Load(t, "")
+	s := newTestServer(t)
+	doc, raw := loadSpec(t, s, "")
+
+	// The document decodes and encodes back to itself
+	var decoded map[string]any
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != string(raw) {
+		t.Error("openapi.json does not round-trip through encoding/json")
+	}
+	if decoded["openapi"] != "3.0.3" {
+		t.Errorf("openapi = %v", decoded["openapi"])
+	}
+
+	// Operation IDs are unique
+	ids := map[string]string{}
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			if other, ok := ids[op.OperationID]; ok {
+				t.Errorf("operationId %q of %s %s is also that of %s", op.OperationID, method, path, other)
+			}
+			ids[op.OperationID] = method + " " + path
+		}
+	}
+
+	// Every reference names a component
+	var refs []string
+	collectRefs(decoded, &refs)
+	if len(refs) == 0 {
+		t.Fatal("openapi.json references no schema")
+	}
+	for _, ref := range refs {
+		name, ok := strings.CutPrefix(ref, "#/components/schemas/")
+		if _, found := doc.Components.Schemas[name]; !ok || !found {
+			t.Errorf("reference %q names no component", ref)
+		}
+	}
+}
+
+// collectRefs appends every $ref below v to refs
+func collectRefs(v any, refs *[]string) {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if ref, ok := value.(string); ok && key == "$ref" {
+				*refs = append(*refs, ref)
+				continue
+			}
+			collectRefs(value, refs)
+		}
+	case []any:
+		for _, value := range v {
+			collectRefs(value, refs)
+		}
+	}
+}
+
+func TestOpenAPISchemasDescribeJSONBodies(t *testing.T) {
+	configtest.Load(t, "")
+	s := newTestServer(t)
+	doc, _ := loadSpec(t, s, "")
+
+	// The fields a documented body marshals to are the properties of its
+	// schema
+	for _, r := range s.routes() {
+		for _, body := range []any{r.doc.request, r.doc.response} {
+			if body == nil {
+				continue
+			}
+			typ := reflect.TypeOf(body)
+			name := typ.PkgPath()[strings.LastIndex(typ.PkgPath(), "/")+1:] + "." + typ.Name()
+			schema, ok := doc.Components.Schemas[name]
+			if !ok {
+				t.Errorf("%s %s: no component %s", r.method, r.path, name)
+				continue
+			}
+			encoded, err := json.Marshal(reflect.New(typ).Interface())
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			var fields map[string]any
+			if err := json.Unmarshal(encoded, &fields); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			for field := range fields {
+				if _, ok := schema.Properties[field]; !ok {
+					t.Errorf("%s marshals %q, which its schema lacks", name, field)
+				}
+			}
+		}
+	}
+}
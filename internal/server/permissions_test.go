@@ -0,0 +1,218 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/matthieukhl/latentia/internal/config/configtest"
+)
+
+// permissions is the access level each route must require. It is spelled
+// out rather than read from routes() so that loosening a route shows up
+// here as a failing test.
+var permissions = map[string]access{
+	"GET /metrics":                                accessPublic,
+	"GET /api/health":                             accessPublic,
+	"GET /api/openapi.json":                       accessPublic,
+	"GET /api/docs":                               accessPublic,
+	"GET /api/jobs":                               accessViewer,
+	"GET /api/ingest/status":                      accessViewer,
+	"GET /api/targets":                            accessViewer,
+	"GET /api/config":                             accessAdmin,
+	"GET /api/audit":                              accessViewer,
+	"GET /api/stats":                              accessViewer,
+	"GET /api/rewrites":                           accessViewer,
+	"GET /api/rewrites/:id":                       accessViewer,
+	"POST /api/rewrites/:id/accept":               accessReviewer,
+	"POST /api/rewrites/:id/reject":               accessReviewer,
+	"POST /api/rewrites/:id/benchmark":            accessReviewer,
+	"POST /api/rewrites/:id/retry":                accessReviewer,
+	"GET /api/rewrites/:id/history":               accessViewer,
+	"GET /api/optimizations":                      accessViewer,
+	"GET /api/optimizations/:id":                  accessViewer,
+	"POST /api/optimizations/:id/accept":          accessReviewer,
+	"POST /api/optimizations/:id/reject":          accessReviewer,
+	"POST /api/optimizations/:id/benchmark":       accessReviewer,
+	"POST /api/optimizations/:id/retry":           accessReviewer,
+	"GET /api/optimizations/:id/history":          accessViewer,
+	"POST /api/analyze":                           accessReviewer,
+	"GET /api/slow-queries/stats":                 accessViewer,
+	"GET /api/slow-queries":                       accessViewer,
+	"GET /api/slow-queries/:id":                   accessViewer,
+	"GET /api/digests/:digest/history":            accessViewer,
+	"GET /api/usage":                              accessViewer,
+	"GET /api/export":                             accessViewer,
+	"GET /api/suppressions":                       accessViewer,
+	"POST /api/suppressions":                      accessReviewer,
+	"DELETE /api/suppressions/:id":                accessAdmin,
+	"GET /api/index-recommendations":              accessViewer,
+	"POST /api/index-recommendations/:id/approve": accessReviewer,
+	"POST /api/index-recommendations/:id/reject":  accessReviewer,
+	"GET /api/search":                             accessViewer,
+	"GET /api/documents":                          accessViewer,
+	"DELETE /api/documents/:id":                   accessAdmin,
+}
+
+const rolesConfig = `
+server:
+  auth:
+    keys:
+      - name: dashboard
+        role: viewer
+        api_key: "viewer-key"
+      - name: oncall
+        role: reviewer
+        api_key: "reviewer-key"
+      - name: ops
+        role: admin
+        api_key: "admin-key"
+`
+
+// roleKeys are the keys of rolesConfig, from the least to the most allowed
+var roleKeys = []struct {
+	key   string
+	level access
+}{
+	{"viewer-key", accessViewer},
+	{"reviewer-key", accessReviewer},
+	{"admin-key", accessAdmin},
+}
+
+// concretePath fills the parameters of a route path
+func concretePath(path string) string {
+	path = strings.ReplaceAll(path, ":id", "1")
+	return strings.ReplaceAll(path, ":digest", "0123abcd")
+}
+
+func TestRoutesDeclareExpectedPermissions(t *testing.T) {
+	configtest.Load(t, rolesConfig)
+	s := newTestServer(t)
+
+	seen := map[string]bool{}
+	for _, r := range s.routes() {
+		name := r.method + " " + r.path
+		seen[name] = true
+		want, ok := permissions[name]
+		if !ok {
+			t.Errorf("route %s is not in the permission table", name)
+			continue
+		}
+		if r.access != want {
+			t.Errorf("route %s requires %s, want %s", name, r.access, want)
+		}
+	}
+	for name := range permissions {
+		if !seen[name] {
+			t.Errorf("route %s is in the permission table but not served", name)
+		}
+	}
+}
+
+func TestRoutePermissionsByRole(t *testing.T) {
+	configtest.Load(t, rolesConfig)
+	s := newTestServer(t)
+
+	// Every route guarded as setupRoutes does, but with a handler that
+	// never reaches the database
+	stub := gin.New()
+	for _, r := range s.routes() {
+		stub.Handle(r.method, r.path, authorize(r.access), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	}
+
+	for _, r := range s.routes() {
+		path := concretePath(r.path)
+		name := r.method + " " + r.path
+
+		req := httptest.NewRequest(r.method, path, nil)
+		w := httptest.NewRecorder()
+		stub.ServeHTTP(w, req)
+		want := http.StatusUnauthorized
+		if r.access == accessPublic {
+			want = http.StatusNoContent
+		}
+		if w.Code != want {
+			t.Errorf("%s without a key: status = %d, want %d", name, w.Code, want)
+		}
+
+		for _, role := range roleKeys {
+			req := httptest.NewRequest(r.method, path, nil)
+			req.Header.Set("Authorization", "Bearer "+role.key)
+			w := httptest.NewRecorder()
+			stub.ServeHTTP(w, req)
+
+			want := http.StatusNoContent
+			if role.level < r.access {
+				want = http.StatusForbidden
+			}
+			if w.Code != want {
+				t.Errorf("%s as %s: status = %d, want %d", name, role.level, w.Code, want)
+			}
+		}
+	}
+}
+
+func TestRouterRefusesTooLowRoles(t *testing.T) {
+	configtest.Load(t, rolesConfig)
+	s := newTestServer(t)
+
+	// Refusals happen before the handler, so the real router answers them
+	// without a database
+	for _, r := range s.routes() {
+		for _, role := range roleKeys {
+			if role.level >= r.access {
+				continue
+			}
+			w := serve(s, r.method, concretePath(r.path), role.key, nil)
+			if w.Code != http.StatusForbidden {
+				t.Errorf("%s %s as %s: status = %d, want 403", r.method, r.path, role.level, w.Code)
+				continue
+			}
+			want := "API key '"
+			if got := errorBody(t, w.Body.String()); !strings.HasPrefix(got, want) || !strings.HasSuffix(got, "requires "+r.access.String()) {
+				t.Errorf("%s %s as %s: error = %q", r.method, r.path, role.level, got)
+			}
+		}
+	}
+}
+
+func TestDocsArePublicWithoutKeys(t *testing.T) {
+	configtest.Load(t, "")
+	s := newTestServer(t)
+
+	w := serve(s, http.MethodGet, "/api/openapi.json", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/openapi.json = %d, want 200", w.Code)
+	}
+	if w := serve(s, http.MethodGet, "/api/docs", "", nil); w.Code != http.StatusOK {
+		t.Errorf("GET /api/docs = %d, want 200", w.Code)
+	}
+
+	var spec struct {
+		Paths map[string]map[string]struct {
+			Security     []any  `json:"security"`
+			RequiredRole string `json:"x-required-role"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	// The document advertises the same permission table
+	for name, want := range permissions {
+		method, path, _ := strings.Cut(name, " ")
+		op, ok := spec.Paths[openAPIPath(path)][strings.ToLower(method)]
+		if !ok {
+			t.Errorf("openapi.json does not describe %s", name)
+			continue
+		}
+		switch {
+		case want == accessPublic && (len(op.Security) != 0 || op.RequiredRole != ""):
+			t.Errorf("%s is public but documented with security %v, role %q", name, op.Security, op.RequiredRole)
+		case want != accessPublic && (len(op.Security) == 0 || op.RequiredRole != want.String()):
+			t.Errorf("%s documented with security %v, role %q, want %s", name, op.Security, op.RequiredRole, want)
+		}
+	}
+}
@@ -1,57 +1,1180 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
+	"github.com/matthieukhl/latentia/internal/analyze"
+	"github.com/matthieukhl/latentia/internal/config"
 	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/ingest"
+	"github.com/matthieukhl/latentia/internal/llm"
+	"github.com/matthieukhl/latentia/internal/metrics"
+	"github.com/matthieukhl/latentia/internal/rag"
+	"github.com/matthieukhl/latentia/internal/safety"
+	"github.com/matthieukhl/latentia/internal/server/dto"
+	"github.com/matthieukhl/latentia/internal/tracing"
+	"github.com/matthieukhl/latentia/internal/worker"
 )
 
 type Server struct {
 	router *gin.Engine
 	db     *database.DB
+	engine *analyze.OptimizationEngine
+	runner *worker.Runner
+	cfg    config.ServerConfig
+	http   *http.Server
+
+	// analyzer has LLM providers for POST /api/analyze; nil disables it
+	analyzer *analyze.OptimizationEngine
+	ingester *ingest.SlowQueryIngester
+
+	// scheduledIngest is the scheduled ingest job reported by
+	// /api/ingest/status, nil when ingestion is not scheduled
+	scheduledIngest *ingest.ScheduledIngest
+
+	// docs serves /api/search and /api/documents; nil disables them
+	docs *rag.DocumentStore
+
+	// openAPI is the OpenAPI document of the routes, as JSON
+	openAPI []byte
 }
 
-// NewServer creates a new server instance
-func NewServer(db *database.DB) *Server {
-	router := gin.Default()
+// NewServer creates a new server instance configured by the server section
+// of the current config. analyzer serves POST /api/analyze, which answers
+// 503 when it is nil.
+func NewServer(db *database.DB, analyzer *analyze.OptimizationEngine) *Server {
+	// Requests are logged through slog rather than gin's own text logger;
+	// the recovery is innermost so a panic is logged as a 500
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.Use(logRequests(), traceRequests(), recoverPanics())
 	
 	server := &Server{
 		router: router,
 		db:     db,
+		cfg:    config.Current().Server,
+		
+		// Reviews never call the LLM, so the engine needs no providers
+		engine:   analyze.NewOptimizationEngine(db, nil, nil),
+		analyzer: analyzer,
+		ingester: ingest.NewSlowQueryIngester(db),
 	}
 	
 	server.setupRoutes()
+	server.http = &http.Server{
+		Addr:              server.cfg.Addr,
+		Handler:           router,
+		ReadTimeout:       server.cfg.ReadTimeout,
+		ReadHeaderTimeout: server.cfg.ReadTimeout,
+		WriteTimeout:      server.cfg.WriteTimeout,
+		IdleTimeout:       server.cfg.IdleTimeout,
+	}
 	return server
 }
 
-// setupRoutes configures all API routes
+// route is an endpoint under server.base_path, the least role allowed to
+// call it and its description in the OpenAPI document
+type route struct {
+	method  string
+	path    string
+	access  access
+	handler gin.HandlerFunc
+	doc     *routeDoc
+}
+
+// routes declares every endpoint with its permission. Viewers get read-only
+// endpoints, reviewers can also accept and reject rewrites, and admins can
+// do everything, including reading the configuration.
+func (s *Server) routes() []route {
+	return []route{
+		{http.MethodGet, "/metrics", accessPublic, s.serveMetrics, metricsDoc},
+		{http.MethodGet, "/api/health", accessPublic, s.healthCheck, healthDoc},
+		{http.MethodGet, "/api/openapi.json", accessPublic, s.serveOpenAPI, openAPIDoc},
+		{http.MethodGet, "/api/docs", accessPublic, s.serveAPIDocs, apiDocsDoc},
+		{http.MethodGet, "/api/jobs", accessViewer, s.listJobs, jobsDoc},
+		{http.MethodGet, "/api/ingest/status", accessViewer, s.ingestStatus, ingestDoc},
+		{http.MethodGet, "/api/targets", accessViewer, s.listTargets, targetsDoc},
+		{http.MethodGet, "/api/config", accessAdmin, s.showConfig, configDoc},
+		{http.MethodGet, "/api/audit", accessViewer, s.listAudit, auditDoc},
+		{http.MethodGet, "/api/stats", accessViewer, s.getStats, statsDoc},
+		{http.MethodGet, "/api/rewrites", accessViewer, s.listRewrites, rewritesDoc},
+		{http.MethodGet, "/api/rewrites/:id", accessViewer, s.getRewrite, rewriteDoc},
+		{http.MethodPost, "/api/rewrites/:id/accept", accessReviewer, s.reviewRewrite(database.ActionAccept), acceptDoc},
+		{http.MethodPost, "/api/rewrites/:id/reject", accessReviewer, s.reviewRewrite(database.ActionReject), rejectDoc},
+		{http.MethodPost, "/api/rewrites/:id/benchmark", accessReviewer, s.benchmarkRewrite, benchmarkDoc},
+		{http.MethodPost, "/api/rewrites/:id/retry", accessReviewer, s.retryRewrite, retryDoc},
+		{http.MethodGet, "/api/rewrites/:id/history", accessViewer, s.getRewriteHistory, historyDoc},
+		{http.MethodGet, "/api/optimizations", accessViewer, s.listRewrites, rewritesDoc},
+		{http.MethodGet, "/api/optimizations/:id", accessViewer, s.getRewrite, rewriteDoc},
+		{http.MethodPost, "/api/optimizations/:id/accept", accessReviewer, s.reviewRewrite(database.ActionAccept), acceptDoc},
+		{http.MethodPost, "/api/optimizations/:id/reject", accessReviewer, s.reviewRewrite(database.ActionReject), rejectDoc},
+		{http.MethodPost, "/api/optimizations/:id/benchmark", accessReviewer, s.benchmarkRewrite, benchmarkDoc},
+		{http.MethodPost, "/api/optimizations/:id/retry", accessReviewer, s.retryRewrite, retryDoc},
+		{http.MethodGet, "/api/optimizations/:id/history", accessViewer, s.getRewriteHistory, historyDoc},
+		{http.MethodPost, "/api/analyze", accessReviewer, s.analyzeQuery, analyzeDoc},
+		{http.MethodGet, "/api/slow-queries/stats", accessViewer, s.listSlowQueryStats, slowQueryStatsDoc},
+		{http.MethodGet, "/api/slow-queries", accessViewer, s.listSlowQueries, slowQueriesDoc},
+		{http.MethodGet, "/api/slow-queries/:id", accessViewer, s.getSlowQuery, slowQueryDoc},
+		{http.MethodGet, "/api/digests/:digest/history", accessViewer, s.getDigestHistory, digestHistoryDoc},
+		{http.MethodGet, "/api/usage", accessViewer, s.getUsage, usageDoc},
+		{http.MethodGet, "/api/export", accessViewer, s.exportRewrites, exportDoc},
+		{http.MethodGet, "/api/suppressions", accessViewer, s.listSuppressions, suppressionsDoc},
+		{http.MethodPost, "/api/suppressions", accessReviewer, s.createSuppression, createSuppressionDoc},
+		{http.MethodDelete, "/api/suppressions/:id", accessAdmin, s.deleteSuppression, deleteSuppressionDoc},
+		{http.MethodGet, "/api/index-recommendations", accessViewer, s.listIndexRecommendations, indexRecommendationsDoc},
+		{http.MethodPost, "/api/index-recommendations/:id/approve", accessReviewer, s.reviewIndexRecommendation(database.ActionApproveIndex), approveIndexDoc},
+		{http.MethodPost, "/api/index-recommendations/:id/reject", accessReviewer, s.reviewIndexRecommendation(database.ActionRejectIndex), rejectIndexDoc},
+		{http.MethodGet, "/api/search", accessViewer, s.searchDocuments, searchDoc},
+		{http.MethodGet, "/api/documents", accessViewer, s.listDocuments, documentsDoc},
+		{http.MethodDelete, "/api/documents/:id", accessAdmin, s.deleteDocument, deleteDocumentDoc},
+	}
+}
+
+// setupRoutes configures all routes under server.base_path
 func (s *Server) setupRoutes() {
-	api := s.router.Group("/api")
-	{
-		api.GET("/health", s.healthCheck)
+	root := s.router.Group(s.cfg.Prefix() + "/")
+	routes := s.routes()
+	for _, r := range routes {
+		if r.access == accessUndeclared {
+			panic(fmt.Sprintf("route %s %s declares no permission", r.method, r.path))
+		}
+		if r.doc == nil {
+			panic(fmt.Sprintf("route %s %s has no OpenAPI description", r.method, r.path))
+		}
+		root.Handle(r.method, r.path, authorize(r.access), r.handler)
+	}
+	
+	spec, err := json.Marshal(openAPI(s.cfg.Prefix(), routes))
+	if err != nil {
+		panic(fmt.Sprintf("failed to build the OpenAPI document: %v", err))
+	}
+	s.openAPI = spec
+	
+	if s.cfg.UI {
+		s.setupUI(root)
+	}
+}
+
+// quietRoutes are polled by monitoring, so their successful requests are
+// only logged at debug level
+var quietRoutes = []string{"/metrics", "/api/health"}
+
+// logRequests logs every request once it is served, with its status,
+// latency and, on authenticated routes, the API key that made it
+func logRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		started := time.Now()
+		c.Next()
+
+		ctx := c.Request.Context()
+		status := c.Writer.Status()
+		route := c.FullPath()
+		attrs := []any{"method", c.Request.Method, "route", route, "status", status,
+			"duration_ms", time.Since(started).Milliseconds(), "client_ip", c.ClientIP()}
+		if route == "" {
+			attrs = append(attrs, "path", c.Request.URL.Path)
+		}
+		if role := database.RoleFromContext(ctx); role != "" {
+			attrs = append(attrs, "actor", database.ActorFromContext(ctx), "role", role)
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "error", c.Errors.String())
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status < 400 && slices.ContainsFunc(quietRoutes, func(r string) bool { return strings.HasSuffix(route, r) }):
+			level = slog.LevelDebug
+		}
+		slog.Log(ctx, level, "http request", attrs...)
+	}
+}
+
+// recoverPanics answers 500 to a request whose handler panicked, logging
+// the panic instead of gin's stack dump on stderr
+func recoverPanics() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		slog.ErrorContext(c.Request.Context(), "panic serving request", "method", c.Request.Method,
+			"route", c.FullPath(), "panic", fmt.Sprint(recovered))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	})
+}
+
+// traceRequests wraps every request in a server span, continuing the
+// caller's trace when a traceparent header is present
+func traceRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !tracing.Enabled() {
+			c.Next()
+			return
+		}
+		
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx := tracing.Extract(c.Request.Context(), c.GetHeader("traceparent"))
+		ctx, span := tracing.StartKind(ctx, c.Request.Method+" "+route, tracing.KindServer,
+			tracing.String("http.request.method", c.Request.Method),
+			tracing.String("http.route", route),
+			tracing.String("url.path", c.Request.URL.Path))
+		defer span.End()
+		
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		
+		status := c.Writer.Status()
+		span.SetAttributes(tracing.Int("http.response.status_code", int64(status)))
+		if status >= 500 {
+			span.RecordError(fmt.Errorf("HTTP %d", status))
+		}
+	}
+}
+
+// showConfig returns the resolved configuration with secrets masked;
+// ?provenance=true annotates every value with its source
+func (s *Server) showConfig(c *gin.Context) {
+	cfg := config.Current()
+	
+	c.JSON(http.StatusOK, dto.Config{
+		File:    cfg.File(),
+		Profile: cfg.Profile(),
+		Config:  cfg.Redacted(c.Query("provenance") == "true"),
+	})
+}
+
+// listAudit returns audit log entries, most recent first, filtered by the
+// actor, action, rewrite_id, since and until (RFC 3339) and limit parameters
+func (s *Server) listAudit(c *gin.Context) {
+	filter := database.AuditFilter{
+		Actor:  c.Query("actor"),
+		Action: c.Query("action"),
+	}
+	
+	var err error
+	if v := c.Query("rewrite_id"); v != "" {
+		if filter.RewriteID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rewrite_id"})
+			return
+		}
+	}
+	for param, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := c.Query(param); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + param + ": expected RFC 3339 time"})
+				return
+			}
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 1 || filter.Limit > maxAuditLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid limit: must be between 1 and %d", maxAuditLimit)})
+			return
+		}
+	}
+	
+	entries, err := s.db.ListAudit(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, dto.Audit{Entries: entries})
+}
+
+const maxAuditLimit = 1000
+
+// listRewrites returns the rewrites with the status parameter, pending by
+// default, of the slow queries of the target parameter, up to the limit
+// parameter
+func (s *Server) listRewrites(c *gin.Context) {
+	status := c.DefaultQuery("status", "pending")
+	if !slices.Contains(analyze.RewriteStatuses, status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid status: must be one of %s", strings.Join(analyze.RewriteStatuses, ", "))})
+		return
+	}
+	
+	limit := defaultRewriteLimit
+	if v := c.Query("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxRewriteLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid limit: must be between 1 and %d", maxRewriteLimit)})
+			return
+		}
+	}
+	
+	sort := c.Query("sort")
+	if sort != "" && !slices.Contains(analyze.RewriteSorts, sort) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid sort: must be one of %s", strings.Join(analyze.RewriteSorts, ", "))})
+		return
+	}
+	
+	rewrites, err := s.engine.ListOptimizations(c.Request.Context(), status, sort, c.Query("target"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if rewrites == nil {
+		rewrites = []analyze.OptimizationResult{}
+	}
+	
+	c.JSON(http.StatusOK, dto.Rewrites{Rewrites: rewrites})
+}
+
+const (
+	defaultRewriteLimit = 100
+	maxRewriteLimit     = 500
+)
+
+// getStats reports rewrite counts per status, the time accepted rewrites
+// saved once applied, how confidence compares to review decisions and,
+// separately, what suppressions are hiding
+func (s *Server) getStats(c *gin.Context) {
+	ctx := c.Request.Context()
+	counts, err := s.engine.CountOptimizationsByStatus(ctx, time.Time{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	realized, err := s.engine.GetRealizedStats(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	suppressions, err := s.db.ActiveSuppressions(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	suppressed, err := s.db.CountSuppressedSlowQueries(ctx, time.Time{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	calibration, err := s.engine.ConfidenceCalibration(ctx, time.Time{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, dto.Stats{
+		Rewrites: counts,
+		Realized: realized,
+		Suppressed: dto.SuppressedStats{
+			ActiveSuppressions: len(suppressions),
+			SlowQueries:        suppressed,
+			Rewrites:           counts["suppressed"],
+		},
+		Calibration: calibration,
+	})
+}
+
+// getRewrite returns one rewrite in any status, with its SQL diff, and with
+// ?include=raw the prompt and completion stored with it
+func (s *Server) getRewrite(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rewrite id"})
+		return
+	}
+	
+	rewrite, err := s.engine.GetOptimizationByID(c.Request.Context(), id)
+	if errors.Is(err, analyze.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if includeRaw(c) {
+		if err := s.engine.LoadRawExchange(c.Request.Context(), rewrite); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	
+	c.JSON(http.StatusOK, rewrite)
+}
+
+// includeRaw reports whether ?include=raw asks for the prompt and
+// completion of rewrites, which are otherwise left out of responses
+func includeRaw(c *gin.Context) bool {
+	for _, v := range strings.Split(c.Query("include"), ",") {
+		if strings.TrimSpace(v) == "raw" {
+			return true
+		}
+	}
+	return false
+}
+
+// omitRaw clears the prompt and completion of a rewrite just generated
+// unless ?include=raw asked for them
+func omitRaw(c *gin.Context, result *analyze.OptimizationResult) {
+	if !includeRaw(c) {
+		result.PromptText, result.RawResponse = "", ""
+	}
+}
+
+// listSlowQueryStats lists digests by total query time, with their
+// occurrence counts and average and maximum query times
+func (s *Server) listSlowQueryStats(c *gin.Context) {
+	limit := defaultRewriteLimit
+	if v := c.Query("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxRewriteLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid limit: must be between 1 and %d", maxRewriteLimit)})
+			return
+		}
+	}
+	
+	stats, err := s.db.ListSlowQueryStats(c.Request.Context(), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, dto.SlowQueryStats{Digests: stats})
+}
+
+// listSlowQueries returns a page of captured slow queries, most recent
+// first, filtered by the target, status, source, db, min_query_time
+// (seconds) and since (RFC 3339) parameters, with the number of matches
+func (s *Server) listSlowQueries(c *gin.Context) {
+	filter := ingest.SlowQueryFilter{
+		Target: c.Query("target"),
+		Digest: c.Query("digest"),
+		Status: c.Query("status"),
+		Source: c.Query("source"),
+		DB:     c.Query("db"),
+	}
+	if filter.Status != "" && !slices.Contains(ingest.SlowQueryStatuses, filter.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid status: must be one of %s", strings.Join(ingest.SlowQueryStatuses, ", "))})
+		return
+	}
+	if filter.Source != "" && !slices.Contains(ingest.SlowQuerySources, filter.Source) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid source: must be one of %s", strings.Join(ingest.SlowQuerySources, ", "))})
+		return
+	}
+	
+	var err error
+	if v := c.Query("min_query_time"); v != "" {
+		if filter.MinQueryTime, err = strconv.ParseFloat(v, 64); err != nil || filter.MinQueryTime < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid min_query_time: expected seconds >= 0"})
+			return
+		}
+	}
+	if v := c.Query("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since: expected RFC 3339 time"})
+			return
+		}
+	}
+	var ok bool
+	if filter.Page, filter.PageSize, ok = slowQueryPage(c); !ok {
+		return
+	}
+	
+	queries, total, err := ingest.NewSlowQueryIngester(s.db).ListSlowQueries(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, dto.SlowQueries{
+		SlowQueries: queries,
+		Total:       total,
+		Page:        filter.Page,
+		PageSize:    filter.PageSize,
+	})
+}
+
+// slowQueryPage reads ?page= and ?page_size= for a list of slow queries,
+// answering 400 and returning false when either is invalid
+func slowQueryPage(c *gin.Context) (page, pageSize int, ok bool) {
+	var err error
+	page = 1
+	if v := c.Query("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page: must be >= 1"})
+			return 0, 0, false
+		}
+	}
+	pageSize = ingest.DefaultSlowQueryPageSize
+	if v := c.Query("page_size"); v != "" {
+		if pageSize, err = strconv.Atoi(v); err != nil || pageSize < 1 || pageSize > ingest.MaxSlowQueryPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid page_size: must be between 1 and %d", ingest.MaxSlowQueryPageSize)})
+			return 0, 0, false
+		}
+	}
+	return page, pageSize, true
+}
+
+// getDigestHistory returns the rewrites proposed for a digest with their
+// reviews, its accepted rewrite first, and a page of its occurrences, most
+// recent first
+func (s *Server) getDigestHistory(c *gin.Context) {
+	digest := strings.ToLower(c.Param("digest"))
+	if digest == "" || len(digest) > 64 || strings.Trim(digest, "0123456789abcdef") != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid digest: expected up to 64 hexadecimal characters"})
+		return
+	}
+	filter := ingest.SlowQueryFilter{Digest: digest}
+	var ok bool
+	if filter.Page, filter.PageSize, ok = slowQueryPage(c); !ok {
+		return
+	}
+	
+	occurrences, total, err := s.ingester.ListSlowQueries(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	history, err := s.engine.DigestHistory(c.Request.Context(), digest)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if total == 0 && len(history.Rewrites) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no slow queries or rewrites for digest %s", digest)})
+		return
+	}
+	
+	c.JSON(http.StatusOK, dto.DigestHistory{
+		Digest:          digest,
+		AcceptedRewrite: history.Accepted,
+		Rewrites:        history.Rewrites,
+		Occurrences:     occurrences,
+		Total:           total,
+		Page:            filter.Page,
+		PageSize:        filter.PageSize,
+	})
+}
+
+// getSlowQuery returns one slow query with the rewrites proposed for it
+func (s *Server) getSlowQuery(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid slow query id"})
+		return
+	}
+	
+	query, err := ingest.NewSlowQueryIngester(s.db).GetSlowQueryByID(c.Request.Context(), id)
+	if errors.Is(err, ingest.ErrSlowQueryNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	rewrites, err := s.engine.ListSlowQueryRewrites(c.Request.Context(), id, query.BestRewriteID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, dto.SlowQuery{SlowQuery: query, Rewrites: rewrites})
+}
+
+// getUsage returns the generator tokens and estimated cost per day and
+// model over the last ?days days, today included, and their totals
+func (s *Server) getUsage(c *gin.Context) {
+	days := database.DefaultLLMUsageDays
+	if v := c.Query("days"); v != "" {
+		var err error
+		if days, err = strconv.Atoi(v); err != nil || days < 1 || days > database.MaxLLMUsageDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid days: must be between 1 and %d", database.MaxLLMUsageDays)})
+			return
+		}
+	}
+	
+	since := time.Now().UTC().AddDate(0, 0, 1-days)
+	usage, err := s.db.ListLLMUsage(c.Request.Context(), since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	
+	total := database.TotalLLMUsage(usage)
+	c.JSON(http.StatusOK, dto.Usage{
+		Since: since.Format(time.DateOnly),
+		Usage: usage,
+		Total: dto.UsageTotal{
+			Requests:         total.Requests,
+			PromptTokens:     total.PromptTokens,
+			CompletionTokens: total.CompletionTokens,
+			EstimatedCost:    total.EstimatedCost,
+		},
+	})
+}
+
+// exportRewrites renders the latest rewrite of every digest in a status,
+// accepted by default, as Markdown, SQL or JSON, the report written by
+// "agent export"
+func (s *Server) exportRewrites(c *gin.Context) {
+	format := c.DefaultQuery("format", analyze.ExportJSON)
+	if !slices.Contains(analyze.ExportFormats, format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid format '%s': must be one of %s", format, strings.Join(analyze.ExportFormats, ", "))})
+		return
+	}
+	status := c.DefaultQuery("status", "accepted")
+	if !slices.Contains(analyze.RewriteStatuses, status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid status: must be one of %s", strings.Join(analyze.RewriteStatuses, ", "))})
+		return
+	}
+	var since time.Time
+	if v := c.Query("since"); v != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, v); err != nil {
+			if since, err = time.Parse(time.DateOnly, v); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since: expected RFC 3339 time or YYYY-MM-DD"})
+				return
+			}
+		}
+	}
+	
+	export, err := s.engine.ExportRewrites(c.Request.Context(), status, since)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.Header("Content-Type", analyze.ExportContentType(format))
+	c.Status(http.StatusOK)
+	if err := export.Write(c.Writer, format); err != nil {
+		c.Error(err)
+	}
+}
+
+// listSuppressions returns active suppressions, or all of them with
+// ?all=true
+func (s *Server) listSuppressions(c *gin.Context) {
+	suppressions, err := s.db.ListSuppressions(c.Request.Context(), c.Query("all") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, dto.Suppressions{Suppressions: suppressions})
+}
+
+// createSuppression suppresses a digest or digest pattern, closing its
+// pending rewrites
+func (s *Server) createSuppression(c *gin.Context) {
+	var req dto.SuppressionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+	
+	suppression := database.Suppression{
+		Digest:        req.Digest,
+		DigestPattern: req.DigestPattern,
+		Reason:        req.Reason,
+		ExpiresAt:     req.ExpiresAt,
+	}
+	if err := suppression.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+	
+	created, err := s.db.CreateSuppression(c.Request.Context(), suppression)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusCreated, created)
+}
+
+func (s *Server) deleteSuppression(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid suppression id"})
+		return
+	}
+	
+	err = s.db.DeleteSuppression(c.Request.Context(), id)
+	if errors.Is(err, database.ErrSuppressionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, dto.Deleted{ID: id, Deleted: true})
+}
+
+// reviewRewrite accepts or rejects the pending rewrite :id, recording the
+// reviewer, the API key unless the body names one, and the reason, which
+// rejections require, and returns the reviewed rewrite
+func (s *Server) reviewRewrite(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rewrite id"})
+			return
+		}
+		
+		var req dto.RewriteReviewRequest
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		if utf8.RuneCountInString(req.Reason) > maxReasonLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("reason must be at most %d characters", maxReasonLength)})
+			return
+		}
+		if err := analyze.ValidateReviewer(req.Reviewer); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		
+		ctx := c.Request.Context()
+		if action == database.ActionAccept {
+			err = s.engine.AcceptOptimization(ctx, id, req.Reviewer, req.Reason)
+		} else {
+			err = s.engine.RejectOptimization(ctx, id, req.Reviewer, req.Reason)
+		}
+		if errors.Is(err, analyze.ErrReasonRequired) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, analyze.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, analyze.ErrNotPending) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		
+		rewrite, err := s.engine.GetOptimizationByID(ctx, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, rewrite)
+	}
+}
+
+// benchmarkRewrite executes the original and optimized SQL of rewrite :id
+// in the sandbox and returns the stored timings. Statements that are not
+// read-only, match safety.forbid_patterns or run longer than
+// safety.max_stmt_seconds are refused with 422.
+func (s *Server) benchmarkRewrite(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rewrite id"})
+		return
+	}
+	
+	req := dto.BenchmarkRequest{Runs: analyze.DefaultBenchmarkRuns}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+	if req.Runs < 1 || req.Runs > analyze.MaxBenchmarkRuns {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid runs: must be between 1 and %d", analyze.MaxBenchmarkRuns)})
+		return
+	}
+	
+	bench, err := s.engine.BenchmarkRewrite(c.Request.Context(), id, req.Runs)
+	if errors.Is(err, analyze.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if _, refused := safety.AsViolation(err); refused || errors.Is(err, analyze.ErrBenchmarkRefused) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, bench)
+}
+
+// Limits of POST /api/analyze, matching app_slow_queries.sample_sql and db
+const (
+	maxAnalyzeSQLBytes = 65535
+	maxAnalyzeDBLength = 64
+)
+
+// analyzeQuery optimizes SQL submitted in the body rather than captured
+// from the cluster and returns the stored rewrite. The SQL is recorded as a
+// slow query of source adhoc, completed or skipped afterwards. More than
+// one statement, an administrative or DDL statement, or SQL matching
+// safety.forbid_patterns is refused with 422,
+// and an analysis running past server.analyze_timeout fails with 504.
+func (s *Server) analyzeQuery(c *gin.Context) {
+	if s.analyzer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "ad-hoc analysis is unavailable: the LLM providers failed to start"})
+		return
+	}
+	
+	var req dto.AnalyzeRequest
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 2*maxAnalyzeSQLBytes)
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+	if strings.TrimSpace(req.SQL) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sql is required"})
+		return
+	}
+	if len(req.SQL) > maxAnalyzeSQLBytes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("sql must be at most %d bytes", maxAnalyzeSQLBytes)})
+		return
+	}
+	if utf8.RuneCountInString(req.DB) > maxAnalyzeDBLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("db must be at most %d characters", maxAnalyzeDBLength)})
+		return
+	}
+	sql, err := safety.Optimizable(req.SQL)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	
+	ctx := c.Request.Context()
+	// The query is explained on the target it is recorded under
+	tdb, err := s.db.ForTarget(req.Target)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	q, err := ingest.NewSlowQueryIngester(tdb).RecordAdhocQuery(ctx, sql, req.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record query: " + err.Error()})
+		return
+	}
+	
+	timeout := config.Current().Server.AnalyzeTimeout
+	analyzeCtx, cancel := context.WithTimeout(ctx, timeout)
+	if req.Force {
+		analyzeCtx = analyze.WithoutRewriteCache(analyzeCtx)
+	}
+	result, err := s.analyzer.OptimizeQuery(analyzeCtx, q.ID, sql)
+	cancel()
+	// The slow query is settled even when the client went away
+	settleCtx := context.WithoutCancel(ctx)
+	if err != nil {
+		// Nothing retries an ad-hoc query, so it never goes back to pending
+		if skipErr := s.ingester.SkipSlowQuery(settleCtx, q.ID, "ad-hoc analysis failed: "+err.Error()); skipErr != nil {
+			slog.WarnContext(ctx, "failed to update ad-hoc slow query", "slow_query_id", q.ID, "error", skipErr)
+		}
+		switch _, refused := safety.AsViolation(err); {
+		case refused:
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": fmt.Sprintf("analysis did not finish within %v", timeout)})
+		case errors.Is(err, llm.ErrRateLimited):
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	
+	// An invalid rewrite completes the analysis but is nobody's best
+	var bestRewriteID int64
+	if result.Status != "invalid" {
+		bestRewriteID = result.ID
+	}
+	if err := s.ingester.CompleteSlowQuery(settleCtx, q.ID, bestRewriteID); err != nil {
+		slog.WarnContext(ctx, "failed to update ad-hoc slow query", "slow_query_id", q.ID, "error", err)
+	}
+	
+	if result.Cached {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+	omitRaw(c, result)
+	c.JSON(http.StatusCreated, result)
+}
+
+// retryRewrite asks the LLM for another rewrite of the rejected or invalid
+// rewrite :id, passing it the optional feedback, and returns the new rewrite
+// linked to it by parent_rewrite_id. Other statuses are refused with 409,
+// and a retry running past server.analyze_timeout fails with 504.
+func (s *Server) retryRewrite(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rewrite id"})
+		return
+	}
+	if s.analyzer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "retries are unavailable: the LLM providers failed to start"})
+		return
+	}
+	
+	var req dto.RetryRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+		return
+	}
+	if utf8.RuneCountInString(req.Feedback) > maxReasonLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("feedback must be at most %d characters", maxReasonLength)})
+		return
+	}
+	
+	ctx := c.Request.Context()
+	timeout := config.Current().Server.AnalyzeTimeout
+	retryCtx, cancel := context.WithTimeout(ctx, timeout)
+	result, err := s.analyzer.ReOptimize(retryCtx, id, req.Feedback)
+	cancel()
+	switch _, refused := safety.AsViolation(err); {
+	case err == nil:
+	case errors.Is(err, analyze.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, analyze.ErrNotRetryable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case refused:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": fmt.Sprintf("retry did not finish within %v", timeout)})
+		return
+	case errors.Is(err, llm.ErrRateLimited):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	
+	omitRaw(c, result)
+	c.JSON(http.StatusCreated, result)
+}
+
+// getRewriteHistory returns the retries rewrite :id belongs to, from the
+// first proposal to the latest retry
+func (s *Server) getRewriteHistory(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rewrite id"})
+		return
+	}
+	
+	history, err := s.engine.RewriteHistory(c.Request.Context(), id)
+	if errors.Is(err, analyze.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, dto.RewriteHistory{RewriteID: id, History: history})
+}
+
+// listIndexRecommendations returns index recommendations newest first,
+// optionally only those of ?rewrite_id= or in ?status=
+func (s *Server) listIndexRecommendations(c *gin.Context) {
+	var rewriteID int64
+	if v := c.Query("rewrite_id"); v != "" {
+		var err error
+		if rewriteID, err = strconv.ParseInt(v, 10, 64); err != nil || rewriteID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rewrite id"})
+			return
+		}
+	}
+	
+	status := c.Query("status")
+	switch status {
+	case "", database.IndexPending, database.IndexApproved, database.IndexRejected, database.IndexApplied:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid status '%s'", status)})
+		return
+	}
+	
+	limit := defaultRewriteLimit
+	if v := c.Query("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxRewriteLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid limit: must be between 1 and %d", maxRewriteLimit)})
+			return
+		}
+	}
+	
+	recommendations, err := s.db.ListIndexRecommendations(c.Request.Context(), rewriteID, status, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, dto.IndexRecommendations{IndexRecommendations: recommendations})
+}
+
+// reviewIndexRecommendation approves or rejects the pending index
+// recommendation :id. Approval never creates the index; an operator does
+// that with "agent apply-index".
+func (s *Server) reviewIndexRecommendation(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid index recommendation id"})
+			return
+		}
+		
+		var req dto.ReviewRequest
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body: " + err.Error()})
+			return
+		}
+		if utf8.RuneCountInString(req.Reason) > maxReasonLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("reason must be at most %d characters", maxReasonLength)})
+			return
+		}
+		
+		ctx := c.Request.Context()
+		err = s.db.ReviewIndexRecommendation(ctx, id, action, req.Reason)
+		if errors.Is(err, database.ErrIndexNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, database.ErrIndexNotPending) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		
+		recommendation, err := s.db.GetIndexRecommendation(ctx, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, recommendation)
+	}
+}
+
+// maxReasonLength matches app_audit_log.reason
+const maxReasonLength = 512
+
+// serveMetrics serves the pipeline metrics in the Prometheus text format
+func (s *Server) serveMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	metrics.RecordDBStats(s.db.Stats())
+	metrics.Default.WriteText(c.Writer)
+}
+
+// SetRunner exposes the background job runner through /api/jobs
+func (s *Server) SetRunner(runner *worker.Runner) {
+	s.runner = runner
+}
+
+// SetIngest exposes the latest run of the scheduled ingest job through
+// /api/ingest/status
+func (s *Server) SetIngest(scheduled *ingest.ScheduledIngest) {
+	s.scheduledIngest = scheduled
+}
+
+// ingestStatus reports when the scheduled ingestion last ran, what it
+// inserted and whether INFORMATION_SCHEMA.SLOW_QUERY could be read
+func (s *Server) ingestStatus(c *gin.Context) {
+	if s.scheduledIngest == nil {
+		c.JSON(http.StatusOK, dto.IngestStatus{Scheduled: false})
+		return
+	}
+	
+	status := s.scheduledIngest.Status()
+	response := dto.IngestStatus{Scheduled: true, Status: &status}
+	if s.runner != nil {
+		for _, job := range s.runner.Jobs() {
+			if job.Name == "ingest" {
+				response.Schedule = job.Schedule
+				response.NextRun = job.NextRun
+				response.Running = job.Running
+			}
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// listTargets reports the enabled targets, whose names filter the slow
+// queries and rewrites
+func (s *Server) listTargets(c *gin.Context) {
+	names := s.db.TargetNames()
+	c.JSON(http.StatusOK, dto.Targets{Targets: names, Default: names[0]})
+}
+
+// listJobs reports each background job with its schedule and next run time
+func (s *Server) listJobs(c *gin.Context) {
+	jobs := []worker.JobStatus{}
+	if s.runner != nil {
+		jobs = s.runner.Jobs()
+	}
+	
+	c.JSON(http.StatusOK, dto.Jobs{Jobs: jobs})
 }
 
 // healthCheck endpoint for monitoring
 func (s *Server) healthCheck(c *gin.Context) {
 	// Check database health
-	if err := s.db.HealthCheck(); err != nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{
-			"status": "error",
-			"error":  "database connection failed",
-		})
+	if err := s.db.HealthCheck(c.Request.Context()); err != nil {
+		slog.WarnContext(c.Request.Context(), "health check failed", "error", err)
+		message := "database connection failed"
+		if errors.Is(err, database.ErrConnectionLost) {
+			message = "database connection lost"
+		}
+		c.JSON(http.StatusServiceUnavailable, dto.Health{Status: "error", Error: message})
 		return
 	}
 	
-	c.JSON(http.StatusOK, gin.H{
-		"status":  "ok",
-		"service": "latentia",
-		"version": "0.1.0",
-	})
+	c.JSON(http.StatusOK, dto.Health{Status: "ok", Service: "latentia", Version: version})
+}
+
+// Start listens on server.addr with the configured timeouts, serving HTTPS
+// when server.tls is set
+func (s *Server) Start() error {
+	var err error
+	if s.cfg.TLS.Enabled() {
+		err = s.http.ListenAndServeTLS(s.cfg.TLS.CertFile, s.cfg.TLS.KeyFile)
+	} else {
+		err = s.http.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
 }
 
-// Start starts the HTTP server
-func (s *Server) Start(addr string) error {
-	return s.router.Run(addr)
+// Shutdown stops accepting connections and waits for in-flight requests
+// until ctx is done. Start returns nil once it has been called.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.http.Shutdown(ctx)
 }
\ No newline at end of file
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/matthieukhl/latentia/internal/config/configtest"
+	"github.com/matthieukhl/latentia/internal/database"
+	"github.com/matthieukhl/latentia/internal/llm"
+	"github.com/matthieukhl/latentia/internal/metrics"
+	"github.com/matthieukhl/latentia/internal/types"
+)
+
+// unreachableDSN points at a closed port, so handlers reaching the database
+// fail fast instead of hanging
+const unreachableDSN = "latentia:latentia@tcp(127.0.0.1:1)/latentia?timeout=200ms"
+
+// newTestServer returns a server over a database that is never reached
+// unless a handler queries it
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	pool, err := sql.Open("mysql", unreachableDSN)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	return NewServer(&database.DB{DB: pool}, nil)
+}
+
+// serve sends one request to s and returns the recorded response
+func serve(s *Server, method, path, key string, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, body)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return serveRequest(s, req)
+}
+
+// serveRequest sends req to s as it is and returns the recorded response
+func serveRequest(s *Server, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestMetricsScrape(t *testing.T) {
+	cfg := configtest.Load(t, `
+llm:
+  generator:
+    provider: mock
+    model: mock-model
+  embedder:
+    provider: mock
+    model: mock-embedder
+  mock:
+    latency: 0s
+`)
+
+	// Exercise the instrumented provider paths of the mock pipeline
+	ctx := context.Background()
+	generator, err := llm.NewGenerator(&cfg.LLM)
+	if err != nil {
+		t.Fatalf("NewGenerator: %v", err)
+	}
+	if _, err := generator.Complete(ctx, "optimize SELECT * FROM orders", types.GenerationOptions{MaxTokens: 100}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	embedder, err := llm.NewEmbedder(&cfg.LLM)
+	if err != nil {
+		t.Fatalf("NewEmbedder: %v", err)
+	}
+	if _, err := embedder.Embed(ctx, []string{"TiDB indexes", "TiFlash replicas"}); err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	timer := metrics.StartStage(metrics.StageAnalysis)
+	timer.Next(metrics.StageParse)
+	timer.Done()
+	metrics.RecordReview("accept", "api")
+
+	w := serve(newTestServer(t), http.MethodGet, "/metrics", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d, want 200", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	body := w.Body.String()
+	for _, series := range []string{
+		`latentia_llm_requests_total{provider="mock",outcome="ok"} `,
+		`latentia_llm_request_duration_seconds_count{provider="mock"} `,
+		`latentia_llm_queue_wait_seconds_count{class="interactive"} `,
+		`latentia_embedding_requests_total{provider="mock",outcome="ok"} `,
+		`latentia_embedding_texts_total{provider="mock"} `,
+		`latentia_optimization_stage_duration_seconds_count{stage="analysis"} `,
+		`latentia_optimization_stage_duration_seconds_count{stage="parse"} `,
+		`latentia_reviews_total{action="accept",actor="api"} `,
+		`latentia_db_connections{state="in_use"} `,
+		`latentia_db_max_open_connections `,
+		"# TYPE latentia_slow_queries_ingested_total counter",
+		"# TYPE latentia_optimizations_started_total counter",
+		"# TYPE latentia_vector_search_duration_seconds histogram",
+	} {
+		if !strings.Contains(body, series) {
+			t.Errorf("scrape has no %s", series)
+		}
+	}
+}
+
+func TestReviewRefusesMultilineReviewer(t *testing.T) {
+	configtest.Load(t, rolesConfig)
+	s := newTestServer(t)
+
+	for _, reviewer := range []string{"x\nDROP TABLE orders; --", "x\rDROP TABLE orders", "bob\tsmith"} {
+		body := strings.NewReader(`{"reviewer": ` + strconv.Quote(reviewer) + `}`)
+		w := serve(s, http.MethodPost, "/api/rewrites/1/accept", "reviewer-key", body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("reviewer %q: status = %d, want 400", reviewer, w.Code)
+			continue
+		}
+		if got := errorBody(t, w.Body.String()); !strings.Contains(got, "control characters") {
+			t.Errorf("reviewer %q: error = %q", reviewer, got)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/matthieukhl/latentia/internal/config/configtest"
+)
+
+// freeAddr returns a local address nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// startServer runs s.Start in the background once a slow handler is
+// registered at /test/slow, and returns Start's result channel once the
+// server accepts connections. The handler reports its start on started and
+// holds the request until release is closed.
+func startServer(t *testing.T, s *Server, started chan<- struct{}, release <-chan struct{}) chan error {
+	t.Helper()
+	s.router.GET("/test/slow", func(c *gin.Context) {
+		started <- struct{}{}
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+		c.String(http.StatusOK, "done")
+	})
+
+	result := make(chan error, 1)
+	go func() { result <- s.Start() }()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", s.http.Addr); err == nil {
+			conn.Close()
+			return result
+		}
+	}
+	t.Fatal("server did not start")
+	return nil
+}
+
+// get requests path on s in the background and returns the body or error
+func get(s *Server, path string) chan string {
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + s.http.Addr + path)
+		if err != nil {
+			body <- "error: " + err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		body <- string(b)
+	}()
+	return body
+}
+
+func TestServerTimeoutsFromConfig(t *testing.T) {
+	configtest.Load(t, `
+server:
+  addr: 127.0.0.1:9999
+  read_timeout: 11s
+  write_timeout: 3m
+  idle_timeout: 4m
+`)
+	s := newTestServer(t)
+	if s.http.Addr != "127.0.0.1:9999" {
+		t.Errorf("Addr = %q", s.http.Addr)
+	}
+	if s.http.ReadTimeout != 11*time.Second || s.http.ReadHeaderTimeout != 11*time.Second {
+		t.Errorf("read timeouts = %v, %v, want 11s", s.http.ReadTimeout, s.http.ReadHeaderTimeout)
+	}
+	if s.http.WriteTimeout != 3*time.Minute || s.http.IdleTimeout != 4*time.Minute {
+		t.Errorf("write, idle timeouts = %v, %v, want 3m, 4m", s.http.WriteTimeout, s.http.IdleTimeout)
+	}
+}
+
+func TestShutdownFinishesInFlightRequest(t *testing.T) {
+	configtest.Load(t, "server:\n  addr: "+freeAddr(t)+"\n")
+	s := newTestServer(t)
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	result := startServer(t, s, started, release)
+
+	body := get(s, "/test/slow")
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	// Shutdown waits for the request, while refusing new connections
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v with a request in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := net.DialTimeout("tcp", s.http.Addr, time.Second); err == nil {
+		t.Error("server accepts connections while shutting down")
+	}
+
+	close(release)
+	if got := <-body; got != "done" {
+		t.Errorf("in-flight request = %q, want it answered", got)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Start returned %v after Shutdown, want nil", err)
+	}
+}
+
+func TestShutdownGivesUpAtDeadline(t *testing.T) {
+	configtest.Load(t, "server:\n  addr: "+freeAddr(t)+"\n")
+	s := newTestServer(t)
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	defer close(release)
+	result := startServer(t, s, started, release)
+
+	get(s, "/test/slow")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	begun := time.Now()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v, want the deadline", err)
+	}
+	if elapsed := time.Since(begun); elapsed > time.Second {
+		t.Errorf("Shutdown took %v past its 50ms deadline", elapsed)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Start returned %v, want nil", err)
+	}
+}
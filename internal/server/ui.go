@@ -0,0 +1,49 @@
+package server
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uiFiles is the review app: plain HTML, CSS and JavaScript with no build
+// step, calling /api/rewrites with an API key the reviewer enters
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// setupUI serves the review app under /ui. Paths that are not assets get the
+// app itself, so links such as /ui/rewrites/42 from notifications open it.
+func (s *Server) setupUI(root *gin.RouterGroup) {
+	assets, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	index := template.Must(template.ParseFS(assets, "index.html"))
+	base := s.cfg.Prefix()
+
+	root.GET("/ui", func(c *gin.Context) {
+		c.Redirect(http.StatusMovedPermanently, base+"/ui/")
+	})
+	root.GET("/ui/*path", func(c *gin.Context) {
+		c.Header("Content-Security-Policy", "default-src 'self'; img-src 'self' data:")
+		c.Header("X-Content-Type-Options", "nosniff")
+
+		path := strings.TrimPrefix(c.Param("path"), "/")
+		if info, err := fs.Stat(assets, path); err == nil && !info.IsDir() && path != "index.html" {
+			c.FileFromFS(path, http.FS(assets))
+			return
+		}
+
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := index.Execute(c.Writer, gin.H{"Base": base}); err != nil {
+			c.Error(err)
+		}
+	})
+}
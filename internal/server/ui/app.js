@@ -0,0 +1,324 @@
+// Review app for pending rewrites. It talks to the agent API with the API
+// key the reviewer enters, kept in sessionStorage, and renders everything
+// with textContent so stored SQL and model output are never parsed as HTML.
+(function () {
+  "use strict";
+
+  var base = document.querySelector('meta[name="latentia-base"]').content;
+  var api = base + "/api";
+  var keyName = "latentia-api-key";
+
+  var els = {
+    status: document.getElementById("status"),
+    signin: document.getElementById("signin"),
+    apikey: document.getElementById("apikey"),
+    signout: document.getElementById("signout"),
+    refresh: document.getElementById("refresh"),
+    app: document.getElementById("app"),
+    list: document.getElementById("list"),
+    count: document.getElementById("count"),
+    target: document.getElementById("target"),
+    detail: document.getElementById("detail"),
+    template: document.getElementById("detail-template"),
+  };
+
+  var rewrites = [];
+  var selected = null;
+  var targetsLoaded = false;
+
+  // Notification links look like /ui/rewrites/42?action=accept; the action
+  // only highlights the button, reviews always need a click
+  var linked = (function () {
+    var match = location.pathname.match(/\/ui\/rewrites\/(\d+)/);
+    var action = new URLSearchParams(location.search).get("action");
+    return match ? { id: Number(match[1]), action: action } : null;
+  })();
+
+  function setStatus(text, isError) {
+    els.status.textContent = text || "";
+    els.status.className = isError ? "status error" : "status";
+  }
+
+  function request(method, path, body) {
+    var headers = { Authorization: "Bearer " + sessionStorage.getItem(keyName) };
+    if (body) headers["Content-Type"] = "application/json";
+    return fetch(api + path, {
+      method: method,
+      headers: headers,
+      body: body ? JSON.stringify(body) : undefined,
+    }).then(function (resp) {
+      return resp.json().catch(function () { return {}; }).then(function (data) {
+        if (resp.status === 401) {
+          signOut("Invalid API key");
+          throw new Error("invalid API key");
+        }
+        if (!resp.ok) throw new Error(data.error || "HTTP " + resp.status);
+        return data;
+      });
+    });
+  }
+
+  function signOut(message) {
+    sessionStorage.removeItem(keyName);
+    els.app.hidden = true;
+    els.signout.hidden = true;
+    els.refresh.hidden = true;
+    els.signin.hidden = false;
+    els.apikey.value = "";
+    els.apikey.focus();
+    setStatus(message, Boolean(message));
+  }
+
+  // The target filter is only shown when db.targets lists several
+  function loadTargets() {
+    if (targetsLoaded) return Promise.resolve();
+    return request("GET", "/targets").then(function (data) {
+      targetsLoaded = true;
+      data.targets.forEach(function (name) {
+        var option = document.createElement("option");
+        option.value = name;
+        option.textContent = name;
+        els.target.append(option);
+      });
+      els.target.hidden = data.targets.length < 2;
+    });
+  }
+
+  function load() {
+    setStatus("Loading…");
+    return loadTargets().then(function () {
+      var path = "/rewrites?limit=500";
+      if (els.target.value) path += "&target=" + encodeURIComponent(els.target.value);
+      return request("GET", path);
+    }).then(function (data) {
+      rewrites = data.rewrites.slice().sort(function (a, b) {
+        return b.confidence_score - a.confidence_score || Date.parse(b.created_at) - Date.parse(a.created_at);
+      });
+      els.signin.hidden = true;
+      els.app.hidden = false;
+      els.signout.hidden = false;
+      els.refresh.hidden = false;
+      setStatus("");
+      renderList();
+
+      var target = linked && find(linked.id);
+      if (linked && !target) setStatus("Rewrite #" + linked.id + " is not pending", true);
+      select(target || (selected && find(selected.id)) || rewrites[0] || null);
+    }).catch(function (err) {
+      setStatus(err.message, true);
+    });
+  }
+
+  function find(id) {
+    for (var i = 0; i < rewrites.length; i++) {
+      if (rewrites[i].id === id) return rewrites[i];
+    }
+    return null;
+  }
+
+  function renderList() {
+    els.count.textContent = "(" + rewrites.length + ")";
+    els.list.replaceChildren();
+    rewrites.forEach(function (r) {
+      var li = document.createElement("li");
+      li.dataset.id = r.id;
+      var title = document.createElement("div");
+      title.textContent = "#" + r.id + " · " + r.confidence_score.toFixed(2) + " · " + (r.pattern.type || "query");
+      var sql = document.createElement("div");
+      sql.className = "sql";
+      sql.textContent = r.original_sql;
+      li.append(title, sql);
+      li.addEventListener("click", function () { select(r); });
+      els.list.append(li);
+    });
+  }
+
+  function select(r) {
+    selected = r;
+    Array.prototype.forEach.call(els.list.children, function (li) {
+      li.classList.toggle("selected", r !== null && Number(li.dataset.id) === r.id);
+    });
+    els.detail.replaceChildren();
+    if (!r) {
+      var empty = document.createElement("p");
+      empty.className = "empty";
+      empty.textContent = "No rewrites are waiting for review.";
+      els.detail.append(empty);
+      return;
+    }
+    els.detail.append(renderDetail(r));
+  }
+
+  function renderDetail(r) {
+    var node = els.template.content.cloneNode(true);
+    var q = function (sel) { return node.querySelector(sel); };
+    var meta = r.metadata || {};
+
+    q(".title").textContent = "Rewrite #" + r.id;
+    q(".confidence").textContent = "confidence " + r.confidence_score.toFixed(2) +
+      (r.confidence_source ? " from " + r.confidence_source : "");
+
+    var facts = q(".facts");
+    [
+      ["Target", els.target.hidden ? "" : r.target],
+      ["Pattern", [r.pattern.type, r.pattern.complexity].filter(Boolean).join(", ")],
+      ["Tables", (r.pattern.tables || []).join(", ")],
+      ["Anti-patterns", (r.pattern.findings || []).map(function (f) { return f.code + " (" + f.severity + ")"; }).join(", ")],
+      ["Indexes", (r.index_recommendations || []).map(function (i) { return "#" + i.id + " " + i.table + " (" + i.columns.join(", ") + ") " + i.status; }).join("; ")],
+      ["Created", new Date(r.created_at).toLocaleString()],
+      ["Prompt", r.prompt_version || (meta.prompt_template ? meta.prompt_template + " (" + String(meta.prompt_hash || "").slice(0, 12) + ")" : "")],
+    ].forEach(function (fact) {
+      if (!fact[1]) return;
+      var dt = document.createElement("dt");
+      dt.textContent = fact[0];
+      var dd = document.createElement("dd");
+      dd.textContent = fact[1];
+      facts.append(dt, dd);
+    });
+
+    var changes = q(".changes");
+    ((r.diff && r.diff.summary) || []).forEach(function (text) {
+      var li = document.createElement("li");
+      li.textContent = text;
+      changes.append(li);
+    });
+    if (!changes.children.length) changes.remove();
+    if (r.diff && r.diff.unified) {
+      renderUnified(q(".diff"), r.diff.unified);
+    } else {
+      renderDiff(q(".diff"), r.original_sql, r.optimized_sql);
+    }
+    q(".rationale").textContent = r.rationale || "—";
+    q(".improvement").textContent = r.expected_improvement || "—";
+    q(".caveats").textContent = r.caveats || "—";
+
+    var citations = q(".citations");
+    (meta.citations || []).forEach(function (c) {
+      var li = document.createElement("li");
+      var label = c.document + (c.category ? " (" + c.category + ")" : "") + " · score " + Number(c.score).toFixed(2);
+      if (/^https?:\/\//.test(c.url || "")) {
+        var a = document.createElement("a");
+        a.href = c.url;
+        a.target = "_blank";
+        a.rel = "noopener noreferrer";
+        a.textContent = label;
+        li.append(a);
+      } else {
+        li.textContent = label;
+      }
+      citations.append(li);
+    });
+    if (!citations.children.length) citations.replaceWith(paragraph("No documentation was retrieved."));
+
+    var evidence = q(".evidence");
+    ["explain", "plan", "plans", "benchmark"].forEach(function (key) {
+      var value = key === "benchmark" && r.benchmark ? r.benchmark : meta[key];
+      if (value === undefined) return;
+      var pre = document.createElement("pre");
+      pre.textContent = key + ": " + JSON.stringify(value, null, 2);
+      evidence.append(pre);
+    });
+    if (!evidence.children.length) evidence.append(paragraph("No plan or benchmark data was recorded."));
+
+    var form = q(".review");
+    var reason = form.querySelector("textarea");
+    var accept = form.querySelector(".accept");
+    var reject = form.querySelector(".reject");
+    accept.addEventListener("click", function () { review(r, "accept", reason.value, [accept, reject]); });
+    reject.addEventListener("click", function () { review(r, "reject", reason.value, [accept, reject]); });
+    if (linked && linked.id === r.id && (linked.action === "accept" || linked.action === "reject")) {
+      form.classList.add("highlight");
+      setTimeout(function () { (linked.action === "accept" ? accept : reject).focus(); }, 0);
+    }
+    return node;
+  }
+
+  function paragraph(text) {
+    var p = document.createElement("p");
+    p.className = "empty";
+    p.textContent = text;
+    return p;
+  }
+
+  function review(r, action, reason, buttons) {
+    buttons.forEach(function (b) { b.disabled = true; });
+    setStatus(action === "accept" ? "Accepting…" : "Rejecting…");
+    request("POST", "/rewrites/" + r.id + "/" + action, { reason: reason.trim() }).then(function () {
+      setStatus("Rewrite #" + r.id + (action === "accept" ? " accepted" : " rejected"));
+      var index = rewrites.indexOf(r);
+      rewrites.splice(index, 1);
+      linked = null;
+      renderList();
+      select(rewrites[Math.min(index, rewrites.length - 1)] || null);
+    }).catch(function (err) {
+      buttons.forEach(function (b) { b.disabled = false; });
+      setStatus(err.message, true);
+    });
+  }
+
+  // Unified diff computed by the agent from the formatted statements
+  function renderUnified(pre, unified) {
+    unified.split("\n").forEach(function (text) {
+      if (!text || text.startsWith("--- ") || text.startsWith("+++ ")) return;
+      var span = document.createElement("span");
+      if (text[0] === "+") span.className = "add";
+      else if (text[0] === "-") span.className = "del";
+      else if (text.startsWith("@@")) span.className = "hunk";
+      span.textContent = text;
+      pre.append(span);
+    });
+  }
+
+  // Fallback line diff of the statements after breaking them before major clauses,
+  // since generated SQL is often on one line
+  function renderDiff(pre, original, optimized) {
+    var a = sqlLines(original);
+    var b = sqlLines(optimized);
+    var n = a.length, m = b.length;
+    var lcs = [];
+    for (var i = 0; i <= n; i++) lcs.push(new Array(m + 1).fill(0));
+    for (i = n - 1; i >= 0; i--) {
+      for (var j = m - 1; j >= 0; j--) {
+        lcs[i][j] = a[i] === b[j] ? lcs[i + 1][j + 1] + 1 : Math.max(lcs[i + 1][j], lcs[i][j + 1]);
+      }
+    }
+
+    var line = function (cls, prefix, text) {
+      var span = document.createElement("span");
+      if (cls) span.className = cls;
+      span.textContent = prefix + text;
+      pre.append(span);
+    };
+    i = 0; j = 0;
+    while (i < n && j < m) {
+      if (a[i] === b[j]) { line("", "  ", a[i]); i++; j++; }
+      else if (lcs[i + 1][j] >= lcs[i][j + 1]) { line("del", "- ", a[i++]); }
+      else { line("add", "+ ", b[j++]); }
+    }
+    while (i < n) line("del", "- ", a[i++]);
+    while (j < m) line("add", "+ ", b[j++]);
+  }
+
+  var clauses = /\s+(?=(?:SELECT|FROM|WHERE|(?:LEFT |RIGHT |INNER |CROSS |STRAIGHT_)?JOIN|ON|AND|OR|GROUP BY|HAVING|ORDER BY|LIMIT|UNION(?: ALL)?|WITH)\b)/gi;
+
+  function sqlLines(sql) {
+    return (sql || "").trim().split("\n").reduce(function (out, line) {
+      return out.concat(line.trim().replace(clauses, "\n").split("\n"));
+    }, []).map(function (l) { return l.trim(); }).filter(Boolean);
+  }
+
+  els.signin.addEventListener("submit", function (e) {
+    e.preventDefault();
+    sessionStorage.setItem(keyName, els.apikey.value.trim());
+    load();
+  });
+  els.signout.addEventListener("click", function () { signOut(""); });
+  els.refresh.addEventListener("click", load);
+  els.target.addEventListener("change", load);
+
+  if (sessionStorage.getItem(keyName)) {
+    load();
+  } else {
+    signOut("");
+  }
+})();
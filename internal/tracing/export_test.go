@@ -0,0 +1,246 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// goldenSpans are two ended spans of one trace with fixed IDs and times
+func goldenSpans() []*Span {
+	start := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	trace := [16]byte{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}
+	root := &Span{
+		sc:    spanContext{traceID: trace, spanID: [8]byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}, sampled: true},
+		name:  "http.request",
+		kind:  KindServer,
+		start: start,
+		end:   start.Add(1500 * time.Millisecond),
+		attrs: []Attr{String("http.method", "POST"), Int("http.status_code", 200)},
+	}
+	child := &Span{
+		sc:       spanContext{traceID: trace, spanID: [8]byte{0x53, 0x99, 0x5c, 0x3f, 0x42, 0xcd, 0x8a, 0xd8}, sampled: true},
+		parentID: root.sc.spanID,
+		name:     "llm.generate",
+		kind:     KindClient,
+		start:    start.Add(100 * time.Millisecond),
+		end:      start.Add(1400 * time.Millisecond),
+		attrs: []Attr{
+			String("llm.provider", "openai"),
+			Int("llm.tokens", 1234),
+			Float("optimization.confidence", 0.85),
+			Bool("cache.hit", false),
+			{Key: "duration", Value: 250 * time.Millisecond},
+		},
+		status:  statusError,
+		message: "context deadline exceeded",
+	}
+	return []*Span{root, child}
+}
+
+func TestPayloadGolden(t *testing.T) {
+	e := &exporter{service: "latentia-test"}
+	got, err := json.MarshalIndent(e.payload(goldenSpans()), "", "  ")
+	if err != nil {
+		t.Fatalf("failed to encode payload: %v", err)
+	}
+	got = append(got, '\n')
+
+	path := filepath.Join("testdata", "otlp_payload.golden.json")
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("payload =\n%s\nwant\n%s", got, want)
+	}
+}
+
+// The OTLP/JSON rules of the collector's protobuf decoding: hex IDs of
+// the right length, 64-bit integers as decimal strings, enums as numbers
+// and exactly one value field per attribute
+func TestPayloadFollowsOTLPJSON(t *testing.T) {
+	e := &exporter{service: "latentia-test"}
+	body, err := json.Marshal(e.payload(goldenSpans()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []map[string]json.RawMessage `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Scope struct {
+					Name string `json:"name"`
+				} `json:"scope"`
+				Spans []map[string]json.RawMessage `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		t.Fatalf("payload does not decode as ExportTraceServiceRequest: %v", err)
+	}
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("want one resource and one scope, got %s", body)
+	}
+
+	hexID := func(digits int) *regexp.Regexp { return regexp.MustCompile(fmt.Sprintf(`^"[0-9a-f]{%d}"$`, digits)) }
+	decimal := regexp.MustCompile(`^"[0-9]+"$`)
+	spanFields := map[string]bool{
+		"traceId": true, "spanId": true, "parentSpanId": true, "name": true, "kind": true,
+		"startTimeUnixNano": true, "endTimeUnixNano": true, "attributes": true, "status": true,
+	}
+	for _, span := range req.ResourceSpans[0].ScopeSpans[0].Spans {
+		for field := range span {
+			if !spanFields[field] {
+				t.Errorf("unknown span field %q", field)
+			}
+		}
+		if !hexID(32).Match(span["traceId"]) {
+			t.Errorf("traceId = %s, want 32 hex digits", span["traceId"])
+		}
+		if !hexID(16).Match(span["spanId"]) {
+			t.Errorf("spanId = %s, want 16 hex digits", span["spanId"])
+		}
+		if parent, ok := span["parentSpanId"]; ok && !hexID(16).Match(parent) {
+			t.Errorf("parentSpanId = %s, want 16 hex digits", parent)
+		}
+		for _, field := range []string{"startTimeUnixNano", "endTimeUnixNano"} {
+			if !decimal.Match(span[field]) {
+				t.Errorf("%s = %s, want a decimal string", field, span[field])
+			}
+		}
+		var kind int
+		if err := json.Unmarshal(span["kind"], &kind); err != nil || kind < 1 || kind > 5 {
+			t.Errorf("kind = %s, want a SpanKind number", span["kind"])
+		}
+
+		var attrs []struct {
+			Key   string                     `json:"key"`
+			Value map[string]json.RawMessage `json:"value"`
+		}
+		if raw, ok := span["attributes"]; ok {
+			if err := json.Unmarshal(raw, &attrs); err != nil {
+				t.Fatalf("attributes: %v", err)
+			}
+		}
+		for _, attr := range attrs {
+			if len(attr.Value) != 1 {
+				t.Errorf("attribute %s has %d values, want 1", attr.Key, len(attr.Value))
+			}
+			for kind, value := range attr.Value {
+				switch kind {
+				case "stringValue", "boolValue", "doubleValue":
+				case "intValue":
+					if !decimal.Match(value) {
+						t.Errorf("intValue of %s = %s, want a decimal string", attr.Key, value)
+					}
+				default:
+					t.Errorf("attribute %s has unknown value %q", attr.Key, kind)
+				}
+			}
+		}
+	}
+}
+
+// collector records the OTLP requests it receives
+type collector struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	c.mu.Lock()
+	c.requests = append(c.requests, r)
+	c.bodies = append(c.bodies, body)
+	c.mu.Unlock()
+}
+
+func TestExportOnShutdown(t *testing.T) {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	shutdown, err := Setup(Options{Endpoint: srv.URL, SampleRate: 1, ServiceName: "latentia-test"})
+	if err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	ctx, root := StartKind(context.Background(), "http.request", KindServer, String("http.route", "/v1/analyze"))
+	_, child := Start(ctx, "parse")
+	child.RecordError(errors.New("syntax error"))
+	child.End()
+	root.End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if Enabled() {
+		t.Error("tracing still enabled after shutdown")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.requests) != 1 {
+		t.Fatalf("collector got %d requests, want 1", len(c.requests))
+	}
+	req := c.requests[0]
+	if req.Method != http.MethodPost || req.URL.Path != "/v1/traces" {
+		t.Errorf("request = %s %s, want POST /v1/traces", req.Method, req.URL.Path)
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	var payload otlpRequest
+	if err := json.Unmarshal(c.bodies[0], &payload); err != nil {
+		t.Fatalf("failed to decode payload: %v", err)
+	}
+	spans := payload.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	// Spans are exported as they end: the child first
+	parse, request := spans[0], spans[1]
+	if parse.Name != "parse" || request.Name != "http.request" {
+		t.Fatalf("span names = %q, %q", parse.Name, request.Name)
+	}
+	if parse.TraceID != request.TraceID || parse.ParentSpanID != request.SpanID {
+		t.Errorf("parse span is not a child of the request span: %+v %+v", parse, request)
+	}
+	if request.ParentSpanID != "" || request.Kind != KindServer {
+		t.Errorf("request span = %+v, want a root server span", request)
+	}
+	if parse.Status == nil || parse.Status.Code != statusError || parse.Status.Message != "syntax error" {
+		t.Errorf("parse status = %+v, want the error", parse.Status)
+	}
+	if got := payload.ResourceSpans[0].Resource.Attributes; len(got) != 1 || got[0].Key != "service.name" || got[0].Value["stringValue"] != "latentia-test" {
+		t.Errorf("resource attributes = %+v", got)
+	}
+}
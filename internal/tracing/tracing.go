@@ -0,0 +1,265 @@
+// Package tracing records spans across the optimization pipeline and exports
+// them to an OpenTelemetry collector over OTLP/HTTP with JSON encoding.
+//
+// Until Setup is called with an endpoint every function here is a no-op:
+// Start returns the context unchanged and a nil *Span, whose methods do
+// nothing, so instrumented code costs one atomic load when tracing is off.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultServiceName is reported as service.name when none is configured
+const DefaultServiceName = "latentia-agent"
+
+// Span kinds, numbered as in OTLP
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+// OTLP status codes
+const (
+	statusError = 2
+)
+
+// Options configures the tracer
+type Options struct {
+	// Endpoint is the OTLP/HTTP collector URL, e.g. http://localhost:4318;
+	// /v1/traces is appended when it has no path. Empty disables tracing.
+	Endpoint    string
+	SampleRate  float64 // fraction of traces started here that are recorded
+	ServiceName string
+}
+
+type tracer struct {
+	sampleRate float64
+	exporter   *exporter
+}
+
+var active atomic.Pointer[tracer]
+
+// Setup starts exporting spans as described by opts and returns a function
+// that flushes pending spans and stops the exporter
+func Setup(opts Options) (func(context.Context) error, error) {
+	if opts.Endpoint == "" {
+		active.Store(nil)
+		return func(context.Context) error { return nil }, nil
+	}
+
+	endpoint, err := TracesURL(opts.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	service := opts.ServiceName
+	if service == "" {
+		service = DefaultServiceName
+	}
+
+	t := &tracer{sampleRate: opts.SampleRate, exporter: newExporter(endpoint, service)}
+	active.Store(t)
+	return func(ctx context.Context) error {
+		active.CompareAndSwap(t, nil)
+		return t.exporter.shutdown(ctx)
+	}, nil
+}
+
+// TracesURL validates an OTLP/HTTP endpoint and returns the URL spans are
+// posted to
+func TracesURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid OTLP endpoint %q: expected http(s)://host[:port][/path]", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	return u.String(), nil
+}
+
+// Enabled reports whether spans are being recorded
+func Enabled() bool {
+	return active.Load() != nil
+}
+
+// Attr is a span attribute; values are strings, integers, floats or bools
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// String returns a string attribute
+func String(key, value string) Attr { return Attr{key, value} }
+
+// Int returns an integer attribute
+func Int(key string, value int64) Attr { return Attr{key, value} }
+
+// Float returns a floating point attribute
+func Float(key string, value float64) Attr { return Attr{key, value} }
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attr { return Attr{key, value} }
+
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+func (sc spanContext) valid() bool {
+	return sc.traceID != [16]byte{}
+}
+
+// Span is one timed operation. A nil *Span is valid and records nothing.
+type Span struct {
+	tracer   *tracer
+	sc       spanContext
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	mu      sync.Mutex
+	end     time.Time
+	attrs   []Attr
+	status  int
+	message string
+	ended   bool
+}
+
+type spanKey struct{}
+
+// Start begins an internal span as a child of the span in ctx, if any
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	return StartKind(ctx, name, KindInternal, attrs...)
+}
+
+// StartKind begins a span of the given kind as a child of the span in ctx
+func StartKind(ctx context.Context, name string, kind int, attrs ...Attr) (context.Context, *Span) {
+	t := active.Load()
+	if t == nil {
+		return ctx, nil
+	}
+
+	parent := fromContext(ctx)
+	sc := spanContext{traceID: parent.traceID, sampled: parent.sampled}
+	if !parent.valid() {
+		rand.Read(sc.traceID[:])
+		sc.sampled = sampled(sc.traceID, t.sampleRate)
+	}
+	rand.Read(sc.spanID[:])
+
+	span := &Span{tracer: t, sc: sc, parentID: parent.spanID, name: name, kind: kind, start: time.Now()}
+	if sc.sampled {
+		span.attrs = append(span.attrs, attrs...)
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// sampled decides from the trace ID so every process sampling the same
+// trace at the same rate agrees
+func sampled(traceID [16]byte, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	return float64(binary.BigEndian.Uint64(traceID[8:])>>11)/(1<<53) < rate
+}
+
+func fromContext(ctx context.Context) spanContext {
+	switch v := ctx.Value(spanKey{}).(type) {
+	case *Span:
+		if v != nil {
+			return v.sc
+		}
+	case spanContext:
+		return v
+	}
+	return spanContext{}
+}
+
+// SetAttributes adds attributes to the span
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil || !s.sc.sampled {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// RecordError marks the span as failed with err; nil errors are ignored
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil || !s.sc.sampled {
+		return
+	}
+	s.mu.Lock()
+	s.status, s.message = statusError, err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export
+func (s *Span) End() {
+	if s == nil || !s.sc.sampled {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.end = true, time.Now()
+	s.mu.Unlock()
+	s.tracer.exporter.enqueue(s)
+}
+
+// IDs returns the hex trace and span IDs of the sampled span in ctx, for
+// correlating log lines with traces
+func IDs(ctx context.Context) (traceID, spanID string, ok bool) {
+	sc := fromContext(ctx)
+	if !sc.valid() || !sc.sampled {
+		return "", "", false
+	}
+	return hex.EncodeToString(sc.traceID[:]), hex.EncodeToString(sc.spanID[:]), true
+}
+
+// Extract continues the trace of a W3C traceparent header, so spans started
+// from the returned context join the caller's trace. Invalid headers are
+// ignored.
+func Extract(ctx context.Context, traceparent string) context.Context {
+	if active.Load() == nil || traceparent == "" {
+		return ctx
+	}
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return ctx
+	}
+
+	var sc spanContext
+	trace, errTrace := hex.DecodeString(parts[1])
+	span, errSpan := hex.DecodeString(parts[2])
+	flags, errFlags := hex.DecodeString(parts[3])
+	if errTrace != nil || errSpan != nil || errFlags != nil || len(trace) != 16 || len(span) != 8 || len(flags) != 1 {
+		return ctx
+	}
+	copy(sc.traceID[:], trace)
+	copy(sc.spanID[:], span)
+	sc.sampled = flags[0]&1 == 1
+	if !sc.valid() || sc.spanID == [8]byte{} {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, sc)
+}
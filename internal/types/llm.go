@@ -1,6 +1,9 @@
 package types
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // Embedder generates vector embeddings from text
 type Embedder interface {
@@ -11,10 +14,94 @@ type Embedder interface {
 
 // Generator produces text completions from prompts
 type Generator interface {
-	Complete(ctx context.Context, prompt string, opts map[string]any) (string, error)
+	Complete(ctx context.Context, prompt string, opts GenerationOptions) (string, error)
 	Model() string
 }
 
+// JSONGenerator is implemented by generators whose provider can be made to
+// answer with a single JSON object, requested with GenerationOptions.JSON.
+// The optional JSONSchema describes the object for providers that need one.
+type JSONGenerator interface {
+	Generator
+	SupportsJSON() bool
+}
+
+// SupportsJSON reports whether g honours GenerationOptions.JSON
+func SupportsJSON(g Generator) bool {
+	j, ok := g.(JSONGenerator)
+	return ok && j.SupportsJSON()
+}
+
+// StreamingGenerator is implemented by generators that can deliver a
+// completion while it is generated
+type StreamingGenerator interface {
+	Generator
+	// CompleteStream calls onChunk with each piece of the completion as it
+	// arrives. An error from onChunk stops the stream and is returned.
+	CompleteStream(ctx context.Context, prompt string, opts GenerationOptions, onChunk func(chunk string) error) error
+}
+
+// CompleteStream streams the completion of prompt from g, or, when g cannot
+// stream, passes its buffered completion to onChunk in one piece
+func CompleteStream(ctx context.Context, g Generator, prompt string, opts GenerationOptions, onChunk func(chunk string) error) error {
+	if s, ok := g.(StreamingGenerator); ok {
+		return s.CompleteStream(ctx, prompt, opts, onChunk)
+	}
+	completion, err := g.Complete(ctx, prompt, opts)
+	if err != nil {
+		return err
+	}
+	return onChunk(completion)
+}
+
+// EmbedInput is what embedded texts are used for. Providers such as Cohere
+// and Voyage embed documents and the queries searching them differently.
+type EmbedInput string
+
+const (
+	EmbedDocument EmbedInput = "document"
+	EmbedQuery    EmbedInput = "query"
+)
+
+type embedInputKey struct{}
+
+// WithEmbedInput returns a context whose embedded texts are used as input
+func WithEmbedInput(ctx context.Context, input EmbedInput) context.Context {
+	return context.WithValue(ctx, embedInputKey{}, input)
+}
+
+// EmbedInputOf returns what the texts embedded with ctx are used for,
+// EmbedDocument when WithEmbedInput did not say
+func EmbedInputOf(ctx context.Context) EmbedInput {
+	if input, ok := ctx.Value(embedInputKey{}).(EmbedInput); ok {
+		return input
+	}
+	return EmbedDocument
+}
+
+// Usage counts the tokens of the completions made with a context
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+type usageKey struct{}
+
+// WithUsage returns a context into which generators report token usage,
+// together with the Usage they add to
+func WithUsage(ctx context.Context) (context.Context, *Usage) {
+	usage := &Usage{}
+	return context.WithValue(ctx, usageKey{}, usage), usage
+}
+
+// RecordUsage adds the tokens of one completion to the Usage of ctx, if any
+func RecordUsage(ctx context.Context, promptTokens, completionTokens int) {
+	if usage, ok := ctx.Value(usageKey{}).(*Usage); ok {
+		usage.PromptTokens += promptTokens
+		usage.CompletionTokens += completionTokens
+	}
+}
+
 // EmbeddingResult represents a text embedding with metadata
 type EmbeddingResult struct {
 	Text      string    `json:"text"`
@@ -22,10 +109,83 @@ type EmbeddingResult struct {
 	Index     int       `json:"index"`
 }
 
-// GenerationOptions contains options for text generation
+// GenerationOptions tune one completion. Zero values keep the defaults of
+// the provider, or of its ProviderOptions; providers without a setting,
+// such as Anthropic for Seed, ignore it.
 type GenerationOptions struct {
 	MaxTokens   int      `json:"max_tokens,omitempty"`
-	Temperature float64  `json:"temperature,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
 	TopP        float64  `json:"top_p,omitempty"`
 	Stop        []string `json:"stop,omitempty"`
-}
\ No newline at end of file
+
+	// System replaces the default system prompt
+	System string `json:"system,omitempty"`
+
+	// Seed asks for a reproducible completion from providers that support
+	// one
+	Seed *int `json:"seed,omitempty"`
+
+	// Timeout bounds the completion, retries included, on top of the
+	// caller's deadline
+	Timeout time.Duration `json:"timeout,omitempty"`
+
+	// JSON asks a JSONGenerator for a single JSON object, described by
+	// JSONSchema for providers that need one
+	JSON       bool           `json:"json,omitempty"`
+	JSONSchema map[string]any `json:"json_schema,omitempty"`
+}
+
+// OptionsFromMap converts the map taken by Complete before
+// GenerationOptions, keyed by their JSON names; values of another type are
+// ignored, as they always were.
+//
+// Deprecated: pass GenerationOptions. This adapter will be removed in the
+// next release.
+func OptionsFromMap(opts map[string]any) GenerationOptions {
+	var o GenerationOptions
+	if val, ok := opts["max_tokens"].(int); ok {
+		o.MaxTokens = val
+	}
+	if val, ok := opts["temperature"].(float64); ok {
+		o.Temperature = &val
+	}
+	if val, ok := opts["top_p"].(float64); ok {
+		o.TopP = val
+	}
+	if val, ok := opts["stop"].([]string); ok {
+		o.Stop = val
+	}
+	if val, ok := opts["system"].(string); ok {
+		o.System = val
+	}
+	if val, ok := opts["seed"].(int); ok {
+		o.Seed = &val
+	}
+	if val, ok := opts["timeout"].(time.Duration); ok {
+		o.Timeout = val
+	}
+	if val, ok := opts["json"].(bool); ok {
+		o.JSON = val
+	}
+	if val, ok := opts["json_schema"].(map[string]any); ok {
+		o.JSONSchema = val
+	}
+	return o
+}
+
+// CompleteMap is g.Complete with the options as a map.
+//
+// Deprecated: call Complete with GenerationOptions. This adapter will be
+// removed in the next release.
+func CompleteMap(ctx context.Context, g Generator, prompt string, opts map[string]any) (string, error) {
+	return g.Complete(ctx, prompt, OptionsFromMap(opts))
+}
+
+// ProviderOptions tunes a provider's HTTP client and request defaults
+type ProviderOptions struct {
+	Timeout     time.Duration
+	MaxRetries  int
+	MaxTokens   int      // 0 keeps the provider's built-in default
+	Temperature *float64 // nil keeps the provider's built-in default
+	Dimensions  int      // 0 keeps the embedding model's default size
+}
@@ -0,0 +1,239 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/matthieukhl/latentia/internal/schedule"
+	"github.com/matthieukhl/latentia/internal/tracing"
+)
+
+// Job is a named unit of background work run on a schedule
+type Job struct {
+	Name     string
+	Schedule schedule.Schedule
+	Run      func(ctx context.Context) error
+}
+
+// JobStatus is a point-in-time view of a registered job
+type JobStatus struct {
+	Name       string     `json:"name"`
+	Schedule   string     `json:"schedule"`
+	NextRun    *time.Time `json:"next_run"`
+	LastRun    *time.Time `json:"last_run"`
+	LastError  string     `json:"last_error,omitempty"`
+	Running    bool       `json:"running"`
+	RunCount   int        `json:"run_count"`
+	ErrorCount int        `json:"error_count"`
+}
+
+type jobState struct {
+	job    Job
+	status JobStatus
+	reset  chan struct{}
+}
+
+// Runner executes registered jobs according to their schedules. Each job has
+// its own loop, so it never overlaps with itself: the next activation is
+// computed only once the previous run has returned.
+type Runner struct {
+	mu   sync.Mutex
+	jobs map[string]*jobState
+	now  func() time.Time
+
+	// OnComplete, when set, is called after every run of every job
+	OnComplete func(name string, elapsed time.Duration, err error)
+}
+
+func NewRunner() *Runner {
+	return &Runner{
+		jobs: make(map[string]*jobState),
+		now:  time.Now,
+	}
+}
+
+// Register adds a job to the runner. It must be called before Run.
+func (r *Runner) Register(job Job) error {
+	if job.Name == "" || job.Schedule == nil || job.Run == nil {
+		return fmt.Errorf("job requires a name, schedule and run function")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.jobs[job.Name]; exists {
+		return fmt.Errorf("job %q already registered", job.Name)
+	}
+	r.jobs[job.Name] = &jobState{
+		job:    job,
+		status: JobStatus{Name: job.Name, Schedule: job.Schedule.String()},
+		reset:  make(chan struct{}, 1),
+	}
+	return nil
+}
+
+// Reschedule replaces the schedule of a registered job. A job waiting for its
+// next activation recomputes it immediately; a running job keeps running.
+func (r *Runner) Reschedule(name string, sched schedule.Schedule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.jobs[name]
+	if !ok {
+		return fmt.Errorf("job %q not registered", name)
+	}
+	state.job.Schedule = sched
+	state.status.Schedule = sched.String()
+
+	select {
+	case state.reset <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run starts every registered job and blocks until ctx is cancelled and all
+// in-flight runs have returned
+func (r *Runner) Run(ctx context.Context) {
+	r.mu.Lock()
+	states := make([]*jobState, 0, len(r.jobs))
+	for _, state := range r.jobs {
+		states = append(states, state)
+	}
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, state := range states {
+		wg.Add(1)
+		go func(state *jobState) {
+			defer wg.Done()
+			r.loop(ctx, state)
+		}(state)
+	}
+	wg.Wait()
+}
+
+func (r *Runner) loop(ctx context.Context, state *jobState) {
+	for {
+		r.mu.Lock()
+		next := state.job.Schedule.Next(r.now())
+		if next.IsZero() {
+			state.status.NextRun = nil
+		} else {
+			state.status.NextRun = &next
+		}
+		r.mu.Unlock()
+
+		// A schedule that never fires again still waits for a reschedule
+		var fire <-chan time.Time
+		var timer *time.Timer
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			fire = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-state.reset:
+			if timer != nil {
+				timer.Stop()
+			}
+			continue
+		case <-fire:
+		}
+
+		r.execute(ctx, state)
+	}
+}
+
+func (r *Runner) execute(ctx context.Context, state *jobState) {
+	started := r.now()
+
+	r.mu.Lock()
+	state.status.Running = true
+	state.status.NextRun = nil
+	r.mu.Unlock()
+
+	err := runJob(ctx, state.job)
+
+	if r.OnComplete != nil {
+		r.OnComplete(state.job.Name, r.now().Sub(started), err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state.status.Running = false
+	state.status.LastRun = &started
+	state.status.RunCount++
+	if err != nil {
+		state.status.ErrorCount++
+		state.status.LastError = err.Error()
+	} else {
+		state.status.LastError = ""
+	}
+}
+
+// Jobs returns the status of every registered job sorted by name
+func (r *Runner) Jobs() []JobStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	statuses := make([]JobStatus, 0, len(r.jobs))
+	for _, state := range r.jobs {
+		status := state.status
+		if status.NextRun == nil && !status.Running && status.RunCount == 0 {
+			// Not started yet: show when it would first fire
+			if next := state.job.Schedule.Next(r.now()); !next.IsZero() {
+				status.NextRun = &next
+			}
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// runJob runs a job, turning a panic into an error and logging it with its
+// stack trace through slog instead of crashing the runner
+func runJob(ctx context.Context, job Job) (err error) {
+	ctx, span := tracing.Start(ctx, "job "+job.Name, tracing.String("job.name", job.Name))
+	defer func() {
+		if p := recover(); p != nil {
+			slog.ErrorContext(ctx, "job panicked", "job", job.Name, "panic", fmt.Sprint(p), "stack", string(debug.Stack()))
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+		span.RecordError(err)
+		span.End()
+	}()
+	return job.Run(ctx)
+}
+
+// Detach returns a context that outlives the cancellation of ctx by grace,
+// so work started before a shutdown can finish, and is cancelled once grace
+// has elapsed since ctx was
+func Detach(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	detached, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-detached.Done():
+		}
+	})
+	return detached, func() {
+		stop()
+		cancel()
+	}
+}
@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDetachOutlivesCancellationByGrace(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel := Detach(parent, 100*time.Millisecond)
+	defer cancel()
+
+	cancelParent()
+	cancelled := time.Now()
+	select {
+	case <-ctx.Done():
+		t.Fatal("detached context cancelled with its parent")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	select {
+	case <-ctx.Done():
+		if elapsed := time.Since(cancelled); elapsed < 100*time.Millisecond {
+			t.Errorf("detached context cancelled %v after its parent, want the 100ms grace", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("detached context not cancelled after the grace")
+	}
+}
+
+func TestDetachCancel(t *testing.T) {
+	// Cancelling the detached context does not wait for the grace
+	parent, cancelParent := context.WithCancel(context.Background())
+	defer cancelParent()
+	ctx, cancel := Detach(parent, time.Hour)
+	cancelParent()
+	cancel()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("cancel did not stop the detached context")
+	}
+
+	// Nor does it cancel the parent
+	parent, cancelParent = context.WithCancel(context.Background())
+	defer cancelParent()
+	_, cancel = Detach(parent, time.Hour)
+	cancel()
+	if parent.Err() != nil {
+		t.Error("cancel reached the parent context")
+	}
+}